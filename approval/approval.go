@@ -0,0 +1,153 @@
+// Package approval gates clients joining labeled sessions behind an external approval, e.g. a change-management
+// system approving access to production, so that a second person signs off before anyone attaches.
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDenied is returned when an approver denies a client.
+	ErrDenied = errors.New("approval denied")
+)
+
+// Labels describe a session, e.g. env=prod.
+type Labels map[string]string
+
+// ParseLabels parses labels in the form of key=value.
+func ParseLabels(ss []string) (Labels, error) {
+	labels := make(Labels)
+	for _, s := range ss {
+		k, v, ok := strings.Cut(s, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, must be in the form of key=value", s)
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+
+	return labels, nil
+}
+
+// Matches reports whether l has all the labels of selector. An empty selector matches nothing,
+// so that approval isn't required by accident.
+func (l Labels) Matches(selector Labels) bool {
+	if len(selector) == 0 {
+		return false
+	}
+
+	for k, v := range selector {
+		if lv, ok := l[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l Labels) String() string {
+	var ss []string
+	for k, v := range l {
+		ss = append(ss, k+"="+v)
+	}
+	sort.Strings(ss)
+
+	return strings.Join(ss, ",")
+}
+
+// Request asks for the approval of a client joining a session.
+type Request struct {
+	SessionID string `json:"session_id"`
+	Labels    Labels `json:"labels"`
+	Client    Client `json:"client"`
+}
+
+// Client is the client to approve.
+type Client struct {
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+	DisplayName          string `json:"display_name,omitempty"`
+	Addr                 string `json:"addr,omitempty"`
+	Version              string `json:"version,omitempty"`
+}
+
+// Decision is the decision of an approver.
+type Decision struct {
+	Approved bool
+	// Approver identifies who decided, e.g. the username of the second approver.
+	Approver string
+	Reason   string
+}
+
+// Approver decides whether clients may join.
+type Approver interface {
+	// Approve blocks until the request is approved or denied, or ctx is done.
+	Approve(ctx context.Context, req Request) (Decision, error)
+}
+
+// Policy requires clients joining sessions with labels matching Selector to be approved by Approver.
+type Policy struct {
+	Selector Labels
+	Approver Approver
+	// Timeout denies requests that aren't decided in time if it's positive.
+	Timeout time.Duration
+}
+
+// Required reports whether clients joining a session with labels require approval.
+func (p *Policy) Required(labels Labels) bool {
+	return p != nil && p.Approver != nil && labels.Matches(p.Selector)
+}
+
+// Approve asks Approver to approve req within Timeout. It returns an error wrapping ErrDenied if the client is denied.
+func (p *Policy) Approve(ctx context.Context, req Request) (Decision, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	d, err := p.Approver.Approve(ctx, req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return Decision{Reason: "timed out"}, fmt.Errorf("%w: timed out after %s", ErrDenied, p.Timeout)
+		}
+		return d, err
+	}
+	if !d.Approved {
+		if d.Reason != "" {
+			return d, fmt.Errorf("%w: %s", ErrDenied, d.Reason)
+		}
+		return d, ErrDenied
+	}
+
+	return d, nil
+}
+
+// Load returns the approver posting requests to a webhook, running a command, or deciding them on a web page
+// if web is true, or nil if none is specified.
+func Load(webhook, command string, web bool) (Approver, error) {
+	var n int
+	for _, specified := range []bool{webhook != "", command != "", web} {
+		if specified {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("approval webhook, command, and web page can't be used together")
+	}
+
+	switch {
+	case webhook != "":
+		return NewWebhook(webhook)
+	case command != "":
+		return NewCommand(command)
+	case web:
+		return NewWeb()
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,337 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"env=prod", " team = infra ", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Labels{"env": "prod", "team": "infra", "empty": ""}, labels); diff != "" {
+		t.Fatal(diff)
+	}
+	if want, got := "empty=,env=prod,team=infra", labels.String(); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	for _, s := range []string{"env", "=prod"} {
+		if _, err := ParseLabels([]string{s}); err == nil {
+			t.Fatalf("want error parsing %q", s)
+		}
+	}
+}
+
+func Test_Labels_Matches(t *testing.T) {
+	labels := Labels{"env": "prod", "team": "infra"}
+	cases := []struct {
+		selector Labels
+		want     bool
+	}{
+		{selector: Labels{"env": "prod"}, want: true},
+		{selector: Labels{"env": "prod", "team": "infra"}, want: true},
+		{selector: Labels{"env": "staging"}},
+		{selector: Labels{"env": "prod", "region": "eu"}},
+		{selector: Labels{}},
+	}
+
+	for _, c := range cases {
+		if got := labels.Matches(c.selector); c.want != got {
+			t.Fatalf("%s: want=%t got=%t", c.selector, c.want, got)
+		}
+	}
+}
+
+type approverFunc func(ctx context.Context, req Request) (Decision, error)
+
+func (f approverFunc) Approve(ctx context.Context, req Request) (Decision, error) {
+	return f(ctx, req)
+}
+
+func Test_Policy(t *testing.T) {
+	p := &Policy{
+		Selector: Labels{"env": "prod"},
+		Approver: approverFunc(func(ctx context.Context, req Request) (Decision, error) {
+			switch req.Client.DisplayName {
+			case "alice":
+				return Decision{Approved: true, Approver: "bob"}, nil
+			case "mallory":
+				return Decision{Approver: "bob", Reason: "outside the change window"}, nil
+			default:
+				<-ctx.Done()
+				return Decision{}, ctx.Err()
+			}
+		}),
+		Timeout: 10 * time.Millisecond,
+	}
+
+	if !p.Required(Labels{"env": "prod"}) || p.Required(Labels{"env": "dev"}) {
+		t.Fatal("want approval required for prod sessions only")
+	}
+	var nilPolicy *Policy
+	if nilPolicy.Required(Labels{"env": "prod"}) {
+		t.Fatal("want no approval required without a policy")
+	}
+
+	d, err := p.Approve(context.Background(), Request{Client: Client{DisplayName: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Decision{Approved: true, Approver: "bob"}, d); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if _, err := p.Approve(context.Background(), Request{Client: Client{DisplayName: "mallory"}}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("want denied, got %v", err)
+	}
+
+	d, err = p.Approve(context.Background(), Request{Client: Client{DisplayName: "eve"}})
+	if !errors.Is(err, ErrDenied) {
+		t.Fatalf("want denied after timeout, got %v", err)
+	}
+	if want, got := "timed out", d.Reason; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
+
+func Test_Webhook(t *testing.T) {
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/approvals":
+			var req Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Labels["env"] != "prod" {
+				http.Error(w, "missing labels", http.StatusBadRequest)
+				return
+			}
+
+			status := StatusPending
+			if req.Client.DisplayName == "mallory" {
+				status = StatusDenied
+			}
+			_ = json.NewEncoder(w).Encode(WebhookResponse{Status: status, Reason: "no ticket", PollURL: "approvals/" + req.SessionID})
+		case r.Method == http.MethodGet && r.URL.Path == "/approvals/session":
+			resp := WebhookResponse{Status: StatusPending}
+			if polls.Add(1) == 2 {
+				resp = WebhookResponse{Status: StatusApproved, Approver: "bob"}
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(srv.URL + "/approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh.PollInterval = time.Millisecond
+
+	req := Request{SessionID: "session", Labels: Labels{"env": "prod"}, Client: Client{DisplayName: "alice"}}
+	d, err := wh.Approve(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Decision{Approved: true, Approver: "bob"}, d); diff != "" {
+		t.Fatal(diff)
+	}
+	if want, got := int32(2), polls.Load(); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	req.Client.DisplayName = "mallory"
+	d, err = wh.Approve(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Decision{Reason: "no ticket"}, d); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if _, err := NewWebhook("ftp://example.com"); err == nil {
+		t.Fatal("want error for unsupported scheme")
+	}
+}
+
+func Test_Command(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "approve")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+if [ "$UPTERM_CLIENT_NAME" = "alice" ] && [ "$1" = "session" ] && [ "$2" = "env=prod" ]; then
+  echo bob
+  exit 0
+fi
+echo "not in the oncall group" >&2
+exit 1
+`), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := NewCommand(script + " %s %l")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := Request{SessionID: "session", Labels: Labels{"env": "prod"}, Client: Client{DisplayName: "alice"}}
+	d, err := cmd.Approve(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Decision{Approved: true, Approver: "bob"}, d); diff != "" {
+		t.Fatal(diff)
+	}
+
+	req.Client.DisplayName = "mallory"
+	d, err = cmd.Approve(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Decision{Reason: "not in the oncall group"}, d); diff != "" {
+		t.Fatal(diff)
+	}
+
+	missing, err := NewCommand(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := missing.Approve(context.Background(), req); err == nil {
+		t.Fatal("want error running missing command")
+	}
+}
+
+func Test_Web(t *testing.T) {
+	web, err := NewWeb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(web)
+	defer srv.Close()
+
+	// don't follow the redirect after deciding
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	page := func(token string) (int, string) {
+		resp, err := client.Get(srv.URL + "/?" + url.Values{"token": {token}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	decide := func(token, id, decision string) int {
+		resp, err := client.PostForm(srv.URL+"/", url.Values{"token": {token}, "id": {id}, "decision": {decision}, "reason": {"not on call"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code, _ := page("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("want unauthorized with a wrong token, got %d", code)
+	}
+
+	type result struct {
+		d   Decision
+		err error
+	}
+	approve := func(name string) chan result {
+		done := make(chan result, 1)
+		go func() {
+			d, err := web.Approve(context.Background(), Request{SessionID: "session", Client: Client{DisplayName: name}})
+			done <- result{d, err}
+		}()
+		return done
+	}
+
+	for _, tc := range []struct {
+		name     string
+		decision string
+		want     Decision
+	}{
+		{"alice", StatusApproved, Decision{Approved: true, Approver: "web page from 127.0.0.1", Reason: "not on call"}},
+		{"mallory", StatusDenied, Decision{Approver: "web page from 127.0.0.1", Reason: "not on call"}},
+	} {
+		done := approve(tc.name)
+
+		var id string
+		for deadline := time.Now().Add(5 * time.Second); id == "" && time.Now().Before(deadline); {
+			if reqs := web.pendingRequests(); len(reqs) == 1 {
+				id = reqs[0].ID
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, body := page(web.Token); !strings.Contains(body, tc.name) {
+			t.Fatalf("want the page listing %s, got %s", tc.name, body)
+		}
+
+		if code := decide("wrong", id, tc.decision); code != http.StatusUnauthorized {
+			t.Fatalf("want unauthorized with a wrong token, got %d", code)
+		}
+		if code := decide(web.Token, id, tc.decision); code != http.StatusSeeOther {
+			t.Fatalf("want redirect after deciding, got %d", code)
+		}
+		r := <-done
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if diff := cmp.Diff(tc.want, r.d); diff != "" {
+			t.Fatal(diff)
+		}
+
+		// decided requests can't be decided again
+		if code := decide(web.Token, id, tc.decision); code != http.StatusConflict {
+			t.Fatalf("want conflict deciding again, got %d", code)
+		}
+	}
+
+	// requests are withdrawn when the client gives up waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := web.Approve(ctx, Request{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled, got %v", err)
+	}
+	if reqs := web.pendingRequests(); len(reqs) != 0 {
+		t.Fatalf("want no pending requests, got %d", len(reqs))
+	}
+}
+
+func Test_Load(t *testing.T) {
+	if _, err := Load("https://example.com", "", true); err == nil {
+		t.Fatal("want error with more than one approver")
+	}
+
+	approver, err := Load("", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := approver.(*Web); !ok {
+		t.Fatalf("want web approver, got %T", approver)
+	}
+
+	if approver, err := Load("", "", false); err != nil || approver != nil {
+		t.Fatalf("want no approver, got %v %v", approver, err)
+	}
+}
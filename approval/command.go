@@ -0,0 +1,93 @@
+package approval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// NewCommand returns an approver running a command for each request, e.g. a script asking for approval in a
+// ticketing system or checking the OIDC groups of the approver, like AuthorizedKeysCommand of OpenSSH.
+// The command blocks until the request is decided. It approves the client by exiting with zero and denies it
+// otherwise. The first line it prints is the approver if approved, or the reason if denied.
+// The tokens %s, %f, %n, and %l in the command are expanded to the session ID, the fingerprint and the display name
+// of the client, and the labels of the session. They are also set in the environment as UPTERM_SESSION_ID,
+// UPTERM_CLIENT_FINGERPRINT, UPTERM_CLIENT_NAME, UPTERM_CLIENT_ADDR, and UPTERM_SESSION_LABELS.
+func NewCommand(command string) (*Command, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing approval command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty approval command")
+	}
+
+	return &Command{args: args}, nil
+}
+
+// Command approves clients with a command.
+type Command struct {
+	args []string
+}
+
+func (c *Command) Approve(ctx context.Context, req Request) (Decision, error) {
+	tokens := strings.NewReplacer(
+		"%%", "%",
+		"%s", req.SessionID,
+		"%f", req.Client.PublicKeyFingerprint,
+		"%n", req.Client.DisplayName,
+		"%l", req.Labels.String(),
+	)
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = tokens.Replace(arg)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"UPTERM_SESSION_ID="+req.SessionID,
+		"UPTERM_SESSION_LABELS="+req.Labels.String(),
+		"UPTERM_CLIENT_FINGERPRINT="+req.Client.PublicKeyFingerprint,
+		"UPTERM_CLIENT_NAME="+req.Client.DisplayName,
+		"UPTERM_CLIENT_ADDR="+req.Client.Addr,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return Decision{}, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return Decision{}, fmt.Errorf("error running approval command: %w", err)
+	}
+
+	msg := firstLine(stdout.Bytes())
+	if err != nil {
+		if msg == "" {
+			msg = firstLine(stderr.Bytes())
+		}
+		return Decision{Reason: msg}, nil
+	}
+
+	return Decision{Approved: true, Approver: msg}, nil
+}
+
+func firstLine(b []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	if s.Scan() {
+		return strings.TrimSpace(s.Text())
+	}
+
+	return ""
+}
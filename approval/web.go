@@ -0,0 +1,220 @@
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webRefreshInterval is how often the page is reloaded to show new requests while none are pending.
+const webRefreshInterval = 5 * time.Second
+
+// NewWeb returns an approver deciding requests on a web page, authenticated with a random token.
+func NewWeb() (*Web, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &Web{
+		Token:   hex.EncodeToString(b),
+		pending: make(map[string]*webRequest),
+	}, nil
+}
+
+// Web approves clients on a web page, e.g. opened on a phone by the owner of a host running headless.
+// It serves the page as an http.Handler. Requests to it must carry Token, e.g. in the URL of WebURL.
+type Web struct {
+	Token string
+
+	mu      sync.Mutex
+	seq     int
+	pending map[string]*webRequest
+}
+
+type webRequest struct {
+	ID        string
+	Request   Request
+	Requested time.Time
+	decision  chan Decision
+}
+
+func (w *Web) Approve(ctx context.Context, req Request) (Decision, error) {
+	w.mu.Lock()
+	w.seq++
+	wr := &webRequest{
+		ID:        strconv.Itoa(w.seq),
+		Request:   req,
+		Requested: time.Now(),
+		decision:  make(chan Decision, 1),
+	}
+	w.pending[wr.ID] = wr
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		delete(w.pending, wr.ID)
+		w.mu.Unlock()
+	}()
+
+	select {
+	case d := <-wr.decision:
+		return d, nil
+	case <-ctx.Done():
+		return Decision{}, ctx.Err()
+	}
+}
+
+func (w *Web) pendingRequests() []*webRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	reqs := make([]*webRequest, 0, len(w.pending))
+	for _, wr := range w.pending {
+		reqs = append(reqs, wr)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Requested.Before(reqs[j].Requested)
+	})
+
+	return reqs
+}
+
+// decide decides the pending request with id. It reports whether the request is pending.
+func (w *Web) decide(id string, d Decision) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wr, ok := w.pending[id]
+	if !ok {
+		return false
+	}
+	delete(w.pending, id)
+	wr.decision <- d
+
+	return true
+}
+
+// WebURL returns the URL of the page served on addr, carrying the token. Unspecified hosts are replaced with localhost.
+func (w *Web) WebURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host, port = addr.String(), ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	u := url.URL{Scheme: "http", Host: host, Path: "/", RawQuery: url.Values{"token": {w.Token}}.Encode()}
+	return u.String()
+}
+
+func (w *Web) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	// the token is in the URL, so it mustn't leak in referrers or caches
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.Header().Set("X-Frame-Options", "DENY")
+
+	if r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		token = r.PostFormValue("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(w.Token)) != 1 {
+		http.Error(rw, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = webPage.Execute(rw, struct {
+			Token   string
+			Refresh int
+			Pending []*webRequest
+		}{
+			Token:   w.Token,
+			Refresh: int(webRefreshInterval.Seconds()),
+			Pending: w.pendingRequests(),
+		})
+	case http.MethodPost:
+		d := Decision{
+			Approved: r.PostFormValue("decision") == StatusApproved,
+			Approver: "web page from " + remoteHost(r),
+			Reason:   r.PostFormValue("reason"),
+		}
+		if !w.decide(r.PostFormValue("id"), d) {
+			http.Error(rw, "the request isn't pending", http.StatusConflict)
+			return
+		}
+
+		// redirect to the page, so that reloading it doesn't post the decision again
+		http.Redirect(rw, r, "/?"+url.Values{"token": {w.Token}}.Encode(), http.StatusSeeOther)
+	default:
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+var webPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if not .Pending}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>upterm approvals</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.request { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1em; margin-bottom: 1em; }
+dt { font-weight: bold; }
+button { font-size: 1.2em; margin: 0.2em; }
+</style>
+</head>
+<body>
+<h1>Pending clients</h1>
+{{range .Pending}}
+<div class="request">
+<dl>
+<dt>Session</dt><dd>{{.Request.SessionID}} {{.Request.Labels}}</dd>
+<dt>Client</dt><dd>{{with .Request.Client.DisplayName}}{{.}} {{end}}{{.Request.Client.PublicKeyFingerprint}}</dd>
+<dt>Address</dt><dd>{{.Request.Client.Addr}} {{.Request.Client.Version}}</dd>
+<dt>Requested</dt><dd>{{.Requested.Format "15:04:05"}}</dd>
+</dl>
+<form method="post" action="/">
+<input type="hidden" name="token" value="{{$.Token}}">
+<input type="hidden" name="id" value="{{.ID}}">
+<input type="text" name="reason" placeholder="Reason (optional)">
+<button type="submit" name="decision" value="approved">Approve</button>
+<button type="submit" name="decision" value="denied">Deny</button>
+</form>
+</div>
+{{else}}
+<p>No clients are waiting for approval.</p>
+{{end}}
+</body>
+</html>
+`))
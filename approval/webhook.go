@@ -0,0 +1,142 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultPollInterval is how often pending requests are polled unless the webhook responds with Retry-After.
+	defaultPollInterval = 2 * time.Second
+	// maxResponseBytes bounds the responses of webhooks.
+	maxResponseBytes = 1 << 20
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// WebhookResponse is the response of a webhook to a request and to polls of it.
+type WebhookResponse struct {
+	// Status is one of StatusPending, StatusApproved, and StatusDenied.
+	Status   string `json:"status"`
+	Approver string `json:"approver,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// PollURL is where the status of a pending request is polled with GET, relative to the webhook URL.
+	// It defaults to the webhook URL, or the last poll URL responded.
+	PollURL string `json:"poll_url,omitempty"`
+}
+
+// NewWebhook returns an approver posting requests as JSON to u, e.g. of a change-management system.
+// It responds with a WebhookResponse, and pending requests are polled until they are decided.
+func NewWebhook(u string) (*Webhook, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing approval webhook url: %w", err)
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported approval webhook url %q, must be http or https", u)
+	}
+
+	return &Webhook{
+		URL:          pu,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: defaultPollInterval,
+	}, nil
+}
+
+// Webhook approves clients with a webhook.
+type Webhook struct {
+	URL          *url.URL
+	Client       *http.Client
+	PollInterval time.Duration
+}
+
+func (w *Webhook) Approve(ctx context.Context, req Request) (Decision, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL.String(), bytes.NewReader(b))
+	if err != nil {
+		return Decision{}, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	poll := w.URL
+	for {
+		resp, wait, err := w.do(hreq)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		switch resp.Status {
+		case StatusApproved:
+			return Decision{Approved: true, Approver: resp.Approver, Reason: resp.Reason}, nil
+		case StatusDenied:
+			return Decision{Approver: resp.Approver, Reason: resp.Reason}, nil
+		case StatusPending:
+		default:
+			return Decision{}, fmt.Errorf("unknown approval status %q", resp.Status)
+		}
+
+		if resp.PollURL != "" {
+			if poll, err = w.URL.Parse(resp.PollURL); err != nil {
+				return Decision{}, fmt.Errorf("error parsing approval poll url: %w", err)
+			}
+		}
+		if hreq, err = http.NewRequestWithContext(ctx, http.MethodGet, poll.String(), nil); err != nil {
+			return Decision{}, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Decision{}, ctx.Err()
+		}
+	}
+}
+
+// do sends req and returns the response and how long to wait before polling.
+func (w *Webhook) do(req *http.Request) (*WebhookResponse, time.Duration, error) {
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		if err := req.Context().Err(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("error requesting approval: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading approval response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, 0, fmt.Errorf("error requesting approval: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var r WebhookResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, 0, fmt.Errorf("error parsing approval response: %w", err)
+	}
+
+	wait := w.PollInterval
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		wait = time.Duration(s) * time.Second
+	}
+
+	return &r, wait, nil
+}
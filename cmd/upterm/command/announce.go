@@ -0,0 +1,39 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+	"golang.org/x/crypto/ssh"
+)
+
+// displayAnnouncement displays the announcement of the session signed with the signer, for the host to share
+// with invitees over chat. Invitees verify it with the public key of the host, e.g. from GitHub, so that they
+// don't join a session of someone else if the join command is tampered with.
+func displayAnnouncement(w io.Writer, session *api.GetSessionResponse, label string, signer ssh.Signer) error {
+	user, err := api.EncodeIdentifierSession(session)
+	if err != nil {
+		return err
+	}
+
+	joinCmd, err := routing.JoinCommand(session.Host, user)
+	if err != nil {
+		return err
+	}
+
+	text, sig, err := host.SignAnnouncement(label, joinCmd, signer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nShare the signed announcement below. Invitees save it to announcement.txt and the signature to announcement.sig,\n")
+	fmt.Fprintf(w, "list your public key as 'IDENTITY KEY' in an allowed_signers file, and verify it with:\n")
+	fmt.Fprintf(w, "  ssh-keygen -Y verify -f allowed_signers -I IDENTITY -n %s -s announcement.sig < announcement.txt\n\n", host.AnnouncementNamespace)
+	fmt.Fprint(w, text)
+	fmt.Fprintf(w, "\n%s", sig)
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// tmuxResetTerminal leaves the alternate screen, shows the cursor, and turns off mouse reporting and bracketed
+// paste, which tmux clients hung up by the end of the session leave on in the terminal of the host.
+const tmuxResetTerminal = "\x1b[?1049l\x1b[?25h\x1b[?1000l\x1b[?1002l\x1b[?1006l\x1b[?2004l"
+
+var flagTmux string
+
+func attachCmd() *cobra.Command {
+	cmd := hostCmd()
+	cmd.Use = "attach"
+	cmd.Short = "Share an existing tmux session"
+	cmd.Long = `Share an existing tmux session, hosting a terminal session that attaches to it with 'tmux attach'. Clients are
+forced to attach to the tmux session with their own tmux clients, so that tmux sizes the windows for everyone rather
+than clients fighting over the size of a shared terminal. Clients of read-only sessions attach with 'tmux attach -r',
+which neither lets them type nor resize the windows, even after the session is toggled writable.
+
+The tmux session keeps running when the session ends: the tmux clients of the host and clients are detached. Detach
+the tmux client of the host, e.g. with Ctrl-b d, to end the session.
+
+It takes the flags of 'upterm host', except --force-command, --menu, and --sandbox. Flags that can't be used with
+--force-command can't be used with it either.`
+	cmd.Example = `  # Share the tmux session named pair-programming:
+  upterm attach --tmux pair-programming --github-user username
+
+  # Share the tmux session named demo read-only, attaching clients with 'tmux attach -r':
+  upterm attach --tmux demo --read-only`
+	cmd.Args = cobra.NoArgs
+	cmd.PreRunE = validateAttachRequiredFlags
+	cmd.RunE = attachRunE
+
+	cmd.PersistentFlags().StringVar(&flagTmux, "tmux", "", "Share the tmux session with the specified name (required).")
+
+	return cmd
+}
+
+func validateAttachRequiredFlags(c *cobra.Command, args []string) error {
+	var result error
+
+	if flagTmux == "" {
+		result = multierror.Append(result, fmt.Errorf("missing flag --tmux"))
+	}
+	if flagForceCommand != "" || len(flagMenu) > 0 {
+		result = multierror.Append(result, fmt.Errorf("--force-command and --menu can't be used with --tmux, clients attach to the tmux session"))
+	}
+	if flagSandbox != "" || flagSandboxTool != "" {
+		result = multierror.Append(result, fmt.Errorf("--sandbox can't be used with --tmux, the tmux server runs outside of the sandbox"))
+	}
+	if result != nil {
+		return result
+	}
+
+	// the force command is checked against the other flags, and set again once the profile has set --read-only
+	flagForceCommand = tmuxAttachCommand(flagTmux, false)
+	if err := validateShareRequiredFlags(c, args); err != nil {
+		return err
+	}
+
+	return checkTmuxSession(flagTmux, os.Getenv("TMUX") != "")
+}
+
+// checkTmuxSession returns an error if tmux isn't installed, the tmux session doesn't exist, or the host is
+// attached to it already, which would mirror the session into itself.
+func checkTmuxSession(name string, inTmux bool) error {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return fmt.Errorf("tmux isn't installed: %w", err)
+	}
+
+	if err := exec.Command("tmux", "has-session", "-t", tmuxTarget(name)).Run(); err != nil {
+		return fmt.Errorf("tmux session %s doesn't exist, start it with 'tmux new -s %s'", name, name)
+	}
+
+	if inTmux {
+		out, err := exec.Command("tmux", "display-message", "-p", "#{session_name}").Output()
+		if err == nil && strings.TrimSpace(string(out)) == name {
+			return fmt.Errorf("upterm attach is running inside tmux session %s, run it from another terminal", name)
+		}
+	}
+
+	return nil
+}
+
+func attachRunE(c *cobra.Command, args []string) error {
+	flagForceCommand = tmuxAttachCommand(flagTmux, flagReadOnly)
+	// the host may share a tmux session from another one, which tmux refuses to attach to unless TMUX is unset
+	if err := os.Unsetenv("TMUX"); err != nil {
+		return err
+	}
+
+	err := shareRunE(c, []string{"tmux", "attach-session", "-t", tmuxTarget(flagTmux)})
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		_, _ = io.WriteString(os.Stdout, tmuxResetTerminal)
+	}
+
+	return err
+}
+
+// tmuxAttachCommand returns the force command attaching clients to the tmux session of name, quoted for
+// --force-command. Clients of read-only sessions attach read-only, which also keeps them from resizing the windows.
+func tmuxAttachCommand(name string, readOnly bool) string {
+	cmd := []string{"tmux", "attach-session"}
+	if readOnly {
+		cmd = append(cmd, "-r")
+	}
+	cmd = append(cmd, "-t", shellQuote(tmuxTarget(name)))
+
+	return strings.Join(cmd, " ")
+}
+
+// tmuxTarget returns the target of the tmux session of name, matching the name exactly rather than as a prefix.
+func tmuxTarget(name string) string {
+	return "=" + name
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/shlex"
+)
+
+func Test_tmuxAttachCommand(t *testing.T) {
+	cases := []struct {
+		name     string
+		session  string
+		readOnly bool
+		want     []string
+	}{
+		{
+			name:    "writable",
+			session: "pair",
+			want:    []string{"tmux", "attach-session", "-t", "=pair"},
+		},
+		{
+			name:     "read-only",
+			session:  "pair",
+			readOnly: true,
+			want:     []string{"tmux", "attach-session", "-r", "-t", "=pair"},
+		},
+		{
+			name:    "quoted",
+			session: "bob's demo",
+			want:    []string{"tmux", "attach-session", "-t", "=bob's demo"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := shlex.Split(tmuxAttachCommand(c.session, c.readOnly))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func Test_checkTmuxSession(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux isn't installed")
+	}
+
+	// a tmux server of its own keeps the test off the sessions of the user
+	t.Setenv("TMUX_TMPDIR", t.TempDir())
+	if err := exec.Command("tmux", "new-session", "-d", "-s", "pair").Run(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = exec.Command("tmux", "kill-server").Run() })
+
+	if err := checkTmuxSession("pair", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTmuxSession("pai", false); err == nil {
+		t.Fatal("want an error for a prefix of the session name")
+	}
+	if err := checkTmuxSession("missing", false); err == nil {
+		t.Fatal("want an error for a missing session")
+	}
+}
@@ -0,0 +1,258 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/ftests/scenarios"
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/server"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagBenchServers     []string
+	flagBenchHostServer  string
+	flagBenchBenchmarks  []string
+	flagBenchKeystrokes  int
+	flagBenchBulkSize    string
+	flagBenchTimeout     time.Duration
+	flagBenchKnownHosts  string
+	flagBenchAgreePolicy string
+	flagBenchMetricsFile string
+)
+
+func benchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark the transports of an upterm deployment",
+		Long: fmt.Sprintf(`Benchmark the transports of an upterm deployment by measuring the latency of interactive traffic and the
+throughput of bulk traffic between a host and a client, e.g. to compare the ssh and WebSocket transports or to
+validate optimizations of them. Each benchmark hosts a session with throwaway keys and joins it as a client.
+Bytes are counted exactly and bulk transfers are verified. Benchmarks: %s.
+
+Specify --server multiple times to compare transports or nodes. Clients join sessions on each server, hosted on
+--host-server if it's set, e.g. to benchmark routing across the nodes of a cluster.`, strings.Join(scenarios.BenchmarkNames(), ", ")),
+		Example: `  # Compare the ssh and WebSocket transports of a server:
+  upterm bench --server ssh://uptermd.example.com:22 --server wss://uptermd.example.com
+
+  # Benchmark clients joining through another node than the host's:
+  upterm bench --host-server ssh://node1.example.com:22 --server ssh://node2.example.com:22 --server wss://node2.example.com
+
+  # Publish the results to the textfile collector of the Prometheus node exporter:
+  upterm bench --server wss://uptermd.example.com --metrics-file /var/lib/node_exporter/upterm_bench.prom`,
+		RunE:         benchRunE,
+		SilenceUsage: true,
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd.PersistentFlags().StringArrayVar(&flagBenchServers, "server", []string{defaultServer}, "Specify an upterm server address clients join on. Supported protocols: ssh, ws, wss.")
+	cmd.PersistentFlags().StringVar(&flagBenchHostServer, "host-server", "", "Specify an upterm server address sessions are hosted on. Defaults to the --server clients join on.")
+	cmd.PersistentFlags().StringSliceVar(&flagBenchBenchmarks, "benchmark", nil, fmt.Sprintf("Run only the specified benchmarks (%s).", strings.Join(scenarios.BenchmarkNames(), ", ")))
+	cmd.PersistentFlags().IntVar(&flagBenchKeystrokes, "keystrokes", scenarios.DefaultBenchOptions.Keystrokes, "Specify how many keys the interactive benchmark types.")
+	cmd.PersistentFlags().StringVar(&flagBenchBulkSize, "bulk-size", "16MiB", "Specify the size of the files the bulk benchmarks transfer, e.g. 64MiB.")
+	cmd.PersistentFlags().DurationVar(&flagBenchTimeout, "timeout", time.Minute, "Specify the timeout of each benchmark.")
+	cmd.PersistentFlags().StringVarP(&flagBenchKnownHosts, "known-hosts", "", defaultKnownHost(homeDir), "Specify a file containing known keys for remote hosts (required).")
+	cmd.PersistentFlags().StringVar(&flagBenchAgreePolicy, "agree-policy", "", "Agree to the server policy with the specified hash without prompting.")
+	cmd.PersistentFlags().StringVar(&flagBenchMetricsFile, "metrics-file", "", "Write the results as Prometheus metrics to the file, e.g. for the textfile collector of the node exporter.")
+
+	return cmd
+}
+
+func benchRunE(c *cobra.Command, args []string) error {
+	bs, err := scenarios.FindBenchmarks(flagBenchBenchmarks)
+	if err != nil {
+		return err
+	}
+
+	bulkBytes, err := server.ParseByteSize(flagBenchBulkSize)
+	if err != nil {
+		return fmt.Errorf("invalid bulk size: %w", err)
+	}
+	if flagBenchKeystrokes <= 0 {
+		return fmt.Errorf("keystrokes must be positive")
+	}
+	opts := scenarios.BenchOptions{Keystrokes: flagBenchKeystrokes, BulkBytes: int64(bulkBytes)}
+
+	hkcb, err := host.NewPromptingHostKeyCallback(os.Stdin, os.Stdout, flagBenchKnownHosts)
+	if err != nil {
+		return err
+	}
+	hkcb = rememberHostKeys(hkcb)
+
+	var (
+		mu     sync.Mutex
+		agreed = flagBenchAgreePolicy
+	)
+	agree := func(policy, hash string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := agreePolicy(bufio.NewReader(os.Stdin), os.Stdout, agreed, policy, hash); err != nil {
+			return err
+		}
+		agreed = hash
+
+		return nil
+	}
+
+	envs := benchEnvs(flagBenchHostServer, flagBenchServers)
+	var (
+		results []benchResult
+		failed  int
+	)
+	for _, b := range bs {
+		for _, env := range envs {
+			env.HostKeyCallback = hkcb
+			env.AgreePolicyCallback = agree
+
+			m, err := scenarios.RunBenchmark(context.Background(), env, b, opts, flagBenchTimeout)
+			if err != nil {
+				failed++
+			}
+			results = append(results, benchResult{Env: env, Measurement: m, Err: err})
+		}
+	}
+
+	displayBenchResults(os.Stdout, results)
+
+	if flagBenchMetricsFile != "" {
+		if err := writeBenchMetrics(flagBenchMetricsFile, results); err != nil {
+			return fmt.Errorf("error writing metrics: %w", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d benchmark(s) failed", failed)
+	}
+
+	return nil
+}
+
+type benchResult struct {
+	Env         scenarios.Env
+	Measurement scenarios.Measurement
+	Err         error
+}
+
+// benchEnvs returns an environment per server clients join on, hosting sessions on hostServer if it's set.
+func benchEnvs(hostServer string, servers []string) []scenarios.Env {
+	var envs []scenarios.Env
+	for _, s := range servers {
+		env := scenarios.Env{HostURL: s, ClientURL: s}
+		if hostServer != "" {
+			env.HostURL = hostServer
+		}
+		envs = append(envs, env)
+	}
+
+	return envs
+}
+
+func benchPath(env scenarios.Env) string {
+	if env.HostURL == env.ClientURL {
+		return env.HostURL
+	}
+
+	return fmt.Sprintf("%s -> %s", env.HostURL, env.ClientURL)
+}
+
+func displayBenchResults(w io.Writer, results []benchResult) {
+	var (
+		rows    [][]string
+		details []string
+	)
+	for _, r := range results {
+		name, path := r.Measurement.Benchmark, benchPath(r.Env)
+		if r.Err != nil {
+			rows = append(rows, []string{name, path, "fail", "", "", "", ""})
+			details = append(details, fmt.Sprintf("%s on %s: %s", name, path, r.Err))
+			continue
+		}
+
+		m := r.Measurement
+		p50, p99 := "n/a", "n/a"
+		if len(m.Latencies) > 0 {
+			p50, p99 = m.Latency(0.5).Round(time.Microsecond).String(), m.Latency(0.99).Round(time.Microsecond).String()
+		}
+		rows = append(rows, []string{
+			name,
+			path,
+			formatBytes(m.Bytes),
+			m.Duration.Round(time.Millisecond).String(),
+			formatBytes(int64(m.Throughput())) + "/s",
+			p50,
+			p99,
+		})
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Benchmark", "Server", "Bytes", "Duration", "Throughput", "p50", "p99"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, d := range details {
+		fmt.Fprintln(w, d)
+	}
+}
+
+// writeBenchMetrics writes the results to file in the Prometheus text format, labeled by the benchmark,
+// the servers, and the transport clients join with.
+func writeBenchMetrics(file string, results []benchResult) error {
+	labels := []string{"benchmark", "host_server", "client_server", "transport"}
+	var (
+		reg        = prometheus.NewRegistry()
+		bytes      = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "bytes", Help: "Bytes received end to end by the benchmark."}, labels)
+		duration   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "duration_seconds", Help: "Duration of the traffic of the benchmark."}, labels)
+		throughput = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "throughput_bytes_per_second", Help: "Bytes received per second by the benchmark."}, labels)
+		latency    = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "latency_seconds", Help: "Round trips of interactive traffic by quantile."}, append(labels, "quantile"))
+		success    = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "success", Help: "Whether the benchmark succeeded."}, labels)
+		timestamp  = prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "upterm", Subsystem: "bench", Name: "timestamp_seconds", Help: "Unix time the benchmarks finished."})
+	)
+	reg.MustRegister(bytes, duration, throughput, latency, success, timestamp)
+
+	for _, r := range results {
+		var transport string
+		if u, err := url.Parse(r.Env.ClientURL); err == nil {
+			transport = u.Scheme
+		}
+		lv := []string{r.Measurement.Benchmark, r.Env.HostURL, r.Env.ClientURL, transport}
+
+		if r.Err != nil {
+			success.WithLabelValues(lv...).Set(0)
+			continue
+		}
+		success.WithLabelValues(lv...).Set(1)
+
+		m := r.Measurement
+		bytes.WithLabelValues(lv...).Set(float64(m.Bytes))
+		duration.WithLabelValues(lv...).Set(m.Duration.Seconds())
+		throughput.WithLabelValues(lv...).Set(m.Throughput())
+		if len(m.Latencies) > 0 {
+			for _, q := range []float64{0.5, 0.9, 0.99} {
+				latency.WithLabelValues(append(lv, fmt.Sprint(q))...).Set(m.Latency(q).Seconds())
+			}
+		}
+	}
+	timestamp.SetToCurrentTime()
+
+	return prometheus.WriteToTextfile(file, reg)
+}
@@ -0,0 +1,88 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/owenthereal/upterm/ftests/scenarios"
+)
+
+func Test_benchEnvs(t *testing.T) {
+	cases := []struct {
+		name       string
+		hostServer string
+		servers    []string
+		want       []scenarios.Env
+	}{
+		{
+			name:    "clients join on the host's server",
+			servers: []string{"ssh://a:22", "wss://a"},
+			want: []scenarios.Env{
+				{HostURL: "ssh://a:22", ClientURL: "ssh://a:22"},
+				{HostURL: "wss://a", ClientURL: "wss://a"},
+			},
+		},
+		{
+			name:       "sessions are hosted on the host server",
+			hostServer: "ssh://a:22",
+			servers:    []string{"ssh://b:22", "wss://b"},
+			want: []scenarios.Env{
+				{HostURL: "ssh://a:22", ClientURL: "ssh://b:22"},
+				{HostURL: "ssh://a:22", ClientURL: "wss://b"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := benchEnvs(c.hostServer, c.servers)
+			if diff := cmp.Diff(c.want, got, cmpopts.IgnoreFields(scenarios.Env{}, "HostKeyCallback", "AgreePolicyCallback", "Logger")); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func Test_writeBenchMetrics(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bench.prom")
+	results := []benchResult{
+		{
+			Env:         scenarios.Env{HostURL: "ssh://a:22", ClientURL: "wss://b"},
+			Measurement: scenarios.Measurement{Benchmark: "interactive", Bytes: 10, Duration: time.Second, Latencies: []time.Duration{time.Millisecond}},
+		},
+		{
+			Env:         scenarios.Env{HostURL: "ssh://a:22", ClientURL: "ssh://a:22"},
+			Measurement: scenarios.Measurement{Benchmark: "bulk-download"},
+			Err:         fmt.Errorf("boom"),
+		},
+	}
+	if err := writeBenchMetrics(file, results); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`upterm_bench_bytes{benchmark="interactive",client_server="wss://b",host_server="ssh://a:22",transport="wss"} 10`,
+		`upterm_bench_throughput_bytes_per_second{benchmark="interactive",client_server="wss://b",host_server="ssh://a:22",transport="wss"} 10`,
+		`upterm_bench_latency_seconds{benchmark="interactive",client_server="wss://b",host_server="ssh://a:22",quantile="0.99",transport="wss"} 0.001`,
+		`upterm_bench_success{benchmark="interactive",client_server="wss://b",host_server="ssh://a:22",transport="wss"} 1`,
+		`upterm_bench_success{benchmark="bulk-download",client_server="ssh://a:22",host_server="ssh://a:22",transport="ssh"} 0`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("want %q in metrics:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), `upterm_bench_bytes{benchmark="bulk-download"`) {
+		t.Fatalf("want no measurement of failed benchmark in metrics:\n%s", b)
+	}
+}
@@ -0,0 +1,165 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/owenthereal/upterm/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileName = "config.yaml"
+)
+
+// config is the upterm config file, e.g. written by 'upterm init'.
+// Its values are the defaults of the corresponding flags of 'upterm host'. See configFlags.
+type config struct {
+	Server             string   `yaml:"server,omitempty"`
+	PrivateKeys        []string `yaml:"private-keys,omitempty"`
+	KnownHosts         string   `yaml:"known-hosts,omitempty"`
+	AuthorizedKeys     string   `yaml:"authorized-keys,omitempty"`
+	AuthorizedKeysURLs []string `yaml:"authorized-keys-urls,omitempty"`
+	CertAuthorities    []string `yaml:"cert-authorities,omitempty"`
+	GitHubUsers        []string `yaml:"github-users,omitempty"`
+	GitLabUsers        []string `yaml:"gitlab-users,omitempty"`
+	CodebergUsers      []string `yaml:"codeberg-users,omitempty"`
+	SourceHutUsers     []string `yaml:"srht-users,omitempty"`
+	KeepAlive          string   `yaml:"keep-alive,omitempty"`
+	ReadOnly           bool     `yaml:"read-only,omitempty"`
+}
+
+// configFlags maps the keys of the config file to the flags of 'upterm host' they set the defaults of.
+var configFlags = map[string]string{
+	"server":               "server",
+	"private-keys":         "private-key",
+	"known-hosts":          "known-hosts",
+	"authorized-keys":      "authorized-keys",
+	"authorized-keys-urls": "authorized-keys-url",
+	"cert-authorities":     "cert-authority",
+	"github-users":         "github-user",
+	"gitlab-users":         "gitlab-user",
+	"codeberg-users":       "codeberg-user",
+	"srht-users":           "srht-user",
+	"keep-alive":           "keep-alive",
+	"read-only":            "read-only",
+}
+
+// configFile returns the config file in $XDG_CONFIG_HOME/upterm, which defaults to ~/.config/upterm.
+// The config file in ~/.upterm written by earlier versions is used if only it exists.
+func configFile() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	legacyDir, err := utils.UptermDir()
+	if err != nil {
+		return "", err
+	}
+
+	return findConfigFile(homeDir, os.Getenv("XDG_CONFIG_HOME"), legacyDir), nil
+}
+
+func findConfigFile(homeDir, xdgConfigHome, legacyDir string) string {
+	dir := xdgConfigHome
+	// relative paths are invalid per the XDG base directory spec
+	if dir == "" || !filepath.IsAbs(dir) {
+		dir = filepath.Join(homeDir, ".config")
+	}
+
+	file := filepath.Join(dir, "upterm", configFileName)
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+
+	legacy := filepath.Join(legacyDir, configFileName)
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+
+	return file
+}
+
+// readConfig reads the config file. It returns an empty config if the file doesn't exist.
+func readConfig(file string) (*config, error) {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func writeConfig(file string, cfg *config) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, b, 0600)
+}
+
+// applyConfig sets the flags that are not set on the command line to the values in the config file of --config,
+// or of configFile if it's not set. A missing default config file is ignored.
+func applyConfig(c *cobra.Command) error {
+	file := flagConfig
+	if file == "" {
+		f, err := configFile()
+		if err != nil {
+			return err
+		}
+		file = f
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) && flagConfig == "" {
+			return nil
+		}
+		return err
+	}
+
+	return applyConfigFlags(v, c.Flags())
+}
+
+// applyConfigFlags sets the flags of configFlags that are not changed to the values set in v.
+// Unlike setting them on the command line, the flags stay unchanged, so that profiles still apply to them.
+func applyConfigFlags(v *viper.Viper, flags *pflag.FlagSet) error {
+	for key, name := range configFlags {
+		f := flags.Lookup(name)
+		if f == nil || f.Changed || !v.IsSet(key) {
+			continue
+		}
+
+		var err error
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			err = sv.Replace(v.GetStringSlice(key))
+		} else {
+			err = f.Value.Set(v.GetString(key))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", key, v.ConfigFileUsed(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func Test_findConfigFile(t *testing.T) {
+	homeDir := t.TempDir()
+	legacyDir := filepath.Join(homeDir, ".upterm")
+	xdgConfigHome := filepath.Join(homeDir, "xdg")
+
+	write := func(file string) {
+		t.Helper()
+		if err := writeConfig(file, &config{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defaultFile := filepath.Join(homeDir, ".config", "upterm", configFileName)
+	if want, got := defaultFile, findConfigFile(homeDir, "", legacyDir); want != got {
+		t.Fatalf("want %s without config files, got %s", want, got)
+	}
+	if want, got := defaultFile, findConfigFile(homeDir, "relative", legacyDir); want != got {
+		t.Fatalf("want %s for a relative XDG_CONFIG_HOME, got %s", want, got)
+	}
+
+	legacyFile := filepath.Join(legacyDir, configFileName)
+	write(legacyFile)
+	if want, got := legacyFile, findConfigFile(homeDir, xdgConfigHome, legacyDir); want != got {
+		t.Fatalf("want the legacy config file %s, got %s", want, got)
+	}
+
+	xdgFile := filepath.Join(xdgConfigHome, "upterm", configFileName)
+	write(xdgFile)
+	if want, got := xdgFile, findConfigFile(homeDir, xdgConfigHome, legacyDir); want != got {
+		t.Fatalf("want the XDG config file %s over the legacy one, got %s", want, got)
+	}
+}
+
+func Test_applyConfigFlags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), configFileName)
+	cfg := `server: ssh://config:22
+private-keys: [/config/id_ed25519]
+github-users:
+  - alice
+  - bob
+keep-alive: 30s
+read-only: true
+`
+	if err := os.WriteFile(file, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		server      string
+		privateKeys []string
+		githubUsers []string
+		gitlabUsers []string
+		keepAlive   time.Duration
+		readOnly    bool
+	)
+	flags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+	flags.StringVar(&server, "server", defaultServer, "")
+	flags.StringSliceVar(&privateKeys, "private-key", []string{"/default/id_rsa"}, "")
+	flags.StringSliceVar(&githubUsers, "github-user", nil, "")
+	flags.StringSliceVar(&gitlabUsers, "gitlab-user", []string{"carol"}, "")
+	flags.DurationVar(&keepAlive, "keep-alive", defaultKeepAlive, "")
+	flags.BoolVar(&readOnly, "read-only", false, "")
+	if err := flags.Parse([]string{"--server", "ssh://flag:22"}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFlags(v, flags); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "ssh://flag:22", server; want != got {
+		t.Fatalf("want the flag on the command line to override the config file, got %s", got)
+	}
+	if diff := cmp.Diff([]string{"/config/id_ed25519"}, privateKeys); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"alice", "bob"}, githubUsers); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"carol"}, gitlabUsers); diff != "" {
+		t.Fatalf("want flags missing in the config file to keep their defaults: %s", diff)
+	}
+	if want, got := 30*time.Second, keepAlive; want != got {
+		t.Fatalf("want keep-alive %s, got %s", want, got)
+	}
+	if !readOnly {
+		t.Fatal("want read-only from the config file")
+	}
+	if flags.Changed("read-only") {
+		t.Fatal("want flags set by the config file to stay unchanged, so that profiles apply to them")
+	}
+
+	v.Set("keep-alive", "soon")
+	err := applyConfigFlags(v, pflag.NewFlagSet("host", pflag.ContinueOnError))
+	if err != nil {
+		t.Fatalf("want flags missing in the flag set to be skipped, got %s", err)
+	}
+	keepAliveFlags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+	keepAliveFlags.DurationVar(&keepAlive, "keep-alive", defaultKeepAlive, "")
+	if err := applyConfigFlags(v, keepAliveFlags); err == nil || !strings.Contains(err.Error(), "invalid keep-alive") {
+		t.Fatalf("want an invalid keep-alive error, got %v", err)
+	}
+}
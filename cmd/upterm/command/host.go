@@ -1,37 +1,124 @@
 package command
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eiannone/keyboard"
 	"github.com/gen2brain/beeep"
 	"github.com/google/shlex"
 	"github.com/hashicorp/go-multierror"
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/approval"
 	"github.com/owenthereal/upterm/host"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultKeepAlive is below the idle timeouts of common load balancers: nlb is 350 sec & heroku router is 55 sec.
+const defaultKeepAlive = 50 * time.Second
+
+const (
+	// webhookSecretEnvVar is the environment variable --webhook-secret defaults to.
+	webhookSecretEnvVar = "UPTERM_WEBHOOK_SECRET"
+	// webhookCloseTimeout bounds posting the last events of the session to --webhook-url after it ends.
+	webhookCloseTimeout = 15 * time.Second
 )
 
 var (
 	flagServer             string
+	flagConfig             string
+	flagKeepAlive          time.Duration
 	flagForceCommand       string
 	flagPrivateKeys        []string
 	flagKnownHostsFilename string
 	flagAuthorizedKeys     string
+	flagAuthorizedKeysURLs []string
+	flagCertAuthorities    []string
 	flagCodebergUsers      []string
 	flagGitHubUsers        []string
 	flagGitLabUsers        []string
 	flagSourceHutUsers     []string
+	flagKeysRefresh        time.Duration
 	flagReadOnly           bool
 	flagAccept             bool
+	flagStartAt            string
+	flagMaxDuration        time.Duration
+	flagIdleTimeout        time.Duration
+	flagDirectListen       string
+	flagSandbox            string
+	flagSandboxTool        string
+	flagAgreePolicy        string
+	flagMenu               []string
+	flagLimitRate          string
+	flagTCPOpts            []string
+	flagPtyBackend         string
+	flagQR                 bool
+	flagQRTTL              time.Duration
+	flagShareLink          bool
+	flagShareLinkTTL       time.Duration
+	flagSFTP               bool
+	flagAllowExec          bool
+	flagPortForwarding     bool
+	flagForwardPorts       []uint
+	flagVSCode             bool
+	flagVSCodePort         uint
+	flagVSCodeBin          string
+	flagCompression        string
+	flagAnnounce           bool
+	flagAnnounceLabel      string
+	flagProfile            string
+	flagStrictCrypto       bool
+	flagRecordingOptOut    bool
+	flagMaxClients         int
+	flagPasteThreshold     int
+	flagName               string
+	flagListenOnly         bool
+	flagSizePolicy         string
+	flagQueue              bool
+	flagWindowSize         string
+	flagAllowTelnet        bool
+	flagClientIdleTimeout  time.Duration
+	flagEvictGhostsAfter   time.Duration
+	flagCoalesceOutput     time.Duration
+	flagIdentityFile       string
+	flagIdentityCommand    string
+	flagJump               string
+	flagWSFallback         bool
+	flagReconnectTimeout   time.Duration
+	flagShowTimer          bool
+	flagShowActivity       bool
+	flagStatusLine         bool
+	flagShowTyping         bool
+	flagRestartCommand     bool
+	flagKeepSessionOnExit  bool
+	flagLabels             []string
+	flagApprovalWebhook    string
+	flagApprovalCommand    string
+	flagApprovalWeb        string
+	flagApprovalSelector   []string
+	flagApprovalTimeout    time.Duration
+	flagWebhookURL         string
+	flagWebhookSecret      string
 )
 
 func hostCmd() *cobra.Command {
@@ -42,7 +129,12 @@ func hostCmd() *cobra.Command {
 and client to a command's IO. Authentication against the Upterm server defaults to using private key files located
 at ~/.ssh/id_dsa, ~/.ssh/id_ecdsa, ~/.ssh/id_ed25519, and ~/.ssh/id_rsa. If no private key file is found, it resorts
 to reading private keys from the SSH Agent. Absence of private keys in files or SSH Agent generates an on-the-fly
-private key. To authorize client connections, specify a authorized_key file with public keys using --authorized-keys.`,
+private key. Keys on security keys, e.g. ~/.ssh/id_ed25519_sk on a YubiKey, authenticate through the SSH Agent
+after adding them with ssh-add, and wait for a touch. To authorize client connections, specify a authorized_key file
+with public keys using --authorized-keys.
+
+While hosting, type Ctrl-] followed by p before typing a password or other secret to hide the output from clients.
+The output is shared again after typing Ctrl-] p again, or after 30 seconds. Type Ctrl-] twice to send Ctrl-].`,
 		Example: `  # Host a terminal session running $SHELL, attaching client's IO to the host's:
   upterm host
 
@@ -52,14 +144,83 @@ private key. To authorize client connections, specify a authorized_key file with
   # Host a terminal session allowing only specified public key(s) to connect:
   upterm host --authorized-keys PATH_TO_AUTHORIZED_KEY_FILE
 
+  # Combine authorized keys from a file, GitHub users, and a team URL. Duplicated keys are listed once:
+  upterm host --authorized-keys PATH_TO_AUTHORIZED_KEY_FILE --github-user username --authorized-keys-url https://example.com/team.keys
+
+  # Re-fetch the keys of GitHub users every 15 minutes, so that revoked keys can't join a long-running session:
+  upterm host --github-user username --authorized-keys-refresh 15m
+
+  # Name the session, which clients see when they join:
+  upterm host --name "debugging prod incident"
+
   # Host a session executing a custom command:
   upterm host -- docker run --rm -ti ubuntu bash
 
   # Host a 'tmux new -t pair-programming' session, forcing clients to join with 'tmux attach -t pair-programming':
   upterm host --force-command 'tmux attach -t pair-programming' -- tmux new -t pair-programming
 
+  # Schedule a session at 15:00 for one hour, sharing the join command right away:
+  upterm host --start-at 15:00 --max-duration 1h
+
+  # End the session after an hour, or earlier once nobody types for 15 minutes:
+  upterm host --max-duration 1h --idle-timeout 15m
+
+  # Also accept clients on the same network connecting directly, bypassing the server relay:
+  upterm host --github-user username --direct-listen :2222
+
+  # Offer clients a menu of commands for a support session:
+  upterm host --menu 'logs=tail -f log/production.log' --menu 'top=htop' --read-only
+
+  # Stream a read-only demo, showing viewers when you type and that a quiet session is still alive:
+  upterm host --read-only --show-activity
+
+  # Share a QR code that a mobile SSH client joins with once within 10 minutes:
+  upterm host --qr --qr-ttl 10m
+
+  # Let at most two clients join at once:
+  upterm host --max-clients 2
+
+  # Wait for a busy shared server to have capacity instead of failing:
+  upterm host --queue
+
+  # Keep the terminal at the size of yours rather than shrinking it to fit the smallest client:
+  upterm host --size-policy host
+
+  # Confirm pastes of clients larger than 4 KiB with Ctrl-] y or drop them with Ctrl-] n:
+  upterm host --paste-threshold 4096
+
+  # Let a classroom tool that only speaks telnet watch through the telnet bridge of the server with an invite:
+  upterm host --read-only --allow-telnet --share-link
+
+  # Serve multiple independent terminals from one host process, spawning more with 'upterm session new':
+  upterm host --listen-only --github-user username
+
+  # Invite someone without a registered key with a link they join with once within 30 minutes:
+  upterm host --github-user username --share-link --share-link-ttl 30m
+
+  # Share an announcement signed with your SSH key, which invitees verify with 'ssh-keygen -Y verify':
+  upterm host --github-user username --announce-label "pairing on the release"
+
+  # Let clients attach VS Code for the web to the current directory besides the terminal, served with code-server:
+  upterm host --github-user username --vscode
+
+  # Let clients transfer files to and from the current directory with sftp:
+  upterm host --github-user username --sftp
+
+  # Apply hardened defaults: strict crypto, read-only, no sftp, a 15m client idle timeout, and a 4h max duration:
+  upterm host --github-user username --profile hardened
+
+  # Require a second approver from a change-management webhook before clients attach to a production session:
+  upterm host --github-user username --label env=prod --approval-webhook https://change.example.com/upterm/approvals
+
+  # Hand clients a shell without network access and with a read-only home directory, using bwrap, firejail, or nsjail:
+  upterm host --github-user username --sandbox
+
   # Use a different Uptermd server, hosting a session via WebSocket:
-  upterm host --server wss://YOUR_UPTERMD_SERVER -- YOUR_COMMAND`,
+  upterm host --server wss://YOUR_UPTERMD_SERVER -- YOUR_COMMAND
+
+  # Read the defaults of the server, keys, and authorized users from a config file other than ~/.config/upterm/config.yaml:
+  upterm host --config ./upterm.yaml`,
 		PreRunE: validateShareRequiredFlags,
 		RunE:    shareRunE,
 	}
@@ -69,17 +230,81 @@ private key. To authorize client connections, specify a authorized_key file with
 		log.Fatal(err)
 	}
 
-	cmd.PersistentFlags().StringVarP(&flagServer, "server", "", "ssh://uptermd.upterm.dev:22", "Specify the upterm server address (required). Supported protocols: ssh, ws, wss.")
+	cmd.PersistentFlags().StringVarP(&flagServer, "server", "", defaultServer, "Specify the upterm server address (required). Supported protocols: ssh, ws, wss.")
+	cmd.PersistentFlags().StringVar(&flagConfig, "config", "", "Read the defaults of flags from the specified config file instead of $XDG_CONFIG_HOME/upterm/config.yaml, which defaults to ~/.config/upterm/config.yaml. It sets server, private-keys, known-hosts, authorized-keys, authorized-keys-urls, cert-authorities, github-users, gitlab-users, codeberg-users, srht-users, keep-alive, and read-only. Flags set on the command line override it.")
+	cmd.PersistentFlags().DurationVar(&flagKeepAlive, "keep-alive", defaultKeepAlive, "Ping the server and clients every specified duration to keep idle connections alive through proxies and load balancers.")
+	cmd.PersistentFlags().StringVarP(&flagJump, "jump", "J", "", "Reach the ssh server through jump hosts, like ProxyJump of OpenSSH, e.g. a bastion of a restricted network. Separate multiple hops by commas in the form of [user@]host[:port]. Jump hosts authenticate with the same keys or SSH agent as the server.")
+	cmd.PersistentFlags().DurationVar(&flagReconnectTimeout, "reconnect-timeout", time.Minute, "Reconnect to the server for up to the duration if the connection drops, e.g. on a network blip, resuming the session with the same session ID. The command keeps running meanwhile, and clients reconnect once the session is resumed. Servers keep the sessions of dropped hosts for a grace period of their own. Set it to 0 to end the session with the connection.")
+	cmd.PersistentFlags().BoolVar(&flagWSFallback, "ws-fallback", true, "Retry over the WebSocket endpoint of an ssh server, wss on port 443, if it's unreachable over SSH, e.g. from networks blocking ssh ports. The downgrade is logged. Connections through --jump don't fall back.")
 	cmd.PersistentFlags().StringVarP(&flagForceCommand, "force-command", "f", "", "Enforce a specified command for clients to join, and link the command's input/output to the client's terminal.")
 	cmd.PersistentFlags().StringSliceVarP(&flagPrivateKeys, "private-key", "i", defaultPrivateKeys(homeDir), "Specify private key files for public key authentication with the upterm server (required).")
 	cmd.PersistentFlags().StringVarP(&flagKnownHostsFilename, "known-hosts", "", defaultKnownHost(homeDir), "Specify a file containing known keys for remote hosts (required).")
 	cmd.PersistentFlags().StringVar(&flagAuthorizedKeys, "authorized-keys", "", "Specify a authorize_keys file listing authorized public keys for connection.")
+	cmd.PersistentFlags().StringSliceVar(&flagAuthorizedKeysURLs, "authorized-keys-url", nil, "Authorize public keys fetched from the specified URLs in the authorized_keys format, e.g. a team's keys. Responses are cached and revalidated with ETags.")
+	cmd.PersistentFlags().StringSliceVar(&flagCertAuthorities, "cert-authority", nil, "Specify files of CA public keys. Clients authenticating with user certs signed by the CAs are allowed to connect.")
 	cmd.PersistentFlags().StringSliceVar(&flagCodebergUsers, "codeberg-user", nil, "Authorize specified Codeberg users by allowing their public keys to connect.")
 	cmd.PersistentFlags().StringSliceVar(&flagGitHubUsers, "github-user", nil, "Authorize specified GitHub users by allowing their public keys to connect. Configure GitHub CLI environment variables as needed; see https://cli.github.com/manual/gh_help_environment for details.")
 	cmd.PersistentFlags().StringSliceVar(&flagGitLabUsers, "gitlab-user", nil, "Authorize specified GitLab users by allowing their public keys to connect.")
 	cmd.PersistentFlags().StringSliceVar(&flagSourceHutUsers, "srht-user", nil, "Authorize specified SourceHut users by allowing their public keys to connect.")
+	cmd.PersistentFlags().DurationVar(&flagKeysRefresh, "authorized-keys-refresh", 0, "Re-fetch the authorized keys of code host users and URLs every specified duration while hosting, e.g. 15m, so that revoked keys can't join and new keys can. Keys are kept when fetching fails.")
 	cmd.PersistentFlags().BoolVar(&flagAccept, "accept", false, "Automatically accept client connections without prompts.")
-	cmd.PersistentFlags().BoolVarP(&flagReadOnly, "read-only", "r", false, "Host a read-only session, preventing client interaction.")
+	cmd.PersistentFlags().BoolVarP(&flagReadOnly, "read-only", "r", false, "Host a read-only session, preventing client interaction. Toggle it while hosting by typing Ctrl-] followed by r, or with 'upterm session set --read-only'.")
+	cmd.PersistentFlags().StringVar(&flagStartAt, "start-at", "", "Schedule the session to start at a future time, e.g. 15:00 or 2006-01-02T15:04:05Z07:00. Clients joining earlier wait until then.")
+	cmd.PersistentFlags().DurationVar(&flagMaxDuration, "max-duration", 0, "End the session after the specified duration since it starts, e.g. 1h.")
+	cmd.PersistentFlags().DurationVar(&flagIdleTimeout, "idle-timeout", 0, "End the session when neither the host nor clients type for the specified duration, e.g. 30m. Everyone is warned a minute before.")
+	cmd.PersistentFlags().StringVar(&flagSandbox, "sandbox", "", fmt.Sprintf("Run the command and the force command in a sandbox with the specified profile (%s). Defaults to strict: no network and a read-only home directory.", joinSandboxProfiles()))
+	cmd.PersistentFlags().Lookup("sandbox").NoOptDefVal = string(host.SandboxStrict)
+	cmd.PersistentFlags().StringVar(&flagSandboxTool, "sandbox-tool", "", fmt.Sprintf("Specify the sandbox tool (%s). Defaults to the first installed one.", strings.Join(host.SandboxTools, ", ")))
+	cmd.PersistentFlags().StringVar(&flagLimitRate, "limit-rate", "", "Cap the bandwidth of the reverse tunnel, e.g. 1mbit, 512kbit, or 100k bytes per second. Interactive traffic is prioritized over bulk transfers within the limit.")
+	cmd.PersistentFlags().StringSliceVar(&flagTCPOpts, "tcp-opt", nil, fmt.Sprintf("Set a socket option of the tcp connection to an ssh server in the form of KEY=VALUE (%s), e.g. sndbuf=4194304,rcvbuf=4194304,congestion=bbr for long fat networks. Defaults to nodelay=true and keepalive=15s. Buffer sizes are in bytes. congestion is only supported on linux. Options failing to be set are logged without failing the session.", strings.Join(utils.TCPOpts, ", ")))
+	cmd.PersistentFlags().StringVar(&flagPtyBackend, "pty-backend", "", fmt.Sprintf("Specify the backend attaching commands to terminals (%s). Defaults to pty, or conpty on Windows. With tmux, the command runs in a pane of a dedicated tmux server; only that pane is shared.", strings.Join(host.PtyBackends, ", ")))
+	cmd.PersistentFlags().BoolVar(&flagQueue, "queue", false, "Wait in the queue of the server for it to have capacity if it's at capacity, e.g. a shared team server during a busy incident, instead of failing. The position in the queue is displayed until the session is created. Servers without a queue still refuse the session.")
+	cmd.PersistentFlags().StringVar(&flagSizePolicy, "size-policy", "smallest", fmt.Sprintf("Resolve the window of the terminal when this terminal and clients' differ (%s). host follows this terminal, smallest fits every terminal, largest covers every terminal so that smaller ones crop the output, and manual keeps the window of --window-size. The terminal is resized again when clients join or leave.", strings.Join(host.SizePolicies, ", ")))
+	cmd.PersistentFlags().StringVar(&flagWindowSize, "window-size", "", "Set the window of the terminal with --size-policy manual in the form of COLUMNSxROWS, e.g. 120x40.")
+	cmd.PersistentFlags().BoolVar(&flagQR, "qr", false, "Display a QR code of an ssh:// URI to join the session with a one-time token, for mobile SSH clients like Termius or Blink. Requires an ssh server. Authorized keys still apply.")
+	cmd.PersistentFlags().DurationVar(&flagQRTTL, "qr-ttl", 10*time.Minute, "Expire the one-time token of the QR code after the specified duration.")
+	cmd.PersistentFlags().BoolVar(&flagShareLink, "share-link", false, "Print an ssh:// link, or a wss:// link for WebSocket servers, to join the session with a one-time token. The token lets someone without an authorized key join once; the server consumes it when they authenticate.")
+	cmd.PersistentFlags().DurationVar(&flagShareLinkTTL, "share-link-ttl", time.Hour, "Expire the one-time token of the share link after the specified duration.")
+	cmd.PersistentFlags().BoolVar(&flagSFTP, "sftp", false, "Let clients transfer files to and from the current directory with SFTP, e.g. sftp or scp -s. Refused while the session is read-only. Can't be used with --force-command, --menu, or --sandbox.")
+	cmd.PersistentFlags().BoolVar(&flagAllowExec, "allow-exec", false, "Let clients transfer files to and from the current directory with scp and rsync -e ssh, which run their server side without a PTY. Other commands are refused. Refused while the session is read-only. Can't be used with --force-command, --menu, or --sandbox.")
+	cmd.PersistentFlags().BoolVar(&flagPortForwarding, "allow-port-forwarding", false, "Let clients forward connections to ports of this machine, e.g. 'ssh -L 8080:localhost:8080' to reach a local dev server. Only localhost destinations are allowed. Refused while the session is read-only. Can't be used with --force-command, --menu, or --sandbox.")
+	cmd.PersistentFlags().UintSliceVar(&flagForwardPorts, "forward", nil, "Publish a localhost port of this machine, e.g. of a local dev server, so that clients can reach it with 'ssh -L 3000:localhost:3000'. Published ports are reachable even while the session is read-only. Repeat the flag to publish more ports.")
+	cmd.PersistentFlags().BoolVar(&flagVSCode, "vscode", false, "Serve the current directory in VS Code for the web with code-server on a localhost port published to clients, so that they can attach an editor besides the terminal. The editor is protected by a random password shown with the session. Can't be used with --force-command, --menu, --sandbox, or --read-only.")
+	cmd.PersistentFlags().UintVar(&flagVSCodePort, "vscode-port", host.DefaultVSCodePort, "Serve VS Code of --vscode on the specified localhost port.")
+	cmd.PersistentFlags().StringVar(&flagVSCodeBin, "vscode-bin", "code-server", "Serve VS Code of --vscode with the specified code-server binary.")
+	cmd.PersistentFlags().StringVar(&flagCompression, "transfer-compression", "off", "Compress file transfers of --sftp and --allow-exec with zstd for clients supporting it, at a level of off, "+strings.Join(api.CompressionLevels, ", ")+". Terminals are never compressed.")
+	cmd.PersistentFlags().BoolVar(&flagAnnounce, "announce", false, "Display an announcement of the session to share with invitees, signed with your SSH key in the format of 'ssh-keygen -Y sign', so that they can verify the join command hasn't been tampered with.")
+	cmd.PersistentFlags().StringVar(&flagAnnounceLabel, "announce-label", "", "Describe the session in the signed announcement, e.g. \"pairing on the release\". Implies --announce.")
+	cmd.PersistentFlags().BoolVar(&flagRecordingOptOut, "recording-opt-out", false, "Ask the server not to record the session in its audit log. Servers only honor it for hosts their host ACL grants recording-opt-out.")
+	cmd.PersistentFlags().IntVar(&flagMaxClients, "max-clients", 0, "Cap the clients attached to the session at once. Further clients are disconnected with a banner. 0 means no cap, though the server may set one.")
+	cmd.PersistentFlags().IntVar(&flagPasteThreshold, "paste-threshold", 0, "Hold pastes of clients larger than the specified bytes, and bracketed pastes with escape sequences, until you forward them by typing Ctrl-] followed by y or drop them with Ctrl-] n. Unconfirmed pastes are dropped after 30 seconds. 0 disables it.")
+	cmd.PersistentFlags().BoolVar(&flagStrictCrypto, "strict-crypto", false, "Only negotiate modern key exchanges, AEAD ciphers, and public keys with non-SHA-1 signatures with the server and clients.")
+	cmd.PersistentFlags().DurationVar(&flagClientIdleTimeout, "client-idle-timeout", 0, "Disconnect clients that haven't typed for the specified duration, e.g. 15m. Clients of read-only sessions are disconnected too.")
+	cmd.PersistentFlags().BoolVar(&flagShowTimer, "show-timer", false, "Show the elapsed and remaining time of the session in the terminal titles of the host and clients when --max-duration is set or the server limits the session age, and when clients are disconnected if --client-idle-timeout is set.")
+	cmd.PersistentFlags().BoolVar(&flagShowActivity, "show-activity", false, "Show clients of read-only sessions when the host is typing in their terminal titles, and tell them the session is still alive once the output stalls for two minutes, e.g. for viewers of long-running demos.")
+	cmd.PersistentFlags().BoolVar(&flagStatusLine, "status-line", false, "Show the number of clients connected and their fingerprints on the last line of your terminal, updated as they join and leave, so that you never forget the session is watched. The command runs in a window a line shorter.")
+	cmd.PersistentFlags().BoolVar(&flagShowTyping, "show-typing", false, "Prefix the status line with the ID of the client typing while it types, so that you know who typed what when several clients can type. It implies --status-line.")
+	cmd.PersistentFlags().BoolVar(&flagRestartCommand, "restart-command", false, "Restart the command when it fails, e.g. a crashed shell, instead of ending the session. Clients stay connected across restarts. The session ends once the command exits successfully.")
+	cmd.PersistentFlags().BoolVar(&flagKeepSessionOnExit, "keep-session-on-exit", false, "Keep the session once the command exits, showing that it exited until you or a client types r to restart it or q to end the session. Clients stay connected meanwhile. With --restart-command, it applies once the command exits successfully.")
+	cmd.PersistentFlags().StringVar(&flagIdentityFile, "identity-file", "", "Display clients by names from a lookup file instead of bare fingerprints, one per line in the form of FINGERPRINT NAME or an authorized_keys line commented with the name. Names default to the comments of authorized keys and the usernames of --github-user and the like.")
+	cmd.PersistentFlags().StringVar(&flagIdentityCommand, "identity-command", "", "Look up the display names of clients with a command, like AuthorizedKeysCommand of OpenSSH, e.g. a script querying LDAP. %f, %k, and %t are expanded to the fingerprint, the base64-encoded key, and the key type. It prints the name on the first line. It runs if --identity-file doesn't know the key.")
+	cmd.PersistentFlags().DurationVar(&flagEvictGhostsAfter, "evict-ghosts-after", 30*time.Second, "Disconnect clients that stop responding to keepalives for the specified duration, e.g. after a NAT timeout or a crashed terminal, so that they leave the connected clients. 0 disables it.")
+	cmd.PersistentFlags().DurationVar(&flagCoalesceOutput, "coalesce-output", 2*time.Millisecond, "Coalesce small writes of the output to clients for up to the specified duration, sending fewer packets and WebSocket frames for chatty TUIs. Output after a pause, like echoed keystrokes, is sent immediately. 0 disables it.")
+	cmd.PersistentFlags().StringVar(&flagProfile, "profile", "", fmt.Sprintf("Apply curated defaults (%s). hardened requires authorized keys, enables --strict-crypto and --read-only, disables --sftp, and sets --client-idle-timeout to %s and --max-duration to %s. Flags set explicitly override the profile. The effective policy is displayed at startup.", strings.Join(hostProfiles, ", "), durationOrUnlimited(hardenedClientIdleTimeout), durationOrUnlimited(hardenedMaxDuration)))
+	cmd.PersistentFlags().StringArrayVar(&flagMenu, "menu", nil, "Let clients choose a command from a menu in the form of NAME=COMMAND instead of attaching to the host's command. Each client runs the chosen command in its own terminal. Repeat the flag to add commands.")
+	cmd.PersistentFlags().StringVar(&flagAgreePolicy, "agree-policy", "", "Agree to the server policy with the specified hash without prompting, e.g. for automation. The session fails to start if the policy has changed.")
+	cmd.PersistentFlags().StringVar(&flagName, "name", "", "Name the session, e.g. \"debugging prod incident\". The name is shown with the session, by 'upterm session list', and to joining clients.")
+	cmd.PersistentFlags().BoolVar(&flagAllowTelnet, "allow-telnet", false, "Let viewers watch the session read-only over the telnet-over-TLS bridge of the server, e.g. from classroom tools that only speak telnet. Their input is discarded. They log in with the username of the session, which must carry an invite of --share-link if authorized keys are set. The server sees the session unencrypted. Can't be used with --force-command or --menu.")
+	cmd.PersistentFlags().BoolVar(&flagListenOnly, "listen-only", false, "Serve multiple independent sessions, each with its own terminal and session ID, instead of sharing this terminal. A session running the command is spawned first, and more are spawned with 'upterm session new'. The host ends when all sessions have ended. Can't be used with --direct-listen, --qr, --share-link, or --vscode.")
+	cmd.PersistentFlags().StringArrayVar(&flagLabels, "label", nil, "Label the session in the form of KEY=VALUE, e.g. env=prod, to apply approval policies. Labels are sent to the server, which shows them in its notifications and audit log. Repeat the flag to add labels.")
+	cmd.PersistentFlags().StringVar(&flagApprovalWebhook, "approval-webhook", "", "Require clients of sessions matching --approval-selector to be approved by a webhook, e.g. of a change-management system. Requests are POSTed as JSON and answered with {\"status\": \"pending|approved|denied\", \"approver\": ..., \"reason\": ..., \"poll_url\": ...}. Pending requests are polled with GET until they are decided. Clients wait meanwhile.")
+	cmd.PersistentFlags().StringVar(&flagApprovalCommand, "approval-command", "", "Require clients of sessions matching --approval-selector to be approved by a command, e.g. a script checking the OIDC groups of a second approver. It exits with zero to approve and prints the approver, or exits with non-zero to deny and prints the reason. %s, %f, %n, and %l are expanded to the session ID, the fingerprint and the name of the client, and the session labels.")
+	cmd.PersistentFlags().StringVar(&flagApprovalWeb, "approval-web", "", "Require clients of sessions matching --approval-selector to be approved on a web page served at the specified address, e.g. 127.0.0.1:8421, or :8421 to open it from a phone on the same network. The URL of the page carries an access token and is printed when the session starts. Suits hosts running headless.")
+	cmd.PersistentFlags().StringArrayVar(&flagApprovalSelector, "approval-selector", []string{"env=prod"}, "Require approval for sessions with all the specified labels in the form of KEY=VALUE. Sessions matching it can't be hosted without --approval-webhook, --approval-command, or --approval-web.")
+	cmd.PersistentFlags().DurationVar(&flagApprovalTimeout, "approval-timeout", 10*time.Minute, "Deny clients that aren't approved within the specified duration.")
+	cmd.PersistentFlags().StringVar(&flagWebhookURL, "webhook-url", "", "POST the events of the session as JSON to the specified URL, e.g. to pipe clients joining into a chat or an audit system: session-created, client-joined and client-left with the fingerprint of the client, client-approval, and session-ended with the session statistics. Bodies are {\"session_id\": ..., \"version\": 1, \"kind\": ..., \"time\": ..., \"payload\": {...}}.")
+	cmd.PersistentFlags().StringVar(&flagWebhookSecret, "webhook-secret", "", fmt.Sprintf("Sign the bodies of --webhook-url with HMAC-SHA256 keyed with the specified secret in the %s header, in the form of sha256=HEX. Defaults to $%s, which keeps the secret out of the process list.", host.WebhookSignatureHeader, webhookSecretEnvVar))
+	cmd.PersistentFlags().StringVar(&flagDirectListen, "direct-listen", "", "Also accept clients connecting directly to this host on the specified address, e.g. :2222, bypassing the server relay. Requires authorized keys.")
 
 	return cmd
 }
@@ -87,6 +312,16 @@ private key. To authorize client connections, specify a authorized_key file with
 func validateShareRequiredFlags(c *cobra.Command, args []string) error {
 	var result error
 
+	if err := applyConfig(c); err != nil {
+		result = multierror.Append(result, fmt.Errorf("error reading config: %w", err))
+	}
+
+	if err := applyHostProfile(c, flagProfile); err != nil {
+		result = multierror.Append(result, err)
+	} else if flagProfile == profileHardened && !hasAuthorizedKeysFlags() {
+		result = multierror.Append(result, fmt.Errorf("--profile hardened requires authorized keys, e.g. --github-user or --authorized-keys"))
+	}
+
 	if flagServer == "" {
 		result = multierror.Append(result, fmt.Errorf("missing flag --server"))
 	} else {
@@ -119,9 +354,400 @@ func validateShareRequiredFlags(c *cobra.Command, args []string) error {
 		}
 	}
 
+	if flagKeysRefresh < 0 {
+		result = multierror.Append(result, fmt.Errorf("--authorized-keys-refresh must not be negative"))
+	}
+	if flagReconnectTimeout < 0 {
+		result = multierror.Append(result, fmt.Errorf("--reconnect-timeout must not be negative"))
+	}
+
+	if flagJump != "" {
+		if _, err := host.ParseJumpHosts(flagJump); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if u, err := url.Parse(flagServer); err == nil && u.Scheme != "ssh" {
+			result = multierror.Append(result, fmt.Errorf("--jump requires an ssh server"))
+		}
+	}
+
+	if flagStartAt != "" {
+		if _, err := parseStartAt(flagStartAt, time.Now()); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if flagMaxDuration < 0 {
+		result = multierror.Append(result, fmt.Errorf("max duration must be positive"))
+	}
+
+	if flagIdleTimeout < 0 {
+		result = multierror.Append(result, fmt.Errorf("idle timeout must be positive"))
+	}
+
+	if flagClientIdleTimeout < 0 {
+		result = multierror.Append(result, fmt.Errorf("client idle timeout must be positive"))
+	}
+
+	if flagEvictGhostsAfter < 0 {
+		result = multierror.Append(result, fmt.Errorf("--evict-ghosts-after must not be negative"))
+	}
+
+	if flagCoalesceOutput < 0 {
+		result = multierror.Append(result, fmt.Errorf("--coalesce-output must not be negative"))
+	}
+
+	if flagDirectListen != "" {
+		if _, _, err := net.SplitHostPort(flagDirectListen); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error parsing direct listen address: %w", err))
+		}
+	}
+
+	if flagLimitRate != "" {
+		if _, err := host.ParseRate(flagLimitRate); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if len(flagTCPOpts) > 0 {
+		if _, err := utils.ParseTCPTuning(flagTCPOpts); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if flagPtyBackend != "" {
+		if err := host.ValidatePtyBackend(flagPtyBackend); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if w, h, err := parseWindowSize(flagWindowSize); err != nil {
+		result = multierror.Append(result, err)
+	} else if err := host.ValidateSizePolicy(flagSizePolicy, w, h); err != nil {
+		result = multierror.Append(result, fmt.Errorf("--size-policy: %w, e.g. --window-size 120x40", err))
+	}
+
+	if flagQR {
+		if u, err := url.Parse(flagServer); err == nil && u.Scheme != "ssh" {
+			result = multierror.Append(result, fmt.Errorf("--qr requires an ssh server, mobile SSH clients can't proxy via %s", u.Scheme))
+		}
+		if flagQRTTL <= 0 {
+			result = multierror.Append(result, fmt.Errorf("QR code ttl must be positive"))
+		}
+	}
+
+	if flagShareLink {
+		if flagShareLinkTTL <= 0 {
+			result = multierror.Append(result, fmt.Errorf("share link ttl must be positive"))
+		}
+		if flagProfile == profileHardened {
+			result = multierror.Append(result, fmt.Errorf("--share-link can't be used with --profile hardened, which requires authorized keys"))
+		}
+	}
+
+	if err := validateSessionName(flagName); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if flagKeepAlive <= 0 {
+		result = multierror.Append(result, fmt.Errorf("keep-alive must be positive"))
+	}
+
+	if flagMaxClients < 0 {
+		result = multierror.Append(result, fmt.Errorf("max clients must not be negative"))
+	}
+
+	if flagPasteThreshold < 0 {
+		result = multierror.Append(result, fmt.Errorf("paste threshold must not be negative"))
+	}
+
+	if flagAllowTelnet && (flagForceCommand != "" || len(flagMenu) > 0) {
+		result = multierror.Append(result, fmt.Errorf("--allow-telnet can't be used with --force-command or --menu, viewers would get their own terminals"))
+	}
+
+	if flagListenOnly && (flagDirectListen != "" || flagQR || flagShareLink || flagVSCode) {
+		result = multierror.Append(result, fmt.Errorf("--listen-only can't be used with --direct-listen, --qr, --share-link, or --vscode, only one session can use them"))
+	}
+
+	if len(flagMenu) > 0 {
+		if flagForceCommand != "" {
+			result = multierror.Append(result, fmt.Errorf("--menu can't be used with --force-command"))
+		}
+		if _, err := parseMenu(flagMenu); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if flagSFTP && (flagForceCommand != "" || len(flagMenu) > 0 || flagSandbox != "" || flagSandboxTool != "") {
+		result = multierror.Append(result, fmt.Errorf("--sftp can't be used with --force-command, --menu, or --sandbox, file transfers would bypass them"))
+	}
+
+	if flagAllowExec && (flagForceCommand != "" || len(flagMenu) > 0 || flagSandbox != "" || flagSandboxTool != "") {
+		result = multierror.Append(result, fmt.Errorf("--allow-exec can't be used with --force-command, --menu, or --sandbox, file transfers would bypass them"))
+	}
+
+	if flagPortForwarding && (flagForceCommand != "" || len(flagMenu) > 0 || flagSandbox != "" || flagSandboxTool != "") {
+		result = multierror.Append(result, fmt.Errorf("--allow-port-forwarding can't be used with --force-command, --menu, or --sandbox, forwarded connections would bypass them"))
+	}
+
+	if flagVSCode {
+		if flagForceCommand != "" || len(flagMenu) > 0 || flagSandbox != "" || flagSandboxTool != "" || flagReadOnly {
+			result = multierror.Append(result, fmt.Errorf("--vscode can't be used with --force-command, --menu, --sandbox, or --read-only, the editor would bypass them"))
+		}
+		if flagVSCodePort == 0 || flagVSCodePort > 65535 {
+			result = multierror.Append(result, fmt.Errorf("--vscode-port %d must be between 1 and 65535", flagVSCodePort))
+		}
+	}
+
+	for _, p := range flagForwardPorts {
+		if p == 0 || p > 65535 {
+			result = multierror.Append(result, fmt.Errorf("--forward port %d must be between 1 and 65535", p))
+		}
+	}
+
+	if flagCompression != "off" {
+		if _, err := api.ParseCompressionLevel(flagCompression); err != nil {
+			result = multierror.Append(result, fmt.Errorf("--transfer-compression: %w", err))
+		}
+	}
+
+	if flagSandbox != "" || flagSandboxTool != "" {
+		if _, err := newSandbox().Validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if _, _, err := newApprovalPolicy(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
 	return result
 }
 
+// userKeyFlags returns the usernames of the code hosts of host.KeyFetchers by the names of the fetchers.
+func userKeyFlags() map[string][]string {
+	return map[string][]string{
+		"codeberg": flagCodebergUsers,
+		"github":   flagGitHubUsers,
+		"gitlab":   flagGitLabUsers,
+		"srht":     flagSourceHutUsers,
+	}
+}
+
+// sessionAccess returns the access of the flags, which is persisted to re-establish the session with it.
+func sessionAccess() host.SessionAccess {
+	return host.SessionAccess{
+		PrivateKeys:        absPaths(flagPrivateKeys),
+		KnownHostsFile:     absPath(flagKnownHostsFilename),
+		AuthorizedKeysFile: absPath(flagAuthorizedKeys),
+		AuthorizedKeysURLs: flagAuthorizedKeysURLs,
+		CertAuthorities:    absPaths(flagCertAuthorities),
+		CodebergUsers:      flagCodebergUsers,
+		GitHubUsers:        flagGitHubUsers,
+		GitLabUsers:        flagGitLabUsers,
+		SourceHutUsers:     flagSourceHutUsers,
+		Profile:            flagProfile,
+	}
+}
+
+// absPath returns the absolute path of file, so that it's found from any directory. Empty files stay empty.
+func absPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+
+	return file
+}
+
+func absPaths(files []string) []string {
+	var abs []string
+	for _, f := range files {
+		abs = append(abs, absPath(f))
+	}
+
+	return abs
+}
+
+// loadAuthorizedKeys reads the authorized keys of the flags. Keys fetched from code hosts and URLs are cached
+// in the upterm dir.
+func loadAuthorizedKeys(ctx context.Context, logger log.FieldLogger) ([]*host.AuthorizedKey, error) {
+	var authorizedKeys []*host.AuthorizedKey
+	if flagAuthorizedKeys != "" {
+		aks, err := host.AuthorizedKeysFromFile(flagAuthorizedKeys)
+		if err != nil {
+			return nil, fmt.Errorf("error reading authorized keys: %w", err)
+		}
+		authorizedKeys = append(authorizedKeys, aks)
+	}
+	for _, file := range flagCertAuthorities {
+		cas, err := host.CertAuthoritiesFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cert authorities: %w", err)
+		}
+		authorizedKeys = append(authorizedKeys, cas)
+	}
+
+	users := userKeyFlags()
+	if !hasUserKeyFlags() && flagAuthorizedKeysURLs == nil {
+		return host.MergeAuthorizedKeys(authorizedKeys), nil
+	}
+
+	uptermDir, err := utils.CreateUptermDir()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := filepath.Join(uptermDir, "cache")
+
+	for _, name := range host.KeyFetcherNames() {
+		if users[name] == nil {
+			continue
+		}
+
+		fetcher := host.KeyFetchers[name]
+		uk := host.UserKeys{
+			Fetcher:   fetcher,
+			Usernames: users[name],
+			CacheDir:  cacheDir,
+			Logger:    logger,
+		}
+		userKeys, err := uk.Fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s user keys: %w", fetcher.Name(), err)
+		}
+		authorizedKeys = append(authorizedKeys, userKeys...)
+	}
+	for _, u := range flagAuthorizedKeysURLs {
+		urlKeys, err := host.AuthorizedKeysFromURL(u, cacheDir, logger)
+		if err != nil {
+			return nil, fmt.Errorf("error reading authorized keys from URL: %w", err)
+		}
+		authorizedKeys = append(authorizedKeys, urlKeys)
+	}
+
+	return host.MergeAuthorizedKeys(authorizedKeys), nil
+}
+
+func hasUserKeyFlags() bool {
+	for _, usernames := range userKeyFlags() {
+		if len(usernames) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newApprovalPolicy returns the labels of the session and the approval policy of the flags.
+// Sessions requiring approval can't be hosted without an approver.
+func newApprovalPolicy() (approval.Labels, *approval.Policy, error) {
+	labels, err := approval.ParseLabels(flagLabels)
+	if err != nil {
+		return nil, nil, err
+	}
+	selector, err := approval.ParseLabels(flagApprovalSelector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid approval selector: %w", err)
+	}
+	if flagApprovalTimeout <= 0 {
+		return nil, nil, fmt.Errorf("approval timeout must be positive")
+	}
+
+	approver, err := approval.Load(flagApprovalWebhook, flagApprovalCommand, flagApprovalWeb != "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if approver == nil {
+		if labels.Matches(selector) {
+			return nil, nil, fmt.Errorf("sessions labeled %s require approval, specify --approval-webhook, --approval-command, or --approval-web", selector)
+		}
+		return labels, nil, nil
+	}
+
+	return labels, &approval.Policy{
+		Selector: selector,
+		Approver: approver,
+		Timeout:  flagApprovalTimeout,
+	}, nil
+}
+
+func newSandbox() *host.Sandbox {
+	profile := host.SandboxProfile(flagSandbox)
+	if profile == "" {
+		profile = host.SandboxStrict
+	}
+
+	homeDir, _ := os.UserHomeDir()
+
+	return &host.Sandbox{
+		Profile: profile,
+		Tool:    flagSandboxTool,
+		HomeDir: homeDir,
+	}
+}
+
+func joinSandboxProfiles() string {
+	var profiles []string
+	for _, p := range host.SandboxProfiles {
+		profiles = append(profiles, string(p))
+	}
+
+	return strings.Join(profiles, ", ")
+}
+
+// parseMenu parses menu items in the form of NAME=COMMAND.
+func parseMenu(items []string) ([]*api.MenuItem, error) {
+	var menu []*api.MenuItem
+	for _, item := range items {
+		name, command, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid menu item %q, expect NAME=COMMAND", item)
+		}
+
+		args, err := shlex.Split(command)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing command of menu item %s: %w", name, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing command of menu item %s", name)
+		}
+
+		menu = append(menu, &api.MenuItem{Name: name, Command: args})
+	}
+
+	return menu, nil
+}
+
+// parseStartAt parses a time of day in the local timezone, e.g. 15:00, or a RFC3339 timestamp.
+// A time of day that has passed today refers to the same time tomorrow, while a timestamp must not have passed.
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		if t.Before(now) {
+			return time.Time{}, fmt.Errorf("start time %s has passed", t.Format(time.RFC3339))
+		}
+
+		return t, nil
+	}
+
+	for _, layout := range []string{"15:04", "15:04:05", time.Kitchen} {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid start time %q, expect a time of day like 15:00 or a RFC3339 timestamp", s)
+}
+
 func shareRunE(c *cobra.Command, args []string) error {
 	var err error
 	if len(args) == 0 {
@@ -143,6 +769,11 @@ func shareRunE(c *cobra.Command, args []string) error {
 		}
 	}
 
+	windowWidth, windowHeight, err := parseWindowSize(flagWindowSize)
+	if err != nil {
+		return err
+	}
+
 	lf, err := utils.OpenHostLogFile()
 	if err != nil {
 		return err
@@ -152,55 +783,131 @@ func shareRunE(c *cobra.Command, args []string) error {
 	logger := log.New()
 	logger.SetOutput(lf)
 
-	var authorizedKeys []*host.AuthorizedKey
-	if flagAuthorizedKeys != "" {
-		aks, err := host.AuthorizedKeysFromFile(flagAuthorizedKeys)
-		if err != nil {
-			return fmt.Errorf("error reading authorized keys: %w", err)
-		}
-		authorizedKeys = append(authorizedKeys, aks)
+	authorizedKeys, err := loadAuthorizedKeys(context.Background(), logger)
+	if err != nil {
+		return err
 	}
-	if flagCodebergUsers != nil {
-		codebergUserKeys, err := host.CodebergUserAuthorizedKeys(flagCodebergUsers)
-		if err != nil {
-			return fmt.Errorf("error reading Codeberg user keys: %w", err)
-		}
-		authorizedKeys = append(authorizedKeys, codebergUserKeys...)
+
+	var numAuthorizedKeys int
+	for _, ak := range authorizedKeys {
+		numAuthorizedKeys += len(ak.PublicKeys)
+	}
+	displayHostProfile(os.Stdout, c, flagProfile, numAuthorizedKeys)
+
+	signers, cleanup, err := host.Signers(flagPrivateKeys)
+	if err != nil {
+		return fmt.Errorf("error reading private keys: %w", err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	hkcb, err := host.NewPromptingHostKeyCallback(os.Stdin, os.Stdout, flagKnownHostsFilename)
+	if err != nil {
+		return err
 	}
-	if flagGitHubUsers != nil {
-		gitHubUserKeys, err := host.GitHubUserAuthorizedKeys(flagGitHubUsers, logger)
+
+	var startAt time.Time
+	if flagStartAt != "" {
+		startAt, err = parseStartAt(flagStartAt, time.Now())
 		if err != nil {
-			return fmt.Errorf("error reading GitHub user keys: %w", err)
+			return err
 		}
-		authorizedKeys = append(authorizedKeys, gitHubUserKeys...)
 	}
-	if flagGitLabUsers != nil {
-		gitLabUserKeys, err := host.GitLabUserAuthorizedKeys(flagGitLabUsers)
-		if err != nil {
-			return fmt.Errorf("error reading GitLab user keys: %w", err)
+
+	menu, err := parseMenu(flagMenu)
+	if err != nil {
+		return err
+	}
+
+	var sandbox *host.Sandbox
+	if flagSandbox != "" || flagSandboxTool != "" {
+		sandbox = newSandbox()
+	}
+
+	var limitRate int64
+	if flagLimitRate != "" {
+		if limitRate, err = host.ParseRate(flagLimitRate); err != nil {
+			return err
 		}
-		authorizedKeys = append(authorizedKeys, gitLabUserKeys...)
 	}
-	if flagSourceHutUsers != nil {
-		sourceHutUserKeys, err := host.SourceHutUserAuthorizedKeys(flagSourceHutUsers)
+
+	var tcpTuning *utils.TCPTuning
+	if len(flagTCPOpts) > 0 {
+		t, err := utils.ParseTCPTuning(flagTCPOpts)
 		if err != nil {
-			return fmt.Errorf("error reading SourceHut user keys: %w", err)
+			return err
 		}
-		authorizedKeys = append(authorizedKeys, sourceHutUserKeys...)
+		tcpTuning = &t
 	}
 
-	signers, cleanup, err := host.Signers(flagPrivateKeys)
+	var forwardedPorts []uint32
+	for _, p := range flagForwardPorts {
+		forwardedPorts = append(forwardedPorts, uint32(p))
+	}
+
+	var vscode *host.VSCode
+	if flagVSCode {
+		vscode = &host.VSCode{Port: uint32(flagVSCodePort), Bin: flagVSCodeBin}
+	}
+
+	var joinTokens *host.JoinTokens
+	if flagQR || flagShareLink {
+		joinTokens = host.NewJoinTokens()
+	}
+	var announcer ssh.Signer
+	if flagAnnounce || flagAnnounceLabel != "" {
+		if announcer, err = host.AnnouncementSigner(signers); err != nil {
+			return err
+		}
+	}
+	identities, err := identity.Load(flagIdentityFile, flagIdentityCommand)
 	if err != nil {
-		return fmt.Errorf("error reading private keys: %w", err)
+		return err
 	}
-	if cleanup != nil {
-		defer cleanup()
+
+	var jumpHosts []*url.URL
+	if flagJump != "" {
+		if jumpHosts, err = host.ParseJumpHosts(flagJump); err != nil {
+			return err
+		}
 	}
 
-	hkcb, err := host.NewPromptingHostKeyCallback(os.Stdin, os.Stdout, flagKnownHostsFilename)
+	labels, approvalPolicy, err := newApprovalPolicy()
 	if err != nil {
 		return err
 	}
+	if approvalPolicy.Required(labels) {
+		if web, ok := approvalPolicy.Approver.(*approval.Web); ok {
+			ln, err := serveApprovalWeb(web, logger)
+			if err != nil {
+				return err
+			}
+			defer ln.Close()
+		}
+	}
+
+	var webhook *host.Webhook
+	if flagWebhookURL != "" {
+		secret := flagWebhookSecret
+		if secret == "" {
+			secret = os.Getenv(webhookSecretEnvVar)
+		}
+		if webhook, err = host.NewWebhook(flagWebhookURL, secret, logger.WithField("com", "webhook")); err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+			defer cancel()
+			if err := webhook.Close(ctx); err != nil {
+				logger.WithError(err).Warn("error posting the last events to the webhook")
+			}
+		}()
+	}
+
+	sessionCreatedCallback := func(session *api.GetSessionResponse) error {
+		return displaySessionCallback(session, joinTokens, announcer)
+	}
 
 	h := &host.Host{
 		Host:                   flagServer,
@@ -209,17 +916,172 @@ func shareRunE(c *cobra.Command, args []string) error {
 		Signers:                signers,
 		HostKeyCallback:        hkcb,
 		AuthorizedKeys:         authorizedKeys,
-		KeepAliveDuration:      50 * time.Second, // nlb is 350 sec & heroku router is 55 sec
-		SessionCreatedCallback: displaySessionCallback,
+		KeepAliveDuration:      flagKeepAlive,
+		SessionCreatedCallback: sessionCreatedCallback,
+		SessionEndedCallback:   displayStatsCallback,
 		ClientJoinedCallback:   clientJoinedCallback,
 		ClientLeftCallback:     clientLeftCallback,
 		Stdin:                  os.Stdin,
 		Stdout:                 os.Stdout,
 		Logger:                 logger,
 		ReadOnly:               flagReadOnly,
+		StartAt:                startAt,
+		MaxDuration:            flagMaxDuration,
+		IdleTimeout:            flagIdleTimeout,
+		DirectListenAddr:       flagDirectListen,
+		Sandbox:                sandbox,
+		Menu:                   menu,
+		LimitRate:              limitRate,
+		PtyBackend:             flagPtyBackend,
+		JoinTokens:             joinTokens,
+		SFTP:                   flagSFTP,
+		Exec:                   flagAllowExec,
+		PortForwarding:         flagPortForwarding,
+		ForwardedPorts:         forwardedPorts,
+		VSCode:                 vscode,
+		TransferCompression:    flagCompression,
+		TCPTuning:              tcpTuning,
+		StrictCrypto:           flagStrictCrypto,
+		RecordingOptOut:        flagRecordingOptOut,
+		MaxClients:             flagMaxClients,
+		PasteThreshold:         flagPasteThreshold,
+		Name:                   flagName,
+		ListenOnly:             flagListenOnly,
+		SizePolicy:             flagSizePolicy,
+		Queue:                  flagQueue,
+		WebSocketFallback:      flagWSFallback,
+		ReconnectTimeout:       flagReconnectTimeout,
+		QueuePositionCallback:  displayQueuePosition,
+		WindowWidth:            windowWidth,
+		WindowHeight:           windowHeight,
+		Viewers:                flagAllowTelnet,
+		RequireAuthorizedKeys:  flagProfile == profileHardened,
+		ClientIdleTimeout:      flagClientIdleTimeout,
+		EvictGhostsAfter:       flagEvictGhostsAfter,
+		OutputCoalesceDelay:    flagCoalesceOutput,
+		Identities:             identities,
+		JumpHosts:              jumpHosts,
+		ShowTimer:              flagShowTimer,
+		ShowActivity:           flagShowActivity,
+		StatusLine:             flagStatusLine || flagShowTyping,
+		ShowTyping:             flagShowTyping,
+		RestartCommand:         flagRestartCommand,
+		KeepSessionOnExit:      flagKeepSessionOnExit,
+		Access:                 sessionAccess(),
+		Labels:                 labels,
+		Approval:               approvalPolicy,
+		Webhook:                webhook,
+		RefreshAuthorizedKeys: func(ctx context.Context) ([]*host.AuthorizedKey, error) {
+			return loadAuthorizedKeys(ctx, logger)
+		},
+		AuthorizedKeysRefreshInterval: flagKeysRefresh,
+		AgreePolicyCallback: func(policy, hash string) error {
+			return agreePolicy(bufio.NewReader(os.Stdin), os.Stdout, flagAgreePolicy, policy, hash)
+		},
+	}
+
+	ctx := context.Background()
+	if flagListenOnly {
+		// the terminal isn't raw, so end the sessions on ctrl-c, cleaning up their admin sockets
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+
+	if err := h.Run(ctx); err != nil {
+		if errors.Is(err, host.ErrMaxDurationReached) {
+			fmt.Printf("\nSession ended after reaching its max duration of %s\n", flagMaxDuration)
+			return nil
+		}
+		if errors.Is(err, host.ErrIdleTimeoutReached) {
+			fmt.Printf("\nSession ended after nobody typed for %s\n", flagIdleTimeout)
+			return nil
+		}
+		if errors.Is(err, host.ErrSessionExpired) {
+			fmt.Printf("\nSession ended by the server after reaching its max session age\n")
+			return nil
+		}
+		var ended *host.SessionEndedError
+		if errors.As(err, &ended) {
+			fmt.Printf("\nSession ended by the server: %s\n", ended.Reason)
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// serveApprovalWeb serves the approval web page on --approval-web until the returned listener is closed,
+// printing its URL.
+func serveApprovalWeb(web *approval.Web, logger log.FieldLogger) (net.Listener, error) {
+	ln, err := net.Listen("tcp", flagApprovalWeb)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for the approval web page: %w", err)
 	}
 
-	return h.Run(context.Background())
+	go func() {
+		srv := &http.Server{Handler: web, ReadHeaderTimeout: 10 * time.Second}
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.WithError(err).Error("error serving the approval web page")
+		}
+	}()
+
+	u := web.WebURL(ln.Addr())
+	logger.WithField("url", u).Info("Serving the approval web page")
+	fmt.Printf("Approve clients at %s\n\n", u)
+
+	return ln, nil
+}
+
+// agreePolicy displays the server policy and asks the host to agree to it,
+// unless the host has agreed to the policy with the hash of --agree-policy.
+func agreePolicy(in *bufio.Reader, out io.Writer, agreed, policy, hash string) error {
+	if agreed != "" && agreed == hash {
+		return nil
+	}
+
+	fmt.Fprintf(out, "=== Server Policy\n%s\n", strings.TrimRight(policy, "\n"))
+	if agreed != "" {
+		return fmt.Errorf("the server policy has changed, review it and rerun with --agree-policy %s", hash)
+	}
+
+	fmt.Fprint(out, "\nDo you agree to the server policy? [y/N]: ")
+	answer, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		fmt.Fprintf(out, "To agree without prompting next time, run with --agree-policy %s\n\n", hash)
+		return nil
+	default:
+		return fmt.Errorf("you must agree to the server policy to host a session")
+	}
+}
+
+func displayStatsCallback(stats *api.SessionStats) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"=== Session Summary"})
+	table.SetHeaderLine(false)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetRowSeparator("")
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetNoWhiteSpace(true)
+	table.AppendBulk(statsRows(stats))
+
+	fmt.Println()
+	table.Render()
+}
+
+func displayQueuePosition(pos, length int) {
+	fmt.Printf("The server is at capacity, waiting in its queue at position %d of %d...\n", pos, length)
 }
 
 func clientJoinedCallback(c *api.Client) {
@@ -231,14 +1093,48 @@ func clientLeftCallback(c *api.Client) {
 }
 
 func notifyBody(c *api.Client) string {
-	return clientDesc(c.Addr, c.Version, c.PublicKeyFingerprint)
+	return clientDesc(c.Addr, c.Version, c.PublicKeyFingerprint, c.DisplayName)
 }
 
-func displaySessionCallback(session *api.GetSessionResponse) error {
+func displaySessionCallback(session *api.GetSessionResponse, joinTokens *host.JoinTokens, announcer ssh.Signer) error {
 	if err := displaySession(session); err != nil {
 		return err
 	}
 
+	if flagQR {
+		uri, expiresAt, err := joinURIWithToken(session, joinTokens, flagQRTTL, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := displayQRCode(os.Stdout, uri, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	if flagShareLink {
+		link, cmd, expiresAt, err := shareLink(session, joinTokens, flagShareLinkTTL, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := displayShareLink(os.Stdout, link, cmd, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	if announcer != nil {
+		if err := displayAnnouncement(os.Stdout, session, flagAnnounceLabel, announcer); err != nil {
+			return err
+		}
+	}
+
+	if flagListenOnly {
+		if dir, err := utils.UptermDir(); err == nil {
+			fmt.Printf("\nRun 'upterm session new --admin-socket %s' to spawn another session\n\n", filepath.Join(dir, host.AdminSocketFile(session.SessionId)))
+		}
+
+		return nil
+	}
+
 	if !flagAccept {
 		fmt.Printf("\nRun 'upterm session current' to display this screen again\n\n")
 
@@ -285,3 +1181,37 @@ func defaultPrivateKeys(homeDir string) []string {
 func defaultKnownHost(homeDir string) string {
 	return filepath.Join(homeDir, ".ssh", "known_hosts")
 }
+
+// maxSessionNameLength is the max length of session names in characters.
+const maxSessionNameLength = 100
+
+// validateSessionName checks a session name is short and has no control characters, which would inject escape
+// sequences into the terminals of clients.
+// parseWindowSize parses a window in the form of COLUMNSxROWS, e.g. 120x40. It returns zeros if s is empty.
+func parseWindowSize(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	cols, rows, ok := strings.Cut(s, "x")
+	w, werr := strconv.Atoi(cols)
+	h, herr := strconv.Atoi(rows)
+	if !ok || werr != nil || herr != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid window size %q, expect COLUMNSxROWS like 120x40", s)
+	}
+
+	return w, h, nil
+}
+
+func validateSessionName(name string) error {
+	if utf8.RuneCountInString(name) > maxSessionNameLength {
+		return fmt.Errorf("--name must be at most %d characters", maxSessionNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("--name must not contain control characters")
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,15 @@
 package command
 
 import (
+	"bufio"
+	"bytes"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/host/api"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 func Test_parseURL(t *testing.T) {
@@ -69,3 +75,223 @@ func Test_parseURL(t *testing.T) {
 	}
 
 }
+
+func Test_parseStartAt(t *testing.T) {
+	loc := time.FixedZone("test", -7*60*60)
+	now := time.Date(2024, 5, 21, 12, 30, 0, 0, loc)
+
+	cases := []struct {
+		name    string
+		startAt string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:    "later today",
+			startAt: "15:00",
+			want:    time.Date(2024, 5, 21, 15, 0, 0, 0, loc),
+		},
+		{
+			name:    "passed today",
+			startAt: "09:15:30",
+			want:    time.Date(2024, 5, 22, 9, 15, 30, 0, loc),
+		},
+		{
+			name:    "kitchen",
+			startAt: "3:04PM",
+			want:    time.Date(2024, 5, 21, 15, 4, 0, 0, loc),
+		},
+		{
+			name:    "rfc3339",
+			startAt: "2024-06-01T10:00:00Z",
+			want:    time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "rfc3339 passed",
+			startAt: "2024-05-21T12:00:00-07:00",
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			startAt: "tomorrow",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		cc := c
+		t.Run(cc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseStartAt(cc.startAt, now)
+			if cc.wantErr {
+				if err == nil {
+					t.Fatal("expect error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cc.want.Equal(got) {
+				t.Fatalf("want=%s got=%s", cc.want, got)
+			}
+		})
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	cases := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1024, want: "1.0 KiB"},
+		{n: 1536, want: "1.5 KiB"},
+		{n: 5 * 1024 * 1024, want: "5.0 MiB"},
+		{n: 3 * 1024 * 1024 * 1024, want: "3.0 GiB"},
+	}
+
+	for _, c := range cases {
+		if want, got := c.want, formatBytes(c.n); want != got {
+			t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+		}
+	}
+}
+
+func Test_agreePolicy(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		agreed  string
+		wantErr bool
+	}{
+		{name: "agreed with hash", agreed: "hash"},
+		{name: "outdated hash", agreed: "old", wantErr: true},
+		{name: "agreed interactively", in: "y\n"},
+		{name: "declined interactively", in: "n\n", wantErr: true},
+		{name: "no answer", in: "", wantErr: true},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := agreePolicy(bufio.NewReader(strings.NewReader(c.in)), &out, c.agreed, "No customer data.", "hash")
+			if (err != nil) != c.wantErr {
+				t.Fatalf("want error %t, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_parseMenu(t *testing.T) {
+	menu, err := parseMenu([]string{"logs=tail -f 'log/production.log'", " top = htop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*api.MenuItem{
+		{Name: "logs", Command: []string{"tail", "-f", "log/production.log"}},
+		{Name: "top", Command: []string{"htop"}},
+	}
+	if diff := cmp.Diff(want, menu, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, item := range []string{"logs", "=htop", "logs="} {
+		if _, err := parseMenu([]string{item}); err == nil {
+			t.Errorf("want error for %q", item)
+		}
+	}
+}
+
+func Test_validateSessionName(t *testing.T) {
+	for _, name := range []string{"", "debugging prod incident", "démo 🚀"} {
+		if err := validateSessionName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"evil\x1b]2;title\a", "two\nlines", strings.Repeat("a", maxSessionNameLength+1)} {
+		if err := validateSessionName(name); err == nil {
+			t.Errorf("want error for %q", name)
+		}
+	}
+}
+
+func Test_parseWindowSize(t *testing.T) {
+	if w, h, err := parseWindowSize("120x40"); err != nil || w != 120 || h != 40 {
+		t.Errorf("want 120x40, got %dx%d: %v", w, h, err)
+	}
+	if w, h, err := parseWindowSize(""); err != nil || w != 0 || h != 0 {
+		t.Errorf("want no window size, got %dx%d: %v", w, h, err)
+	}
+
+	for _, s := range []string{"120", "120x", "x40", "0x40", "-1x40", "120x40x2"} {
+		if _, _, err := parseWindowSize(s); err == nil {
+			t.Errorf("want error for %q", s)
+		}
+	}
+}
+
+func Test_newApprovalPolicy(t *testing.T) {
+	defer func() {
+		flagLabels, flagApprovalWebhook, flagApprovalCommand, flagApprovalSelector, flagApprovalTimeout = nil, "", "", nil, 0
+	}()
+
+	cases := []struct {
+		name         string
+		labels       []string
+		webhook      string
+		wantRequired bool
+		wantErr      bool
+	}{
+		{
+			name:   "unlabeled session",
+			labels: nil,
+		},
+		{
+			name:   "session not matching the selector",
+			labels: []string{"env=dev"},
+		},
+		{
+			name:    "session matching the selector without an approver",
+			labels:  []string{"env=prod"},
+			wantErr: true,
+		},
+		{
+			name:         "session matching the selector with an approver",
+			labels:       []string{"env=prod", "team=infra"},
+			webhook:      "https://change.example.com/approvals",
+			wantRequired: true,
+		},
+		{
+			name:    "invalid label",
+			labels:  []string{"prod"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			flagLabels, flagApprovalWebhook, flagApprovalSelector, flagApprovalTimeout = c.labels, c.webhook, []string{"env=prod"}, time.Minute
+
+			labels, policy, err := newApprovalPolicy()
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy.Required(labels); c.wantRequired != got {
+				t.Fatalf("want required=%t got=%t", c.wantRequired, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultServer = "ssh://uptermd.upterm.dev:22"
+)
+
+var (
+	flagInitForce bool
+)
+
+func initCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Set up upterm interactively",
+		Long: `Set up upterm interactively. This command locates or creates an SSH key, picks a server, writes the
+config file in ~/.config/upterm/config.yaml, verifies the connectivity to the server, and prints how to host and join a
+session. Values in the config file are the defaults of the flags of 'upterm host'. The config file is in
+$XDG_CONFIG_HOME/upterm if it's set, and ~/.upterm/config.yaml of earlier versions is kept if it exists.`,
+		Example: `  # Set up upterm:
+  upterm init
+
+  # Overwrite the existing config file:
+  upterm init --force`,
+		RunE: initRunE,
+	}
+
+	cmd.PersistentFlags().BoolVar(&flagInitForce, "force", false, "Overwrite the existing config file.")
+
+	return cmd
+}
+
+func initRunE(c *cobra.Command, args []string) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	file, err := configFile()
+	if err != nil {
+		return err
+	}
+
+	w := wizard{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		homeDir:  homeDir,
+		file:     file,
+		force:    flagInitForce,
+		checkFn:  checkServer,
+		keygenFn: generateKey,
+	}
+
+	return w.Run()
+}
+
+type wizard struct {
+	in       *bufio.Reader
+	out      io.Writer
+	homeDir  string
+	file     string
+	force    bool
+	checkFn  func(server string) (string, error)
+	keygenFn func(file string) error
+}
+
+func (w wizard) Run() error {
+	if _, err := os.Stat(w.file); err == nil && !w.force {
+		return fmt.Errorf("config file %s exists, rerun with --force to overwrite it", w.file)
+	}
+
+	fmt.Fprintf(w.out, "Welcome to upterm! This wizard writes %s.\n\n", w.file)
+
+	keys, err := w.setupKeys()
+	if err != nil {
+		return err
+	}
+
+	server, err := w.setupServer()
+	if err != nil {
+		return err
+	}
+
+	cfg := &config{
+		Server:      server,
+		PrivateKeys: keys,
+	}
+	if err := writeConfig(w.file, cfg); err != nil {
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	fmt.Fprintf(w.out, "\nWrote %s\n", w.file)
+
+	fmt.Fprintf(w.out, "Checking connectivity to %s... ", server)
+	version, err := w.checkFn(server)
+	if err != nil {
+		fmt.Fprintf(w.out, "failed: %s\n", err)
+		fmt.Fprintln(w.out, "Check your network or the server address, then rerun 'upterm init --force'.")
+	} else {
+		fmt.Fprintf(w.out, "ok (%s)\n", version)
+	}
+
+	fmt.Fprintf(w.out, `
+You're all set! Try it out:
+
+  1. Host a session:
+     upterm host
+
+  2. In the hosted session, display the join command:
+     upterm session current
+
+  3. Share the SSH command with your pair, or try it yourself in another terminal.
+`)
+
+	return nil
+}
+
+func (w wizard) setupKeys() ([]string, error) {
+	keys := defaultPrivateKeys(w.homeDir)
+	if len(keys) > 0 {
+		fmt.Fprintln(w.out, "Found SSH keys:")
+		for _, k := range keys {
+			fmt.Fprintf(w.out, "  %s\n", k)
+		}
+
+		return keys, nil
+	}
+
+	key := filepath.Join(w.homeDir, ".ssh", "id_ed25519")
+	ok, err := w.confirm(fmt.Sprintf("No SSH key is found. Create %s?", key), true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		fmt.Fprintln(w.out, "Skipped. upterm creates a temporary key for each session.")
+		return nil, nil
+	}
+
+	if err := w.keygenFn(key); err != nil {
+		return nil, fmt.Errorf("error creating SSH key: %w", err)
+	}
+	fmt.Fprintf(w.out, "Created %s\n", key)
+
+	return []string{key}, nil
+}
+
+func (w wizard) setupServer() (string, error) {
+	fmt.Fprintf(w.out, `
+Which server do you want to use?
+  1. The public server %s (default)
+  2. A self-hosted or other server
+`, defaultServer)
+
+	answer, err := w.ask("Choose [1-2]: ")
+	if err != nil {
+		return "", err
+	}
+
+	switch answer {
+	case "", "1":
+		return defaultServer, nil
+	case "2":
+		fmt.Fprintln(w.out, `
+To self-host, run uptermd on a machine reachable by your pairs, e.g.:
+  uptermd --ssh-addr 0.0.0.0:2222
+See https://github.com/owenthereal/upterm#deploy-uptermd for more deployment options.`)
+
+		for {
+			server, err := w.ask("Server address, e.g. ssh://HOST:22 or wss://HOST: ")
+			if err != nil {
+				return "", err
+			}
+
+			if err := validateServerURL(server); err != nil {
+				fmt.Fprintf(w.out, "Invalid server address: %s\n", err)
+				continue
+			}
+
+			return server, nil
+		}
+	default:
+		return "", fmt.Errorf("invalid choice %q", answer)
+	}
+}
+
+func (w wizard) confirm(question string, defaultYes bool) (bool, error) {
+	hint := "[Y/n]"
+	if !defaultYes {
+		hint = "[y/N]"
+	}
+
+	answer, err := w.ask(fmt.Sprintf("%s %s: ", question, hint))
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "":
+		return defaultYes, nil
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (w wizard) ask(prompt string) (string, error) {
+	fmt.Fprint(w.out, prompt)
+
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func validateServerURL(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return err
+		}
+	case "ws", "wss":
+		if u.Host == "" {
+			return fmt.Errorf("missing host")
+		}
+	default:
+		return fmt.Errorf("unsupported server protocol %q", u.Scheme)
+	}
+
+	return nil
+}
+
+// checkServer verifies the server is an upterm server. It returns the version of the server.
+func checkServer(server string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := host.ProbeServer(ctx, server)
+	return p.Version, p.Err
+}
+
+func generateKey(file string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "upterm")
+	if err != nil {
+		return err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(sshPub), 0644)
+}
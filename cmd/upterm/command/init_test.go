@@ -0,0 +1,91 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/upterm"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_wizard(t *testing.T) {
+	homeDir := t.TempDir()
+	file := filepath.Join(homeDir, ".upterm", configFileName)
+
+	var out bytes.Buffer
+	w := wizard{
+		// create a key, pick a self-hosted server after an invalid address
+		in:       bufio.NewReader(strings.NewReader("\n2\nhttp://foo\nssh://localhost:2222\n")),
+		out:      &out,
+		homeDir:  homeDir,
+		file:     file,
+		checkFn:  func(server string) (string, error) { return upterm.ServerSSHServerVersion, nil },
+		keygenFn: generateKey,
+	}
+	if err := w.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := filepath.Join(homeDir, ".ssh", "id_ed25519")
+	b, err := os.ReadFile(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh.ParsePrivateKey(b); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := readConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &config{
+		Server:      "ssh://localhost:2222",
+		PrivateKeys: []string{key},
+	}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatal(diff)
+	}
+	if !strings.Contains(out.String(), "Invalid server address") {
+		t.Fatalf("invalid server address should be reported:\n%s", out.String())
+	}
+
+	// existing config is not overwritten without force
+	w.in = bufio.NewReader(strings.NewReader("\n"))
+	if err := w.Run(); err == nil {
+		t.Fatal("expected error for existing config file")
+	}
+}
+
+func Test_checkServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(upterm.ServerSSHServerVersion + "\r\n"))
+			conn.Close()
+		}
+	}()
+
+	version, err := checkServer("ssh://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := upterm.ServerSSHServerVersion, version; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
@@ -0,0 +1,123 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+const profileHardened = "hardened"
+
+const (
+	hardenedClientIdleTimeout = 15 * time.Minute
+	hardenedMaxDuration       = 4 * time.Hour
+)
+
+var hostProfiles = []string{profileHardened}
+
+// applyHostProfile sets the flags of the profile that aren't set explicitly.
+func applyHostProfile(c *cobra.Command, profile string) error {
+	switch profile {
+	case "":
+		return nil
+	case profileHardened:
+	default:
+		return fmt.Errorf("unsupported profile %q, expect one of %s", profile, strings.Join(hostProfiles, ", "))
+	}
+
+	if !c.Flags().Changed("strict-crypto") {
+		flagStrictCrypto = true
+	}
+	if !c.Flags().Changed("read-only") {
+		flagReadOnly = true
+	}
+	if !c.Flags().Changed("sftp") {
+		flagSFTP = false
+	}
+	if !c.Flags().Changed("allow-exec") {
+		flagAllowExec = false
+	}
+	if !c.Flags().Changed("allow-port-forwarding") {
+		flagPortForwarding = false
+	}
+	if !c.Flags().Changed("client-idle-timeout") {
+		flagClientIdleTimeout = hardenedClientIdleTimeout
+	}
+	if !c.Flags().Changed("max-duration") {
+		flagMaxDuration = hardenedMaxDuration
+	}
+
+	return nil
+}
+
+// hasAuthorizedKeysFlags reports whether any flag authorizing clients is set.
+func hasAuthorizedKeysFlags() bool {
+	return flagAuthorizedKeys != "" ||
+		len(flagAuthorizedKeysURLs) > 0 ||
+		len(flagCertAuthorities) > 0 ||
+		len(flagCodebergUsers) > 0 ||
+		len(flagGitHubUsers) > 0 ||
+		len(flagGitLabUsers) > 0 ||
+		len(flagSourceHutUsers) > 0
+}
+
+// displayHostProfile writes the effective policy of the profile, marking the settings overridden by flags.
+func displayHostProfile(w io.Writer, c *cobra.Command, profile string, authorizedKeys int) {
+	if profile == "" {
+		return
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"=== Session Policy"})
+	table.SetHeaderLine(false)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetRowSeparator("")
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetNoWhiteSpace(true)
+	table.AppendBulk(hostProfileRows(c, profile, authorizedKeys))
+	table.Render()
+	fmt.Fprintln(w)
+}
+
+func hostProfileRows(c *cobra.Command, profile string, authorizedKeys int) [][]string {
+	setting := func(flag, value string) string {
+		if c.Flags().Changed(flag) {
+			return value + " (overridden)"
+		}
+		return value
+	}
+
+	return [][]string{
+		{"Profile:", profile},
+		{"Strict Crypto:", setting("strict-crypto", onOff(flagStrictCrypto))},
+		{"Authorized Keys:", fmt.Sprintf("required (%d)", authorizedKeys)},
+		{"Read-only:", setting("read-only", onOff(flagReadOnly))},
+		{"SFTP:", setting("sftp", onOff(flagSFTP))},
+		{"Exec:", setting("allow-exec", onOff(flagAllowExec))},
+		{"Port Forwarding:", setting("allow-port-forwarding", onOff(flagPortForwarding))},
+		{"Client Idle Timeout:", setting("client-idle-timeout", durationOrUnlimited(flagClientIdleTimeout))},
+		{"Max Duration:", setting("max-duration", durationOrUnlimited(flagMaxDuration))},
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func durationOrUnlimited(d time.Duration) string {
+	if d <= 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
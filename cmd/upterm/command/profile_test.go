@@ -0,0 +1,39 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_applyHostProfile(t *testing.T) {
+	c := hostCmd()
+	if err := c.ParseFlags([]string{"--profile", "hardened", "--max-duration", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyHostProfile(c, flagProfile); err != nil {
+		t.Fatal(err)
+	}
+
+	if !flagStrictCrypto || !flagReadOnly || flagSFTP || flagAllowExec || flagPortForwarding || flagClientIdleTimeout != hardenedClientIdleTimeout {
+		t.Fatalf("profile isn't applied: strict crypto %t, read-only %t, sftp %t, exec %t, port forwarding %t, client idle timeout %s", flagStrictCrypto, flagReadOnly, flagSFTP, flagAllowExec, flagPortForwarding, flagClientIdleTimeout)
+	}
+	if flagMaxDuration != time.Hour {
+		t.Fatalf("want max duration overridden to 1h, got %s", flagMaxDuration)
+	}
+
+	var rows []string
+	for _, row := range hostProfileRows(c, flagProfile, 2) {
+		rows = append(rows, strings.Join(row, " "))
+	}
+	for _, want := range []string{"Profile: hardened", "Authorized Keys: required (2)", "Read-only: on", "Max Duration: 1h0m0s (overridden)"} {
+		if !strings.Contains(strings.Join(rows, "\n"), want) {
+			t.Fatalf("policy %q doesn't contain %q", rows, want)
+		}
+	}
+
+	if err := applyHostProfile(c, "unknown"); err == nil {
+		t.Fatal("want error for unsupported profile")
+	}
+}
@@ -1,37 +1,79 @@
 package command
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/oklog/run"
+	"github.com/owenthereal/upterm/host"
 	uio "github.com/owenthereal/upterm/io"
 	"github.com/owenthereal/upterm/ws"
 	"github.com/spf13/cobra"
 )
 
+const (
+	proxyInitialBackoff = 500 * time.Millisecond
+	proxyMaxBackoff     = 10 * time.Second
+	proxyDialTimeout    = 30 * time.Second
+)
+
+var (
+	flagProxyStdio   bool
+	flagProxyVerbose bool
+	flagProxyRetries int
+)
+
 func proxyCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "proxy",
 		Short: "Proxy a terminal session via WebSocket",
-		Long:  "Proxy a terminal session via WebSocket, to be used alongside SSH ProxyCommand.",
+		Long: `Proxy a terminal session via WebSocket, to be used alongside SSH ProxyCommand. The connection is passed
+through stdin and stdout, and diagnostics are written to stderr. Failing connections are retried with a capped
+exponential backoff before giving up. Connections to ssh:// servers are proxied directly, falling back to the
+WebSocket endpoint of the server on port 443 if it's unreachable over SSH, e.g. from networks blocking ssh ports.
+
+To join sessions over WebSocket with a plain 'ssh TOKEN@uptermd.upterm.dev', add a Match block to ~/.ssh/config:
+
+  Match host uptermd.upterm.dev
+    ProxyCommand upterm proxy --stdio wss://%r@%h`,
 		Example: `  # Host shares a session running $SHELL over WebSocket:
   upterm host --server wss://uptermd.upterm.dev -- YOUR_COMMAND
 
   # Client connects to the host session via WebSocket:
-  ssh -o ProxyCommand='upterm proxy wss://TOKEN@uptermd.upterm.dev' TOKEN:uptermd.uptermd.dev:443`,
+  ssh -o ProxyCommand='upterm proxy wss://TOKEN@uptermd.upterm.dev' TOKEN@uptermd.upterm.dev
+
+  # Client connects to the host session via SSH, falling back to WebSocket if the ssh port is blocked:
+  ssh -o ProxyCommand='upterm proxy ssh://%r@%h:%p' TOKEN@uptermd.upterm.dev
+
+  # Diagnose a slow or failing connection by printing the timing of each hop without proxying:
+  upterm proxy -v --stdio=false wss://TOKEN@uptermd.upterm.dev`,
 		RunE: proxyRunE,
+		// stdout is the proxied connection
+		SilenceUsage: true,
 	}
 
+	cmd.PersistentFlags().BoolVar(&flagProxyStdio, "stdio", true, "Pass the connection through stdin and stdout, for SSH ProxyCommand. Set it to false to only check the connection.")
+	cmd.PersistentFlags().BoolVarP(&flagProxyVerbose, "verbose", "v", false, "Print the timing of each hop of the connection to stderr.")
+	cmd.PersistentFlags().IntVar(&flagProxyRetries, "retries", 3, "Retry connecting the specified times before giving up.")
+
 	return cmd
 }
 
 func proxyRunE(c *cobra.Command, args []string) error {
 	if len(args) == 0 {
-		return fmt.Errorf("missing WebSocket url")
+		return fmt.Errorf("missing WebSocket or ssh url")
 	}
 
 	u, err := url.Parse(args[0])
@@ -39,10 +81,27 @@ func proxyRunE(c *cobra.Command, args []string) error {
 		return err
 	}
 
-	conn, err := ws.NewWSConn(u, true)
+	p := proxyDialer{
+		Retries: flagProxyRetries,
+		Stderr:  os.Stderr,
+		Verbose: flagProxyVerbose,
+	}
+	var conn net.Conn
+	if u.Scheme == "ssh" {
+		conn, err = p.DialSSH(context.Background(), u)
+	} else {
+		conn, err = p.Dial(context.Background(), u)
+	}
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	if !flagProxyStdio {
+		fmt.Fprintf(os.Stderr, "upterm proxy: connected to %s\n", u.Redacted())
+		return nil
+	}
+	recordRecentSession(u)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -69,3 +128,181 @@ func proxyRunE(c *cobra.Command, args []string) error {
 
 	return g.Run()
 }
+
+// proxyDialer dials the WebSocket endpoint of a server, retrying failures with a capped exponential backoff.
+// Rejections of the server, e.g. a malformed token, are not retried.
+type proxyDialer struct {
+	Retries int
+	Stderr  io.Writer
+	Verbose bool
+
+	// sleep defaults to time.Sleep.
+	sleep func(time.Duration)
+	// webSocketFallback defaults to host.WebSocketFallback.
+	webSocketFallback func(context.Context, *url.URL) *url.URL
+}
+
+// DialSSH connects to the ssh server directly, falling back to its WebSocket endpoint if it's unreachable over SSH,
+// e.g. from networks blocking ssh ports, and the server advertises one.
+func (p proxyDialer) DialSSH(ctx context.Context, u *url.URL) (net.Conn, error) {
+	conn, err := dialSSH(ctx, u.Host)
+	if err == nil {
+		return conn, nil
+	}
+
+	fallback := p.webSocketFallback
+	if fallback == nil {
+		fallback = host.WebSocketFallback
+	}
+	ws := fallback(ctx, u)
+	if ws == nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", u.Redacted(), err)
+	}
+
+	fmt.Fprintf(p.Stderr, "upterm proxy: %s is unreachable over ssh: %s, falling back to %s\n", u.Redacted(), err, ws.Redacted())
+	return p.Dial(ctx, ws)
+}
+
+// dialSSH connects to the ssh server at addr and reads its identification, so that connections reset by
+// firewalls inspecting the protocol fail here rather than once they are proxied. The identification is replayed to
+// the reader of the connection.
+func dialSSH(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetReadDeadline(deadline)
+	r := bufio.NewReader(conn)
+	version, err := r.Peek(len("SSH-"))
+	if err == nil && string(version) != "SSH-" {
+		err = fmt.Errorf("not an ssh server: %q", version)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	return bufferedConn{Conn: conn, r: r}, nil
+}
+
+// bufferedConn reads a connection through a buffer holding data read ahead of it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (p proxyDialer) Dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	backoff := proxyInitialBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := p.dial(ctx, u)
+		if err == nil {
+			return conn, nil
+		}
+
+		var perr permanentError
+		if errors.As(err, &perr) || attempt >= p.Retries {
+			return nil, err
+		}
+
+		fmt.Fprintf(p.Stderr, "upterm proxy: %s, retrying in %s (%d/%d)\n", err, backoff, attempt+1, p.Retries)
+		sleep(backoff)
+		backoff = min(backoff*2, proxyMaxBackoff)
+	}
+}
+
+// permanentError is a failure retrying doesn't fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+func (p proxyDialer) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	defer cancel()
+
+	var h hopTimings
+	h.start = time.Now()
+
+	d := *websocket.DefaultDialer
+	d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		h.add("dns", time.Since(start))
+
+		start = time.Now()
+		var nd net.Dialer
+		conn, err := nd.DialContext(ctx, network, net.JoinHostPort(ips[0], port))
+		if err != nil {
+			return nil, err
+		}
+		h.add("connect "+conn.RemoteAddr().String(), time.Since(start))
+
+		return conn, nil
+	}
+
+	var tlsStart, upgradeStart time.Time
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			h.add("tls", time.Since(tlsStart))
+		},
+		WroteHeaders:         func() { upgradeStart = time.Now() },
+		GotFirstResponseByte: func() { h.add("upgrade", time.Since(upgradeStart)) },
+	})
+
+	conn, resp, err := ws.DialWSConn(ctx, &d, u, true)
+	if p.Verbose {
+		fmt.Fprintf(p.Stderr, "upterm proxy: %s\n", h.String())
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return nil, permanentError{fmt.Errorf("server rejected the connection: %s: %s", resp.Status, strings.TrimSpace(string(b)))}
+		}
+
+		return nil, fmt.Errorf("error connecting to %s: %w", u.Redacted(), err)
+	}
+
+	return conn, nil
+}
+
+// hopTimings are the durations of the steps of establishing a connection.
+type hopTimings struct {
+	start time.Time
+	hops  []string
+}
+
+func (h *hopTimings) add(hop string, d time.Duration) {
+	h.hops = append(h.hops, fmt.Sprintf("%s %s", hop, d.Round(time.Millisecond)))
+}
+
+func (h *hopTimings) String() string {
+	return strings.Join(append(h.hops, fmt.Sprintf("total %s", time.Since(h.start).Round(time.Millisecond))), ", ")
+}
@@ -0,0 +1,208 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+)
+
+func Test_proxyDialer(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(strings.Replace(srv.URL, "http", "ws", 1))
+	u.User = url.UserPassword("session", "node")
+
+	var (
+		stderr   bytes.Buffer
+		backoffs []time.Duration
+	)
+	p := proxyDialer{
+		Retries: 3,
+		Stderr:  &stderr,
+		Verbose: true,
+		sleep:   func(d time.Duration) { backoffs = append(backoffs, d) },
+	}
+
+	conn, err := p.Dial(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if diff := cmp.Diff([]time.Duration{500 * time.Millisecond, time.Second}, backoffs); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := strings.Count(stderr.String(), "total "); got != 3 {
+		t.Fatalf("want timings of 3 attempts, got %d: %s", got, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "dns ") || !strings.Contains(stderr.String(), "connect ") {
+		t.Fatalf("missing hop timings: %s", stderr.String())
+	}
+}
+
+func Test_proxyDialer_rejected(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "invalid session", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(strings.Replace(srv.URL, "http", "ws", 1))
+
+	var stderr bytes.Buffer
+	p := proxyDialer{
+		Retries: 3,
+		Stderr:  &stderr,
+		sleep:   func(time.Duration) { t.Fatal("rejected connection shouldn't be retried") },
+	}
+
+	_, err := p.Dial(context.Background(), u)
+	var perr permanentError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want permanent error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid session") {
+		t.Fatalf("want the reason of the rejection, got %v", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("want 1 attempt, got %d", got)
+	}
+}
+
+func Test_proxyDialer_backoffCapped(t *testing.T) {
+	var backoffs []time.Duration
+	p := proxyDialer{
+		Retries: 7,
+		Stderr:  &bytes.Buffer{},
+		sleep:   func(d time.Duration) { backoffs = append(backoffs, d) },
+	}
+
+	u, _ := url.Parse("ws://127.0.0.1:1")
+	if _, err := p.Dial(context.Background(), u); err == nil {
+		t.Fatal("want error")
+	}
+
+	want := []time.Duration{
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	if diff := cmp.Diff(want, backoffs); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_dialSSH(t *testing.T) {
+	serve := func(banner string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.WriteString(conn, banner)
+		}()
+
+		return ln.Addr().String()
+	}
+
+	conn, err := dialSSH(context.Background(), serve("SSH-2.0-uptermd\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the identification is replayed
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "SSH-2.0-uptermd\r\n", string(b); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	if _, err := dialSSH(context.Background(), serve("HTTP/1.1 400 Bad Request\r\n")); err == nil {
+		t.Fatal("want an error for a server not speaking ssh")
+	}
+}
+
+func Test_proxyDialer_DialSSH(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &url.URL{Scheme: "ssh", User: url.User("session"), Host: ln.Addr().String()}
+	ln.Close()
+
+	var stderr bytes.Buffer
+	p := proxyDialer{
+		Stderr: &stderr,
+		webSocketFallback: func(ctx context.Context, u *url.URL) *url.URL {
+			ws, _ := url.Parse(strings.Replace(srv.URL, "http", "ws", 1))
+			ws.User = u.User
+			return ws
+		},
+	}
+
+	conn, err := p.DialSSH(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if !strings.Contains(stderr.String(), "falling back to ws://session@") {
+		t.Fatalf("want the downgrade logged, got %q", stderr.String())
+	}
+
+	p.webSocketFallback = func(context.Context, *url.URL) *url.URL { return nil }
+	if _, err := p.DialSSH(context.Background(), u); err == nil {
+		t.Fatal("want an error without a WebSocket endpoint to fall back to")
+	}
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+	"github.com/skip2/go-qrcode"
+)
+
+// joinURIWithToken returns an ssh:// URI to join the session with a new one-time token expiring after ttl.
+// The username is encoded compactly, so that the QR code of the URI stays small enough to scan from a terminal.
+func joinURIWithToken(session *api.GetSessionResponse, tokens *host.JoinTokens, ttl time.Duration, now time.Time) (string, time.Time, error) {
+	ed, err := routing.NewEncodeDecoder(routing.V2)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	user, err := ed.Encode(session.SessionId, session.NodeAddr)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, expiresAt, err := tokens.Issue(ttl, now)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error issuing join token: %w", err)
+	}
+
+	uri, err := routing.JoinURI(session.Host, routing.WithToken(user, token))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return uri, expiresAt, nil
+}
+
+// displayQRCode renders the QR code of the join URI in the terminal, for mobile SSH clients to scan.
+func displayQRCode(w io.Writer, uri string, expiresAt time.Time) error {
+	qr, err := qrcode.New(uri, qrcode.Low)
+	if err != nil {
+		return fmt.Errorf("error generating QR code: %w", err)
+	}
+
+	fmt.Fprintf(w, "\nScan to join with a mobile SSH client, e.g. Termius or Blink. The code can be used once until %s:\n\n", expiresAt.Local().Format(time.Kitchen))
+	fmt.Fprint(w, qr.ToSmallString(false))
+	fmt.Fprintf(w, "%s\n", uri)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+)
+
+func Test_joinURIWithToken(t *testing.T) {
+	now := time.Now()
+	session := &api.GetSessionResponse{
+		SessionId: "10OLFAKZu4cxx2roOboaY",
+		NodeAddr:  "127.0.0.1:2222",
+		Host:      "ssh://uptermd.upterm.dev:22",
+	}
+	tokens := host.NewJoinTokens()
+
+	uri, expiresAt, err := joinURIWithToken(session, tokens, 10*time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(10 * time.Minute); !expiresAt.Equal(want) {
+		t.Fatalf("want expiry %s, got %s", want, expiresAt)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "ssh" || u.Host != "uptermd.upterm.dev" {
+		t.Fatalf("unexpected join uri %s", uri)
+	}
+
+	user, token := routing.SplitToken(u.User.Username())
+	sessionID, nodeAddr, err := routing.Decode(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID != session.SessionId || nodeAddr != session.NodeAddr {
+		t.Fatalf("want %s,%s, got %s,%s", session.SessionId, session.NodeAddr, sessionID, nodeAddr)
+	}
+	if !tokens.Redeem(token, now) {
+		t.Fatalf("token %q of the join uri isn't redeemable", token)
+	}
+
+	var out bytes.Buffer
+	if err := displayQRCode(&out, uri, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), uri) {
+		t.Fatalf("want the uri displayed with the QR code, got %s", out.String())
+	}
+}
@@ -0,0 +1,620 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/gorilla/websocket"
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/routing"
+	"github.com/owenthereal/upterm/server"
+	"github.com/owenthereal/upterm/utils"
+	"github.com/owenthereal/upterm/ws"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// recentFile is the history of joined sessions in the upterm dir.
+	recentFile = "recent.json"
+	// recentMax is the number of sessions kept in the history.
+	recentMax = 50
+)
+
+type sessionStatus string
+
+const (
+	sessionActive  sessionStatus = "active"
+	sessionEnded   sessionStatus = "ended"
+	sessionUnknown sessionStatus = "unknown"
+)
+
+var (
+	flagRecentLabel   string
+	flagRecentTimeout time.Duration
+	flagRecentOffline bool
+)
+
+func recentCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recent",
+		Short: "List recently joined sessions",
+		Long: `List recently joined sessions, newest first, with whether they're still active. Sessions joined with
+'upterm proxy' or 'upterm recent join' are remembered in ~/.upterm/recent.json, and sessions joined with plain ssh
+can be bookmarked with 'upterm recent add'. Sessions are checked by the server without authenticating, so hosts
+aren't notified. A session hosted on another node of a cluster is reported active until joining it fails.`,
+		Example: `  # List recent sessions:
+  upterm recent
+
+  # Bookmark a session joined with plain ssh:
+  upterm recent add --label "pairing with alice" 'ssh TOKEN@uptermd.upterm.dev'
+
+  # Join the most recent session again:
+  upterm recent join 1`,
+		RunE: recentRunE,
+		// ended sessions are reported in the results
+		SilenceUsage: true,
+	}
+
+	cmd.PersistentFlags().DurationVar(&flagRecentTimeout, "timeout", 5*time.Second, "Specify the timeout of checking each session.")
+	cmd.Flags().BoolVar(&flagRecentOffline, "offline", false, "List the sessions without checking whether they're active.")
+	cmd.AddCommand(recentAddCmd())
+	cmd.AddCommand(recentJoinCmd())
+	cmd.AddCommand(recentRemoveCmd())
+
+	return cmd
+}
+
+func recentAddCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add JOIN_COMMAND",
+		Short: "Bookmark a session",
+		Long:  `Bookmark a session by its join command, e.g. 'ssh TOKEN@uptermd.upterm.dev -p 2222', or its ssh:// URI.`,
+		Example: `  # Bookmark a session with a label:
+  upterm recent add --label "weekly support" 'ssh TOKEN@uptermd.upterm.dev'`,
+		Args: cobra.ExactArgs(1),
+		RunE: recentAddRunE,
+	}
+
+	cmd.Flags().StringVar(&flagRecentLabel, "label", "", "Describe the session, e.g. \"pairing with alice\".")
+
+	return cmd
+}
+
+func recentJoinCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "join NUMBER|SESSION_ID",
+		Short: "Join a recent session again",
+		Long:  `Join a recent session again with ssh if it's still active. Sessions are numbered as listed by 'upterm recent'.`,
+		Example: `  # Join the most recent session again:
+  upterm recent join 1`,
+		Args: cobra.ExactArgs(1),
+		RunE: recentJoinRunE,
+		// the session may have ended, or ssh fails
+		SilenceUsage: true,
+	}
+
+	return cmd
+}
+
+func recentRemoveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "remove NUMBER|SESSION_ID",
+		Aliases: []string{"rm"},
+		Short:   "Forget a recent session",
+		Args:    cobra.ExactArgs(1),
+		RunE:    recentRemoveRunE,
+	}
+
+	return cmd
+}
+
+func recentRunE(c *cobra.Command, args []string) error {
+	store, err := newRecentStore()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No recent session is found. Join one with 'upterm proxy' or bookmark one with 'upterm recent add'.")
+		return nil
+	}
+
+	var checks []sessionCheck
+	if !flagRecentOffline {
+		checks = checkSessions(context.Background(), sessions, flagRecentTimeout)
+
+		// remember host keys seen for the first time
+		var changed bool
+		for i, check := range checks {
+			if check.Fingerprint != "" && sessions[i].HostKeyFingerprint == "" {
+				sessions[i].HostKeyFingerprint = check.Fingerprint
+				changed = true
+			}
+		}
+		if changed {
+			if err := store.Save(sessions); err != nil {
+				return err
+			}
+		}
+	}
+
+	displayRecentSessions(os.Stdout, sessions, checks, time.Now())
+
+	return nil
+}
+
+func recentAddRunE(c *cobra.Command, args []string) error {
+	server, user, err := parseJoinCommand(args[0])
+	if err != nil {
+		return err
+	}
+
+	store, err := newRecentStore()
+	if err != nil {
+		return err
+	}
+
+	return store.Add(recentSession{
+		Server:   server,
+		User:     user,
+		Label:    flagRecentLabel,
+		JoinedAt: time.Now(),
+	})
+}
+
+func recentJoinRunE(c *cobra.Command, args []string) error {
+	store, err := newRecentStore()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	i, err := findRecentSession(sessions, args[0])
+	if err != nil {
+		return err
+	}
+	sess := sessions[i]
+
+	ctx, cancel := context.WithTimeout(context.Background(), flagRecentTimeout)
+	defer cancel()
+
+	check := checkSession(ctx, sess.Server, sess.User)
+	if check.Status == sessionEnded {
+		return fmt.Errorf("session %s has ended: %w", sess.SessionID(), check.Err)
+	}
+	if sess.HostKeyFingerprint != "" && check.Fingerprint != "" && sess.HostKeyFingerprint != check.Fingerprint {
+		return fmt.Errorf("host key of %s changed from %s to %s, forget the session with 'upterm recent rm' if it's expected", sess.Server, sess.HostKeyFingerprint, check.Fingerprint)
+	}
+
+	joinCmd, err := routing.JoinCommand(sess.Server, sess.User)
+	if err != nil {
+		return err
+	}
+	joinArgs, err := shlex.Split(joinCmd)
+	if err != nil {
+		return err
+	}
+
+	sess.JoinedAt = time.Now()
+	if sess.HostKeyFingerprint == "" {
+		sess.HostKeyFingerprint = check.Fingerprint
+	}
+	if err := store.Add(sess); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(joinArgs[0], joinArgs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func recentRemoveRunE(c *cobra.Command, args []string) error {
+	store, err := newRecentStore()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	i, err := findRecentSession(sessions, args[0])
+	if err != nil {
+		return err
+	}
+
+	return store.Save(append(sessions[:i], sessions[i+1:]...))
+}
+
+// recentSession is a session the client joined or bookmarked.
+type recentSession struct {
+	// Server is the URL of the server the session is joined on, e.g. ssh://uptermd.upterm.dev:22.
+	Server string `json:"server"`
+	// User is the username joining the session, which identifies it.
+	User  string `json:"user"`
+	Label string `json:"label,omitempty"`
+	// HostKeyFingerprint is the SHA256 fingerprint of the host key of the server when the session was first checked.
+	HostKeyFingerprint string    `json:"host_key_fingerprint,omitempty"`
+	JoinedAt           time.Time `json:"joined_at"`
+}
+
+// SessionID returns the id of the session, or the user if it can't be decoded.
+func (s recentSession) SessionID() string {
+	user, _ := routing.SplitToken(s.User)
+	id, _, err := routing.Decode(user)
+	if err != nil {
+		return s.User
+	}
+
+	return id
+}
+
+func (s recentSession) same(o recentSession) bool {
+	return s.Server == o.Server && s.User == o.User
+}
+
+// recentStore keeps the history of joined sessions, newest first.
+type recentStore struct {
+	file string
+}
+
+func newRecentStore() (*recentStore, error) {
+	dir, err := utils.CreateUptermDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recentStore{file: filepath.Join(dir, recentFile)}, nil
+}
+
+func (s *recentStore) Load() ([]recentSession, error) {
+	b, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []recentSession
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		return nil, fmt.Errorf("error parsing recent sessions %s: %w", s.file, err)
+	}
+
+	return sessions, nil
+}
+
+func (s *recentStore) Save(sessions []recentSession) error {
+	b, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename to avoid leaving a partial history behind
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.file)
+}
+
+// Add adds the session to the top of the history. A session joined before is moved to the top,
+// keeping its label and host key unless they're set.
+func (s *recentStore) Add(sess recentSession) error {
+	sessions, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	result := []recentSession{sess}
+	for _, prev := range sessions {
+		if !prev.same(sess) {
+			result = append(result, prev)
+			continue
+		}
+
+		if sess.Label == "" {
+			result[0].Label = prev.Label
+		}
+		if sess.HostKeyFingerprint == "" {
+			result[0].HostKeyFingerprint = prev.HostKeyFingerprint
+		}
+	}
+	if len(result) > recentMax {
+		result = result[:recentMax]
+	}
+
+	return s.Save(result)
+}
+
+// findRecentSession finds a session by its number in the list starting from 1, or by its id.
+func findRecentSession(sessions []recentSession, arg string) (int, error) {
+	if n, err := strconv.Atoi(arg); err == nil {
+		if n < 1 || n > len(sessions) {
+			return 0, fmt.Errorf("no recent session numbered %d, there are %d", n, len(sessions))
+		}
+
+		return n - 1, nil
+	}
+
+	for i, s := range sessions {
+		if s.SessionID() == arg {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no recent session %s is found", arg)
+}
+
+// parseJoinCommand parses the server and user of a join command, e.g. 'ssh TOKEN@uptermd.upterm.dev -p 2222',
+// or of a URI like ssh://TOKEN@uptermd.upterm.dev:2222. The server of WebSocket sessions is parsed from the
+// 'upterm proxy' ProxyCommand.
+func parseJoinCommand(cmd string) (string, string, error) {
+	if server, user, ok := parseJoinURI(cmd); ok {
+		return server, user, nil
+	}
+
+	args, err := shlex.Split(cmd)
+	if err != nil {
+		return "", "", err
+	}
+	if len(args) > 0 && args[0] == "ssh" {
+		args = args[1:]
+	}
+
+	var dest, port string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-p" && i+1 < len(args):
+			port = args[i+1]
+			i++
+		case args[i] == "-o" && i+1 < len(args):
+			if proxyCmd, ok := strings.CutPrefix(args[i+1], "ProxyCommand="); ok {
+				proxyArgs, _ := shlex.Split(proxyCmd)
+				for _, arg := range proxyArgs {
+					if server, user, ok := parseJoinURI(arg); ok {
+						return server, user, nil
+					}
+				}
+			}
+			i++
+		case !strings.HasPrefix(args[i], "-") && dest == "":
+			dest = args[i]
+		}
+	}
+
+	at := strings.LastIndex(dest, "@")
+	if at <= 0 || at == len(dest)-1 {
+		return "", "", fmt.Errorf("no USER@HOST in join command %q", cmd)
+	}
+	user, host := dest[:at], dest[at+1:]
+
+	if port == "" {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return "ssh://" + host, user, nil
+		}
+		port = "22"
+	}
+
+	return "ssh://" + net.JoinHostPort(host, port), user, nil
+}
+
+func parseJoinURI(uri string) (string, string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil || u.Host == "" {
+		return "", "", false
+	}
+
+	switch u.Scheme {
+	case "ssh", "ws", "wss":
+		return fmt.Sprintf("%s://%s", u.Scheme, u.Host), userOfURL(u), true
+	default:
+		return "", "", false
+	}
+}
+
+// userOfURL returns the username of u. The username of WebSocket URLs is split into the session and the node
+// at ':', so the password is the rest of the username.
+func userOfURL(u *url.URL) string {
+	user := u.User.Username()
+	if pass, ok := u.User.Password(); ok {
+		user += ":" + pass
+	}
+
+	return user
+}
+
+// sessionCheck is the result of checking whether a session is active.
+type sessionCheck struct {
+	Status sessionStatus
+	// Fingerprint is the SHA256 fingerprint of the host key of the server.
+	Fingerprint string
+	Err         error
+}
+
+func checkSessions(ctx context.Context, sessions []recentSession, timeout time.Duration) []sessionCheck {
+	checks := make([]sessionCheck, len(sessions))
+
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s recentSession) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			checks[i] = checkSession(ctx, s.Server, s.User)
+		}(i, s)
+	}
+	wg.Wait()
+
+	return checks
+}
+
+// checkSession asks the server whether the session is active without authenticating, so that the host isn't
+// notified. The server rejects clients of sessions that don't exist before they authenticate.
+func checkSession(ctx context.Context, serverURL, user string) sessionCheck {
+	var (
+		check     = sessionCheck{Status: sessionUnknown}
+		rejection *server.Rejection
+		mu        sync.Mutex
+	)
+
+	config := &ssh.ClientConfig{
+		User: user,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			check.Fingerprint = utils.FingerprintSHA256(key)
+			return nil
+		},
+		BannerCallback: func(msg string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			rejection, _ = server.ParseRejection(msg)
+			return nil
+		},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		config.Timeout = time.Until(deadline)
+	}
+
+	conn, err := dialSessionCheck(ctx, serverURL, config)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	if err == nil {
+		// the server doesn't authenticate clients without keys, but close the connection anyway
+		_ = ssh.NewClient(c, chans, reqs).Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch {
+	case rejection != nil && (rejection.Code == server.RejectionSessionNotFound || rejection.Code == server.RejectionNodeUnavailable || rejection.Code == server.RejectionInvalidSession):
+		check.Status = sessionEnded
+		check.Err = rejection
+	case rejection != nil:
+		check.Err = rejection
+	case check.Fingerprint != "":
+		// the server knows the session, or it's hosted on another node
+		check.Status = sessionActive
+	default:
+		check.Err = err
+	}
+
+	return check
+}
+
+func dialSessionCheck(ctx context.Context, serverURL string, config *ssh.ClientConfig) (net.Conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", u.Host)
+	case "ws", "wss":
+		// the username of WebSocket sessions is passed as basic auth, split into the session and the node
+		wu := *u
+		sessionID, encodedNodeAddr, _ := strings.Cut(config.User, ":")
+		wu.User = url.UserPassword(sessionID, encodedNodeAddr)
+		conn, _, err := ws.DialWSConn(ctx, websocket.DefaultDialer, &wu, true)
+		return conn, err
+	default:
+		return nil, fmt.Errorf("unsupported server protocol %q", u.Scheme)
+	}
+}
+
+func displayRecentSessions(w io.Writer, sessions []recentSession, checks []sessionCheck, now time.Time) {
+	header := []string{"#", "Session", "Label", "Server", "Host Key", "Joined"}
+	if checks != nil {
+		header = append(header, "Status")
+	}
+
+	var rows [][]string
+	for i, s := range sessions {
+		row := []string{
+			strconv.Itoa(i + 1),
+			s.SessionID(),
+			naIfEmpty(s.Label),
+			s.Server,
+			naIfEmpty(s.HostKeyFingerprint),
+			fmt.Sprintf("%s ago", now.Sub(s.JoinedAt).Round(time.Minute)),
+		}
+		if checks != nil {
+			row = append(row, string(checks[i].Status))
+		}
+		rows = append(rows, row)
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("|")
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+// recordRecentSession remembers the session joined via the WebSocket URL. Errors are ignored since
+// the history is a convenience, and mustn't fail joining.
+func recordRecentSession(u *url.URL) {
+	if u.User == nil {
+		return
+	}
+
+	store, err := newRecentStore()
+	if err != nil {
+		return
+	}
+
+	_ = store.Add(recentSession{
+		Server:   fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		User:     userOfURL(u),
+		JoinedAt: time.Now(),
+	})
+}
@@ -24,11 +24,19 @@ func Root() *cobra.Command {
   $ ssh TOKEN@uptermd.upterm.dev`,
 	}
 
+	rootCmd.AddCommand(attachCmd())
+	rootCmd.AddCommand(initCmd())
+	rootCmd.AddCommand(benchCmd())
 	rootCmd.AddCommand(hostCmd())
 	rootCmd.AddCommand(proxyCmd())
+	rootCmd.AddCommand(recentCmd())
+	rootCmd.AddCommand(serversCmd())
 	rootCmd.AddCommand(sessionCmd())
+	rootCmd.AddCommand(streamCmd())
 	rootCmd.AddCommand(upgradeCmd())
+	rootCmd.AddCommand(verifyCmd())
 	rootCmd.AddCommand(versionCmd())
+	rootCmd.AddCommand(whoAmICmd())
 
 	return rootCmd
 }
@@ -0,0 +1,159 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/host"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagServersFile    string
+	flagServersTimeout time.Duration
+)
+
+func serversCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "servers",
+		Short: "List and test public upterm servers",
+		Long: `List and test public upterm servers to pick one for 'upterm host --server'. The curated list of public
+servers is used unless --file specifies a list of servers, one per line.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&flagServersFile, "file", "", "Read the servers from the specified file instead of the curated list, one per line. Lines starting with # are ignored. Use - to read from stdin.")
+	cmd.AddCommand(serversListCmd())
+	cmd.AddCommand(serversTestCmd())
+
+	return cmd
+}
+
+func serversListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List upterm servers",
+		Example: `  # List the curated public servers:
+  upterm servers list
+
+  # List the servers in a file:
+  upterm servers list --file servers.txt`,
+		RunE: serversListRunE,
+	}
+
+	return cmd
+}
+
+func serversTestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test [SERVER...]",
+		Short: "Probe the latency and version of upterm servers",
+		Long: `Probe the latency and version of upterm servers concurrently. Servers are sorted by latency and
+unreachable servers are listed last. Servers specified as arguments are probed instead of the list.`,
+		Example: `  # Test the curated public servers:
+  upterm servers test
+
+  # Test a self-hosted server:
+  upterm servers test ssh://uptermd.example.com:22`,
+		RunE: serversTestRunE,
+		// unreachable servers are reported in the results
+		SilenceUsage: true,
+	}
+
+	cmd.PersistentFlags().DurationVar(&flagServersTimeout, "timeout", 5*time.Second, "Specify the timeout of probing each server.")
+
+	return cmd
+}
+
+func serversListRunE(c *cobra.Command, args []string) error {
+	servers, err := readServers()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range servers {
+		fmt.Println(s)
+	}
+
+	return nil
+}
+
+func serversTestRunE(c *cobra.Command, args []string) error {
+	servers := args
+	if len(servers) == 0 {
+		var err error
+		if servers, err = readServers(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range servers {
+		if err := validateServerURL(s); err != nil {
+			return fmt.Errorf("invalid server %s: %w", s, err)
+		}
+	}
+
+	probes := host.ProbeServers(context.Background(), servers, flagServersTimeout)
+	displayServerProbes(os.Stdout, probes)
+
+	if probes[0].Err != nil {
+		return host.ErrNoServerReachable
+	}
+
+	return nil
+}
+
+// readServers reads the servers from --file, or returns the curated list if it's not set.
+func readServers() ([]string, error) {
+	if flagServersFile == "" {
+		return host.PublicServers, nil
+	}
+
+	r := os.Stdin
+	if flagServersFile != "-" {
+		f, err := os.Open(flagServersFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	servers, err := host.ReadServerList(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading servers: %w", err)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no server is listed in %s", flagServersFile)
+	}
+
+	return servers, nil
+}
+
+func displayServerProbes(w io.Writer, probes []host.ServerProbe) {
+	var rows [][]string
+	for _, p := range probes {
+		if p.Err != nil {
+			rows = append(rows, []string{p.Server, "-", "-", fmt.Sprintf("unreachable: %s", p.Err)})
+			continue
+		}
+		rows = append(rows, []string{p.Server, p.Latency.Round(time.Millisecond).String(), p.Version, "ok"})
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Server", "Latency", "Version", "Status"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(rows)
+	table.Render()
+
+	if len(probes) > 0 && probes[0].Err == nil {
+		fmt.Fprintf(w, "\nThe fastest server is %s. Host a session on it with:\n  upterm host --server %s\n", probes[0].Server, probes[0].Server)
+	}
+}
@@ -2,23 +2,38 @@ package command
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/owenthereal/upterm/host"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/routing"
 	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
+// clientIDDisplayLength is how many characters of client IDs are displayed.
+const clientIDDisplayLength = 8
+
 var (
-	flagAdminSocket string
+	flagAdminSocket        string
+	flagReshare            bool
+	flagShowAuthorizedKeys bool
+	flagSetReadOnly        bool
+	flagListJSON           bool
+	flagFingerprintFormat  string
 )
 
 func sessionCmd() *cobra.Command {
@@ -30,6 +45,120 @@ func sessionCmd() *cobra.Command {
 	cmd.AddCommand(current())
 	cmd.AddCommand(list())
 	cmd.AddCommand(show())
+	cmd.AddCommand(recoverSession())
+	cmd.AddCommand(set())
+	cmd.AddCommand(kick())
+	cmd.AddCommand(newSession())
+	cmd.AddCommand(fingerprint())
+
+	return cmd
+}
+
+func recoverSession() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "recover",
+		Aliases: []string{"r"},
+		Short:   "Recover sessions left behind by a crashed host",
+		Long: `Recover sessions left behind by a crashed host. Session state is persisted in ~/.upterm while a session
+is being hosted. This command reports sessions whose host process is no longer running and cleans up their stale
+admin sockets. With --reshare, the named session is re-established with the same parameters, including the keys authorized to join.`,
+		Example: `  # Report and clean up stale sessions:
+  upterm session recover
+
+  # Re-establish a stale session with the same server and command:
+  upterm session recover NAME --reshare`,
+		RunE: recoverRunE,
+	}
+
+	cmd.PersistentFlags().BoolVar(&flagReshare, "reshare", false, "Re-establish the named session with the same parameters, including the keys authorized to join.")
+
+	return cmd
+}
+
+func set() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Change the current terminal session",
+		Long: `Change the current terminal session while clients are connected. Connected clients are notified of the
+change. The host can also toggle the read-only mode by typing Ctrl-] followed by r in the hosted terminal.`,
+		Example: `  # Make the active session read-only:
+  upterm session set --read-only
+
+  # Let clients type again:
+  upterm session set --read-only=false`,
+		PreRunE: validateCurrentRequiredFlags,
+		RunE:    setRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+	cmd.PersistentFlags().BoolVarP(&flagSetReadOnly, "read-only", "r", false, "Set the session read-only, preventing client interaction.")
+
+	return cmd
+}
+
+func kick() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kick CLIENT_ID",
+		Short: "Disconnect a client from the current terminal session",
+		Long: `Disconnect a client from the current terminal session by closing its connection. The client leaves the
+session like it disconnected, and may join again unless its key is no longer authorized. Client IDs are listed with
+the connected clients by 'upterm session current'. A unique prefix of an ID is enough.`,
+		Example: `  # List the connected clients of the active session with their IDs:
+  upterm session current
+
+  # Disconnect the client with the ID starting with 3f9a1c2e:
+  upterm session kick 3f9a1c2e`,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: validateCurrentRequiredFlags,
+		RunE:    kickRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+
+	return cmd
+}
+
+func newSession() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "new [-- COMMAND]",
+		Short: "Spawn another session from a listen-only host",
+		Long: `Spawn another terminal session from the host process of the current session, which must be hosted with
+'upterm host --listen-only'. The new session has its own terminal and session ID, and runs the specified command or
+the command of the host. It's shared with the same server and authorized keys, and is displayed once it's
+established.`,
+		Example: `  # Spawn another session running the command of the host:
+  upterm session new
+
+  # Spawn a session running htop from a listen-only host:
+  upterm session new --admin-socket ~/.upterm/SESSION_ID.sock -- htop`,
+		PreRunE: validateCurrentRequiredFlags,
+		RunE:    newSessionRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+
+	return cmd
+}
+
+func fingerprint() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "fingerprint",
+		Aliases: []string{"fp"},
+		Short:   "Display the host key fingerprints of the current terminal session",
+		Long: `Display the fingerprints of the host key of the server the current session is shared with and of the host
+keys clients verify the session with, so that they can be verified programmatically, e.g. against SSHFP records in DNS.
+The keys of the session are listed for the direct address if the session accepts direct connections.`,
+		Example: `  # Display the fingerprints like ssh-keygen -l:
+  upterm session fingerprint
+
+  # Display the fingerprints in JSON, e.g. for scripts:
+  upterm session fingerprint --format json`,
+		PreRunE: validateCurrentRequiredFlags,
+		RunE:    fingerprintRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+	cmd.PersistentFlags().StringVar(&flagFingerprintFormat, "format", utils.FingerprintFormatOpenSSH, fmt.Sprintf("Output format: %s.", strings.Join(utils.FingerprintFormats, ", ")))
 
 	return cmd
 }
@@ -39,12 +168,18 @@ func list() *cobra.Command {
 		Use:     "list",
 		Aliases: []string{"ls", "l"},
 		Short:   "List shared sessions",
-		Long:    `List shared sessions. Session admin sockets are located in ~/.upterm.`,
+		Long: `List shared sessions with their commands, servers, and numbers of connected clients. Session admin
+sockets are located in ~/.upterm. The current session, as defined in $UPTERM_ADMIN_SOCKET, is marked with *.`,
 		Example: `  # List shared sessions:
-  upterm session list`,
+  upterm session list
+
+  # List shared sessions in JSON, e.g. for scripts:
+  upterm session list --json`,
 		RunE: listRunE,
 	}
 
+	cmd.PersistentFlags().BoolVar(&flagListJSON, "json", false, "Print the sessions as a JSON array.")
+
 	return cmd
 }
 
@@ -74,12 +209,16 @@ sharing a session with 'upterm host'.`,
   upterm session current
 
   # Display the session with a custom admin socket path:
-  upterm session current --admin-socket ADMIN_SOCKET_PATH`,
+  upterm session current --admin-socket ADMIN_SOCKET_PATH
+
+  # Print the authorized keys composed from all sources in the authorized_keys format:
+  upterm session current --show-authorized-keys`,
 		PreRunE: validateCurrentRequiredFlags,
 		RunE:    currentRunE,
 	}
 
 	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+	cmd.PersistentFlags().BoolVar(&flagShowAuthorizedKeys, "show-authorized-keys", false, "Print the authorized keys of the session in the authorized_keys format, grouped by source.")
 
 	return cmd
 }
@@ -90,22 +229,42 @@ func listRunE(c *cobra.Command, args []string) error {
 		return err
 	}
 
-	sessions, err := listSessions(uptermDir)
+	sessions, err := listSessions(uptermDir, currentAdminSocketFile(), session)
 	if err != nil {
 		return err
 	}
 
+	if flagListJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sessions)
+	}
+
 	if len(sessions) == 0 {
 		fmt.Println("No session is found. Create one with `upterm host`.")
 		return nil
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Current", "Session", "Command", "Force Command", "Host"})
+	table.SetHeader([]string{"Current", "Session", "Name", "Command", "Force Command", "Server", "Clients"})
 	table.SetBorder(false)
 	table.SetAlignment(tablewriter.ALIGN_CENTER)
 	table.SetCenterSeparator("|")
-	table.AppendBulk(sessions)
+	for _, sess := range sessions {
+		var current string
+		if sess.Current {
+			current = "*"
+		}
+		table.Append([]string{
+			current,
+			sess.SessionID,
+			naIfEmpty(sess.Name),
+			strings.Join(sess.Command, " "),
+			naIfEmpty(strings.Join(sess.ForceCommand, " ")),
+			sess.Server,
+			fmt.Sprint(sess.Clients),
+		})
+	}
 
 	table.Render()
 	return nil
@@ -124,19 +283,356 @@ func infoRunE(c *cobra.Command, args []string) error {
 	return displaySessionFromAdminSocketPath(filepath.Join(uptermDir, host.AdminSocketFile(args[0])))
 }
 
+func recoverRunE(c *cobra.Command, args []string) error {
+	if flagReshare && len(args) == 0 {
+		return fmt.Errorf("missing session name to reshare")
+	}
+
+	uptermDir, err := utils.CreateUptermDir()
+	if err != nil {
+		return err
+	}
+
+	states, err := host.ReadSessionStates(uptermDir)
+	if err != nil {
+		return err
+	}
+
+	var stale []*host.SessionState
+	for _, st := range states {
+		if len(args) > 0 && st.SessionID != args[0] {
+			continue
+		}
+
+		if isSessionAlive(st) {
+			continue
+		}
+
+		stale = append(stale, st)
+	}
+
+	if len(stale) == 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("no stale session %s is found", args[0])
+		}
+
+		fmt.Println("No stale session is found.")
+		return nil
+	}
+
+	data := [][]string{}
+	for _, st := range stale {
+		if err := host.RemoveSessionState(uptermDir, st); err != nil {
+			return fmt.Errorf("error cleaning up session %s: %w", st.SessionID, err)
+		}
+
+		data = append(data, []string{
+			st.SessionID,
+			strings.Join(st.Command, " "),
+			naIfEmpty(strings.Join(st.ForceCommand, " ")),
+			st.Host,
+			st.StartedAt.Local().Format(time.RFC3339),
+		})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Session", "Command", "Force Command", "Host", "Started At"})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetCenterSeparator("|")
+	table.AppendBulk(data)
+	table.Render()
+
+	fmt.Printf("\nCleaned up %d stale session(s).\n", len(stale))
+
+	if !flagReshare {
+		return nil
+	}
+
+	return reshareSession(stale[0])
+}
+
+// isSessionAlive reports whether the host of the session is still running and serving its admin socket.
+func isSessionAlive(st *host.SessionState) bool {
+	if !st.IsProcessAlive() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := host.AdminClient(st.AdminSocketFile)
+	if err != nil {
+		return false
+	}
+
+	_, err = c.GetSession(ctx, &api.GetSessionRequest{})
+	return err == nil
+}
+
+func reshareSession(st *host.SessionState) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, reshareArgs(st)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// reshareArgs returns the args of upterm host re-establishing the session of st with the same access.
+func reshareArgs(st *host.SessionState) []string {
+	args := []string{"host", "--server", st.Host}
+	args = append(args, accessArgs(st.Access)...)
+	if len(st.ForceCommand) > 0 {
+		args = append(args, "--force-command", shellJoin(st.ForceCommand))
+	}
+	if st.Name != "" {
+		args = append(args, "--name", st.Name)
+	}
+	if st.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	args = append(args, "--")
+	args = append(args, st.Command...)
+
+	return args
+}
+
+// accessArgs returns the flags of upterm host specifying access.
+func accessArgs(a host.SessionAccess) []string {
+	var args []string
+	flag := func(name string, values ...string) {
+		for _, v := range values {
+			if v != "" {
+				args = append(args, "--"+name, v)
+			}
+		}
+	}
+
+	flag("private-key", a.PrivateKeys...)
+	flag("known-hosts", a.KnownHostsFile)
+	flag("authorized-keys", a.AuthorizedKeysFile)
+	flag("authorized-keys-url", a.AuthorizedKeysURLs...)
+	flag("cert-authority", a.CertAuthorities...)
+	flag("codeberg-user", a.CodebergUsers...)
+	flag("github-user", a.GitHubUsers...)
+	flag("gitlab-user", a.GitLabUsers...)
+	flag("srht-user", a.SourceHutUsers...)
+	flag("profile", a.Profile)
+
+	return args
+}
+
+// shellJoin joins args into a string that shlex splits back into the same args.
+func shellJoin(args []string) string {
+	var quoted []string
+	for _, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`") {
+			quoted = append(quoted, arg)
+			continue
+		}
+
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'"'"'`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
+
 func currentRunE(c *cobra.Command, args []string) error {
+	if flagShowAuthorizedKeys {
+		sess, err := session(flagAdminSocket)
+		if err != nil {
+			return err
+		}
+
+		printAuthorizedKeys(os.Stdout, sess.AuthorizedKeys)
+		return nil
+	}
+
 	return displaySessionFromAdminSocketPath(flagAdminSocket)
 }
 
-func listSessions(dir string) ([][]string, error) {
-	result := make([][]string, 0)
+func fingerprintRunE(c *cobra.Command, args []string) error {
+	sess, err := session(flagAdminSocket)
+	if err != nil {
+		return err
+	}
+
+	fps, err := sessionFingerprints(sess)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteHostKeyFingerprints(c.OutOrStdout(), flagFingerprintFormat, fps)
+}
+
+// sessionFingerprints returns the fingerprints of the server host key followed by the ones of the session host keys.
+// Host keys and their certificates are listed once.
+func sessionFingerprints(sess *api.GetSessionResponse) ([]utils.HostKeyFingerprint, error) {
+	var fps []utils.HostKeyFingerprint
+	seen := make(map[string]bool)
+	add := func(addr, authorizedKey string) error {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+		if err != nil {
+			return fmt.Errorf("error parsing host key: %w", err)
+		}
+
+		fp := utils.NewHostKeyFingerprint(addr, key)
+		if id := fp.Host + " " + fp.PublicKey; !seen[id] {
+			seen[id] = true
+			fps = append(fps, fp)
+		}
+
+		return nil
+	}
+
+	if sess.ServerHostKey != "" {
+		_, _, host, port, err := parseURL(sess.Host)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(net.JoinHostPort(host, port), sess.ServerHostKey); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range sess.HostPublicKeys {
+		if err := add(sess.DirectAddr, k); err != nil {
+			return nil, err
+		}
+	}
+
+	return fps, nil
+}
+
+func setRunE(c *cobra.Command, args []string) error {
+	req := &api.SetSessionRequest{}
+	if c.Flags().Changed("read-only") {
+		req.ReadOnly = &flagSetReadOnly
+	}
+	if req.ReadOnly == nil {
+		return fmt.Errorf("nothing to change, specify --read-only")
+	}
+
+	client, err := host.AdminClient(flagAdminSocket)
+	if err != nil {
+		return err
+	}
+
+	sess, err := client.SetSession(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	return displaySession(sess)
+}
+
+func kickRunE(c *cobra.Command, args []string) error {
+	client, err := host.AdminClient(flagAdminSocket)
+	if err != nil {
+		return err
+	}
+
+	sess, err := client.GetSession(context.Background(), &api.GetSessionRequest{})
+	if err != nil {
+		return err
+	}
+	id, err := resolveClientID(sess.ConnectedClients, args[0])
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.KickClient(context.Background(), &api.KickClientRequest{ClientId: id}); err != nil {
+		return err
+	}
+	fmt.Printf("Disconnected client %s\n", shortClientID(id))
+
+	return nil
+}
+
+func newSessionRunE(c *cobra.Command, args []string) error {
+	client, err := host.AdminClient(flagAdminSocket)
+	if err != nil {
+		return err
+	}
+
+	sess, err := client.NewSession(context.Background(), &api.NewSessionRequest{Command: args})
+	if err != nil {
+		return err
+	}
+
+	return displaySession(sess)
+}
+
+// resolveClientID returns the ID of the connected client whose ID is id or starts with it.
+func resolveClientID(clients []*api.Client, id string) (string, error) {
+	var matches []string
+	for _, c := range clients {
+		if c.Id == id {
+			return id, nil
+		}
+		if id != "" && strings.HasPrefix(c.Id, id) {
+			matches = append(matches, c.Id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no connected client has the ID %s", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("client ID %s is ambiguous, it matches %d clients", id, len(matches))
+	}
+}
+
+// shortClientID returns the prefix of a client ID displayed with the client, which is enough to kick it.
+func shortClientID(id string) string {
+	if len(id) > clientIDDisplayLength {
+		return id[:clientIDDisplayLength]
+	}
+
+	return id
+}
+
+func printAuthorizedKeys(w io.Writer, keys []*api.AuthorizedKey) {
+	for _, ak := range keys {
+		fmt.Fprintf(w, "# %s\n", ak.Comment)
+		for _, pk := range ak.PublicKeys {
+			fmt.Fprintln(w, pk)
+		}
+	}
+}
+
+// listedSession is a session listed by `upterm session list`.
+type listedSession struct {
+	SessionID    string   `json:"session_id"`
+	Name         string   `json:"name"`
+	Command      []string `json:"command"`
+	ForceCommand []string `json:"force_command"`
+	// Server is the URL of the server the session is hosted on, e.g. ssh://uptermd.upterm.dev:22.
+	Server  string `json:"server"`
+	Clients int    `json:"clients"`
+	// Current tells whether the session is the one defined in $UPTERM_ADMIN_SOCKET.
+	Current     bool   `json:"current"`
+	AdminSocket string `json:"admin_socket"`
+}
+
+// listSessions queries the sessions of the admin sockets in dir with getSession.
+// Sockets of sessions that can't be queried, e.g. of crashed hosts, are skipped.
+func listSessions(dir, currentAdminSocket string, getSession func(adminSocket string) (*api.GetSessionResponse, error)) ([]listedSession, error) {
+	result := make([]listedSession, 0)
 
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	currentAdminSocket := currentAdminSocketFile()
 	for _, file := range files {
 		// continue if the file is not SESSION.sock
 		if filepath.Ext(file.Name()) != host.AdminSockExt {
@@ -144,25 +640,21 @@ func listSessions(dir string) ([][]string, error) {
 		}
 
 		adminSocket := filepath.Join(dir, file.Name())
-		session, err := session(adminSocket)
+		session, err := getSession(adminSocket)
 		if err != nil {
 			continue
 		}
 
-		var current string
-		if adminSocket == currentAdminSocket {
-			current = "*"
-		}
-
-		result = append(
-			result,
-			[]string{
-				current,
-				session.SessionId,
-				strings.Join(session.Command, " "),
-				naIfEmpty(strings.Join(session.ForceCommand, " ")),
-				session.Host,
-			})
+		result = append(result, listedSession{
+			SessionID:    session.SessionId,
+			Name:         session.Name,
+			Command:      session.Command,
+			ForceCommand: session.ForceCommand,
+			Server:       session.Host,
+			Clients:      len(session.ConnectedClients),
+			Current:      adminSocket == currentAdminSocket,
+			AdminSocket:  adminSocket,
+		})
 	}
 
 	return result, nil
@@ -211,7 +703,7 @@ func displaySession(session *api.GetSessionResponse) error {
 		return err
 	}
 
-	u, scheme, host, port, err := parseURL(session.Host)
+	u, _, host, port, err := parseURL(session.Host)
 	if err != nil {
 		return err
 	}
@@ -223,22 +715,69 @@ func displaySession(session *api.GetSessionResponse) error {
 		hostPort = host + ":" + port
 	}
 
-	var sshCmd string
-	if scheme == "ssh" {
-		sshCmd = fmt.Sprintf("ssh %s@%s", user, host)
-		if port != "22" {
-			sshCmd = fmt.Sprintf("%s -p %s", sshCmd, port)
-		}
-	} else {
-		sshCmd = fmt.Sprintf("ssh -o ProxyCommand='upterm proxy %s://%s@%s' %s@%s", scheme, user, hostPort, user, host+":"+port)
+	sshCmd, err := routing.JoinCommand(session.Host, user)
+	if err != nil {
+		return err
 	}
 
-	data := [][]string{
+	var data [][]string
+	if session.Name != "" {
+		data = append(data, []string{"Name:", session.Name})
+	}
+	data = append(data, [][]string{
 		{"Command:", strings.Join(session.Command, " ")},
 		{"Force Command:", naIfEmpty(strings.Join(session.ForceCommand, " "))},
 		{"Host:", u.Scheme + "://" + hostPort},
 		{"Authorized Keys:", naIfEmpty(displayAuthorizedKeys(session.AuthorizedKeys))},
-		{"SSH Session:", sshCmd},
+	}...)
+	if session.AuthKey != "" {
+		data = append(data, []string{"Auth Key:", describeAuthorizedKey(session.AuthKey, time.Now())})
+	}
+	if session.ServerHostKey != "" {
+		data = append(data, []string{"Server Host Key:", describeAuthorizedKey(session.ServerHostKey, time.Now())})
+	}
+	if session.StartAt != 0 {
+		data = append(data, []string{"Starts At:", time.Unix(session.StartAt, 0).Local().Format(time.RFC1123)})
+	}
+	if session.MaxDurationSeconds != 0 {
+		data = append(data, []string{"Max Duration:", (time.Duration(session.MaxDurationSeconds) * time.Second).String()})
+	}
+	if session.ExpiresAt != 0 {
+		data = append(data, []string{"Expires At:", time.Unix(session.ExpiresAt, 0).Local().Format(time.RFC1123)})
+	}
+	if session.ReadOnly {
+		data = append(data, []string{"Read-Only:", "yes"})
+	}
+	if session.MaxClients > 0 {
+		data = append(data, []string{"Max Clients:", fmt.Sprintf("%d", session.MaxClients)})
+	}
+	if session.Recorded {
+		data = append(data, []string{"Recorded:", "yes, in the audit log of the server"})
+	}
+	for i, item := range session.Menu {
+		var header string
+		if i == 0 {
+			header = "Menu:"
+		}
+		data = append(data, []string{header, fmt.Sprintf("%d) %s: %s", i+1, item.Name, strings.Join(item.Command, " "))})
+	}
+	if session.Sandbox != "" {
+		data = append(data, []string{"Sandbox:", session.Sandbox})
+	}
+	data = append(data, []string{"SSH Session:", sshCmd})
+	for i, p := range session.ForwardedPorts {
+		var header string
+		if i == 0 {
+			header = "Forwarded Port(s):"
+		}
+		data = append(data, []string{header, forwardCommand(sshCmd, p)})
+	}
+	if session.VscodePort != 0 {
+		data = append(data, []string{"VS Code:", forwardCommand(sshCmd, session.VscodePort)})
+		data = append(data, []string{"", fmt.Sprintf("then open http://localhost:%d with password %s", session.VscodePort, session.VscodePassword)})
+	}
+	if session.DirectAddr != "" {
+		data = append(data, []string{"Direct SSH Session:", directSSHCommand(session.DirectAddr)})
 	}
 
 	isFirst := true
@@ -248,7 +787,14 @@ func displaySession(session *api.GetSessionResponse) error {
 			header = "Connected Client(s):"
 			isFirst = false
 		}
-		data = append(data, []string{header, clientDesc(c.Addr, c.Version, c.PublicKeyFingerprint)})
+		desc := shortClientID(c.Id) + " " + clientDesc(c.Addr, c.Version, c.PublicKeyFingerprint, c.DisplayName)
+		if c.LastInputAt != 0 {
+			desc += fmt.Sprintf(" (typed %s, last %s ago)", formatBytes(c.InputBytes), time.Since(time.Unix(c.LastInputAt, 0)).Round(time.Second))
+		}
+		data = append(data, []string{header, desc})
+	}
+	if session.Stats != nil {
+		data = append(data, statsRows(session.Stats)...)
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
@@ -268,8 +814,43 @@ func displaySession(session *api.GetSessionResponse) error {
 	return nil
 }
 
-func clientDesc(addr, clientVer, fingerprint string) string {
-	return fmt.Sprintf("%s %s %s", addr, clientVer, fingerprint)
+func statsRows(stats *api.SessionStats) [][]string {
+	return [][]string{
+		{"Duration:", (time.Duration(stats.DurationSeconds) * time.Second).String()},
+		{"Data In/Out:", fmt.Sprintf("%s / %s", formatBytes(stats.BytesIn), formatBytes(stats.BytesOut))},
+		{"Peak Clients:", fmt.Sprintf("%d", stats.PeakClients)},
+	}
+}
+
+// formatBytes formats n in binary units, e.g. 1.5 KiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// describeAuthorizedKey describes a key in authorized_keys format with utils.DescribeKey, or returns it as is if it
+// fails to be parsed.
+func describeAuthorizedKey(s string, now time.Time) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return s
+	}
+
+	return utils.DescribeKey(key, now)
+}
+
+func clientDesc(addr, clientVer, fingerprint, name string) string {
+	return fmt.Sprintf("%s %s %s", addr, clientVer, identity.Describe(name, fingerprint))
 }
 
 func currentAdminSocketFile() string {
@@ -318,3 +899,17 @@ func naIfEmpty(s string) string {
 
 	return s
 }
+
+// forwardCommand returns the ssh command joining the session that forwards the local port to the same port of the host.
+func forwardCommand(sshCmd string, port uint32) string {
+	return fmt.Sprintf("ssh -L %d:localhost:%d %s", port, port, strings.TrimPrefix(sshCmd, "ssh "))
+}
+
+func directSSHCommand(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "ssh " + addr
+	}
+
+	return fmt.Sprintf("ssh %s -p %s", host, port)
+}
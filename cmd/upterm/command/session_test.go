@@ -0,0 +1,229 @@
+package command
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_listSessions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alive.sock", "crashed.sock", "other.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alive := filepath.Join(dir, "alive.sock")
+	getSession := func(adminSocket string) (*api.GetSessionResponse, error) {
+		if adminSocket != alive {
+			return nil, errors.New("connection refused")
+		}
+
+		return &api.GetSessionResponse{
+			SessionId:        "alive",
+			Name:             "debugging prod incident",
+			Command:          []string{"bash", "-l"},
+			Host:             "ssh://uptermd.upterm.dev:22",
+			ConnectedClients: []*api.Client{{Id: "1"}, {Id: "2"}},
+		}, nil
+	}
+
+	sessions, err := listSessions(dir, alive, getSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []listedSession{
+		{
+			SessionID:   "alive",
+			Name:        "debugging prod incident",
+			Command:     []string{"bash", "-l"},
+			Server:      "ssh://uptermd.upterm.dev:22",
+			Clients:     2,
+			Current:     true,
+			AdminSocket: alive,
+		},
+	}
+	if diff := cmp.Diff(want, sessions); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_sessionFingerprints(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIUWrZ79+0njqn+JU8IqzywcKVnUgXjM5hNiqYaT9gcf"
+
+	fps, err := sessionFingerprints(&api.GetSessionResponse{
+		Host:           "ssh://uptermd.upterm.dev",
+		ServerHostKey:  key,
+		DirectAddr:     "192.0.2.1:2222",
+		HostPublicKeys: []string{key, key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := utils.WriteHostKeyFingerprints(&buf, utils.FingerprintFormatOpenSSH, fps); err != nil {
+		t.Fatal(err)
+	}
+	want := `256 SHA256:ht0r1FDVGKSOmzx1JUPRzzNMeZVeP/gVbz+M31FBMkQ uptermd.upterm.dev (ED25519)
+256 SHA256:ht0r1FDVGKSOmzx1JUPRzzNMeZVeP/gVbz+M31FBMkQ [192.0.2.1]:2222 (ED25519)
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the records match ssh-keygen -r
+	buf.Reset()
+	if err := utils.WriteHostKeyFingerprints(&buf, utils.FingerprintFormatSSHFP, fps[:1]); err != nil {
+		t.Fatal(err)
+	}
+	want = `uptermd.upterm.dev. IN SSHFP 4 1 228ee4d12ccf0a76a957a1db73914c74ea2ef96b
+uptermd.upterm.dev. IN SSHFP 4 2 86dd2bd450d518a48e9b3c752543d1cf334c79955e3ff8156f3f8cdf51413244
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := utils.WriteHostKeyFingerprints(&buf, "xml", fps); err == nil {
+		t.Fatal("expect error for an unsupported format")
+	}
+}
+
+func Test_describeAuthorizedKey(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIUWrZ79+0njqn+JU8IqzywcKVnUgXjM5hNiqYaT9gcf"
+	now := time.Unix(1700000000, 0)
+
+	if want, got := "ssh-ed25519 SHA256:ht0r1FDVGKSOmzx1JUPRzzNMeZVeP/gVbz+M31FBMkQ", describeAuthorizedKey(key, now); want != got {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if want, got := "not a key", describeAuthorizedKey("not a key", now); want != got {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:         pub,
+		KeyId:       "host",
+		CertType:    ssh.UserCert,
+		ValidBefore: uint64(now.Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	certKey := string(ssh.MarshalAuthorizedKey(cert))
+
+	want := fmt.Sprintf(`ssh-ed25519 cert SHA256:ht0r1FDVGKSOmzx1JUPRzzNMeZVeP/gVbz+M31FBMkQ (key ID "host", signed by %s, valid until %s)`, utils.FingerprintSHA256(ca.PublicKey()), now.Add(time.Hour).Format(time.RFC3339))
+	if got := describeAuthorizedKey(certKey, now); want != got {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if got := describeAuthorizedKey(certKey, now.Add(2*time.Hour)); !strings.Contains(got, "expired at") {
+		t.Fatalf("want an expired cert, got %q", got)
+	}
+}
+
+func Test_forwardCommand(t *testing.T) {
+	for sshCmd, want := range map[string]string{
+		"ssh abc@uptermd.upterm.dev":         "ssh -L 3000:localhost:3000 abc@uptermd.upterm.dev",
+		"ssh abc@uptermd.upterm.dev -p 2222": "ssh -L 3000:localhost:3000 abc@uptermd.upterm.dev -p 2222",
+	} {
+		if got := forwardCommand(sshCmd, 3000); got != want {
+			t.Errorf("want=%q got=%q", want, got)
+		}
+	}
+}
+
+func Test_resolveClientID(t *testing.T) {
+	clients := []*api.Client{{Id: "3f9a1c2e77"}, {Id: "3f9b0000aa"}, {Id: "3f"}}
+
+	for id, want := range map[string]string{
+		"3f9a1c2e77": "3f9a1c2e77",
+		"3f9a":       "3f9a1c2e77",
+		"3f9b":       "3f9b0000aa",
+		"3f":         "3f",
+	} {
+		got, err := resolveClientID(clients, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: want=%s got=%s", id, want, got)
+		}
+	}
+
+	for _, id := range []string{"3f9", "ff", ""} {
+		if got, err := resolveClientID(clients, id); err == nil {
+			t.Errorf("%q: want error for an ambiguous or unknown ID, got %s", id, got)
+		}
+	}
+}
+
+func Test_reshareArgs(t *testing.T) {
+	st := &host.SessionState{
+		Host:         "ssh://uptermd.upterm.dev:22",
+		Command:      []string{"bash", "-l"},
+		ForceCommand: []string{"tmux", "attach"},
+		Name:         "pairing",
+		ReadOnly:     true,
+		Access: host.SessionAccess{
+			PrivateKeys:        []string{"/home/owen/.ssh/id_ed25519", "/home/owen/.ssh/id_rsa"},
+			KnownHostsFile:     "/home/owen/.ssh/known_hosts",
+			AuthorizedKeysFile: "/home/owen/.ssh/authorized_keys",
+			GitHubUsers:        []string{"owenthereal"},
+			SourceHutUsers:     []string{"~sircmpwn"},
+			Profile:            "hardened",
+		},
+	}
+
+	want := []string{
+		"host", "--server", "ssh://uptermd.upterm.dev:22",
+		"--private-key", "/home/owen/.ssh/id_ed25519",
+		"--private-key", "/home/owen/.ssh/id_rsa",
+		"--known-hosts", "/home/owen/.ssh/known_hosts",
+		"--authorized-keys", "/home/owen/.ssh/authorized_keys",
+		"--github-user", "owenthereal",
+		"--srht-user", "~sircmpwn",
+		"--profile", "hardened",
+		"--force-command", "tmux attach",
+		"--name", "pairing",
+		"--read-only",
+		"--", "bash", "-l",
+	}
+	if diff := cmp.Diff(want, reshareArgs(st)); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the args parse back into the access of the session
+	cmd := hostCmd()
+	if err := cmd.ParseFlags(reshareArgs(st)[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(st.Access, sessionAccess()); diff != "" {
+		t.Fatal(diff)
+	}
+}
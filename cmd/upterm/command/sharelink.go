@@ -0,0 +1,47 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+)
+
+// shareLink returns a link and the ssh command to join the session with a new one-time invite expiring after ttl,
+// which lets a client with any key join. The invite is registered with the server of the session.
+func shareLink(session *api.GetSessionResponse, tokens *host.JoinTokens, ttl time.Duration, now time.Time) (string, string, time.Time, error) {
+	ed, err := routing.NewEncodeDecoder(routing.V2)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	user, err := ed.Encode(session.SessionId, session.NodeAddr)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	token, expiresAt, err := tokens.Invite(ttl, now)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("error issuing share link: %w", err)
+	}
+	user = routing.WithToken(user, token)
+
+	link, err := routing.ShareLink(session.Host, user)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	cmd, err := routing.JoinCommand(session.Host, user)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return link, cmd, expiresAt, nil
+}
+
+func displayShareLink(w io.Writer, link, cmd string, expiresAt time.Time) error {
+	_, err := fmt.Fprintf(w, "\nShare this link to invite someone without an authorized key. It can be used once until %s:\n\n%s\n\nor join with:\n\n%s\n", expiresAt.Local().Format(time.Kitchen), link, cmd)
+	return err
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+)
+
+func Test_shareLink(t *testing.T) {
+	now := time.Now()
+	session := &api.GetSessionResponse{
+		SessionId: "10OLFAKZu4cxx2roOboaY",
+		NodeAddr:  "127.0.0.1:2222",
+		Host:      "wss://uptermd.upterm.dev",
+	}
+	tokens := host.NewJoinTokens()
+	if _, _, _, err := shareLink(session, tokens, time.Hour, now); err == nil {
+		t.Fatal("want error sharing a link before the session is established")
+	}
+
+	var registered string
+	tokens.SetRegistrar(func(token string, expiresAt time.Time) error {
+		registered = token
+		return nil
+	})
+	link, cmd, _, err := shareLink(session, tokens, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "wss" || u.Host != "uptermd.upterm.dev" {
+		t.Fatalf("unexpected share link %s", link)
+	}
+	if _, token := routing.SplitToken(u.User.Username()); token != registered {
+		t.Fatalf("want registered token %q in the share link, got %q", registered, token)
+	}
+	if !strings.Contains(cmd, u.User.Username()) {
+		t.Fatalf("want the join command to carry the token, got %s", cmd)
+	}
+}
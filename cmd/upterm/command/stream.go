@@ -0,0 +1,81 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagStreamAsciinemaServer string
+)
+
+func streamCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stream",
+		Short: "Relay the current terminal session to a live stream",
+		Long: `Relay the output of the current terminal session to a live stream of asciinema-server, so that an
+unlimited audience can watch it read-only in a browser while only trusted collaborators join over SSH. The output the
+host types privately isn't streamed.
+
+Create a live stream on the asciinema server and pass its producer URL, e.g. wss://asciinema.org/ws/S/TOKEN, with
+--asciinema-server. The stream is relayed until the session ends or the command is interrupted. By default, the
+session is the one of the admin socket in the UPTERM_ADMIN_SOCKET environment variable.`,
+		Example: `  # Relay the current session to a live stream of asciinema.org:
+  upterm stream --asciinema-server wss://asciinema.org/ws/S/TOKEN
+
+  # Relay another session to a self-hosted asciinema-server:
+  upterm stream --admin-socket ~/.upterm/SESSION_ID.sock --asciinema-server wss://asciinema.example.com/ws/S/TOKEN`,
+		PreRunE: validateStreamRequiredFlags,
+		RunE:    streamRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&flagAdminSocket, "admin-socket", "", currentAdminSocketFile(), "admin unix domain socket (required)")
+	cmd.PersistentFlags().StringVar(&flagStreamAsciinemaServer, "asciinema-server", "", "Producer URL of the live stream of asciinema-server to relay the session to, e.g. wss://asciinema.org/ws/S/TOKEN (required). http and https URLs are dialed with ws and wss.")
+
+	return cmd
+}
+
+func validateStreamRequiredFlags(c *cobra.Command, args []string) error {
+	if err := validateCurrentRequiredFlags(c, args); err != nil {
+		return err
+	}
+	if flagStreamAsciinemaServer == "" {
+		return fmt.Errorf(`required flag(s) "%s" not set`, "asciinema-server")
+	}
+
+	return nil
+}
+
+func streamRunE(c *cobra.Command, args []string) error {
+	u, err := host.ParseAsciinemaURL(flagStreamAsciinemaServer)
+	if err != nil {
+		return err
+	}
+
+	client, err := host.AdminClient(flagAdminSocket)
+	if err != nil {
+		return err
+	}
+	sess, err := session(flagAdminSocket)
+	if err != nil {
+		return fmt.Errorf("error getting session: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Streaming session %s to %s://%s, press Ctrl-C to stop\n", sess.SessionId, u.Scheme, u.Host)
+	if err := host.StreamAsciinema(ctx, client, u); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	fmt.Println("Stream ended")
+
+	return nil
+}
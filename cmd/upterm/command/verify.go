@@ -0,0 +1,196 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/ftests/scenarios"
+	"github.com/owenthereal/upterm/host"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	flagVerifyServers     []string
+	flagVerifyScenarios   []string
+	flagVerifyTimeout     time.Duration
+	flagVerifyKnownHosts  string
+	flagVerifyAgreePolicy string
+)
+
+func verifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify an upterm deployment",
+		Long: fmt.Sprintf(`Verify an upterm deployment by running functional test scenarios against it, e.g. as a smoke test after an
+upgrade. Each scenario hosts a session with throwaway keys and joins it as a client. Scenarios: %s.
+
+Specify --server multiple times to verify the nodes of a deployment. Clients join sessions on the next node, verifying
+routing across nodes. The command fails if a scenario fails.`, strings.Join(scenarios.Names(), ", ")),
+		Example: `  # Verify the default server:
+  upterm verify
+
+  # Verify two nodes of a deployment, including routing between them:
+  upterm verify --server ssh://node1.example.com:22 --server ssh://node2.example.com:22
+
+  # Verify the WebSocket endpoint with some scenarios:
+  upterm verify --server wss://uptermd.example.com --scenario auth,attach`,
+		RunE: verifyRunE,
+		// failing scenarios are reported in the results
+		SilenceUsage: true,
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd.PersistentFlags().StringArrayVar(&flagVerifyServers, "server", []string{defaultServer}, "Specify an upterm server address to verify. Supported protocols: ssh, ws, wss.")
+	cmd.PersistentFlags().StringSliceVar(&flagVerifyScenarios, "scenario", nil, fmt.Sprintf("Run only the specified scenarios (%s).", strings.Join(scenarios.Names(), ", ")))
+	cmd.PersistentFlags().DurationVar(&flagVerifyTimeout, "timeout", 30*time.Second, "Specify the timeout of each scenario.")
+	cmd.PersistentFlags().StringVarP(&flagVerifyKnownHosts, "known-hosts", "", defaultKnownHost(homeDir), "Specify a file containing known keys for remote hosts (required).")
+	cmd.PersistentFlags().StringVar(&flagVerifyAgreePolicy, "agree-policy", "", "Agree to the server policy with the specified hash without prompting.")
+
+	return cmd
+}
+
+func verifyRunE(c *cobra.Command, args []string) error {
+	ss, err := scenarios.Find(flagVerifyScenarios)
+	if err != nil {
+		return err
+	}
+
+	hkcb, err := host.NewPromptingHostKeyCallback(os.Stdin, os.Stdout, flagVerifyKnownHosts)
+	if err != nil {
+		return err
+	}
+	hkcb = rememberHostKeys(hkcb)
+
+	// hosts of all scenarios agree to the same policy, so ask once
+	var (
+		mu     sync.Mutex
+		agreed = flagVerifyAgreePolicy
+	)
+	agree := func(policy, hash string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := agreePolicy(bufio.NewReader(os.Stdin), os.Stdout, agreed, policy, hash); err != nil {
+			return err
+		}
+		agreed = hash
+
+		return nil
+	}
+
+	envs := verifyEnvs(flagVerifyServers)
+	results := make([][]scenarios.Result, len(ss))
+	var failed int
+	for i, s := range ss {
+		for _, env := range envs {
+			env.HostKeyCallback = hkcb
+			env.AgreePolicyCallback = agree
+
+			r := scenarios.Run(context.Background(), env, s, flagVerifyTimeout)
+			if r.Status == scenarios.StatusFail {
+				failed++
+			}
+			results[i] = append(results[i], r)
+		}
+	}
+
+	displayVerifyResults(os.Stdout, envs, results)
+
+	if failed > 0 {
+		return fmt.Errorf("%d scenario(s) failed", failed)
+	}
+
+	return nil
+}
+
+// rememberHostKeys skips checking host keys that have been accepted by hkcb,
+// so that the host isn't prompted for the same key by every scenario.
+func rememberHostKeys(hkcb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	var (
+		mu       sync.Mutex
+		accepted = make(map[string]bool)
+	)
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		k := hostname + " " + string(key.Marshal())
+		if accepted[k] {
+			return nil
+		}
+
+		if err := hkcb(hostname, remote, key); err != nil {
+			return err
+		}
+		accepted[k] = true
+
+		return nil
+	}
+}
+
+// verifyEnvs returns an environment per server. Clients join on the next server.
+func verifyEnvs(servers []string) []scenarios.Env {
+	var envs []scenarios.Env
+	for i, s := range servers {
+		envs = append(envs, scenarios.Env{
+			HostURL:   s,
+			ClientURL: servers[(i+1)%len(servers)],
+		})
+	}
+
+	return envs
+}
+
+func displayVerifyResults(w io.Writer, envs []scenarios.Env, results [][]scenarios.Result) {
+	header := []string{"Scenario"}
+	for _, env := range envs {
+		if env.ClientURL == env.HostURL {
+			header = append(header, env.HostURL)
+		} else {
+			header = append(header, fmt.Sprintf("%s -> %s", env.HostURL, env.ClientURL))
+		}
+	}
+
+	var (
+		rows    [][]string
+		details []string
+	)
+	for _, rr := range results {
+		row := []string{rr[0].Scenario}
+		for j, r := range rr {
+			row = append(row, fmt.Sprintf("%s (%s)", r.Status, r.Duration.Round(time.Millisecond)))
+			if r.Err != nil {
+				details = append(details, fmt.Sprintf("%s on %s: %s: %s", r.Scenario, header[j+1], r.Status, r.Err))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, d := range details {
+		fmt.Fprintln(w, d)
+	}
+}
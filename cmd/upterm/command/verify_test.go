@@ -0,0 +1,41 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/owenthereal/upterm/ftests/scenarios"
+)
+
+func Test_verifyEnvs(t *testing.T) {
+	cases := []struct {
+		name    string
+		servers []string
+		want    []scenarios.Env
+	}{
+		{
+			name:    "single server",
+			servers: []string{"ssh://a:22"},
+			want:    []scenarios.Env{{HostURL: "ssh://a:22", ClientURL: "ssh://a:22"}},
+		},
+		{
+			name:    "clients join on the next server",
+			servers: []string{"ssh://a:22", "ssh://b:22", "wss://c"},
+			want: []scenarios.Env{
+				{HostURL: "ssh://a:22", ClientURL: "ssh://b:22"},
+				{HostURL: "ssh://b:22", ClientURL: "wss://c"},
+				{HostURL: "wss://c", ClientURL: "ssh://a:22"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := verifyEnvs(c.servers)
+			if diff := cmp.Diff(c.want, got, cmpopts.IgnoreFields(scenarios.Env{}, "HostKeyCallback", "AgreePolicyCallback", "Logger")); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -3,17 +3,16 @@ package command
 import (
 	"fmt"
 
+	"github.com/owenthereal/upterm/upterm"
 	"github.com/spf13/cobra"
 )
 
-const Version = "0.14.3"
-
 func versionCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Show version",
 		RunE: func(c *cobra.Command, args []string) error {
-			_, err := fmt.Printf("Upterm version v%s\n", Version)
+			_, err := fmt.Printf("Upterm version v%s\n", upterm.Version)
 			return err
 		},
 	}
@@ -0,0 +1,205 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	flagWhoAmIPrivateKeys []string
+	flagWhoAmIKnownHosts  string
+	flagWhoAmITimeout     time.Duration
+	flagWhoAmIJSON        bool
+)
+
+func whoAmICmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Display how the host sees your connection to a session",
+		Long: `Display how the host sees your connection to a session: the key you authenticated with, whether the
+session is read-only, and the nodes you are routed through. It helps to find out e.g. which of several keys is used
+or why joining lands on an unexpected node.
+
+The command joins the session with the specified join command or URI like ssh does, authenticating with the keys of
+the SSH agent or the private key files, and leaves without attaching to the terminal. The host may see the client
+join and leave briefly.`,
+		Example: `  # Display your connection to a session with its join command:
+  upterm whoami ssh TOKEN@uptermd.upterm.dev
+
+  # Display your connection to a session joined over WebSocket with a specific key, as JSON:
+  upterm whoami -i ~/.ssh/id_ed25519 --json wss://TOKEN@uptermd.upterm.dev`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: whoAmIRunE,
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd.PersistentFlags().StringSliceVarP(&flagWhoAmIPrivateKeys, "private-key", "i", defaultPrivateKeys(homeDir), "Specify private key files for public key authentication with the session if the SSH agent has no keys.")
+	cmd.PersistentFlags().StringVarP(&flagWhoAmIKnownHosts, "known-hosts", "", defaultKnownHost(homeDir), "Specify a file containing known keys for remote hosts (required).")
+	cmd.PersistentFlags().DurationVar(&flagWhoAmITimeout, "timeout", 30*time.Second, "Specify the timeout of joining the session.")
+	cmd.PersistentFlags().BoolVar(&flagWhoAmIJSON, "json", false, "Print the connection as JSON.")
+
+	return cmd
+}
+
+func whoAmIRunE(c *cobra.Command, args []string) error {
+	serverURL, user, err := parseJoinCommand(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	signers, cleanup, err := host.Signers(flagWhoAmIPrivateKeys)
+	if err != nil {
+		return fmt.Errorf("error reading private keys: %w", err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	// prompts go to stderr to keep the output parsable
+	hkcb, err := host.NewPromptingHostKeyCallback(os.Stdin, os.Stderr, flagWhoAmIKnownHosts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flagWhoAmITimeout)
+	defer cancel()
+
+	w, err := whoAmI(ctx, serverURL, user, signers, hkcb)
+	if err != nil {
+		return err
+	}
+
+	if flagWhoAmIJSON {
+		b, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true, Multiline: true}.Marshal(w)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(b))
+		return err
+	}
+
+	displayWhoAmI(os.Stdout, w)
+	return nil
+}
+
+// whoAmI joins the session of the user on the server and asks the host about the connection.
+func whoAmI(ctx context.Context, serverURL, user string, signers []ssh.Signer, hkcb ssh.HostKeyCallback) (*api.WhoAmI, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rejection *server.Rejection
+		mu        sync.Mutex
+	)
+	captureRejection := func(msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r, ok := server.ParseRejection(msg); ok {
+			rejection = r
+		}
+	}
+
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signers...),
+			// rejections are delivered as keyboard-interactive instructions or banners
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				captureRejection(instruction)
+				return nil, nil
+			}),
+		},
+		HostKeyCallback: hkcb,
+		BannerCallback: func(msg string) error {
+			captureRejection(msg)
+			return nil
+		},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		config.Timeout = time.Until(deadline)
+	}
+
+	conn, err := dialSessionCheck(ctx, serverURL, config)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", u.Host, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, u.Host, config)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if rejection != nil {
+			return nil, fmt.Errorf("error joining session: %w", rejection)
+		}
+		return nil, fmt.Errorf("error joining session: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	return api.RequestWhoAmI(client)
+}
+
+func displayWhoAmI(w io.Writer, whoami *api.WhoAmI) {
+	c := whoami.Client
+	if c == nil {
+		c = &api.Client{}
+	}
+
+	readOnly := "no"
+	if whoami.ReadOnly {
+		readOnly = "yes"
+	}
+
+	data := [][]string{
+		{"Client:", identity.Describe(c.DisplayName, c.PublicKeyFingerprint)},
+		{"Key Fingerprint:", c.PublicKeyFingerprint},
+		{"Address:", naIfEmpty(c.Addr)},
+		{"Version:", naIfEmpty(c.Version)},
+		{"Read-Only:", readOnly},
+		{"Connected Node:", naIfEmpty(c.NodeAddr)},
+		{"Session Node:", naIfEmpty(whoami.SessionNodeAddr)},
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"=== " + naIfEmpty(whoami.SessionId)})
+	table.SetHeaderLine(false)
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetRowSeparator("")
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetNoWhiteSpace(true)
+	table.AppendBulk(data)
+	table.Render()
+}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/owenthereal/upterm/server"
+	"github.com/owenthereal/upterm/utils"
+	"github.com/spf13/cobra"
+)
+
+func fingerprintsCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "fingerprints",
+		Short: "Display the fingerprints of the host keys",
+		Long: `Display the fingerprints of the host keys of uptermd, so that they can be published for hosts and clients to
+verify the server, e.g. as SSHFP records in DNS. It reads the same flags, environment variables, and config file as
+uptermd, and lists the keys for each hostname of the server.`,
+		Example: `  # Display the fingerprints like ssh-keygen -l:
+  uptermd fingerprints --private-key /etc/uptermd/ssh_host_ed25519_key --hostname uptermd.upterm.dev
+
+  # Display the SSHFP records to publish in DNS:
+  uptermd fingerprints --format sshfp`,
+		RunE: func(c *cobra.Command, args []string) error {
+			var opt server.Opt
+			if err := unmarshalFlags(c, &opt); err != nil {
+				return err
+			}
+
+			fps, err := server.HostKeyFingerprints(opt)
+			if err != nil {
+				return err
+			}
+
+			return utils.WriteHostKeyFingerprints(c.OutOrStdout(), format, fps)
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", utils.FingerprintFormatOpenSSH, fmt.Sprintf("output format (%s)", strings.Join(utils.FingerprintFormats, ", ")))
+
+	return cmd
+}
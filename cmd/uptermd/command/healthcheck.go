@@ -0,0 +1,55 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/owenthereal/upterm/server"
+	"github.com/spf13/cobra"
+)
+
+func healthcheckCmd() *cobra.Command {
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check the health of a running uptermd",
+		Long: `Check the health of a running uptermd by reading the identification of its SSH server. It reads the
+same flags, environment variables, and config file as uptermd, so that it checks the address the server listens on.
+It exits with a non-zero status if the server is unhealthy, which suits container healthchecks, e.g. Docker HEALTHCHECK.`,
+		Example: `  # Check the server listening on the default address:
+  uptermd healthcheck
+
+  # Check the server of a container started with UPTERMD_SSH_ADDR=0.0.0.0:2222:
+  HEALTHCHECK CMD ["uptermd", "healthcheck"]`,
+		RunE: func(c *cobra.Command, args []string) error {
+			var opt server.Opt
+			if err := unmarshalFlags(c, &opt); err != nil {
+				return err
+			}
+
+			addr := opt.SSHAddr
+			if opt.MuxAddr != "" {
+				addr = opt.MuxAddr
+			}
+			if addr == "" {
+				return fmt.Errorf("must specify a ssh address")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := server.CheckHealth(ctx, addr); err != nil {
+				return fmt.Errorf("unhealthy server at %s: %w", addr, err)
+			}
+
+			_, err := fmt.Fprintf(c.OutOrStdout(), "healthy server at %s\n", addr)
+			return err
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "timeout of the check")
+
+	return cmd
+}
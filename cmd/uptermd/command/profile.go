@@ -0,0 +1,49 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const hardenedMaxSessionAge = 8 * time.Hour
+
+// profiles are curated defaults of options keyed by profile names.
+var profiles = map[string]map[string]interface{}{
+	"hardened": {
+		"strict-crypto":           true,
+		"require-authorized-keys": true,
+		"max-session-age":         hardenedMaxSessionAge,
+	},
+}
+
+// applyProfile sets the options of the profile as defaults, so that options set with flags,
+// environment variables, or the config file override them.
+func applyProfile(v *viper.Viper, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	defaults, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unsupported profile %q, expect one of %v", name, profileNames())
+	}
+
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	return nil
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
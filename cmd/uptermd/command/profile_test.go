@@ -0,0 +1,60 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/server"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_applyProfile(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want server.Opt
+	}{
+		{
+			name: "no profile",
+			args: nil,
+			want: server.Opt{},
+		},
+		{
+			name: "hardened",
+			args: []string{"--profile", "hardened"},
+			want: server.Opt{Profile: "hardened", StrictCrypto: true, RequireAuthorizedKeys: true, MaxSessionAge: hardenedMaxSessionAge},
+		},
+		{
+			name: "hardened with overrides",
+			args: []string{"--profile", "hardened", "--max-session-age", "1h", "--require-authorized-keys=false"},
+			want: server.Opt{Profile: "hardened", StrictCrypto: true, MaxSessionAge: time.Hour},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := Root(log.New())
+			if err := cmd.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			var opt server.Opt
+			if err := unmarshalFlags(cmd, &opt); err != nil {
+				t.Fatal(err)
+			}
+
+			if opt.Profile != c.want.Profile || opt.StrictCrypto != c.want.StrictCrypto ||
+				opt.RequireAuthorizedKeys != c.want.RequireAuthorizedKeys || opt.MaxSessionAge != c.want.MaxSessionAge {
+				t.Fatalf("want %+v, got %+v", c.want, opt)
+			}
+		})
+	}
+
+	cmd := Root(log.New())
+	if err := cmd.ParseFlags([]string{"--profile", "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := unmarshalFlags(cmd, &server.Opt{}); err == nil {
+		t.Fatal("want error for unsupported profile")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/utils"
@@ -23,18 +24,98 @@ func Root(logger log.FieldLogger) *cobra.Command {
 
 	cmd.PersistentFlags().String("config", "", "server config")
 
-	cmd.PersistentFlags().StringP("ssh-addr", "", utils.DefaultLocalhost("2222"), "ssh server address")
-	cmd.PersistentFlags().StringP("ws-addr", "", "", "websocket server address")
-	cmd.PersistentFlags().StringP("node-addr", "", "", "node address")
+	cmd.PersistentFlags().StringP("ssh-addr", "", utils.DefaultLocalhost("2222"), "ssh server address. A unix domain socket, e.g. unix:///run/uptermd.sock, or a socket passed by systemd socket activation, e.g. systemd:uptermd-ssh.socket named by its FileDescriptorName=, is listened on too. systemd: selects the only socket passed.")
+	cmd.PersistentFlags().StringP("ws-addr", "", "", "websocket server address. Like --ssh-addr, it can be a unix:// or systemd: socket.")
+	cmd.PersistentFlags().StringP("mux-addr", "", "", "address serving both ssh and websocket on a single port, e.g. :443 for restrictive networks. The protocol of connections is detected from their first bytes. --ssh-addr and --ws-addr are ignored if it's set. Like --ssh-addr, it can be a unix:// or systemd: socket.")
+	cmd.PersistentFlags().StringP("node-addr", "", "", "node address. Defaults to the tcp address of --ssh-addr or --ws-addr, and is required when they are unix:// or systemd: sockets that aren't tcp.")
 	cmd.PersistentFlags().StringSliceP("private-key", "", nil, "server private key")
 	cmd.PersistentFlags().StringSliceP("hostname", "", nil, "server hostname for public-key authentication certificate principals. If empty, public-key authentication is used instead.")
+	cmd.PersistentFlags().StringSliceP("sshd-private-key", "", nil, "host key of the internal sshd hosts create sessions on, separate from --private-key of the proxy facing hosts and clients, so that a compromised key is contained to one component and each can be rotated on its own. Defaults to --private-key. Nodes of a cluster must share it.")
+	cmd.PersistentFlags().StringSliceP("user-ca-key", "", nil, "private key of the CA signing the user certificates the proxy authenticates with to the internal sshd, neighbour nodes, and hosts. The first key signs and all are trusted, so that a new CA can be rolled out before the old one is removed. Certificates of neighbour nodes not signed by it are rejected. Defaults to --private-key. Nodes of a cluster must share it.")
 
 	cmd.PersistentFlags().StringP("network", "", "mem", "network provider")
 	cmd.PersistentFlags().StringSliceP("network-opt", "", nil, "network provider option")
 
-	cmd.PersistentFlags().StringP("metric-addr", "", "", "metric server address")
+	cmd.PersistentFlags().StringSliceP("session-alias", "", nil, "vanity hostname of a session in the form of HOSTNAME=SESSION_ID[@NODE_ADDR]. WebSocket requests to the hostname are routed to the session.")
+
+	cmd.PersistentFlags().IntP("join-attempts-per-key", "", 0, "max join attempts per minute by a client public key. 0 means unlimited.")
+	cmd.PersistentFlags().IntP("join-attempts-per-session", "", 0, "max join attempts per minute to a session. 0 means unlimited.")
+	cmd.PersistentFlags().IntP("max-conns-per-ip", "", 0, "max connections per minute from an IP to the ssh and the websocket proxies. Connections over it are closed right away. Connections from the node itself and neighbour nodes routing clients aren't limited. Behind load balancers that don't preserve the IPs of clients, e.g. without the PROXY protocol, clients share the IPs of the load balancers. 0 means unlimited.")
+	cmd.PersistentFlags().DurationP("auth-failure-ban", "", 0, "ban IPs whose ssh connections fail to authenticate --max-auth-failures-per-ip times within the duration for the duration, e.g. clients scanning keys or session IDs. Banned IPs are refused by the ssh and the websocket proxies. 0 disables banning.")
+	cmd.PersistentFlags().IntP("max-auth-failures-per-ip", "", 10, "failed ssh authentications within --auth-failure-ban before an IP is banned")
+	cmd.PersistentFlags().StringSliceP("allow-cidr", "", nil, "CIDR, e.g. 10.8.0.0/16 of a VPN, or IP allowed to connect to the ssh and the websocket proxies. Connections from other IPs may only authenticate to the ssh proxy as neighbour nodes routing clients, with certs signed by the nodes. Connections from the node itself and over unix sockets aren't filtered. Can be repeated. Allows all IPs if empty.")
+	cmd.PersistentFlags().StringSliceP("deny-cidr", "", nil, "CIDR or IP denied connecting to the ssh and the websocket proxies, which takes precedence over --allow-cidr. Can be repeated.")
+	cmd.PersistentFlags().IntP("join-concurrency-per-key", "", 0, "max concurrent joins by a client public key. 0 means unlimited.")
+
+	cmd.PersistentFlags().StringP("policy-file", "", "", "policy document hosts must agree to before creating sessions, e.g. no customer data on shared sessions. Hosts agree interactively or with 'upterm host --agree-policy HASH'.")
+
+	cmd.PersistentFlags().StringP("shadow-addr", "", "", "ssh address of a canary uptermd. Handshakes of incoming connections are mirrored to it and compared with this node without affecting the connections.")
+	cmd.PersistentFlags().Float64P("shadow-rate", "", 1, "fraction of connections mirrored to --shadow-addr, between 0 and 1")
+
+	cmd.PersistentFlags().DurationP("node-eviction-grace", "", time.Minute, "how long a neighbour node this node routes clients to fails health checks before it's evicted. Clients of sessions on evicted nodes are rejected without dialing them. 0 disables evicting nodes.")
+
+	cmd.PersistentFlags().DurationP("max-session-age", "", 0, "end sessions after the duration since they're created, e.g. 8h, regardless of the duration configured by hosts. Hosts and clients are warned 10 minutes before. 0 means unlimited.")
+
+	cmd.PersistentFlags().DurationP("session-idle-timeout", "", 0, "end sessions without connected clients for the duration, e.g. 1h. Hosts are told why their sessions ended. 0 means unlimited.")
+	cmd.PersistentFlags().DurationP("session-resume-grace", "", time.Minute, "keep the sessions of hosts whose connections drop, e.g. on network blips, for the duration, so that the hosts reconnect and resume them with the same session IDs. Clients reconnect once the host has. Hosts must reconnect to the same node. 0 ends sessions with the connections of their hosts.")
+
+	cmd.PersistentFlags().DurationP("ws-coalesce-delay", "", 2*time.Millisecond, "coalesce small writes to WebSocket connections for up to the duration, sending fewer frames for chatty TUIs. Writes after a pause, like echoed keystrokes, are sent immediately. 0 disables it.")
+	cmd.PersistentFlags().StringP("ws-tls-cert", "", "", "PEM certificate serving wss:// on --ws-addr or --mux-addr without a reverse proxy terminating TLS. Requires --ws-tls-key.")
+	cmd.PersistentFlags().StringP("ws-tls-key", "", "", "PEM private key of --ws-tls-cert")
+	cmd.PersistentFlags().StringSliceP("ws-tls-domain", "", nil, "domain to obtain a certificate for from Let's Encrypt, serving wss:// on --ws-addr or --mux-addr. The address must be reachable on port 443 of the domain for the ACME TLS-ALPN challenge. Can't be used with --ws-tls-cert. Can be repeated.")
+	cmd.PersistentFlags().StringP("ws-tls-cache-dir", "", "", "directory the certificates of --ws-tls-domain are cached in across restarts. Defaults to uptermd/autocert in the user cache directory.")
+
+	cmd.PersistentFlags().StringP("relay-core", "", server.RelayCoreGoroutine, fmt.Sprintf("core relaying the data of WebSocket connections and of clients to hosts (%s). netpoll waits for idle sockets with epoll instead of a goroutine and a buffer per direction, saving memory with many idle sessions. It's only supported on linux.", strings.Join(server.RelayCores, ", ")))
+
+	cmd.PersistentFlags().StringSliceP("tcp-opt", "", nil, fmt.Sprintf("socket option of the tcp connections of hosts and clients, and to neighbour nodes, in the form of KEY=VALUE (%s), e.g. sndbuf=4194304,rcvbuf=4194304,congestion=bbr for long fat networks. Defaults to nodelay=true and keepalive=15s. Buffer sizes are in bytes. congestion is only supported on linux, where unprivileged processes can pick the algorithms in net.ipv4.tcp_allowed_congestion_control. Connections failing to be tuned are counted in tcp_tuning_error_count.", strings.Join(utils.TCPOpts, ", ")))
+
+	cmd.PersistentFlags().BoolP("strict-crypto", "", false, "only negotiate modern key exchanges, AEAD ciphers, and public keys with non-SHA-1 signatures with hosts and clients. Older clients fail to connect.")
+	cmd.PersistentFlags().BoolP("require-authorized-keys", "", false, "refuse to create sessions for hosts that let any client join, e.g. hosts must run 'upterm host --github-user' or '--authorized-keys'.")
+	cmd.PersistentFlags().StringP("profile", "", "", fmt.Sprintf("apply curated defaults (%s). hardened enables --strict-crypto and --require-authorized-keys, and sets --max-session-age to %s. Options set explicitly override the profile. The effective policy is logged at startup.", strings.Join(profileNames(), ", "), hardenedMaxSessionAge))
+
+	cmd.PersistentFlags().IntP("host-max-sessions", "", 0, "max concurrent sessions of a host key on the node, so that a host doesn't exhaust a shared node. Hosts authenticating with certs are limited by the keys of their certs. 0 means unlimited.")
+	cmd.PersistentFlags().IntP("host-max-clients", "", 0, "max clients connected to a session at once, which the max-clients of --host-acl-file lowers. 0 means unlimited.")
+	cmd.PersistentFlags().DurationP("host-max-session-age", "", 0, "end the sessions of a host after the duration since they're created, which --max-session-age lowers. 0 means unlimited.")
+	cmd.PersistentFlags().StringP("host-quota-file", "", "", "YAML file of the quotas of hosts by the SHA256 fingerprints of their keys, e.g. 'hosts: {SHA256:...: {max-sessions: 10, max-clients: 20, max-session-age: 8h}}'. Its default quota, e.g. 'default: {max-sessions: 2}', replaces --host-max-sessions, --host-max-clients, and --host-max-session-age. The quota of a host replaces the default quota.")
+	cmd.PersistentFlags().StringP("host-acl-file", "", "", "authorized_keys file of host keys, or of CAs with the cert-authority option, whose options are the features granted to their sessions: max-clients=N, sftp, port-forwarding, recording-opt-out, and labels=\"KEY=VALUE,...\". A line of '@default OPTIONS' grants hosts matching no key; without it, they can't create sessions. Hosts turn off the features they aren't granted.")
+
+	cmd.PersistentFlags().StringSliceP("peer", "", nil, "metric server URL of another node in the cluster, e.g. http://10.0.0.2:9090")
+	cmd.PersistentFlags().StringP("require-min-version-peers", "", "", "refuse to start if a reachable --peer runs an uptermd version older than this one, e.g. 0.14.0, or too old to report its version. It prevents accidentally joining a cluster of incompatible nodes.")
+
+	cmd.PersistentFlags().StringP("identity-file", "", "", "lookup file of client display names, one per line in the form of FINGERPRINT NAME or an authorized_keys line commented with the name. Names are logged and passed on to hosts instead of bare fingerprints.")
+	cmd.PersistentFlags().StringP("identity-command", "", "", "command looking up the display name of a client key, like AuthorizedKeysCommand of OpenSSH, e.g. a script querying LDAP. %f, %k, and %t are expanded to the fingerprint, the base64-encoded key, and the key type. It prints the name on the first line. It runs if --identity-file doesn't know the key.")
+	cmd.PersistentFlags().StringP("authz-webhook", "", "", "URL of a policy hook deciding whether clients may join sessions, e.g. by time windows or on-call checks, after their keys are authorized by the hosts. The session ID, host user, and labels, and the fingerprint, IP, and display name of the client are POSTed as JSON, and it responds with {\"allow\": true|false, \"reason\": ...}. The reason is shown to denied clients. Clients are denied if the hook fails or doesn't respond in 1.5 seconds.")
+	cmd.PersistentFlags().StringP("authz-command", "", "", "command deciding whether clients may join sessions, like --authz-webhook. It exits with zero to allow and non-zero to deny, printing the reason. %s, %f, %a, and %u are expanded to the session ID, the fingerprint and the IP of the client, and the host user. Can't be used with --authz-webhook.")
+
+	cmd.PersistentFlags().StringP("admin-addr", "", "", "admin API address listing and killing the sessions of this node, and capturing their debug logs, over gRPC and JSON/HTTP, e.g. GET /v1/sessions and DELETE /v1/sessions/ID. Bind it to a private network or a unix:// socket. Requires --admin-token.")
+	cmd.PersistentFlags().StringP("admin-token", "", "", "bearer token clients of the admin API authenticate with. Prefer setting it with the UPTERMD_ADMIN_TOKEN environment variable.")
+	cmd.PersistentFlags().StringP("log-capture-dir", "", "", "directory the admin API captures the debug logs of a session or a component to for a while, e.g. POST /v1/log-captures with {\"session_id\": \"ID\", \"duration_seconds\": 600}, without logging at debug globally. Each capture is capped at 64MiB and lasts at most an hour. Defaults to uptermd-log-captures in the temp directory.")
+
+	cmd.PersistentFlags().StringP("memory-budget", "", "", "memory usage of the process, e.g. 2GiB or 1500MB, above which new sessions are refused until it falls below 90% of it, preventing OOM kills that end all sessions at once. Unlimited if empty.")
+	cmd.PersistentFlags().BoolP("memory-evict-idle", "", false, "also end the oldest session without connected clients every 5 seconds while --memory-budget is exceeded. Hosts are told why their sessions ended.")
+	cmd.PersistentFlags().IntP("max-sessions", "", 0, "max sessions of the node. Further hosts are refused, or wait in the session queue if they ask to with 'upterm host --queue'. Unlimited if 0.")
+	cmd.PersistentFlags().IntP("session-queue-size", "", 0, "max hosts waiting in line for the node to have capacity, at --max-sessions or above --memory-budget, instead of being refused. Hosts are let in first come, first served and told their positions. Hosts not asking to wait are refused while others wait. Disabled if 0.")
+
+	cmd.PersistentFlags().StringP("metric-addr", "", "", "metric server address. It also serves the health of the node on /healthz and /readyz.")
+	cmd.PersistentFlags().StringP("health-addr", "", "", "address serving the health of the node on /healthz and /readyz as JSON, e.g. for load balancer health checks without exposing metrics: the node address, the version, how clients are routed to neighbour nodes, the numbers of sessions, clients, and queued hosts, memory pressure, and evicted neighbour nodes. /readyz responds with 503 while the node starts or shuts down. Disabled if empty.")
+	cmd.PersistentFlags().StringP("otel-endpoint", "", "", "OTLP/HTTP endpoint of an OpenTelemetry collector spans of client joins, routing between nodes, and sessions are exported to, e.g. http://localhost:4318. It can be set with the UPTERMD_OTEL_ENDPOINT environment variable. Tracing is disabled if it's empty.")
+
+	cmd.PersistentFlags().StringP("audit-log", "", "", "where to write a structured audit log of sessions created and closed, and clients authenticated and rejected with their key fingerprints, as JSON lines: stdout, a file path, or an http(s) webhook URL events are POSTed to. Disabled if empty.")
+	cmd.PersistentFlags().StringP("audit-log-max-size", "", "", "size, e.g. 100MiB, an audit log file is rotated at. Rotated logs are named after the time they're rotated at, e.g. audit.log.20240521T123000.000000000Z, and the oldest are removed for them to total at most the size too. Unlimited if empty.")
+	cmd.PersistentFlags().DurationP("audit-log-max-age", "", 0, "how long events are kept in audit log files, e.g. 720h. A log is rotated once its first event is older than the duration, and rotated logs are removed once their last events are. 0 means unlimited.")
+	cmd.PersistentFlags().StringP("notify-slack-webhook", "", "", "incoming webhook URL of a Slack channel the sessions created and closed on the node are posted to, with their host users, host key fingerprints, and labels. Set it with $UPTERMD_NOTIFY_SLACK_WEBHOOK to keep it out of the process list. Sessions of hosts granted to opt out of recording aren't posted.")
+	cmd.PersistentFlags().StringP("notify-discord-webhook", "", "", "webhook URL of a Discord channel the sessions created and closed on the node are posted to, like --notify-slack-webhook. Set it with $UPTERMD_NOTIFY_DISCORD_WEBHOOK.")
+
+	cmd.PersistentFlags().StringP("telnet-addr", "", "", "address of an insecure telnet-over-TLS bridge letting legacy tools, e.g. screen-sharing appliances, watch sessions read-only, e.g. :2323. Viewers log in with the username clients join a session with, which carries a share link invite unless the session lets any client join. Only sessions of hosts running 'upterm host --allow-telnet' are relayed. The node decrypts the sessions it relays, so viewers aren't end-to-end encrypted with hosts. Requires --telnet-tls-cert and --telnet-tls-key.")
+	cmd.PersistentFlags().StringP("telnet-tls-cert", "", "", "PEM certificate of the telnet bridge")
+	cmd.PersistentFlags().StringP("telnet-tls-key", "", "", "PEM private key of the telnet bridge")
+
 	cmd.PersistentFlags().BoolP("debug", "", os.Getenv("DEBUG") != "", "debug")
 
+	cmd.AddCommand(topologyCmd())
+	cmd.AddCommand(healthcheckCmd())
+	cmd.AddCommand(fingerprintsCmd())
+
 	return cmd
 }
 
@@ -81,5 +162,9 @@ func unmarshalFlags(cmd *cobra.Command, opts interface{}) error {
 		}
 	}
 
+	if err := applyProfile(v, v.GetString("profile")); err != nil {
+		return err
+	}
+
 	return v.Unmarshal(opts)
 }
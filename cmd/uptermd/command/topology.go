@@ -0,0 +1,100 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/owenthereal/upterm/server"
+	"github.com/spf13/cobra"
+)
+
+func topologyCmd() *cobra.Command {
+	var (
+		nodes   []string
+		format  string
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "topology",
+		Short: "Display the cluster topology",
+		Long: `Display the cluster topology. It queries the metric server of each node for the sessions hosted on the node
+and the connections the node routed to neighbour nodes in the last 15 minutes. Nodes must run with --metric-addr.`,
+		Example: `  # Display the nodes, their sessions and routes:
+  uptermd topology --node http://10.0.0.1:9090 --node http://10.0.0.2:9090
+
+  # Render the topology with Graphviz:
+  uptermd topology --node http://10.0.0.1:9090 --node http://10.0.0.2:9090 --format dot | dot -Tpng -o topology.png`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(nodes) == 0 {
+				return fmt.Errorf("missing flag --node")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			t := server.FetchTopology(ctx, http.DefaultClient, nodes)
+
+			switch format {
+			case "dot":
+				return t.WriteDOT(os.Stdout)
+			case "table":
+				displayTopology(t)
+				return nil
+			default:
+				return fmt.Errorf("unsupported format %q", format)
+			}
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&nodes, "node", nil, "metric server URL of a node, e.g. http://10.0.0.1:9090 (required)")
+	cmd.Flags().StringVar(&format, "format", "table", "output format: table or dot")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Second, "timeout of querying the nodes")
+
+	return cmd
+}
+
+func displayTopology(t *server.Topology) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Node", "Version", "Sessions", "Routes To", "Count", "Last Seen"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetRowSeparator("")
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("  ")
+
+	for _, n := range t.Nodes {
+		if n.Error != "" {
+			table.Append([]string{n.NodeAddr, "", "unreachable: " + n.Error, "", "", ""})
+			continue
+		}
+
+		sessions, version := strconv.Itoa(n.Sessions), n.Version
+		if version == "" {
+			version = "unknown"
+		}
+		if len(n.Routes) == 0 {
+			table.Append([]string{n.NodeAddr, version, sessions, "-", "", ""})
+			continue
+		}
+
+		for i, r := range n.Routes {
+			node := n.NodeAddr
+			if i > 0 {
+				node, version, sessions = "", "", ""
+			}
+			table.Append([]string{node, version, sessions, r.To, strconv.Itoa(r.Count), r.LastSeen.Local().Format(time.RFC3339)})
+		}
+	}
+
+	table.Render()
+}
@@ -0,0 +1,213 @@
+// Package events defines the typed session events emitted by upterm.
+//
+// Events are plain Go structs identified by a Kind. They are published on an
+// emitter under their kind topic and can be serialized into a versioned
+// Envelope for consumers outside of the process, e.g. hooks, audit logs and
+// exporters. New kinds can be added without breaking existing consumers since
+// unknown kinds are reported with ErrUnknownKind instead of failing decoding
+// of the envelope itself.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+// Version is the version of the envelope format.
+const Version = 1
+
+var (
+	ErrUnknownKind = errors.New("events: unknown kind")
+)
+
+type Kind string
+
+const (
+	KindClientJoined    Kind = "client-joined"
+	KindClientLeft      Kind = "client-left"
+	KindReadOnlyChanged Kind = "read-only-changed"
+	KindPrivacyChanged  Kind = "privacy-changed"
+	KindSessionExpiring Kind = "session-expiring"
+	KindClientApproval  Kind = "client-approval"
+	KindSessionIdle     Kind = "session-idle"
+	KindSessionCreated  Kind = "session-created"
+	KindSessionEnded    Kind = "session-ended"
+	KindClientInput     Kind = "client-input"
+)
+
+type Event interface {
+	Kind() Kind
+}
+
+type ClientJoined struct {
+	Client *api.Client `json:"client"`
+}
+
+func (ClientJoined) Kind() Kind { return KindClientJoined }
+
+type ClientLeft struct {
+	Client *api.Client `json:"client"`
+}
+
+func (ClientLeft) Kind() Kind { return KindClientLeft }
+
+type ReadOnlyChanged struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+func (ReadOnlyChanged) Kind() Kind { return KindReadOnlyChanged }
+
+// PrivacyChanged is emitted when the host starts or stops typing privately.
+// The output of the session is hidden from clients while it's private.
+type PrivacyChanged struct {
+	Private bool `json:"private"`
+}
+
+func (PrivacyChanged) Kind() Kind { return KindPrivacyChanged }
+
+// SessionExpiring is emitted when the session is about to reach the max session age of the server,
+// which ends it at ExpiresAt.
+type SessionExpiring struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (SessionExpiring) Kind() Kind { return KindSessionExpiring }
+
+// SessionIdle is emitted when neither the host nor clients have typed for almost the idle timeout of the session,
+// which ends it at EndsAt unless someone types.
+type SessionIdle struct {
+	EndsAt time.Time `json:"ends_at"`
+}
+
+func (SessionIdle) Kind() Kind { return KindSessionIdle }
+
+// SessionCreated is emitted when the session is created on the server Host.
+type SessionCreated struct {
+	SessionID string   `json:"session_id"`
+	Host      string   `json:"host"`
+	Command   []string `json:"command,omitempty"`
+}
+
+func (SessionCreated) Kind() Kind { return KindSessionCreated }
+
+// SessionEnded is emitted when the session ends, with its statistics.
+type SessionEnded struct {
+	SessionID string            `json:"session_id"`
+	Stats     *api.SessionStats `json:"stats"`
+}
+
+func (SessionEnded) Kind() Kind { return KindSessionEnded }
+
+// Statuses of ClientApproval.
+const (
+	ApprovalRequested = "requested"
+	ApprovalApproved  = "approved"
+	ApprovalDenied    = "denied"
+)
+
+// ClientApproval is emitted when the approval of a client joining a labeled session is requested and when it's
+// decided, recording who approved or denied it for audits.
+type ClientApproval struct {
+	Client   *api.Client       `json:"client"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Status   string            `json:"status"`
+	Approver string            `json:"approver,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+}
+
+func (ClientApproval) Kind() Kind { return KindClientApproval }
+
+// ClientInput is emitted when a client starts a burst of input into the terminal of the host, i.e. when it types
+// after the host or another client did or after a pause. The input that follows until the next ClientInput is
+// attributed to the client.
+type ClientInput struct {
+	Client *api.Client `json:"client"`
+}
+
+func (ClientInput) Kind() Kind { return KindClientInput }
+
+var decoders = map[Kind]func(json.RawMessage) (Event, error){
+	KindClientJoined:    decode[ClientJoined],
+	KindClientLeft:      decode[ClientLeft],
+	KindReadOnlyChanged: decode[ReadOnlyChanged],
+	KindPrivacyChanged:  decode[PrivacyChanged],
+	KindSessionExpiring: decode[SessionExpiring],
+	KindClientApproval:  decode[ClientApproval],
+	KindSessionIdle:     decode[SessionIdle],
+	KindSessionCreated:  decode[SessionCreated],
+	KindSessionEnded:    decode[SessionEnded],
+	KindClientInput:     decode[ClientInput],
+}
+
+func decode[T Event](b json.RawMessage) (Event, error) {
+	var e T
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Envelope is the serializable form of an event.
+type Envelope struct {
+	Version int             `json:"version"`
+	Kind    Kind            `json:"kind"`
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func NewEnvelope(e Event, t time.Time) (*Envelope, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling event %s: %w", e.Kind(), err)
+	}
+
+	return &Envelope{
+		Version: Version,
+		Kind:    e.Kind(),
+		Time:    t,
+		Payload: b,
+	}, nil
+}
+
+// Event decodes the payload of the envelope into a typed event.
+// It returns ErrUnknownKind if the kind is not known to this version of upterm.
+func (e *Envelope) Event() (Event, error) {
+	dec, ok := decoders[e.Kind]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, e.Kind)
+	}
+
+	return dec(e.Payload)
+}
+
+// Emit publishes the event on the emitter under its kind.
+func Emit(em *emitter.Emitter, e Event) {
+	em.Emit(string(e.Kind()), e)
+}
+
+// On subscribes to events of the given kind.
+func On(em *emitter.Emitter, kind Kind, middlewares ...func(*emitter.Event)) <-chan emitter.Event {
+	return em.On(string(kind), middlewares...)
+}
+
+// Off unsubscribes from events of the given kind.
+func Off(em *emitter.Emitter, kind Kind, chs ...<-chan emitter.Event) {
+	em.Off(string(kind), chs...)
+}
+
+// From extracts the typed event from an emitter event.
+// It returns nil if the emitter event does not carry one.
+func From(evt emitter.Event) Event {
+	if len(evt.Args) == 0 {
+		return nil
+	}
+
+	e, _ := evt.Args[0].(Event)
+	return e
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/host/api"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func Test_Envelope(t *testing.T) {
+	at := time.Date(2024, 5, 21, 0, 0, 0, 0, time.UTC)
+	cases := []Event{
+		ClientJoined{Client: &api.Client{Id: "1", Version: "SSH-2.0-Go", Addr: "127.0.0.1:22", PublicKeyFingerprint: "SHA256:foo"}},
+		ClientLeft{Client: &api.Client{Id: "1"}},
+		ReadOnlyChanged{ReadOnly: true},
+		PrivacyChanged{Private: true},
+		SessionExpiring{ExpiresAt: at.Add(10 * time.Minute)},
+		ClientApproval{Client: &api.Client{Id: "1"}, Labels: map[string]string{"env": "prod"}, Status: ApprovalApproved, Approver: "alice"},
+		SessionIdle{EndsAt: at.Add(time.Minute)},
+		SessionCreated{SessionID: "session", Host: "ssh://uptermd.upterm.dev:22", Command: []string{"bash"}},
+		SessionEnded{SessionID: "session", Stats: &api.SessionStats{BytesIn: 1, PeakClients: 2, DurationSeconds: 60}},
+		ClientInput{Client: &api.Client{Id: "1", PublicKeyFingerprint: "SHA256:foo"}},
+	}
+
+	for _, c := range cases {
+		cc := c
+		t.Run(string(cc.Kind()), func(t *testing.T) {
+			env, err := NewEnvelope(cc, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got Envelope
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(Version, got.Version); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if diff := cmp.Diff(at, got.Time); diff != "" {
+				t.Fatal(diff)
+			}
+
+			e, err := got.Event()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(cc, e, protocmp.Transform()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func Test_Envelope_UnknownKind(t *testing.T) {
+	env := &Envelope{
+		Version: Version,
+		Kind:    "from-the-future",
+		Payload: json.RawMessage(`{}`),
+	}
+
+	if _, err := env.Event(); !errors.Is(err, ErrUnknownKind) {
+		t.Fatalf("expect unknown kind error but got %v", err)
+	}
+}
+
+func Test_EmitOn(t *testing.T) {
+	em := emitter.New(1)
+	ch := On(em, KindClientJoined)
+	defer Off(em, KindClientJoined, ch)
+
+	want := ClientJoined{Client: &api.Client{Id: "1"}}
+	go Emit(em, want)
+
+	select {
+	case evt := <-ch:
+		if diff := cmp.Diff(Event(want), From(evt), protocmp.Transform()); diff != "" {
+			t.Fatal(diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event is not received")
+	}
+}
@@ -0,0 +1,93 @@
+package ftests
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/ftests/scenarios"
+	"golang.org/x/crypto/ssh"
+)
+
+// testBenchmarks runs the benchmarks with little traffic, verifying that they count bytes exactly.
+func testBenchmarks(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	env := scenarios.Env{
+		HostURL:         hostShareURL,
+		ClientURL:       clientJoinURL,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	opts := scenarios.BenchOptions{Keystrokes: 40, BulkBytes: 256 << 10}
+
+	for _, b := range scenarios.Benchmarks {
+		m, err := scenarios.RunBenchmark(context.Background(), env, b, opts, 10*time.Second)
+		if err != nil {
+			t.Errorf("benchmark %s failed: %s", b.Name, err)
+			continue
+		}
+
+		want := opts.BulkBytes
+		if b.Name == "interactive" {
+			// 39 echoed keys and an Enter echoed as CRLF and followed by the line of 32 keys
+			want = 39 + 2 + 32 + 2
+			if len(m.Latencies) != 39 {
+				t.Errorf("benchmark %s: want 39 latencies, got %d", b.Name, len(m.Latencies))
+			}
+		}
+		if m.Bytes != want {
+			t.Errorf("benchmark %s: want %d bytes, got %d", b.Name, want, m.Bytes)
+		}
+	}
+}
+
+// Benchmark_transports compares the ssh and WebSocket transports on a single node and across nodes:
+//
+//	go test ./ftests -run '^$' -bench transports
+func Benchmark_transports(b *testing.B) {
+	paths := []struct {
+		name      string
+		hostURL   string
+		clientURL string
+	}{
+		{"ssh/singleNode", "ssh://" + ts1.SSHAddr(), "ssh://" + ts1.SSHAddr()},
+		{"ws/singleNode", "ws://" + ts1.WSAddr(), "ws://" + ts1.WSAddr()},
+		{"ssh/multiNodes", "ssh://" + ts1.SSHAddr(), "ssh://" + ts2.SSHAddr()},
+		{"ws/multiNodes", "ws://" + ts1.WSAddr(), "ws://" + ts2.WSAddr()},
+	}
+
+	for _, p := range paths {
+		for _, bench := range scenarios.Benchmarks {
+			b.Run(p.name+"/"+bench.Name, func(b *testing.B) {
+				env := scenarios.Env{
+					HostURL:         p.hostURL,
+					ClientURL:       p.clientURL,
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				}
+
+				var (
+					bytes     int64
+					duration  time.Duration
+					latencies []time.Duration
+				)
+				for i := 0; i < b.N; i++ {
+					m, err := scenarios.RunBenchmark(context.Background(), env, bench, scenarios.DefaultBenchOptions, time.Minute)
+					if err != nil {
+						b.Fatal(err)
+					}
+					bytes += m.Bytes
+					duration += m.Duration
+					latencies = append(latencies, m.Latencies...)
+				}
+
+				// sessions are set up in every iteration, so the measurements of the traffic are reported instead of ns/op
+				m := scenarios.Measurement{Bytes: bytes, Duration: duration, Latencies: latencies}
+				slices.Sort(m.Latencies)
+				b.ReportMetric(m.Throughput()/(1<<20), "MiB/s")
+				if len(m.Latencies) > 0 {
+					b.ReportMetric(float64(m.Latency(0.5).Microseconds()), "p50-µs")
+					b.ReportMetric(float64(m.Latency(0.99).Microseconds()), "p99-µs")
+				}
+			})
+		}
+	}
+}
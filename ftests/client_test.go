@@ -1,17 +1,34 @@
 package ftests
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/owenthereal/upterm/approval"
 	"github.com/owenthereal/upterm/host"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+	"github.com/owenthereal/upterm/server"
+	"github.com/owenthereal/upterm/upterm"
+	"github.com/owenthereal/upterm/utils"
+	"github.com/pkg/sftp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
 )
 
 func testHostNoAuthorizedKeyAnyClientJoin(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
@@ -74,12 +91,26 @@ func testClientAuthorizedKeyNotMatching(t *testing.T, hostShareURL, hostNodeAddr
 
 	err = c.Join(session, clientJoinURL)
 
-	// Unfortunately there is no explicit error to the client.
 	// SSH handshake should fail with the connection closed.
-	// And there should be error like "public key not allowed" in the log
+	// The rejection reason is delivered separately to the client.
 	if want, got := "ssh: handshake failed:", err.Error(); !strings.Contains(got, want) {
 		t.Fatalf("Unexpected error, want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
 	}
+
+	checkRejection(t, c, server.RejectionKeyNotAuthorized)
+}
+
+func checkRejection(t *testing.T, c *Client, want server.RejectionCode) {
+	t.Helper()
+
+	r := c.Rejection()
+	if r == nil {
+		t.Fatalf("expect rejection %s but got none", want)
+	}
+
+	if diff := cmp.Diff(want, r.Code); diff != "" {
+		t.Fatal(diff)
+	}
 }
 
 func testClientNonExistingSession(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
@@ -123,11 +154,12 @@ func testClientNonExistingSession(t *testing.T, hostShareURL, hostNodeAddr, clie
 	session.SessionId = "not-existance" // set session ID to non-existance
 	err = c.Join(session, clientJoinURL)
 
-	// Unfortunately there is no explicit error to the client.
-	// But ssh handshake fails with the connection closed
+	// SSH handshake fails with the connection closed
 	if want, got := "ssh: handshake failed:", err.Error(); !strings.Contains(got, want) {
 		t.Fatalf("Unexpected error, want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
 	}
+
+	checkRejection(t, c, server.RejectionSessionNotFound)
 }
 
 func testClientAttachHostWithSameCommand(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
@@ -342,34 +374,1444 @@ func testClientAttachReadOnly(t *testing.T, hostShareURL, hostNodeAddr, clientJo
 
 }
 
-func getAndVerifySession(t *testing.T, adminSocketFile string, wantHostURL, wantNodeURL string) *api.GetSessionResponse {
-	adminClient, err := host.AdminClient(adminSocketFile)
+func testClientFeatures(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(adminSockDir)
 
-	sess, err := adminClient.GetSession(context.Background(), &api.GetSessionRequest{})
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+		Features: map[string]string{
+			api.FeatureKeepAlive:       "1s",
+			api.FeatureLatencyProbe:    "true",
+			api.FeatureRecordingNotice: "true",
+			api.FeatureCompression:     "zstd",
+			"unknown-feature":          "true",
+		},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the keepalive interval is raised to the minimum, and unsupported or unknown features are declined
+	want := map[string]string{
+		api.FeatureKeepAlive:       "5s",
+		api.FeatureLatencyProbe:    "true",
+		api.FeatureRecordingNotice: "false",
+	}
+	if diff := cmp.Diff(want, c.AgreedFeatures); diff != "" {
+		t.Fatal(diff)
+	}
+
+	payload := []byte("ping")
+	ok, reply, err := c.sshClient.SendRequest(upterm.HostPingRequestType, true, payload)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !ok || string(reply) != string(payload) {
+		t.Fatalf("want ping echoed, got ok=%t reply=%q", ok, reply)
+	}
+}
 
-	checkSessionPayload(t, sess, wantHostURL, wantNodeURL)
+func testClientJoinToken(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
 
-	return sess
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	tokens := host.NewJoinTokens()
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		JoinTokens:               tokens,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	ed, err := routing.NewEncodeDecoder(routing.V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := ed.Encode(session.SessionId, session.NodeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := tokens.Issue(time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+		User:        routing.WithToken(user, token),
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// the token is redeemed
+	c = &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+		User:        routing.WithToken(user, token),
+	}
+	if err := c.Join(session, clientJoinURL); err == nil {
+		c.Close()
+		t.Fatal("want joining with a redeemed token to fail")
+	}
+
+	c = &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+		User:        routing.WithToken(user, "unknown"),
+	}
+	if err := c.Join(session, clientJoinURL); err == nil {
+		c.Close()
+		t.Fatal("want joining with an unknown token to fail")
+	}
 }
 
-func checkSessionPayload(t *testing.T, sess *api.GetSessionResponse, wantHostURL, wantNodeURL string) {
-	if sess.SessionId == "" {
-		t.Fatalf("session ID is empty")
+// testClientJoinInvite joins with a key that isn't authorized using a one-time invite, e.g. of a share link,
+// which both the server and the host redeem.
+func testClientJoinInvite(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
 	}
-	if want, got := wantHostURL, sess.Host; want != got {
-		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	tokens := host.NewJoinTokens()
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		JoinTokens:               tokens,
 	}
-	if want, got := wantNodeURL, sess.NodeAddr; want != got {
-		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	ed, err := routing.NewEncodeDecoder(routing.V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := ed.Encode(session.SessionId, session.NodeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens that aren't invites don't let unauthorized keys in, and aren't redeemed by them
+	token, _, err := tokens.Issue(time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		PrivateKeys: []string{HostPrivateKey},
+		User:        routing.WithToken(user, token),
+	}
+	if err := c.Join(session, clientJoinURL); err == nil {
+		c.Close()
+		t.Fatal("want joining with an unauthorized key and a token that isn't an invite to fail")
+	}
+	if !tokens.Redeem(token, time.Now()) {
+		t.Fatal("want the token left for authorized keys")
+	}
+
+	invite, _, err := tokens.Invite(time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = &Client{
+		PrivateKeys: []string{HostPrivateKey},
+		User:        routing.WithToken(user, invite),
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// the invite is redeemed
+	c = &Client{
+		PrivateKeys: []string{HostPrivateKey},
+		User:        routing.WithToken(user, invite),
+	}
+	if err := c.Join(session, clientJoinURL); err == nil {
+		c.Close()
+		t.Fatal("want joining with a redeemed invite to fail")
 	}
 }
 
-func newAdminSocketDir() (string, error) {
-	return os.MkdirTemp("", "upterm")
+// testClientSFTP transfers files with the host over the sftp subsystem, which is routed like terminal sessions
+// when clients join on a node other than the host's.
+func testClientSFTP(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		SFTP:                     true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sc, err := sftp.NewClient(c.SSHClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	dir := t.TempDir()
+
+	// download
+	want := "from host"
+	if err := os.WriteFile(filepath.Join(dir, "download"), []byte(want), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := sc.Open(filepath.Join(dir, "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("downloaded file mismatched (-want +got):\n%s", diff)
+	}
+
+	// upload
+	want = "from client"
+	f, err = sc.Create(filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("uploaded file mismatched (-want +got):\n%s", diff)
+	}
+}
+
+// testClientCompressedSFTP transfers files over an SFTP channel compressed with zstd, agreed on with the host
+// before the channel is opened.
+func testClientCompressedSFTP(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		SFTP:                     true,
+		TransferCompression:      "fastest",
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	agreed, err := api.NegotiateFeatures(c.SSHClient(), map[string]string{api.FeatureCompression: "zstd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{api.FeatureCompression: api.CompressionZstd}, agreed); diff != "" {
+		t.Fatal(diff)
+	}
+
+	sess, err := c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := api.NewCompressedStream(struct {
+		io.Reader
+		io.WriteCloser
+	}{stdout, stdin}, zstd.SpeedFastest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := sftp.NewClientPipe(cs, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	dir := t.TempDir()
+
+	want := strings.Repeat("compressible ", 1<<12)
+	f, err := sc.Create(filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = sc.Open(filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("transferred file mismatched (-want +got):\n%s", diff)
+	}
+}
+
+// testClientPortForwarding forwards connections of the client to a loopback port of the host with direct-tcpip channels,
+// which are routed like terminal sessions when clients join on a node other than the host's.
+func testClientPortForwarding(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		PortForwarding:           true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	// a local dev server of the host
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn, err := c.SSHClient().Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	want := "hello from client"
+	if _, err := io.WriteString(conn, want); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("forwarded output mismatched (-want +got):\n%s", diff)
+	}
+
+	// destinations outside the host are refused
+	if conn, err := c.SSHClient().Dial("tcp", "192.0.2.1:80"); err == nil {
+		conn.Close()
+		t.Fatal("want forwarding to a destination outside the host refused")
+	}
+}
+
+// testClientForwardedPort forwards connections of the client to a port published by the host of a read-only session,
+// and not to other ports of the host.
+func testClientForwardedPort(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	// a local dev server of the host
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.WriteString(conn, "hello from host")
+			}()
+		}
+	}()
+	port := uint32(ln.Addr().(*net.TCPAddr).Port)
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		ReadOnly:                 true,
+		ForwardedPorts:           []uint32{port},
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if diff := cmp.Diff([]uint32{port}, session.ForwardedPorts); diff != "" {
+		t.Fatalf("forwarded ports mismatched (-want +got):\n%s", diff)
+	}
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn, err := c.SSHClient().Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("hello from host", string(b)); diff != "" {
+		t.Fatalf("forwarded output mismatched (-want +got):\n%s", diff)
+	}
+
+	// ports that aren't published are refused
+	if conn, err := c.SSHClient().Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)+1))); err == nil {
+		conn.Close()
+		t.Fatal("want forwarding to a port that isn't published refused")
+	}
+}
+
+// testClientExec verifies that channel requests other than a terminal reach the host and are answered by it,
+// whichever node the client joins on.
+func testClientExec(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// exec without a terminal is refused by the host
+	sess, err := c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := sess.CombinedOutput("echo hello")
+	sess.Close()
+
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Fatalf("want exit status 1, got %v", err)
+	}
+	if diff := cmp.Diff("PTY is required.\n", string(out)); diff != "" {
+		t.Fatalf("output mismatched (-want +got):\n%s", diff)
+	}
+
+	// sftp isn't enabled by the host
+	sess, err = c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	if err := sess.RequestSubsystem("sftp"); err == nil {
+		t.Fatal("want sftp subsystem to be refused")
+	}
+}
+
+// testClientScp transfers files with the host with the protocol of scp over exec channels, which are routed like
+// terminal sessions when clients join on a node other than the host's.
+func testClientScp(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		Exec:                     true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	dir := t.TempDir()
+
+	// upload
+	sess, err := c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Start("scp -t -- " + dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ack := func() {
+		t.Helper()
+
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(stdout, b); err != nil {
+			t.Fatal(err)
+		}
+		if b[0] != 0 {
+			t.Fatalf("want ack, got %q", b)
+		}
+	}
+
+	want := "from client"
+	ack()
+	if _, err := fmt.Fprintf(stdin, "C0600 %d upload\n", len(want)); err != nil {
+		t.Fatal(err)
+	}
+	ack()
+	if _, err := io.WriteString(stdin, want+"\x00"); err != nil {
+		t.Fatal(err)
+	}
+	ack()
+	stdin.Close()
+	if err := sess.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("uploaded file mismatched (-want +got):\n%s", diff)
+	}
+
+	// download
+	sess, err = c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	stdin, err = sess.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err = sess.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Start("scp -f " + filepath.Join(dir, "upload")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(stdout)
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(fmt.Sprintf("C0600 %d upload\n", len(want)), header); diff != "" {
+		t.Fatalf("header mismatched (-want +got):\n%s", diff)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	b = make([]byte, len(want)+1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want+"\x00", string(b)); diff != "" {
+		t.Fatalf("downloaded file mismatched (-want +got):\n%s", diff)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Close()
+	if err := sess.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// other commands are refused
+	sess, err = c.SSHClient().NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	var exitErr *ssh.ExitError
+	if _, err := sess.CombinedOutput("echo hello"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Fatalf("want exit status 1, got %v", err)
+	}
+}
+
+func testClientToggleReadOnly(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		ReadOnly:                 true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if !session.ReadOnly {
+		t.Fatal("session should be read-only")
+	}
+
+	_, hostOutputCh := h.InputOutput()
+	hostScanner := scanner(hostOutputCh)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	if want, got := "=== Attached to read-only session ===", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly := false
+	session, err = adminClient.SetSession(context.Background(), &api.SetSessionRequest{ReadOnly: &readOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ReadOnly {
+		t.Fatal("session should be read-write")
+	}
+
+	// both the host and the client are notified
+	if want, got := "=== Session is now read-write ===", scan(hostScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "=== Session is now read-write ===", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	// client input is connected
+	remoteInputCh <- "echo hello"
+	if want, got := "echo hello", scan(hostScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(hostScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientMenu(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		Menu: []*api.MenuItem{
+			{Name: "greet", Command: []string{"bash", "-c", "echo hello from menu && sleep 1"}},
+			{Name: "shell", Command: []string{"bash", "--norc"}},
+		},
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if want, got := 2, len(session.Menu); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	for _, want := range []string{
+		"=== Choose a command ===",
+		"1) greet: bash -c echo hello from menu && sleep 1",
+		"2) shell: bash --norc",
+		"q) Quit",
+	} {
+		if got := scan(remoteScanner); want != got {
+			t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+		}
+	}
+
+	remoteInputCh <- "1"
+	if want, got := "Enter a number: 1", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello from menu", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientAttachDirect(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		DirectListenAddr:         "127.0.0.1:0",
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if session.DirectAddr == "" {
+		t.Fatal("direct address is empty")
+	}
+
+	hostInputCh, hostOutputCh := h.InputOutput()
+	hostScanner := scanner(hostOutputCh)
+
+	// join directly, bypassing the server
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, "ssh://"+session.DirectAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	_, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	hostInputCh <- "echo hello"
+	if want, got := "echo hello", scan(hostScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(hostScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	if want, got := "echo hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	// unauthorized keys are rejected
+	c = &Client{
+		PrivateKeys: []string{HostPrivateKey},
+	}
+	if err := c.Join(session, "ssh://"+session.DirectAddr); err == nil {
+		t.Fatal("expected direct join with unauthorized key to fail")
+	}
+}
+
+func getAndVerifySession(t *testing.T, adminSocketFile string, wantHostURL, wantNodeURL string) *api.GetSessionResponse {
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := adminClient.GetSession(context.Background(), &api.GetSessionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSessionPayload(t, sess, wantHostURL, wantNodeURL)
+	if sess.ServerHostKey == "" || len(sess.HostPublicKeys) == 0 {
+		t.Fatalf("want host keys of the server and the session, got %q and %q", sess.ServerHostKey, sess.HostPublicKeys)
+	}
+	// the host authenticates to the server with one of its keys
+	if !slices.Contains(sess.HostPublicKeys, sess.AuthKey) {
+		t.Fatalf("want the auth key to be one of %q, got %q", sess.HostPublicKeys, sess.AuthKey)
+	}
+
+	return sess
+}
+
+func checkSessionPayload(t *testing.T, sess *api.GetSessionResponse, wantHostURL, wantNodeURL string) {
+	if sess.SessionId == "" {
+		t.Fatalf("session ID is empty")
+	}
+	if want, got := wantHostURL, sess.Host; want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := wantNodeURL, sess.NodeAddr; want != got {
+		t.Fatalf("want=%s got=%s:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func newAdminSocketDir() (string, error) {
+	return os.MkdirTemp("", "upterm")
+}
+
+// testClientSignal verifies that signal requests of clients reach the foreground job of the host's command.
+func testClientSignal(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	remoteInputCh <- `trap 'echo got-$((1+1))-INT' INT; echo trapped-$((1+1))`
+	if want, got := `trap 'echo got-$((1+1))-INT' INT; echo trapped-$((1+1))`, scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	// wait for the trap to be set before signaling
+	if want, got := "trapped-2", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	if err := c.Signal(ssh.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	// readline redraws the prompt line after an interrupt
+	if want, got := "got-2-INT", scan(remoteScanner); !strings.HasSuffix(got, want) {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+}
+
+// testClientTermCapAdvisory verifies that clients are advised once the command uses capabilities their terminals lack.
+func testClientTermCapAdvisory(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	// the client joins with TERM=xterm, which has 8 colors
+	remoteInputCh <- `printf '\033[38;5;208m%s\033[0m\n' orange`
+	if want, got := `printf '\033[38;5;208m%s\033[0m\n' orange`, scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	var lines []string
+	for i := 0; i < 3; i++ {
+		line := scan(remoteScanner)
+		if line == "=== Your terminal (TERM=xterm) lacks capabilities used in this session ===" {
+			if want, got := "- 256 colors: join with TERM=xterm-256color if your terminal supports it", scan(remoteScanner); want != got {
+				t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+			}
+			return
+		}
+		lines = append(lines, line)
+	}
+
+	t.Fatalf("want advisory, got %q", lines)
+}
+
+type approverFunc func(ctx context.Context, req approval.Request) (approval.Decision, error)
+
+func (f approverFunc) Approve(ctx context.Context, req approval.Request) (approval.Decision, error) {
+	return f(ctx, req)
+}
+
+func testClientApproval(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	requests := make(chan approval.Request, 1)
+	decide := make(chan approval.Decision)
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		Labels:                   approval.Labels{"env": "prod", "team": "infra"},
+		Approval: &approval.Policy{
+			Selector: approval.Labels{"env": "prod"},
+			Approver: approverFunc(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				requests <- req
+				select {
+				case d := <-decide:
+					return d, nil
+				case <-ctx.Done():
+					return approval.Decision{}, ctx.Err()
+				}
+			}),
+			Timeout: time.Minute,
+		},
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	// the client waits for the approval
+	if want, got := "=== Session labeled env=prod,team=infra requires approval. Waiting for an approver... ===", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	var req approval.Request
+	select {
+	case req = <-requests:
+	case <-time.After(10 * time.Second):
+		t.Fatal("approval wasn't requested")
+	}
+	if want, got := session.SessionId, req.SessionID; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ClientPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := utils.FingerprintSHA256(pk), req.Client.PublicKeyFingerprint; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	decide <- approval.Decision{Approved: true, Approver: "alice"}
+	if want, got := "=== Access approved by alice ===", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	remoteInputCh <- "echo hello"
+	if want, got := "echo hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientMaxClients(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		MaxClients:               1,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if want, got := int32(1), session.MaxClients; want != got {
+		t.Fatalf("want max clients %d, got %d", want, got)
+	}
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+	remoteInputCh <- "echo hello"
+	if want, got := "echo hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+
+	// the session is full
+	full := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := full.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer full.Close()
+
+	_, fullOutputCh := full.InputOutput()
+	if want, got := "=== The session is full, it allows 1 clients at once. Try again later ===", scan(scanner(fullOutputCh)); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientSessionName(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		Name:                     "debugging prod incident",
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+	if want, got := "debugging prod incident", session.Name; want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, remoteOutputCh := c.InputOutput()
+	if want, got := "=== Joined session: debugging prod incident ===", scan(scanner(remoteOutputCh)); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientAuthorizedKeysRefresh(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	// the keys are rotated from the client key to the host key, e.g. when a user replaces their key on GitHub
+	hostPk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(HostPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated atomic.Bool
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		RefreshAuthorizedKeys: func(ctx context.Context) ([]*host.AuthorizedKey, error) {
+			if !rotated.Load() {
+				return nil, errors.New("code host is down")
+			}
+			return []*host.AuthorizedKey{{PublicKeys: []ssh.PublicKey{hostPk}, Comment: "github"}}, nil
+		},
+		RefreshInterval: 100 * time.Millisecond,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	// keys are kept when refreshing fails
+	time.Sleep(300 * time.Millisecond)
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	rotated.Store(true)
+
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sess, err := adminClient.GetSession(context.Background(), &api.GetSessionRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aks := sess.AuthorizedKeys; len(aks) == 1 && aks[0].Comment == "github" {
+			session = sess
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("authorized keys weren't refreshed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// the revoked key is refused
+	c = &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err == nil {
+		c.Close()
+		t.Fatal("want error joining with a revoked key")
+	}
+	checkRejection(t, c, server.RejectionKeyNotAuthorized)
+
+	// the new key joins
+	c = &Client{
+		PrivateKeys: []string{HostPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+	remoteInputCh <- "echo hello"
+	if want, got := "echo hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+	if want, got := "hello", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+	}
+}
+
+func testClientWhoAmI(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		ReadOnly:                 true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	w, err := api.RequestWhoAmI(c.sshClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ClientPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// WebSocket clients are relayed to the SSH proxy of the node of the session
+	u, err := url.Parse(clientJoinURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectedNode := u.Host
+	if u.Scheme != "ssh" {
+		connectedNode = hostNodeAddr
+	}
+
+	if want, got := utils.FingerprintSHA256(pk), w.Client.GetPublicKeyFingerprint(); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if !w.ReadOnly {
+		t.Fatal("want read-only session")
+	}
+	if want, got := session.SessionId, w.SessionId; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := hostNodeAddr, w.SessionNodeAddr; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := connectedNode, w.Client.GetNodeAddr(); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
 }
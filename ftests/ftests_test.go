@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"net"
 	"net/url"
@@ -20,8 +19,10 @@ import (
 
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/oklog/run"
+	"github.com/owenthereal/upterm/approval"
 	"github.com/owenthereal/upterm/host"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
 	uio "github.com/owenthereal/upterm/io"
 	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/utils"
@@ -98,9 +99,35 @@ func Test_ftest(t *testing.T) {
 		testClientAttachHostWithSameCommand,
 		testClientAttachHostWithDifferentCommand,
 		testClientAttachReadOnly,
+		testClientToggleReadOnly,
+		testClientMenu,
+		testClientFeatures,
+		testClientJoinToken,
+		testClientJoinInvite,
+		testClientSFTP,
+		testClientCompressedSFTP,
+		testClientPortForwarding,
+		testClientForwardedPort,
+		testClientExec,
+		testClientScp,
+		testClientSignal,
+		testClientTermCapAdvisory,
+		testClientApproval,
+		testClientMaxClients,
+		testClientSessionName,
+		testClientAuthorizedKeysRefresh,
+		testClientWhoAmI,
+		testClientAttachDirect,
 		testHostFailToShareWithoutPrivateKey,
 		testHostSessionCreatedCallback,
 		testHostClientCallback,
+		testHostKickClient,
+		testHostStreamOutput,
+		testHostKeepSessionOnExit,
+		testHostListenOnly,
+		testHostJump,
+		testScenarios,
+		testBenchmarks,
 	}
 
 	for _, test := range testCases {
@@ -210,12 +237,20 @@ func (s *Server) start() error {
 	logger := log.New()
 	logger.Level = log.DebugLevel
 
+	// the ws proxy relays its tcp connections to the ssh proxy with epoll where it's supported
+	relayCore := server.RelayCoreGoroutine
+	if runtime.GOOS == "linux" {
+		relayCore = server.RelayCoreNetpoll
+	}
+
 	s.Server = &server.Server{
 		NodeAddr:        s.SSHAddr(), // node addr is hard coded to ssh addr
 		HostSigners:     hostSigners,
 		Signers:         signers,
 		NetworkProvider: network,
 		MetricsProvider: provider.NewDiscardProvider(),
+		WSCoalesceDelay: 2 * time.Millisecond,
+		RelayCore:       relayCore,
 		Logger:          logger,
 	}
 
@@ -250,6 +285,24 @@ type Host struct {
 	ClientLeftCallback       func(*api.Client)
 	PermittedClientPublicKey string
 	ReadOnly                 bool
+	DirectListenAddr         string
+	Menu                     []*api.MenuItem
+	JoinTokens               *host.JoinTokens
+	SFTP                     bool
+	Exec                     bool
+	PortForwarding           bool
+	ForwardedPorts           []uint32
+	TransferCompression      string
+	Identities               identity.Resolver
+	JumpHosts                []*url.URL
+	Labels                   approval.Labels
+	Approval                 *approval.Policy
+	RefreshAuthorizedKeys    func(ctx context.Context) ([]*host.AuthorizedKey, error)
+	RefreshInterval          time.Duration
+	MaxClients               int
+	Name                     string
+	ListenOnly               bool
+	KeepSessionOnExit        bool
 	inputCh                  chan string
 	outputCh                 chan string
 	ctx                      context.Context
@@ -304,29 +357,55 @@ func (c *Host) Share(url string) error {
 		c.AdminSocketFile = filepath.Join(adminSockDir, "upterm.sock")
 	}
 
+	stateDir, err := os.MkdirTemp("", "upterm-state")
+	if err != nil {
+		return err
+	}
+
 	logger := log.New()
 	logger.Level = log.DebugLevel
 
 	c.Host = &host.Host{
-		Host:                   url,
-		Command:                c.Command,
-		ForceCommand:           c.ForceCommand,
-		Signers:                signers,
-		AuthorizedKeys:         authorizedKeys,
-		AdminSocketFile:        c.AdminSocketFile,
-		SessionCreatedCallback: c.SessionCreatedCallback,
-		ClientJoinedCallback:   c.ClientJoinedCallback,
-		ClientLeftCallback:     c.ClientLeftCallback,
-		KeepAliveDuration:      10 * time.Second,
-		Logger:                 logger,
-		HostKeyCallback:        ssh.InsecureIgnoreHostKey(),
-		Stdin:                  stdinr,
-		Stdout:                 stdoutw,
-		ReadOnly:               c.ReadOnly,
+		Host:                          url,
+		Command:                       c.Command,
+		ForceCommand:                  c.ForceCommand,
+		Signers:                       signers,
+		AuthorizedKeys:                authorizedKeys,
+		Identities:                    c.Identities,
+		JumpHosts:                     c.JumpHosts,
+		AdminSocketFile:               c.AdminSocketFile,
+		StateDir:                      stateDir,
+		SessionCreatedCallback:        c.SessionCreatedCallback,
+		ClientJoinedCallback:          c.ClientJoinedCallback,
+		ClientLeftCallback:            c.ClientLeftCallback,
+		KeepAliveDuration:             10 * time.Second,
+		Logger:                        logger,
+		HostKeyCallback:               ssh.InsecureIgnoreHostKey(),
+		Stdin:                         stdinr,
+		Stdout:                        stdoutw,
+		ReadOnly:                      c.ReadOnly,
+		DirectListenAddr:              c.DirectListenAddr,
+		Menu:                          c.Menu,
+		JoinTokens:                    c.JoinTokens,
+		SFTP:                          c.SFTP,
+		Exec:                          c.Exec,
+		PortForwarding:                c.PortForwarding,
+		ForwardedPorts:                c.ForwardedPorts,
+		TransferCompression:           c.TransferCompression,
+		Labels:                        c.Labels,
+		Approval:                      c.Approval,
+		RefreshAuthorizedKeys:         c.RefreshAuthorizedKeys,
+		AuthorizedKeysRefreshInterval: c.RefreshInterval,
+		OutputCoalesceDelay:           2 * time.Millisecond,
+		MaxClients:                    c.MaxClients,
+		Name:                          c.Name,
+		ListenOnly:                    c.ListenOnly,
+		KeepSessionOnExit:             c.KeepSessionOnExit,
 	}
 
 	errCh := make(chan error)
 	go func() {
+		defer os.RemoveAll(stateDir)
 		if err := c.Host.Run(c.ctx); err != nil {
 			log.WithError(err).Error("error running host")
 			errCh <- err
@@ -377,12 +456,19 @@ func (c *Host) InputOutput() (chan string, chan string) {
 
 type Client struct {
 	PrivateKeys []string
-	sshClient   *ssh.Client
-	session     *ssh.Session
-	sshStdin    io.WriteCloser
-	sshStdout   io.Reader
-	inputCh     chan string
-	outputCh    chan string
+	// User overrides the username encoded from the session if it's non-empty.
+	User string
+	// Features are proposed to the host when joining if it's non-nil.
+	Features map[string]string
+	// AgreedFeatures are the features the host agreed to.
+	AgreedFeatures map[string]string
+	rejection      *server.Rejection
+	sshClient      *ssh.Client
+	session        *ssh.Session
+	sshStdin       io.WriteCloser
+	sshStdout      io.Reader
+	inputCh        chan string
+	outputCh       chan string
 }
 
 func (c *Client) init() {
@@ -394,28 +480,52 @@ func (c *Client) InputOutput() (chan string, chan string) {
 	return c.inputCh, c.outputCh
 }
 
+// Rejection returns the reason the server rejected the client, if any.
+func (c *Client) Rejection() *server.Rejection {
+	return c.rejection
+}
+
+func (c *Client) captureRejection(msg string) {
+	if r, ok := server.ParseRejection(msg); ok {
+		c.rejection = r
+	}
+}
+
 func (c *Client) Close() {
-	c.session.Close()
+	if c.session != nil {
+		c.session.Close()
+	}
 	c.sshClient.Close()
 }
 
-func (c *Client) JoinWithContext(ctx context.Context, session *api.GetSessionResponse, clientJoinURL string) error {
-	c.init()
-
+// Dial connects the client to the session without opening a terminal, e.g. to request other channels.
+func (c *Client) Dial(session *api.GetSessionResponse, clientJoinURL string) error {
 	auths, err := authMethodsFromFiles(c.PrivateKeys)
 	if err != nil {
 		return err
 	}
 
-	user, err := api.EncodeIdentifierSession(session)
-	if err != nil {
-		return err
+	user := c.User
+	if user == "" {
+		user, err = api.EncodeIdentifierSession(session)
+		if err != nil {
+			return err
+		}
 	}
 
+	// simulate openssh that displays banners and keyboard-interactive instructions
+	auths = append(auths, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		c.captureRejection(instruction)
+		return nil, nil
+	}))
 	config := &ssh.ClientConfig{
 		User:            user,
 		Auth:            auths,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(msg string) error {
+			c.captureRejection(msg)
+			return nil
+		},
 	}
 
 	u, err := url.Parse(clientJoinURL)
@@ -435,6 +545,28 @@ func (c *Client) JoinWithContext(ctx context.Context, session *api.GetSessionRes
 		return err
 	}
 
+	return nil
+}
+
+// SSHClient returns the connection of the client to the session.
+func (c *Client) SSHClient() *ssh.Client {
+	return c.sshClient
+}
+
+func (c *Client) JoinWithContext(ctx context.Context, session *api.GetSessionResponse, clientJoinURL string) error {
+	c.init()
+
+	if err := c.Dial(session, clientJoinURL); err != nil {
+		return err
+	}
+
+	var err error
+	if c.Features != nil {
+		if c.AgreedFeatures, err = api.NegotiateFeatures(c.sshClient, c.Features); err != nil {
+			return err
+		}
+	}
+
 	c.session, err = c.sshClient.NewSession()
 	if err != nil {
 		return err
@@ -508,6 +640,11 @@ func (c *Client) JoinWithContext(ctx context.Context, session *api.GetSessionRes
 	return nil
 }
 
+// Signal sends a signal request in the terminal of the client, like SSH libraries do instead of typing Ctrl-C.
+func (c *Client) Signal(sig ssh.Signal) error {
+	return c.session.Signal(sig)
+}
+
 func (c *Client) Join(session *api.GetSessionResponse, clientJoinURL string) error {
 	return c.JoinWithContext(context.Background(), session, clientJoinURL)
 }
@@ -540,24 +677,17 @@ func scan(s *bufio.Scanner) string {
 }
 
 func waitForUnixSocket(socket string, errCh chan error) error {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	dialCh := make(chan error, 1)
+	go func() {
+		_, err := host.DialAdminClient(context.Background(), socket, host.WithAdminDialTimeout(10*time.Second))
+		dialCh <- err
+	}()
 
-	count := 0
-	for {
-		select {
-		case err := <-errCh:
-			return err
-		case <-ticker.C:
-			log.WithField("socket", socket).Info("waiting for unix socket")
-			if _, err := os.Stat(socket); err == nil {
-				return nil
-			}
-			count++
-			if count >= 10 {
-				return fmt.Errorf("waiting for unix socket failed")
-			}
-		}
+	select {
+	case err := <-errCh:
+		return err
+	case err := <-dialCh:
+		return err
 	}
 }
 
@@ -2,17 +2,25 @@ package ftests
 
 import (
 	"context"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	gssh "github.com/charmbracelet/ssh"
 	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/host"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func testHostClientCallback(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
@@ -27,11 +35,17 @@ func testHostClientCallback(t *testing.T, hostShareURL, hostNodeAddr, clientJoin
 
 	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
 
+	clientPK, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ClientPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	h := &Host{
 		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
 		PrivateKeys:              []string{HostPrivateKey},
 		AdminSocketFile:          adminSocketFile,
 		PermittedClientPublicKey: ClientPublicKeyContent,
+		Identities:               identity.Names{utils.FingerprintSHA256(clientPK): "Alice Smith"},
 		ClientJoinedCallback: func(c *api.Client) {
 			jch <- c
 		},
@@ -79,6 +93,10 @@ func testHostClientCallback(t *testing.T, hostShareURL, hostNodeAddr, clientJoin
 		if diff := cmp.Diff("SSH-2.0-Go", cc.Version); diff != "" {
 			t.Fatal(diff)
 		}
+
+		if diff := cmp.Diff("Alice Smith", cc.DisplayName); diff != "" {
+			t.Fatal(diff)
+		}
 	case <-time.After(2 * time.Second):
 		t.Fatal("client joined callback is not called")
 	}
@@ -118,6 +136,270 @@ func testHostClientCallback(t *testing.T, hostShareURL, hostNodeAddr, clientJoin
 	}
 }
 
+func testHostKickClient(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	jch := make(chan *api.Client, 1)
+	lch := make(chan *api.Client, 1)
+
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		ClientJoinedCallback: func(c *api.Client) {
+			jch <- c
+		},
+		ClientLeftCallback: func(c *api.Client) {
+			lch <- c
+		},
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var joined *api.Client
+	select {
+	case joined = <-jch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client joined callback is not called")
+	}
+
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adminClient.NewSession(context.Background(), &api.NewSessionRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("want spawning a session from a single session host refused, got %v", err)
+	}
+	if _, err := adminClient.KickClient(context.Background(), &api.KickClientRequest{ClientId: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("want kicking an unknown client not found, got %v", err)
+	}
+	if _, err := adminClient.KickClient(context.Background(), &api.KickClientRequest{ClientId: joined.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case left := <-lch:
+		if diff := cmp.Diff(joined.Id, left.Id); diff != "" {
+			t.Fatal(diff)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client left callback is not called")
+	}
+
+	// the connection of the client is closed
+	done := make(chan error, 1)
+	go func() {
+		done <- c.SSHClient().Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("want the connection of the kicked client closed")
+	}
+}
+
+func testHostStreamOutput(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	_ = getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := adminClient.StreamOutput(ctx, &api.StreamOutputRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream starts with the window of the terminal
+	chunk, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Data) != 0 {
+		t.Fatalf("want the window first, got %q", chunk.Data)
+	}
+
+	hostInputCh, _ := h.InputOutput()
+	hostInputCh <- "echo hello"
+
+	var output string
+	// the output is the echo of the input followed by the output of the command
+	for strings.Count(output, "hello\r\n") < 2 {
+		chunk, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("want the output streamed, got %q: %v", output, err)
+		}
+		output += string(chunk.Data)
+	}
+}
+
+func testHostKeepSessionOnExit(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		KeepSessionOnExit:        true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	session := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	remoteInputCh, remoteOutputCh := c.InputOutput()
+	remoteScanner := scanner(remoteOutputCh)
+
+	// the client stays attached once the command exits
+	remoteInputCh <- "exit 3"
+	if want, got := "exit 3", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	// bash says it exits
+	if want, got := "exit", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	if want, got := "=== The command exited with status 3. Press r to restart it or q to end the session ===", scan(remoteScanner); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// and types into the command restarted
+	remoteInputCh <- "r"
+	remoteInputCh <- "echo hello"
+	// the input typed before the shell reads it is echoed by the terminal too
+	for got := scan(remoteScanner); got != "hello"; got = scan(remoteScanner) {
+		if want := "echo hello"; want != got {
+			t.Fatalf("want=%q got=%q", want, got)
+		}
+	}
+}
+
+func testHostListenOnly(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	adminSocketFile := filepath.Join(adminSockDir, "upterm.sock")
+
+	h := &Host{
+		Command:                  []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          adminSocketFile,
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		ListenOnly:               true,
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	first := getAndVerifySession(t, adminSocketFile, hostShareURL, hostNodeAddr)
+
+	adminClient, err := host.AdminClient(adminSocketFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := []string{"bash", "-c", "PS1='' BASH_SILENCE_DEPRECATION_WARNING=1 bash --norc -i"}
+	spawned, err := adminClient.NewSession(context.Background(), &api.NewSessionRequest{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSessionPayload(t, spawned, hostShareURL, hostNodeAddr)
+	if spawned.SessionId == first.SessionId {
+		t.Fatalf("want a session with its own ID, got %s", spawned.SessionId)
+	}
+	if diff := cmp.Diff(command, spawned.Command); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// each session has its own terminal
+	join := func(session *api.GetSessionResponse, text string) {
+		t.Helper()
+
+		c := &Client{
+			PrivateKeys: []string{ClientPrivateKey},
+		}
+		if err := c.Join(session, clientJoinURL); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		remoteInputCh, remoteOutputCh := c.InputOutput()
+		remoteScanner := scanner(remoteOutputCh)
+		time.Sleep(1 * time.Second) // HACK: wait for ssh stdin/stdout to fully attach
+
+		remoteInputCh <- "echo " + text
+		if want, got := "echo "+text, scan(remoteScanner); want != got {
+			t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+		}
+		if want, got := text, scan(remoteScanner); want != got {
+			t.Fatalf("want=%q got=%q:\n%s", want, got, cmp.Diff(want, got))
+		}
+	}
+	join(first, "hello first")
+	join(spawned, "hello spawned")
+}
+
 func testHostSessionCreatedCallback(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
 	h := &Host{
 		Command:      []string{"bash", "--norc"},
@@ -155,3 +437,83 @@ func testHostFailToShareWithoutPrivateKey(t *testing.T, hostShareURL, hostNodeAd
 		t.Fatalf("expect permission denied error: %s", err)
 	}
 }
+
+func testHostJump(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	u, err := url.Parse(hostShareURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "ssh" {
+		t.Skip("jump hosts require an ssh server")
+	}
+
+	signer, err := host.SignersFromFiles([]string{HostPrivateKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bastion forwarding connections of the host to the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan string, 1)
+	bastion := &gssh.Server{
+		PublicKeyHandler: func(ctx gssh.Context, key gssh.PublicKey) bool {
+			return gssh.KeysEqual(key, signer[0].PublicKey())
+		},
+		LocalPortForwardingCallback: func(ctx gssh.Context, destinationHost string, destinationPort uint32) bool {
+			forwarded <- net.JoinHostPort(destinationHost, strconv.Itoa(int(destinationPort)))
+			return true
+		},
+		ChannelHandlers: map[string]gssh.ChannelHandler{
+			"direct-tcpip": gssh.DirectTCPIPHandler,
+		},
+	}
+	bastion.AddHostKey(signer[0])
+	go func() {
+		_ = bastion.Serve(ln)
+	}()
+	defer bastion.Close()
+
+	adminSockDir, err := newAdminSocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(adminSockDir)
+
+	jch := make(chan *api.Client, 1)
+	h := &Host{
+		Command:                  []string{"bash", "--norc"},
+		PrivateKeys:              []string{HostPrivateKey},
+		AdminSocketFile:          filepath.Join(adminSockDir, "upterm.sock"),
+		PermittedClientPublicKey: ClientPublicKeyContent,
+		JumpHosts:                []*url.URL{{Scheme: "ssh", User: url.User("bastion"), Host: ln.Addr().String()}},
+		ClientJoinedCallback: func(c *api.Client) {
+			jch <- c
+		},
+	}
+	if err := h.Share(hostShareURL); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if want, got := u.Host, <-forwarded; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	session := getAndVerifySession(t, h.AdminSocketFile, hostShareURL, hostNodeAddr)
+	c := &Client{
+		PrivateKeys: []string{ClientPrivateKey},
+	}
+	if err := c.Join(session, clientJoinURL); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case <-jch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client joined callback is not called")
+	}
+}
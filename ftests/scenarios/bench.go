@@ -0,0 +1,312 @@
+package scenarios
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// benchLineLen is how many keystrokes of the interactive benchmark are typed before Enter,
+// keeping lines within the buffer of the terminal.
+const benchLineLen = 32
+
+// BenchOptions sizes the traffic of benchmarks.
+type BenchOptions struct {
+	// Keystrokes is how many keys the interactive benchmark types.
+	Keystrokes int
+	// BulkBytes is the size of the files the bulk benchmarks transfer.
+	BulkBytes int64
+}
+
+// DefaultBenchOptions are the options of benchmarks unless specified.
+var DefaultBenchOptions = BenchOptions{
+	Keystrokes: 200,
+	BulkBytes:  16 << 20,
+}
+
+// Benchmark measures a kind of traffic between a host and a client through a deployment,
+// e.g. to compare the ssh and WebSocket transports or to validate optimizations of them.
+type Benchmark struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, env Env, opts BenchOptions) (Measurement, error)
+}
+
+// Measurement is the outcome of a benchmark.
+type Measurement struct {
+	Benchmark string
+	// Bytes is how many bytes of payload are received end to end, counted exactly.
+	Bytes    int64
+	Duration time.Duration
+	// Latencies are the round trips of interactive traffic in ascending order. It's empty for bulk traffic.
+	Latencies []time.Duration
+}
+
+// Throughput returns the bytes received per second.
+func (m Measurement) Throughput() float64 {
+	if m.Duration <= 0 {
+		return 0
+	}
+
+	return float64(m.Bytes) / m.Duration.Seconds()
+}
+
+// Latency returns the p-th percentile of Latencies, e.g. 0.99, or zero without latencies.
+func (m Measurement) Latency(p float64) time.Duration {
+	if len(m.Latencies) == 0 {
+		return 0
+	}
+
+	i := int(p*float64(len(m.Latencies))+0.5) - 1
+	i = max(0, min(i, len(m.Latencies)-1))
+
+	return m.Latencies[i]
+}
+
+// Benchmarks are the benchmarks in the order they run.
+var Benchmarks = []Benchmark{
+	{
+		Name:        "interactive",
+		Description: "round trips of keystrokes of a client echoed by the host's terminal",
+		Run:         benchInteractive,
+	},
+	{
+		Name:        "bulk-download",
+		Description: "throughput of a client downloading a file from the host with SFTP",
+		Run:         benchBulkDownload,
+	},
+	{
+		Name:        "bulk-upload",
+		Description: "throughput of a client uploading a file to the host with SFTP",
+		Run:         benchBulkUpload,
+	},
+}
+
+// FindBenchmarks returns the benchmarks with the names, or Benchmarks if names is empty.
+func FindBenchmarks(names []string) ([]Benchmark, error) {
+	if len(names) == 0 {
+		return Benchmarks, nil
+	}
+
+	var result []Benchmark
+	for _, name := range names {
+		i := slices.IndexFunc(Benchmarks, func(b Benchmark) bool { return b.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown benchmark %q, supported benchmarks: %s", name, strings.Join(BenchmarkNames(), ", "))
+		}
+		result = append(result, Benchmarks[i])
+	}
+
+	return result, nil
+}
+
+// BenchmarkNames returns the names of Benchmarks.
+func BenchmarkNames() []string {
+	var names []string
+	for _, b := range Benchmarks {
+		names = append(names, b.Name)
+	}
+
+	return names
+}
+
+// RunBenchmark runs the benchmark against env within timeout.
+func RunBenchmark(ctx context.Context, env Env, b Benchmark, opts BenchOptions, timeout time.Duration) (Measurement, error) {
+	if env.Logger == nil {
+		logger := log.New()
+		logger.SetLevel(log.PanicLevel)
+		env.Logger = logger
+	}
+	if env.HostKeyCallback == nil {
+		return Measurement{}, fmt.Errorf("host key callback is required")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	m, err := b.Run(ctx, env, opts)
+	m.Benchmark = b.Name
+
+	return m, err
+}
+
+// benchInteractive types keys one at a time in the client's terminal, waiting for each to be echoed back.
+func benchInteractive(ctx context.Context, env Env, opts BenchOptions) (Measurement, error) {
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true})
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer h.Close()
+
+	c, err := joinSession(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer c.Close()
+
+	// wait for the terminal to be ready: the input is echoed and then printed by cat
+	ready := marker("client")
+	if err := c.Type(ready); err != nil {
+		return Measurement{}, err
+	}
+	if err := c.output.WaitFor(ctx, ready+"\r\n"+ready+"\r\n"); err != nil {
+		return Measurement{}, fmt.Errorf("client didn't see its input: %w", err)
+	}
+
+	var (
+		m     Measurement
+		start = c.received.Count()
+		want  = start
+		began = time.Now()
+		line  int
+	)
+	for i := 0; i < opts.Keystrokes; i++ {
+		key := "x"
+		if line == benchLineLen {
+			// Enter is echoed as CRLF, followed by the line printed by cat
+			key = "\r"
+			want += 2 + int64(line) + 2
+			line = 0
+		} else {
+			want++
+			line++
+		}
+
+		sent := time.Now()
+		if _, err := io.WriteString(c.stdin, key); err != nil {
+			return m, err
+		}
+		if err := c.received.WaitFor(ctx, want); err != nil {
+			return m, fmt.Errorf("keystroke %d wasn't echoed: %w", i, err)
+		}
+		if key != "\r" {
+			m.Latencies = append(m.Latencies, time.Since(sent))
+		}
+	}
+	m.Duration = time.Since(began)
+	m.Bytes = c.received.Count() - start
+	slices.Sort(m.Latencies)
+
+	return m, nil
+}
+
+// benchBulkDownload downloads a file of random bytes from the host, verifying its size and hash.
+func benchBulkDownload(ctx context.Context, env Env, opts BenchOptions) (Measurement, error) {
+	return benchBulk(ctx, env, func(sc *sftp.Client, dir string, content []byte) (int64, error) {
+		name := filepath.Join(dir, "download")
+		if err := os.WriteFile(name, content, 0600); err != nil {
+			return 0, err
+		}
+
+		f, err := sc.Open(name)
+		if err != nil {
+			return 0, fmt.Errorf("error downloading file: %w", err)
+		}
+		defer f.Close()
+
+		hash := sha256.New()
+		n, err := f.WriteTo(hash)
+		if err != nil {
+			return n, fmt.Errorf("error downloading file: %w", err)
+		}
+		if !bytes.Equal(hash.Sum(nil), sha256Sum(content)) {
+			return n, fmt.Errorf("downloaded file of %d bytes doesn't match the %d bytes of the host", n, len(content))
+		}
+
+		return n, nil
+	}, opts)
+}
+
+// benchBulkUpload uploads a file of random bytes to the host, verifying its size and hash.
+func benchBulkUpload(ctx context.Context, env Env, opts BenchOptions) (Measurement, error) {
+	return benchBulk(ctx, env, func(sc *sftp.Client, dir string, content []byte) (int64, error) {
+		name := filepath.Join(dir, "upload")
+
+		f, err := sc.Create(name)
+		if err != nil {
+			return 0, fmt.Errorf("error uploading file: %w", err)
+		}
+		if _, err := f.ReadFrom(bytes.NewReader(content)); err != nil {
+			f.Close()
+			return 0, fmt.Errorf("error uploading file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return 0, fmt.Errorf("error uploading file: %w", err)
+		}
+
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(sha256Sum(b), sha256Sum(content)) {
+			return int64(len(b)), fmt.Errorf("uploaded file of %d bytes doesn't match the %d bytes of the client", len(b), len(content))
+		}
+
+		return int64(len(b)), nil
+	}, opts)
+}
+
+// benchBulk times transfer, which transfers content between the client and the host's directory dir over sftp
+// and returns the bytes received.
+func benchBulk(ctx context.Context, env Env, transfer func(sc *sftp.Client, dir string, content []byte) (int64, error), opts BenchOptions) (Measurement, error) {
+	content := make([]byte, opts.BulkBytes)
+	// random bytes are incompressible, so that compression of the transport doesn't inflate the throughput
+	if _, err := rand.Read(content); err != nil {
+		return Measurement{}, err
+	}
+
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true, sftp: true})
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer h.Close()
+
+	c, err := dialClient(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		if c.rejection != nil {
+			return Measurement{}, fmt.Errorf("error joining session: %w", c.rejection)
+		}
+		return Measurement{}, fmt.Errorf("error joining session: %w", err)
+	}
+	defer c.Close()
+
+	sc, err := sftp.NewClient(c.sshClient)
+	if err != nil {
+		return Measurement{}, fmt.Errorf("error starting sftp: %w", err)
+	}
+	defer sc.Close()
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	began := time.Now()
+	go func() {
+		n, err := transfer(sc, h.dir, content)
+		done <- result{n: n, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return Measurement{Bytes: r.n, Duration: time.Since(began)}, r.err
+	case <-ctx.Done():
+		return Measurement{}, ctx.Err()
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
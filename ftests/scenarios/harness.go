@@ -0,0 +1,409 @@
+package scenarios
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owenthereal/upterm/host"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/server"
+	"github.com/owenthereal/upterm/ws"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+// transcript collects the output of a terminal.
+type transcript struct {
+	mu      sync.Mutex
+	b       strings.Builder
+	changed chan struct{}
+}
+
+func newTranscript() *transcript {
+	return &transcript{changed: make(chan struct{})}
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.b.Write(p)
+	close(t.changed)
+	t.changed = make(chan struct{})
+
+	return len(p), nil
+}
+
+func (t *transcript) Contains(s string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.Contains(t.b.String(), s)
+}
+
+// WaitFor waits until s is in the transcript.
+func (t *transcript) WaitFor(ctx context.Context, s string) error {
+	for {
+		t.mu.Lock()
+		found, changed := strings.Contains(t.b.String(), s), t.changed
+		t.mu.Unlock()
+
+		if found {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %q: %w", s, ctx.Err())
+		}
+	}
+}
+
+// counter counts the bytes written to it, e.g. the output of a terminal.
+type counter struct {
+	mu      sync.Mutex
+	n       int64
+	changed chan struct{}
+}
+
+func newCounter() *counter {
+	return &counter{changed: make(chan struct{})}
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.n += int64(len(p))
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return len(p), nil
+}
+
+func (c *counter) Count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.n
+}
+
+// WaitFor waits until at least n bytes are written.
+func (c *counter) WaitFor(ctx context.Context, n int64) error {
+	for {
+		c.mu.Lock()
+		count, changed := c.n, c.changed
+		c.mu.Unlock()
+
+		if count >= n {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d bytes, got %d: %w", n, count, ctx.Err())
+		}
+	}
+}
+
+func newSigner() (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+type hostOptions struct {
+	// authorizeClient only lets clients with sharedHost.clientSigner join.
+	authorizeClient bool
+	readOnly        bool
+	sftp            bool
+}
+
+// sharedHost is a host sharing a session running cat, which echoes the input of the host and clients.
+type sharedHost struct {
+	session      *api.GetSessionResponse
+	clientSigner ssh.Signer
+	stdin        *os.File
+	output       *transcript
+
+	cancel func()
+	done   chan struct{}
+	dir    string
+}
+
+func shareSession(ctx context.Context, env Env, opts hostOptions) (*sharedHost, error) {
+	signer, err := newSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	clientSigner, err := newSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	var authorizedKeys []*host.AuthorizedKey
+	if opts.authorizeClient {
+		authorizedKeys = append(authorizedKeys, &host.AuthorizedKey{
+			PublicKeys: []ssh.PublicKey{clientSigner.PublicKey()},
+			Comment:    "upterm verify",
+		})
+	}
+
+	dir, err := os.MkdirTemp("", "upterm")
+	if err != nil {
+		return nil, err
+	}
+
+	stdinr, stdinw, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	stdoutr, stdoutw, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	h := &sharedHost{
+		clientSigner: clientSigner,
+		stdin:        stdinw,
+		output:       newTranscript(),
+		done:         make(chan struct{}),
+		dir:          dir,
+	}
+	go func() {
+		_, _ = io.Copy(h.output, stdoutr)
+	}()
+
+	sessionCh := make(chan *api.GetSessionResponse, 1)
+	errCh := make(chan error, 1)
+
+	hctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+
+	uh := &host.Host{
+		Host:              env.HostURL,
+		Command:           []string{"cat"},
+		Signers:           []ssh.Signer{signer},
+		HostKeyCallback:   env.HostKeyCallback,
+		AuthorizedKeys:    authorizedKeys,
+		AdminSocketFile:   filepath.Join(dir, "upterm.sock"),
+		StateDir:          dir,
+		KeepAliveDuration: 10 * time.Second,
+		Logger:            env.Logger,
+		Stdin:             stdinr,
+		Stdout:            stdoutw,
+		ReadOnly:          opts.readOnly,
+		SFTP:              opts.sftp,
+		SessionCreatedCallback: func(session *api.GetSessionResponse) error {
+			sessionCh <- session
+			return nil
+		},
+		AgreePolicyCallback: env.AgreePolicyCallback,
+	}
+	go func() {
+		defer close(h.done)
+		defer stdoutw.Close()
+		defer stdinr.Close()
+
+		if err := uh.Run(hctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case h.session = <-sessionCh:
+		return h, nil
+	case err := <-errCh:
+		h.Close()
+		return nil, fmt.Errorf("error hosting session: %w", err)
+	case <-ctx.Done():
+		h.Close()
+		return nil, fmt.Errorf("error hosting session: %w", ctx.Err())
+	}
+}
+
+// Session returns a copy of the hosted session.
+func (h *sharedHost) Session() *api.GetSessionResponse {
+	return proto.Clone(h.session).(*api.GetSessionResponse)
+}
+
+// Type types s followed by a newline in the host's terminal.
+func (h *sharedHost) Type(s string) error {
+	_, err := io.WriteString(h.stdin, s+"\n")
+	return err
+}
+
+func (h *sharedHost) Close() {
+	h.cancel()
+	h.stdin.Close()
+
+	select {
+	case <-h.done:
+	case <-time.After(5 * time.Second):
+	}
+
+	os.RemoveAll(h.dir)
+}
+
+type client struct {
+	sshClient *ssh.Client
+	session   *ssh.Session
+	stdin     io.WriteCloser
+	output    *transcript
+	// received counts the bytes of output exactly, e.g. for benchmarks.
+	received  *counter
+	rejection *server.Rejection
+}
+
+func (c *client) captureRejection(msg string) {
+	if r, ok := server.ParseRejection(msg); ok {
+		c.rejection = r
+	}
+}
+
+// Type types s followed by a newline in the client's terminal.
+func (c *client) Type(s string) error {
+	_, err := io.WriteString(c.stdin, s+"\n")
+	return err
+}
+
+func (c *client) Close() {
+	if c.session != nil {
+		c.session.Close()
+	}
+	if c.sshClient != nil {
+		c.sshClient.Close()
+	}
+}
+
+func dialClient(ctx context.Context, env Env, session *api.GetSessionResponse, signer ssh.Signer) (*client, error) {
+	c := &client{output: newTranscript(), received: newCounter()}
+
+	user, err := api.EncodeIdentifierSession(session)
+	if err != nil {
+		return c, err
+	}
+
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			// rejections are delivered as keyboard-interactive instructions or banners
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				c.captureRejection(instruction)
+				return nil, nil
+			}),
+		},
+		HostKeyCallback: env.HostKeyCallback,
+		BannerCallback: func(msg string) error {
+			c.captureRejection(msg)
+			return nil
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	u, err := url.Parse(env.clientURL())
+	if err != nil {
+		return c, err
+	}
+
+	dialed := make(chan error, 1)
+	go func() {
+		var err error
+		if u.Scheme == "ws" || u.Scheme == "wss" {
+			wu, _ := url.Parse(u.String())
+			wu.User = url.UserPassword(session.SessionId, base64.URLEncoding.EncodeToString([]byte(session.NodeAddr)))
+			c.sshClient, err = ws.NewSSHClient(wu, config, true)
+		} else {
+			c.sshClient, err = ssh.Dial("tcp", u.Host, config)
+		}
+		dialed <- err
+	}()
+
+	select {
+	case err := <-dialed:
+		return c, err
+	case <-ctx.Done():
+		return c, ctx.Err()
+	}
+}
+
+func joinSession(ctx context.Context, env Env, session *api.GetSessionResponse, signer ssh.Signer) (*client, error) {
+	c, err := dialClient(ctx, env, session, signer)
+	if err != nil {
+		if c.rejection != nil {
+			return nil, fmt.Errorf("error joining session: %w", c.rejection)
+		}
+		return nil, fmt.Errorf("error joining session: %w", err)
+	}
+
+	if err := c.attach(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("error attaching to session: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *client) attach() error {
+	var err error
+	c.session, err = c.sshClient.NewSession()
+	if err != nil {
+		return err
+	}
+
+	if err := c.session.RequestPty("xterm", 40, 80, ssh.TerminalModes{}); err != nil {
+		return err
+	}
+
+	c.stdin, err = c.session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	c.session.Stdout = io.MultiWriter(c.output, c.received)
+	c.session.Stderr = io.MultiWriter(c.output, c.received)
+
+	return c.session.Shell()
+}
+
+// expectRejection joins the session expecting the server to reject the client with code.
+func expectRejection(ctx context.Context, env Env, session *api.GetSessionResponse, signer ssh.Signer, code server.RejectionCode) error {
+	c, err := dialClient(ctx, env, session, signer)
+	if err == nil {
+		c.Close()
+		return fmt.Errorf("want rejection %s, but joined the session", code)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	if c.rejection == nil {
+		return fmt.Errorf("want rejection %s, got error: %w", code, err)
+	}
+	if c.rejection.Code != code {
+		return fmt.Errorf("want rejection %s, got %s", code, c.rejection.Code)
+	}
+
+	return nil
+}
@@ -0,0 +1,343 @@
+// Package scenarios packages the functional test scenarios of upterm as a library,
+// so that they can run against a live deployment, e.g. as a smoke test after an upgrade
+// with 'upterm verify'. Each scenario hosts a session in-process with throwaway keys
+// and joins it as a client.
+package scenarios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/owenthereal/upterm/server"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// readOnlyGracePeriod is how long input of clients is expected to take to reach the host.
+const readOnlyGracePeriod = 500 * time.Millisecond
+
+// ErrSkipped is returned by scenarios that can't run in an environment.
+var ErrSkipped = errors.New("skipped")
+
+// Env is the deployment the scenarios run against.
+type Env struct {
+	// HostURL is the server sessions are hosted on, e.g. ssh://uptermd.upterm.dev:22.
+	HostURL string
+	// ClientURL is the server clients join sessions on. It's HostURL if it's empty.
+	// Routing across nodes is verified if it's a different node than HostURL.
+	ClientURL string
+	// HostKeyCallback verifies the server host keys.
+	HostKeyCallback ssh.HostKeyCallback
+	// AgreePolicyCallback is called with the server policy if the server has one.
+	AgreePolicyCallback func(policy, hash string) error
+	Logger              log.FieldLogger
+}
+
+func (e Env) clientURL() string {
+	if e.ClientURL == "" {
+		return e.HostURL
+	}
+
+	return e.ClientURL
+}
+
+// Scenario is a functional test of a deployment.
+type Scenario struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, env Env) error
+}
+
+// All are the scenarios in the order they run.
+var All = []Scenario{
+	{
+		Name:        "auth",
+		Description: "clients with unauthorized keys or unknown sessions are rejected, authorized clients join",
+		Run:         testAuth,
+	},
+	{
+		Name:        "attach",
+		Description: "clients attach to the host's terminal and share input and output",
+		Run:         testAttach,
+	},
+	{
+		Name:        "read-only",
+		Description: "input of clients is discarded in read-only sessions",
+		Run:         testReadOnly,
+	},
+	{
+		Name:        "sftp",
+		Description: "clients transfer files to and from the host with SFTP",
+		Run:         testSFTP,
+	},
+	{
+		Name:        "cross-node",
+		Description: "clients joining on another node are routed to the host's node",
+		Run:         testCrossNode,
+	},
+}
+
+// Find returns the scenarios with the names, or All if names is empty.
+func Find(names []string) ([]Scenario, error) {
+	if len(names) == 0 {
+		return All, nil
+	}
+
+	var result []Scenario
+	for _, name := range names {
+		var found bool
+		for _, s := range All {
+			if s.Name == name {
+				result = append(result, s)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown scenario %q, supported scenarios: %s", name, strings.Join(Names(), ", "))
+		}
+	}
+
+	return result, nil
+}
+
+// Names returns the names of All.
+func Names() []string {
+	var names []string
+	for _, s := range All {
+		names = append(names, s.Name)
+	}
+
+	return names
+}
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of running a scenario.
+type Result struct {
+	Scenario string
+	Status   Status
+	// Err is the failure or the reason of skipping.
+	Err      error
+	Duration time.Duration
+}
+
+// Run runs the scenario against env within timeout.
+func Run(ctx context.Context, env Env, s Scenario, timeout time.Duration) Result {
+	if env.Logger == nil {
+		logger := log.New()
+		logger.SetLevel(log.PanicLevel)
+		env.Logger = logger
+	}
+	if env.HostKeyCallback == nil {
+		return Result{Scenario: s.Name, Status: StatusFail, Err: fmt.Errorf("host key callback is required")}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.Run(ctx, env)
+	result := Result{Scenario: s.Name, Status: StatusPass, Err: err, Duration: time.Since(start)}
+	switch {
+	case errors.Is(err, ErrSkipped):
+		result.Status = StatusSkip
+	case err != nil:
+		result.Status = StatusFail
+	}
+
+	return result
+}
+
+func testAuth(ctx context.Context, env Env) error {
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true})
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	// a client with an unauthorized key
+	other, err := newSigner()
+	if err != nil {
+		return err
+	}
+	if err := expectRejection(ctx, env, h.session, other, server.RejectionKeyNotAuthorized); err != nil {
+		return fmt.Errorf("unauthorized key: %w", err)
+	}
+
+	// a client joining a session that doesn't exist
+	session := h.Session()
+	session.SessionId = "upterm-verify-" + session.SessionId
+	if err := expectRejection(ctx, env, session, h.clientSigner, server.RejectionSessionNotFound); err != nil {
+		return fmt.Errorf("unknown session: %w", err)
+	}
+
+	c, err := joinSession(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		return fmt.Errorf("authorized key: %w", err)
+	}
+	defer c.Close()
+
+	return nil
+}
+
+func testAttach(ctx context.Context, env Env) error {
+	return attach(ctx, env)
+}
+
+func testCrossNode(ctx context.Context, env Env) error {
+	if env.clientURL() == env.HostURL {
+		return fmt.Errorf("%w: requires a client server URL of another node", ErrSkipped)
+	}
+
+	return attach(ctx, env)
+}
+
+// testSFTP uploads a file to the host and downloads it back over the sftp subsystem.
+func testSFTP(ctx context.Context, env Env) error {
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true, sftp: true})
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	c, err := dialClient(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		if c.rejection != nil {
+			return fmt.Errorf("error joining session: %w", c.rejection)
+		}
+		return fmt.Errorf("error joining session: %w", err)
+	}
+	defer c.Close()
+
+	sc, err := sftp.NewClient(c.sshClient)
+	if err != nil {
+		return fmt.Errorf("error starting sftp: %w", err)
+	}
+	defer sc.Close()
+
+	want := marker("client")
+	name := filepath.Join(h.dir, "upload")
+
+	f, err := sc.Create(name)
+	if err != nil {
+		return fmt.Errorf("error uploading file: %w", err)
+	}
+	if _, err := io.WriteString(f, want); err != nil {
+		f.Close()
+		return fmt.Errorf("error uploading file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error uploading file: %w", err)
+	}
+
+	if b, err := os.ReadFile(name); err != nil || string(b) != want {
+		return fmt.Errorf("uploaded file didn't reach the host: %q, %v", b, err)
+	}
+
+	f, err = sc.Open(name)
+	if err != nil {
+		return fmt.Errorf("error downloading file: %w", err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("error downloading file: %w", err)
+	}
+	if string(b) != want {
+		return fmt.Errorf("want downloaded file %q, got %q", want, b)
+	}
+
+	return nil
+}
+
+// attach verifies input of the host and the client reaches both of them.
+func attach(ctx context.Context, env Env) error {
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true})
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	c, err := joinSession(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	hostInput := marker("host")
+	if err := h.Type(hostInput); err != nil {
+		return err
+	}
+	if err := c.output.WaitFor(ctx, hostInput); err != nil {
+		return fmt.Errorf("client didn't see host input: %w", err)
+	}
+
+	clientInput := marker("client")
+	if err := c.Type(clientInput); err != nil {
+		return err
+	}
+	if err := h.output.WaitFor(ctx, clientInput); err != nil {
+		return fmt.Errorf("host didn't see client input: %w", err)
+	}
+
+	return nil
+}
+
+func testReadOnly(ctx context.Context, env Env) error {
+	h, err := shareSession(ctx, env, hostOptions{authorizeClient: true, readOnly: true})
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	c, err := joinSession(ctx, env, h.session, h.clientSigner)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	clientInput := marker("client")
+	if err := c.Type(clientInput); err != nil {
+		return err
+	}
+
+	// give the client input time to reach the host, where it would be echoed before the host input
+	select {
+	case <-time.After(readOnlyGracePeriod):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	hostInput := marker("host")
+	if err := h.Type(hostInput); err != nil {
+		return err
+	}
+	if err := c.output.WaitFor(ctx, hostInput); err != nil {
+		return fmt.Errorf("client didn't see host input: %w", err)
+	}
+
+	if h.output.Contains(clientInput) {
+		return fmt.Errorf("client input reached the read-only session")
+	}
+
+	return nil
+}
+
+func marker(who string) string {
+	return fmt.Sprintf("upterm-verify-%s-%d", who, time.Now().UnixNano())
+}
@@ -0,0 +1,25 @@
+package ftests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/ftests/scenarios"
+	"golang.org/x/crypto/ssh"
+)
+
+func testScenarios(t *testing.T, hostShareURL, hostNodeAddr, clientJoinURL string) {
+	env := scenarios.Env{
+		HostURL:         hostShareURL,
+		ClientURL:       clientJoinURL,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	for _, s := range scenarios.All {
+		r := scenarios.Run(context.Background(), env, s, 10*time.Second)
+		if r.Status == scenarios.StatusFail {
+			t.Errorf("scenario %s failed: %s", s.Name, r.Err)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package host
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"time"
 
 	"github.com/owenthereal/upterm/host/api"
 	"google.golang.org/grpc"
@@ -10,8 +15,35 @@ import (
 
 const (
 	AdminSockExt = ".sock"
+
+	defaultAdminDialTimeout    = 5 * time.Second
+	defaultAdminInitialBackoff = 50 * time.Millisecond
+	defaultAdminMaxBackoff     = time.Second
+)
+
+var (
+	// ErrAdminSocketNotFound is returned when the admin socket file doesn't exist.
+	ErrAdminSocketNotFound = errors.New("admin socket not found")
+	// ErrAdminUnavailable is returned when the admin socket exists but nothing is serving it,
+	// e.g. the host has crashed.
+	ErrAdminUnavailable = errors.New("admin socket unavailable")
 )
 
+// AdminDialError is returned when an admin client fails to connect to a socket before the dial timeout.
+// It wraps ErrAdminSocketNotFound, ErrAdminUnavailable, or the context error.
+type AdminDialError struct {
+	Socket string
+	Err    error
+}
+
+func (e *AdminDialError) Error() string {
+	return fmt.Sprintf("error dialing admin socket %s: %s", e.Socket, e.Err)
+}
+
+func (e *AdminDialError) Unwrap() error {
+	return e.Err
+}
+
 func AdminSocketFile(sessionID string) string {
 	return fmt.Sprintf("%s%s", sessionID, AdminSockExt)
 }
@@ -25,3 +57,81 @@ func AdminClient(socket string) (api.AdminServiceClient, error) {
 
 	return api.NewAdminServiceClient(conn), nil
 }
+
+type adminDialOptions struct {
+	timeout        time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+type AdminDialOption func(*adminDialOptions)
+
+// WithAdminDialTimeout sets how long to wait for the admin socket to be ready. It defaults to 5 seconds.
+func WithAdminDialTimeout(d time.Duration) AdminDialOption {
+	return func(o *adminDialOptions) {
+		o.timeout = d
+	}
+}
+
+// WithAdminDialBackoff sets the initial and the max backoff between retries.
+// The backoff doubles after each retry.
+func WithAdminDialBackoff(initial, max time.Duration) AdminDialOption {
+	return func(o *adminDialOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// DialAdminClient connects to the admin socket, retrying with backoff until the socket is served,
+// the dial timeout elapses or ctx is done. It returns an *AdminDialError on failure.
+func DialAdminClient(ctx context.Context, socket string, opts ...AdminDialOption) (api.AdminServiceClient, error) {
+	o := adminDialOptions{
+		timeout:        defaultAdminDialTimeout,
+		initialBackoff: defaultAdminInitialBackoff,
+		maxBackoff:     defaultAdminMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	backoff := o.initialBackoff
+	for {
+		err := probeAdminSocket(ctx, socket)
+		if err == nil {
+			return AdminClient(socket)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.Canceled) {
+				err = ctx.Err()
+			}
+			return nil, &AdminDialError{Socket: socket, Err: err}
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
+
+func probeAdminSocket(ctx context.Context, socket string) error {
+	if _, err := os.Stat(socket); err != nil {
+		return ErrAdminSocketNotFound
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return ErrAdminUnavailable
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,118 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/host/internal"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func Test_DialAdminClient(t *testing.T) {
+	// unix socket paths are limited in length, avoid t.TempDir()
+	dir, err := os.MkdirTemp("", "upterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, AdminSocketFile("session"))
+	em := emitter.New(1)
+	s := internal.AdminServer{
+		Session:      &api.GetSessionResponse{SessionId: "session"},
+		ClientRepo:   internal.NewClientRepo(),
+		Stats:        internal.NewStats(time.Now()),
+		EventEmitter: em,
+	}
+	defer func() { _ = s.Shutdown(context.Background()) }()
+
+	// serve after the client starts dialing
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = s.Serve(context.Background(), socket)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := DialAdminClient(ctx, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := c.GetSession(ctx, &api.GetSessionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "session", sess.SessionId; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	stream, err := c.WatchEvents(ctx, &api.WatchEventsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &api.Client{Id: "client", Addr: "127.0.0.1:1234"}
+	// the stream subscribes asynchronously, emit until an event is received
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				events.Emit(em, events.ClientJoined{Client: client})
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := string(events.KindClientJoined), e.Kind; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if diff := cmp.Diff(client, e.Client, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_DialAdminClient_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	notServed := filepath.Join(dir, AdminSocketFile("not-served"))
+	if err := os.WriteFile(notServed, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		socket string
+		want   error
+	}{
+		{socket: filepath.Join(dir, AdminSocketFile("not-found")), want: ErrAdminSocketNotFound},
+		{socket: notServed, want: ErrAdminUnavailable},
+	}
+
+	for _, c := range cases {
+		_, err := DialAdminClient(context.Background(), c.socket, WithAdminDialTimeout(100*time.Millisecond), WithAdminDialBackoff(10*time.Millisecond, 20*time.Millisecond))
+
+		var dialErr *AdminDialError
+		if !errors.As(err, &dialErr) {
+			t.Fatalf("want AdminDialError got=%v", err)
+		}
+		if !errors.Is(err, c.want) {
+			t.Fatalf("want=%s got=%s", c.want, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package admintest provides an in-memory api.AdminServiceClient for testing code that talks to an upterm host.
+package admintest
+
+import (
+	"context"
+	"io"
+
+	"github.com/owenthereal/upterm/host/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ api.AdminServiceClient = &AdminServiceClient{}
+
+// AdminServiceClient is a mock api.AdminServiceClient.
+// GetSession returns Session or Err. WatchEvents streams Events, then io.EOF.
+// SetSession applies the request to Session and returns it. KickClient removes the client from the connected
+// clients of Session, or returns a NotFound error if it isn't connected. NewSession appends the command to
+// NewSessions and returns Session as the spawned session. StreamOutput streams Output, then io.EOF.
+type AdminServiceClient struct {
+	Session     *api.GetSessionResponse
+	Events      []*api.SessionEvent
+	Output      []*api.OutputChunk
+	NewSessions [][]string
+	Err         error
+}
+
+func (c *AdminServiceClient) GetSession(ctx context.Context, in *api.GetSessionRequest, opts ...grpc.CallOption) (*api.GetSessionResponse, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	return c.Session, nil
+}
+
+func (c *AdminServiceClient) SetSession(ctx context.Context, in *api.SetSessionRequest, opts ...grpc.CallOption) (*api.GetSessionResponse, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	if in.ReadOnly != nil {
+		c.Session.ReadOnly = *in.ReadOnly
+	}
+
+	return c.Session, nil
+}
+
+func (c *AdminServiceClient) KickClient(ctx context.Context, in *api.KickClientRequest, opts ...grpc.CallOption) (*api.KickClientResponse, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	for i, client := range c.Session.ConnectedClients {
+		if client.Id == in.ClientId {
+			c.Session.ConnectedClients = append(c.Session.ConnectedClients[:i], c.Session.ConnectedClients[i+1:]...)
+			return &api.KickClientResponse{}, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "client %s isn't connected", in.ClientId)
+}
+
+func (c *AdminServiceClient) NewSession(ctx context.Context, in *api.NewSessionRequest, opts ...grpc.CallOption) (*api.GetSessionResponse, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	c.NewSessions = append(c.NewSessions, in.Command)
+
+	return c.Session, nil
+}
+
+func (c *AdminServiceClient) WatchEvents(ctx context.Context, in *api.WatchEventsRequest, opts ...grpc.CallOption) (api.AdminService_WatchEventsClient, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	return &watchEventsClient{ctx: ctx, events: c.Events}, nil
+}
+
+type watchEventsClient struct {
+	grpc.ClientStream
+
+	ctx    context.Context
+	events []*api.SessionEvent
+}
+
+func (c *watchEventsClient) Recv() (*api.SessionEvent, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(c.events) == 0 {
+		return nil, io.EOF
+	}
+
+	e := c.events[0]
+	c.events = c.events[1:]
+
+	return e, nil
+}
+
+func (c *watchEventsClient) Context() context.Context {
+	return c.ctx
+}
+
+func (c *watchEventsClient) CloseSend() error {
+	return nil
+}
+
+func (c *AdminServiceClient) StreamOutput(ctx context.Context, in *api.StreamOutputRequest, opts ...grpc.CallOption) (api.AdminService_StreamOutputClient, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	return &streamOutputClient{ctx: ctx, chunks: c.Output}, nil
+}
+
+type streamOutputClient struct {
+	grpc.ClientStream
+
+	ctx    context.Context
+	chunks []*api.OutputChunk
+}
+
+func (c *streamOutputClient) Recv() (*api.OutputChunk, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(c.chunks) == 0 {
+		return nil, io.EOF
+	}
+
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+
+	return chunk, nil
+}
+
+func (c *streamOutputClient) Context() context.Context {
+	return c.ctx
+}
+
+func (c *streamOutputClient) CloseSend() error {
+	return nil
+}
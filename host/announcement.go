@@ -0,0 +1,223 @@
+package host
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// AnnouncementNamespace is the namespace of announcement signatures, i.e. the -n of ssh-keygen -Y verify.
+const AnnouncementNamespace = "upterm-session"
+
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigHashAlg   = "sha512"
+	sshsigPEMHeader = "SSH SIGNATURE"
+	// sshsigLineWidth is the width of armored signatures of ssh-keygen.
+	sshsigLineWidth = 70
+)
+
+var ErrAnnouncementNotSigned = errors.New("announcement is not signed by the key")
+
+// Announcement is the invitation to a session the host shares with clients, e.g. over chat.
+// It's signed with an SSH key of the host in the format of 'ssh-keygen -Y sign', so that clients
+// can check that the join command hasn't been tampered with.
+type Announcement struct {
+	// Label describes the session to clients, e.g. "pairing on the release".
+	Label       string
+	JoinCommand string
+	// Fingerprint is the SHA256 fingerprint of the public key signing the announcement.
+	Fingerprint string
+}
+
+func (a Announcement) String() string {
+	var b strings.Builder
+	b.WriteString("upterm session announcement\n")
+	if a.Label != "" {
+		fmt.Fprintf(&b, "Label: %s\n", a.Label)
+	}
+	fmt.Fprintf(&b, "Join: %s\n", a.JoinCommand)
+	fmt.Fprintf(&b, "Signed by: %s\n", a.Fingerprint)
+
+	return b.String()
+}
+
+// SignAnnouncement announces the session with the join command and signs it with the signer.
+// It returns the announcement and its armored signature, which verifies with ssh-keygen:
+//
+//	ssh-keygen -Y verify -f allowed_signers -I IDENTITY -n upterm-session -s announcement.sig < announcement.txt
+func SignAnnouncement(label, joinCommand string, signer ssh.Signer) (string, []byte, error) {
+	a := Announcement{
+		Label:       label,
+		JoinCommand: joinCommand,
+		Fingerprint: utils.FingerprintSHA256(signer.PublicKey()),
+	}
+	text := a.String()
+
+	sig, err := sshsigSign(signer, AnnouncementNamespace, []byte(text))
+	if err != nil {
+		return "", nil, fmt.Errorf("error signing announcement: %w", err)
+	}
+
+	return text, sig, nil
+}
+
+// VerifyAnnouncement verifies the armored signature of the announcement. It returns the public key signing it,
+// which must be checked against the keys the host is known by, e.g. from GitHub.
+func VerifyAnnouncement(text string, signature []byte) (ssh.PublicKey, error) {
+	return sshsigVerify(AnnouncementNamespace, []byte(text), signature)
+}
+
+// AnnouncementSigner returns the signer to sign announcements with, preferring plain keys to certs
+// since verifying certs requires the cert authority, and keys not on security keys, which wait for a touch.
+func AnnouncementSigner(signers []ssh.Signer) (ssh.Signer, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no key to sign the announcement with")
+	}
+
+	var plain []ssh.Signer
+	for _, s := range signers {
+		if _, ok := s.PublicKey().(*ssh.Certificate); !ok {
+			plain = append(plain, s)
+		}
+	}
+	for _, s := range plain {
+		if !isSecurityKey(s.PublicKey()) {
+			return s, nil
+		}
+	}
+	if len(plain) > 0 {
+		return plain[0], nil
+	}
+
+	return signers[0], nil
+}
+
+// sshsigSignedData is what is signed in the SSHSIG format of OpenSSH, see PROTOCOL.sshsig.
+type sshsigSignedData struct {
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+type sshsigBlob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+func sshsigSign(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	h := sha512.Sum512(message)
+	data := sshsigData(namespace, sshsigHashAlg, h[:])
+
+	var (
+		sig *ssh.Signature
+		err error
+	)
+	// ssh-keygen doesn't accept RSA signatures with SHA-1
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshsigMagic), ssh.Marshal(sshsigBlob{
+		Version:   sshsigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: namespace,
+		HashAlg:   sshsigHashAlg,
+		Signature: ssh.Marshal(sig),
+	})...)
+
+	return armorSSHSIG(blob), nil
+}
+
+func sshsigVerify(namespace string, message, armored []byte) (ssh.PublicKey, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sshsigPEMHeader {
+		return nil, fmt.Errorf("invalid signature: no %s block", sshsigPEMHeader)
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshsigMagic)) {
+		return nil, fmt.Errorf("invalid signature: bad magic")
+	}
+
+	var blob sshsigBlob
+	if err := ssh.Unmarshal(block.Bytes[len(sshsigMagic):], &blob); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if blob.Version != sshsigVersion {
+		return nil, fmt.Errorf("unsupported signature version %d", blob.Version)
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("signature namespace %q doesn't match %q", blob.Namespace, namespace)
+	}
+
+	var hh hash.Hash
+	switch blob.HashAlg {
+	case "sha512":
+		hh = sha512.New()
+	case "sha256":
+		hh = sha256.New()
+	default:
+		return nil, fmt.Errorf("unsupported signature hash algorithm %q", blob.HashAlg)
+	}
+	hh.Write(message)
+
+	pk, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature key: %w", err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if err := pk.Verify(sshsigData(namespace, blob.HashAlg, hh.Sum(nil)), &sig); err != nil {
+		return nil, ErrAnnouncementNotSigned
+	}
+
+	return pk, nil
+}
+
+func sshsigData(namespace, hashAlg string, h []byte) []byte {
+	return append([]byte(sshsigMagic), ssh.Marshal(sshsigSignedData{
+		Namespace: namespace,
+		HashAlg:   hashAlg,
+		Hash:      h,
+	})...)
+}
+
+// armorSSHSIG armors the signature like ssh-keygen, which wraps lines at 70 characters rather than the 64 of PEM.
+func armorSSHSIG(blob []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(blob)
+
+	var b bytes.Buffer
+	b.WriteString("-----BEGIN " + sshsigPEMHeader + "-----\n")
+	for len(enc) > sshsigLineWidth {
+		b.WriteString(enc[:sshsigLineWidth] + "\n")
+		enc = enc[sshsigLineWidth:]
+	}
+	b.WriteString(enc + "\n")
+	b.WriteString("-----END " + sshsigPEMHeader + "-----\n")
+
+	return b.Bytes()
+}
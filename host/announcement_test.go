@@ -0,0 +1,97 @@
+package host
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_SignAnnouncement(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]interface{}{
+		"ed25519": edKey,
+		"rsa":     rsaKey,
+		"ecdsa":   ecKey,
+	} {
+		t.Run(name, func(t *testing.T) {
+			signer, err := ssh.NewSignerFromKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			text, sig, err := SignAnnouncement("pairing", "ssh session@uptermd.upterm.dev", signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range []string{"Label: pairing\n", "Join: ssh session@uptermd.upterm.dev\n", utils.FingerprintSHA256(signer.PublicKey())} {
+				if !strings.Contains(text, want) {
+					t.Fatalf("announcement %q doesn't contain %q", text, want)
+				}
+			}
+
+			pk, err := VerifyAnnouncement(text, sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utils.KeysEqual(pk, signer.PublicKey()) {
+				t.Fatal("announcement is verified with another key")
+			}
+
+			tampered := strings.Replace(text, "session@", "attacker@", 1)
+			if _, err := VerifyAnnouncement(tampered, sig); !errors.Is(err, ErrAnnouncementNotSigned) {
+				t.Fatalf("want tampered announcement to fail verification, got %v", err)
+			}
+
+			verifyWithSSHKeygen(t, text, sig, signer.PublicKey())
+		})
+	}
+}
+
+// verifyWithSSHKeygen checks that the signature is compatible with OpenSSH if ssh-keygen is installed.
+func verifyWithSSHKeygen(t *testing.T, text string, sig []byte, pk ssh.PublicKey) {
+	keygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Log("ssh-keygen is not installed")
+		return
+	}
+
+	dir := t.TempDir()
+	signers := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(signers, []byte("host@upterm "+string(ssh.MarshalAuthorizedKey(pk))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sigFile := filepath.Join(dir, "announcement.sig")
+	if err := os.WriteFile(sigFile, sig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(keygen, "-Y", "verify", "-f", signers, "-I", "host@upterm", "-n", AnnouncementNamespace, "-s", sigFile)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen failed to verify the signature: %s: %s", err, out)
+	}
+}
@@ -23,6 +23,11 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type AdminServiceClient interface {
 	GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error)
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (AdminService_WatchEventsClient, error)
+	SetSession(ctx context.Context, in *SetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error)
+	KickClient(ctx context.Context, in *KickClientRequest, opts ...grpc.CallOption) (*KickClientResponse, error)
+	NewSession(ctx context.Context, in *NewSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error)
+	StreamOutput(ctx context.Context, in *StreamOutputRequest, opts ...grpc.CallOption) (AdminService_StreamOutputClient, error)
 }
 
 type adminServiceClient struct {
@@ -42,11 +47,107 @@ func (c *adminServiceClient) GetSession(ctx context.Context, in *GetSessionReque
 	return out, nil
 }
 
+func (c *adminServiceClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (AdminService_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AdminService_ServiceDesc.Streams[0], "/api.AdminService/WatchEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminServiceWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AdminService_WatchEventsClient interface {
+	Recv() (*SessionEvent, error)
+	grpc.ClientStream
+}
+
+type adminServiceWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminServiceWatchEventsClient) Recv() (*SessionEvent, error) {
+	m := new(SessionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminServiceClient) SetSession(ctx context.Context, in *SetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error) {
+	out := new(GetSessionResponse)
+	err := c.cc.Invoke(ctx, "/api.AdminService/SetSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) KickClient(ctx context.Context, in *KickClientRequest, opts ...grpc.CallOption) (*KickClientResponse, error) {
+	out := new(KickClientResponse)
+	err := c.cc.Invoke(ctx, "/api.AdminService/KickClient", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) NewSession(ctx context.Context, in *NewSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error) {
+	out := new(GetSessionResponse)
+	err := c.cc.Invoke(ctx, "/api.AdminService/NewSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) StreamOutput(ctx context.Context, in *StreamOutputRequest, opts ...grpc.CallOption) (AdminService_StreamOutputClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AdminService_ServiceDesc.Streams[1], "/api.AdminService/StreamOutput", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminServiceStreamOutputClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AdminService_StreamOutputClient interface {
+	Recv() (*OutputChunk, error)
+	grpc.ClientStream
+}
+
+type adminServiceStreamOutputClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminServiceStreamOutputClient) Recv() (*OutputChunk, error) {
+	m := new(OutputChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // AdminServiceServer is the server API for AdminService service.
 // All implementations should embed UnimplementedAdminServiceServer
 // for forward compatibility
 type AdminServiceServer interface {
 	GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error)
+	WatchEvents(*WatchEventsRequest, AdminService_WatchEventsServer) error
+	SetSession(context.Context, *SetSessionRequest) (*GetSessionResponse, error)
+	KickClient(context.Context, *KickClientRequest) (*KickClientResponse, error)
+	NewSession(context.Context, *NewSessionRequest) (*GetSessionResponse, error)
+	StreamOutput(*StreamOutputRequest, AdminService_StreamOutputServer) error
 }
 
 // UnimplementedAdminServiceServer should be embedded to have forward compatible implementations.
@@ -56,6 +157,21 @@ type UnimplementedAdminServiceServer struct {
 func (UnimplementedAdminServiceServer) GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
 }
+func (UnimplementedAdminServiceServer) WatchEvents(*WatchEventsRequest, AdminService_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedAdminServiceServer) SetSession(context.Context, *SetSessionRequest) (*GetSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSession not implemented")
+}
+func (UnimplementedAdminServiceServer) KickClient(context.Context, *KickClientRequest) (*KickClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KickClient not implemented")
+}
+func (UnimplementedAdminServiceServer) NewSession(context.Context, *NewSessionRequest) (*GetSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewSession not implemented")
+}
+func (UnimplementedAdminServiceServer) StreamOutput(*StreamOutputRequest, AdminService_StreamOutputServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamOutput not implemented")
+}
 
 // UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to AdminServiceServer will
@@ -86,6 +202,102 @@ func _AdminService_GetSession_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AdminService_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServiceServer).WatchEvents(m, &adminServiceWatchEventsServer{stream})
+}
+
+type AdminService_WatchEventsServer interface {
+	Send(*SessionEvent) error
+	grpc.ServerStream
+}
+
+type adminServiceWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminServiceWatchEventsServer) Send(m *SessionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AdminService_SetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AdminService/SetSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetSession(ctx, req.(*SetSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_KickClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KickClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).KickClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AdminService/KickClient",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).KickClient(ctx, req.(*KickClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_NewSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).NewSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AdminService/NewSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).NewSession(ctx, req.(*NewSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_StreamOutput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamOutputRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServiceServer).StreamOutput(m, &adminServiceStreamOutputServer{stream})
+}
+
+type AdminService_StreamOutputServer interface {
+	Send(*OutputChunk) error
+	grpc.ServerStream
+}
+
+type adminServiceStreamOutputServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminServiceStreamOutputServer) Send(m *OutputChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -97,7 +309,30 @@ var AdminService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetSession",
 			Handler:    _AdminService_GetSession_Handler,
 		},
+		{
+			MethodName: "SetSession",
+			Handler:    _AdminService_SetSession_Handler,
+		},
+		{
+			MethodName: "KickClient",
+			Handler:    _AdminService_KickClient_Handler,
+		},
+		{
+			MethodName: "NewSession",
+			Handler:    _AdminService_NewSession_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _AdminService_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamOutput",
+			Handler:       _AdminService_StreamOutput_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
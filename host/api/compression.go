@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionZstd is the algorithm of FeatureCompression compressing file transfers with Zstandard.
+const CompressionZstd = "zstd"
+
+// CompressionLevels are the levels of compressing file transfers, from the fastest to the best ratio.
+var CompressionLevels = []string{"fastest", "default", "better", "best"}
+
+// ParseCompressionLevel parses one of CompressionLevels.
+func ParseCompressionLevel(s string) (zstd.EncoderLevel, error) {
+	ok, level := zstd.EncoderLevelFromString(s)
+	if !ok {
+		return 0, fmt.Errorf("unsupported compression level %q, must be one of %s", s, strings.Join(CompressionLevels, ", "))
+	}
+
+	return level, nil
+}
+
+// CompressedStream compresses what's written to a stream and decompresses what's read from it with zstd,
+// e.g. the SFTP or exec channel of a file transfer whose peer agreed to FeatureCompression.
+// Each write is flushed, so that request-response protocols like SFTP don't stall.
+type CompressedStream struct {
+	rw  io.ReadWriter
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+
+	mu        sync.Mutex
+	closedEnc bool
+	// closeDec closes the decoder once reads fail. It's closed by the reader, since it can't be closed
+	// concurrently with reads.
+	closeDec sync.Once
+}
+
+// NewCompressedStream returns the stream compressing writes to rw at level and decompressing reads from it.
+func NewCompressedStream(rw io.ReadWriter, level zstd.EncoderLevel) (*CompressedStream, error) {
+	enc, err := zstd.NewWriter(rw, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	// decode synchronously, without reading ahead of what's been received
+	dec, err := zstd.NewReader(rw, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		_ = enc.Close()
+		return nil, err
+	}
+
+	return &CompressedStream{
+		rw:  rw,
+		enc: enc,
+		dec: dec,
+	}, nil
+}
+
+func (s *CompressedStream) Read(p []byte) (int, error) {
+	n, err := s.dec.Read(p)
+	if err != nil {
+		s.closeDec.Do(s.dec.Close)
+	}
+
+	return n, err
+}
+
+func (s *CompressedStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.enc.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, s.enc.Flush()
+}
+
+// CloseWrite ends the compressed stream, and closes the writes of the underlying stream if it supports it,
+// e.g. ssh.Channel.
+func (s *CompressedStream) CloseWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closedEnc {
+		return nil
+	}
+	s.closedEnc = true
+
+	if err := s.enc.Close(); err != nil {
+		return err
+	}
+	if cw, ok := s.rw.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+// Close ends the compressed stream and closes the underlying stream if it's an io.Closer, which fails pending reads.
+func (s *CompressedStream) Close() error {
+	err := s.CloseWrite()
+	if c, ok := s.rw.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func Test_CompressedStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	s1, err := NewCompressedStream(c1, zstd.SpeedDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := NewCompressedStream(c2, zstd.SpeedFastest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each write is flushed, so that a request is read before the stream ends
+	req := []byte("request")
+	go func() {
+		_, _ = s1.Write(req)
+	}()
+	got := make([]byte, len(req))
+	if _, err := io.ReadFull(s2, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req, got) {
+		t.Fatalf("want=%q got=%q", req, got)
+	}
+
+	resp := bytes.Repeat([]byte("response"), 4096)
+	go func() {
+		_, _ = s2.Write(resp)
+		_ = s2.Close()
+	}()
+	got, err = io.ReadAll(s1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, got) {
+		t.Fatalf("want %d bytes, got %d", len(resp), len(got))
+	}
+}
+
+func Test_ParseCompressionLevel(t *testing.T) {
+	for _, s := range CompressionLevels {
+		if _, err := ParseCompressionLevel(s); err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+	}
+
+	if _, err := ParseCompressionLevel("ultra"); err == nil {
+		t.Fatal("expect error for unsupported level")
+	}
+}
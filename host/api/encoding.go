@@ -1,10 +1,7 @@
 package api
 
 import (
-	"encoding/base64"
-	"fmt"
-	"strings"
-
+	"github.com/owenthereal/upterm/routing"
 	"github.com/owenthereal/upterm/upterm"
 )
 
@@ -19,12 +16,11 @@ func EncodeIdentifierSession(session *GetSessionResponse) (string, error) {
 }
 
 func EncodeIdentifier(id *Identifier) (string, error) {
-	result := id.Id
 	if id.Type == Identifier_CLIENT {
-		result += ":" + base64.URLEncoding.EncodeToString([]byte(id.NodeAddr))
+		return routing.Encode(id.Id, id.NodeAddr)
 	}
 
-	return result, nil
+	return id.Id, nil
 }
 
 func DecodeIdentifier(id, clientVersion string) (*Identifier, error) {
@@ -37,19 +33,14 @@ func DecodeIdentifier(id, clientVersion string) (*Identifier, error) {
 	}
 
 	// client
-	split := strings.SplitN(id, ":", 2)
-	if len(split) != 2 {
-		return nil, fmt.Errorf("invalid client session id: %s", id)
-	}
-
-	nodeAddr, err := base64.URLEncoding.DecodeString(split[1])
+	sessionID, nodeAddr, err := routing.Decode(id)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Identifier{
-		Id:       split[0],
+		Id:       sessionID,
 		Type:     Identifier_CLIENT,
-		NodeAddr: string(nodeAddr),
+		NodeAddr: nodeAddr,
 	}, nil
 }
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/owenthereal/upterm/upterm"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// FeatureKeepAlive is the interval of keepalives sent to the client, e.g. 15s.
+	// Hosts agree to intervals down to a minimum and never longer than their own.
+	FeatureKeepAlive = "keepalive-interval"
+	// FeatureCompression is a comma-separated list of compression algorithms of the client
+	// in the order of preference. Hosts reply with the algorithm they pick, e.g. CompressionZstd,
+	// and compress SFTP and exec channels with it. Terminals are never compressed.
+	FeatureCompression = "compression"
+	// FeatureLatencyProbe lets the client measure the round trip to the host with
+	// upterm-ping@upterm.dev requests, which the host replies to with the request payload.
+	FeatureLatencyProbe = "latency-probe"
+	// FeatureRecordingNotice tells the host that the client displays recording notices.
+	// Hosts reply whether the session is recorded, true or false.
+	FeatureRecordingNotice = "recording-notice"
+	// FeatureViewOnly tells the host that the client only watches the session, e.g. the telnet bridge of a server
+	// relaying it to viewers. Hosts reply true if they let viewers watch, and discard the input of the client.
+	FeatureViewOnly = "view-only"
+)
+
+// NegotiateFeatures proposes features to the host of a joined session before a session channel is opened.
+// It returns the features the host agrees to, which are empty if the host doesn't support negotiation.
+func NegotiateFeatures(conn ssh.Conn, proposed map[string]string) (map[string]string, error) {
+	b, err := proto.Marshal(&Features{Features: proposed})
+	if err != nil {
+		return nil, err
+	}
+
+	ok, reply, err := conn.SendRequest(upterm.HostFeaturesRequestType, true, b)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// hosts of old versions reject unknown requests
+		return map[string]string{}, nil
+	}
+
+	var agreed Features
+	if err := proto.Unmarshal(reply, &agreed); err != nil {
+		return nil, fmt.Errorf("error decoding features: %w", err)
+	}
+	if agreed.Features == nil {
+		agreed.Features = map[string]string{}
+	}
+
+	return agreed.Features, nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/owenthereal/upterm/upterm"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrWhoAmIUnsupported is returned by RequestWhoAmI if the host is of an old version that doesn't reply.
+var ErrWhoAmIUnsupported = errors.New("the host doesn't support whoami")
+
+// RequestWhoAmI asks the host of a joined session how it sees the connection of the client.
+func RequestWhoAmI(conn ssh.Conn) (*WhoAmI, error) {
+	ok, reply, err := conn.SendRequest(upterm.HostWhoAmIRequestType, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWhoAmIUnsupported
+	}
+
+	var w WhoAmI
+	if err := proto.Unmarshal(reply, &w); err != nil {
+		return nil, fmt.Errorf("error decoding whoami: %w", err)
+	}
+
+	return &w, nil
+}
@@ -0,0 +1,211 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+const (
+	// asciinemaSubprotocol is the WebSocket subprotocol of the producers of live streams of asciinema-server,
+	// which sends the header and the events of an asciicast v2 recording as text messages.
+	asciinemaSubprotocol = "v2.asciicast"
+	// asciinemaWriteTimeout bounds sending a message to the live stream.
+	asciinemaWriteTimeout = 10 * time.Second
+
+	// the window of the live stream until the terminal of the session is sized
+	asciinemaDefaultCols = 80
+	asciinemaDefaultRows = 24
+)
+
+// asciicastHeader is the header of an asciicast v2 recording.
+type asciicastHeader struct {
+	Version   int   `json:"version"`
+	Width     int32 `json:"width"`
+	Height    int32 `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// ParseAsciinemaURL parses the producer URL of a live stream of asciinema-server, e.g.
+// wss://asciinema.org/ws/S/TOKEN. http and https URLs are dialed with ws and wss.
+func ParseAsciinemaURL(u string) (*url.URL, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing asciinema server url: %w", err)
+	}
+
+	switch pu.Scheme {
+	case "ws", "wss":
+	case "http":
+		pu.Scheme = "ws"
+	case "https":
+		pu.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported asciinema server url %q, must be ws, wss, http, or https", u)
+	}
+	if pu.Host == "" {
+		return nil, fmt.Errorf("asciinema server url %q has no host", u)
+	}
+
+	return pu, nil
+}
+
+// StreamAsciinema relays the output of the session of the admin client to the live stream of asciinema-server
+// with the producer URL u, until the session ends or ctx is done. Viewers watch the stream read-only in their
+// browsers without joining the session.
+func StreamAsciinema(ctx context.Context, admin api.AdminServiceClient, u *url.URL) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := admin.StreamOutput(ctx, &api.StreamOutputRequest{})
+	if err != nil {
+		return fmt.Errorf("error streaming output: %w", err)
+	}
+	// the stream starts with the window of the terminal
+	first, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("error streaming output: %w", err)
+	}
+
+	d := &websocket.Dialer{
+		Proxy:            websocket.DefaultDialer.Proxy,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		Subprotocols:     []string{asciinemaSubprotocol},
+	}
+	ws, _, err := d.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error connecting to asciinema server: %w", err)
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != asciinemaSubprotocol {
+		return fmt.Errorf("asciinema server doesn't support the %s protocol", asciinemaSubprotocol)
+	}
+
+	// handle pings and the server closing the stream
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				closed <- err
+				cancel()
+				return
+			}
+		}
+	}()
+
+	r := &asciicastRelay{ws: ws, start: time.UnixMilli(first.TimeUnixMs)}
+	err = r.Relay(first, stream)
+	if ctx.Err() != nil {
+		select {
+		case err := <-closed:
+			return fmt.Errorf("asciinema server closed the stream: %w", err)
+		default:
+			return ctx.Err()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// the session ended
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(asciinemaWriteTimeout))
+
+	return nil
+}
+
+type asciicastRelay struct {
+	ws    *websocket.Conn
+	start time.Time
+	// pending is the start of a UTF-8 character split across chunks of output, which JSON can't encode alone.
+	pending    []byte
+	cols, rows int32
+}
+
+// Relay sends the header with the window of first, and then the output of stream as events until it ends.
+func (r *asciicastRelay) Relay(first *api.OutputChunk, stream api.AdminService_StreamOutputClient) error {
+	r.cols, r.rows = first.Cols, first.Rows
+	if r.cols == 0 || r.rows == 0 {
+		r.cols, r.rows = asciinemaDefaultCols, asciinemaDefaultRows
+	}
+	if err := r.send(asciicastHeader{Version: 2, Width: r.cols, Height: r.rows, Timestamp: r.start.Unix()}); err != nil {
+		return err
+	}
+
+	chunk := first
+	for {
+		if err := r.event(chunk); err != nil {
+			return err
+		}
+
+		var err error
+		chunk, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error streaming output: %w", err)
+		}
+	}
+}
+
+func (r *asciicastRelay) event(chunk *api.OutputChunk) error {
+	t := float64(chunk.TimeUnixMs-r.start.UnixMilli()) / 1000
+	if t < 0 {
+		t = 0
+	}
+
+	if len(chunk.Data) == 0 {
+		if chunk.Cols == 0 || chunk.Rows == 0 || (chunk.Cols == r.cols && chunk.Rows == r.rows) {
+			return nil
+		}
+		r.cols, r.rows = chunk.Cols, chunk.Rows
+		return r.send([]any{t, "r", fmt.Sprintf("%dx%d", r.cols, r.rows)})
+	}
+
+	data := append(r.pending, chunk.Data...)
+	data, r.pending = splitUTF8(data)
+	if len(data) == 0 {
+		return nil
+	}
+
+	return r.send([]any{t, "o", string(data)})
+}
+
+func (r *asciicastRelay) send(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_ = r.ws.SetWriteDeadline(time.Now().Add(asciinemaWriteTimeout))
+	if err := r.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return fmt.Errorf("error sending to asciinema server: %w", err)
+	}
+
+	return nil
+}
+
+// splitUTF8 splits p before a UTF-8 character incomplete at its end, if any.
+func splitUTF8(p []byte) ([]byte, []byte) {
+	// a character is at most utf8.UTFMax bytes
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			return p[:i], append([]byte(nil), p[i:]...)
+		}
+		break
+	}
+
+	return p, nil
+}
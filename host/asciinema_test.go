@@ -0,0 +1,87 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+	"github.com/owenthereal/upterm/host/admintest"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+func Test_StreamAsciinema(t *testing.T) {
+	messages := make(chan []string, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{asciinemaSubprotocol}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws/S/token" {
+			http.NotFound(w, r)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+
+		var msgs []string
+		for {
+			typ, b, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			if typ != websocket.TextMessage {
+				t.Errorf("want text messages, got %d", typ)
+			}
+			msgs = append(msgs, string(b))
+		}
+		messages <- msgs
+	}))
+	defer srv.Close()
+
+	admin := &admintest.AdminServiceClient{
+		Output: []*api.OutputChunk{
+			{Cols: 100, Rows: 30, TimeUnixMs: 1700000000000},
+			{Data: []byte("héllo \xe2\x9c"), Cols: 100, Rows: 30, TimeUnixMs: 1700000000500},
+			{Data: []byte("\x93\r\n"), Cols: 100, Rows: 30, TimeUnixMs: 1700000001000},
+			// the same window isn't resent
+			{Cols: 100, Rows: 30, TimeUnixMs: 1700000001000},
+			{Cols: 80, Rows: 24, TimeUnixMs: 1700000001250},
+		},
+	}
+
+	u, err := ParseAsciinemaURL(srv.URL + "/ws/S/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "ws" {
+		t.Fatalf("want ws, got %s", u.Scheme)
+	}
+	if err := StreamAsciinema(context.Background(), admin, u); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-messages
+	want := []string{
+		`{"version":2,"width":100,"height":30,"timestamp":1700000000}`,
+		`[0.5,"o","héllo "]`,
+		`[1,"o","✓\r\n"]`,
+		`[1.25,"r","80x24"]`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	for _, m := range got {
+		if !json.Valid([]byte(m)) {
+			t.Fatalf("want JSON, got %q", m)
+		}
+	}
+
+	if _, err := ParseAsciinemaURL("ftp://asciinema.org/ws/S/token"); err == nil {
+		t.Fatal("expect error for an unsupported scheme")
+	}
+}
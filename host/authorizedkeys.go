@@ -1,15 +1,17 @@
 package host
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"path/filepath"
 	"time"
 
-	"github.com/cli/go-gh/v2/pkg/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/utils"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
@@ -19,11 +21,17 @@ const (
 	gitHubKeysUrlFmt    = "https://github.com/%s"
 	gitLabKeysUrlFmt    = "https://gitlab.com/%s"
 	sourceHutKeysUrlFmt = "https://meta.sr.ht/~%s"
+
+	// keyFetchTimeout is how long fetching keys may take.
+	keyFetchTimeout = 5 * time.Second
 )
 
 type AuthorizedKey struct {
 	PublicKeys []ssh.PublicKey
 	Comment    string
+	// Names are the display names of the keys by fingerprint, e.g. the comments of the keys
+	// or the users the keys belong to.
+	Names identity.Names
 }
 
 func AuthorizedKeysFromFile(file string) (*AuthorizedKey, error) {
@@ -35,124 +43,185 @@ func AuthorizedKeysFromFile(file string) (*AuthorizedKey, error) {
 	return parseAuthorizedKeys(authorizedKeysBytes, file)
 }
 
-func CodebergUserAuthorizedKeys(usernames []string) ([]*AuthorizedKey, error) {
-	return usersPublicKeys(codebergKeysUrlFmt, usernames)
+// CertAuthoritiesFromFile reads CA public keys from file.
+// Clients authenticating with a user cert signed by one of the CAs are authorized.
+func CertAuthoritiesFromFile(file string) (*AuthorizedKey, error) {
+	keysBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAuthorizedKeys(keysBytes, "cert-authority "+file)
 }
 
-func GitHubUserAuthorizedKeys(usernames []string, logger *logrus.Logger) ([]*AuthorizedKey, error) {
+// AuthorizedKeysFromURL fetches keys in the authorized_keys format from u, e.g. the keys of a team.
+// Responses are cached in cacheDir and revalidated with ETags.
+// The cached keys are used when u is unreachable.
+func AuthorizedKeysFromURL(u string, cacheDir string, logger logrus.FieldLogger) (*AuthorizedKey, error) {
+	keysBytes, err := fetchWithETagCache(u, cacheDir, logger)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", u, err)
+	}
+
+	ak, err := parseAuthorizedKeys(keysBytes, u)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", u, err)
+	}
+
+	return ak, nil
+}
+
+// MergeAuthorizedKeys deduplicates public keys across sources.
+// Sources earlier in aks take precedence: a key is kept in the first source listing it.
+// Sources without keys left are dropped. Keys without names are named by later sources listing them.
+func MergeAuthorizedKeys(aks []*AuthorizedKey) []*AuthorizedKey {
 	var (
-		authorizedKeys []*AuthorizedKey
-		seen           = make(map[string]bool)
+		merged []*AuthorizedKey
+		// seen maps the fingerprints of the kept keys to the names of the sources keeping them
+		seen = make(map[string]identity.Names)
 	)
-	for _, username := range usernames {
-		if _, found := seen[username]; !found {
-			seen[username] = true
+	for _, ak := range aks {
+		if ak == nil {
+			continue
+		}
 
-			pks, err := githubUserPublicKeys(username, logger)
-			if err != nil {
-				return nil, err
+		var (
+			pks   []ssh.PublicKey
+			names = make(identity.Names)
+		)
+		for _, pk := range ak.PublicKeys {
+			fp := utils.FingerprintSHA256(pk)
+			name := ak.Names[fp]
+			if kept, ok := seen[fp]; ok {
+				if _, named := kept[fp]; !named && name != "" {
+					kept[fp] = name
+				}
+				continue
 			}
 
-			aks, err := parseAuthorizedKeys(pks, username)
-			if err != nil {
-				return nil, err
+			seen[fp] = names
+			pks = append(pks, pk)
+			if name != "" {
+				names[fp] = name
 			}
+		}
 
-			authorizedKeys = append(authorizedKeys, aks)
+		if len(pks) > 0 {
+			merged = append(merged, &AuthorizedKey{
+				PublicKeys: pks,
+				Comment:    ak.Comment,
+				Names:      names,
+			})
 		}
 	}
 
-	return authorizedKeys, nil
-}
-
-func GitLabUserAuthorizedKeys(usernames []string) ([]*AuthorizedKey, error) {
-	return usersPublicKeys(gitLabKeysUrlFmt, usernames)
-}
-
-func SourceHutUserAuthorizedKeys(usernames []string) ([]*AuthorizedKey, error) {
-	return usersPublicKeys(sourceHutKeysUrlFmt, usernames)
+	return merged
 }
 
 func parseAuthorizedKeys(keysBytes []byte, comment string) (*AuthorizedKey, error) {
-	var authorizedKeys []ssh.PublicKey
+	var (
+		authorizedKeys []ssh.PublicKey
+		names          = make(identity.Names)
+	)
 	for len(keysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(keysBytes)
+		pubKey, keyComment, _, rest, err := ssh.ParseAuthorizedKey(keysBytes)
 		if err != nil {
 			return nil, err
 		}
 
 		authorizedKeys = append(authorizedKeys, pubKey)
+		if keyComment != "" {
+			names[utils.FingerprintSHA256(pubKey)] = keyComment
+		}
 		keysBytes = rest
 	}
 
 	return &AuthorizedKey{
 		PublicKeys: authorizedKeys,
 		Comment:    comment,
+		Names:      names,
 	}, nil
 }
 
-func githubUserPublicKeys(username string, logger *logrus.Logger) ([]byte, error) {
-	client, err := api.DefaultRESTClient()
-	if err != nil {
-		if strings.Contains(err.Error(), "authentication token not found for host") {
-			// fallback to use the public GH API
-			logger.WithError(err).Warn("no GitHub token found, falling back to public API")
-			return userPublicKeys(gitHubKeysUrlFmt, username)
-		}
-
-		return nil, err
+// nameKeys names all the keys after the user they belong to, since the comments of keys
+// published by code hosts are usually stripped or meaningless.
+func (ak *AuthorizedKey) nameKeys(username string) {
+	for _, pk := range ak.PublicKeys {
+		ak.Names[utils.FingerprintSHA256(pk)] = username
 	}
+}
 
-	keys := []struct {
-		Key string `json:"key"`
-	}{}
-	if err := client.Get(fmt.Sprintf("users/%s/keys", url.PathEscape(username)), &keys); err != nil {
-		return nil, err
-	}
+// AuthorizedKeyNames returns the display names of the keys of aks. Sources earlier in aks take precedence.
+func AuthorizedKeyNames(aks []*AuthorizedKey) identity.Names {
+	names := make(identity.Names)
+	for _, ak := range aks {
+		if ak == nil {
+			continue
+		}
 
-	var authorizedKeys []string
-	for _, key := range keys {
-		authorizedKeys = append(authorizedKeys, key.Key)
+		for fp, name := range ak.Names {
+			if _, ok := names[fp]; !ok {
+				names[fp] = name
+			}
+		}
 	}
 
-	return []byte(strings.Join(authorizedKeys, "\n")), nil
+	return names
 }
 
-func usersPublicKeys(urlFmt string, usernames []string) ([]*AuthorizedKey, error) {
-	var (
-		authorizedKeys []*AuthorizedKey
-		seen           = make(map[string]bool)
-	)
-	for _, username := range usernames {
-		if _, found := seen[username]; !found {
-			seen[username] = true
+func fetchWithETagCache(u string, cacheDir string, logger logrus.FieldLogger) ([]byte, error) {
+	sum := sha256.Sum256([]byte(u))
+	name := filepath.Join(cacheDir, hex.EncodeToString(sum[:]))
+	bodyFile, etagFile := name+".keys", name+".etag"
 
-			keyBytes, err := userPublicKeys(urlFmt, username)
-			if err != nil {
-				return nil, fmt.Errorf("[%s]: %s", username, err)
-			}
-			userKeys, err := parseAuthorizedKeys(keyBytes, username)
-			if err != nil {
-				return nil, fmt.Errorf("[%s]: %s", username, err)
-			}
+	cached, cacheErr := os.ReadFile(bodyFile)
+	etag, _ := os.ReadFile(etagFile)
 
-			authorizedKeys = append(authorizedKeys, userKeys)
-		}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cacheErr == nil && len(etag) > 0 {
+		req.Header.Set("If-None-Match", string(etag))
 	}
-	return authorizedKeys, nil
-}
-
-func userPublicKeys(urlFmt string, username string) ([]byte, error) {
-	path := url.PathEscape(fmt.Sprintf("%s.keys", username))
 
 	client := http.Client{
 		Timeout: 5 * time.Second,
 	}
-	resp, err := client.Get(fmt.Sprintf(urlFmt, path))
+	resp, err := client.Do(req)
 	if err != nil {
+		if cacheErr == nil {
+			logger.WithError(err).WithField("url", u).Warn("error fetching authorized keys, using cached keys")
+			return cached, nil
+		}
+
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cacheErr == nil:
+		return cached, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(bodyFile, body, 0600); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(etagFile, []byte(etag), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
 }
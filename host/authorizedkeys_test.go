@@ -0,0 +1,113 @@
+package host
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/owenthereal/upterm/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testPublicKey2 = `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINwWa43kU++lbWKaZOpPotpH6uf5jYKIuHBZUT/PEddZ`
+)
+
+func Test_MergeAuthorizedKeys(t *testing.T) {
+	pk1, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk2, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := MergeAuthorizedKeys([]*AuthorizedKey{
+		{PublicKeys: []ssh.PublicKey{pk1}, Comment: "file"},
+		nil,
+		{PublicKeys: []ssh.PublicKey{pk1, pk2}, Comment: "github"},
+		{PublicKeys: []ssh.PublicKey{pk2}, Comment: "url"},
+	})
+
+	if want, got := 2, len(merged); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+	if want, got := "file", merged[0].Comment; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := "github", merged[1].Comment; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := 1, len(merged[1].PublicKeys); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+}
+
+func Test_AuthorizedKeysFromURL(t *testing.T) {
+	var requests, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testPublicKey + "\n" + testPublicKey2 + "\n"))
+	}))
+
+	cacheDir := t.TempDir()
+	logger := logrus.New()
+
+	for i := 0; i < 2; i++ {
+		ak, err := AuthorizedKeysFromURL(srv.URL, cacheDir, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := 2, len(ak.PublicKeys); want != got {
+			t.Fatalf("want=%d got=%d", want, got)
+		}
+	}
+	if want, got := int32(2), requests.Load(); want != got {
+		t.Fatalf("requests: want=%d got=%d", want, got)
+	}
+	if want, got := int32(1), notModified.Load(); want != got {
+		t.Fatalf("not modified responses: want=%d got=%d", want, got)
+	}
+
+	// cached keys are used when the URL is unreachable
+	srv.Close()
+	ak, err := AuthorizedKeysFromURL(srv.URL, cacheDir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(ak.PublicKeys); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+}
+
+func Test_AuthorizedKeyNames(t *testing.T) {
+	ak, err := parseAuthorizedKeys([]byte(testPublicKey+" alice@laptop\n"+testPublicKey2+"\n"), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk1, pk2 := ak.PublicKeys[0], ak.PublicKeys[1]
+
+	user, err := parseAuthorizedKeys([]byte(testPublicKey+" key\n"+testPublicKey2+"\n"), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.nameKeys("bob")
+
+	names := AuthorizedKeyNames(MergeAuthorizedKeys([]*AuthorizedKey{ak, nil, user}))
+	if want, got := "alice@laptop", names[utils.FingerprintSHA256(pk1)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := "bob", names[utils.FingerprintSHA256(pk2)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
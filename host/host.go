@@ -3,6 +3,7 @@ package host
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,10 +13,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/oklog/run"
 	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/approval"
+	"github.com/owenthereal/upterm/events"
 	"github.com/owenthereal/upterm/host/api"
 	"github.com/owenthereal/upterm/host/internal"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
@@ -148,30 +154,246 @@ func (cb hostKeyCallback) appendHostLine(isCert bool, hostname, remote string, k
 	return nil
 }
 
+var (
+	ErrMaxDurationReached = errors.New("session reached its max duration")
+	// ErrIdleTimeoutReached is returned when the session ends after neither the host nor clients type for IdleTimeout.
+	ErrIdleTimeoutReached = errors.New("session reached its idle timeout")
+	// ErrSessionExpired is returned when the server ends the session by its max session age.
+	ErrSessionExpired = errors.New("session reached the max session age of the server")
+	// ErrDirectWithoutAuthorizedKeys is returned when direct connections are enabled without authorized keys.
+	ErrDirectWithoutAuthorizedKeys = errors.New("direct connections require authorized keys")
+	// ErrAuthorizedKeysRequired is returned when authorized keys are required but none are given,
+	// e.g. GitHub users without public keys.
+	ErrAuthorizedKeysRequired = errors.New("authorized keys are required")
+	// ErrMenuWithForceCommand is returned when both a menu and a force command are set.
+	ErrMenuWithForceCommand = errors.New("menu can't be used with force command")
+	// ErrSFTPWithRestrictedCommand is returned when SFTP is enabled for clients restricted by a force command,
+	// a menu, or a sandbox, since file transfers would bypass the restriction.
+	ErrSFTPWithRestrictedCommand = errors.New("sftp can't be used with force command, menu, or sandbox")
+	// ErrExecWithRestrictedCommand is returned when exec is enabled for clients restricted by a force command,
+	// a menu, or a sandbox, since file transfers would bypass the restriction.
+	ErrExecWithRestrictedCommand = errors.New("exec can't be used with force command, menu, or sandbox")
+	// ErrPortForwardingWithRestrictedCommand is returned when port forwarding is enabled for clients restricted by
+	// a force command, a menu, or a sandbox, since forwarded connections would bypass the restriction.
+	ErrPortForwardingWithRestrictedCommand = errors.New("port forwarding can't be used with force command, menu, or sandbox")
+	// ErrVSCodeWithRestrictedCommand is returned when VS Code is served to clients restricted by a force command,
+	// a menu, a sandbox, or read-only mode, since the editor would bypass the restriction.
+	ErrVSCodeWithRestrictedCommand = errors.New("vs code can't be used with force command, menu, sandbox, or read-only")
+	// ErrViewersWithRestrictedCommand is returned when viewers are let in to sessions with a force command or a menu,
+	// which run a command per client rather than sharing a terminal to watch.
+	ErrViewersWithRestrictedCommand = errors.New("viewers can't be used with force command or menu")
+	// ErrListenOnlyWithExclusiveFeature is returned when a listen-only host enables a feature that only one session
+	// can use at a time, since it listens on a port of the host or registers tokens with a single session.
+	ErrListenOnlyWithExclusiveFeature = errors.New("listen-only can't be used with direct connections, join tokens, or vs code")
+)
+
+// SessionEndedError is returned when the server ends the session with a reason, e.g. under memory pressure.
+type SessionEndedError struct {
+	Reason string
+}
+
+func (e *SessionEndedError) Error() string {
+	return fmt.Sprintf("session ended by the server: %s", e.Reason)
+}
+
 type Host struct {
-	Host                   string
-	KeepAliveDuration      time.Duration
-	Command                []string
-	ForceCommand           []string
-	Signers                []ssh.Signer
-	HostKeyCallback        ssh.HostKeyCallback
-	AuthorizedKeys         []*AuthorizedKey
-	AdminSocketFile        string
+	Host              string
+	KeepAliveDuration time.Duration
+	Command           []string
+	ForceCommand      []string
+	Signers           []ssh.Signer
+	HostKeyCallback   ssh.HostKeyCallback
+	AuthorizedKeys    []*AuthorizedKey
+	// RefreshAuthorizedKeys re-reads AuthorizedKeys every AuthorizedKeysRefreshInterval if both are set, e.g. to
+	// honor keys users add to their code host accounts mid-session. The keys are kept if it fails or returns none.
+	RefreshAuthorizedKeys         func(ctx context.Context) ([]*AuthorizedKey, error)
+	AuthorizedKeysRefreshInterval time.Duration
+	AdminSocketFile               string
+	StateDir                      string
+	// Access is persisted with the state of the session in StateDir, to re-establish the session with the same
+	// access, e.g. once the host crashes.
+	Access                 SessionAccess
 	SessionCreatedCallback func(*api.GetSessionResponse) error
-	ClientJoinedCallback   func(*api.Client)
-	ClientLeftCallback     func(*api.Client)
-	Logger                 log.FieldLogger
-	Stdin                  *os.File
-	Stdout                 *os.File
-	ReadOnly               bool
+	// SessionEndedCallback is called with the session statistics when the session ends.
+	SessionEndedCallback func(*api.SessionStats)
+	ClientJoinedCallback func(*api.Client)
+	ClientLeftCallback   func(*api.Client)
+	EventCallback        func(events.Event)
+	// Webhook posts the events of the session, e.g. clients joining, if it's non-nil.
+	Webhook *Webhook
+	Logger  log.FieldLogger
+	Stdin   *os.File
+	Stdout  *os.File
+	// ReadOnly is the initial read-only mode of the session. The host toggles it by typing Ctrl-] followed by r,
+	// or with the SetSession admin API.
+	ReadOnly bool
+	// StartAt is the time clients are allowed in. Clients joining earlier wait until then.
+	StartAt time.Time
+	// MaxDuration ends the session after the duration since StartAt or since the session is created.
+	MaxDuration time.Duration
+	// IdleTimeout ends the session when neither the host nor clients type for the duration. The host and clients
+	// are warned a minute before, or halfway through timeouts shorter than two minutes.
+	IdleTimeout time.Duration
+	// DirectListenAddr is an address the host listens on for clients that can reach it directly,
+	// bypassing the server relay. Direct clients must be in AuthorizedKeys.
+	DirectListenAddr string
+	// Sandbox runs Command and ForceCommand in a sandbox tool if it's set.
+	Sandbox *Sandbox
+	// AgreePolicyCallback is called with the server policy and its hash if the server has one.
+	// It returns nil if the host agrees to the policy. Sessions fail to be created on servers
+	// with a policy if it's nil.
+	AgreePolicyCallback func(policy, hash string) error
+	// Menu lets clients choose a command to run in a dedicated PTY instead of attaching to Command,
+	// e.g. for support sessions limited to a set of commands. It can't be used with ForceCommand.
+	Menu []*api.MenuItem
+	// LimitRate caps the bytes per second the host writes to the reverse tunnel if it's positive.
+	// Interactive traffic is prioritized over bulk traffic, e.g. file transfers, within the limit.
+	LimitRate int64
+	// TCPTuning sets the socket options of the TCP connection to the server if it's non-nil, e.g. larger buffers
+	// and BBR for long fat networks. Connections through JumpHosts or over WebSocket aren't tuned.
+	TCPTuning *utils.TCPTuning
+	// PtyBackend is the name of the backend attaching commands to terminals, one of PtyBackends.
+	// It defaults to the pseudo terminal of the OS, or ConPTY on Windows.
+	PtyBackend string
+	// JoinTokens are the one-time tokens the host issues to share the session, e.g. in a QR code.
+	// Clients joining with usernames carrying other tokens are rejected. Invites of JoinTokens are registered
+	// with the server once the session is established, e.g. in SessionCreatedCallback.
+	JoinTokens *JoinTokens
+	// SFTP lets clients transfer files to and from the working directory of the host with SFTP.
+	// It can't be used with ForceCommand, Menu, or Sandbox.
+	SFTP bool
+	// Exec lets clients transfer files to and from the working directory of the host with scp and rsync -e ssh.
+	// Other commands clients exec are refused. It can't be used with ForceCommand, Menu, or Sandbox.
+	Exec bool
+	// PortForwarding lets clients forward connections to loopback ports of the host, e.g. with ssh -L.
+	// It can't be used with ForceCommand, Menu, or Sandbox.
+	PortForwarding bool
+	// ForwardedPorts are the loopback ports of the host published to clients, e.g. of a local dev web server.
+	// Clients may forward connections to them regardless of PortForwarding, even while the session is read-only.
+	ForwardedPorts []uint32
+	// TransferCompression compresses file transfers of SFTP and Exec with zstd for clients negotiating it,
+	// at one of api.CompressionLevels. Transfers aren't compressed if it's empty or off.
+	TransferCompression string
+	// StrictCrypto restricts the connection to the server and the connections of clients to modern key exchanges,
+	// AEAD ciphers, and public keys with non-SHA-1 signatures.
+	StrictCrypto bool
+	// RequireAuthorizedKeys refuses to host the session if AuthorizedKeys has no keys, letting any client join.
+	RequireAuthorizedKeys bool
+	// ClientIdleTimeout disconnects clients that haven't typed for the duration if it's positive.
+	ClientIdleTimeout time.Duration
+	// EvictGhostsAfter disconnects clients whose connections are half dead, e.g. dropped by a NAT or left behind by
+	// a crashed terminal, once they send nothing for the duration, so that they leave the connected clients.
+	// Clients are pinged within the duration. Ghost clients linger until their connections time out if it's zero.
+	EvictGhostsAfter time.Duration
+	// OutputCoalesceDelay coalesces small writes of the output to clients for up to the duration if it's positive,
+	// reducing the overhead of packets and WebSocket frames of chatty TUIs. Output after a pause, e.g. echoed
+	// keystrokes, isn't delayed.
+	OutputCoalesceDelay time.Duration
+	// Identities resolves the display names of clients, e.g. from a lookup file or a directory.
+	// Names fall back to the comments of AuthorizedKeys and then to the names resolved by the server.
+	Identities identity.Resolver
+	// JumpHosts are the SSH servers the reverse tunnel hops through in order to reach Host, e.g. a bastion
+	// of a restricted network. See ParseJumpHosts. They can't be used with ws or wss servers.
+	JumpHosts []*url.URL
+	// WebSocketFallback retries over the WebSocket endpoint of an ssh Host if it's unreachable over SSH, e.g. when
+	// connecting times out or is reset, and the server advertises one. See WebSocketFallback. The downgrade is
+	// logged. Connections through JumpHosts don't fall back.
+	WebSocketFallback bool
+	// ReconnectTimeout re-establishes the reverse tunnel if its connection drops, e.g. on a network blip, resuming
+	// the session with the same session ID, retrying for up to the duration. The command keeps running meanwhile,
+	// and clients reconnect once the session is resumed. The session ends with the connection if it's zero or the
+	// server doesn't keep the sessions of dropped hosts.
+	ReconnectTimeout time.Duration
+	// ShowTimer shows the elapsed and remaining time of the session in the terminal titles of the host and clients
+	// if the session ends by MaxDuration or the max session age of the server, and when clients are disconnected
+	// for being idle if ClientIdleTimeout is set.
+	ShowTimer bool
+	// ShowActivity shows clients of read-only sessions when the host is typing in their terminal titles, and tells
+	// them the session is still alive once the output stalls for two minutes.
+	ShowActivity bool
+	// StatusLine shows the number of clients connected and their fingerprints on the last line of the terminal of
+	// the host, updated as they join and leave. The command runs in a window a line shorter.
+	StatusLine bool
+	// ShowTyping prefixes the status line with the ID of the client typing while it types, if StatusLine is set.
+	ShowTyping bool
+	// RestartCommand restarts the command when it fails, e.g. a crashed shell, instead of ending the session.
+	// Clients stay attached across restarts.
+	RestartCommand bool
+	// KeepSessionOnExit keeps the session once the command exits, telling the host and clients to type r to
+	// restart the command or q to end the session.
+	KeepSessionOnExit bool
+	// Labels describe the session, e.g. env=prod, to approval policies, and to the server in its notifications and
+	// audit log.
+	Labels approval.Labels
+	// Approval requires clients to be approved before they attach if the session has the labels of its selector.
+	// Clients wait until they are approved, and approvals are recorded as events.ClientApproval.
+	Approval *approval.Policy
+	// RecordingOptOut asks the server not to record the session in its audit log. Servers only honor it for hosts
+	// their host ACL grants to opt out.
+	RecordingOptOut bool
+	// MaxClients caps the clients attached to the session at once if it's positive. Further clients are
+	// disconnected with a banner.
+	MaxClients int
+	// PasteThreshold holds pastes of clients larger than the bytes, and bracketed pastes with escape sequences,
+	// until the host forwards them by typing Ctrl-] followed by y or drops them with Ctrl-] n if it's positive,
+	// so that a client doesn't dump a huge buffer or a payload of escape sequences into the shell by accident.
+	PasteThreshold int
+	// VSCode serves VS Code for the web to clients on a published localhost port if it's set, so that they can
+	// attach an editor besides the terminal. It can't be used with ForceCommand, Menu, Sandbox, or ReadOnly,
+	// since the editor would bypass them.
+	VSCode *VSCode
+	// Name describes the session, e.g. debugging prod incident. It's shown with the session and to joining clients.
+	Name string
+	// Viewers lets viewers watch the session over the telnet bridge of the server, e.g. from classroom tools that
+	// only speak telnet. Their input is discarded. They log in with the username clients join with, which must carry
+	// an invite of JoinTokens, e.g. of a share link, unless any client may join. It can't be used with ForceCommand
+	// or Menu, since viewers would get their own terminals.
+	Viewers bool
+	// ListenOnly serves multiple independent sessions, each with its own PTY and session ID, instead of sharing the
+	// terminal of the host. A session running Command is spawned first, and more are spawned with the NewSession
+	// admin API, e.g. by upterm session new. Sessions are headless, and the host ends when all of them have ended.
+	// It can't be used with DirectListenAddr, JoinTokens, or VSCode.
+	ListenOnly bool
+	// Queue waits for the server to have capacity in its session queue if it's at capacity, e.g. a shared team server
+	// during a busy incident, instead of failing. QueuePositionCallback is called with the position of the host and
+	// the length of the queue whenever they change.
+	Queue                 bool
+	QueuePositionCallback func(pos, length int)
+	// SizePolicy is the name of the policy resolving the window of the terminal when the host and clients request
+	// conflicting windows, one of SizePolicies. It defaults to the smallest window.
+	SizePolicy string
+	// WindowWidth and WindowHeight are the fixed window of the manual size policy.
+	WindowWidth  int
+	WindowHeight int
+
+	// pool spawns sessions of a listen-only host.
+	pool *sessionPool
 }
 
+// Run hosts the session until it ends, or the sessions of a listen-only host until all of them end.
 func (c *Host) Run(ctx context.Context) error {
+	if c.ListenOnly {
+		if c.DirectListenAddr != "" || c.JoinTokens != nil || c.VSCode != nil {
+			return ErrListenOnlyWithExclusiveFeature
+		}
+
+		return newSessionPool(c).Run(ctx)
+	}
+
+	return c.run(ctx)
+}
+
+func (c *Host) run(ctx context.Context) error {
 	u, err := url.Parse(c.Host)
 	if err != nil {
 		return fmt.Errorf("error parsing host url: %s", err)
 	}
 
+	hostSigners, err := hostKeySigners(c.Signers)
+	if err != nil {
+		return fmt.Errorf("error creating host keys: %w", err)
+	}
+
 	if c.Stdin == nil {
 		c.Stdin = os.Stdin
 	}
@@ -179,23 +401,134 @@ func (c *Host) Run(ctx context.Context) error {
 		c.Stdout = os.Stdout
 	}
 
+	ptyBackend, err := internal.NewPtyBackend(c.PtyBackend)
+	if err != nil {
+		return err
+	}
+
+	sizePolicy, err := internal.NewSizePolicy(c.SizePolicy, c.WindowWidth, c.WindowHeight)
+	if err != nil {
+		return err
+	}
+
+	var transferCompression zstd.EncoderLevel
+	if c.TransferCompression != "" && c.TransferCompression != "off" {
+		if transferCompression, err = api.ParseCompressionLevel(c.TransferCompression); err != nil {
+			return err
+		}
+	}
+
+	command, forceCommand := c.Command, c.ForceCommand
+	if len(c.Menu) > 0 && len(c.ForceCommand) > 0 {
+		return ErrMenuWithForceCommand
+	}
+	if c.SFTP && (len(c.ForceCommand) > 0 || len(c.Menu) > 0 || c.Sandbox != nil) {
+		return ErrSFTPWithRestrictedCommand
+	}
+	if c.Exec && (len(c.ForceCommand) > 0 || len(c.Menu) > 0 || c.Sandbox != nil) {
+		return ErrExecWithRestrictedCommand
+	}
+	if c.PortForwarding && (len(c.ForceCommand) > 0 || len(c.Menu) > 0 || c.Sandbox != nil) {
+		return ErrPortForwardingWithRestrictedCommand
+	}
+
+	if c.Viewers && (len(c.ForceCommand) > 0 || len(c.Menu) > 0) {
+		return ErrViewersWithRestrictedCommand
+	}
+
+	if c.VSCode != nil && (len(c.ForceCommand) > 0 || len(c.Menu) > 0 || c.Sandbox != nil || c.ReadOnly) {
+		return ErrVSCodeWithRestrictedCommand
+	}
+	if c.VSCode != nil {
+		if _, err := c.VSCode.Validate(); err != nil {
+			return err
+		}
+		if c.VSCode.Password == "" {
+			vscode := *c.VSCode
+			if vscode.Password, err = newVSCodePassword(); err != nil {
+				return err
+			}
+			c.VSCode = &vscode
+		}
+	}
+
+	menu := c.Menu
+	if c.Sandbox != nil {
+		menu = nil
+		for _, item := range c.Menu {
+			cmd, err := c.Sandbox.Wrap(item.Command)
+			if err != nil {
+				return err
+			}
+			menu = append(menu, &api.MenuItem{Name: item.Name, Command: cmd})
+		}
+
+		if command, err = c.Sandbox.Wrap(c.Command); err != nil {
+			return err
+		}
+		if forceCommand, err = c.Sandbox.Wrap(c.ForceCommand); err != nil {
+			return err
+		}
+	}
+
 	var aks []ssh.PublicKey
 	for _, ak := range c.AuthorizedKeys {
 		aks = append(aks, ak.PublicKeys...)
 	}
 
+	if c.RequireAuthorizedKeys && len(aks) == 0 {
+		return ErrAuthorizedKeysRequired
+	}
+
+	var directLn net.Listener
+	if c.DirectListenAddr != "" {
+		if len(aks) == 0 {
+			return ErrDirectWithoutAuthorizedKeys
+		}
+
+		directLn, err = net.Listen("tcp", c.DirectListenAddr)
+		if err != nil {
+			return fmt.Errorf("error listening on direct address: %w", err)
+		}
+		defer directLn.Close()
+	}
+
 	logger := c.Logger.WithField("server", u)
 
 	logger.Info("Establishing reverse tunnel")
 	rt := internal.ReverseTunnel{
 		Host:              u,
 		Signers:           c.Signers,
+		HostSigners:       hostSigners,
 		HostKeyCallback:   c.HostKeyCallback,
 		AuthorizedKeys:    aks,
 		KeepAliveDuration: c.KeepAliveDuration,
+		LimitRate:         c.LimitRate,
+		TCPTuning:         c.TCPTuning,
+		StrictCrypto:      c.StrictCrypto,
+		RecordingOptOut:   c.RecordingOptOut,
+		Labels:            c.Labels,
+		Queue:             c.Queue,
+		QueuePosition:     c.QueuePositionCallback,
+		ReconnectTimeout:  c.ReconnectTimeout,
+		JumpHosts:         c.JumpHosts,
 		Logger:            c.Logger.WithField("com", "reverse-tunnel"),
 	}
+	if c.AgreePolicyCallback != nil {
+		rt.AgreePolicy = func(p *server.GetPolicyResponse) error {
+			return c.AgreePolicyCallback(p.Text, p.Hash)
+		}
+	}
 	sessResp, err := rt.Establish(ctx)
+	var uerr *internal.UnreachableError
+	if errors.As(err, &uerr) && c.WebSocketFallback {
+		if ws := WebSocketFallback(ctx, u); ws != nil {
+			logger.WithError(err).WithField("fallback", ws).Warn("Falling back to WebSocket, the server is unreachable over SSH")
+			u, rt.Host = ws, ws
+			logger = c.Logger.WithField("server", u)
+			sessResp, err = rt.Establish(ctx)
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -215,13 +548,85 @@ func (c *Host) Run(ctx context.Context) error {
 	logger = logger.WithField("session", sessResp.SessionID)
 	logger.Info("Established reverse tunnel")
 
+	if sessResp.Features != nil {
+		c.restrictToFeatures(sessResp.Features, logger)
+	}
+	if c.RecordingOptOut && sessResp.Recorded {
+		logger.Warn("The server doesn't allow opting out of recording the session")
+	}
+
 	session := &api.GetSessionResponse{
-		SessionId:      sessResp.SessionID,
-		Host:           u.String(),
-		NodeAddr:       sessResp.NodeAddr,
-		Command:        c.Command,
-		ForceCommand:   c.ForceCommand,
-		AuthorizedKeys: toApiAuthorizedKeys(c.AuthorizedKeys),
+		SessionId:          sessResp.SessionID,
+		Name:               c.Name,
+		Host:               u.String(),
+		NodeAddr:           sessResp.NodeAddr,
+		Command:            c.Command,
+		ForceCommand:       c.ForceCommand,
+		AuthorizedKeys:     toApiAuthorizedKeys(c.AuthorizedKeys),
+		MaxDurationSeconds: int64(c.MaxDuration.Seconds()),
+		ReadOnly:           c.ReadOnly,
+		Menu:               c.Menu,
+		ExpiresAt:          sessResp.ExpiresAt,
+		ForwardedPorts:     c.ForwardedPorts,
+		MaxClients:         maxClients(maxClients(int32(c.MaxClients), sessResp.Features.GetMaxClients()), sessResp.MaxClients),
+		Recorded:           sessResp.Recorded,
+	}
+	for _, s := range hostSigners {
+		session.HostPublicKeys = append(session.HostPublicKeys, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.PublicKey()))))
+	}
+	if key := rt.ServerHostKey(); key != nil {
+		session.ServerHostKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	if key := rt.AuthKey(); key != nil {
+		session.AuthKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	if !c.StartAt.IsZero() {
+		session.StartAt = c.StartAt.Unix()
+	}
+	if directLn != nil {
+		session.DirectAddr = directAddr(directLn.Addr())
+	}
+	if c.Sandbox != nil {
+		session.Sandbox = c.Sandbox.String()
+	}
+	forwardedPorts := c.ForwardedPorts
+	if c.VSCode != nil {
+		session.VscodePort = c.VSCode.Port
+		session.VscodePassword = c.VSCode.Password
+		forwardedPorts = append(append([]uint32(nil), forwardedPorts...), c.VSCode.Port)
+	}
+
+	if c.StateDir == "" {
+		dir, err := utils.CreateUptermDir()
+		if err != nil {
+			return err
+		}
+
+		c.StateDir = dir
+	}
+
+	startedAt := time.Now()
+	state := &SessionState{
+		SessionID:       sessResp.SessionID,
+		Name:            c.Name,
+		Host:            u.String(),
+		Command:         c.Command,
+		ForceCommand:    c.ForceCommand,
+		ReadOnly:        c.ReadOnly,
+		AdminSocketFile: c.AdminSocketFile,
+		PID:             os.Getpid(),
+		StartedAt:       startedAt,
+		Access:          c.Access,
+	}
+	if err := WriteSessionState(c.StateDir, state); err != nil {
+		logger.WithError(err).Error("error persisting session state")
+	} else {
+		defer func() { _ = RemoveSessionState(c.StateDir, state) }()
+	}
+
+	if c.JoinTokens != nil {
+		c.JoinTokens.SetRegistrar(rt.IssueJoinToken)
+		defer c.JoinTokens.SetRegistrar(nil)
 	}
 
 	if c.SessionCreatedCallback != nil {
@@ -230,8 +635,26 @@ func (c *Host) Run(ctx context.Context) error {
 		}
 	}
 
+	emit := func(e events.Event) {
+		if c.EventCallback != nil {
+			c.EventCallback(e)
+		}
+		c.Webhook.Post(session.SessionId, e)
+	}
+	emit(events.SessionCreated{SessionID: session.SessionId, Host: u.String(), Command: session.Command})
+
+	authorizedKeys := internal.NewAuthorizedKeys(aks, session.AuthorizedKeys, AuthorizedKeyNames(c.AuthorizedKeys))
 	clientRepo := internal.NewClientRepo()
+	conns := internal.NewClientConns()
 	eventEmitter := emitter.New(1)
+	stats := internal.NewStats(startedAt)
+	readOnly := internal.NewReadOnly(c.ReadOnly, eventEmitter)
+	attribution := internal.NewInputAttribution(eventEmitter)
+	output := internal.NewOutputStream()
+	if c.SessionEndedCallback != nil {
+		defer func() { c.SessionEndedCallback(stats.Snapshot(time.Now())) }()
+	}
+	defer func() { emit(events.SessionEnded{SessionID: session.SessionId, Stats: stats.Snapshot(time.Now())}) }()
 
 	logger = logger.WithFields(log.Fields{"cmd": c.Command, "force-cmd": c.ForceCommand})
 
@@ -239,8 +662,18 @@ func (c *Host) Run(ctx context.Context) error {
 	{
 		ctx, cancel := context.WithCancel(ctx)
 		s := internal.AdminServer{
-			Session:    session,
-			ClientRepo: clientRepo,
+			Session:        session,
+			AuthorizedKeys: authorizedKeys,
+			ClientRepo:     clientRepo,
+			Stats:          stats,
+			EventEmitter:   eventEmitter,
+			ReadOnly:       readOnly,
+			Conns:          conns,
+			Attribution:    attribution,
+			Output:         output,
+		}
+		if c.pool != nil {
+			s.NewSession = c.pool.spawn
 		}
 		g.Add(func() error {
 			return s.Serve(ctx, c.AdminSocketFile)
@@ -251,70 +684,264 @@ func (c *Host) Run(ctx context.Context) error {
 	}
 	{
 		g.Add(func() error {
-			for evt := range eventEmitter.On(upterm.EventClientJoined) {
-				args := evt.Args
-				if len(args) == 0 {
+			for evt := range events.On(eventEmitter, events.KindClientJoined) {
+				e, ok := events.From(evt).(events.ClientJoined)
+				if !ok {
 					continue
 				}
 
-				client, ok := args[0].(*api.Client)
-				if ok {
-					_ = clientRepo.Add(client)
-					logger.WithField("client", client.Addr).Info("Client joined")
-					if c.ClientJoinedCallback != nil {
-						c.ClientJoinedCallback(client)
-					}
+				client := e.Client
+				_ = clientRepo.Add(client)
+				stats.ClientJoined()
+				logger.WithField("client", client.Addr).WithField("identity", identity.Describe(client.DisplayName, client.PublicKeyFingerprint)).Info("Client joined")
+				if c.ClientJoinedCallback != nil {
+					c.ClientJoinedCallback(client)
 				}
+				emit(e)
 			}
 
 			return nil
 		}, func(err error) {
-			eventEmitter.Off(upterm.EventClientJoined)
+			events.Off(eventEmitter, events.KindClientJoined)
 		})
 	}
 	{
 		g.Add(func() error {
-			for evt := range eventEmitter.On(upterm.EventClientLeft) {
-				args := evt.Args
-				if len(args) == 0 {
+			for evt := range events.On(eventEmitter, events.KindClientLeft) {
+				e, ok := events.From(evt).(events.ClientLeft)
+				if !ok {
 					continue
 				}
 
-				cid, ok := args[0].(string)
-				if ok {
-					client := clientRepo.Get(cid)
-					if client != nil {
-						logger.WithField("client", client.Addr).Info("Client left")
-						clientRepo.Delete(cid)
-						if c.ClientLeftCallback != nil && client != nil {
-							c.ClientLeftCallback(client)
-						}
+				client := clientRepo.Get(e.Client.Id)
+				if client != nil {
+					logger.WithField("client", client.Addr).WithField("identity", identity.Describe(client.DisplayName, client.PublicKeyFingerprint)).Info("Client left")
+					clientRepo.Delete(client.Id)
+					attribution.Forget(client.Id)
+					stats.ClientLeft()
+					if c.ClientLeftCallback != nil {
+						c.ClientLeftCallback(client)
 					}
+					emit(e)
 				}
 			}
 
 			return nil
 		}, func(err error) {
-			eventEmitter.Off(upterm.EventClientLeft)
+			events.Off(eventEmitter, events.KindClientLeft)
+		})
+	}
+	if c.RefreshAuthorizedKeys != nil && c.AuthorizedKeysRefreshInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			ticker := time.NewTicker(c.AuthorizedKeysRefreshInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					c.refreshAuthorizedKeys(ctx, authorizedKeys, &rt, logger)
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		// attribute bursts of input to the clients typing them
+		g.Add(func() error {
+			for evt := range events.On(eventEmitter, events.KindClientInput) {
+				e, ok := events.From(evt).(events.ClientInput)
+				if !ok {
+					continue
+				}
+
+				logger.WithField("client", e.Client.Addr).WithField("identity", identity.Describe(e.Client.DisplayName, e.Client.PublicKeyFingerprint)).Debug("Client typing")
+				emit(e)
+			}
+
+			return nil
+		}, func(err error) {
+			events.Off(eventEmitter, events.KindClientInput)
+		})
+	}
+	{
+		// record approvals for audits
+		g.Add(func() error {
+			for evt := range events.On(eventEmitter, events.KindClientApproval) {
+				e, ok := events.From(evt).(events.ClientApproval)
+				if !ok {
+					continue
+				}
+
+				logger.WithFields(log.Fields{
+					"client":   e.Client.Addr,
+					"identity": identity.Describe(e.Client.DisplayName, e.Client.PublicKeyFingerprint),
+					"labels":   approval.Labels(e.Labels).String(),
+					"approver": e.Approver,
+					"reason":   e.Reason,
+				}).Infof("Client approval %s", e.Status)
+				emit(e)
+			}
+
+			return nil
+		}, func(err error) {
+			events.Off(eventEmitter, events.KindClientApproval)
+		})
+	}
+	start := startedAt
+	if c.StartAt.After(start) {
+		start = c.StartAt
+	}
+	var endsAt time.Time
+	if c.MaxDuration > 0 {
+		endsAt = start.Add(c.MaxDuration)
+
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			timer := time.NewTimer(time.Until(endsAt))
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+				logger.WithField("max-duration", c.MaxDuration).Info("Session reached max duration")
+				return ErrMaxDurationReached
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if c.VSCode != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		vscode := *c.VSCode
+		g.Add(func() error {
+			return vscode.run(ctx, logger.WithField("com", "vscode"))
+		}, func(err error) {
+			cancel()
+		})
+	}
+	var activity *internal.Activity
+	if c.ShowActivity {
+		activity = internal.NewActivity(start)
+	}
+	var idle *internal.SessionIdle
+	if c.IdleTimeout > 0 {
+		idle = internal.NewSessionIdle(c.IdleTimeout, start)
+
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			if err := idle.Wait(ctx, eventEmitter); err != nil {
+				return err
+			}
+
+			logger.WithField("idle-timeout", c.IdleTimeout).Info("Session reached idle timeout")
+			return ErrIdleTimeoutReached
+		}, func(err error) {
+			cancel()
+		})
+	}
+	var expiresAt time.Time
+	if sessResp.ExpiresAt != 0 {
+		expiresAt = time.Unix(sessResp.ExpiresAt, 0)
+		logger = logger.WithField("expires-at", expiresAt)
+		if endsAt.IsZero() || expiresAt.Before(endsAt) {
+			endsAt = expiresAt
+		}
+
+		// The server ends the session at expiresAt. Clients are warned before, and the host stops
+		// in case the server's clock is behind.
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			warning := time.NewTimer(time.Until(expiresAt.Add(-server.SessionExpiryWarning)))
+			defer warning.Stop()
+			expiry := time.NewTimer(time.Until(expiresAt))
+			defer expiry.Stop()
+
+			for {
+				select {
+				case <-warning.C:
+					logger.Info("Session is reaching the max session age of the server")
+					events.Emit(eventEmitter, events.SessionExpiring{ExpiresAt: expiresAt})
+				case <-expiry.C:
+					return ErrSessionExpired
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, func(err error) {
+			cancel()
 		})
 	}
 	{
 		logger.Info("Starting sshd server")
 		defer logger.Info("Finishing sshd server")
 
+		var timer *internal.SessionTimer
+		if c.ShowTimer && (!endsAt.IsZero() || c.ClientIdleTimeout > 0) {
+			timer = &internal.SessionTimer{StartedAt: start, EndsAt: endsAt}
+		}
+
+		identities := identity.Chain{authorizedKeys}
+		if c.Identities != nil {
+			identities = append(identity.Chain{c.Identities}, identities...)
+		}
+
 		ctx, cancel := context.WithCancel(ctx)
 		sshServer := internal.Server{
-			Command:           c.Command,
-			CommandEnv:        []string{fmt.Sprintf("%s=%s", upterm.HostAdminSocketEnvVar, c.AdminSocketFile)},
-			ForceCommand:      c.ForceCommand,
-			Signers:           c.Signers,
-			AuthorizedKeys:    aks,
-			EventEmitter:      eventEmitter,
-			KeepAliveDuration: c.KeepAliveDuration,
-			Stdin:             c.Stdin,
-			Stdout:            c.Stdout,
-			Logger:            c.Logger.WithField("com", "server"),
-			ReadOnly:          c.ReadOnly,
+			Command:             command,
+			CommandEnv:          []string{fmt.Sprintf("%s=%s", upterm.HostAdminSocketEnvVar, c.AdminSocketFile)},
+			ForceCommand:        forceCommand,
+			Signers:             hostSigners,
+			AuthorizedKeys:      authorizedKeys,
+			EventEmitter:        eventEmitter,
+			KeepAliveDuration:   c.KeepAliveDuration,
+			Stdin:               c.Stdin,
+			Stdout:              c.Stdout,
+			Logger:              c.Logger.WithField("com", "server"),
+			ReadOnly:            readOnly,
+			StartAt:             c.StartAt,
+			DirectListener:      directLn,
+			Stats:               stats,
+			Menu:                menu,
+			PtyBackend:          ptyBackend,
+			JoinTokens:          c.JoinTokens,
+			SFTP:                c.SFTP,
+			Exec:                c.Exec,
+			PortForwarding:      c.PortForwarding,
+			ForwardedPorts:      forwardedPorts,
+			TransferCompression: transferCompression,
+			Recorded:            sessResp.Recorded,
+			MaxClients:          c.MaxClients,
+			PasteThreshold:      c.PasteThreshold,
+			Viewers:             c.Viewers,
+			SizePolicy:          sizePolicy,
+			StrictCrypto:        c.StrictCrypto,
+			ClientIdleTimeout:   c.ClientIdleTimeout,
+			EvictGhostsAfter:    c.EvictGhostsAfter,
+			OutputCoalesceDelay: c.OutputCoalesceDelay,
+			Idle:                idle,
+			Activity:            activity,
+			Conns:               conns,
+			Name:                c.Name,
+			Identities:          identities,
+			Timer:               timer,
+			StatusLine:          c.StatusLine,
+			Attribution:         attribution,
+			ShowTyping:          c.ShowTyping,
+			Output:              output,
+			RestartCommand:      c.RestartCommand,
+			KeepOnExit:          c.KeepSessionOnExit,
+		}
+		if c.Approval.Required(c.Labels) {
+			sshServer.Approval = &internal.Approval{
+				Policy:    c.Approval,
+				SessionID: sessResp.SessionID,
+				Labels:    c.Labels,
+			}
 		}
 		g.Add(func() error {
 			return sshServer.ServeWithContext(ctx, rt.Listener())
@@ -323,7 +950,33 @@ func (c *Host) Run(ctx context.Context) error {
 		})
 	}
 
-	return g.Run()
+	err = g.Run()
+	// the server may close the tunnel right before the host stops
+	if err != nil && !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		return ErrSessionExpired
+	}
+	if reason := rt.EndedReason(); err != nil && reason != "" {
+		return &SessionEndedError{Reason: reason}
+	}
+
+	return err
+}
+
+// directAddr returns the address clients use to connect directly.
+// An unspecified listening IP is replaced with the hostname.
+func directAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		if hostname, err := os.Hostname(); err == nil {
+			host = hostname
+		}
+	}
+
+	return net.JoinHostPort(host, port)
 }
 
 func keyType(t string) string {
@@ -349,17 +1002,103 @@ func createFileIfNotExist(file string) error {
 	return nil
 }
 
+// restrictToFeatures turns off the features the host ACL of the server doesn't grant, and labels the session with
+// the labels it assigns. The server caps the clients of the session itself.
+func (c *Host) restrictToFeatures(f *server.HostFeatures, logger log.FieldLogger) {
+	if !f.Sftp && (c.SFTP || c.Exec) {
+		logger.Warn("The server doesn't allow file transfers, turning off SFTP and exec")
+		c.SFTP, c.Exec = false, false
+	}
+	if !f.PortForwarding && (c.PortForwarding || len(c.ForwardedPorts) > 0 || c.VSCode != nil) {
+		logger.Warn("The server doesn't allow port forwarding, turning it and VS Code off")
+		c.PortForwarding, c.ForwardedPorts, c.VSCode = false, nil, nil
+	}
+	if len(f.Labels) > 0 {
+		labels := make(approval.Labels)
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+		for k, v := range f.Labels {
+			labels[k] = v
+		}
+		c.Labels = labels
+	}
+}
+
+// refreshAuthorizedKeys re-reads the authorized keys and replaces them on the host and on the server if they changed.
+// The keys are kept if they can't be read or none are left, so that the session isn't opened to any client.
+// maxClients returns the lower of the caps of the clients of the session by the host and by the server,
+// ignoring caps that aren't positive.
+func maxClients(host, server int32) int32 {
+	if host <= 0 || (server > 0 && server < host) {
+		return server
+	}
+
+	return host
+}
+
+func (c *Host) refreshAuthorizedKeys(ctx context.Context, authorizedKeys *internal.AuthorizedKeys, rt *internal.ReverseTunnel, logger log.FieldLogger) {
+	refreshed, err := c.RefreshAuthorizedKeys(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("error refreshing authorized keys, keeping the keys")
+		return
+	}
+
+	var keys []ssh.PublicKey
+	for _, ak := range refreshed {
+		keys = append(keys, ak.PublicKeys...)
+	}
+	if len(keys) == 0 {
+		logger.Warn("no authorized keys are left after refreshing, keeping the keys")
+		return
+	}
+
+	added, removed := diffKeys(authorizedKeys.Keys(), keys)
+	if added == 0 && removed == 0 {
+		return
+	}
+
+	// the server refuses clients with keys it doesn't know before they reach the host
+	if err := rt.UpdateAuthorizedKeys(keys); err != nil {
+		logger.WithError(err).Warn("error updating authorized keys on the server, keeping the keys")
+		return
+	}
+	authorizedKeys.Set(keys, toApiAuthorizedKeys(refreshed), AuthorizedKeyNames(refreshed))
+
+	logger.WithFields(log.Fields{"added": added, "removed": removed}).Info("Refreshed authorized keys")
+}
+
+// diffKeys returns the numbers of keys added to and removed from old in new.
+func diffKeys(old, new []ssh.PublicKey) (added, removed int) {
+	fps := make(map[string]bool)
+	for _, k := range old {
+		fps[utils.FingerprintSHA256(k)] = true
+	}
+	for _, k := range new {
+		fp := utils.FingerprintSHA256(k)
+		if fps[fp] {
+			delete(fps, fp)
+		} else {
+			added++
+		}
+	}
+
+	return added, len(fps)
+}
+
 func toApiAuthorizedKeys(aks []*AuthorizedKey) []*api.AuthorizedKey {
 	var apiAks []*api.AuthorizedKey
 	for _, ak := range aks {
-		var fps []string
+		var fps, pks []string
 		for _, pk := range ak.PublicKeys {
 			fps = append(fps, utils.FingerprintSHA256(pk))
+			pks = append(pks, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk))))
 		}
 
 		apiAks = append(apiAks, &api.AuthorizedKey{
 			PublicKeyFingerprints: fps,
 			Comment:               ak.Comment,
+			PublicKeys:            pks,
 		})
 	}
 
@@ -2,13 +2,19 @@ package host
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/owenthereal/upterm/approval"
+	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -92,3 +98,66 @@ func Test_hostKeyCallback(t *testing.T) {
 		t.Fatalf("unexpected error message: %s", err.Error())
 	}
 }
+
+func Test_Host_restrictToFeatures(t *testing.T) {
+	h := &Host{
+		SFTP:           true,
+		Exec:           true,
+		PortForwarding: true,
+		ForwardedPorts: []uint32{8080},
+		Labels:         approval.Labels{"env": "dev", "team": "web"},
+	}
+	labels := h.Labels
+
+	h.restrictToFeatures(&server.HostFeatures{PortForwarding: true, Labels: map[string]string{"env": "prod"}}, log.New())
+
+	if h.SFTP || h.Exec {
+		t.Fatal("want file transfers turned off")
+	}
+	if !h.PortForwarding || len(h.ForwardedPorts) != 1 {
+		t.Fatal("want port forwarding kept")
+	}
+	if want := (approval.Labels{"env": "prod", "team": "web"}); !reflect.DeepEqual(h.Labels, want) {
+		t.Fatalf("want labels %v, got %v", want, h.Labels)
+	}
+	if labels["env"] != "dev" {
+		t.Fatal("want the labels of the caller unchanged")
+	}
+
+	h.VSCode = &VSCode{Port: DefaultVSCodePort}
+	h.restrictToFeatures(&server.HostFeatures{}, log.New())
+	if h.PortForwarding || h.ForwardedPorts != nil || h.VSCode != nil {
+		t.Fatal("want port forwarding and vs code turned off")
+	}
+}
+
+func Test_maxClients(t *testing.T) {
+	cases := []struct {
+		host, server, want int32
+	}{
+		{0, 0, 0},
+		{2, 0, 2},
+		{0, 5, 5},
+		{2, 5, 2},
+		{8, 5, 5},
+	}
+	for _, c := range cases {
+		if got := maxClients(c.host, c.server); got != c.want {
+			t.Errorf("maxClients(%d, %d): want %d, got %d", c.host, c.server, c.want, got)
+		}
+	}
+}
+
+func Test_Host_ListenOnlyWithExclusiveFeature(t *testing.T) {
+	for name, h := range map[string]*Host{
+		"direct":      {DirectListenAddr: ":0"},
+		"join tokens": {JoinTokens: NewJoinTokens()},
+		"vscode":      {VSCode: &VSCode{Port: DefaultVSCodePort}},
+	} {
+		h.ListenOnly = true
+		h.Logger = log.New()
+		if err := h.Run(context.Background()); !errors.Is(err, ErrListenOnlyWithExclusiveFeature) {
+			t.Errorf("%s: want %v, got %v", name, ErrListenOnlyWithExclusiveFeature, err)
+		}
+	}
+}
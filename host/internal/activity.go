@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// activityTypingWindow is how long the host is shown typing after a keystroke.
+	activityTypingWindow = 3 * time.Second
+	// activityFrozenAfter is how long the output stalls before clients are told the session is still alive.
+	activityFrozenAfter = 2 * time.Minute
+)
+
+// NewActivity returns the activity of a session without input or output since now.
+func NewActivity(now time.Time) *Activity {
+	a := &Activity{frozenAfter: activityFrozenAfter}
+	a.lastTyped.Store(now.Add(-activityTypingWindow).UnixNano())
+	a.lastOutput.Store(now.UnixNano())
+
+	return a
+}
+
+// Activity shows clients of read-only sessions when the host is typing in their terminal titles, and tells them
+// when the output stalls, so that viewers of long-running demos know the session is still alive.
+type Activity struct {
+	frozenAfter time.Duration
+	lastTyped   atomic.Int64
+	lastOutput  atomic.Int64
+}
+
+// Reader records reading input of the host from r as typing. It returns r if a is nil.
+func (a *Activity) Reader(r io.Reader) io.Reader {
+	if a == nil {
+		return r
+	}
+
+	return &stampReader{r: r, last: &a.lastTyped}
+}
+
+// Writer records writing output of the command to w. It returns w if a is nil.
+func (a *Activity) Writer(w io.Writer) io.Writer {
+	if a == nil {
+		return w
+	}
+
+	return &stampWriter{w: w, last: &a.lastOutput}
+}
+
+func (a *Activity) typing(now time.Time) bool {
+	return now.Sub(time.Unix(0, a.lastTyped.Load())) < activityTypingWindow
+}
+
+// quiet returns how long the command hasn't written output as of now.
+func (a *Activity) quiet(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, a.lastOutput.Load()))
+}
+
+// Title decorates the terminal title of a client with the host typing while the session is read-only.
+// title returns the title without the indicator, or is nil if there is none.
+func (a *Activity) Title(title func(now time.Time) string, readonly *ReadOnly) func(now time.Time) string {
+	return func(now time.Time) string {
+		var t string
+		if title != nil {
+			t = title(now)
+		}
+		if !readonly.Get() || !a.typing(now) {
+			return t
+		}
+		if t == "" {
+			return "upterm: host is typing"
+		}
+
+		return t + ", host is typing"
+	}
+}
+
+// writeFrozenNotices writes a banner to w once the output stalls for the frozen duration while the session is
+// read-only, and again after each later stall, until ctx is done.
+func (a *Activity) writeFrozenNotices(ctx context.Context, w io.Writer, readonly *ReadOnly) error {
+	timer := time.NewTimer(a.frozenAfter)
+	defer timer.Stop()
+
+	var noticed bool
+	for {
+		select {
+		case now := <-timer.C:
+			quiet := a.quiet(now)
+			if quiet < a.frozenAfter {
+				noticed = false
+				timer.Reset(a.frozenAfter - quiet)
+				continue
+			}
+
+			if !noticed && readonly.Get() {
+				banner := fmt.Sprintf("\r\n=== No output for %s, the session is still alive ===\r\n", a.frozenAfter)
+				_, _ = io.WriteString(w, banner)
+				noticed = true
+			}
+			timer.Reset(a.frozenAfter)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stampReader stores the time of reads returning data in last.
+type stampReader struct {
+	r    io.Reader
+	last *atomic.Int64
+}
+
+func (r *stampReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.last.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+// stampWriter stores the time of writes in last.
+type stampWriter struct {
+	w    io.Writer
+	last *atomic.Int64
+}
+
+func (w *stampWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.last.Store(time.Now().UnixNano())
+	}
+
+	return w.w.Write(p)
+}
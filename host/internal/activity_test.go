@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Activity_Title(t *testing.T) {
+	now := time.Now()
+	a := NewActivity(now)
+	readonly := NewReadOnly(true, nil)
+	title := a.Title(nil, readonly)
+
+	if got := title(now); got != "" {
+		t.Fatalf("want no title before the host types, got %q", got)
+	}
+
+	if _, err := io.ReadAll(a.Reader(strings.NewReader("a"))); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "upterm: host is typing", title(time.Now()); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	if got := title(time.Now().Add(activityTypingWindow)); got != "" {
+		t.Fatalf("want no indicator after the typing window, got %q", got)
+	}
+
+	timer := a.Title(func(now time.Time) string { return "upterm: 1m elapsed" }, readonly)
+	if want, got := "upterm: 1m elapsed, host is typing", timer(time.Now()); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// clients of sessions that aren't read-only aren't shown the indicator
+	if want, got := "upterm: 1m elapsed", a.Title(func(now time.Time) string { return "upterm: 1m elapsed" }, NewReadOnly(false, nil))(time.Now()); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	var nilActivity *Activity
+	r := strings.NewReader("a")
+	if nilActivity.Reader(r) != io.Reader(r) {
+		t.Fatal("want the reader unchanged without activity")
+	}
+}
+
+func Test_Activity_writeFrozenNotices(t *testing.T) {
+	a := NewActivity(time.Now())
+	a.frozenAfter = 50 * time.Millisecond
+
+	banners := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- a.writeFrozenNotices(ctx, chanWriter(banners), NewReadOnly(true, nil))
+	}()
+
+	waitBanner := func() {
+		t.Helper()
+		select {
+		case b := <-banners:
+			if !strings.Contains(b, "No output for 50ms, the session is still alive") {
+				t.Fatalf("want frozen notice, got %q", b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want frozen notice")
+		}
+	}
+	waitBanner()
+
+	// the notice is written once per stall
+	select {
+	case b := <-banners:
+		t.Fatalf("want one notice while the output stalls, got %q", b)
+	case <-time.After(3 * a.frozenAfter):
+	}
+
+	if _, err := a.Writer(io.Discard).Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	waitBanner()
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context canceled, got %v", err)
+	}
+}
+
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
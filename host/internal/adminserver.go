@@ -2,16 +2,43 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"runtime"
 	"sync"
+	"time"
 
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
 	"github.com/owenthereal/upterm/host/api"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// adminSocketMode restricts the admin socket to the user of the host, since it spawns sessions, streams the output,
+// and kicks clients.
+const adminSocketMode = 0600
+
 type AdminServer struct {
-	Session    *api.GetSessionResponse
-	ClientRepo *ClientRepo
+	Session *api.GetSessionResponse
+	// AuthorizedKeys overrides the authorized keys of Session if it's non-nil, e.g. as they are refreshed.
+	AuthorizedKeys *AuthorizedKeys
+	ClientRepo     *ClientRepo
+	Stats          *Stats
+	EventEmitter   *emitter.Emitter
+	ReadOnly       *ReadOnly
+	// Conns lets the host kick clients.
+	Conns *ClientConns
+	// Attribution attributes the input of clients, which is shown with the clients connected if it's non-nil.
+	Attribution *InputAttribution
+	// Output streams the output of the terminal. Streaming it is refused if it's nil.
+	Output *OutputStream
+	// NewSession spawns another session running command, or the command of the host if it's empty.
+	// Spawning sessions is refused if it's nil.
+	NewSession func(command []string) (*api.GetSessionResponse, error)
 	srv        *grpc.Server
 	sync.Mutex
 }
@@ -21,12 +48,31 @@ func (s *AdminServer) Serve(ctx context.Context, sock string) error {
 	if err != nil {
 		return err
 	}
+	// the permissions of unix sockets aren't honored on windows
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(sock, adminSocketMode); err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("error restricting admin socket: %w", err)
+		}
+	}
+
+	if s.ReadOnly == nil {
+		s.ReadOnly = NewReadOnly(false, s.EventEmitter)
+	}
 
 	s.Lock()
 	s.srv = grpc.NewServer()
 	api.RegisterAdminServiceServer(s.srv, &adminServiceServer{
-		Session:    s.Session,
-		ClientRepo: s.ClientRepo,
+		Session:        s.Session,
+		AuthorizedKeys: s.AuthorizedKeys,
+		ClientRepo:     s.ClientRepo,
+		Stats:          s.Stats,
+		EventEmitter:   s.EventEmitter,
+		ReadOnly:       s.ReadOnly,
+		Conns:          s.Conns,
+		Attribution:    s.Attribution,
+		Output:         s.Output,
+		newSession:     s.NewSession,
 	})
 	s.Unlock()
 
@@ -37,6 +83,10 @@ func (s *AdminServer) Shutdown(ctx context.Context) error {
 	s.Lock()
 	defer s.Unlock()
 
+	// end streaming the output, which would keep stopping the server waiting
+	if s.Output != nil {
+		s.Output.Close()
+	}
 	if s.srv != nil {
 		s.srv.GracefulStop()
 	}
@@ -45,18 +95,153 @@ func (s *AdminServer) Shutdown(ctx context.Context) error {
 }
 
 type adminServiceServer struct {
-	Session    *api.GetSessionResponse
-	ClientRepo *ClientRepo
+	Session        *api.GetSessionResponse
+	AuthorizedKeys *AuthorizedKeys
+	ClientRepo     *ClientRepo
+	Stats          *Stats
+	EventEmitter   *emitter.Emitter
+	ReadOnly       *ReadOnly
+	Conns          *ClientConns
+	Attribution    *InputAttribution
+	Output         *OutputStream
+	newSession     func(command []string) (*api.GetSessionResponse, error)
 }
 
 func (s *adminServiceServer) GetSession(ctx context.Context, in *api.GetSessionRequest) (*api.GetSessionResponse, error) {
+	authorizedKeys := s.Session.AuthorizedKeys
+	if s.AuthorizedKeys != nil {
+		authorizedKeys = s.AuthorizedKeys.API()
+	}
+
 	return &api.GetSessionResponse{
-		SessionId:        s.Session.SessionId,
-		Host:             s.Session.Host,
-		NodeAddr:         s.Session.NodeAddr,
-		Command:          s.Session.Command,
-		ForceCommand:     s.Session.ForceCommand,
-		AuthorizedKeys:   s.Session.AuthorizedKeys,
-		ConnectedClients: s.ClientRepo.Clients(),
+		SessionId:          s.Session.SessionId,
+		Name:               s.Session.Name,
+		Host:               s.Session.Host,
+		NodeAddr:           s.Session.NodeAddr,
+		Command:            s.Session.Command,
+		ForceCommand:       s.Session.ForceCommand,
+		AuthorizedKeys:     authorizedKeys,
+		ConnectedClients:   s.Attribution.Attribute(s.ClientRepo.Clients()),
+		StartAt:            s.Session.StartAt,
+		MaxDurationSeconds: s.Session.MaxDurationSeconds,
+		DirectAddr:         s.Session.DirectAddr,
+		Sandbox:            s.Session.Sandbox,
+		Menu:               s.Session.Menu,
+		Stats:              s.Stats.Snapshot(time.Now()),
+		ReadOnly:           s.ReadOnly.Get(),
+		ExpiresAt:          s.Session.ExpiresAt,
+		HostPublicKeys:     s.Session.HostPublicKeys,
+		ServerHostKey:      s.Session.ServerHostKey,
+		AuthKey:            s.Session.AuthKey,
+		ForwardedPorts:     s.Session.ForwardedPorts,
+		MaxClients:         s.Session.MaxClients,
+		Recorded:           s.Session.Recorded,
+		VscodePort:         s.Session.VscodePort,
+		VscodePassword:     s.Session.VscodePassword,
 	}, nil
 }
+
+// SetSession changes the session while clients are connected. It returns the changed session.
+func (s *adminServiceServer) SetSession(ctx context.Context, in *api.SetSessionRequest) (*api.GetSessionResponse, error) {
+	if in.ReadOnly != nil {
+		s.ReadOnly.Set(*in.ReadOnly)
+	}
+
+	return s.GetSession(ctx, &api.GetSessionRequest{})
+}
+
+// KickClient closes the connection of a client, which leaves the session.
+func (s *adminServiceServer) KickClient(ctx context.Context, in *api.KickClientRequest) (*api.KickClientResponse, error) {
+	if err := s.Conns.Kick(in.ClientId); err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, status.Errorf(codes.NotFound, "client %s isn't connected", in.ClientId)
+		}
+		return nil, err
+	}
+
+	return &api.KickClientResponse{}, nil
+}
+
+// NewSession spawns another session of a listen-only host.
+func (s *adminServiceServer) NewSession(ctx context.Context, in *api.NewSessionRequest) (*api.GetSessionResponse, error) {
+	if s.newSession == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the host serves a single session, run 'upterm host --listen-only' to spawn sessions")
+	}
+
+	return s.newSession(in.Command)
+}
+
+// WatchEvents streams client joined, left, and input events until the client cancels.
+func (s *adminServiceServer) WatchEvents(in *api.WatchEventsRequest, stream api.AdminService_WatchEventsServer) error {
+	joined := events.On(s.EventEmitter, events.KindClientJoined)
+	defer events.Off(s.EventEmitter, events.KindClientJoined, joined)
+	left := events.On(s.EventEmitter, events.KindClientLeft)
+	defer events.Off(s.EventEmitter, events.KindClientLeft, left)
+	input := events.On(s.EventEmitter, events.KindClientInput)
+	defer events.Off(s.EventEmitter, events.KindClientInput, input)
+
+	for {
+		var evt emitter.Event
+		select {
+		case evt = <-joined:
+		case evt = <-left:
+		case evt = <-input:
+		case <-stream.Context().Done():
+			return nil
+		}
+
+		se := &api.SessionEvent{
+			Time: time.Now().Unix(),
+		}
+		switch e := events.From(evt).(type) {
+		case events.ClientJoined:
+			se.Kind, se.Client = string(e.Kind()), e.Client
+		case events.ClientLeft:
+			se.Kind, se.Client = string(e.Kind()), e.Client
+		case events.ClientInput:
+			se.Kind, se.Client = string(e.Kind()), e.Client
+		default:
+			continue
+		}
+
+		if err := stream.Send(se); err != nil {
+			return err
+		}
+	}
+}
+
+// StreamOutput streams the output of the terminal until the client cancels or falls behind.
+func (s *adminServiceServer) StreamOutput(in *api.StreamOutputRequest, stream api.AdminService_StreamOutputServer) error {
+	if s.Output == nil {
+		return status.Error(codes.FailedPrecondition, "the session doesn't stream its output")
+	}
+
+	sub := s.Output.Subscribe()
+	defer sub.Close()
+
+	for {
+		select {
+		case chunk := <-sub.Chunks():
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			if err := sub.Err(); err != nil {
+				return status.Error(codes.ResourceExhausted, err.Error())
+			}
+			// deliver the last output once the session ends
+			for {
+				select {
+				case chunk := <-sub.Chunks():
+					if err := stream.Send(chunk); err != nil {
+						return err
+					}
+				default:
+					return nil
+				}
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+func Test_AdminServer_socketMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions aren't honored on windows")
+	}
+
+	// unix socket paths are limited to ~100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "upterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "admin.sock")
+
+	s := &AdminServer{
+		Session:      &api.GetSessionResponse{SessionId: "session"},
+		ClientRepo:   NewClientRepo(),
+		EventEmitter: &emitter.Emitter{},
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background(), sock)
+	}()
+
+	var fi os.FileInfo
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if fi, err = os.Stat(sock); err == nil && fi.Mode().Perm() == adminSocketMode {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != adminSocketMode {
+		t.Fatalf("want mode %o, got %o", adminSocketMode, fi.Mode().Perm())
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/approval"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/upterm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Approval keeps clients of a labeled session waiting until an approver lets them in.
+// A key approved once isn't asked for again in the session, e.g. when the client reconnects or opens SFTP.
+type Approval struct {
+	Policy    *approval.Policy
+	SessionID string
+	Labels    approval.Labels
+
+	mu       sync.Mutex
+	approved map[string]approval.Decision
+}
+
+func (a *Approval) lookup(fingerprint string) (approval.Decision, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d, ok := a.approved[fingerprint]
+	return d, ok
+}
+
+func (a *Approval) remember(fingerprint string, d approval.Decision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.approved == nil {
+		a.approved = make(map[string]approval.Decision)
+	}
+	a.approved[fingerprint] = d
+}
+
+// Approved reports whether the client of ctx has been approved, without asking for it.
+func (a *Approval) Approved(ctx gssh.Context) bool {
+	c, ok := ctx.Value(contextKeyClient).(*api.Client)
+	if !ok {
+		return false
+	}
+
+	_, ok = a.lookup(c.PublicKeyFingerprint)
+	return ok
+}
+
+// Wait waits for the approval of the client of sess, writing the waiting state to w if it's non-nil and pinging the
+// client every keepAlive to keep the connection alive. It returns an error if the client is denied or disconnects.
+func (a *Approval) Wait(ctx context.Context, sess gssh.Session, w io.Writer, keepAlive time.Duration, eventEmmiter *emitter.Emitter, logger log.FieldLogger) error {
+	c, ok := sess.Context().Value(contextKeyClient).(*api.Client)
+	if !ok {
+		return fmt.Errorf("%w: unknown client", approval.ErrDenied)
+	}
+	if _, ok := a.lookup(c.PublicKeyFingerprint); ok {
+		return nil
+	}
+
+	write := func(s string) {
+		if w != nil {
+			_, _ = io.WriteString(w, s)
+		}
+	}
+	emit := func(status string, d approval.Decision) {
+		events.Emit(eventEmmiter, events.ClientApproval{
+			Client:   c,
+			Labels:   a.Labels,
+			Status:   status,
+			Approver: d.Approver,
+			Reason:   d.Reason,
+		})
+	}
+
+	write(fmt.Sprintf("\r\n=== Session labeled %s requires approval. Waiting for an approver... ===\r\n", a.Labels))
+	emit(events.ApprovalRequested, approval.Decision{})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		d   approval.Decision
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		d, err := a.Policy.Approve(ctx, approval.Request{
+			SessionID: a.SessionID,
+			Labels:    a.Labels,
+			Client: approval.Client{
+				PublicKeyFingerprint: c.PublicKeyFingerprint,
+				DisplayName:          c.DisplayName,
+				Addr:                 c.Addr,
+				Version:              c.Version,
+			},
+		})
+		done <- result{d: d, err: err}
+	}()
+
+	// keep the connection alive while waiting
+	var tick <-chan time.Time
+	if keepAlive > 0 {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				if !errors.Is(r.err, approval.ErrDenied) {
+					logger.WithError(r.err).Error("error requesting approval")
+					r.d.Reason = "error requesting approval"
+					r.err = fmt.Errorf("%w: %s", approval.ErrDenied, r.d.Reason)
+				}
+				emit(events.ApprovalDenied, r.d)
+				write(fmt.Sprintf("\r\n=== Access %s ===\r\n", describeDecision(r.d, "denied")))
+				return r.err
+			}
+
+			a.remember(c.PublicKeyFingerprint, r.d)
+			emit(events.ApprovalApproved, r.d)
+			write(fmt.Sprintf("\r\n=== Access %s ===\r\n\r\n", describeDecision(r.d, "approved")))
+			return nil
+		case <-tick:
+			if _, err := sess.SendRequest(upterm.OpenSSHKeepAliveRequestType, true, nil); err != nil {
+				logger.WithError(err).Debug("error pinging client to keepalive")
+			}
+		case <-sess.Context().Done():
+			emit(events.ApprovalDenied, approval.Decision{Reason: "client disconnected"})
+			return sess.Context().Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// describeDecision describes the decision d, e.g. "approved by alice" or "denied by bob: outside the change window".
+func describeDecision(d approval.Decision, verb string) string {
+	s := verb
+	if d.Approver != "" {
+		s += " by " + d.Approver
+	}
+	if d.Reason != "" {
+		s += ": " + d.Reason
+	}
+
+	return s
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// inputBurstGap is how long a client pauses typing before its next input starts another burst.
+const inputBurstGap = 2 * time.Second
+
+// NewInputAttribution returns an InputAttribution emitting events.ClientInput on eventEmitter.
+func NewInputAttribution(eventEmitter *emitter.Emitter) *InputAttribution {
+	return &InputAttribution{
+		eventEmitter: eventEmitter,
+		inputs:       make(map[string]*clientInput),
+	}
+}
+
+// InputAttribution attributes the input into the terminal of the host to the clients typing it, so that it's known
+// who typed what when several clients can type. Each burst of input of a client is announced with
+// events.ClientInput, and the input of clients is totaled for the admin API.
+type InputAttribution struct {
+	eventEmitter *emitter.Emitter
+
+	mu sync.Mutex
+	// typist is the client typing the current burst, or nil if the host typed last.
+	typist *api.Client
+	lastAt time.Time
+	inputs map[string]*clientInput
+}
+
+type clientInput struct {
+	bytes  int64
+	lastAt time.Time
+}
+
+// Writer attributes the input of client written to w, i.e. forwarded to the terminal. It returns w if a is nil.
+func (a *InputAttribution) Writer(w io.Writer, client *api.Client) io.Writer {
+	if a == nil || client == nil {
+		return w
+	}
+
+	return &attributedWriter{w: w, a: a, client: client}
+}
+
+// Reader ends the burst of the client typing when the host types, reading from r. It returns r if a is nil.
+func (a *InputAttribution) Reader(r io.Reader) io.Reader {
+	if a == nil {
+		return r
+	}
+
+	return &hostInputReader{r: r, a: a}
+}
+
+// Typing returns the client typing a burst of input as of now, or nil if none is.
+func (a *InputAttribution) Typing(now time.Time) *api.Client {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.typist == nil || now.Sub(a.lastAt) >= inputBurstGap {
+		return nil
+	}
+
+	return a.typist
+}
+
+// Attribute returns copies of clients with the input they typed. It returns clients if a is nil.
+func (a *InputAttribution) Attribute(clients []*api.Client) []*api.Client {
+	if a == nil {
+		return clients
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	attributed := make([]*api.Client, len(clients))
+	for i, c := range clients {
+		c = proto.Clone(c).(*api.Client)
+		if in, ok := a.inputs[c.Id]; ok {
+			c.InputBytes, c.LastInputAt = in.bytes, in.lastAt.Unix()
+		}
+		attributed[i] = c
+	}
+
+	return attributed
+}
+
+// Forget drops the input of the client with the ID once it leaves. It's a no-op if a is nil.
+func (a *InputAttribution) Forget(id string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.inputs, id)
+	if a.typist != nil && a.typist.Id == id {
+		a.typist = nil
+	}
+}
+
+func (a *InputAttribution) record(client *api.Client, n int, now time.Time) {
+	a.mu.Lock()
+	burst := a.typist == nil || a.typist.Id != client.Id || now.Sub(a.lastAt) >= inputBurstGap
+	a.typist, a.lastAt = client, now
+	in, ok := a.inputs[client.Id]
+	if !ok {
+		in = &clientInput{}
+		a.inputs[client.Id] = in
+	}
+	in.bytes += int64(n)
+	in.lastAt = now
+	a.mu.Unlock()
+
+	if burst {
+		events.Emit(a.eventEmitter, events.ClientInput{Client: client})
+	}
+}
+
+func (a *InputAttribution) hostTyped() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.typist = nil
+}
+
+type attributedWriter struct {
+	w      io.Writer
+	a      *InputAttribution
+	client *api.Client
+}
+
+func (w *attributedWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.a.record(w.client, n, time.Now())
+	}
+
+	return n, err
+}
+
+type hostInputReader struct {
+	r io.Reader
+	a *InputAttribution
+}
+
+func (r *hostInputReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.a.hostTyped()
+	}
+
+	return n, err
+}
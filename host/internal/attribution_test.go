@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+func Test_InputAttribution(t *testing.T) {
+	em := emitter.New(10)
+	input := events.On(em, events.KindClientInput)
+	defer events.Off(em, events.KindClientInput, input)
+
+	a := NewInputAttribution(em)
+	alice := &api.Client{Id: "alice"}
+	bob := &api.Client{Id: "bob"}
+
+	var pty bytes.Buffer
+	write := func(c *api.Client, s string) {
+		if _, err := a.Writer(&pty, c).Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantInput := func(c *api.Client) {
+		t.Helper()
+		select {
+		case evt := <-input:
+			if e, ok := events.From(evt).(events.ClientInput); !ok || e.Client.Id != c.Id {
+				t.Fatalf("want input of %s, got %v", c.Id, evt.Args)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want input of %s", c.Id)
+		}
+	}
+	wantNoInput := func() {
+		t.Helper()
+		select {
+		case evt := <-input:
+			t.Fatalf("want no input, got %v", evt.Args)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// a burst of input is announced once
+	write(alice, "l")
+	write(alice, "s")
+	wantInput(alice)
+	wantNoInput()
+	if c := a.Typing(time.Now()); c == nil || c.Id != alice.Id {
+		t.Fatalf("want alice typing, got %v", c)
+	}
+	if c := a.Typing(time.Now().Add(inputBurstGap)); c != nil {
+		t.Fatalf("want nobody typing after a pause, got %v", c)
+	}
+
+	// another client starts another burst
+	write(bob, "echo")
+	wantInput(bob)
+
+	// so does the client after the host typed
+	if _, err := a.Reader(strings.NewReader("x")).Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if c := a.Typing(time.Now()); c != nil {
+		t.Fatalf("want nobody typing after the host, got %v", c)
+	}
+	write(bob, "!")
+	wantInput(bob)
+
+	if want, got := "lsecho!", pty.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	carol := &api.Client{Id: "carol"}
+	clients := a.Attribute([]*api.Client{alice, bob, carol})
+	for i, want := range []int64{2, 5, 0} {
+		if got := clients[i].InputBytes; got != want {
+			t.Fatalf("want %d bytes typed by %s, got %d", want, clients[i].Id, got)
+		}
+	}
+	if clients[0].LastInputAt == 0 || clients[2].LastInputAt != 0 {
+		t.Fatalf("unexpected last input of %v", clients)
+	}
+	if alice.InputBytes != 0 {
+		t.Fatal("expect the clients not modified")
+	}
+
+	a.Forget(bob.Id)
+	if c := a.Typing(time.Now()); c != nil {
+		t.Fatalf("want nobody typing after bob left, got %v", c)
+	}
+	if got := a.Attribute([]*api.Client{bob})[0].InputBytes; got != 0 {
+		t.Fatalf("want the input of bob forgotten, got %d", got)
+	}
+
+	// attribution is optional
+	var nilA *InputAttribution
+	if w := nilA.Writer(&pty, alice); w != &pty {
+		t.Fatal("expect the writer unwrapped")
+	}
+	if clients := nilA.Attribute([]*api.Client{alice}); clients[0] != alice {
+		t.Fatal("expect the clients as is")
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"sync"
+
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKeys are the keys of the clients authorized to join the session, which may change while the session
+// is hosted, e.g. when the keys of users are re-fetched. It also resolves the display names of the keys.
+type AuthorizedKeys struct {
+	mu    sync.RWMutex
+	keys  []ssh.PublicKey
+	api   []*api.AuthorizedKey
+	names identity.Names
+}
+
+// NewAuthorizedKeys returns the authorized keys. apiKeys describe the keys by source in the admin API.
+func NewAuthorizedKeys(keys []ssh.PublicKey, apiKeys []*api.AuthorizedKey, names identity.Names) *AuthorizedKeys {
+	return &AuthorizedKeys{keys: keys, api: apiKeys, names: names}
+}
+
+// Set replaces the authorized keys.
+func (a *AuthorizedKeys) Set(keys []ssh.PublicKey, apiKeys []*api.AuthorizedKey, names identity.Names) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keys, a.api, a.names = keys, apiKeys, names
+}
+
+// Keys returns the authorized keys.
+func (a *AuthorizedKeys) Keys() []ssh.PublicKey {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.keys
+}
+
+// API returns the authorized keys by source in the admin API.
+func (a *AuthorizedKeys) API() []*api.AuthorizedKey {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.api
+}
+
+// Authorized reports whether key is one of the authorized keys.
+func (a *AuthorizedKeys) Authorized(key ssh.PublicKey) bool {
+	for _, k := range a.Keys() {
+		if utils.KeysEqual(k, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *AuthorizedKeys) Resolve(ctx context.Context, key ssh.PublicKey) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.names.Resolve(ctx, key)
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"syscall"
 
 	"github.com/oklog/run"
 	"github.com/olebedev/emitter"
@@ -40,33 +39,116 @@ type command struct {
 	args []string
 	env  []string
 
-	cmd  *exec.Cmd
-	ptmx *pty
+	cmd     *exec.Cmd
+	backend PtyBackend
+	ptmx    Pty
+	proc    Process
 
 	stdin  *os.File
 	stdout *os.File
 
 	writers *uio.MultiWriter
+	// hotkeys are called when the host types hotkeyPrefix followed by the key.
+	hotkeys map[byte]func()
+	// privacy routes the output to stdout only while the host types privately.
+	privacy *Privacy
+	// timer shows the time of the session in the terminal title of the host if it's non-nil.
+	timer *SessionTimer
+	// status shows the clients connected on the last line of the terminal of the host if it's non-nil.
+	status *statusLine
+	// idle records the input of the host as activity of the session if it's non-nil.
+	idle *SessionIdle
+	// activity records the input of the host and the output of the command if it's non-nil.
+	activity *Activity
+	// attribution ends the burst of input of the client typing when the host types if it's non-nil.
+	attribution *InputAttribution
+	// restart restarts the command when it fails, and keepOnExit keeps the terminal once it exits until the host
+	// or a client types respawnKeyRestart or respawnKeyQuit. respawn is the terminal of the command if either is
+	// set.
+	restart    bool
+	keepOnExit bool
+	respawn    *respawnPty
 
 	eventEmitter *emitter.Emitter
 
 	ctx context.Context
 }
 
-func (c *command) Start(ctx context.Context) (*pty, error) {
+func (c *command) Start(ctx context.Context) (Pty, error) {
 	c.ctx = ctx
-	c.cmd = exec.CommandContext(ctx, c.name, c.args...)
-	c.cmd.Env = append(c.env, os.Environ()...)
+	if c.backend == nil {
+		c.backend = ptyBackend{}
+	}
 
 	var err error
-	c.ptmx, err = startPty(c.cmd)
+	c.ptmx, c.proc, err = c.start()
 	if err != nil {
-		return nil, fmt.Errorf("unable to start pty: %w", err)
+		return nil, err
+	}
+	if c.restart || c.keepOnExit {
+		c.respawn = newRespawnPty(c.ptmx)
+		c.ptmx = c.respawn
 	}
 
 	return c.ptmx, nil
 }
 
+func (c *command) start() (Pty, Process, error) {
+	c.cmd = exec.CommandContext(c.ctx, c.name, c.args...)
+	c.cmd.Env = append(c.env, os.Environ()...)
+	hangUpOnCancel(c.cmd)
+
+	ptmx, proc, err := startedCommands.Start(c.backend, c.cmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to start pty: %w", err)
+	}
+
+	return ptmx, proc, nil
+}
+
+// wait waits for the command to exit, restarting it if it fails or the host or a client asks to.
+func (c *command) wait() error {
+	for {
+		err := c.proc.Wait()
+		if c.respawn == nil || c.ctx.Err() != nil {
+			return err
+		}
+
+		switch {
+		case c.restart && err != nil:
+			c.respawn.Exited(false)
+			c.respawn.WaitDrained(c.ctx, respawnDrainTimeout)
+			c.banner(fmt.Sprintf("The command %s, restarting it", describeExit(err)))
+			if !c.respawn.Delay(c.ctx, commandRestartDelay) {
+				return err
+			}
+		case c.keepOnExit:
+			c.respawn.Exited(true)
+			c.respawn.WaitDrained(c.ctx, respawnDrainTimeout)
+			c.banner(fmt.Sprintf("The command %s. Press %c to restart it or %c to end the session", describeExit(err), respawnKeyRestart, respawnKeyQuit))
+			if !c.respawn.WaitRestart(c.ctx) {
+				return err
+			}
+		default:
+			return err
+		}
+
+		ptmx, proc, serr := c.start()
+		if serr != nil {
+			return fmt.Errorf("error restarting command: %w", serr)
+		}
+		c.proc = proc
+		if err := c.respawn.Swap(ptmx); err != nil {
+			return err
+		}
+	}
+}
+
+// banner writes a message to the host and clients.
+func (c *command) banner(msg string) {
+	_, _ = fmt.Fprintf(c.writers, "\r\n=== %s ===\r\n", msg)
+}
+
 func (c *command) Run() error {
 	// Set stdin in raw mode.
 	isTty := term.IsTerminal(int(c.stdin.Fd()))
@@ -83,26 +165,31 @@ func (c *command) Run() error {
 	if isTty {
 		// pty
 		ch := make(chan os.Signal, 1)
-		signal.Notify(ch, syscall.SIGWINCH)
-		ch <- syscall.SIGWINCH // Initial resize.
+		if len(windowChangedSignals) > 0 {
+			signal.Notify(ch, windowChangedSignals...)
+		}
+		ch <- nil // Initial resize.
 		ctx, cancel := context.WithCancel(c.ctx)
 		tee := terminalEventEmitter{c.eventEmitter}
 		g.Add(func() error {
 			for {
 				select {
 				case <-ctx.Done():
-					close(ch)
+					signal.Stop(ch)
 					return ctx.Err()
 				case <-ch:
-					h, w, err := getPtysize(c.stdin)
+					w, h, err := term.GetSize(int(c.stdin.Fd()))
 					if err != nil {
 						return err
 					}
-					tee.TerminalWindowChanged("local", c.ptmx, w, h)
+					if c.status != nil {
+						w, h = c.status.Resize(w, h)
+					}
+					tee.TerminalWindowChanged(localTerminalID, c.ptmx, w, h)
 				}
 			}
 		}, func(err error) {
-			tee.TerminalDetached("local", c.ptmx)
+			tee.TerminalDetached(localTerminalID, c.ptmx)
 			cancel()
 		})
 	}
@@ -111,7 +198,11 @@ func (c *command) Run() error {
 		// input
 		ctx, cancel := context.WithCancel(c.ctx)
 		g.Add(func() error {
-			_, err := io.Copy(c.ptmx, uio.NewContextReader(ctx, c.stdin))
+			var w io.Writer = c.ptmx
+			if len(c.hotkeys) > 0 {
+				w = &hotkeyWriter{w: c.ptmx, keys: c.hotkeys}
+			}
+			_, err := uio.Copy(w, uio.NewContextReader(ctx, c.attribution.Reader(c.activity.Reader(c.idle.Reader(c.stdin)))))
 			return err
 		}, func(err error) {
 			cancel()
@@ -119,21 +210,41 @@ func (c *command) Run() error {
 	}
 	{
 		// output
-		if err := c.writers.Append(c.stdout); err != nil {
+		var stdout io.Writer = c.stdout
+		if c.status != nil {
+			stdout = c.status
+		}
+		if c.timer != nil {
+			tw := c.timer.Writer(stdout, nil)
+			stdout = tw
+
+			ctx, cancel := context.WithCancel(c.ctx)
+			g.Add(func() error {
+				return tw.Run(ctx)
+			}, func(err error) {
+				cancel()
+			})
+		}
+		if err := c.writers.Append(stdout); err != nil {
 			return err
 		}
+		var w io.Writer = c.writers
+		if c.privacy != nil {
+			w = c.privacy.Writer(c.writers, stdout)
+		}
+		w = c.activity.Writer(w)
 		ctx, cancel := context.WithCancel(c.ctx)
 		g.Add(func() error {
-			_, err := io.Copy(c.writers, uio.NewContextReader(ctx, c.ptmx))
+			_, err := uio.Copy(w, uio.NewContextReader(ctx, c.ptmx))
 			return ptyError(err)
 		}, func(err error) {
-			c.writers.Remove(os.Stdout)
+			c.writers.Remove(stdout)
 			cancel()
 		})
 	}
 	{
 		g.Add(func() error {
-			return c.cmd.Wait()
+			return c.wait()
 		}, func(err error) {
 			c.ptmx.Close()
 		})
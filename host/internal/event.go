@@ -5,19 +5,28 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/olebedev/emitter"
-	"github.com/owenthereal/upterm/upterm"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	errBadFileDescriptor = "bad file descriptor"
+
+	// Terminal events are internal to the host and carry the pty,
+	// so they are not part of the public events package.
+	eventTerminalWindowChanged = "terminal-window-changed"
+	eventTerminalDetached      = "terminal-detached"
+
+	// windowCoalesceDelay is how long window changes are coalesced before resizing ptys, so that commands
+	// receive a single SIGWINCH when clients drag their windows or several clients resize at once.
+	windowCoalesceDelay = 50 * time.Millisecond
 )
 
 type terminal struct {
 	ID     string
-	Pty    *pty
+	Pty    Pty
 	Window window
 }
 
@@ -30,7 +39,7 @@ type terminalEventEmitter struct {
 	eventEmitter *emitter.Emitter
 }
 
-func (t terminalEventEmitter) TerminalWindowChanged(id string, pty *pty, w, h int) {
+func (t terminalEventEmitter) TerminalWindowChanged(id string, pty Pty, w, h int) {
 	tt := terminal{
 		ID:  id,
 		Pty: pty,
@@ -39,57 +48,108 @@ func (t terminalEventEmitter) TerminalWindowChanged(id string, pty *pty, w, h in
 			Height: h,
 		},
 	}
-	t.eventEmitter.Emit(upterm.EventTerminalWindowChanged, tt)
+	t.eventEmitter.Emit(eventTerminalWindowChanged, tt)
 }
 
-func (t terminalEventEmitter) TerminalDetached(id string, pty *pty) {
+func (t terminalEventEmitter) TerminalDetached(id string, pty Pty) {
 	tt := terminal{
 		ID:  id,
 		Pty: pty,
 	}
-	t.eventEmitter.Emit(upterm.EventTerminalDetached, tt)
+	t.eventEmitter.Emit(eventTerminalDetached, tt)
 }
 
 type terminalEventHandler struct {
 	eventEmitter *emitter.Emitter
+	sizePolicy   *SizePolicy
 	logger       log.FieldLogger
 }
 
 func (t terminalEventHandler) Handle(ctx context.Context) error {
-	winCh := t.eventEmitter.On(upterm.EventTerminalWindowChanged, emitter.Sync, emitter.Skip)
-	dtCh := t.eventEmitter.On(upterm.EventTerminalDetached, emitter.Sync, emitter.Skip)
+	winCh := t.eventEmitter.On(eventTerminalWindowChanged, emitter.Sync, emitter.Skip)
+	dtCh := t.eventEmitter.On(eventTerminalDetached, emitter.Sync, emitter.Skip)
+
+	defer func() {
+		t.eventEmitter.Off(eventTerminalWindowChanged, winCh)
+		t.eventEmitter.Off(eventTerminalDetached, dtCh)
+	}()
 
+	var (
+		m       = make(map[io.ReadWriteCloser]map[string]terminal)
+		resizer = newWindowResizer(t.sizePolicy)
+		timer   *time.Timer
+		timerC  <-chan time.Time
+	)
 	defer func() {
-		t.eventEmitter.Off(upterm.EventTerminalWindowChanged, winCh)
-		t.eventEmitter.Off(upterm.EventTerminalDetached, dtCh)
+		if timer != nil {
+			timer.Stop()
+		}
 	}()
+	// The first window change is applied right away, e.g. when a client joins, and later ones
+	// are coalesced until windowCoalesceDelay passes without changes.
+	resize := func() {
+		if err := resizer.Resize(m); err != nil {
+			t.logger.WithError(err).Error("error resizing window")
+		}
+	}
+	changed := func(pty Pty) {
+		resizer.Changed(pty)
+		if timerC != nil {
+			return
+		}
+
+		resize()
+		if timer == nil {
+			timer = time.NewTimer(windowCoalesceDelay)
+		} else {
+			timer.Reset(windowCoalesceDelay)
+		}
+		timerC = timer.C
+	}
 
-	m := make(map[io.ReadWriteCloser]map[string]terminal)
 	for {
 		select {
 		case evt := <-winCh:
-			if err := t.handleWindowChanged(evt, m); err != nil {
+			pty, err := t.handleWindowChanged(evt, m)
+			if err != nil {
 				t.logger.WithError(err).Error("error handling window changed")
+				continue
 			}
+			changed(pty)
 		case evt := <-dtCh:
-			if err := t.handleTerminalDetached(evt, m); err != nil {
+			pty, err := t.handleTerminalDetached(evt, m)
+			if err != nil {
 				t.logger.WithError(err).Error("error handling terminal detached")
+				continue
 			}
+			// the pty may be resized when a terminal is detached, e.g. grow when its smallest one is
+			if _, ok := m[pty]; ok {
+				changed(pty)
+			} else {
+				resizer.Forget(pty)
+			}
+		case <-timerC:
+			if !resizer.Pending() {
+				timerC = nil
+				continue
+			}
+			resize()
+			timer.Reset(windowCoalesceDelay)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
-func (t terminalEventHandler) handleWindowChanged(evt emitter.Event, m map[io.ReadWriteCloser]map[string]terminal) error {
+func (t terminalEventHandler) handleWindowChanged(evt emitter.Event, m map[io.ReadWriteCloser]map[string]terminal) (Pty, error) {
 	args := evt.Args
 	if len(args) == 0 {
-		return fmt.Errorf("expect terminal window change event to have at least one argument")
+		return nil, fmt.Errorf("expect terminal window change event to have at least one argument")
 	}
 
 	tt, ok := args[0].(terminal)
 	if !ok {
-		return fmt.Errorf("expect terminal window change event to receive a terminal")
+		return nil, fmt.Errorf("expect terminal window change event to receive a terminal")
 	}
 
 	pty := tt.Pty
@@ -99,22 +159,19 @@ func (t terminalEventHandler) handleWindowChanged(evt emitter.Event, m map[io.Re
 		m[pty] = ts
 	}
 	ts[tt.ID] = tt
-	if err := resizeWindow(pty, ts); err != nil && !strings.Contains(err.Error(), errBadFileDescriptor) {
-		return fmt.Errorf("error resizing window: %w", err)
-	}
 
-	return nil
+	return pty, nil
 }
 
-func (t terminalEventHandler) handleTerminalDetached(evt emitter.Event, m map[io.ReadWriteCloser]map[string]terminal) error {
+func (t terminalEventHandler) handleTerminalDetached(evt emitter.Event, m map[io.ReadWriteCloser]map[string]terminal) (Pty, error) {
 	args := evt.Args
 	if len(args) == 0 {
-		return fmt.Errorf("expect terminal window change event to have at least one argument")
+		return nil, fmt.Errorf("expect terminal window change event to have at least one argument")
 	}
 
 	tt, ok := args[0].(terminal)
 	if !ok {
-		return fmt.Errorf("expect terminal window change event to receive a terminal")
+		return nil, fmt.Errorf("expect terminal window change event to receive a terminal")
 	}
 
 	pty := tt.Pty
@@ -127,21 +184,65 @@ func (t terminalEventHandler) handleTerminalDetached(evt emitter.Event, m map[io
 		delete(m, pty)
 	}
 
-	return nil
+	return pty, nil
 }
 
-func resizeWindow(ptmx *pty, ts map[string]terminal) error {
-	var w, h int
+func newWindowResizer(policy *SizePolicy) *windowResizer {
+	if policy == nil {
+		policy = &SizePolicy{name: SizePolicySmallest}
+	}
 
-	for _, t := range ts {
-		if w == 0 || w > t.Window.Width {
-			w = t.Window.Width
+	return &windowResizer{
+		policy:  policy,
+		pending: make(map[Pty]bool),
+		sizes:   make(map[Pty]window),
+	}
+}
+
+// windowResizer resizes ptys with pending window changes to the windows resolved by the size policy.
+// Ptys are only resized if their sizes change, since each resize signals SIGWINCH to their commands.
+type windowResizer struct {
+	policy  *SizePolicy
+	pending map[Pty]bool
+	sizes   map[Pty]window
+}
+
+func (r *windowResizer) Changed(pty Pty) {
+	r.pending[pty] = true
+}
+
+func (r *windowResizer) Pending() bool {
+	return len(r.pending) > 0
+}
+
+func (r *windowResizer) Forget(pty Pty) {
+	delete(r.pending, pty)
+	delete(r.sizes, pty)
+}
+
+func (r *windowResizer) Resize(m map[io.ReadWriteCloser]map[string]terminal) error {
+	var result error
+	for pty := range r.pending {
+		delete(r.pending, pty)
+
+		ts, ok := m[pty]
+		if !ok {
+			continue
 		}
 
-		if h == 0 || h > t.Window.Height {
-			h = t.Window.Height
+		w := r.policy.fit(ts)
+		if r.sizes[pty] == w {
+			continue
+		}
+
+		if err := pty.Setsize(w.Height, w.Width); err != nil {
+			if !strings.Contains(err.Error(), errBadFileDescriptor) {
+				result = fmt.Errorf("error resizing window: %w", err)
+			}
+			continue
 		}
+		r.sizes[pty] = w
 	}
 
-	return ptmx.Setsize(h, w)
+	return result
 }
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"io"
+	"testing"
+)
+
+type sizeRecorder struct {
+	io.ReadWriteCloser
+	sizes []window
+}
+
+func (p *sizeRecorder) Setsize(h, w int) error {
+	p.sizes = append(p.sizes, window{Width: w, Height: h})
+	return nil
+}
+
+func Test_windowResizer(t *testing.T) {
+	pty := &sizeRecorder{}
+	m := map[io.ReadWriteCloser]map[string]terminal{
+		pty: {
+			"host":   {ID: "host", Pty: pty, Window: window{Width: 120, Height: 40}},
+			"client": {ID: "client", Pty: pty, Window: window{Width: 80, Height: 50}},
+		},
+	}
+
+	r := newWindowResizer(nil)
+	// window changes are coalesced into a single resize
+	r.Changed(pty)
+	r.Changed(pty)
+	if err := r.Resize(m); err != nil {
+		t.Fatal(err)
+	}
+	// unchanged sizes don't resize the pty again
+	r.Changed(pty)
+	if err := r.Resize(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pty grows when the smallest terminal is detached
+	delete(m[pty], "client")
+	r.Changed(pty)
+	if err := r.Resize(m); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []window{{Width: 80, Height: 40}, {Width: 120, Height: 40}}
+	if len(pty.sizes) != len(want) || pty.sizes[0] != want[0] || pty.sizes[1] != want[1] {
+		t.Fatalf("want resizes %v, got %v", want, pty.sizes)
+	}
+}
+
+func Test_SizePolicy(t *testing.T) {
+	host := terminal{ID: localTerminalID, Window: window{Width: 100, Height: 30}}
+	small := terminal{ID: "small", Window: window{Width: 80, Height: 40}}
+	large := terminal{ID: "large", Window: window{Width: 160, Height: 20}}
+
+	cases := []struct {
+		name          string
+		policy        string
+		width, height int
+		ts            []terminal
+		want          window
+	}{
+		{
+			name:   "host",
+			policy: SizePolicyHost,
+			ts:     []terminal{host, small, large},
+			want:   window{Width: 100, Height: 30},
+		},
+		{
+			name:   "host without the terminal of the host",
+			policy: SizePolicyHost,
+			ts:     []terminal{small, large},
+			want:   window{Width: 80, Height: 20},
+		},
+		{
+			name: "default",
+			ts:   []terminal{host, small, large},
+			want: window{Width: 80, Height: 20},
+		},
+		{
+			name:   "largest",
+			policy: SizePolicyLargest,
+			ts:     []terminal{host, small, large},
+			want:   window{Width: 160, Height: 40},
+		},
+		{
+			name:   "manual",
+			policy: SizePolicyManual,
+			width:  120,
+			height: 50,
+			ts:     []terminal{host, small},
+			want:   window{Width: 120, Height: 50},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := NewSizePolicy(c.policy, c.width, c.height)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ts := make(map[string]terminal)
+			for _, tt := range c.ts {
+				ts[tt.ID] = tt
+			}
+			if got := p.fit(ts); got != c.want {
+				t.Fatalf("want %v, got %v", c.want, got)
+			}
+		})
+	}
+
+	if _, err := NewSizePolicy(SizePolicyManual, 0, 0); err != ErrManualSizeRequired {
+		t.Fatalf("want %v, got %v", ErrManualSizeRequired, err)
+	}
+	if _, err := NewSizePolicy(SizePolicyHost, 120, 50); err == nil {
+		t.Fatal("want an error for a window size without the manual policy")
+	}
+	if _, err := NewSizePolicy("biggest", 0, 0); err == nil {
+		t.Fatal("want an error for an unsupported policy")
+	}
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/klauspost/compress/zstd"
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// execHandler runs the server side of file transfer tools clients exec without a terminal, e.g. scp and rsync -e ssh,
+// in the working directory of the host. Other commands are refused. Like SFTP, clients are refused while the session
+// is read-only, and transfers in progress aren't stopped when it turns read-only.
+type execHandler struct {
+	// compression compresses transfers of clients agreeing to it if it's non-zero.
+	compression  zstd.EncoderLevel
+	readonly     *ReadOnly
+	eventEmmiter *emitter.Emitter
+	logger       log.FieldLogger
+	// approval keeps clients waiting until they are approved if it's non-nil.
+	approval          *Approval
+	keepAliveDuration time.Duration
+}
+
+func (h *execHandler) HandleExec(sess gssh.Session) {
+	args := sess.Command()
+	logger := h.logger.WithField("command", strings.Join(args, " "))
+
+	if !isAllowedExecCommand(args) {
+		logger.Info("refused command")
+		_, _ = io.WriteString(sess.Stderr(), "Command is not allowed. Only scp and rsync can be run without a PTY.\n")
+		_ = sess.Exit(1)
+		return
+	}
+	if h.readonly.Get() {
+		_, _ = io.WriteString(sess.Stderr(), "File transfers are not allowed in read-only sessions.\n")
+		_ = sess.Exit(1)
+		return
+	}
+
+	if h.approval != nil {
+		// the waiting state isn't shown, since the output of the command is the protocol of the tool
+		if err := h.approval.Wait(sess.Context(), sess, nil, h.keepAliveDuration, h.eventEmmiter, h.logger); err != nil {
+			_, _ = io.WriteString(sess.Stderr(), err.Error()+"\n")
+			_ = sess.Exit(1)
+			return
+		}
+	}
+
+	// stdout and stdin are compressed, while stderr is shown to the client as is
+	var rw io.ReadWriter = sess
+	var cs *api.CompressedStream
+	if h.compression != 0 && featuresFromContext(sess.Context()).compressTransfers {
+		var err error
+		cs, err = api.NewCompressedStream(sess, h.compression)
+		if err != nil {
+			logger.WithError(err).Error("error compressing command")
+			_ = sess.Exit(1)
+			return
+		}
+		rw = cs
+	}
+
+	cmd := exec.CommandContext(sess.Context(), args[0], args[1:]...)
+	cmd.Stdout = rw
+	cmd.Stderr = sess.Stderr()
+	// copy stdin without waiting for it when the command exits, since clients may not close it
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.WithError(err).Error("error piping stdin")
+		_ = sess.Exit(1)
+		return
+	}
+
+	logger.Info("running command")
+	if err := cmd.Start(); err != nil {
+		logger.WithError(err).Error("error starting command")
+		_, _ = io.WriteString(sess.Stderr(), "Error starting command: "+err.Error()+"\n")
+		_ = sess.Exit(127)
+		return
+	}
+	go func() {
+		_, _ = io.Copy(stdin, rw)
+		_ = stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	if cs != nil {
+		// end the compressed output before the exit status
+		if err := cs.CloseWrite(); err != nil {
+			logger.WithError(err).Debug("error closing compressed output")
+		}
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		_ = sess.Exit(0)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		_ = sess.Exit(exitErr.ExitCode())
+	default:
+		logger.WithError(err).Debug("error running command")
+		_ = sess.Exit(1)
+	}
+}
+
+// scpServerFlags are the flags scp passes to the server side: -t or -f, and -d, -p, -r, and -v.
+const scpServerFlags = "tfdprv"
+
+// isAllowedExecCommand reports whether args run the server side of scp or rsync, as they are exec'ed by
+// scp and rsync -e ssh on the client.
+func isAllowedExecCommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	switch args[0] {
+	case "scp":
+		// -t receives and -f sends files. Only the options scp passes to the server side are allowed,
+		// so that clients can't make it run programs, e.g. with -S.
+		var transfer bool
+		for _, arg := range args[1:] {
+			if arg == "--" || !strings.HasPrefix(arg, "-") {
+				break
+			}
+			if strings.Trim(arg[1:], scpServerFlags) != "" {
+				return false
+			}
+			transfer = transfer || strings.ContainsAny(arg, "tf")
+		}
+		return transfer
+	case "rsync":
+		// the server side of transfers, not of the rsync daemon
+		return args[1] == "--server" && !slices.Contains(args, "--daemon")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,30 @@
+package internal
+
+import "testing"
+
+func Test_isAllowedExecCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"scp", "-t", "--", "dir"}, true},
+		{[]string{"scp", "-v", "-r", "-p", "-d", "-t", "--", "dir"}, true},
+		{[]string{"scp", "-f", "file"}, true},
+		{[]string{"scp", "-pf", "file"}, true},
+		{[]string{"rsync", "--server", "-vlogDtpre.iLsfxCIvu", ".", "dir"}, true},
+		{[]string{"rsync", "--server", "--sender", "-vlogDtpre.iLsfxCIvu", ".", "dir"}, true},
+		// other commands and the client sides of the tools
+		{[]string{"echo", "hello"}, false},
+		{[]string{"scp"}, false},
+		{[]string{"scp", "file", "host:file"}, false},
+		{[]string{"scp", "-S", "/tmp/evil", "-t", "dir"}, false},
+		{[]string{"scp", "-t", "-o", "ProxyCommand=evil", "dir"}, false},
+		{[]string{"rsync", "file", "host:file"}, false},
+		{[]string{"rsync", "--server", "--daemon", "."}, false},
+		{[]string{"/usr/bin/scp", "-t", "dir"}, false},
+	} {
+		if got := isAllowedExecCommand(tc.args); got != tc.want {
+			t.Errorf("%q: want=%t got=%t", tc.args, tc.want, got)
+		}
+	}
+}
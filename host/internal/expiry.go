@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+// writeExpiryBanners writes a banner to w when the session is about to reach the max session age
+// of the server or its idle timeout until ctx is done.
+func writeExpiryBanners(ctx context.Context, eventEmitter *emitter.Emitter, w io.Writer) error {
+	ch := events.On(eventEmitter, events.KindSessionExpiring)
+	defer events.Off(eventEmitter, events.KindSessionExpiring, ch)
+	idle := events.On(eventEmitter, events.KindSessionIdle)
+	defer events.Off(eventEmitter, events.KindSessionIdle, idle)
+
+	for {
+		var banner string
+		select {
+		case evt := <-ch:
+			e, ok := events.From(evt).(events.SessionExpiring)
+			if !ok {
+				continue
+			}
+
+			banner = fmt.Sprintf("\r\n=== The session ends at %s by server policy ===\r\n", e.ExpiresAt.Local().Format(time.Kitchen))
+		case evt := <-idle:
+			e, ok := events.From(evt).(events.SessionIdle)
+			if !ok {
+				continue
+			}
+
+			banner = fmt.Sprintf("\r\n=== The session ends at %s for inactivity unless someone types ===\r\n", e.EndsAt.Local().Format(time.Kitchen))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if _, err := io.WriteString(w, banner); err != nil {
+			return err
+		}
+	}
+}
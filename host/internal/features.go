@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+// minKeepAlive is the shortest keepalive interval clients can negotiate.
+const minKeepAlive = 5 * time.Second
+
+var contextKeyFeatures = &contextKey{"features"}
+
+// sessionFeatures are the features agreed with a client.
+type sessionFeatures struct {
+	keepAlive    time.Duration
+	latencyProbe bool
+	// compressTransfers is whether file transfers over SFTP and exec channels are compressed with zstd.
+	compressTransfers bool
+	// viewOnly is whether the client only watches the session, so that its input and window sizes are ignored.
+	viewOnly bool
+}
+
+func featuresFromContext(ctx gssh.Context) sessionFeatures {
+	if f, ok := ctx.Value(contextKeyFeatures).(sessionFeatures); ok {
+		return f
+	}
+
+	return sessionFeatures{}
+}
+
+// featureNegotiator agrees to the features proposed by clients when they join.
+// Unknown features and malformed values are ignored, so that clients of newer versions can join.
+type featureNegotiator struct {
+	keepAlive time.Duration
+	// recorded is whether the session is recorded, which is announced to clients displaying recording notices.
+	recorded bool
+	// compressTransfers is whether the host compresses file transfers of clients supporting zstd.
+	compressTransfers bool
+	// viewers is whether the host lets clients only watching the session join, e.g. over the telnet bridge.
+	viewers bool
+	logger  log.FieldLogger
+}
+
+func (n featureNegotiator) Negotiate(proposed map[string]string) (map[string]string, sessionFeatures) {
+	var (
+		agreed = make(map[string]string)
+		f      sessionFeatures
+	)
+
+	for name, value := range proposed {
+		switch name {
+		case api.FeatureKeepAlive:
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				continue
+			}
+			f.keepAlive = max(min(d, n.keepAlive), minKeepAlive)
+			agreed[name] = f.keepAlive.String()
+		case api.FeatureLatencyProbe:
+			if ok, _ := strconv.ParseBool(value); ok {
+				f.latencyProbe = true
+				agreed[name] = strconv.FormatBool(true)
+			}
+		case api.FeatureRecordingNotice:
+			if ok, _ := strconv.ParseBool(value); ok {
+				agreed[name] = strconv.FormatBool(n.recorded)
+			}
+		case api.FeatureViewOnly:
+			if ok, _ := strconv.ParseBool(value); ok {
+				f.viewOnly = n.viewers
+				agreed[name] = strconv.FormatBool(n.viewers)
+			}
+		case api.FeatureCompression:
+			// only file transfers are compressed, the terminal stays uncompressed to keep it snappy
+			if n.compressTransfers && slices.ContainsFunc(strings.Split(value, ","), func(alg string) bool {
+				return strings.TrimSpace(alg) == api.CompressionZstd
+			}) {
+				f.compressTransfers = true
+				agreed[name] = api.CompressionZstd
+			}
+		}
+	}
+
+	return agreed, f
+}
+
+// HandleFeaturesRequest handles the features request of a client. It must be sent before a session is opened.
+func (n featureNegotiator) HandleFeaturesRequest(ctx gssh.Context, srv *gssh.Server, req *ssh.Request) (bool, []byte) {
+	var proposed api.Features
+	if err := proto.Unmarshal(req.Payload, &proposed); err != nil {
+		n.logger.WithError(err).Debug("error decoding features")
+		return false, nil
+	}
+
+	agreed, f := n.Negotiate(proposed.Features)
+	ctx.SetValue(contextKeyFeatures, f)
+
+	b, err := proto.Marshal(&api.Features{Features: agreed})
+	if err != nil {
+		return false, nil
+	}
+
+	n.logger.WithField("features", agreed).Debug("negotiated features")
+	return true, b
+}
+
+// handlePingRequest echoes the payload of ping requests of clients that agreed to latency probes.
+func handlePingRequest(ctx gssh.Context, srv *gssh.Server, req *ssh.Request) (bool, []byte) {
+	if !featuresFromContext(ctx).latencyProbe {
+		return false, nil
+	}
+
+	return true, req.Payload
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_featureNegotiator(t *testing.T) {
+	n := featureNegotiator{keepAlive: 30 * time.Second, logger: log.New()}
+
+	cases := []struct {
+		name     string
+		proposed map[string]string
+		want     map[string]string
+		wantF    sessionFeatures
+	}{
+		{
+			name:     "keepalive",
+			proposed: map[string]string{api.FeatureKeepAlive: "10s"},
+			want:     map[string]string{api.FeatureKeepAlive: "10s"},
+			wantF:    sessionFeatures{keepAlive: 10 * time.Second},
+		},
+		{
+			name:     "keepalive longer than the host's",
+			proposed: map[string]string{api.FeatureKeepAlive: "5m"},
+			want:     map[string]string{api.FeatureKeepAlive: "30s"},
+			wantF:    sessionFeatures{keepAlive: 30 * time.Second},
+		},
+		{
+			name:     "malformed keepalive",
+			proposed: map[string]string{api.FeatureKeepAlive: "soon"},
+			want:     map[string]string{},
+		},
+		{
+			name:     "latency probe and recording notice",
+			proposed: map[string]string{api.FeatureLatencyProbe: "true", api.FeatureRecordingNotice: "true"},
+			want:     map[string]string{api.FeatureLatencyProbe: "true", api.FeatureRecordingNotice: "false"},
+			wantF:    sessionFeatures{latencyProbe: true},
+		},
+		{
+			name:     "view only without viewers",
+			proposed: map[string]string{api.FeatureViewOnly: "true"},
+			want:     map[string]string{api.FeatureViewOnly: "false"},
+		},
+		{
+			name:     "unsupported and unknown",
+			proposed: map[string]string{api.FeatureCompression: "zstd,gzip", "teleport": "true"},
+			want:     map[string]string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, f := n.Negotiate(c.proposed)
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+			if f != c.wantF {
+				t.Fatalf("want features %+v, got %+v", c.wantF, f)
+			}
+		})
+	}
+}
+
+func Test_featureNegotiator_ViewOnly(t *testing.T) {
+	n := featureNegotiator{keepAlive: 30 * time.Second, viewers: true, logger: log.New()}
+
+	got, f := n.Negotiate(map[string]string{api.FeatureViewOnly: "true"})
+	if diff := cmp.Diff(map[string]string{api.FeatureViewOnly: "true"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if !f.viewOnly {
+		t.Fatal("want the client to only view the session")
+	}
+
+	if _, f := n.Negotiate(map[string]string{api.FeatureViewOnly: "false"}); f.viewOnly {
+		t.Fatal("want clients not proposing view-only to interact")
+	}
+}
+
+func Test_featureNegotiator_Compression(t *testing.T) {
+	n := featureNegotiator{compressTransfers: true, logger: log.New()}
+
+	got, f := n.Negotiate(map[string]string{api.FeatureCompression: "gzip, zstd"})
+	if diff := cmp.Diff(map[string]string{api.FeatureCompression: api.CompressionZstd}, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if !f.compressTransfers {
+		t.Fatal("expect transfers compressed")
+	}
+
+	got, f = n.Negotiate(map[string]string{api.FeatureCompression: "gzip"})
+	if len(got) != 0 || f.compressTransfers {
+		t.Fatalf("expect compression declined without zstd, got %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/upterm"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ghostPings is how many keepalives are sent to a client within the eviction timeout, so that a live client
+// answers before it's evicted even if a few replies are slow.
+const ghostPings = 3
+
+// ghostEvictor disconnects ghost clients, whose connections are half dead, e.g. dropped by a NAT or left behind by a
+// crashed terminal. Such connections never close on their own, so the clients would linger in the connected
+// clients. Clients are pinged, and those sending nothing for the timeout, not even a reply, are evicted.
+type ghostEvictor struct {
+	after  time.Duration
+	logger log.FieldLogger
+}
+
+// ConnCallback watches the connection of a client until it's closed.
+func (e *ghostEvictor) ConnCallback(ctx gssh.Context, conn net.Conn) net.Conn {
+	ac := &activityConn{Conn: conn}
+	ac.touch(time.Now())
+
+	go e.watch(ctx, ac)
+
+	return ac
+}
+
+func (e *ghostEvictor) watch(ctx gssh.Context, conn *activityConn) {
+	ticker := time.NewTicker(e.after / ghostPings)
+	defer ticker.Stop()
+
+	var pinging atomic.Bool
+	for {
+		select {
+		case now := <-ticker.C:
+			if silent := now.Sub(conn.LastRead()); silent >= e.after {
+				logger := e.logger
+				if c, ok := ctx.Value(contextKeyClient).(*api.Client); ok {
+					logger = logger.WithField("client", identity.Describe(c.DisplayName, c.PublicKeyFingerprint))
+				}
+				logger.WithField("silent", silent.Round(time.Millisecond)).Warn("evicting unresponsive client")
+
+				_ = conn.Close()
+				return
+			}
+
+			// the ssh connection is set once the handshake completes
+			sshConn, ok := ctx.Value(gssh.ContextKeyConn).(ssh.Conn)
+			if !ok || !pinging.CompareAndSwap(false, true) {
+				continue
+			}
+			go func() {
+				defer pinging.Store(false)
+
+				// any reply counts, since clients refuse requests they don't know
+				if _, _, err := sshConn.SendRequest(upterm.OpenSSHKeepAliveRequestType, true, nil); err != nil {
+					e.logger.WithError(err).Debug("error pinging client to keepalive")
+				}
+			}()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// activityConn records when bytes are last read from a client.
+type activityConn struct {
+	net.Conn
+	lastRead atomic.Int64
+}
+
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch(time.Now())
+	}
+
+	return n, err
+}
+
+func (c *activityConn) touch(t time.Time) {
+	c.lastRead.Store(t.UnixNano())
+}
+
+// LastRead returns when bytes are last read.
+func (c *activityConn) LastRead() time.Time {
+	return time.Unix(0, c.lastRead.Load())
+}
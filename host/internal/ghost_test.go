@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// frozenConn stops reading once it's frozen, like a client whose connection is half dead.
+type frozenConn struct {
+	net.Conn
+	frozen chan struct{}
+	thawed chan struct{}
+}
+
+func (c *frozenConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.frozen:
+		<-c.thawed
+	default:
+	}
+
+	return c.Conn.Read(b)
+}
+
+func Test_ghostEvictor(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evictAfter := 300 * time.Millisecond
+	ge := ghostEvictor{
+		after:  evictAfter,
+		logger: log.New(),
+	}
+	srv := &gssh.Server{
+		Handler:      func(s gssh.Session) {},
+		ConnCallback: ge.ConnCallback,
+	}
+	srv.AddHostKey(newTestSigner(t))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	defer srv.Close()
+
+	dial := func(t *testing.T) (*ssh.Client, *frozenConn) {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fc := &frozenConn{Conn: conn, frozen: make(chan struct{}), thawed: make(chan struct{})}
+
+		c, chans, reqs, err := ssh.NewClientConn(fc, ln.Addr().String(), &ssh.ClientConfig{
+			User:            "client",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := ssh.NewClient(c, chans, reqs)
+		t.Cleanup(func() {
+			client.Close()
+		})
+
+		return client, fc
+	}
+
+	t.Run("live client", func(t *testing.T) {
+		client, _ := dial(t)
+
+		// the client replies to keepalives
+		time.Sleep(2 * evictAfter)
+		if _, _, err := client.SendRequest("ping", true, nil); err != nil {
+			t.Fatalf("want live client connected, got %s", err)
+		}
+	})
+
+	t.Run("ghost client", func(t *testing.T) {
+		client, fc := dial(t)
+
+		close(fc.frozen)
+		time.Sleep(2 * evictAfter)
+		close(fc.thawed)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Wait()
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("want ghost client evicted")
+		}
+	})
+}
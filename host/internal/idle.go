@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+// errClientIdle is returned when a client is disconnected for not typing within the idle timeout.
+var errClientIdle = errors.New("client is idle")
+
+func newIdleTimer(timeout time.Duration, now time.Time) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	t.lastActive.Store(now.UnixNano())
+
+	return t
+}
+
+// idleTimer disconnects a client that hasn't typed for the timeout.
+// Input counts as activity even if it's discarded in read-only sessions.
+type idleTimer struct {
+	timeout    time.Duration
+	lastActive atomic.Int64
+}
+
+// Reader records reading input from r as activity.
+func (t *idleTimer) Reader(r io.Reader) io.Reader {
+	return &activityReader{r: r, t: t}
+}
+
+// Idle returns how long the client has been idle as of now.
+func (t *idleTimer) Idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, t.lastActive.Load()))
+}
+
+// Wait writes a banner to w and returns errClientIdle once the client is idle for the timeout,
+// or returns when ctx is done.
+func (t *idleTimer) Wait(ctx context.Context, w io.Writer) error {
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case now := <-timer.C:
+			idle := t.Idle(now)
+			if idle < t.timeout {
+				timer.Reset(t.timeout - idle)
+				continue
+			}
+
+			banner := fmt.Sprintf("\r\n=== Disconnected after being idle for %s ===\r\n", t.timeout)
+			_, _ = io.WriteString(w, banner)
+			return errClientIdle
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type activityReader struct {
+	r io.Reader
+	t *idleTimer
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.lastActive.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+// sessionIdleWarning is how long before the idle timeout of a session the host and clients are warned.
+// Timeouts shorter than twice of it are warned halfway.
+const sessionIdleWarning = time.Minute
+
+// NewSessionIdle returns a SessionIdle with the timeout, idle since now.
+func NewSessionIdle(timeout time.Duration, now time.Time) *SessionIdle {
+	warning := sessionIdleWarning
+	if timeout < 2*warning {
+		warning = timeout / 2
+	}
+
+	return &SessionIdle{
+		timer:   newIdleTimer(timeout, now),
+		warning: warning,
+	}
+}
+
+// SessionIdle ends a session when neither the host nor clients type for the timeout.
+type SessionIdle struct {
+	timer   *idleTimer
+	warning time.Duration
+}
+
+// Reader records reading input from r as activity. It returns r if s is nil.
+func (s *SessionIdle) Reader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+
+	return s.timer.Reader(r)
+}
+
+// Wait emits events.SessionIdle before the timeout and returns nil once the session is idle for the timeout,
+// or returns when ctx is done.
+func (s *SessionIdle) Wait(ctx context.Context, eventEmitter *emitter.Emitter) error {
+	timeout := s.timer.timeout
+	timer := time.NewTimer(timeout - s.warning)
+	defer timer.Stop()
+
+	var warned bool
+	for {
+		select {
+		case now := <-timer.C:
+			idle := s.timer.Idle(now)
+			switch {
+			case idle >= timeout:
+				return nil
+			case idle >= timeout-s.warning:
+				if !warned {
+					events.Emit(eventEmitter, events.SessionIdle{EndsAt: now.Add(timeout - idle)})
+					warned = true
+				}
+				timer.Reset(timeout - idle)
+			default:
+				// warn again if the session is idle again after someone types
+				warned = false
+				timer.Reset(timeout - s.warning - idle)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+func Test_idleTimer(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	idle := newIdleTimer(timeout, time.Now())
+
+	// typing keeps the client connected
+	r := idle.Reader(strings.NewReader("a"))
+	time.Sleep(timeout / 2)
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if d := idle.Idle(time.Now()); d >= timeout/2 {
+		t.Fatalf("want input to reset idle time, got %s", d)
+	}
+
+	var out bytes.Buffer
+	start := time.Now()
+	if err := idle.Wait(context.Background(), &out); !errors.Is(err, errClientIdle) {
+		t.Fatalf("want client idle, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout/2 {
+		t.Fatalf("client disconnected too early after %s", elapsed)
+	}
+	if !strings.Contains(out.String(), "Disconnected after being idle") {
+		t.Fatalf("want idle banner, got %q", out.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newIdleTimer(time.Hour, time.Now()).Wait(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context canceled, got %v", err)
+	}
+}
+
+func Test_SessionIdle(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	idle := NewSessionIdle(timeout, time.Now())
+
+	em := emitter.New(1)
+	ch := events.On(em, events.KindSessionIdle)
+	defer events.Off(em, events.KindSessionIdle, ch)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- idle.Wait(context.Background(), em)
+	}()
+
+	waitWarning := func() {
+		t.Helper()
+
+		select {
+		case evt := <-ch:
+			if _, ok := events.From(evt).(events.SessionIdle); !ok {
+				t.Fatalf("want session idle event, got %v", evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("want the session idle warned")
+		}
+	}
+
+	// the warning is repeated after typing
+	waitWarning()
+	typed := time.Now()
+	if _, err := io.ReadAll(idle.Reader(strings.NewReader("a"))); err != nil {
+		t.Fatal(err)
+	}
+	waitWarning()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(typed); elapsed < timeout {
+			t.Fatalf("session ended too early after %s", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want the idle session ended")
+	}
+
+	var nilIdle *SessionIdle
+	r := strings.NewReader("a")
+	if got := nilIdle.Reader(r); got != r {
+		t.Fatal("want input read as is without a session idle timeout")
+	}
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// joinTokenBytes is the entropy of a join token.
+const joinTokenBytes = 10
+
+var joinTokenEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewJoinTokens returns an empty store of join tokens.
+func NewJoinTokens() *JoinTokens {
+	return &JoinTokens{tokens: make(map[string]joinToken)}
+}
+
+type joinToken struct {
+	expiresAt time.Time
+	// invite tokens let clients with keys that aren't authorized join.
+	invite bool
+}
+
+// JoinTokens are one-time tokens the host shares to join a session, e.g. in a QR code.
+// A client joining with a token redeems it. A token can't be redeemed again or after it expires.
+type JoinTokens struct {
+	mu     sync.Mutex
+	tokens map[string]joinToken
+	// register registers invite tokens with the server, which refuses clients with keys that aren't authorized
+	// unless they carry one.
+	register func(token string, expiresAt time.Time) error
+}
+
+// Issue issues a token expiring after ttl. The token only lets clients with authorized keys join.
+func (t *JoinTokens) Issue(ttl time.Duration, now time.Time) (string, time.Time, error) {
+	return t.issue(ttl, now, false)
+}
+
+// Invite issues a token expiring after ttl that lets a client with any key join, e.g. in a share link.
+// The token is registered with the server of the session, so it fails until the session is established.
+func (t *JoinTokens) Invite(ttl time.Duration, now time.Time) (string, time.Time, error) {
+	t.mu.Lock()
+	register := t.register
+	t.mu.Unlock()
+	if register == nil {
+		return "", time.Time{}, fmt.Errorf("session isn't established")
+	}
+
+	token, expiresAt, err := t.issue(ttl, now, true)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := register(token, expiresAt); err != nil {
+		t.mu.Lock()
+		delete(t.tokens, token)
+		t.mu.Unlock()
+
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// SetRegistrar sets the function registering invite tokens with the server of the session.
+func (t *JoinTokens) SetRegistrar(register func(token string, expiresAt time.Time) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.register = register
+}
+
+func (t *JoinTokens) issue(ttl time.Duration, now time.Time, invite bool) (string, time.Time, error) {
+	b := make([]byte, joinTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+
+	// usernames of mobile SSH clients may be case-insensitive
+	token := strings.ToLower(joinTokenEncoding.EncodeToString(b))
+	expiresAt := now.Add(ttl)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeExpiredLocked(now)
+	t.tokens[token] = joinToken{expiresAt: expiresAt, invite: invite}
+
+	return token, expiresAt, nil
+}
+
+// Redeem consumes the token. It reports whether the token was issued and hasn't expired or been redeemed.
+// It's safe to call on a nil store, which has no tokens.
+func (t *JoinTokens) Redeem(token string, now time.Time) bool {
+	return t.redeem(token, now, false)
+}
+
+// RedeemInvite consumes the token if it's an invite. It reports whether the token was invited and hasn't
+// expired or been redeemed. Other tokens are left for clients with authorized keys.
+// It's safe to call on a nil store, which has no tokens.
+func (t *JoinTokens) RedeemInvite(token string, now time.Time) bool {
+	return t.redeem(token, now, true)
+}
+
+func (t *JoinTokens) redeem(token string, now time.Time, invite bool) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jt, ok := t.tokens[token]
+	if !ok || (invite && !jt.invite) {
+		return false
+	}
+	delete(t.tokens, token)
+
+	return now.Before(jt.expiresAt)
+}
+
+func (t *JoinTokens) removeExpiredLocked(now time.Time) {
+	for token, jt := range t.tokens {
+		if !now.Before(jt.expiresAt) {
+			delete(t.tokens, token)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/routing"
+)
+
+func Test_JoinTokens(t *testing.T) {
+	now := time.Now()
+	tokens := NewJoinTokens()
+
+	token, expiresAt, err := tokens.Issue(time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(time.Minute); !expiresAt.Equal(want) {
+		t.Fatalf("want expiry %s, got %s", want, expiresAt)
+	}
+
+	// tokens must survive being appended to usernames
+	if _, got := routing.SplitToken(routing.WithToken("session.MTI3LjAuMC4xOjIyMjI", token)); got != token {
+		t.Fatalf("token %q doesn't round trip in a username, got %q", token, got)
+	}
+
+	if tokens.Redeem("unknown", now) {
+		t.Fatal("unknown token must not be redeemed")
+	}
+	if !tokens.Redeem(token, now.Add(30*time.Second)) {
+		t.Fatal("token must be redeemed")
+	}
+	if tokens.Redeem(token, now.Add(30*time.Second)) {
+		t.Fatal("token must not be redeemed twice")
+	}
+
+	expired, _, err := tokens.Issue(time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Redeem(expired, now.Add(time.Minute)) {
+		t.Fatal("expired token must not be redeemed")
+	}
+
+	var nilTokens *JoinTokens
+	if nilTokens.Redeem(token, now) {
+		t.Fatal("nil store must not redeem tokens")
+	}
+}
+
+func Test_JoinTokens_Invite(t *testing.T) {
+	now := time.Now()
+	tokens := NewJoinTokens()
+
+	if _, _, err := tokens.Invite(time.Minute, now); err == nil {
+		t.Fatal("want error inviting before the session is established")
+	}
+
+	var registered []string
+	tokens.SetRegistrar(func(token string, expiresAt time.Time) error {
+		if want := now.Add(time.Minute); !expiresAt.Equal(want) {
+			t.Fatalf("want expiry %s, got %s", want, expiresAt)
+		}
+		registered = append(registered, token)
+		return nil
+	})
+
+	invite, _, err := tokens.Invite(time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 1 || registered[0] != invite {
+		t.Fatalf("want invite %q registered, got %v", invite, registered)
+	}
+
+	token, _, err := tokens.Issue(time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.RedeemInvite(token, now) {
+		t.Fatal("token that isn't an invite must not be redeemed as one")
+	}
+	if !tokens.Redeem(token, now) {
+		t.Fatal("token must survive being refused as an invite")
+	}
+
+	if !tokens.RedeemInvite(invite, now) {
+		t.Fatal("invite must be redeemed")
+	}
+	if tokens.Redeem(invite, now) {
+		t.Fatal("invite must not be redeemed twice")
+	}
+
+	var refused string
+	tokens.SetRegistrar(func(token string, expiresAt time.Time) error {
+		refused = token
+		return fmt.Errorf("refused")
+	})
+	if _, _, err := tokens.Invite(time.Minute, now); err == nil {
+		t.Fatal("want error if the server refuses the invite")
+	}
+	if tokens.RedeemInvite(refused, now) {
+		t.Fatal("invite refused by the server must not be redeemed")
+	}
+}
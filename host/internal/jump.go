@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialJumpHosts connects to addr through the jump hosts, each reached through the previous one, like ProxyJump of OpenSSH.
+// config returns the client config of a jump host for its user. It returns the connection to addr and the clients of the
+// jump hosts, which are closed after the connection in reverse order.
+func dialJumpHosts(jumps []*url.URL, addr string, config func(user string) *ssh.ClientConfig) (net.Conn, []*ssh.Client, error) {
+	var (
+		clients []*ssh.Client
+		dial    = net.Dial
+	)
+	for _, jump := range jumps {
+		conn, err := dial("tcp", jump.Host)
+		if err != nil {
+			closeJumpClients(clients)
+			return nil, nil, fmt.Errorf("error connecting to jump host %s: %w", jump.Host, err)
+		}
+
+		cc, chans, reqs, err := ssh.NewClientConn(conn, jump.Host, config(jump.User.Username()))
+		if err != nil {
+			conn.Close()
+			closeJumpClients(clients)
+			return nil, nil, fmt.Errorf("jump host %s: %w", jump.Host, sshDialError(jump.Host, err))
+		}
+
+		client := ssh.NewClient(cc, chans, reqs)
+		clients = append(clients, client)
+		dial = client.Dial
+	}
+
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		closeJumpClients(clients)
+		return nil, nil, fmt.Errorf("error connecting to %s through jump hosts: %w", addr, err)
+	}
+
+	return conn, clients, nil
+}
+
+func closeJumpClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	gssh "github.com/charmbracelet/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// startJumpHost starts an SSH server forwarding connections like a bastion.
+// It records the users of the connections in users.
+func startJumpHost(t *testing.T, authorized ssh.PublicKey, users chan<- string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &gssh.Server{
+		PublicKeyHandler: func(ctx gssh.Context, key gssh.PublicKey) bool {
+			return gssh.KeysEqual(key, authorized)
+		},
+		LocalPortForwardingCallback: func(ctx gssh.Context, destinationHost string, destinationPort uint32) bool {
+			users <- ctx.User()
+			return true
+		},
+		ChannelHandlers: map[string]gssh.ChannelHandler{
+			"direct-tcpip": gssh.DirectTCPIPHandler,
+		},
+	}
+	srv.AddHostKey(newTestSigner(t))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+func Test_dialJumpHosts(t *testing.T) {
+	// the target echoes a line back
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	signer := newTestSigner(t)
+	users := make(chan string, 2)
+	jumps := []*url.URL{
+		{Scheme: "ssh", User: url.User("alice"), Host: startJumpHost(t, signer.PublicKey(), users)},
+		{Scheme: "ssh", Host: startJumpHost(t, signer.PublicKey(), users)},
+	}
+
+	conn, clients, err := dialJumpHosts(jumps, target.Addr().String(), func(user string) *ssh.ClientConfig {
+		if user == "" {
+			user = "default"
+		}
+
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(clients); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "hello\n", string(buf); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the first hop forwards to the second, which forwards to the target
+	for _, want := range []string{"alice", "default"} {
+		if got := <-users; want != got {
+			t.Fatalf("want=%s got=%s", want, got)
+		}
+	}
+
+	conn.Close()
+	closeJumpClients(clients)
+	wg.Wait()
+
+	// jump hosts reject unknown keys
+	_, _, err = dialJumpHosts(jumps[:1], target.Addr().String(), func(user string) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "jump host "+jumps[0].Host) {
+		t.Fatalf("want error of the jump host, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClientNotFound is returned when kicking a client that isn't connected.
+var ErrClientNotFound = errors.New("client not found")
+
+// errClientConnecting is returned when kicking a client whose connection hasn't completed the handshake yet.
+var errClientConnecting = errors.New("client is still connecting, try again")
+
+// NewClientConns returns an empty set of client connections.
+func NewClientConns() *ClientConns {
+	return &ClientConns{conns: make(map[string]func() error)}
+}
+
+// ClientConns are the connections of the clients of a session by client ID, so that the host can kick clients.
+type ClientConns struct {
+	mu    sync.Mutex
+	conns map[string]func() error
+}
+
+// add registers the function closing the connection of a client. It's a no-op on a nil set.
+func (c *ClientConns) add(id string, close func() error) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conns[id] = close
+}
+
+// remove forgets the connection of a client once it's closed. It's a no-op on a nil set.
+func (c *ClientConns) remove(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.conns, id)
+}
+
+// Kick closes the connection of the client with the ID, which leaves the session as if it disconnected.
+// It returns ErrClientNotFound if the client isn't connected.
+func (c *ClientConns) Kick(id string) error {
+	if c == nil {
+		return ErrClientNotFound
+	}
+
+	c.mu.Lock()
+	close, ok := c.conns[id]
+	c.mu.Unlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+
+	return close()
+}
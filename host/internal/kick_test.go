@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ClientConns(t *testing.T) {
+	conns := NewClientConns()
+
+	var closed int
+	conns.add("a", func() error {
+		closed++
+		return nil
+	})
+
+	if err := conns.Kick("b"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("want client not found, got %v", err)
+	}
+	if err := conns.Kick("a"); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 1 {
+		t.Fatalf("want the connection closed once, got %d", closed)
+	}
+
+	conns.remove("a")
+	if err := conns.Kick("a"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("want a removed client not found, got %v", err)
+	}
+
+	var nilConns *ClientConns
+	nilConns.add("a", func() error { return nil })
+	if err := nilConns.Kick("a"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("want no clients without conns, got %v", err)
+	}
+}
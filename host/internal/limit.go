@@ -0,0 +1,45 @@
+package internal
+
+import "sync"
+
+// newClientLimit returns a limit of max clients attached to the session at once. It's unlimited if max isn't positive.
+func newClientLimit(max int) *clientLimit {
+	if max <= 0 {
+		return nil
+	}
+
+	return &clientLimit{max: max}
+}
+
+// clientLimit caps the clients attached to the session at once. A nil limit is unlimited.
+type clientLimit struct {
+	max int
+
+	mu sync.Mutex
+	n  int
+}
+
+// Acquire takes a slot for a client. It reports false if the session is full.
+// The slot is freed by calling release.
+func (l *clientLimit) Acquire() (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.n >= l.max {
+		return nil, false
+	}
+	l.n++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.n--
+			l.mu.Unlock()
+		})
+	}, true
+}
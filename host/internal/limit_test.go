@@ -0,0 +1,37 @@
+package internal
+
+import "testing"
+
+func Test_clientLimit(t *testing.T) {
+	if l := newClientLimit(0); l != nil {
+		t.Fatal("want no limit without a positive max")
+	}
+	var unlimited *clientLimit
+	for i := 0; i < 3; i++ {
+		if _, ok := unlimited.Acquire(); !ok {
+			t.Fatal("want clients of an unlimited session acquiring slots")
+		}
+	}
+
+	l := newClientLimit(2)
+	release1, ok := l.Acquire()
+	if !ok {
+		t.Fatal("want first slot acquired")
+	}
+	if _, ok := l.Acquire(); !ok {
+		t.Fatal("want second slot acquired")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("want third client refused")
+	}
+
+	release1()
+	// releasing twice frees one slot only
+	release1()
+	if _, ok := l.Acquire(); !ok {
+		t.Fatal("want a released slot acquired")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("want a client refused after a slot is released twice")
+	}
+}
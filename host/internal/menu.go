@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/owenthereal/upterm/host/api"
+)
+
+var errMenuQuit = errors.New("client quit the menu")
+
+// chooseMenuItem renders the menu to the client terminal and reads the choice of the client.
+// The client terminal is in raw mode, so the choice is echoed back as it is typed.
+// It returns errMenuQuit if the client quits.
+func chooseMenuItem(rw io.ReadWriter, items []*api.MenuItem) (*api.MenuItem, error) {
+	var b strings.Builder
+	b.WriteString("\r\n=== Choose a command ===\r\n")
+	for i, item := range items {
+		fmt.Fprintf(&b, "  %d) %s: %s\r\n", i+1, item.Name, strings.Join(item.Command, " "))
+	}
+	b.WriteString("  q) Quit\r\n")
+	if _, err := io.WriteString(rw, b.String()); err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, err := io.WriteString(rw, "\r\nEnter a number: "); err != nil {
+			return nil, err
+		}
+
+		choice, err := readMenuChoice(rw)
+		if err != nil {
+			return nil, err
+		}
+
+		if choice == "q" {
+			return nil, errMenuQuit
+		}
+
+		n, err := strconv.Atoi(choice)
+		if err == nil && n >= 1 && n <= len(items) {
+			_, err := io.WriteString(rw, "\r\n")
+			return items[n-1], err
+		}
+
+		if _, err := fmt.Fprintf(rw, "\r\nInvalid choice %q", choice); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readMenuChoice(rw io.ReadWriter) (string, error) {
+	var (
+		line []byte
+		buf  = make([]byte, 1)
+	)
+	for {
+		if _, err := rw.Read(buf); err != nil {
+			if err == io.EOF {
+				return "", errMenuQuit
+			}
+			return "", err
+		}
+
+		switch c := buf[0]; c {
+		case '\r', '\n':
+			return strings.TrimSpace(string(line)), nil
+		case 0x03, 0x04: // Ctrl-C, Ctrl-D
+			return "", errMenuQuit
+		case 0x7f, 0x08: // backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				if _, err := io.WriteString(rw, "\b \b"); err != nil {
+					return "", err
+				}
+			}
+		default:
+			if c < 0x20 {
+				continue
+			}
+			line = append(line, c)
+			if _, err := rw.Write(buf); err != nil {
+				return "", err
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/owenthereal/upterm/host/api"
+)
+
+type fakeTerminal struct {
+	in  *strings.Reader
+	out bytes.Buffer
+}
+
+func (t *fakeTerminal) Read(p []byte) (int, error) {
+	return t.in.Read(p)
+}
+
+func (t *fakeTerminal) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func Test_chooseMenuItem(t *testing.T) {
+	items := []*api.MenuItem{
+		{Name: "logs", Command: []string{"tail", "-f", "log"}},
+		{Name: "top", Command: []string{"htop"}},
+	}
+
+	cases := []struct {
+		name    string
+		in      string
+		want    *api.MenuItem
+		wantErr error
+	}{
+		{name: "choose", in: "2\r", want: items[1]},
+		{name: "invalid then choose", in: "3\r1\r", want: items[0]},
+		{name: "backspace", in: "3\x7f1\r", want: items[0]},
+		{name: "quit", in: "q\r", wantErr: errMenuQuit},
+		{name: "ctrl-c", in: "\x03", wantErr: errMenuQuit},
+		{name: "eof", in: "1", wantErr: errMenuQuit},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			term := &fakeTerminal{in: strings.NewReader(c.in)}
+			got, err := chooseMenuItem(term, items)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("want error %v, got %v", c.wantErr, err)
+			}
+			if got != c.want {
+				t.Fatalf("want=%v got=%v", c.want, got)
+			}
+			if !strings.Contains(term.out.String(), "1) logs: tail -f log") {
+				t.Fatalf("menu is not rendered: %q", term.out.String())
+			}
+		})
+	}
+}
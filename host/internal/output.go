@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/owenthereal/upterm/host/api"
+)
+
+// outputStreamBuffer is how many chunks of output a subscriber may fall behind before it's disconnected.
+const outputStreamBuffer = 256
+
+// ErrOutputLagging is returned when a subscriber falls behind the output, which never waits for subscribers.
+var ErrOutputLagging = errors.New("fell behind the output")
+
+// NewOutputStream returns an OutputStream without subscribers.
+func NewOutputStream() *OutputStream {
+	return &OutputStream{
+		subs: make(map[*OutputSubscription]struct{}),
+	}
+}
+
+// OutputStream streams the output of the terminal of a session to subscribers read-only, e.g. recorders and
+// broadcast bridges consuming the output without attaching as SSH clients. It's written to with the output of the
+// session and follows the window of the terminal through the Pty it wraps.
+type OutputStream struct {
+	mu         sync.Mutex
+	subs       map[*OutputSubscription]struct{}
+	cols, rows int
+	closed     bool
+}
+
+// Pty wraps pty to stream changes of its window.
+func (o *OutputStream) Pty(pty Pty) Pty {
+	return &streamedPty{Pty: pty, o: o}
+}
+
+// Write sends p to the subscribers. It never fails nor blocks, disconnecting the subscribers falling behind instead.
+func (o *OutputStream) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sendLocked(append([]byte(nil), p...))
+
+	return len(p), nil
+}
+
+// Subscribe returns a subscription to the output from now on, starting with the window of the terminal. It must be
+// closed.
+func (o *OutputStream) Subscribe() *OutputSubscription {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	sub := &OutputSubscription{
+		o:      o,
+		chunks: make(chan *api.OutputChunk, outputStreamBuffer),
+		done:   make(chan struct{}),
+	}
+	sub.chunks <- o.chunkLocked(nil)
+	if o.closed {
+		sub.closeWith(nil)
+		return sub
+	}
+	o.subs[sub] = struct{}{}
+
+	return sub
+}
+
+// Close ends the subscriptions once the session ends.
+func (o *OutputStream) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.closed = true
+	for sub := range o.subs {
+		delete(o.subs, sub)
+		sub.closeWith(nil)
+	}
+}
+
+func (o *OutputStream) resized(cols, rows int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.cols == cols && o.rows == rows {
+		return
+	}
+	o.cols, o.rows = cols, rows
+	o.sendLocked(nil)
+}
+
+func (o *OutputStream) sendLocked(data []byte) {
+	if len(o.subs) == 0 {
+		return
+	}
+
+	chunk := o.chunkLocked(data)
+	for sub := range o.subs {
+		select {
+		case sub.chunks <- chunk:
+		default:
+			delete(o.subs, sub)
+			sub.closeWith(ErrOutputLagging)
+		}
+	}
+}
+
+func (o *OutputStream) chunkLocked(data []byte) *api.OutputChunk {
+	return &api.OutputChunk{
+		Data:       data,
+		Cols:       int32(o.cols),
+		Rows:       int32(o.rows),
+		TimeUnixMs: time.Now().UnixMilli(),
+	}
+}
+
+// OutputSubscription receives the output of an OutputStream.
+type OutputSubscription struct {
+	o      *OutputStream
+	chunks chan *api.OutputChunk
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Chunks returns the output, and changes of the window as chunks without data.
+func (s *OutputSubscription) Chunks() <-chan *api.OutputChunk {
+	return s.chunks
+}
+
+// Done is closed once the subscription or the stream is closed, or the subscriber falls behind the output.
+func (s *OutputSubscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns ErrOutputLagging if the subscriber fell behind the output once Done is closed.
+func (s *OutputSubscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close unsubscribes from the output.
+func (s *OutputSubscription) Close() {
+	s.o.mu.Lock()
+	defer s.o.mu.Unlock()
+
+	delete(s.o.subs, s)
+	s.closeWith(nil)
+}
+
+func (s *OutputSubscription) closeWith(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// streamedPty streams the window of the terminal as it's resized.
+type streamedPty struct {
+	Pty
+	o *OutputStream
+}
+
+// Unwrap returns the pty wrapped.
+func (p *streamedPty) Unwrap() Pty {
+	return p.Pty
+}
+
+func (p *streamedPty) Setsize(h, w int) error {
+	if err := p.Pty.Setsize(h, w); err != nil {
+		return err
+	}
+	p.o.resized(w, h)
+
+	return nil
+}
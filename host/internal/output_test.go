@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+type nopPty struct {
+	h, w int
+}
+
+func (p *nopPty) Read(b []byte) (int, error)  { return 0, nil }
+func (p *nopPty) Write(b []byte) (int, error) { return len(b), nil }
+func (p *nopPty) Close() error                { return nil }
+func (p *nopPty) Setsize(h, w int) error {
+	p.h, p.w = h, w
+	return nil
+}
+
+func Test_OutputStream(t *testing.T) {
+	o := NewOutputStream()
+	inner := &nopPty{}
+	pty := o.Pty(inner)
+
+	// output without subscribers is dropped
+	if _, err := o.Write([]byte("dropped")); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := o.Subscribe()
+	defer sub.Close()
+
+	// the window is streamed first and as it changes
+	if chunk := <-sub.Chunks(); len(chunk.Data) != 0 || chunk.Cols != 0 || chunk.Rows != 0 {
+		t.Fatalf("want an unsized window, got %v", chunk)
+	}
+	if err := pty.Setsize(24, 80); err != nil {
+		t.Fatal(err)
+	}
+	if inner.h != 24 || inner.w != 80 {
+		t.Fatalf("want the pty resized, got %dx%d", inner.w, inner.h)
+	}
+	if chunk := <-sub.Chunks(); len(chunk.Data) != 0 || chunk.Cols != 80 || chunk.Rows != 24 {
+		t.Fatalf("want the window 80x24, got %v", chunk)
+	}
+	// resizing to the same window isn't streamed
+	if err := pty.Setsize(24, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	b := []byte("hello")
+	if _, err := o.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	copy(b, "XXXXX")
+	chunk := <-sub.Chunks()
+	if want, got := "hello", string(chunk.Data); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	if chunk.Cols != 80 || chunk.Rows != 24 || chunk.TimeUnixMs == 0 {
+		t.Fatalf("unexpected chunk %v", chunk)
+	}
+
+	// a subscriber falling behind is disconnected without blocking the output
+	for i := 0; i <= outputStreamBuffer; i++ {
+		if _, err := o.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-sub.Done()
+	if err := sub.Err(); !errors.Is(err, ErrOutputLagging) {
+		t.Fatalf("want ErrOutputLagging, got %v", err)
+	}
+
+	// closed subscriptions receive no output
+	sub = o.Subscribe()
+	sub.Close()
+	if err := sub.Err(); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if _, err := o.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sub.Chunks()); n != 1 {
+		t.Fatalf("want only the window, got %d chunks", n)
+	}
+
+	// subscriptions end once the stream is closed
+	sub = o.Subscribe()
+	o.Close()
+	<-sub.Done()
+	<-o.Subscribe().Done()
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// hotkeyAcceptPaste forwards the paste waiting for the host after hotkeyPrefix.
+	hotkeyAcceptPaste = 'y'
+	// hotkeyRejectPaste drops the paste waiting for the host after hotkeyPrefix.
+	hotkeyRejectPaste = 'n'
+	// pasteConfirmTimeout drops a paste the host doesn't confirm in time, so that the client isn't stuck.
+	pasteConfirmTimeout = 30 * time.Second
+	// pasteMaxSize is the largest paste held for the host. Larger ones are dropped.
+	pasteMaxSize = 1 << 20
+)
+
+var (
+	// pasteStart and pasteEnd surround pastes of terminals in bracketed paste mode.
+	pasteStart = []byte("\x1b[200~")
+	pasteEnd   = []byte("\x1b[201~")
+)
+
+// newPasteGuard returns a guard holding pastes larger than threshold bytes, announcing them to the host on w.
+// It's disabled if threshold isn't positive.
+func newPasteGuard(threshold int, w io.Writer) *pasteGuard {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &pasteGuard{
+		threshold: threshold,
+		timeout:   pasteConfirmTimeout,
+		host:      w,
+		turn:      make(chan struct{}, 1),
+	}
+}
+
+// pasteGuard holds large pastes of clients, and bracketed pastes with escape sequences, until the host confirms them,
+// so that a client doesn't dump a huge buffer or a payload of escape sequences into the shared shell by accident.
+// Pastes are detected by the bracketed paste markers, or as a single write larger than the threshold for terminals
+// without bracketed paste mode. The host confirms one paste at a time. A nil guard forwards everything.
+type pasteGuard struct {
+	threshold int
+	timeout   time.Duration
+	host      io.Writer
+
+	// turn is taken by the paste waiting for the host
+	turn chan struct{}
+
+	mu     sync.Mutex
+	decide chan bool
+}
+
+// Accept forwards the paste waiting for the host, if any.
+func (g *pasteGuard) Accept() {
+	g.resolve(true)
+}
+
+// Reject drops the paste waiting for the host, if any.
+func (g *pasteGuard) Reject() {
+	g.resolve(false)
+}
+
+func (g *pasteGuard) resolve(ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.decide != nil {
+		g.decide <- ok
+		g.decide = nil
+	}
+}
+
+// Writer returns a writer holding the pastes of client written to w until the host confirms them.
+// The client is told about held pastes on notify. Writes block while a paste is held or until ctx is done.
+// It returns w if g is nil.
+func (g *pasteGuard) Writer(ctx context.Context, w io.Writer, client string, notify io.Writer) io.Writer {
+	if g == nil {
+		return w
+	}
+
+	return &pasteWriter{ctx: ctx, w: w, g: g, client: client, notify: notify}
+}
+
+// confirm asks the host to confirm a paste of size bytes by client, telling the client on notify.
+// It reports whether the host accepted the paste.
+func (g *pasteGuard) confirm(ctx context.Context, client string, notify io.Writer, size int, escapes bool) bool {
+	_, _ = fmt.Fprintf(notify, "\r\n=== Your paste of %d bytes is waiting for the host to confirm it ===\r\n", size)
+
+	select {
+	case g.turn <- struct{}{}:
+		defer func() { <-g.turn }()
+	case <-ctx.Done():
+		return false
+	}
+
+	decide := make(chan bool, 1)
+	g.mu.Lock()
+	g.decide = decide
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.decide = nil
+		g.mu.Unlock()
+	}()
+
+	what := fmt.Sprintf("%d bytes", size)
+	if escapes {
+		what += " with escape sequences"
+	}
+	g.write(fmt.Sprintf("\r\n=== %s pasted %s. Type Ctrl-] %c to forward it or Ctrl-] %c to drop it ===\r\n", client, what, hotkeyAcceptPaste, hotkeyRejectPaste))
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+
+	var (
+		ok     bool
+		reason string
+	)
+	select {
+	case ok = <-decide:
+		reason = "by the host"
+	case <-timer.C:
+		reason = fmt.Sprintf("after %s without confirmation", g.timeout)
+	case <-ctx.Done():
+		return false
+	}
+
+	verb := "dropped"
+	if ok {
+		verb = "forwarded"
+	}
+	g.write(fmt.Sprintf("\r\n=== Paste of %s %s ===\r\n", client, verb))
+	_, _ = fmt.Fprintf(notify, "\r\n=== Your paste was %s %s ===\r\n", verb, reason)
+
+	return ok
+}
+
+func (g *pasteGuard) write(s string) {
+	if g.host != nil {
+		_, _ = io.WriteString(g.host, s)
+	}
+}
+
+// pasteWriter buffers bracketed pastes of a client until they end, and holds the large ones.
+type pasteWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	g      *pasteGuard
+	client string
+	notify io.Writer
+
+	pasting bool
+	// buf is the bracketed paste read so far, including the start marker
+	buf []byte
+	// dropped is whether the paste grew larger than pasteMaxSize. Only the tail of it is kept to find the end marker.
+	dropped bool
+}
+
+func (w *pasteWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if !w.pasting {
+			i := bytes.Index(p, pasteStart)
+			if i < 0 {
+				if len(p) > w.g.threshold {
+					// a paste of a terminal without bracketed paste mode
+					return n, w.forward(p, len(p), false)
+				}
+
+				_, err := w.w.Write(p)
+				return n, err
+			}
+
+			if i > 0 {
+				if _, err := w.w.Write(p[:i]); err != nil {
+					return n, err
+				}
+			}
+			w.pasting = true
+			p = p[i:]
+		}
+
+		from := len(pasteStart)
+		if w.dropped {
+			from = 0
+		}
+		w.buf = append(w.buf, p...)
+		j := bytes.Index(w.buf[from:], pasteEnd)
+		if j < 0 {
+			if len(w.buf) > pasteMaxSize {
+				// keep looking for the end marker without holding the paste
+				w.buf = append(w.buf[:0], w.buf[len(w.buf)-len(pasteEnd)+1:]...)
+				w.dropped = true
+			}
+			return n, nil
+		}
+
+		end := from + j + len(pasteEnd)
+		paste, dropped := w.buf[:end], w.dropped
+		p = w.buf[end:]
+		w.buf, w.pasting, w.dropped = nil, false, false
+
+		if dropped {
+			_, _ = fmt.Fprintf(w.notify, "\r\n=== Your paste was dropped for being larger than %d bytes ===\r\n", pasteMaxSize)
+			continue
+		}
+
+		content := paste[len(pasteStart) : len(paste)-len(pasteEnd)]
+		escapes := bytes.IndexByte(content, 0x1b) >= 0
+		if len(content) <= w.g.threshold && !escapes {
+			if _, err := w.w.Write(paste); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := w.forward(paste, len(content), escapes); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// forward writes the paste p of size bytes once the host confirms it.
+func (w *pasteWriter) forward(p []byte, size int, escapes bool) error {
+	if !w.g.confirm(w.ctx, w.client, w.notify, size, escapes) {
+		return nil
+	}
+
+	_, err := w.w.Write(p)
+	return err
+}
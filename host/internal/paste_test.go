@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_pasteGuard(t *testing.T) {
+	t.Parallel()
+
+	paste := func(s string) string {
+		return string(pasteStart) + s + string(pasteEnd)
+	}
+
+	cases := []struct {
+		name    string
+		writes  []string
+		decide  func(g *pasteGuard)
+		want    string
+		wantMsg string
+	}{
+		{
+			name:   "typing",
+			writes: []string{"ls", "\r"},
+			want:   "ls\r",
+		},
+		{
+			name:   "small paste",
+			writes: []string{"a" + paste("echo hi") + "b"},
+			want:   "a" + paste("echo hi") + "b",
+		},
+		{
+			name:    "large paste accepted",
+			writes:  []string{"a" + paste("0123456789") + "b"},
+			decide:  (*pasteGuard).Accept,
+			want:    "a" + paste("0123456789") + "b",
+			wantMsg: "Your paste was forwarded by the host",
+		},
+		{
+			name:    "large paste rejected",
+			writes:  []string{"a" + paste("0123456789") + "b"},
+			decide:  (*pasteGuard).Reject,
+			want:    "ab",
+			wantMsg: "Your paste was dropped by the host",
+		},
+		{
+			name:    "large paste timed out",
+			writes:  []string{paste("0123456789")},
+			decide:  func(g *pasteGuard) {},
+			want:    "",
+			wantMsg: "Your paste was dropped after 50ms without confirmation",
+		},
+		{
+			name:    "paste with escape sequences",
+			writes:  []string{paste("\x1b]52;c;")},
+			decide:  (*pasteGuard).Reject,
+			want:    "",
+			wantMsg: "Your paste was dropped by the host",
+		},
+		{
+			name:    "paste split across writes",
+			writes:  []string{"a" + string(pasteStart) + "01234", "56789\x1b[20", "1~b"},
+			decide:  (*pasteGuard).Reject,
+			want:    "ab",
+			wantMsg: "Your paste was dropped by the host",
+		},
+		{
+			name:    "unbracketed paste",
+			writes:  []string{"0123456789"},
+			decide:  (*pasteGuard).Accept,
+			want:    "0123456789",
+			wantMsg: "Your paste was forwarded by the host",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			host := make(chanWriter, 10)
+			g := newPasteGuard(8, host)
+			g.timeout = 50 * time.Millisecond
+
+			var out, notify bytes.Buffer
+			w := g.Writer(context.Background(), &out, "alice", &notify)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for _, s := range c.writes {
+					if _, err := w.Write([]byte(s)); err != nil {
+						t.Errorf("error writing: %s", err)
+					}
+				}
+			}()
+
+			if c.decide != nil {
+				select {
+				case b := <-host:
+					if !strings.Contains(b, "alice pasted") {
+						t.Fatalf("want paste prompt, got %q", b)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("want paste prompt")
+				}
+				c.decide(g)
+			}
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("want writes to complete")
+			}
+
+			if want, got := c.want, out.String(); want != got {
+				t.Fatalf("want input %q, got %q", want, got)
+			}
+			if !strings.Contains(notify.String(), c.wantMsg) {
+				t.Fatalf("want client notified of %q, got %q", c.wantMsg, notify.String())
+			}
+		})
+	}
+}
+
+func Test_pasteGuard_disabled(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	g := newPasteGuard(0, nil)
+	if w := g.Writer(context.Background(), &out, "alice", nil); w != &out {
+		t.Fatal("want a disabled guard to return the writer")
+	}
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"net"
+	"slices"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// directTCPIPChannel is the type of the channels clients open to forward connections, e.g. with ssh -L.
+const directTCPIPChannel = "direct-tcpip"
+
+// portForwarder lets clients forward connections to ports of the host machine, e.g. a local dev web server.
+// Only loopback destinations are allowed, so that clients can't reach the network of the host through it.
+// Clients are refused until they are approved. Ports other than the published ones are refused while the session
+// is read-only. Forwarded connections aren't closed when the session turns read-only.
+type portForwarder struct {
+	// all allows forwarding to any loopback port instead of only to the published ports.
+	all bool
+	// ports are the loopback ports the host publishes to clients.
+	ports    []uint32
+	readonly *ReadOnly
+	// approval refuses clients it hasn't approved if it's non-nil.
+	approval *Approval
+	logger   log.FieldLogger
+}
+
+func (f *portForwarder) LocalPortForwardingCallback(ctx gssh.Context, host string, port uint32) bool {
+	logger := f.logger.WithFields(log.Fields{"destination-host": host, "destination-port": port})
+	if c, ok := ctx.Value(contextKeyClient).(*api.Client); ok {
+		logger = logger.WithField("client", c.Addr)
+	}
+
+	if !isLoopbackHost(host) {
+		logger.Info("refused forwarding to a destination outside the host")
+		return false
+	}
+	published := slices.Contains(f.ports, port)
+	if !published && !f.all {
+		logger.Info("refused forwarding to a port that isn't published")
+		return false
+	}
+	if !published && f.readonly.Get() {
+		logger.Info("refused forwarding in a read-only session")
+		return false
+	}
+	if f.approval != nil && !f.approval.Approved(ctx) {
+		logger.Info("refused forwarding of an unapproved client")
+		return false
+	}
+
+	logger.Info("forwarding port")
+	return true
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback address.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
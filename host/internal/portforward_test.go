@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"testing"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/approval"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// clientContext is the context of a connection of the client.
+type clientContext struct {
+	gssh.Context
+	client *api.Client
+}
+
+func (c clientContext) Value(key interface{}) interface{} {
+	if key == contextKeyClient {
+		return c.client
+	}
+
+	return nil
+}
+
+func Test_portForwarder(t *testing.T) {
+	readonly := NewReadOnly(false, nil)
+	pf := portForwarder{
+		all:      true,
+		readonly: readonly,
+		logger:   log.New(),
+	}
+	ctx := clientContext{client: &api.Client{Addr: "192.0.2.1:1234", PublicKeyFingerprint: "SHA256:abc"}}
+
+	for host, want := range map[string]bool{
+		"localhost":   true,
+		"127.0.0.1":   true,
+		"::1":         true,
+		"192.0.2.1":   false,
+		"example.com": false,
+	} {
+		if got := pf.LocalPortForwardingCallback(ctx, host, 8080); got != want {
+			t.Errorf("forwarding to %s: want=%t got=%t", host, want, got)
+		}
+	}
+
+	readonly.Set(true)
+	if pf.LocalPortForwardingCallback(ctx, "localhost", 8080) {
+		t.Error("want forwarding refused in a read-only session")
+	}
+	readonly.Set(false)
+
+	// clients are refused until they are approved
+	pf.approval = &Approval{Policy: &approval.Policy{}}
+	if pf.LocalPortForwardingCallback(ctx, "localhost", 8080) {
+		t.Error("want forwarding of an unapproved client refused")
+	}
+	pf.approval.remember("SHA256:abc", approval.Decision{Approved: true, Approver: "alice"})
+	if !pf.LocalPortForwardingCallback(ctx, "localhost", 8080) {
+		t.Error("want forwarding of an approved client allowed")
+	}
+}
+
+func Test_portForwarder_Published(t *testing.T) {
+	readonly := NewReadOnly(true, nil)
+	pf := portForwarder{
+		ports:    []uint32{3000},
+		readonly: readonly,
+		logger:   log.New(),
+	}
+	ctx := clientContext{client: &api.Client{Addr: "192.0.2.1:1234"}}
+
+	// published ports are reachable in read-only sessions
+	if !pf.LocalPortForwardingCallback(ctx, "localhost", 3000) {
+		t.Error("want forwarding to a published port allowed")
+	}
+	if pf.LocalPortForwardingCallback(ctx, "localhost", 8080) {
+		t.Error("want forwarding to a port that isn't published refused")
+	}
+	if pf.LocalPortForwardingCallback(ctx, "192.0.2.1", 3000) {
+		t.Error("want forwarding to a published port outside the host refused")
+	}
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+const (
+	// hotkeyTogglePrivacy toggles the privacy mode after hotkeyPrefix.
+	hotkeyTogglePrivacy = 'p'
+	// privacyTimeout turns the privacy mode off automatically, so that the host
+	// doesn't keep clients in the dark by forgetting to turn it off.
+	privacyTimeout = 30 * time.Second
+)
+
+// NewPrivacy returns the privacy mode of a session, which is off initially.
+func NewPrivacy(eventEmitter *emitter.Emitter) *Privacy {
+	return &Privacy{
+		timeout:      privacyTimeout,
+		eventEmitter: eventEmitter,
+	}
+}
+
+// Privacy is the privacy mode of a session. The host turns it on to type masked input,
+// e.g. passwords: the output of the session is shown to the host only while it's on,
+// including the echo of the input. It turns off after a timeout.
+// Changes are emitted as events.PrivacyChanged.
+type Privacy struct {
+	v            atomic.Bool
+	mu           sync.Mutex
+	timer        *time.Timer
+	timeout      time.Duration
+	eventEmitter *emitter.Emitter
+}
+
+func (p *Privacy) Get() bool {
+	return p.v.Load()
+}
+
+func (p *Privacy) Set(private bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.set(private)
+}
+
+func (p *Privacy) Toggle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.set(!p.v.Load())
+}
+
+func (p *Privacy) set(private bool) {
+	if p.v.Swap(private) == private {
+		return
+	}
+
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if private {
+		var timer *time.Timer
+		timer = time.AfterFunc(p.timeout, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			// ignore a timer stopped after it fired
+			if p.timer == timer {
+				p.set(false)
+			}
+		})
+		p.timer = timer
+	}
+
+	if p.eventEmitter != nil {
+		events.Emit(p.eventEmitter, events.PrivacyChanged{Private: private})
+	}
+}
+
+// Writer returns a writer that writes to public, or only to private while the session is private.
+func (p *Privacy) Writer(public, private io.Writer) io.Writer {
+	return privacyWriter{public: public, private: private, p: p}
+}
+
+type privacyWriter struct {
+	public  io.Writer
+	private io.Writer
+	p       *Privacy
+}
+
+func (w privacyWriter) Write(b []byte) (int, error) {
+	if w.p.Get() {
+		return w.private.Write(b)
+	}
+
+	return w.public.Write(b)
+}
+
+// writePrivacyBanners writes a banner to w whenever the privacy mode changes until ctx is done.
+// host tells whether w is the host's terminal or a client's.
+func writePrivacyBanners(ctx context.Context, eventEmitter *emitter.Emitter, w io.Writer, host bool) error {
+	ch := events.On(eventEmitter, events.KindPrivacyChanged)
+	defer events.Off(eventEmitter, events.KindPrivacyChanged, ch)
+
+	for {
+		select {
+		case evt := <-ch:
+			e, ok := events.From(evt).(events.PrivacyChanged)
+			if !ok {
+				continue
+			}
+
+			var banner string
+			switch {
+			case e.Private && host:
+				banner = "\r\n=== Output is hidden from clients, type Ctrl-] p when done ===\r\n"
+			case e.Private:
+				banner = "\r\n=== The host is typing privately, output is paused ===\r\n"
+			default:
+				banner = "\r\n=== Output is shared again ===\r\n"
+			}
+			if _, err := io.WriteString(w, banner); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
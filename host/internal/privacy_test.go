@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+func Test_Privacy(t *testing.T) {
+	em := emitter.New(1)
+	ch := events.On(em, events.KindPrivacyChanged)
+	defer events.Off(em, events.KindPrivacyChanged, ch)
+
+	p := NewPrivacy(em)
+	p.timeout = 50 * time.Millisecond
+
+	var public, private bytes.Buffer
+	w := p.Writer(&public, &private)
+
+	_, _ = w.Write([]byte("a"))
+	p.Toggle()
+	_, _ = w.Write([]byte("secret"))
+
+	if want, got := "a", public.String(); want != got {
+		t.Fatalf("want public output %q, got %q", want, got)
+	}
+	if want, got := "secret", private.String(); want != got {
+		t.Fatalf("want private output %q, got %q", want, got)
+	}
+
+	for _, want := range []bool{true, false} {
+		select {
+		case evt := <-ch:
+			if got := events.From(evt); got != (events.PrivacyChanged{Private: want}) {
+				t.Fatalf("want private %t, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for private %t", want)
+		}
+	}
+
+	if p.Get() {
+		t.Fatal("want privacy mode to time out")
+	}
+	_, _ = w.Write([]byte("b"))
+	if want, got := "ab", public.String(); want != got {
+		t.Fatalf("want public output %q, got %q", want, got)
+	}
+}
@@ -1,21 +1,93 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 
 	ptylib "github.com/creack/pty"
 )
 
-func startPty(c *exec.Cmd) (*pty, error) {
+const (
+	// PtyBackendPty attaches commands to a pseudo terminal of the OS with creack/pty.
+	PtyBackendPty = "pty"
+	// PtyBackendConPTY attaches commands to a Windows pseudo console.
+	PtyBackendConPTY = "conpty"
+	// PtyBackendTmux runs commands in a tmux pane driven in control mode.
+	PtyBackendTmux = "tmux"
+)
+
+// PtyBackends are the supported pty backends.
+var PtyBackends = []string{PtyBackendPty, PtyBackendConPTY, PtyBackendTmux}
+
+// ErrPtyBackendUnsupported is returned when a pty backend isn't supported on the platform.
+var ErrPtyBackendUnsupported = errors.New("pty backend not supported on this platform")
+
+// Pty is the terminal a command is attached to. Reads return the output of the command
+// and writes are the input of the command.
+type Pty interface {
+	io.ReadWriteCloser
+	// Setsize resizes the terminal to h rows and w columns.
+	Setsize(h, w int) error
+}
+
+// Process is a command started by a PtyBackend.
+type Process interface {
+	// Wait waits for the command to exit. It returns an *exec.ExitError if the command fails.
+	Wait() error
+}
+
+// PtyBackend starts commands attached to a Pty.
+type PtyBackend interface {
+	Name() string
+	// Start starts cmd attached to a new Pty. The command must be waited for with the returned Process.
+	Start(cmd *exec.Cmd) (Pty, Process, error)
+}
+
+// NewPtyBackend returns the pty backend with the name. It defaults to the backend of the platform if name is empty.
+func NewPtyBackend(name string) (PtyBackend, error) {
+	if name == "" {
+		name = PtyBackendPty
+		if runtime.GOOS == "windows" {
+			name = PtyBackendConPTY
+		}
+	}
+
+	switch name {
+	case PtyBackendPty:
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("%w: %s, use %s instead", ErrPtyBackendUnsupported, name, PtyBackendConPTY)
+		}
+		return ptyBackend{}, nil
+	case PtyBackendConPTY:
+		return newConPTYBackend()
+	case PtyBackendTmux:
+		return newTmuxBackend()
+	default:
+		return nil, fmt.Errorf("unsupported pty backend %q, supported backends: %s", name, strings.Join(PtyBackends, ", "))
+	}
+}
+
+// ptyBackend attaches commands to a pseudo terminal of the OS.
+type ptyBackend struct{}
+
+func (ptyBackend) Name() string {
+	return PtyBackendPty
+}
+
+func (ptyBackend) Start(c *exec.Cmd) (Pty, Process, error) {
 	f, err := ptylib.Start(c)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return wrapPty(f), nil
+	return wrapPty(f), c, nil
 }
 
 // Linux kernel return EIO when attempting to read from a master pseudo
@@ -29,10 +101,6 @@ func ptyError(err error) error {
 	return nil
 }
 
-func getPtysize(f *os.File) (h, w int, err error) {
-	return ptylib.Getsize(f)
-}
-
 func wrapPty(f *os.File) *pty {
 	return &pty{File: f}
 }
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package internal
+
+import "fmt"
+
+func newConPTYBackend() (PtyBackend, error) {
+	return nil, fmt.Errorf("%w: %s is only supported on Windows", ErrPtyBackendUnsupported, PtyBackendConPTY)
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// conptyBackend attaches commands to a Windows pseudo console (ConPTY),
+// available since Windows 10 1809.
+type conptyBackend struct{}
+
+func newConPTYBackend() (PtyBackend, error) {
+	if err := windows.NewLazySystemDLL("kernel32.dll").NewProc("CreatePseudoConsole").Find(); err != nil {
+		return nil, fmt.Errorf("%w: %s requires Windows 10 1809 or later", ErrPtyBackendUnsupported, PtyBackendConPTY)
+	}
+
+	return conptyBackend{}, nil
+}
+
+func (conptyBackend) Name() string {
+	return PtyBackendConPTY
+}
+
+// Start starts c in a new pseudo console. exec.Cmd can't attach a process to a pseudo console,
+// so the process is created with the attributes of c and waited for with os.Process.
+func (conptyBackend) Start(c *exec.Cmd) (Pty, Process, error) {
+	if c.Err != nil {
+		return nil, nil, c.Err
+	}
+
+	// the console reads input from inR and writes output to outW
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, nil, err
+	}
+
+	var hpc windows.Handle
+	err = windows.CreatePseudoConsole(windows.Coord{X: 80, Y: 24}, windows.Handle(inR.Fd()), windows.Handle(outW.Fd()), 0, &hpc)
+	// the console duplicates the handles of its ends
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, nil, fmt.Errorf("error creating pseudo console: %w", err)
+	}
+
+	p := &conpty{hpc: hpc, in: inW, out: outR}
+	proc, err := startConPTYProcess(c, hpc)
+	if err != nil {
+		p.Close()
+		return nil, nil, err
+	}
+
+	return p, conptyProcess{proc}, nil
+}
+
+func startConPTYProcess(c *exec.Cmd, hpc windows.Handle) (*os.Process, error) {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return nil, err
+	}
+	defer attrs.Delete()
+
+	// the value of the attribute is the console handle itself
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, *(*unsafe.Pointer)(unsafe.Pointer(&hpc)), unsafe.Sizeof(hpc)); err != nil {
+		return nil, err
+	}
+
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	// don't let the process inherit the std handles of the host
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	cmdLine, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(append([]string{c.Path}, c.Args[1:]...)))
+	if err != nil {
+		return nil, err
+	}
+
+	var dir *uint16
+	if c.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(c.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	env := c.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	if err := windows.CreateProcess(nil, cmdLine, nil, nil, false, flags, envBlock(env), dir, &si.StartupInfo, &pi); err != nil {
+		return nil, fmt.Errorf("error starting %s: %w", c.Path, err)
+	}
+	defer windows.CloseHandle(pi.Thread)
+	// os.FindProcess opens its own handle of the process
+	defer windows.CloseHandle(pi.Process)
+
+	return os.FindProcess(int(pi.ProcessId))
+}
+
+// envBlock returns the environment block of env for CreateProcess.
+func envBlock(env []string) *uint16 {
+	var b []uint16
+	for _, e := range env {
+		b = append(b, utf16.Encode([]rune(e))...)
+		b = append(b, 0)
+	}
+	b = append(b, 0)
+
+	return &b[0]
+}
+
+type conptyProcess struct {
+	*os.Process
+}
+
+func (p conptyProcess) Wait() error {
+	state, err := p.Process.Wait()
+	if err != nil {
+		return err
+	}
+	if !state.Success() {
+		return &exec.ExitError{ProcessState: state}
+	}
+
+	return nil
+}
+
+type conpty struct {
+	hpc  windows.Handle
+	in   *os.File
+	out  *os.File
+	once sync.Once
+}
+
+func (p *conpty) Read(b []byte) (int, error) {
+	return p.out.Read(b)
+}
+
+func (p *conpty) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+func (p *conpty) Setsize(h, w int) error {
+	return windows.ResizePseudoConsole(p.hpc, windows.Coord{X: int16(w), Y: int16(h)})
+}
+
+// Close closes the pseudo console, which terminates the attached process.
+func (p *conpty) Close() error {
+	p.once.Do(func() {
+		p.in.Close()
+		windows.ClosePseudoConsole(p.hpc)
+		p.out.Close()
+	})
+
+	return nil
+}
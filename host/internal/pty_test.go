@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_decodeTmuxOutput(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "hello world", want: "hello world"},
+		{name: "crlf", value: `hi\015\012`, want: "hi\r\n"},
+		{name: "escape", value: `\033[1mbold`, want: "\x1b[1mbold"},
+		{name: "backslash", value: `a\134b`, want: `a\b`},
+		{name: "truncated escape", value: `a\01`, want: `a\01`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := string(decodeTmuxOutput(c.value)); got != c.want {
+				t.Fatalf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_parseTmuxOutput(t *testing.T) {
+	pane, data, ok := parseTmuxOutput(`%output %1 hi there\015\012`)
+	if !ok {
+		t.Fatal("want output parsed")
+	}
+	if want, got := "%1", pane; want != got {
+		t.Fatalf("want pane %q, got %q", want, got)
+	}
+	if want, got := "hi there\r\n", string(data); want != got {
+		t.Fatalf("want data %q, got %q", want, got)
+	}
+
+	if _, _, ok := parseTmuxOutput("%output %1"); ok {
+		t.Fatal("want output without value not parsed")
+	}
+}
+
+func Test_NewPtyBackend(t *testing.T) {
+	if _, err := NewPtyBackend("nope"); err == nil {
+		t.Fatal("want error for unknown backend")
+	}
+
+	if runtime.GOOS != "windows" {
+		_, err := NewPtyBackend(PtyBackendConPTY)
+		if !errors.Is(err, ErrPtyBackendUnsupported) {
+			t.Fatalf("want %s unsupported, got %v", PtyBackendConPTY, err)
+		}
+
+		b, err := NewPtyBackend("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := PtyBackendPty, b.Name(); want != got {
+			t.Fatalf("want default backend %q, got %q", want, got)
+		}
+	}
+}
+
+func Test_PtyBackends(t *testing.T) {
+	for _, name := range []string{PtyBackendPty, PtyBackendTmux} {
+		t.Run(name, func(t *testing.T) {
+			b, err := NewPtyBackend(name)
+			if err != nil {
+				t.Skip(err)
+			}
+
+			ptmx, proc, err := b.Start(exec.Command("cat"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ptmx.Close()
+
+			if err := ptmx.Setsize(30, 100); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(ptmx, "upterm\r"); err != nil {
+				t.Fatal(err)
+			}
+
+			out := make(chan string, 1)
+			go func() {
+				var b bytes.Buffer
+				buf := make([]byte, 1024)
+				for !strings.Contains(b.String(), "upterm\r\nupterm") {
+					n, err := ptmx.Read(buf)
+					if err != nil {
+						break
+					}
+					b.Write(buf[:n])
+				}
+				out <- b.String()
+			}()
+
+			select {
+			case got := <-out:
+				// the terminal echoes the input and cat prints it
+				if want := "upterm\r\nupterm"; !strings.Contains(got, want) {
+					t.Fatalf("want output containing %q, got %q", want, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout reading output")
+			}
+
+			_ = ptmx.Close()
+			done := make(chan error, 1)
+			go func() {
+				done <- proc.Wait()
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for the command to exit after closing the terminal")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// tmuxMaxKeys is the max bytes of input sent by a send-keys command.
+	tmuxMaxKeys = 256
+	// tmuxMaxLine is the max length of a line of tmux control mode output.
+	tmuxMaxLine = 1024 * 1024
+)
+
+var tmuxServers atomic.Int64
+
+// tmuxBackend runs commands in a pane of a dedicated tmux server driven in control mode (tmux -C).
+// Only the pane of the command is shared, so the host can split windows and run other panes
+// privately by attaching to the server with 'tmux -L SOCKET attach'.
+type tmuxBackend struct {
+	path string
+}
+
+func newTmuxBackend() (PtyBackend, error) {
+	path, err := exec.LookPath("tmux")
+	if err != nil {
+		return nil, fmt.Errorf("%w: install tmux or use another pty backend", err)
+	}
+
+	return tmuxBackend{path: path}, nil
+}
+
+func (tmuxBackend) Name() string {
+	return PtyBackendTmux
+}
+
+// Start rewrites c to start a tmux control mode client creating a session that runs c.
+// The process exits when the command of the session exits.
+func (b tmuxBackend) Start(c *exec.Cmd) (Pty, Process, error) {
+	if c.Err != nil {
+		return nil, nil, c.Err
+	}
+
+	socket := fmt.Sprintf("upterm-%d-%d", os.Getpid(), tmuxServers.Add(1))
+	args := []string{b.path, "-L", socket, "-C", "new-session", "-x", "80", "-y", "24", "-P", "-F", "#{pane_id}"}
+	if c.Dir != "" {
+		args = append(args, "-c", c.Dir)
+	}
+	args = append(args, "--", c.Path)
+	args = append(args, c.Args[1:]...)
+
+	c.Path = b.path
+	c.Args = args
+	c.Env = tmuxEnv(c.Env)
+
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	t := newTmuxPty(stdin)
+	go t.readLoop(stdout)
+
+	select {
+	case <-t.ready:
+	case <-t.done:
+		_ = c.Wait()
+		return nil, nil, fmt.Errorf("tmux exited before creating a session")
+	}
+
+	return t, c, nil
+}
+
+// tmuxEnv removes TMUX from env, so that tmux doesn't consider the session nested
+// when the host runs in tmux.
+func tmuxEnv(env []string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+
+	var result []string
+	for _, e := range env {
+		if !strings.HasPrefix(e, "TMUX=") {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// tmuxPty is the pane of a tmux control mode client.
+// Reads return the output of the pane. Writes are sent to the pane as keys.
+type tmuxPty struct {
+	// mu serializes the commands written to stdin
+	mu    sync.Mutex
+	stdin io.WriteCloser
+	pane  string
+
+	out   *io.PipeReader
+	outw  *io.PipeWriter
+	ready chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newTmuxPty(stdin io.WriteCloser) *tmuxPty {
+	out, outw := io.Pipe()
+
+	return &tmuxPty{
+		stdin: stdin,
+		out:   out,
+		outw:  outw,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// readLoop parses the control mode output until tmux exits. The reply to the
+// new-session command is the id of the pane. Output of the pane is written to t.out.
+func (t *tmuxPty) readLoop(r io.Reader) {
+	defer close(t.done)
+	defer t.outw.Close()
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), tmuxMaxLine)
+
+	var (
+		inBlock bool
+		ready   bool
+	)
+	for s.Scan() {
+		line := s.Text()
+
+		if inBlock {
+			if strings.HasPrefix(line, "%end ") || strings.HasPrefix(line, "%error ") {
+				inBlock = false
+				if !ready {
+					ready = true
+					close(t.ready)
+				}
+				continue
+			}
+			if !ready && t.pane == "" {
+				t.pane = line
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "%begin "):
+			inBlock = true
+		case strings.HasPrefix(line, "%output "):
+			pane, data, ok := parseTmuxOutput(line)
+			if !ok || pane != t.pane {
+				continue
+			}
+			if _, err := t.outw.Write(data); err != nil {
+				return
+			}
+		case line == "%exit" || strings.HasPrefix(line, "%exit "):
+			return
+		}
+	}
+}
+
+// parseTmuxOutput parses a %output notification of the form %output PANE VALUE.
+func parseTmuxOutput(line string) (string, []byte, bool) {
+	rest := strings.TrimPrefix(line, "%output ")
+	pane, value, ok := strings.Cut(rest, " ")
+	if !ok {
+		return "", nil, false
+	}
+
+	return pane, decodeTmuxOutput(value), true
+}
+
+// decodeTmuxOutput decodes the value of a %output notification, in which characters
+// less than ASCII 32 and backslashes are escaped in octal, e.g. \015 for \r.
+func decodeTmuxOutput(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b = append(b, (s[i+1]-'0')<<6|(s[i+2]-'0')<<3|(s[i+3]-'0'))
+			i += 3
+			continue
+		}
+
+		b = append(b, s[i])
+	}
+
+	return b
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+func (t *tmuxPty) command(format string, args ...interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err := fmt.Fprintf(t.stdin, format+"\n", args...)
+	return err
+}
+
+func (t *tmuxPty) Read(p []byte) (int, error) {
+	return t.out.Read(p)
+}
+
+// Write sends p to the pane as hex-encoded keys, so that any byte can be sent without quoting.
+func (t *tmuxPty) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i += tmuxMaxKeys {
+		end := i + tmuxMaxKeys
+		if end > len(p) {
+			end = len(p)
+		}
+
+		var keys strings.Builder
+		for _, c := range p[i:end] {
+			fmt.Fprintf(&keys, " %02x", c)
+		}
+
+		if err := t.command("send-keys -t %s -H%s", t.pane, keys.String()); err != nil {
+			return i, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (t *tmuxPty) Setsize(h, w int) error {
+	return t.command("refresh-client -C %dx%d", w, h)
+}
+
+// Close kills the tmux server, which ends the command.
+func (t *tmuxPty) Close() error {
+	var err error
+	t.once.Do(func() {
+		err = t.command("kill-server")
+		if cerr := t.stdin.Close(); err == nil {
+			err = cerr
+		}
+		_ = t.out.Close()
+	})
+
+	if errors.Is(err, os.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+
+	return err
+}
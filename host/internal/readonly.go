@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+const (
+	// hotkeyPrefix is the host key that starts a hotkey, like the escape character of telnet.
+	// Typing it twice sends it to the command.
+	hotkeyPrefix = 0x1d // Ctrl-]
+	// hotkeyToggleReadOnly toggles the read-only mode after hotkeyPrefix.
+	hotkeyToggleReadOnly = 'r'
+)
+
+// NewReadOnly returns the read-only mode of a session, initially set to readOnly.
+func NewReadOnly(readOnly bool, eventEmitter *emitter.Emitter) *ReadOnly {
+	r := &ReadOnly{eventEmitter: eventEmitter}
+	r.v.Store(readOnly)
+
+	return r
+}
+
+// ReadOnly is the read-only mode of a session. It can be changed while clients are connected.
+// Changes are emitted as events.ReadOnlyChanged.
+type ReadOnly struct {
+	v            atomic.Bool
+	mu           sync.Mutex
+	eventEmitter *emitter.Emitter
+}
+
+func (r *ReadOnly) Get() bool {
+	return r.v.Load()
+}
+
+func (r *ReadOnly) Set(readOnly bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.set(readOnly)
+}
+
+func (r *ReadOnly) Toggle() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.set(!r.v.Load())
+}
+
+func (r *ReadOnly) set(readOnly bool) {
+	if r.v.Swap(readOnly) == readOnly {
+		return
+	}
+
+	if r.eventEmitter != nil {
+		events.Emit(r.eventEmitter, events.ReadOnlyChanged{ReadOnly: readOnly})
+	}
+}
+
+// Writer returns a writer that discards writes while the session is read-only.
+func (r *ReadOnly) Writer(w io.Writer) io.Writer {
+	return readOnlyWriter{w: w, r: r}
+}
+
+type readOnlyWriter struct {
+	w io.Writer
+	r *ReadOnly
+}
+
+func (w readOnlyWriter) Write(p []byte) (int, error) {
+	if w.r.Get() {
+		return len(p), nil
+	}
+
+	return w.w.Write(p)
+}
+
+// writeReadOnlyBanners writes a banner to w whenever the read-only mode changes until ctx is done.
+func writeReadOnlyBanners(ctx context.Context, eventEmitter *emitter.Emitter, w io.Writer) error {
+	ch := events.On(eventEmitter, events.KindReadOnlyChanged)
+	defer events.Off(eventEmitter, events.KindReadOnlyChanged, ch)
+
+	for {
+		select {
+		case evt := <-ch:
+			e, ok := events.From(evt).(events.ReadOnlyChanged)
+			if !ok {
+				continue
+			}
+
+			banner := "\r\n=== Session is now read-write ===\r\n"
+			if e.ReadOnly {
+				banner = "\r\n=== Session is now read-only ===\r\n"
+			}
+			if _, err := io.WriteString(w, banner); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// hotkeyWriter intercepts the hotkeys of the host typed in w.
+type hotkeyWriter struct {
+	w    io.Writer
+	keys map[byte]func()
+
+	pending bool
+	buf     []byte
+}
+
+func (h *hotkeyWriter) Write(p []byte) (int, error) {
+	h.buf = h.buf[:0]
+	for _, b := range p {
+		if h.pending {
+			h.pending = false
+			if fn, ok := h.keys[b]; ok {
+				fn()
+				continue
+			}
+			// not a hotkey, pass the prefix through
+			h.buf = append(h.buf, hotkeyPrefix)
+			if b == hotkeyPrefix {
+				continue
+			}
+		} else if b == hotkeyPrefix {
+			h.pending = true
+			continue
+		}
+
+		h.buf = append(h.buf, b)
+	}
+
+	if len(h.buf) > 0 {
+		if _, err := h.w.Write(h.buf); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+)
+
+func Test_hotkeyWriter(t *testing.T) {
+	cases := []struct {
+		name    string
+		writes  []string
+		want    string
+		toggled int
+	}{
+		{name: "plain", writes: []string{"ls\r"}, want: "ls\r"},
+		{name: "hotkey", writes: []string{"a\x1drb"}, want: "ab", toggled: 1},
+		{name: "hotkey across writes", writes: []string{"a\x1d", "r", "b"}, want: "ab", toggled: 1},
+		{name: "escaped prefix", writes: []string{"\x1d\x1d"}, want: "\x1d"},
+		{name: "unknown key", writes: []string{"\x1dx"}, want: "\x1dx"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				buf     bytes.Buffer
+				toggled int
+			)
+			w := &hotkeyWriter{w: &buf, keys: map[byte]func(){hotkeyToggleReadOnly: func() { toggled++ }}}
+			for _, s := range c.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(s) {
+					t.Fatalf("want %d bytes written, got %d", len(s), n)
+				}
+			}
+
+			if got := buf.String(); got != c.want {
+				t.Fatalf("want=%q got=%q", c.want, got)
+			}
+			if toggled != c.toggled {
+				t.Fatalf("want %d toggles, got %d", c.toggled, toggled)
+			}
+		})
+	}
+}
+
+func Test_ReadOnly(t *testing.T) {
+	em := emitter.New(1)
+	ch := events.On(em, events.KindReadOnlyChanged)
+	defer events.Off(em, events.KindReadOnlyChanged, ch)
+
+	r := NewReadOnly(true, em)
+
+	var buf bytes.Buffer
+	w := r.Writer(&buf)
+	if _, err := w.Write([]byte("discarded")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Toggle()
+	if r.Get() {
+		t.Fatal("want read-write after toggle")
+	}
+	if _, err := w.Write([]byte("written")); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "written", buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	select {
+	case evt := <-ch:
+		if want, got := (events.ReadOnlyChanged{ReadOnly: false}), events.From(evt); want != got {
+			t.Fatalf("want=%v got=%v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want read-only changed event")
+	}
+
+	// setting the same mode emits nothing
+	r.Set(false)
+	select {
+	case evt := <-ch:
+		t.Fatalf("want no event, got %v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
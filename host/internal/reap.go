@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// startedCommands are the pids of commands started by the host that are yet to be waited for.
+// The orphan reaper must not reap them, or waiting for them would fail.
+var startedCommands = &commandTracker{pids: make(map[int]bool)}
+
+type commandTracker struct {
+	pids map[int]bool
+	mux  sync.Mutex
+}
+
+// Start starts cmd with backend and tracks it until it's waited for.
+// The reaper can't scan for orphans while a command is being started.
+func (t *commandTracker) Start(backend PtyBackend, cmd *exec.Cmd) (Pty, Process, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	ptmx, proc, err := backend.Start(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cmd.Process == nil {
+		return ptmx, proc, nil
+	}
+
+	pid := cmd.Process.Pid
+	t.pids[pid] = true
+
+	return ptmx, trackedProcess{Process: proc, done: func() { t.untrack(pid) }}, nil
+}
+
+// tracked reports whether pid is a command that is yet to be waited for. It must be called while locked.
+func (t *commandTracker) tracked(pid int) bool {
+	return t.pids[pid]
+}
+
+func (t *commandTracker) untrack(pid int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.pids, pid)
+}
+
+type trackedProcess struct {
+	Process
+	done func()
+}
+
+func (p trackedProcess) Wait() error {
+	defer p.done()
+	return p.Process.Wait()
+}
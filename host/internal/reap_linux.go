@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// shouldReapOrphans reports whether the host has to reap orphaned processes, i.e. it runs as the init process
+// of a container, e.g. 'docker run upterm host', where processes orphaned by the shared command are reparented to it.
+func shouldReapOrphans() bool {
+	return os.Getpid() == 1
+}
+
+// reapOrphans reaps orphaned processes that exit until ctx is done, so that they don't linger as zombies.
+func reapOrphans(ctx context.Context, logger log.FieldLogger) error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, unix.SIGCHLD)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ch:
+			reapZombies(logger)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// reapZombies reaps the exited children of the host that it didn't start. Commands started by the host are
+// waited for by their callers, and helpers it starts in its own session, e.g. tmux commands, are skipped too.
+func reapZombies(logger log.FieldLogger) {
+	self := os.Getpid()
+	sid, err := unix.Getsid(0)
+	if err != nil {
+		logger.WithError(err).Debug("error getting session")
+		return
+	}
+
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return
+	}
+
+	startedCommands.mux.Lock()
+	defer startedCommands.mux.Unlock()
+
+	for _, stat := range stats {
+		b, err := os.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+
+		p, err := parseProcStat(b)
+		if err != nil || p.ppid != self || p.state != 'Z' || p.sid == sid || startedCommands.tracked(p.pid) {
+			continue
+		}
+
+		var ws unix.WaitStatus
+		if _, err := unix.Wait4(p.pid, &ws, unix.WNOHANG, nil); err != nil {
+			logger.WithError(err).WithField("pid", p.pid).Debug("error reaping orphan")
+			continue
+		}
+		logger.WithField("pid", p.pid).Debug("reaped orphan")
+	}
+}
+
+type procStat struct {
+	pid   int
+	state byte
+	ppid  int
+	sid   int
+}
+
+// parseProcStat parses the pid, state, parent pid, and session of /proc/PID/stat, see proc(5).
+// The command name in parentheses may contain spaces and parentheses.
+func parseProcStat(b []byte) (procStat, error) {
+	open, end := bytes.IndexByte(b, '('), bytes.LastIndexByte(b, ')')
+	if open < 0 || end < open {
+		return procStat{}, fmt.Errorf("malformed stat %q", b)
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(b[:open])))
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed pid: %w", err)
+	}
+
+	// state ppid pgrp session ...
+	fields := bytes.Fields(b[end+1:])
+	if len(fields) < 4 || len(fields[0]) != 1 {
+		return procStat{}, fmt.Errorf("malformed stat %q", b)
+	}
+
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed ppid: %w", err)
+	}
+	sid, err := strconv.Atoi(string(fields[3]))
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed session: %w", err)
+	}
+
+	return procStat{pid: pid, state: fields[0][0], ppid: ppid, sid: sid}, nil
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_parseProcStat(t *testing.T) {
+	p, err := parseProcStat([]byte("42 (my (odd) cmd) Z 1 42 42 0 -1 4194564"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (procStat{pid: 42, state: 'Z', ppid: 1, sid: 42}); p != want {
+		t.Fatalf("want %+v, got %+v", want, p)
+	}
+
+	if _, err := parseProcStat([]byte("42 cmd")); err == nil {
+		t.Fatal("want error for malformed stat")
+	}
+}
+
+func Test_reapZombies(t *testing.T) {
+	// an orphan in another session, e.g. a daemon started by the shared command
+	orphan := exec.Command("true")
+	orphan.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := orphan.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// a command started by the host, which its caller waits for
+	started := exec.Command("true")
+	started.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	_, proc, err := startedCommands.Start(startBackend{}, started)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForZombie(t, orphan.Process.Pid)
+	waitForZombie(t, started.Process.Pid)
+
+	reapZombies(log.New())
+
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d", orphan.Process.Pid)); !os.IsNotExist(err) {
+		t.Fatalf("want orphan reaped, got %v", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("want started command waited for, got %v", err)
+	}
+}
+
+type startBackend struct{}
+
+func (startBackend) Name() string {
+	return "start"
+}
+
+func (startBackend) Start(c *exec.Cmd) (Pty, Process, error) {
+	return nil, c, c.Start()
+}
+
+func waitForZombie(t *testing.T, pid int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, err := parseProcStat(b); err == nil && p.state == 'Z' {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("process %d didn't exit", pid)
+}
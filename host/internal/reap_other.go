@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internal
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// shouldReapOrphans is only supported on Linux, where the host may run as the init process of a container.
+func shouldReapOrphans() bool {
+	return false
+}
+
+func reapOrphans(ctx context.Context, logger log.FieldLogger) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
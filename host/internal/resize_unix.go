@@ -0,0 +1,11 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// windowChangedSignals are the signals notifying the host's terminal is resized.
+var windowChangedSignals = []os.Signal{syscall.SIGWINCH}
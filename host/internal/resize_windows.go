@@ -0,0 +1,9 @@
+package internal
+
+import (
+	"os"
+)
+
+// windowChangedSignals are the signals notifying the host's terminal is resized.
+// Windows consoles don't signal resizes, so the terminal keeps its initial size.
+var windowChangedSignals = []os.Signal{}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// commandRestartDelay delays restarting a failed command, so that a command failing right away doesn't spin.
+	commandRestartDelay = time.Second
+
+	// the keys typed to restart the command or to end the session once it exited
+	respawnKeyRestart = 'r'
+	respawnKeyQuit    = 'q'
+	// respawnHeldMax bounds the input typed after restarting the command that is held until it's restarted.
+	respawnHeldMax = 4096
+	// respawnDrainTimeout bounds waiting for the output of a command that exited, which never ends if processes
+	// it started in the background keep its terminal open.
+	respawnDrainTimeout = time.Second
+)
+
+func newRespawnPty(pty Pty) *respawnPty {
+	p := &respawnPty{
+		cur:     pty,
+		drained: make(chan struct{}),
+		keys:    make(chan byte, 1),
+		done:    make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+// respawnPty is the terminal of a command that is restarted once it exits, so that the host and clients stay
+// attached to the same terminal across restarts. Reads wait for the command to be restarted once the terminal of
+// the command that exited is drained, and the input is scanned for the keys restarting the command or ending the
+// session while it's exited.
+type respawnPty struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	cur  Pty
+	// gen counts the restarts, so that reads know when the terminal is swapped.
+	gen    int
+	exited bool
+	closed bool
+	// awaitKeys scans the input for the keys while the command is exited. Once the command is restarted by the
+	// key, the input typed after it is held for the command restarted.
+	awaitKeys  bool
+	restarting bool
+	held       []byte
+	// h and w are the window of the terminal, which is applied to the terminals of restarted commands.
+	h, w int
+	// drained is closed once the output of the terminal of the command is read to the end.
+	drained chan struct{}
+
+	keys chan byte
+	done chan struct{}
+}
+
+// Unwrap returns the terminal of the command running.
+func (p *respawnPty) Unwrap() Pty {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.cur
+}
+
+func (p *respawnPty) Read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		cur, gen := p.cur, p.gen
+		p.mu.Unlock()
+
+		n, err := cur.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+
+		// the command exited, wait for it to be restarted
+		p.mu.Lock()
+		if p.gen == gen {
+			select {
+			case <-p.drained:
+			default:
+				close(p.drained)
+			}
+		}
+		for p.gen == gen && !p.closed {
+			p.cond.Wait()
+		}
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return 0, err
+		}
+	}
+}
+
+// Write writes the input to the command running. The input is discarded while the command is exited, except for
+// the keys restarting it or ending the session, and the input typed after restarting it.
+func (p *respawnPty) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if !p.exited {
+		cur := p.cur
+		p.mu.Unlock()
+		return cur.Write(b)
+	}
+	defer p.mu.Unlock()
+
+	rest := b
+	if !p.restarting {
+		if !p.awaitKeys {
+			return len(b), nil
+		}
+		i := bytes.IndexAny(b, string([]byte{respawnKeyRestart, respawnKeyQuit}))
+		if i < 0 {
+			return len(b), nil
+		}
+		select {
+		case p.keys <- b[i]:
+		default:
+		}
+		if b[i] != respawnKeyRestart {
+			return len(b), nil
+		}
+		p.restarting, rest = true, b[i+1:]
+	}
+	if room := respawnHeldMax - len(p.held); room > 0 {
+		p.held = append(p.held, rest[:min(len(rest), room)]...)
+	}
+
+	return len(b), nil
+}
+
+func (p *respawnPty) Setsize(h, w int) error {
+	p.mu.Lock()
+	p.h, p.w = h, w
+	cur := p.cur
+	p.mu.Unlock()
+
+	return cur.Setsize(h, w)
+}
+
+func (p *respawnPty) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		p.cond.Broadcast()
+	}
+	cur := p.cur
+	p.mu.Unlock()
+
+	return cur.Close()
+}
+
+// Exited discards the input until the command is restarted. awaitKeys scans it for the keys restarting the command
+// or ending the session meanwhile.
+func (p *respawnPty) Exited(awaitKeys bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.exited, p.awaitKeys = true, awaitKeys
+	// drop the keys typed before
+	select {
+	case <-p.keys:
+	default:
+	}
+}
+
+// WaitDrained waits for the output of the command that exited to be read, so that messages written after it follow
+// the output. It waits for timeout at most, and returns early if ctx is done or the terminal is closed.
+func (p *respawnPty) WaitDrained(ctx context.Context, timeout time.Duration) {
+	p.mu.Lock()
+	drained := p.drained
+	p.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-drained:
+	case <-t.C:
+	case <-p.done:
+	case <-ctx.Done():
+	}
+}
+
+// WaitRestart waits for the key restarting the command to be typed once it exited awaiting the keys. It returns
+// false if the key ending the session is typed, or if ctx is done or the terminal is closed first.
+func (p *respawnPty) WaitRestart(ctx context.Context) bool {
+	select {
+	case key := <-p.keys:
+		return key == respawnKeyRestart
+	case <-p.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Delay waits for d to restart the command. It returns false if ctx is done or the terminal is closed first.
+func (p *respawnPty) Delay(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-p.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Swap swaps in the terminal of the command restarted, sized to the window, and closes the terminal of the command
+// that exited. The input held is written to the command restarted.
+func (p *respawnPty) Swap(pty Pty) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return pty.Close()
+	}
+	if p.h > 0 && p.w > 0 {
+		_ = pty.Setsize(p.h, p.w)
+	}
+	old, held := p.cur, p.held
+	p.cur, p.exited, p.awaitKeys, p.restarting, p.held = pty, false, false, false, nil
+	p.drained = make(chan struct{})
+	p.gen++
+	p.cond.Broadcast()
+	if len(held) > 0 {
+		// written while locked to precede the input typed from now on
+		if _, err := pty.Write(held); err != nil {
+			p.mu.Unlock()
+			_ = old.Close()
+			return err
+		}
+	}
+	p.mu.Unlock()
+
+	return old.Close()
+}
+
+// describeExit describes how a command exited with the error of waiting for it.
+func describeExit(err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "exited"
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return fmt.Sprintf("exited with status %d", exitErr.ExitCode())
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("was terminated (%s)", exitErr)
+	default:
+		return fmt.Sprintf("failed: %s", err)
+	}
+}
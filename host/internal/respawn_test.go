@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// pipePty is a Pty whose output is written to out and whose input is read from in.
+type pipePty struct {
+	nopPty
+	r   *io.PipeReader
+	out *io.PipeWriter
+	in  chan []byte
+}
+
+func newPipePty() *pipePty {
+	r, w := io.Pipe()
+	return &pipePty{r: r, out: w, in: make(chan []byte, 10)}
+}
+
+func (p *pipePty) Read(b []byte) (int, error) { return p.r.Read(b) }
+func (p *pipePty) Write(b []byte) (int, error) {
+	p.in <- append([]byte(nil), b...)
+	return len(b), nil
+}
+func (p *pipePty) Close() error { return p.r.Close() }
+
+func Test_respawnPty(t *testing.T) {
+	first := newPipePty()
+	p := newRespawnPty(first)
+	if err := p.Setsize(24, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 32)
+		for {
+			n, err := p.Read(b)
+			if err != nil {
+				close(read)
+				return
+			}
+			read <- string(b[:n])
+		}
+	}()
+
+	_, _ = first.out.Write([]byte("first"))
+	if want, got := "first", <-read; want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	_, _ = p.Write([]byte("ls"))
+	if want, got := "ls", string(<-first.in); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the command fails and is restarted after a delay, discarding the input meanwhile
+	p.Exited(false)
+	_, _ = p.Write([]byte("r"))
+	if len(first.in) != 0 || len(p.keys) != 0 {
+		t.Fatal("expect the input discarded while the command is restarted")
+	}
+
+	// the command exits, and only the keys restarting it or ending the session are read
+	first.out.Close()
+	p.Exited(true)
+	// messages follow the output of the command once it's read to the end
+	start := time.Now()
+	p.WaitDrained(context.Background(), 5*time.Second)
+	if time.Since(start) >= 5*time.Second {
+		t.Fatal("expect the output of the command drained")
+	}
+	_, _ = p.Write([]byte("xyr"))
+	// the input typed after restarting the command is held for it
+	_, _ = p.Write([]byte("ls"))
+	if !p.WaitRestart(context.Background()) {
+		t.Fatal("expect the command restarted")
+	}
+	if len(first.in) != 0 {
+		t.Fatal("expect the input discarded while the command is exited")
+	}
+
+	// reads continue with the terminal of the command restarted, sized to the window
+	second := newPipePty()
+	if err := p.Swap(second); err != nil {
+		t.Fatal(err)
+	}
+	if second.h != 24 || second.w != 80 {
+		t.Fatalf("want the window 80x24, got %dx%d", second.w, second.h)
+	}
+	if want, got := "ls", string(<-second.in); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	_, _ = second.out.Write([]byte("second"))
+	if want, got := "second", <-read; want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	_, _ = p.Write([]byte("ls"))
+	if want, got := "ls", string(<-second.in); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	p.Exited(true)
+	_, _ = p.Write([]byte("q"))
+	if p.WaitRestart(context.Background()) {
+		t.Fatal("expect the session ended")
+	}
+
+	// reads end once the terminal is closed
+	second.out.Close()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-read:
+		if ok {
+			t.Fatal("expect reads ended")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect reads ended")
+	}
+	if p.Delay(context.Background(), time.Hour) {
+		t.Fatal("expect no restart once the terminal is closed")
+	}
+}
+
+func Test_describeExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{
+			want: "exited",
+		},
+		{
+			err:  exec.Command("sh", "-c", "exit 3").Run(),
+			want: "exited with status 3",
+		},
+		{
+			err:  exec.Command("sh", "-c", "kill -9 $$").Run(),
+			want: "was terminated (signal: killed)",
+		},
+		{
+			err:  errors.New("boom"),
+			want: "failed: boom",
+		},
+	}
+	for _, c := range cases {
+		if got := describeExit(c.err); got != c.want {
+			t.Fatalf("want=%q got=%q", c.want, got)
+		}
+	}
+}
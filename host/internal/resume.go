@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"net"
+	"sync"
+)
+
+// resumableListener accepts the connections of clients from the listener of the tunnel, staying open while a dropped
+// tunnel is re-established with another listener, so that the session of the host keeps running meanwhile.
+type resumableListener struct {
+	mu sync.Mutex
+	ln net.Listener
+	// changed is closed and replaced when ln is replaced or the listener fails.
+	changed chan struct{}
+	err     error
+}
+
+func newResumableListener(ln net.Listener) *resumableListener {
+	return &resumableListener{
+		ln:      ln,
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *resumableListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		ln, changed, err := l.ln, l.changed, l.err
+		l.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := ln.Accept()
+		if err == nil {
+			return conn, nil
+		}
+
+		// the tunnel dropped, wait for it to be re-established
+		<-changed
+	}
+}
+
+// resume accepts connections from ln of the re-established tunnel.
+func (l *resumableListener) resume(ln net.Listener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		_ = ln.Close()
+		return
+	}
+	l.ln = ln
+	l.notifyLocked()
+}
+
+// fail fails Accept with err once the tunnel isn't re-established.
+func (l *resumableListener) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err == nil {
+		l.err = err
+		l.notifyLocked()
+	}
+}
+
+func (l *resumableListener) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *resumableListener) Close() error {
+	l.mu.Lock()
+	ln := l.ln
+	l.mu.Unlock()
+
+	l.fail(net.ErrClosed)
+
+	return ln.Close()
+}
+
+func (l *resumableListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ln.Addr()
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_resumableListener(t *testing.T) {
+	ln1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := newResumableListener(ln1)
+	defer rl.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := rl.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	// the tunnel drops
+	ln1.Close()
+	select {
+	case err := <-accepted:
+		t.Fatalf("want Accept to wait for the tunnel re-established, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.resume(ln2)
+	if want, got := ln2.Addr(), rl.Addr(); want != got {
+		t.Fatalf("want addr %s, got %s", want, got)
+	}
+
+	conn, err := net.Dial("tcp", ln2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the connection accepted from the re-established tunnel")
+	}
+
+	// the tunnel drops and isn't re-established
+	errNotResumed := errors.New("not resumed")
+	go func() {
+		_, err := rl.Accept()
+		accepted <- err
+	}()
+	ln2.Close()
+	rl.fail(errNotResumed)
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, errNotResumed) {
+			t.Fatalf("want %v, got %v", errNotResumed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want Accept to fail")
+	}
+}
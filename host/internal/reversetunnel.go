@@ -2,16 +2,20 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os/user"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/owenthereal/upterm/host/api"
 	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/upterm"
+	"github.com/owenthereal/upterm/utils"
 	"github.com/owenthereal/upterm/ws"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -20,43 +24,148 @@ import (
 
 const (
 	publickeyAuthError = "ssh: unable to authenticate, attempted methods [none]"
+	// reconnectInitialBackoff and reconnectMaxBackoff bound the backoff of re-establishing dropped tunnels.
+	reconnectInitialBackoff = time.Second
+	reconnectMaxBackoff     = 15 * time.Second
+	// serverDialTimeout caps connecting to ssh servers and their handshakes, e.g. when a firewall drops the
+	// packets to the port.
+	serverDialTimeout = 30 * time.Second
 )
 
 type ReverseTunnel struct {
 	*ssh.Client
 
-	Host              *url.URL
-	Signers           []ssh.Signer
+	Host    *url.URL
+	Signers []ssh.Signer
+	// HostSigners are the host keys of the sshd of the host, sent as the host public keys of the session.
+	// They default to Signers.
+	HostSigners       []ssh.Signer
 	AuthorizedKeys    []ssh.PublicKey
 	KeepAliveDuration time.Duration
 	HostKeyCallback   ssh.HostKeyCallback
-	Logger            log.FieldLogger
+	// AgreePolicy is called with the server policy if the server has one. It returns nil if the host agrees.
+	AgreePolicy func(*server.GetPolicyResponse) error
+	// LimitRate caps the bytes per second written to the tunnel if it's positive.
+	// Interactive traffic is prioritized over bulk traffic within the limit.
+	LimitRate int64
+	// TCPTuning sets the socket options of the TCP connection to the server if it's non-nil. Connections through
+	// jump hosts or over WebSocket aren't tuned.
+	TCPTuning *utils.TCPTuning
+	// StrictCrypto restricts the connections to the server and the jump hosts to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// Labels describe the session, e.g. env=prod, in the notifications and the audit log of the server.
+	Labels map[string]string
+	// RecordingOptOut asks the server not to record the session in its audit log, which it honors if the host is
+	// granted to opt out.
+	RecordingOptOut bool
+	// Queue asks the server to wait for capacity in its session queue if it's at capacity instead of refusing the
+	// session. QueuePosition is called with the position of the host and the length of the queue whenever they change.
+	Queue         bool
+	QueuePosition func(pos, length int)
+	// ReconnectTimeout re-establishes the tunnel if its connection drops, resuming the session on the server,
+	// retrying with exponential backoff for up to the duration. Clients reconnect once it's resumed. The session
+	// ends with the connection if it's zero or the server doesn't keep the sessions of dropped hosts.
+	ReconnectTimeout time.Duration
+	// JumpHosts are the SSH servers hopped through in order to reach the server. Jump hosts authenticate
+	// with Signers and are verified with HostKeyCallback. Users default to the current user.
+	JumpHosts []*url.URL
+	Logger    log.FieldLogger
 
-	ln net.Listener
+	ln    net.Listener
+	jumps []*ssh.Client
+
+	mu            sync.Mutex
+	closed        bool
+	endedReason   string
+	serverHostKey ssh.PublicKey
+	authKey       ssh.PublicKey
 }
 
 func (c *ReverseTunnel) Close() {
+	c.mu.Lock()
+	c.closed = true
+	client, jumps := c.Client, c.jumps
+	c.mu.Unlock()
+
 	c.ln.Close()
-	c.Client.Close()
+	client.Close()
+	closeJumpClients(jumps)
 }
 
+// Listener returns the listener of the connections of clients forwarded by the server. It stays open while the
+// tunnel is re-established if ReconnectTimeout is set.
 func (c *ReverseTunnel) Listener() net.Listener {
 	return c.ln
 }
 
 func (c *ReverseTunnel) Establish(ctx context.Context) (*server.CreateSessionResponse, error) {
-	user, err := user.Current()
+	sessResp, ln, err := c.connect(nil)
 	if err != nil {
 		return nil, err
 	}
 
+	c.ln = ln
+	if c.ReconnectTimeout > 0 && sessResp.ResumeToken != "" {
+		rl := newResumableListener(ln)
+		c.ln = rl
+		go c.resume(ctx, rl, sessResp)
+	}
+
+	// make sure connection is alive
+	go keepAlive(ctx, c.KeepAliveDuration, c.ping)
+
+	return sessResp, nil
+}
+
+// ping pings the server. Connections the server doesn't reply on within KeepAliveDuration are closed if the tunnel
+// is re-established when it drops, so that connections dropped silently, e.g. by a NAT, are noticed.
+func (c *ReverseTunnel) ping() {
+	client := c.client()
+	errc := make(chan error, 1)
+	go func() {
+		// TODO: ping with session ID
+		_, _, err := client.SendRequest(upterm.OpenSSHKeepAliveRequestType, true, nil)
+		errc <- err
+	}()
+
+	var timeout <-chan time.Time
+	if c.ReconnectTimeout > 0 {
+		timeout = time.After(c.KeepAliveDuration)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			c.Logger.WithError(err).Error("error pinging server")
+		}
+	case <-timeout:
+		c.Logger.Warn("The server didn't reply to ping, reconnecting")
+		client.Close()
+	}
+}
+
+// connect connects to the server and creates the session, or resumes the session of resume if it's non-nil. It
+// returns the listener of the connections of clients to the session.
+func (c *ReverseTunnel) connect(resume *server.CreateSessionResponse) (*server.CreateSessionResponse, net.Listener, error) {
+	user, err := user.Current()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var (
 		auths          []ssh.AuthMethod
+		serverAuths    []ssh.AuthMethod
 		publicKeys     [][]byte
 		authorizedKeys [][]byte
 	)
 	for _, signer := range c.Signers {
 		auths = append(auths, ssh.PublicKeys(signer))
+		serverAuths = append(serverAuths, ssh.PublicKeys(recordAuthKey(signer, c.setAuthKey)))
+	}
+	hostSigners := c.HostSigners
+	if len(hostSigners) == 0 {
+		hostSigners = c.Signers
+	}
+	for _, signer := range hostSigners {
 		publicKeys = append(publicKeys, ssh.MarshalAuthorizedKey(signer.PublicKey()))
 	}
 	for _, ak := range c.AuthorizedKeys {
@@ -69,12 +178,12 @@ func (c *ReverseTunnel) Establish(ctx context.Context) (*server.CreateSessionRes
 	}
 	encodedID, err := api.EncodeIdentifier(id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	config := &ssh.ClientConfig{
 		User:          encodedID,
-		Auth:          auths,
+		Auth:          serverAuths,
 		ClientVersion: upterm.HostSSHClientVersion,
 		// Enforce a restricted set of algorithms for security
 		// TODO: make this configurable if necessary
@@ -88,53 +197,332 @@ func (c *ReverseTunnel) Establish(ctx context.Context) (*server.CreateSessionRes
 		},
 		HostKeyCallback: c.HostKeyCallback,
 	}
+	if c.HostKeyCallback != nil {
+		config.HostKeyCallback = c.verifyServerHostKey
+	}
+	if c.StrictCrypto {
+		config.Config = utils.StrictCryptoConfig()
+	}
 
-	if isWSScheme(c.Host.Scheme) {
+	var conn net.Conn
+	switch {
+	case isWSScheme(c.Host.Scheme) && len(c.JumpHosts) > 0:
+		return nil, nil, fmt.Errorf("jump hosts are not supported with %s servers", c.Host.Scheme)
+	case isWSScheme(c.Host.Scheme):
 		u, _ := url.Parse(c.Host.String()) // clone
 		u.User = url.UserPassword(encodedID, "")
-		c.Client, err = ws.NewSSHClient(u, config, false)
-	} else {
-		c.Client, err = ssh.Dial("tcp", c.Host.Host, config)
+		conn, err = ws.NewWSConn(u, false)
+	case len(c.JumpHosts) > 0:
+		// errors of jump hosts are reported with the jump hosts
+		if conn, err = c.dialJumpHosts(auths, user.Username); err != nil {
+			return nil, nil, err
+		}
+	default:
+		conn, err = net.DialTimeout("tcp", c.Host.Host, serverDialTimeout)
+		if err != nil {
+			return nil, nil, &UnreachableError{host: c.Host.String(), err: err}
+		}
+		_ = conn.SetDeadline(time.Now().Add(serverDialTimeout))
+		if c.TCPTuning != nil {
+			// the options are optimizations, so the tunnel works without them
+			if err := c.TCPTuning.Apply(conn); err != nil {
+				c.Logger.WithError(err).Warn("error tuning tcp connection to the server")
+			}
+		}
 	}
-
 	if err != nil {
-		return nil, sshDialError(c.Host.String(), err)
+		return nil, nil, sshDialError(c.Host.String(), err)
 	}
 
-	sessResp, err := c.createSession(user.Username, publicKeys, authorizedKeys)
+	cc, chans, reqs, err := ssh.NewClientConn(conn, c.Host.Host, config)
 	if err != nil {
-		return nil, fmt.Errorf("error creating session: %w", err)
+		conn.Close()
+		closeJumpClients(c.jumps)
+		if c.Host.Scheme == "ssh" && len(c.JumpHosts) == 0 && isTransportError(err) {
+			return nil, nil, &UnreachableError{host: c.Host.String(), err: err}
+		}
+		return nil, nil, sshDialError(c.Host.String(), err)
+	}
+	if c.Host.Scheme == "ssh" && len(c.JumpHosts) == 0 {
+		_ = conn.SetDeadline(time.Time{})
 	}
+	client := ssh.NewClient(cc, chans, c.handleServerRequests(reqs))
+	c.mu.Lock()
+	closed := c.closed
+	c.Client = client
+	c.mu.Unlock()
+	if closed {
+		client.Close()
+		return nil, nil, net.ErrClosed
+	}
+	c.logAuthKeys()
+
+	var sessResp *server.CreateSessionResponse
+	if resume != nil {
+		if sessResp, err = c.resumeSession(user.Username, resume); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("error resuming session: %w", err)
+		}
+	} else {
+		policyHash, err := c.agreePolicy()
+		if err != nil {
+			return nil, nil, err
+		}
 
-	c.ln, err = c.Client.Listen("unix", sessResp.SessionID)
+		if sessResp, err = c.createSession(user.Username, publicKeys, authorizedKeys, policyHash); err != nil {
+			return nil, nil, fmt.Errorf("error creating session: %w", err)
+		}
+	}
+
+	ln, err := client.Listen("unix", sessResp.SessionID)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create reverse tunnel: %w", err)
+		return nil, nil, fmt.Errorf("unable to create reverse tunnel: %w", err)
+	}
+	if c.LimitRate > 0 {
+		ln = shapedListener{Listener: ln, shaper: newShaper(c.LimitRate)}
 	}
 
-	// make sure connection is alive
-	go keepAlive(ctx, c.KeepAliveDuration, func() {
-		// TODO: ping with session ID
-		_, _, err := c.Client.SendRequest(upterm.OpenSSHKeepAliveRequestType, true, nil)
+	return sessResp, ln, nil
+}
+
+// resume re-establishes the tunnel whenever its connection drops, resuming the session on the server, until ctx
+// is done, the tunnel is closed, the server ends the session, or the session can't be resumed within
+// ReconnectTimeout. ln fails once the tunnel isn't re-established.
+func (c *ReverseTunnel) resume(ctx context.Context, ln *resumableListener, sess *server.CreateSessionResponse) {
+	logger := c.Logger.WithField("session", sess.SessionID)
+
+	for {
+		err := c.client().Wait()
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed || ctx.Err() != nil || c.EndedReason() != "" {
+			ln.fail(net.ErrClosed)
+			return
+		}
+
+		logger.WithError(err).Warn("Lost connection to the server, reconnecting")
+		next, err := c.reconnect(ctx, sess, logger)
 		if err != nil {
-			c.Logger.WithError(err).Error("error pinging server")
+			logger.WithError(err).Error("Unable to resume the session")
+			ln.fail(err)
+			return
+		}
+		ln.resume(next)
+		logger.Info("Resumed the session")
+	}
+}
+
+// reconnect connects to the server and resumes the session, retrying with exponential backoff within
+// ReconnectTimeout. Sessions the server refuses to resume, e.g. after its resume grace, aren't retried.
+func (c *ReverseTunnel) reconnect(ctx context.Context, sess *server.CreateSessionResponse, logger log.FieldLogger) (net.Listener, error) {
+	deadline := time.Now().Add(c.ReconnectTimeout)
+	backoff := reconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		c.mu.Lock()
+		jumps := c.jumps
+		c.jumps = nil
+		c.mu.Unlock()
+		closeJumpClients(jumps)
+
+		_, ln, err := c.connect(sess)
+		if err == nil {
+			return ln, nil
+		}
+		if errors.Is(err, server.ErrSessionNotResumable) || errors.Is(err, net.ErrClosed) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		logger.WithError(err).WithFields(log.Fields{"attempt": attempt, "backoff": backoff}).Warn("Error reconnecting to the server, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+func (c *ReverseTunnel) client() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Client
+}
+
+// dialJumpHosts connects to the server through the jump hosts, reusing the auth methods of the server.
+func (c *ReverseTunnel) dialJumpHosts(auths []ssh.AuthMethod, username string) (net.Conn, error) {
+	// drop the domain of Windows usernames, e.g. DOMAIN\user
+	if i := strings.LastIndex(username, `\`); i >= 0 {
+		username = username[i+1:]
+	}
+
+	conn, jumps, err := dialJumpHosts(c.JumpHosts, c.Host.Host, func(user string) *ssh.ClientConfig {
+		if user == "" {
+			user = username
+		}
+
+		jc := &ssh.ClientConfig{
+			User:            user,
+			Auth:            auths,
+			HostKeyCallback: c.HostKeyCallback,
+		}
+		if c.StrictCrypto {
+			jc.Config = utils.StrictCryptoConfig()
+		}
+
+		return jc
 	})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.jumps = jumps
+	c.mu.Unlock()
 
-	return sessResp, nil
+	return conn, nil
+}
+
+// handleServerRequests records why the server ends the session and passes on the other global requests of the server.
+func (c *ReverseTunnel) handleServerRequests(in <-chan *ssh.Request) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+
+		for req := range in {
+			switch req.Type {
+			case upterm.ServerSessionEndedRequestType:
+				c.mu.Lock()
+				c.endedReason = string(req.Payload)
+				c.mu.Unlock()
+
+				// acknowledge the reason, so that the server closes the connection after it's recorded
+				_ = req.Reply(true, nil)
+			case upterm.ServerQueuePositionRequestType:
+				var pos server.QueuePosition
+				if err := proto.Unmarshal(req.Payload, &pos); err != nil {
+					c.Logger.WithError(err).Error("error unmarshaling queue position")
+					continue
+				}
+				c.Logger.WithFields(log.Fields{"position": pos.Position, "length": pos.Length}).Info("Waiting for the server to have capacity")
+				if c.QueuePosition != nil {
+					c.QueuePosition(int(pos.Position), int(pos.Length))
+				}
+			default:
+				out <- req
+			}
+		}
+	}()
+
+	return out
+}
+
+// verifyServerHostKey verifies the host key of the server with HostKeyCallback, and keeps it for ServerHostKey.
+func (c *ReverseTunnel) verifyServerHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if err := c.HostKeyCallback(hostname, remote, key); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serverHostKey = key
+
+	return nil
+}
+
+// ServerHostKey returns the verified host key of the server, or nil if the tunnel isn't established.
+func (c *ReverseTunnel) ServerHostKey() ssh.PublicKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.serverHostKey
+}
+
+func (c *ReverseTunnel) setAuthKey(key ssh.PublicKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authKey = key
+}
+
+// AuthKey returns the key of Signers the server accepted, e.g. a cert, or nil if the tunnel isn't established or
+// the key isn't known.
+func (c *ReverseTunnel) AuthKey() ssh.PublicKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authKey
+}
+
+// logAuthKeys logs the key the host authenticated with and the host key the server was verified with, which tell
+// apart the keys of hosts with many keys, e.g. in an agent, and expose certs about to expire.
+func (c *ReverseTunnel) logAuthKeys() {
+	logger := c.Logger
+	if key := c.AuthKey(); key != nil {
+		logger = logger.WithField("auth_key", utils.DescribeKey(key, time.Now()))
+	}
+	if key := c.ServerHostKey(); key != nil {
+		logger = logger.WithField("server_host_key", utils.DescribeKey(key, time.Now()))
+	}
+	logger.Info("Authenticated to the server")
+}
+
+// EndedReason returns why the server ended the session, e.g. under memory pressure,
+// or empty if the server hasn't told.
+func (c *ReverseTunnel) EndedReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.endedReason
+}
+
+// agreePolicy gets the server policy and asks the host to agree to it.
+// It returns the hash of the agreed policy, or empty if the server has no policy.
+func (c *ReverseTunnel) agreePolicy() (string, error) {
+	ok, body, err := c.client().SendRequest(upterm.ServerPolicyRequestType, true, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting server policy: %w", err)
+	}
+	if !ok {
+		// the server doesn't support policies
+		return "", nil
+	}
+
+	var policy server.GetPolicyResponse
+	if err := proto.Unmarshal(body, &policy); err != nil {
+		return "", fmt.Errorf("error unmarshaling server policy: %w", err)
+	}
+	if policy.Text == "" {
+		return "", nil
+	}
+
+	if c.AgreePolicy == nil {
+		return "", server.ErrPolicyNotAgreed
+	}
+	if err := c.AgreePolicy(&policy); err != nil {
+		return "", err
+	}
+
+	return policy.Hash, nil
 }
 
-func (c *ReverseTunnel) createSession(user string, hostPublicKeys [][]byte, clientAuthorizedKeys [][]byte) (*server.CreateSessionResponse, error) {
+func (c *ReverseTunnel) createSession(user string, hostPublicKeys [][]byte, clientAuthorizedKeys [][]byte, policyHash string) (*server.CreateSessionResponse, error) {
 	req := &server.CreateSessionRequest{
 		HostUser:             user,
 		HostPublicKeys:       hostPublicKeys,
 		ClientAuthorizedKeys: clientAuthorizedKeys,
+		PolicyHash:           policyHash,
+		Labels:               c.Labels,
+		RecordingOptOut:      c.RecordingOptOut,
+		Queue:                c.Queue,
 	}
 	b, err := proto.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	ok, body, err := c.Client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	ok, body, err := c.client().SendRequest(upterm.ServerCreateSessionRequestType, true, b)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing session: %w", err)
 	}
@@ -150,6 +538,78 @@ func (c *ReverseTunnel) createSession(user string, hostPublicKeys [][]byte, clie
 	return &resp, nil
 }
 
+// resumeSession reclaims the session of sess on the server after the connection of the host dropped.
+func (c *ReverseTunnel) resumeSession(user string, sess *server.CreateSessionResponse) (*server.CreateSessionResponse, error) {
+	b, err := proto.Marshal(&server.CreateSessionRequest{
+		HostUser:        user,
+		RecordingOptOut: c.RecordingOptOut,
+		ResumeSessionId: sess.SessionID,
+		ResumeToken:     sess.ResumeToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ok, body, err := c.client().SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if string(body) == server.ErrSessionNotResumable.Error() {
+			return nil, server.ErrSessionNotResumable
+		}
+		return nil, fmt.Errorf("server refused to resume session: %s", body)
+	}
+
+	var resp server.CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling resumed session: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// UpdateAuthorizedKeys replaces the client authorized keys of the session on the server, which refuses clients
+// with other keys before they reach the host. Servers predating the update reject it.
+func (c *ReverseTunnel) UpdateAuthorizedKeys(keys []ssh.PublicKey) error {
+	var b []byte
+	for _, k := range keys {
+		b = append(b, ssh.MarshalAuthorizedKey(k)...)
+	}
+
+	ok, body, err := c.client().SendRequest(upterm.ServerUpdateAuthorizedKeysRequestType, true, b)
+	if err != nil {
+		return fmt.Errorf("error updating authorized keys: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("server refused to update authorized keys: %s", body)
+	}
+
+	return nil
+}
+
+// IssueJoinToken registers a one-time invite token with the server, which lets a client with a key that isn't
+// authorized join the session once with it until expiresAt. Servers predating invites reject it.
+func (c *ReverseTunnel) IssueJoinToken(token string, expiresAt time.Time) error {
+	b, err := proto.Marshal(&server.IssueJoinTokenRequest{
+		Token:     token,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling join token: %w", err)
+	}
+
+	ok, body, err := c.client().SendRequest(upterm.ServerIssueJoinTokenRequestType, true, b)
+	if err != nil {
+		return fmt.Errorf("error issuing join token: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("server refused to issue join token: %s", body)
+	}
+
+	return nil
+}
+
 func keepAlive(ctx context.Context, d time.Duration, fn func()) {
 	ticker := time.NewTicker(d)
 	defer ticker.Stop()
@@ -179,6 +639,26 @@ func (e *PermissionDeniedError) Error() string {
 
 func (e *PermissionDeniedError) Unwrap() error { return e.err }
 
+// UnreachableError is returned by Establish when the server can't be reached over SSH, e.g. when connecting times
+// out or a firewall resets the connection, as opposed to the server refusing the host.
+type UnreachableError struct {
+	host string
+	err  error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s is unreachable over ssh: %s", e.host, e.err)
+}
+
+func (e *UnreachableError) Unwrap() error { return e.err }
+
+// isTransportError reports whether err of a handshake is a failure of the connection rather than of the
+// negotiation, e.g. a mismatching host key.
+func isTransportError(err error) bool {
+	var nerr net.Error
+	return errors.Is(err, io.EOF) || errors.As(err, &nerr)
+}
+
 func sshDialError(host string, err error) error {
 	if strings.Contains(err.Error(), publickeyAuthError) {
 		return &PermissionDeniedError{
@@ -189,3 +669,45 @@ func sshDialError(host string, err error) error {
 
 	return fmt.Errorf("ssh dial error: %w", err)
 }
+
+// recordAuthKey returns signer calling signed with its key when it signs an authentication request. The server
+// accepted the last key signing before the authentication succeeds, since clients only sign with keys the server
+// is willing to accept. Signers without algorithm negotiation aren't recorded, since wrapping them would change the
+// algorithms they sign with.
+func recordAuthKey(signer ssh.Signer, signed func(ssh.PublicKey)) ssh.Signer {
+	as, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return signer
+	}
+
+	s := authKeySigner{AlgorithmSigner: as, signed: signed}
+	if ms, ok := signer.(ssh.MultiAlgorithmSigner); ok {
+		return authKeyMultiSigner{authKeySigner: s, algorithms: ms.Algorithms()}
+	}
+
+	return s
+}
+
+type authKeySigner struct {
+	ssh.AlgorithmSigner
+	signed func(ssh.PublicKey)
+}
+
+func (s authKeySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.signed(s.PublicKey())
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s authKeySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.signed(s.PublicKey())
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type authKeyMultiSigner struct {
+	authKeySigner
+	algorithms []string
+}
+
+func (s authKeyMultiSigner) Algorithms() []string {
+	return s.algorithms
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/owenthereal/upterm/upterm"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_ReverseTunnel_handleServerRequests(t *testing.T) {
+	c := &ReverseTunnel{}
+
+	in := make(chan *ssh.Request)
+	out := c.handleServerRequests(in)
+
+	go func() {
+		in <- &ssh.Request{Type: "keepalive@openssh.com"}
+		in <- &ssh.Request{Type: upterm.ServerSessionEndedRequestType, Payload: []byte("the server operator ended the session")}
+		close(in)
+	}()
+
+	var forwarded []string
+	for req := range out {
+		forwarded = append(forwarded, req.Type)
+	}
+
+	if len(forwarded) != 1 || forwarded[0] != "keepalive@openssh.com" {
+		t.Fatalf("want other requests forwarded, got %v", forwarded)
+	}
+	if want, got := "the server operator ended the session", c.EndedReason(); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
+
+func Test_ReverseTunnel_unreachable(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	// a firewall accepting the connection but cutting it off
+	reset, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reset.Close()
+	go func() {
+		for {
+			conn, err := reset.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	for _, addr := range []string{closed.Addr().String(), reset.Addr().String()} {
+		c := &ReverseTunnel{Host: &url.URL{Scheme: "ssh", Host: addr}, HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+
+		_, err := c.Establish(context.Background())
+		var uerr *UnreachableError
+		if !errors.As(err, &uerr) {
+			t.Fatalf("want the server at %s unreachable, got %v", addr, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -10,11 +11,15 @@ import (
 	"time"
 
 	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/events"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/routing"
 	"github.com/owenthereal/upterm/server"
 	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/oklog/run"
 	"github.com/olebedev/emitter"
 	uio "github.com/owenthereal/upterm/io"
@@ -23,21 +28,115 @@ import (
 )
 
 type Server struct {
-	Command           []string
-	CommandEnv        []string
-	ForceCommand      []string
-	Signers           []ssh.Signer
-	AuthorizedKeys    []ssh.PublicKey
+	Command      []string
+	CommandEnv   []string
+	ForceCommand []string
+	Signers      []ssh.Signer
+	// AuthorizedKeys are the keys of clients allowed to join. Any client may join if it has no keys,
+	// except for direct clients.
+	AuthorizedKeys    *AuthorizedKeys
 	EventEmitter      *emitter.Emitter
 	KeepAliveDuration time.Duration
 	Stdin             *os.File
 	Stdout            *os.File
 	Logger            log.FieldLogger
-	ReadOnly          bool
+	ReadOnly          *ReadOnly
+	StartAt           time.Time
+	// DirectListener serves clients connecting directly to the host without relaying through the server.
+	// Clients are authenticated with plain public keys against AuthorizedKeys.
+	DirectListener net.Listener
+	Stats          *Stats
+	// Menu lets clients choose a command to run in a dedicated PTY instead of attaching to Command.
+	Menu []*api.MenuItem
+	// PtyBackend attaches Command, ForceCommand, and Menu commands to terminals. It defaults to a pseudo terminal of the OS.
+	PtyBackend PtyBackend
+	// JoinTokens redeems one-time tokens of clients joining with usernames carrying them.
+	JoinTokens *JoinTokens
+	// SFTP lets clients transfer files with the host. It's ignored with ForceCommand or Menu,
+	// which restrict clients to commands.
+	SFTP bool
+	// Exec lets clients transfer files with the host with scp and rsync -e ssh, which exec their server side
+	// without a terminal. Other commands are refused. It's ignored with ForceCommand or Menu, which restrict
+	// clients to commands.
+	Exec bool
+	// TransferCompression compresses file transfers over SFTP and exec channels with zstd at the level
+	// for clients agreeing to api.FeatureCompression if it's non-zero. The terminal is never compressed.
+	TransferCompression zstd.EncoderLevel
+	// MaxClients caps the clients attached to the terminal at once if it's positive. Clients joining a full session
+	// are disconnected with a banner.
+	MaxClients int
+	// Recorded is whether the server records the session, which is announced to clients displaying recording notices.
+	Recorded bool
+	// PortForwarding lets clients forward connections to loopback ports of the host, e.g. with ssh -L.
+	// It's ignored with ForceCommand or Menu, which restrict clients to commands.
+	PortForwarding bool
+	// ForwardedPorts are the loopback ports of the host clients may forward connections to regardless of
+	// PortForwarding, e.g. of a local dev web server published by the host.
+	ForwardedPorts []uint32
+	// StrictCrypto restricts SSH connections to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// ClientIdleTimeout disconnects clients that haven't typed for the duration if it's positive.
+	ClientIdleTimeout time.Duration
+	// Identities resolves the display names of clients if it's non-nil. Names resolved by the server
+	// are used for keys it doesn't know.
+	Identities identity.Resolver
+	// Timer shows the time of the session in the terminal titles of the host and clients if it's non-nil.
+	Timer *SessionTimer
+	// StatusLine shows the number of clients connected and their fingerprints on the last line of the terminal of
+	// the host, updated as they join and leave.
+	StatusLine bool
+	// Attribution attributes the input of the host and clients into the terminal if it's non-nil.
+	Attribution *InputAttribution
+	// ShowTyping prefixes the status line with the ID of the client typing, if StatusLine is set.
+	ShowTyping bool
+	// RestartCommand restarts the command when it fails instead of ending the session.
+	RestartCommand bool
+	// KeepOnExit keeps the session once the command exits until the host or a client types r to restart it or q
+	// to end the session.
+	KeepOnExit bool
+	// Output streams the output and the window of the terminal read-only if it's non-nil.
+	Output *OutputStream
+	// Approval keeps clients waiting until they are approved if it's non-nil.
+	Approval *Approval
+	// EvictGhostsAfter disconnects clients sending nothing for the duration, not even replies to keepalives,
+	// if it's positive.
+	EvictGhostsAfter time.Duration
+	// OutputCoalesceDelay coalesces the small writes of the output to clients for up to the duration if it's
+	// positive, sending fewer and larger packets. See uio.CoalescingWriter.
+	OutputCoalesceDelay time.Duration
+	// Idle records the input of the host and clients as activity of the session if it's non-nil.
+	Idle *SessionIdle
+	// Conns registers the connections of clients, so that the host can kick them, if it's non-nil.
+	Conns *ClientConns
+	// Name describes the session to clients attaching to the terminal if it's non-empty.
+	Name string
+	// Activity shows clients of read-only sessions when the host types and when the output stalls if it's non-nil.
+	Activity *Activity
+	// PasteThreshold holds pastes of clients into the terminal larger than the bytes, and bracketed pastes with
+	// escape sequences, until the host confirms them with hotkeys if it's positive.
+	PasteThreshold int
+	// Viewers lets clients that only watch the session join, e.g. over the telnet bridge of the server. Their input
+	// and window sizes are ignored.
+	Viewers bool
+	// SizePolicy resolves the windows of ptys attached to the terminals of the host and clients. It defaults to
+	// sizing them to the smallest terminals.
+	SizePolicy *SizePolicy
 }
 
 func (s *Server) ServeWithContext(ctx context.Context, l net.Listener) error {
-	writers := uio.NewMultiWriter(5)
+	// record the terminal capabilities used by the command to advise clients whose terminals lack them
+	usage := &termUsage{}
+	writers := uio.NewMultiWriter(5, usage)
+	if s.Stats == nil {
+		s.Stats = NewStats(time.Now())
+	}
+	if s.ReadOnly == nil {
+		s.ReadOnly = NewReadOnly(false, s.EventEmitter)
+	}
+
+	if s.PtyBackend == nil {
+		s.PtyBackend = ptyBackend{}
+	}
 
 	cmdCtx, cmdCancel := context.WithCancel(ctx)
 	defer cmdCancel()
@@ -50,16 +149,49 @@ func (s *Server) ServeWithContext(ctx context.Context, l net.Listener) error {
 		s.EventEmitter,
 		writers,
 	)
+	cmd.backend = s.PtyBackend
+	privacy := NewPrivacy(s.EventEmitter)
+	cmd.privacy = privacy
+	cmd.timer = s.Timer
+	cmd.idle = s.Idle
+	cmd.activity = s.Activity
+	cmd.attribution = s.Attribution
+	cmd.restart = s.RestartCommand
+	cmd.keepOnExit = s.KeepOnExit
+	cmd.hotkeys = map[byte]func(){
+		hotkeyToggleReadOnly: s.ReadOnly.Toggle,
+		hotkeyTogglePrivacy:  privacy.Toggle,
+	}
+	paste := newPasteGuard(s.PasteThreshold, s.Stdout)
+	if paste != nil {
+		cmd.hotkeys[hotkeyAcceptPaste] = paste.Accept
+		cmd.hotkeys[hotkeyRejectPaste] = paste.Reject
+	}
 	ptmx, err := cmd.Start(cmdCtx)
 	if err != nil {
 		return fmt.Errorf("error starting command: %w", err)
 	}
+	if s.Output != nil {
+		ptmx = s.Output.Pty(ptmx)
+		cmd.ptmx = ptmx
+		if err := writers.Append(s.Output); err != nil {
+			return err
+		}
+	}
+	if s.StatusLine && s.Stdout != nil {
+		var attribution *InputAttribution
+		if s.ShowTyping {
+			attribution = s.Attribution
+		}
+		cmd.status = newStatusLine(s.Stdout, s.EventEmitter, attribution)
+	}
 
 	var g run.Group
 	{
 		ctx, cancel := context.WithCancel(ctx)
 		teh := terminalEventHandler{
 			eventEmitter: s.EventEmitter,
+			sizePolicy:   s.SizePolicy,
 			logger:       s.Logger,
 		}
 		g.Add(func() error {
@@ -75,38 +207,97 @@ func (s *Server) ServeWithContext(ctx context.Context, l net.Listener) error {
 			cmdCancel()
 		})
 	}
+	if cmd.status != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return cmd.status.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if shouldReapOrphans() {
+		// reap processes orphaned by the command, which are reparented to the host running as init
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return reapOrphans(ctx, s.Logger.WithField("com", "reaper"))
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if s.Stdout != nil {
+		// notify the host of read-only mode changes
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return writeReadOnlyBanners(ctx, s.EventEmitter, s.Stdout)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if s.Stdout != nil {
+		// notify the host of privacy mode changes
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return writePrivacyBanners(ctx, s.EventEmitter, s.Stdout, true)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if s.Stdout != nil {
+		// warn the host before the server ends the session
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return writeExpiryBanners(ctx, s.EventEmitter, s.Stdout)
+		}, func(err error) {
+			cancel()
+		})
+	}
 	{
 		ctx, cancel := context.WithCancel(ctx)
 		sh := sessionHandler{
 			forceCommand:      s.ForceCommand,
+			menu:              s.Menu,
 			ptmx:              ptmx,
+			ptyBackend:        s.PtyBackend,
 			eventEmmiter:      s.EventEmitter,
 			writers:           writers,
 			keepAliveDuration: s.KeepAliveDuration,
 			ctx:               ctx,
 			logger:            s.Logger,
 			readonly:          s.ReadOnly,
+			startAt:           s.StartAt,
+			stats:             s.Stats,
+			clientIdleTimeout: s.ClientIdleTimeout,
+			coalesceDelay:     s.OutputCoalesceDelay,
+			termUsage:         usage,
+			timer:             s.Timer,
+			approval:          s.Approval,
+			idle:              s.Idle,
+			activity:          s.Activity,
+			name:              s.Name,
+			clients:           newClientLimit(s.MaxClients),
+			paste:             paste,
+			attribution:       s.Attribution,
+		}
+		if s.Exec && len(s.ForceCommand) == 0 && len(s.Menu) == 0 {
+			sh.exec = &execHandler{
+				compression:       s.TransferCompression,
+				readonly:          s.ReadOnly,
+				eventEmmiter:      s.EventEmitter,
+				logger:            s.Logger.WithField("com", "exec"),
+				approval:          s.Approval,
+				keepAliveDuration: s.KeepAliveDuration,
+			}
 		}
 		ph := publicKeyHandler{
 			AuthorizedKeys: s.AuthorizedKeys,
 			EventEmmiter:   s.EventEmitter,
 			Logger:         s.Logger,
+			JoinTokens:     s.JoinTokens,
+			Identities:     s.Identities,
+			Conns:          s.Conns,
 		}
 
-		var ss []gssh.Signer
-		for _, signer := range s.Signers {
-			ss = append(ss, signer)
-		}
-
-		server := gssh.Server{
-			HostSigners:      ss,
-			Handler:          sh.HandleSession,
-			Version:          upterm.HostSSHServerVersion,
-			PublicKeyHandler: ph.HandlePublicKey,
-			ConnectionFailedCallback: func(conn net.Conn, err error) {
-				s.Logger.WithError(err).Error("connection failed")
-			},
-		}
+		server := s.newSSHServer(sh.HandleSession, ph.HandlePublicKey)
 		g.Add(func() error {
 			return server.Serve(l)
 		}, func(err error) {
@@ -115,18 +306,141 @@ func (s *Server) ServeWithContext(ctx context.Context, l net.Listener) error {
 			// shut down ssh server
 			_ = server.Shutdown(ctx)
 		})
+
+		if s.DirectListener != nil {
+			dph := publicKeyHandler{
+				AuthorizedKeys: s.AuthorizedKeys,
+				EventEmmiter:   s.EventEmitter,
+				Logger:         s.Logger.WithField("listener", "direct"),
+				Direct:         true,
+				Identities:     s.Identities,
+				Conns:          s.Conns,
+			}
+
+			directServer := s.newSSHServer(sh.HandleSession, dph.HandlePublicKey)
+			g.Add(func() error {
+				return directServer.Serve(s.DirectListener)
+			}, func(err error) {
+				cancel()
+				_ = directServer.Shutdown(ctx)
+			})
+		}
 	}
 
 	return g.Run()
 }
 
+func (s *Server) newSSHServer(handler gssh.Handler, publicKeyHandler gssh.PublicKeyHandler) *gssh.Server {
+	var ss []gssh.Signer
+	for _, signer := range s.Signers {
+		ss = append(ss, signer)
+	}
+
+	fn := featureNegotiator{
+		keepAlive:         s.KeepAliveDuration,
+		recorded:          s.Recorded,
+		compressTransfers: s.TransferCompression != 0,
+		viewers:           s.Viewers,
+		logger:            s.Logger,
+	}
+	wh := whoAmIHandler{
+		readonly: s.ReadOnly,
+		logger:   s.Logger,
+	}
+
+	srv := &gssh.Server{
+		HostSigners:       ss,
+		Handler:           handler,
+		SubsystemHandlers: s.subsystemHandlers(),
+		Version:           upterm.HostSSHServerVersion,
+		PublicKeyHandler:  publicKeyHandler,
+		RequestHandlers: map[string]gssh.RequestHandler{
+			upterm.HostFeaturesRequestType: fn.HandleFeaturesRequest,
+			upterm.HostPingRequestType:     handlePingRequest,
+			upterm.HostWhoAmIRequestType:   wh.HandleWhoAmIRequest,
+		},
+		ConnectionFailedCallback: func(conn net.Conn, err error) {
+			s.Logger.WithError(err).Error("connection failed")
+		},
+	}
+	pf := portForwarder{
+		all:      s.PortForwarding && len(s.ForceCommand) == 0 && len(s.Menu) == 0,
+		ports:    s.ForwardedPorts,
+		readonly: s.ReadOnly,
+		approval: s.Approval,
+		logger:   s.Logger.WithField("com", "port-forwarding"),
+	}
+	if pf.all || len(pf.ports) > 0 {
+		srv.ChannelHandlers = map[string]gssh.ChannelHandler{
+			"session":          gssh.DefaultSessionHandler,
+			directTCPIPChannel: gssh.DirectTCPIPHandler,
+		}
+		srv.LocalPortForwardingCallback = pf.LocalPortForwardingCallback
+	}
+	if s.EvictGhostsAfter > 0 {
+		ge := ghostEvictor{
+			after:  s.EvictGhostsAfter,
+			logger: s.Logger,
+		}
+		srv.ConnCallback = ge.ConnCallback
+	}
+	if s.StrictCrypto {
+		srv.ServerConfigCallback = func(ctx gssh.Context) *ssh.ServerConfig {
+			return &ssh.ServerConfig{
+				Config:                  utils.StrictCryptoConfig(),
+				PublicKeyAuthAlgorithms: utils.StrictPublicKeyAuthAlgorithms,
+			}
+		}
+	}
+
+	return srv
+}
+
+// subsystemHandlers returns the handlers of the subsystems clients may request in sessions instead of
+// attaching to the terminal. Other subsystems are refused.
+func (s *Server) subsystemHandlers() map[string]gssh.SubsystemHandler {
+	handlers := make(map[string]gssh.SubsystemHandler)
+	if s.SFTP && len(s.ForceCommand) == 0 && len(s.Menu) == 0 {
+		sh := sftpHandler{
+			compression:       s.TransferCompression,
+			readonly:          s.ReadOnly,
+			eventEmmiter:      s.EventEmitter,
+			logger:            s.Logger.WithField("subsystem", sftpSubsystem),
+			approval:          s.Approval,
+			keepAliveDuration: s.KeepAliveDuration,
+		}
+		handlers[sftpSubsystem] = sh.HandleSubsystem
+	}
+
+	return handlers
+}
+
+type contextKey struct {
+	name string
+}
+
+var contextKeyClient = &contextKey{"client"}
+
 type publicKeyHandler struct {
-	AuthorizedKeys []ssh.PublicKey
+	AuthorizedKeys *AuthorizedKeys
 	EventEmmiter   *emitter.Emitter
 	Logger         log.FieldLogger
+	// Direct indicates clients connect directly to the host with plain public keys
+	// instead of certs signed by the server.
+	Direct bool
+	// JoinTokens redeems the token of a client joining with one. Clients with invalid tokens are rejected.
+	JoinTokens *JoinTokens
+	// Identities resolves the display names of clients if it's non-nil.
+	Identities identity.Resolver
+	// Conns registers the connections of clients, so that the host can kick them, if it's non-nil.
+	Conns *ClientConns
 }
 
 func (h *publicKeyHandler) HandlePublicKey(ctx gssh.Context, key gssh.PublicKey) bool {
+	if h.Direct {
+		return h.handleDirectPublicKey(ctx, key)
+	}
+
 	checker := server.UserCertChecker{}
 	auth, pk, err := checker.Authenticate(ctx.User(), key)
 	if err != nil {
@@ -136,54 +450,208 @@ func (h *publicKeyHandler) HandlePublicKey(ctx gssh.Context, key gssh.PublicKey)
 
 	// TODO: sshproxy already rejects unauthorized keys
 	// Does host still need to check them?
-	if len(h.AuthorizedKeys) == 0 {
-		emitClientJoinEvent(h.EventEmmiter, ctx.SessionID(), auth, pk)
-		return true
+	authorized := len(h.AuthorizedKeys.Keys()) == 0 || h.AuthorizedKeys.Authorized(pk)
+	name := h.resolve(ctx, pk, auth.DisplayName)
+	logger := h.Logger.WithField("client", identity.Describe(name, utils.FingerprintSHA256(pk)))
+	_, token := routing.SplitToken(ctx.User())
+	switch {
+	case !authorized && token == "":
+		logger.Info("unauthorized public key")
+		return false
+	case !authorized:
+		// only invites let clients with unauthorized keys in, so other tokens aren't burned by them
+		if !h.JoinTokens.RedeemInvite(token, time.Now()) {
+			logger.Info("unauthorized public key with an invalid, expired, or redeemed invite")
+			return false
+		}
+		logger.Info("unauthorized public key joined with an invite")
+	case token != "" && !h.JoinTokens.Redeem(token, time.Now()):
+		logger.Info("invalid, expired, or redeemed join token")
+		return false
+	}
+
+	emitClientJoinEvent(ctx, h.EventEmmiter, h.Conns, auth, pk, name)
+	return true
+}
+
+// resolve returns the display name of the key of a client, or the name resolved by the server if it's unknown.
+func (h *publicKeyHandler) resolve(ctx context.Context, key ssh.PublicKey, serverName string) string {
+	if h.Identities == nil {
+		return serverName
+	}
+
+	name, err := h.Identities.Resolve(ctx, key)
+	if err != nil {
+		h.Logger.WithError(err).Warn("error resolving client identity")
+	}
+	if name == "" {
+		return serverName
 	}
 
-	for _, k := range h.AuthorizedKeys {
-		if utils.KeysEqual(k, pk) {
-			emitClientJoinEvent(h.EventEmmiter, ctx.SessionID(), auth, pk)
-			return true
+	return name
+}
+
+// handleDirectPublicKey authenticates clients connecting directly. There is no server vouching for them,
+// so authorized keys are always required.
+func (h *publicKeyHandler) handleDirectPublicKey(ctx gssh.Context, key gssh.PublicKey) bool {
+	if _, isCert := key.(*ssh.Certificate); isCert {
+		h.Logger.Info("certs are not supported for direct connections")
+		return false
+	}
+
+	name := h.resolve(ctx, key, "")
+	if h.AuthorizedKeys.Authorized(key) {
+		auth := &server.AuthRequest{
+			ClientVersion: ctx.ClientVersion(),
+			RemoteAddr:    ctx.RemoteAddr().String(),
 		}
+		emitClientJoinEvent(ctx, h.EventEmmiter, h.Conns, auth, key, name)
+		return true
 	}
 
-	h.Logger.Info("unauthorized public key")
+	h.Logger.WithField("client", identity.Describe(name, utils.FingerprintSHA256(key))).Info("unauthorized public key")
 	return false
 }
 
+// outputCoalesceSize is the most output coalesced for a client, keeping bursts, e.g. of TUIs redrawing the screen,
+// within a few SSH packets.
+const outputCoalesceSize = 16 * 1024
+
 type sessionHandler struct {
 	forceCommand      []string
-	ptmx              *pty
+	menu              []*api.MenuItem
+	ptmx              Pty
+	ptyBackend        PtyBackend
 	eventEmmiter      *emitter.Emitter
 	writers           *uio.MultiWriter
 	keepAliveDuration time.Duration
 	ctx               context.Context
 	logger            log.FieldLogger
-	readonly          bool
+	readonly          *ReadOnly
+	startAt           time.Time
+	stats             *Stats
+	clientIdleTimeout time.Duration
+	coalesceDelay     time.Duration
+	termUsage         *termUsage
+	timer             *SessionTimer
+	approval          *Approval
+	idle              *SessionIdle
+	activity          *Activity
+	clients           *clientLimit
+	paste             *pasteGuard
+	attribution       *InputAttribution
+	name              string
+	// exec runs the commands clients exec without a terminal if it's non-nil.
+	exec *execHandler
+}
+
+// keepAlive returns the keepalive interval negotiated by the client or the default one.
+func (h *sessionHandler) keepAlive(sess gssh.Session) time.Duration {
+	if d := featuresFromContext(sess.Context()).keepAlive; d > 0 {
+		return d
+	}
+
+	return h.keepAliveDuration
 }
 
 func (h *sessionHandler) HandleSession(sess gssh.Session) {
 	sessionID := sess.Context().Value(gssh.ContextKeySessionID).(string)
-	defer emitClientLeftEvent(h.eventEmmiter, sessionID)
 
 	ptyReq, winCh, isPty := sess.Pty()
+	if !isPty && h.exec != nil && len(sess.Command()) > 0 {
+		h.exec.HandleExec(sess)
+		return
+	}
 	if !isPty {
 		_, _ = io.WriteString(sess, "PTY is required.\n")
 		_ = sess.Exit(1)
+		return
+	}
+
+	release, ok := h.clients.Acquire()
+	if !ok {
+		h.logger.WithField("session", sessionID).Info("rejecting client of a full session")
+		_, _ = fmt.Fprintf(sess, "\r\n=== The session is full, it allows %d clients at once. Try again later ===\r\n", h.clients.max)
+		_ = sess.Exit(1)
+		return
+	}
+	defer release()
+
+	if err := h.waitForStart(sess); err != nil {
+		_ = sess.Exit(1)
+		return
+	}
+
+	if h.approval != nil {
+		if err := h.approval.Wait(h.ctx, sess, sess, h.keepAlive(sess), h.eventEmmiter, h.logger); err != nil {
+			_ = sess.Exit(1)
+			return
+		}
+	}
+
+	viewOnly := featuresFromContext(sess.Context()).viewOnly
+	forceCommand := h.forceCommand
+	if len(h.menu) > 0 {
+		item, err := chooseMenuItem(sess, h.menu)
+		if err != nil {
+			if !errors.Is(err, errMenuQuit) {
+				h.logger.WithError(err).Error("error choosing menu item")
+			}
+			_ = sess.Exit(1)
+			return
+		}
+
+		forceCommand = item.Command
 	}
 
 	var (
-		g    run.Group
-		err  error
-		ptmx = h.ptmx
+		g     run.Group
+		err   error
+		ptmx  = h.ptmx
+		usage = h.termUsage
+		idle  *idleTimer
+		cw    *uio.CoalescingWriter
+		out   io.Writer = sess
+		// banners go through the same writer as the output to keep their order
+		banner io.Writer = sess
 	)
+	if h.coalesceDelay > 0 {
+		// coalesce chatty output, e.g. of TUIs, into fewer packets
+		cw = uio.NewCoalescingWriter(sess, h.coalesceDelay, outputCoalesceSize)
+		out = cw
+		banner = cw
+	}
+	if h.clientIdleTimeout > 0 {
+		idle = newIdleTimer(h.clientIdleTimeout, time.Now())
+	}
+	var title func(now time.Time) string
+	if h.timer != nil {
+		// show the time of the session in the terminal title of the client
+		title = func(now time.Time) string {
+			return h.timer.title(now, idle)
+		}
+	}
+	if h.activity != nil && len(forceCommand) == 0 {
+		// show the client when the host types while the session is read-only
+		title = h.activity.Title(title, h.readonly)
+	}
+	if title != nil {
+		tw := newTitleWriter(out, title)
+		out = tw
+
+		ctx, cancel := context.WithCancel(h.ctx)
+		g.Add(func() error {
+			return tw.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
 
 	// simulate openssh keepalive
 	{
 		ctx, cancel := context.WithCancel(h.ctx)
 		g.Add(func() error {
-			ticker := time.NewTicker(h.keepAliveDuration)
+			ticker := time.NewTicker(h.keepAlive(sess))
 			defer ticker.Stop()
 
 			for {
@@ -201,23 +669,24 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 		})
 	}
 
-	if len(h.forceCommand) > 0 {
-		var cmd *exec.Cmd
+	if len(forceCommand) > 0 {
+		var proc Process
 
 		ctx, cancel := context.WithCancel(h.ctx)
 		defer cancel()
 
-		cmd, ptmx, err = startAttachCmd(ctx, h.forceCommand, ptyReq.Term)
+		ptmx, proc, err = startAttachCmd(ctx, h.ptyBackend, forceCommand, ptyReq.Term)
 		if err != nil {
 			h.logger.WithError(err).Error("error starting force command")
 			_ = sess.Exit(1)
 			return
 		}
+		usage = &termUsage{}
 
 		{
 			// reattach output
 			g.Add(func() error {
-				_, err := io.Copy(sess, uio.NewContextReader(ctx, ptmx))
+				_, err := uio.Copy(io.MultiWriter(h.stats.Writer(out), usage), uio.NewContextReader(ctx, ptmx))
 				return ptyError(err)
 			}, func(err error) {
 				cancel()
@@ -226,7 +695,7 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 		}
 		{
 			g.Add(func() error {
-				return cmd.Wait()
+				return proc.Wait()
 			}, func(err error) {
 				cancel()
 				ptmx.Close()
@@ -234,12 +703,51 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 		}
 	} else {
 		// output
-		if err := h.writers.Append(sess); err != nil {
+		w := h.stats.Writer(out)
+		if err := h.writers.Append(w); err != nil {
 			_ = sess.Exit(1)
 			return
 		}
 
-		defer h.writers.Remove(sess)
+		defer h.writers.Remove(w)
+
+		if h.activity != nil {
+			// tell the client the session is still alive once the output stalls
+			ctx, cancel := context.WithCancel(h.ctx)
+			g.Add(func() error {
+				return h.activity.writeFrozenNotices(ctx, banner, h.readonly)
+			}, func(err error) {
+				cancel()
+			})
+		}
+
+		// notify the client when the output is paused while the host types privately
+		ctx, cancel := context.WithCancel(h.ctx)
+		g.Add(func() error {
+			return writePrivacyBanners(ctx, h.eventEmmiter, banner, false)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		// warn the client before the server ends the session
+		ctx, cancel := context.WithCancel(h.ctx)
+		g.Add(func() error {
+			return writeExpiryBanners(ctx, h.eventEmmiter, banner)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if supported, err := lookupTermCaps(ptyReq.Term, sess.Environ()); err != nil {
+		h.logger.WithError(err).WithField("term", ptyReq.Term).Debug("unable to look up terminal capabilities")
+	} else {
+		// advise the client once the command uses capabilities its terminal lacks
+		ctx, cancel := context.WithCancel(h.ctx)
+		g.Add(func() error {
+			return writeTermCapAdvisory(ctx, usage, ptyReq.Term, supported, banner)
+		}, func(err error) {
+			cancel()
+		})
 	}
 
 	{
@@ -250,7 +758,10 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 			for {
 				select {
 				case win := <-winCh:
-					tee.TerminalWindowChanged(sessionID, ptmx, win.Width, win.Height)
+					// viewers don't resize the terminal of the others
+					if !viewOnly {
+						tee.TerminalWindowChanged(sessionID, ptmx, win.Width, win.Height)
+					}
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -261,22 +772,78 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 		})
 	}
 
-	// if a readonly session has been requested, don't connect stdin
-	if h.readonly {
-		// write to client to notify them that they have connected to a read-only session
-		_, _ = io.WriteString(sess, "\r\n=== Attached to read-only session ===\r\n\r\n")
-	} else {
-		// input
+	if h.name != "" {
+		_, _ = fmt.Fprintf(banner, "\r\n=== Joined session: %s ===\r\n", h.name)
+	}
+	// if the session is read-only, write to client to notify them that they have connected to a read-only session
+	if h.readonly.Get() {
+		_, _ = io.WriteString(banner, "\r\n=== Attached to read-only session ===\r\n\r\n")
+	}
+	{
+		// notify the client of read-only mode changes
+		ctx, cancel := context.WithCancel(h.ctx)
+		g.Add(func() error {
+			return writeReadOnlyBanners(ctx, h.eventEmmiter, banner)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if !viewOnly {
+		// deliver signals requested by the client, e.g. from SSH libraries, like the keys it types
+		ctx, cancel := context.WithCancel(h.ctx)
+		sf := signalForwarder{
+			ptmx:     ptmx,
+			readonly: h.readonly,
+			logger:   h.logger.WithField("session", sessionID),
+		}
+		g.Add(func() error {
+			return sf.Forward(ctx, sess)
+		}, func(err error) {
+			cancel()
+		})
+	}
+
+	var input io.Reader = h.idle.Reader(sess)
+	if idle != nil {
+		// disconnect the client once it stops typing for the timeout
+		input = idle.Reader(input)
+
 		ctx, cancel := context.WithCancel(h.ctx)
 		g.Add(func() error {
-			_, err := io.Copy(ptmx, uio.NewContextReader(ctx, sess))
+			return idle.Wait(ctx, banner)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		// input, discarded while the session is read-only
+		ctx, cancel := context.WithCancel(h.ctx)
+		w := h.readonly.Writer(ptmx)
+		if viewOnly {
+			w = io.Discard
+		} else if len(forceCommand) == 0 {
+			// attribute the input forwarded to the shared terminal, and hold large pastes until the host confirms them
+			c, _ := sess.Context().Value(contextKeyClient).(*api.Client)
+			client := "a client"
+			if c != nil {
+				client = identity.Describe(c.DisplayName, c.PublicKeyFingerprint)
+			}
+			w = h.paste.Writer(ctx, h.readonly.Writer(h.attribution.Writer(ptmx, c)), client, banner)
+		}
+		g.Add(func() error {
+			_, err := uio.Copy(w, uio.NewContextReader(ctx, h.stats.Reader(input)))
 			return err
 		}, func(err error) {
 			cancel()
 		})
 	}
 
-	if err := g.Run(); err != nil {
+	err = g.Run()
+	if cw != nil {
+		// deliver the last output before exiting
+		_ = cw.Close()
+	}
+	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			_ = sess.Exit(exitError.ExitCode())
 		} else {
@@ -287,24 +854,75 @@ func (h *sessionHandler) HandleSession(sess gssh.Session) {
 	}
 }
 
-func emitClientJoinEvent(eventEmmiter *emitter.Emitter, sessionID string, auth *server.AuthRequest, pk ssh.PublicKey) {
+// waitForStart keeps the client in a waiting room until the session starts.
+func (h *sessionHandler) waitForStart(sess gssh.Session) error {
+	wait := time.Until(h.startAt)
+	if wait <= 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("\r\n=== Session starts at %s (in %s). Waiting for the host... ===\r\n", h.startAt.Format(time.Kitchen), wait.Round(time.Second))
+	if _, err := io.WriteString(sess, msg); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// keep the connection alive while waiting
+	ticker := time.NewTicker(h.keepAlive(sess))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			_, err := io.WriteString(sess, "\r\n=== Session started ===\r\n\r\n")
+			return err
+		case <-ticker.C:
+			if _, err := sess.SendRequest(upterm.OpenSSHKeepAliveRequestType, true, nil); err != nil {
+				h.logger.WithError(err).Debug("error pinging client to keepalive")
+			}
+		case <-sess.Context().Done():
+			return sess.Context().Err()
+		case <-h.ctx.Done():
+			return h.ctx.Err()
+		}
+	}
+}
+
+func emitClientJoinEvent(ctx gssh.Context, eventEmmiter *emitter.Emitter, conns *ClientConns, auth *server.AuthRequest, pk ssh.PublicKey, name string) {
 	c := &api.Client{
-		Id:                   sessionID,
+		Id:                   ctx.SessionID(),
 		Version:              auth.ClientVersion,
 		Addr:                 auth.RemoteAddr,
 		PublicKeyFingerprint: utils.FingerprintSHA256(pk),
+		DisplayName:          name,
+		NodeAddr:             auth.NodeAddr,
 	}
-	eventEmmiter.Emit(upterm.EventClientJoined, c)
-}
-
-func emitClientLeftEvent(eventEmmiter *emitter.Emitter, sessionID string) {
-	eventEmmiter.Emit(upterm.EventClientLeft, sessionID)
+	ctx.SetValue(contextKeyClient, c)
+	conns.add(c.Id, func() error {
+		// the ssh connection is set once the handshake completes
+		conn, ok := ctx.Value(gssh.ContextKeyConn).(ssh.Conn)
+		if !ok {
+			return errClientConnecting
+		}
+		return conn.Close()
+	})
+	events.Emit(eventEmmiter, events.ClientJoined{Client: c})
+
+	// the client leaves once its connection is closed rather than when a session of it ends, since it may
+	// open several sessions, e.g. a terminal and SFTP, or none
+	go func() {
+		<-ctx.Done()
+		conns.remove(c.Id)
+		events.Emit(eventEmmiter, events.ClientLeft{Client: c})
+	}()
 }
 
-func startAttachCmd(ctx context.Context, c []string, term string) (*exec.Cmd, *pty, error) {
+func startAttachCmd(ctx context.Context, backend PtyBackend, c []string, term string) (Pty, Process, error) {
 	cmd := exec.CommandContext(ctx, c[0], c[1:]...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("TERM=%s", term))
-	pty, err := startPty(cmd)
+	hangUpOnCancel(cmd)
 
-	return cmd, pty, err
+	return startedCommands.Start(backend, cmd)
 }
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/klauspost/compress/zstd"
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// sftpSubsystem is the name of the subsystem clients request for SFTP, e.g. with sftp or scp -s.
+const sftpSubsystem = "sftp"
+
+// sftpHandler serves SFTP in the working directory of the host.
+// Clients are refused while the session is read-only. Transfers in progress aren't stopped when it turns read-only.
+type sftpHandler struct {
+	// compression compresses transfers of clients agreeing to it if it's non-zero.
+	compression  zstd.EncoderLevel
+	readonly     *ReadOnly
+	eventEmmiter *emitter.Emitter
+	logger       log.FieldLogger
+	// approval keeps clients waiting until they are approved if it's non-nil.
+	approval          *Approval
+	keepAliveDuration time.Duration
+}
+
+func (h *sftpHandler) HandleSubsystem(sess gssh.Session) {
+	if h.readonly.Get() {
+		_, _ = io.WriteString(sess.Stderr(), "SFTP is not allowed in read-only sessions.\n")
+		_ = sess.Exit(1)
+		return
+	}
+
+	if h.approval != nil {
+		// the waiting state isn't shown, since the output of the subsystem is the SFTP protocol
+		if err := h.approval.Wait(sess.Context(), sess, nil, h.keepAliveDuration, h.eventEmmiter, h.logger); err != nil {
+			_, _ = io.WriteString(sess.Stderr(), err.Error()+"\n")
+			_ = sess.Exit(1)
+			return
+		}
+	}
+
+	var rwc io.ReadWriteCloser = sess
+	if h.compression != 0 && featuresFromContext(sess.Context()).compressTransfers {
+		cs, err := api.NewCompressedStream(sess, h.compression)
+		if err != nil {
+			h.logger.WithError(err).Error("error compressing sftp")
+			_ = sess.Exit(1)
+			return
+		}
+		rwc = cs
+	}
+
+	srv, err := sftp.NewServer(rwc)
+	if err != nil {
+		h.logger.WithError(err).Error("error starting sftp server")
+		_ = sess.Exit(1)
+		return
+	}
+	defer srv.Close()
+
+	if err := srv.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithError(err).Debug("error serving sftp")
+		_ = sess.Exit(1)
+		return
+	}
+
+	_ = sess.Exit(0)
+}
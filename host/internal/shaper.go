@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type priority int
+
+const (
+	priorityInteractive priority = iota
+	priorityBulk
+)
+
+const (
+	// shaperChunk is the max bytes of a write scheduled at once,
+	// so that interactive writes don't queue behind large bulk writes.
+	shaperChunk = 4 * 1024
+	// A connection writing more than bulkThreshold bytes within bulkWindow is bulk,
+	// e.g. a client transferring files, until it slows down again.
+	bulkThreshold = 32 * 1024
+	bulkWindow    = time.Second
+	// shaperYield is how long bulk writes back off while interactive writes are waiting.
+	shaperYield = 5 * time.Millisecond
+)
+
+// shaper is a token bucket shared by the connections of the reverse tunnel.
+// It caps the bytes per second written to the tunnel. Interactive writes take
+// tokens before bulk writes, so that a shell stays usable on a constrained uplink.
+type shaper struct {
+	rate  float64
+	burst float64
+
+	mu                 sync.Mutex
+	tokens             float64
+	last               time.Time
+	interactiveWaiting int
+}
+
+// newShaper creates a shaper writing at most rate bytes per second.
+func newShaper(rate int64) *shaper {
+	burst := float64(rate) / 10
+	if burst < shaperChunk {
+		burst = shaperChunk
+	}
+
+	return &shaper{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (s *shaper) refill(now time.Time) {
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.last = now
+}
+
+// Wait blocks until n bytes of priority p can be written. n must not exceed shaperChunk.
+func (s *shaper) Wait(n int, p priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p == priorityInteractive {
+		s.interactiveWaiting++
+		defer func() { s.interactiveWaiting-- }()
+	}
+
+	for {
+		s.refill(time.Now())
+
+		yield := p == priorityBulk && s.interactiveWaiting > 0
+		if !yield && s.tokens >= float64(n) {
+			s.tokens -= float64(n)
+			return
+		}
+
+		d := shaperYield
+		if !yield {
+			d = time.Duration((float64(n) - s.tokens) / s.rate * float64(time.Second))
+		}
+
+		s.mu.Unlock()
+		time.Sleep(d)
+		s.mu.Lock()
+	}
+}
+
+type shapedListener struct {
+	net.Listener
+	shaper *shaper
+}
+
+func (l shapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &shapedConn{Conn: conn, shaper: l.shaper}, nil
+}
+
+// shapedConn writes through the shaper. Its priority is bulk if it writes
+// more than bulkThreshold bytes within bulkWindow, or it did in the previous window.
+type shapedConn struct {
+	net.Conn
+	shaper *shaper
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowBytes int
+	bulk        bool
+}
+
+func (c *shapedConn) classify(n int, now time.Time) priority {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.windowStart) >= bulkWindow {
+		c.bulk = c.windowBytes > bulkThreshold && now.Sub(c.windowStart) < 2*bulkWindow
+		c.windowStart = now
+		c.windowBytes = 0
+	}
+
+	c.windowBytes += n
+	if c.windowBytes > bulkThreshold {
+		c.bulk = true
+	}
+
+	if c.bulk {
+		return priorityBulk
+	}
+
+	return priorityInteractive
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > shaperChunk {
+			n = shaperChunk
+		}
+
+		c.shaper.Wait(n, c.classify(n, time.Now()))
+
+		w, err := c.Conn.Write(p[:n])
+		written += w
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
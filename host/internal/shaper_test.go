@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func Test_shapedConn_classify(t *testing.T) {
+	c := &shapedConn{}
+	now := time.Now()
+
+	if p := c.classify(100, now); p != priorityInteractive {
+		t.Fatalf("want interactive for small writes, got %v", p)
+	}
+	if p := c.classify(bulkThreshold, now); p != priorityBulk {
+		t.Fatalf("want bulk after writing more than the threshold, got %v", p)
+	}
+	if p := c.classify(100, now.Add(bulkWindow)); p != priorityBulk {
+		t.Fatalf("want bulk in the window after a bulk window, got %v", p)
+	}
+	if p := c.classify(100, now.Add(3*bulkWindow)); p != priorityInteractive {
+		t.Fatalf("want interactive after slowing down, got %v", p)
+	}
+}
+
+func Test_shaper_Rate(t *testing.T) {
+	s := newShaper(80 * 1024) // burst of 8k
+	c := &shapedConn{Conn: discardConn{}, shaper: s}
+
+	start := time.Now()
+	n, err := c.Write(make([]byte, 24*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 24*1024 {
+		t.Fatalf("want %d bytes written, got %d", 24*1024, n)
+	}
+
+	// the first 8k are the burst, the remaining 16k take 200ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("want writes to be limited, took %s", elapsed)
+	}
+}
+
+func Test_shaper_Priority(t *testing.T) {
+	s := newShaper(40 * 1024) // burst of 4k refilled in 100ms
+	s.Wait(shaperChunk, priorityInteractive)
+
+	done := make(chan priority, 2)
+	go func() {
+		s.Wait(shaperChunk, priorityBulk)
+		done <- priorityBulk
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		s.Wait(shaperChunk, priorityInteractive)
+		done <- priorityInteractive
+	}()
+
+	if p := <-done; p != priorityInteractive {
+		t.Fatal("want interactive writes to go before bulk writes")
+	}
+	if p := <-done; p != priorityBulk {
+		t.Fatal("want bulk writes to go eventually")
+	}
+}
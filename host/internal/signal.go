@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// hangUpDelay is how long a command has to exit after it's hung up before it's killed.
+const hangUpDelay = 3 * time.Second
+
+// errSignalUnsupported is returned when signals can't be delivered to the command of a pty,
+// e.g. with the tmux or conpty backends.
+var errSignalUnsupported = errors.New("signals are not supported by the pty")
+
+// clientSignals are the signals clients may send with SSH signal requests, e.g. from SSH libraries
+// that don't type Ctrl-C. Signals that can't be handled by commands, like KILL, are refused.
+var clientSignals = map[gssh.Signal]bool{
+	gssh.SIGINT:          true,
+	gssh.SIGQUIT:         true,
+	gssh.SIGHUP:          true,
+	gssh.SIGTERM:         true,
+	gssh.SIGUSR1:         true,
+	gssh.SIGUSR2:         true,
+	gssh.Signal("TSTP"):  true,
+	gssh.Signal("CONT"):  true,
+	gssh.Signal("WINCH"): true,
+}
+
+// signalForwarder delivers the signal and break requests of a client to the foreground process group
+// of its terminal, like the keys typed by the client. Requests are ignored while the session is read-only.
+type signalForwarder struct {
+	ptmx     Pty
+	readonly *ReadOnly
+	logger   log.FieldLogger
+}
+
+// Forward forwards the requests of sess until ctx is done. A break is delivered as SIGINT,
+// like a terminal with BRKINT set.
+func (f signalForwarder) Forward(ctx context.Context, sess gssh.Session) error {
+	sigCh := make(chan gssh.Signal, 1)
+	sess.Signals(sigCh)
+	breakCh := make(chan bool, 1)
+	sess.Break(breakCh)
+
+	for {
+		select {
+		case sig := <-sigCh:
+			f.deliver(sig)
+		case <-breakCh:
+			f.deliver(gssh.SIGINT)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f signalForwarder) deliver(sig gssh.Signal) {
+	logger := f.logger.WithField("signal", sig)
+	if f.readonly != nil && f.readonly.Get() {
+		logger.Debug("ignored signal in read-only session")
+		return
+	}
+	if !clientSignals[sig] {
+		logger.Info("refused signal")
+		return
+	}
+
+	if err := signalForeground(f.ptmx, string(sig)); err != nil {
+		logger.WithError(err).Debug("error delivering signal")
+	}
+}
+
+// hangUpOnCancel hangs up the command when its context is done instead of killing it, so that the command
+// and the jobs in its process group can clean up like when a terminal is closed. The command is killed
+// if it doesn't exit within hangUpDelay.
+func hangUpOnCancel(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return hangUp(cmd)
+	}
+	cmd.WaitDelay = hangUpDelay
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalForeground signals the foreground process group of the terminal, which is the job
+// a user typing Ctrl-C or Ctrl-Z would signal, e.g. the command run by a shell.
+func signalForeground(p Pty, name string) error {
+	sig := unix.SignalNum("SIG" + name)
+	if sig == 0 {
+		return fmt.Errorf("unknown signal %s", name)
+	}
+
+	// signal the terminal of ptys wrapped, e.g. to stream their windows or to restart their commands
+	for {
+		u, ok := p.(interface{ Unwrap() Pty })
+		if !ok {
+			break
+		}
+		p = u.Unwrap()
+	}
+	pt, ok := p.(*pty)
+	if !ok {
+		return errSignalUnsupported
+	}
+
+	pt.RLock()
+	defer pt.RUnlock()
+
+	// Fd() would set the pty to blocking mode, preventing reads from being interrupted when it's closed
+	rc, err := pt.File.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var (
+		pgrp    int
+		ctrlErr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		pgrp, ctrlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return err
+	}
+	if ctrlErr != nil {
+		return fmt.Errorf("error getting foreground process group: %w", ctrlErr)
+	}
+
+	return unix.Kill(-pgrp, sig)
+}
+
+// hangUp sends SIGHUP to the process group of cmd if it leads one, e.g. commands started in a pty
+// by creack/pty, or to cmd otherwise.
+func hangUp(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	if attr := cmd.SysProcAttr; attr != nil && (attr.Setsid || attr.Setpgid) {
+		return unix.Kill(-cmd.Process.Pid, unix.SIGHUP)
+	}
+
+	return cmd.Process.Signal(unix.SIGHUP)
+}
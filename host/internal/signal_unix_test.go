@@ -0,0 +1,163 @@
+//go:build !windows
+
+package internal
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gssh "github.com/charmbracelet/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// Test_signalForeground checks that signals reach the foreground job of shells whether they're typed
+// or requested with SSH signal requests.
+func Test_signalForeground(t *testing.T) {
+	shells := map[string][]string{
+		"bash": {"bash", "--norc", "--noprofile"},
+		"dash": {"dash"},
+		"sh":   {"sh"},
+		"zsh":  {"zsh", "-f"},
+	}
+
+	for name, shell := range shells {
+		if _, err := exec.LookPath(shell[0]); err != nil {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			interrupt := func(typed bool) func(*testing.T, Pty) {
+				return func(t *testing.T, ptmx Pty) {
+					if typed {
+						_, _ = ptmx.Write([]byte{0x03})
+						return
+					}
+					// the terminal of wrapped ptys is signaled
+					if err := signalForeground(NewOutputStream().Pty(ptmx), "INT"); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for _, typed := range []bool{true, false} {
+				name := "request"
+				if typed {
+					name = "typed"
+				}
+				t.Run("INT "+name, func(t *testing.T) {
+					args := append(shell, "-c", `trap "echo got-INT; exit 0" INT; echo ready; while :; do sleep 0.1; done`)
+					ptmx, out := startShell(t, args)
+					out.WaitFor(t, "ready")
+					interrupt(typed)(t, ptmx)
+					out.WaitFor(t, "got-INT")
+				})
+			}
+
+			t.Run("TSTP request", func(t *testing.T) {
+				ptmx, out := startShell(t, append(shell, "-i"))
+				_, _ = ptmx.Write([]byte("sleep 30\n"))
+				time.Sleep(300 * time.Millisecond)
+
+				if err := signalForeground(ptmx, "TSTP"); err != nil {
+					t.Fatal(err)
+				}
+				// the shell is back in the foreground once the job is stopped
+				_, _ = ptmx.Write([]byte("echo resumed-$((1+1))\n"))
+				out.WaitFor(t, "resumed-2")
+				_, _ = ptmx.Write([]byte("kill %1; exit\n"))
+			})
+		})
+	}
+}
+
+func Test_signalForwarder(t *testing.T) {
+	ptmx, out := startShell(t, []string{"sh", "-c", `trap "echo got-INT; exit 0" INT; echo ready; while :; do sleep 0.1; done`})
+	out.WaitFor(t, "ready")
+
+	readonly := NewReadOnly(true, nil)
+	f := signalForwarder{ptmx: ptmx, readonly: readonly, logger: log.New()}
+
+	f.deliver(gssh.SIGINT)
+	f.deliver(gssh.SIGKILL)
+	time.Sleep(300 * time.Millisecond)
+	if out.Contains("got-INT") {
+		t.Fatal("want signals ignored in read-only sessions")
+	}
+
+	readonly.Set(false)
+	f.deliver(gssh.SIGKILL)
+	time.Sleep(300 * time.Millisecond)
+	if out.Contains("got-INT") {
+		t.Fatal("want signals refused")
+	}
+
+	f.deliver(gssh.SIGINT)
+	out.WaitFor(t, "got-INT")
+}
+
+func startShell(t *testing.T, args []string) (Pty, *syncBuffer) {
+	t.Helper()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = []string{"PS1=$ ", "TERM=dumb", "PATH=/usr/bin:/bin"}
+	ptmx, proc, err := ptyBackend{}.Start(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = hangUp(cmd)
+		_ = ptmx.Close()
+		_ = proc.Wait()
+	})
+
+	out := &syncBuffer{}
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, err := ptmx.Read(b)
+			out.Write(b[:n])
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ptmx, out
+}
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mux sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.buf.Write(p)
+}
+
+func (b *syncBuffer) Contains(s string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return strings.Contains(b.buf.String(), s)
+}
+
+func (b *syncBuffer) WaitFor(t *testing.T, s string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !b.Contains(s) {
+		if time.Now().After(deadline) {
+			b.mux.Lock()
+			defer b.mux.Unlock()
+			t.Fatalf("want output containing %q, got %q", s, b.buf.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,19 @@
+package internal
+
+import (
+	"os/exec"
+)
+
+// signalForeground is unsupported on Windows, which has no process groups of terminals.
+func signalForeground(p Pty, name string) error {
+	return errSignalUnsupported
+}
+
+// hangUp kills cmd since Windows has no SIGHUP.
+func hangUp(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	return cmd.Process.Kill()
+}
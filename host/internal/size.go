@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// SizePolicyHost sizes ptys to the terminal of the host, or to the smallest terminal of clients if the host's
+	// isn't attached, e.g. to the ptys of force commands.
+	SizePolicyHost = "host"
+	// SizePolicySmallest sizes ptys to the largest window fitting all terminals attached to them.
+	SizePolicySmallest = "smallest"
+	// SizePolicyLargest sizes ptys to the smallest window covering all terminals attached to them, so that smaller
+	// terminals crop the output.
+	SizePolicyLargest = "largest"
+	// SizePolicyManual sizes ptys to a fixed window regardless of the terminals attached to them.
+	SizePolicyManual = "manual"
+
+	// localTerminalID identifies the terminal of the host among the terminals attached to a pty.
+	localTerminalID = "local"
+)
+
+// SizePolicies are the supported size policies.
+var SizePolicies = []string{SizePolicyHost, SizePolicySmallest, SizePolicyLargest, SizePolicyManual}
+
+// ErrManualSizeRequired is returned when the manual size policy is missing its window size.
+var ErrManualSizeRequired = errors.New("manual size policy requires a window size")
+
+// SizePolicy resolves the window a pty is resized to when the terminals attached to it, of the host and of clients,
+// request conflicting windows. Ptys are resized again when terminals are attached or detached, e.g. when clients
+// join or leave.
+type SizePolicy struct {
+	name   string
+	width  int
+	height int
+}
+
+// NewSizePolicy returns the size policy with the name, one of SizePolicies. width and height are the window of the
+// manual policy, and must be zero for the others. It defaults to SizePolicySmallest if name is empty.
+func NewSizePolicy(name string, width, height int) (*SizePolicy, error) {
+	if name == "" {
+		name = SizePolicySmallest
+	}
+
+	switch name {
+	case SizePolicyHost, SizePolicySmallest, SizePolicyLargest:
+		if width != 0 || height != 0 {
+			return nil, fmt.Errorf("window size can only be set with the %s size policy", SizePolicyManual)
+		}
+	case SizePolicyManual:
+		if width <= 0 || height <= 0 {
+			return nil, ErrManualSizeRequired
+		}
+	default:
+		return nil, fmt.Errorf("unsupported size policy %s", name)
+	}
+
+	return &SizePolicy{name: name, width: width, height: height}, nil
+}
+
+func (p *SizePolicy) Name() string {
+	return p.name
+}
+
+// fit returns the window of the pty that ts are attached to.
+func (p *SizePolicy) fit(ts map[string]terminal) window {
+	switch p.name {
+	case SizePolicyHost:
+		if t, ok := ts[localTerminalID]; ok {
+			return t.Window
+		}
+		return fitWindow(ts)
+	case SizePolicyLargest:
+		return coverWindow(ts)
+	case SizePolicyManual:
+		return window{Width: p.width, Height: p.height}
+	default:
+		return fitWindow(ts)
+	}
+}
+
+// fitWindow returns the largest window fitting all terminals.
+func fitWindow(ts map[string]terminal) window {
+	var w, h int
+
+	for _, t := range ts {
+		if w == 0 || w > t.Window.Width {
+			w = t.Window.Width
+		}
+
+		if h == 0 || h > t.Window.Height {
+			h = t.Window.Height
+		}
+	}
+
+	return window{Width: w, Height: h}
+}
+
+// coverWindow returns the smallest window covering all terminals.
+func coverWindow(ts map[string]terminal) window {
+	var w, h int
+
+	for _, t := range ts {
+		w = max(w, t.Window.Width)
+		h = max(h, t.Window.Height)
+	}
+
+	return window{Width: w, Height: h}
+}
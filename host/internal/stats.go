@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/owenthereal/upterm/host/api"
+)
+
+func NewStats(startedAt time.Time) *Stats {
+	return &Stats{startedAt: startedAt}
+}
+
+// Stats tracks the bytes transferred and the clients connected during a session.
+// Bytes in are client input written to the host's pty, bytes out are pty output sent to clients.
+type Stats struct {
+	startedAt   time.Time
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	clients     atomic.Int32
+	peakClients atomic.Int32
+}
+
+func (s *Stats) ClientJoined() {
+	n := s.clients.Add(1)
+	for {
+		peak := s.peakClients.Load()
+		if n <= peak || s.peakClients.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (s *Stats) ClientLeft() {
+	s.clients.Add(-1)
+}
+
+// Reader counts bytes read from r as bytes in.
+func (s *Stats) Reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, n: &s.bytesIn}
+}
+
+// Writer counts bytes written to w as bytes out.
+func (s *Stats) Writer(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &s.bytesOut}
+}
+
+func (s *Stats) Snapshot(now time.Time) *api.SessionStats {
+	return &api.SessionStats{
+		BytesIn:         s.bytesIn.Load(),
+		BytesOut:        s.bytesOut.Load(),
+		PeakClients:     s.peakClients.Load(),
+		StartedAt:       s.startedAt.Unix(),
+		DurationSeconds: int64(now.Sub(s.startedAt).Seconds()),
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
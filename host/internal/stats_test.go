@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	startedAt := time.Now()
+	s := NewStats(startedAt)
+
+	s.ClientJoined()
+	s.ClientJoined()
+	s.ClientLeft()
+	s.ClientJoined()
+	s.ClientLeft()
+	s.ClientLeft()
+
+	if _, err := io.Copy(io.Discard, s.Reader(strings.NewReader("hello"))); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.Writer(&buf).Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.Snapshot(startedAt.Add(time.Minute))
+	if want, got := int64(5), stats.BytesIn; want != got {
+		t.Fatalf("bytes in: want=%d got=%d", want, got)
+	}
+	if want, got := int64(11), stats.BytesOut; want != got {
+		t.Fatalf("bytes out: want=%d got=%d", want, got)
+	}
+	if want, got := int32(2), stats.PeakClients; want != got {
+		t.Fatalf("peak clients: want=%d got=%d", want, got)
+	}
+	if want, got := int64(60), stats.DurationSeconds; want != got {
+		t.Fatalf("duration: want=%d got=%d", want, got)
+	}
+}
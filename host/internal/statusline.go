@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+const (
+	// statusFingerprintLen is how many characters of the fingerprints of clients are shown on the status line,
+	// e.g. SHA256:AbCdEfGh.
+	statusFingerprintLen = len("SHA256:") + 8
+	// statusSavedCursorGrace is how long the line isn't drawn while the command has a cursor saved, which drawing
+	// overwrites, in case the command never restores it.
+	statusSavedCursorGrace = 2 * time.Second
+	// statusClientIDLen is how many characters of the ID of the client typing are shown on the status line, which
+	// is enough to kick it.
+	statusClientIDLen = 8
+)
+
+// newStatusLine returns the status line of the host writing the output of the session to w. It follows the clients
+// joining and leaving the session on eventEmitter from now on until Run returns. It also shows the client typing
+// with attribution if it's non-nil.
+func newStatusLine(w io.Writer, eventEmitter *emitter.Emitter, attribution *InputAttribution) *statusLine {
+	s := &statusLine{
+		w:            w,
+		eventEmitter: eventEmitter,
+		joined:       events.On(eventEmitter, events.KindClientJoined),
+		left:         events.On(eventEmitter, events.KindClientLeft),
+		attribution:  attribution,
+		conns:        make(map[string]int),
+	}
+	if attribution != nil {
+		s.input = events.On(eventEmitter, events.KindClientInput)
+	}
+
+	return s
+}
+
+// statusLine shows the clients connected to the session on the last line of the terminal of the host, so that the
+// host never forgets being watched. The line is kept out of the scroll region of the terminal and the command is
+// given a window a line shorter, so the output of the command never overwrites it. It's redrawn as clients join and
+// leave, and after the command resets the scroll region or clears the screen, never in the middle of an escape
+// sequence or a UTF-8 character of the output.
+type statusLine struct {
+	w            io.Writer
+	eventEmitter *emitter.Emitter
+	joined       <-chan emitter.Event
+	left         <-chan emitter.Event
+	// input is nil unless the client typing is shown.
+	input       <-chan emitter.Event
+	attribution *InputAttribution
+
+	mu sync.Mutex
+	// clients are the clients connected in the order they joined. conns counts the joins less the leaves of the
+	// clients by ID, since a client may be seen leaving before joining.
+	clients []*api.Client
+	conns   map[string]int
+	output  outputState
+	resets  resetScanner
+	// cols and rows are the window of the terminal of the host. The line isn't shown if the terminal has a single row.
+	cols, rows int
+	shown      string
+	dirty      bool
+}
+
+// Write writes the output of the session to the terminal, and redraws the line if the output reset it.
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.w.Write(p)
+	s.output.Scan(p[:n])
+	if s.resets.Scan(p[:n], time.Now()) {
+		s.dirty = true
+	}
+	s.drawLocked(false)
+
+	return n, err
+}
+
+// Resize records the window of the terminal of the host and returns the window of the command, which is a line
+// shorter to keep the last line for the status.
+func (s *statusLine) Resize(cols, rows int) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cols, s.rows = cols, rows
+	s.dirty = true
+	// the output may end on the last line, which is scrolled up
+	s.drawLocked(true)
+
+	if rows < 2 {
+		return cols, rows
+	}
+
+	return cols, rows - 1
+}
+
+// Run redraws the line as clients join and leave until ctx is done, and then clears it.
+func (s *statusLine) Run(ctx context.Context) error {
+	defer events.Off(s.eventEmitter, events.KindClientJoined, s.joined)
+	defer events.Off(s.eventEmitter, events.KindClientLeft, s.left)
+	if s.input != nil {
+		defer events.Off(s.eventEmitter, events.KindClientInput, s.input)
+	}
+	defer s.clear()
+
+	// retry drawing deferred by the output ending in an escape sequence, and clear the client typing after its
+	// burst of input
+	ticker := time.NewTicker(timerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt := <-s.joined:
+			if e, ok := events.From(evt).(events.ClientJoined); ok {
+				s.update(e.Client, 1)
+			}
+		case evt := <-s.left:
+			if e, ok := events.From(evt).(events.ClientLeft); ok {
+				s.update(e.Client, -1)
+			}
+		case <-s.input:
+			s.mu.Lock()
+			s.drawLocked(false)
+			s.mu.Unlock()
+		case <-ticker.C:
+			s.mu.Lock()
+			s.drawLocked(false)
+			s.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *statusLine) update(c *api.Client, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.conns[c.Id]
+	s.conns[c.Id] += delta
+	switch after := s.conns[c.Id]; {
+	case before <= 0 && after > 0:
+		s.clients = append(s.clients, c)
+	case before > 0 && after <= 0:
+		for i, cc := range s.clients {
+			if cc.Id == c.Id {
+				s.clients = append(s.clients[:i], s.clients[i+1:]...)
+				break
+			}
+		}
+	}
+	if s.conns[c.Id] == 0 {
+		delete(s.conns, c.Id)
+	}
+
+	s.drawLocked(false)
+}
+
+// text returns the status of the clients, fitting cols. It's prefixed by the ID of the client typing, if shown.
+func (s *statusLine) text() string {
+	var b strings.Builder
+	if c := s.attribution.Typing(time.Now()); c != nil {
+		id := c.Id
+		if len(id) > statusClientIDLen {
+			id = id[:statusClientIDLen]
+		}
+		fmt.Fprintf(&b, "%s typing | ", id)
+	}
+	switch len(s.clients) {
+	case 0:
+		b.WriteString("upterm: no clients connected")
+	case 1:
+		b.WriteString("upterm: 1 client connected:")
+	default:
+		fmt.Fprintf(&b, "upterm: %d clients connected:", len(s.clients))
+	}
+	for i, c := range s.clients {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(" ")
+		if c.DisplayName != "" {
+			b.WriteString(c.DisplayName + " ")
+		}
+		fp := c.PublicKeyFingerprint
+		if len(fp) > statusFingerprintLen {
+			fp = fp[:statusFingerprintLen] + "…"
+		}
+		b.WriteString(fp)
+	}
+
+	text := []rune(b.String())
+	if s.cols > 0 && len(text) > s.cols {
+		text = append(text[:s.cols-1], '…')
+	}
+
+	return string(text)
+}
+
+// drawLocked draws the line on the last row of the terminal if it has changed or was reset, once the output is
+// between escape sequences and the command doesn't have a cursor saved. scroll scrolls the output up if it ends on
+// the last row.
+func (s *statusLine) drawLocked(scroll bool) {
+	if s.rows < 2 || !s.output.Ground() || s.resets.Saved(time.Now()) {
+		return
+	}
+
+	text := s.text()
+	if text == s.shown && !s.dirty {
+		return
+	}
+
+	var seq string
+	if scroll {
+		seq = "\n\x1b[A"
+	}
+	// the cursor and its attributes are saved while the line is drawn, and setting the scroll region homes it
+	seq += fmt.Sprintf("\x1b7\x1b[1;%dr\x1b[%d;1H\x1b[0m\x1b[2K\x1b[7m%s\x1b[0m\x1b8", s.rows-1, s.rows, text)
+	if _, err := io.WriteString(s.w, seq); err == nil {
+		s.shown, s.dirty = text, false
+	}
+}
+
+// clear resets the scroll region and clears the line.
+func (s *statusLine) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shown == "" || !s.output.Ground() {
+		return
+	}
+
+	if _, err := fmt.Fprintf(s.w, "\x1b7\x1b[r\x1b[%d;1H\x1b[2K\x1b8", s.rows); err == nil {
+		s.shown = ""
+	}
+}
+
+// resetScanner tracks the escape sequences of the output resetting the status line: resetting the terminal or its
+// scroll region, erasing the display, and switching to and from the alternate screen. It also tracks whether the
+// command has a cursor saved, which drawing the line would overwrite.
+type resetScanner struct {
+	esc    escState
+	params []byte
+	// savedAt is when the command saved the cursor. It's zero if the cursor isn't saved.
+	savedAt time.Time
+}
+
+// Saved reports whether the command has had a cursor saved as of now for less than statusSavedCursorGrace.
+func (s *resetScanner) Saved(now time.Time) bool {
+	return !s.savedAt.IsZero() && now.Sub(s.savedAt) < statusSavedCursorGrace
+}
+
+// Scan reports whether p written at now resets the status line.
+func (s *resetScanner) Scan(p []byte, now time.Time) bool {
+	var reset bool
+	for _, b := range p {
+		switch s.esc {
+		case escStart:
+			s.esc = escGround
+			switch b {
+			case '[':
+				s.esc, s.params = escCSI, s.params[:0]
+			case 'c':
+				reset, s.savedAt = true, time.Time{}
+			case '7':
+				s.savedAt = now
+			case '8':
+				s.savedAt = time.Time{}
+			}
+		case escCSI:
+			if b < 0x40 || b > 0x7e {
+				if len(s.params) < 16 {
+					s.params = append(s.params, b)
+				}
+				continue
+			}
+			s.esc = escGround
+			switch b {
+			case 'r', 'J':
+				reset = true
+			case 'h', 'l':
+				if params := string(s.params); params == "?1049" || params == "?1047" || params == "?47" {
+					reset = true
+				}
+			case 's':
+				// CSI s with parameters sets the left and right margins instead
+				if len(s.params) == 0 {
+					s.savedAt = now
+				}
+			case 'u':
+				s.savedAt = time.Time{}
+			}
+		default:
+			if b == 0x1b {
+				s.esc = escStart
+			}
+		}
+	}
+
+	return reset
+}
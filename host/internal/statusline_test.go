@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olebedev/emitter"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+)
+
+func Test_statusLine_text(t *testing.T) {
+	alice := &api.Client{Id: "1", DisplayName: "alice", PublicKeyFingerprint: "SHA256:AbCdEfGhIjKlMnOp"}
+	bob := &api.Client{Id: "2", PublicKeyFingerprint: "SHA256:QrStUvWx"}
+
+	s := &statusLine{conns: make(map[string]int)}
+	if want, got := "upterm: no clients connected", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	s.update(alice, 1)
+	if want, got := "upterm: 1 client connected: alice SHA256:AbCdEfGh…", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	s.update(bob, 1)
+	if want, got := "upterm: 2 clients connected: alice SHA256:AbCdEfGh…, SHA256:QrStUvWx", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the line fits the terminal
+	s.cols = 20
+	if want, got := "upterm: 2 clients c…", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	s.cols = 0
+
+	s.update(alice, -1)
+	if want, got := "upterm: 1 client connected: SHA256:QrStUvWx", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// a client seen leaving before joining isn't shown
+	carol := &api.Client{Id: "3", PublicKeyFingerprint: "SHA256:carol"}
+	s.update(carol, -1)
+	s.update(carol, 1)
+	if want, got := "upterm: 1 client connected: SHA256:QrStUvWx", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+}
+
+func Test_statusLine_typing(t *testing.T) {
+	a := NewInputAttribution(emitter.New(10))
+	s := &statusLine{attribution: a, conns: make(map[string]int)}
+	alice := &api.Client{Id: "3f9a1c2e-5b7d-4e21-9c3a-8f1b2d4e6a70", PublicKeyFingerprint: "SHA256:AbCd"}
+	s.update(alice, 1)
+
+	if want, got := "upterm: 1 client connected: SHA256:AbCd", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	if _, err := a.Writer(io.Discard, alice).Write([]byte("ls")); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "3f9a1c2e typing | upterm: 1 client connected: SHA256:AbCd", s.text(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+}
+
+func Test_statusLine_Write(t *testing.T) {
+	var buf bytes.Buffer
+	s := &statusLine{w: &buf, conns: make(map[string]int)}
+	line := "\x1b7\x1b[1;23r\x1b[24;1H\x1b[0m\x1b[2K\x1b[7mupterm: no clients connected\x1b[0m\x1b8"
+
+	// the command runs a line shorter, and the output on the last line is scrolled up
+	if cols, rows := s.Resize(80, 24); cols != 80 || rows != 23 {
+		t.Fatalf("want 80x23, got %dx%d", cols, rows)
+	}
+	if want, got := "\n\x1b[A"+line, buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	write := func(s2 string) {
+		if _, err := s.Write([]byte(s2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the line is drawn once until it's reset
+	buf.Reset()
+	write("ls\r\n")
+	if want, got := "ls\r\n", buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	for _, reset := range []string{"\x1b[r", "\x1b[2J", "\x1b[?1049h", "\x1bc"} {
+		buf.Reset()
+		write(reset)
+		if want, got := reset+line, buf.String(); want != got {
+			t.Fatalf("want=%q got=%q", want, got)
+		}
+	}
+
+	// the line isn't drawn in the middle of an escape sequence, nor while the command has a cursor saved
+	buf.Reset()
+	write("\x1b[")
+	write("2J")
+	if want, got := "\x1b[2J"+line, buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	buf.Reset()
+	write("\x1b7\x1b[2J")
+	if want, got := "\x1b7\x1b[2J", buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+	write("\x1b8")
+	if want, got := "\x1b7\x1b[2J\x1b8"+line, buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the command keeps the window of a terminal with a single row
+	if cols, rows := s.Resize(80, 1); cols != 80 || rows != 1 {
+		t.Fatalf("want 80x1, got %dx%d", cols, rows)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func Test_statusLine_Run(t *testing.T) {
+	em := emitter.New(1)
+	var buf lockedBuffer
+	s := newStatusLine(&buf, em, nil)
+	s.Resize(80, 24)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// waitFor waits for text to be written after the text waited for before
+	var offset int
+	waitFor := func(text string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if i := strings.Index(buf.String()[offset:], text); i >= 0 {
+				offset += i + len(text)
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("want %q, got %q", text, buf.String()[offset:])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	c := &api.Client{Id: "1", PublicKeyFingerprint: "SHA256:AbCd"}
+	events.Emit(em, events.ClientJoined{Client: c})
+	waitFor("upterm: 1 client connected: SHA256:AbCd")
+	events.Emit(em, events.ClientLeft{Client: c})
+	waitFor("upterm: no clients connected")
+
+	cancel()
+	<-done
+	// the scroll region is reset and the line is cleared
+	if got := buf.String(); !strings.HasSuffix(got, "\x1b7\x1b[r\x1b[24;1H\x1b[2K\x1b8") {
+		t.Fatalf("want the line cleared, got %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// termCap is a set of terminal capabilities that commands commonly use and that terminals commonly lack.
+type termCap uint8
+
+const (
+	termCap256Color termCap = 1 << iota
+	termCapTrueColor
+	termCapAltScreen
+)
+
+var termCapAdvice = []struct {
+	cap    termCap
+	advice string
+}{
+	{termCap256Color, "256 colors: join with TERM=xterm-256color if your terminal supports it"},
+	{termCapTrueColor, "24-bit color: join with COLORTERM=truecolor and ssh -o SendEnv=COLORTERM if your terminal supports it"},
+	{termCapAltScreen, "alternate screen: join with TERM=xterm-256color if your terminal supports it, or full-screen apps may garble the screen"},
+}
+
+var (
+	sgrRegexp        = regexp.MustCompile(`\x1b\[([0-9;:]*)m`)
+	decPrivateRegexp = regexp.MustCompile(`\x1b\[\?([0-9;]*)h`)
+)
+
+// maxEscapeSequence is the length of escape sequences carried over between writes by termUsage.
+const maxEscapeSequence = 64
+
+// termUsage records the terminal capabilities used by the output of a command by scanning its escape sequences.
+type termUsage struct {
+	mu      sync.Mutex
+	used    termCap
+	changed chan struct{}
+	// partial is the start of an escape sequence split across writes.
+	partial []byte
+}
+
+func (u *termUsage) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	b := append(u.partial, p...)
+	used := u.used | scanTermCaps(b)
+
+	u.partial = nil
+	if i := bytes.LastIndexByte(b, 0x1b); i >= 0 && len(b)-i < maxEscapeSequence {
+		u.partial = append([]byte(nil), b[i:]...)
+	}
+
+	if used != u.used {
+		u.used = used
+		if u.changed != nil {
+			close(u.changed)
+			u.changed = nil
+		}
+	}
+
+	return len(p), nil
+}
+
+// Used returns the capabilities used so far and a channel that's closed when more are used.
+func (u *termUsage) Used() (termCap, <-chan struct{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.changed == nil {
+		u.changed = make(chan struct{})
+	}
+
+	return u.used, u.changed
+}
+
+// scanTermCaps returns the capabilities used by the escape sequences in b.
+func scanTermCaps(b []byte) termCap {
+	var used termCap
+
+	for _, m := range sgrRegexp.FindAllSubmatch(b, -1) {
+		params := strings.Split(string(m[1]), ";")
+		for i := 0; i < len(params); i++ {
+			// colors are either "38;5;n" and "38;2;r;g;b", or "38:5:n" and "38:2::r:g:b"
+			sub := strings.Split(params[i], ":")
+			if sub[0] != "38" && sub[0] != "48" {
+				continue
+			}
+
+			var mode string
+			if len(sub) > 1 {
+				mode = sub[1]
+			} else if i+1 < len(params) {
+				mode = params[i+1]
+				i++
+			}
+			switch mode {
+			case "5":
+				used |= termCap256Color
+			case "2":
+				used |= termCapTrueColor
+			}
+		}
+	}
+
+	for _, m := range decPrivateRegexp.FindAllSubmatch(b, -1) {
+		for _, mode := range strings.Split(string(m[1]), ";") {
+			switch mode {
+			case "47", "1047", "1049":
+				used |= termCapAltScreen
+			}
+		}
+	}
+
+	return used
+}
+
+// lookupTermCaps returns the capabilities that the terminal of a client supports according to its terminfo entry.
+// COLORTERM in the environment of the client announces 24-bit color like in most terminals.
+func lookupTermCaps(term string, env []string) (termCap, error) {
+	ti, err := loadTerminfo(term, terminfoDirs())
+	if err != nil {
+		return 0, err
+	}
+
+	var caps termCap
+	if ti.maxColors >= 256 {
+		caps |= termCap256Color
+	}
+	if ti.maxColors >= 1<<24 || ti.extended["Tc"] || ti.extended["RGB"] {
+		caps |= termCapTrueColor
+	}
+	for _, e := range env {
+		if e == "COLORTERM=truecolor" || e == "COLORTERM=24bit" {
+			caps |= termCapTrueColor
+		}
+	}
+	if ti.enterCAMode {
+		caps |= termCapAltScreen
+	}
+
+	return caps, nil
+}
+
+// writeTermCapAdvisory writes an advisory to w once the command uses capabilities that the terminal
+// of a client lacks, so that the client knows why the screen is garbled, until ctx is done.
+func writeTermCapAdvisory(ctx context.Context, usage *termUsage, term string, supported termCap, w io.Writer) error {
+	for {
+		used, changed := usage.Used()
+		if lacked := used &^ supported; lacked != 0 {
+			if _, err := io.WriteString(w, termCapAdvisory(term, lacked)); err != nil {
+				return err
+			}
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func termCapAdvisory(term string, lacked termCap) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r\n=== Your terminal (TERM=%s) lacks capabilities used in this session ===\r\n", term)
+	for _, a := range termCapAdvice {
+		if lacked&a.cap != 0 {
+			fmt.Fprintf(&b, "  - %s\r\n", a.advice)
+		}
+	}
+	b.WriteString("\r\n")
+
+	return b.String()
+}
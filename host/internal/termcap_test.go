@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_scanTermCaps(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   termCap
+	}{
+		{name: "plain", output: "hello\r\n", want: 0},
+		{name: "16 colors", output: "\x1b[1;31mred\x1b[0m", want: 0},
+		{name: "256 colors", output: "\x1b[38;5;208mOrange", want: termCap256Color},
+		{name: "256 colors with colons", output: "\x1b[48:5:208m", want: termCap256Color},
+		{name: "24-bit color", output: "\x1b[0;38;2;255;128;0m", want: termCapTrueColor},
+		{name: "24-bit color with colons", output: "\x1b[48:2::255:128:0m", want: termCapTrueColor},
+		{name: "color index is not a mode", output: "\x1b[38;5;2m", want: termCap256Color},
+		{name: "alternate screen", output: "\x1b[?1049h\x1b[H", want: termCapAltScreen},
+		{name: "legacy alternate screen", output: "\x1b[?1;47h", want: termCapAltScreen},
+		{name: "leaving alternate screen", output: "\x1b[?1049l", want: 0},
+		{name: "bracketed paste", output: "\x1b[?2004h", want: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := scanTermCaps([]byte(c.output)); got != c.want {
+				t.Fatalf("want=%b got=%b", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_termUsage(t *testing.T) {
+	var u termUsage
+
+	used, changed := u.Used()
+	if used != 0 {
+		t.Fatalf("want no capabilities used, got %b", used)
+	}
+
+	// escape sequences split across writes are recognized
+	_, _ = u.Write([]byte("vim\r\n\x1b[?10"))
+	_, _ = u.Write([]byte("49h"))
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("want change notified")
+	}
+	if used, _ := u.Used(); used != termCapAltScreen {
+		t.Fatalf("want=%b got=%b", termCapAltScreen, used)
+	}
+}
+
+func Test_writeTermCapAdvisory(t *testing.T) {
+	var u termUsage
+	_, _ = u.Write([]byte("\x1b[38;5;208m"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	advisories := make(chan string, 2)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- writeTermCapAdvisory(ctx, &u, "xterm-256color", termCap256Color|termCapAltScreen, writerFunc(func(p []byte) (int, error) {
+			advisories <- string(p)
+			return len(p), nil
+		}))
+	}()
+
+	select {
+	case a := <-advisories:
+		t.Fatalf("want no advisory for supported capabilities, got %q", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, _ = u.Write([]byte("\x1b[38;2;1;2;3m"))
+	want := "\r\n=== Your terminal (TERM=xterm-256color) lacks capabilities used in this session ===\r\n" +
+		"  - 24-bit color: join with COLORTERM=truecolor and ssh -o SendEnv=COLORTERM if your terminal supports it\r\n\r\n"
+	select {
+	case got := <-advisories:
+		if got != want {
+			t.Fatalf("want=%q got=%q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want advisory")
+	}
+
+	// the advisory is written once
+	_, _ = u.Write([]byte("\x1b[?1049h"))
+	select {
+	case a := <-advisories:
+		t.Fatalf("want a single advisory, got %q", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled, got %v", err)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func Test_parseTerminfo(t *testing.T) {
+	cases := []struct {
+		name     string
+		entry    []byte
+		colors   int
+		caMode   bool
+		extended []string
+	}{
+		{
+			name:   "legacy",
+			entry:  encodeTerminfo(false, 8, false, nil),
+			colors: 8,
+		},
+		{
+			name:     "extended",
+			entry:    encodeTerminfo(false, 256, true, []string{"Tc"}),
+			colors:   256,
+			caMode:   true,
+			extended: []string{"Tc"},
+		},
+		{
+			name:     "32-bit numbers",
+			entry:    encodeTerminfo(true, 1<<24, true, []string{"RGB"}),
+			colors:   1 << 24,
+			caMode:   true,
+			extended: []string{"RGB"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ti, err := parseTerminfo(c.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ti.maxColors != c.colors {
+				t.Fatalf("want colors=%d got=%d", c.colors, ti.maxColors)
+			}
+			if ti.enterCAMode != c.caMode {
+				t.Fatalf("want smcup=%t got=%t", c.caMode, ti.enterCAMode)
+			}
+			if len(ti.extended) != len(c.extended) {
+				t.Fatalf("want extended=%v got=%v", c.extended, ti.extended)
+			}
+			for _, name := range c.extended {
+				if !ti.extended[name] {
+					t.Fatalf("want extended=%v got=%v", c.extended, ti.extended)
+				}
+			}
+		})
+	}
+
+	if _, err := parseTerminfo([]byte{0x1a, 0x01, 0x00}); err == nil {
+		t.Fatal("want error parsing truncated entry")
+	}
+}
+
+func Test_loadTerminfo(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "7a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "7a", "zterm"), encodeTerminfo(false, 256, true, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ti, err := loadTerminfo("zterm", []string{filepath.Join(dir, "missing"), dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.maxColors != 256 {
+		t.Fatalf("want colors=256 got=%d", ti.maxColors)
+	}
+
+	if _, err := loadTerminfo("yterm", []string{dir}); !errors.Is(err, errTerminfoNotFound) {
+		t.Fatalf("want not found, got %v", err)
+	}
+	if _, err := loadTerminfo("../zterm", []string{dir}); err == nil {
+		t.Fatal("want error for invalid name")
+	}
+}
+
+// encodeTerminfo encodes a compiled terminfo entry with max_colors, optionally enter_ca_mode and
+// the extended booleans.
+func encodeTerminfo(wide bool, colors int, caMode bool, extended []string) []byte {
+	var b bytes.Buffer
+	put := func(v ...int) {
+		for _, n := range v {
+			_ = binary.Write(&b, binary.LittleEndian, int16(n))
+		}
+	}
+	putNum := func(n int) {
+		if wide {
+			_ = binary.Write(&b, binary.LittleEndian, int32(n))
+		} else {
+			put(n)
+		}
+	}
+	align := func() {
+		if b.Len()%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+
+	names := "test|test terminal\x00"
+	smcup := "\x1b[?1049h\x00"
+	magic := terminfoMagic
+	if wide {
+		magic = terminfoMagic32
+	}
+	put(magic, len(names), 1, terminfoMaxColors+1, terminfoEnterCAMode+1, len(smcup))
+	b.WriteString(names)
+	b.WriteByte(1)
+	align()
+	for i := 0; i < terminfoMaxColors; i++ {
+		putNum(-1)
+	}
+	putNum(colors)
+	for i := 0; i < terminfoEnterCAMode; i++ {
+		put(-1)
+	}
+	if caMode {
+		put(0)
+	} else {
+		put(-1)
+	}
+	b.WriteString(smcup)
+
+	if len(extended) == 0 {
+		return b.Bytes()
+	}
+
+	align()
+	table := strings.Join(extended, "\x00") + "\x00"
+	put(len(extended), 0, 0, len(extended), len(table))
+	for range extended {
+		b.WriteByte(1)
+	}
+	align()
+	off := 0
+	for _, name := range extended {
+		put(off)
+		off += len(name) + 1
+	}
+	b.WriteString(table)
+
+	return b.Bytes()
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	terminfoMagic   = 0432
+	terminfoMagic32 = 01036
+
+	// terminfoMaxColors is the index of the max_colors number capability.
+	terminfoMaxColors = 13
+	// terminfoEnterCAMode is the index of the enter_ca_mode string capability, which switches to the alternate screen.
+	terminfoEnterCAMode = 28
+)
+
+var errTerminfoNotFound = errors.New("terminfo entry not found")
+
+// terminfo is the subset of a compiled terminfo entry used to compare the capabilities of terminals.
+type terminfo struct {
+	maxColors   int
+	enterCAMode bool
+	// extended are the names of the extended capabilities that are set, e.g. Tc or RGB.
+	extended map[string]bool
+}
+
+// terminfoDirs returns the directories searched for terminfo entries in the order of ncurses.
+func terminfoDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("TERMINFO"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("TERMINFO_DIRS")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo", "/usr/share/lib/terminfo")
+}
+
+// loadTerminfo loads the compiled terminfo entry of term from dirs.
+func loadTerminfo(term string, dirs []string) (*terminfo, error) {
+	if term == "" || strings.ContainsAny(term, `/\`) || term[0] == '.' {
+		return nil, fmt.Errorf("invalid terminal name %q", term)
+	}
+
+	for _, dir := range dirs {
+		// entries are in a directory named after their first letter, or its hex code on case-insensitive file systems
+		for _, sub := range []string{term[:1], fmt.Sprintf("%x", term[0])} {
+			b, err := os.ReadFile(filepath.Join(dir, sub, term))
+			if err != nil {
+				continue
+			}
+
+			ti, err := parseTerminfo(b)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing terminfo entry of %s: %w", term, err)
+			}
+
+			return ti, nil
+		}
+	}
+
+	return nil, errTerminfoNotFound
+}
+
+// terminfoReader reads the sections of a compiled terminfo entry described in term(5).
+type terminfoReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *terminfoReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = errors.New("truncated terminfo entry")
+		return nil
+	}
+
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *terminfoReader) shorts(n int) []int {
+	b := r.bytes(2 * n)
+	if b == nil {
+		return nil
+	}
+
+	s := make([]int, n)
+	for i := range s {
+		s[i] = int(int16(binary.LittleEndian.Uint16(b[2*i:])))
+	}
+	return s
+}
+
+func (r *terminfoReader) numbers(n int, wide bool) []int {
+	if !wide {
+		return r.shorts(n)
+	}
+
+	b := r.bytes(4 * n)
+	if b == nil {
+		return nil
+	}
+
+	s := make([]int, n)
+	for i := range s {
+		s[i] = int(int32(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return s
+}
+
+// align skips the null byte inserted to align sections on an even byte boundary.
+func (r *terminfoReader) align() {
+	if r.off%2 == 1 && r.off < len(r.b) {
+		r.off++
+	}
+}
+
+func parseTerminfo(b []byte) (*terminfo, error) {
+	r := &terminfoReader{b: b}
+
+	header := r.shorts(6)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var wide bool
+	switch header[0] {
+	case terminfoMagic:
+	case terminfoMagic32:
+		wide = true
+	default:
+		return nil, fmt.Errorf("unknown terminfo magic %#o", header[0])
+	}
+
+	namesSize, boolCount, numCount, strCount, strTableSize := header[1], header[2], header[3], header[4], header[5]
+	r.bytes(namesSize)
+	r.bytes(boolCount)
+	r.align()
+	nums := r.numbers(numCount, wide)
+	strs := r.shorts(strCount)
+	r.bytes(strTableSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	ti := &terminfo{
+		maxColors: -1,
+		extended:  make(map[string]bool),
+	}
+	if len(nums) > terminfoMaxColors {
+		ti.maxColors = nums[terminfoMaxColors]
+	}
+	if len(strs) > terminfoEnterCAMode {
+		ti.enterCAMode = strs[terminfoEnterCAMode] >= 0
+	}
+
+	// the extended section is optional
+	r.align()
+	if r.off >= len(b) {
+		return ti, nil
+	}
+
+	extHeader := r.shorts(5)
+	if r.err != nil {
+		return nil, r.err
+	}
+	extBoolCount, extNumCount, extStrCount, extTableSize := extHeader[0], extHeader[1], extHeader[2], extHeader[4]
+	extBools := r.bytes(extBoolCount)
+	r.align()
+	extNums := r.numbers(extNumCount, wide)
+	extStrs := r.shorts(extStrCount)
+	extNames := r.shorts(extBoolCount + extNumCount + extStrCount)
+	table := r.bytes(extTableSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// the names follow the values of the strings that are set in the string table
+	namesOff := 0
+	for _, off := range extStrs {
+		if off < 0 {
+			continue
+		}
+		i := strings.IndexByte(string(table[namesOff:]), 0)
+		if i < 0 {
+			return nil, errors.New("malformed terminfo string table")
+		}
+		namesOff += i + 1
+	}
+
+	set := make([]bool, 0, len(extNames))
+	for _, v := range extBools {
+		set = append(set, v == 1)
+	}
+	for _, v := range extNums {
+		set = append(set, v >= 0)
+	}
+	for _, v := range extStrs {
+		set = append(set, v >= 0)
+	}
+
+	for i, off := range extNames {
+		if off < 0 || namesOff+off >= len(table) {
+			continue
+		}
+		name, _, _ := strings.Cut(string(table[namesOff+off:]), "\x00")
+		if set[i] {
+			ti.extended[name] = true
+		}
+	}
+
+	return ti, nil
+}
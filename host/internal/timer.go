@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timerInterval is how often the terminal titles are checked for updates. Titles change once a minute at most,
+// so that clients aren't flooded.
+const timerInterval = time.Second
+
+const (
+	// titlePush saves the title of the terminal before the timer replaces it, and titlePop restores it.
+	// Terminals without a title stack ignore them.
+	titlePush = "\x1b[22;2t"
+	titlePop  = "\x1b[23;2t"
+)
+
+// SessionTimer shows how long the session has run and how long it has left in the terminal titles of the host
+// and clients, so that nobody is surprised when the session ends. The titles of clients also tell when they are
+// disconnected for being idle.
+type SessionTimer struct {
+	// StartedAt is when the session starts, i.e. when the session is created or when clients are allowed in if later.
+	StartedAt time.Time
+	// EndsAt is when the session ends by its max duration or by the max session age of the server.
+	// It's zero if the session doesn't end.
+	EndsAt time.Time
+}
+
+// title returns the terminal title as of now, or empty if there is nothing to show.
+// idle is the idle timer of a client, or nil for the host.
+func (t *SessionTimer) title(now time.Time, idle *idleTimer) string {
+	if t.EndsAt.IsZero() && idle == nil {
+		return ""
+	}
+
+	if now.Before(t.StartedAt) {
+		return fmt.Sprintf("upterm: starts in %s", formatTimerDuration(t.StartedAt.Sub(now)))
+	}
+
+	parts := []string{fmt.Sprintf("%s elapsed", formatTimerDuration(now.Sub(t.StartedAt)))}
+	if !t.EndsAt.IsZero() {
+		parts = append(parts, fmt.Sprintf("%s left", formatTimerDuration(t.EndsAt.Sub(now))))
+	}
+	if idle != nil {
+		parts = append(parts, fmt.Sprintf("idle timeout in %s", formatTimerDuration(idle.timeout-idle.Idle(now))))
+	}
+
+	return "upterm: " + strings.Join(parts, ", ")
+}
+
+// Writer decorates the output to w with the terminal title. idle is the idle timer of a client, or nil for the host.
+// The title is written by Run.
+func (t *SessionTimer) Writer(w io.Writer, idle *idleTimer) *timerWriter {
+	return newTitleWriter(w, func(now time.Time) string {
+		return t.title(now, idle)
+	})
+}
+
+// newTitleWriter decorates the output to w with the terminal title returned by title, which is written by Run.
+// The previous title is restored while title is empty.
+func newTitleWriter(w io.Writer, title func(now time.Time) string) *timerWriter {
+	return &timerWriter{w: w, title: title}
+}
+
+// timerWriter writes the output of the session and updates the terminal title in between,
+// never in the middle of an escape sequence or a UTF-8 character of the output.
+type timerWriter struct {
+	w     io.Writer
+	title func(now time.Time) string
+
+	mu     sync.Mutex
+	output outputState
+	shown  string
+}
+
+func (w *timerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.w.Write(p)
+	w.output.Scan(p[:n])
+
+	return n, err
+}
+
+// Run updates the terminal title until ctx is done, and then restores the previous title.
+func (w *timerWriter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(timerInterval)
+	defer ticker.Stop()
+	defer w.restore()
+
+	w.tick(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			w.tick(now)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tick writes the title as of now if it has changed and the output is between escape sequences.
+func (w *timerWriter) tick(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	title := w.title(now)
+	if title == w.shown || !w.output.Ground() {
+		return
+	}
+	if title == "" {
+		w.restoreLocked()
+		return
+	}
+
+	seq := fmt.Sprintf("\x1b]2;%s\a", title)
+	if w.shown == "" {
+		seq = titlePush + seq
+	}
+	if _, err := io.WriteString(w.w, seq); err == nil {
+		w.shown = title
+	}
+}
+
+func (w *timerWriter) restore() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.restoreLocked()
+}
+
+func (w *timerWriter) restoreLocked() {
+	if w.shown == "" || !w.output.Ground() {
+		return
+	}
+
+	if _, err := io.WriteString(w.w, titlePop); err == nil {
+		w.shown = ""
+	}
+}
+
+// outputState tracks whether terminal output ends in the middle of an escape sequence or a UTF-8 character.
+type outputState struct {
+	esc  escState
+	utf8 int // continuation bytes of a UTF-8 character still to come
+}
+
+type escState int
+
+const (
+	escGround    escState = iota
+	escStart              // ESC
+	escCSI                // ESC [
+	escString             // OSC, DCS, SOS, PM, or APC, terminated by BEL or ST
+	escStringEsc          // ESC within a string, which is ST if followed by a backslash
+)
+
+func (s *outputState) Scan(p []byte) {
+	for _, b := range p {
+		switch s.esc {
+		case escGround:
+			switch {
+			case b == 0x1b:
+				s.esc, s.utf8 = escStart, 0
+			case b&0xc0 == 0x80:
+				if s.utf8 > 0 {
+					s.utf8--
+				}
+			case b&0xe0 == 0xc0:
+				s.utf8 = 1
+			case b&0xf0 == 0xe0:
+				s.utf8 = 2
+			case b&0xf8 == 0xf0:
+				s.utf8 = 3
+			default:
+				s.utf8 = 0
+			}
+		case escStart:
+			switch {
+			case b == '[':
+				s.esc = escCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				s.esc = escString
+			case b >= 0x20 && b <= 0x2f:
+				// intermediate bytes, e.g. of character set designations
+			default:
+				s.esc = escGround
+			}
+		case escCSI:
+			if b >= 0x40 && b <= 0x7e {
+				s.esc = escGround
+			}
+		case escString:
+			switch b {
+			case '\a':
+				s.esc = escGround
+			case 0x1b:
+				s.esc = escStringEsc
+			}
+		case escStringEsc:
+			if b == '\\' {
+				s.esc = escGround
+			} else {
+				s.esc = escString
+			}
+		}
+	}
+}
+
+// Ground reports whether the output is between escape sequences and characters.
+func (s *outputState) Ground() bool {
+	return s.esc == escGround && s.utf8 == 0
+}
+
+// formatTimerDuration formats d in minutes, e.g. 1h05m or 42m, or <1m under a minute.
+func formatTimerDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "<1m"
+	}
+
+	d = d.Truncate(time.Minute)
+	if h := d / time.Hour; h > 0 {
+		return fmt.Sprintf("%dh%02dm", h, (d%time.Hour)/time.Minute)
+	}
+
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
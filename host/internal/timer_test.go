@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_SessionTimer_title(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		timer SessionTimer
+		now   time.Time
+		idle  *idleTimer
+		want  string
+	}{
+		{
+			name:  "no limits",
+			timer: SessionTimer{StartedAt: start},
+			now:   start.Add(time.Hour),
+		},
+		{
+			name:  "max duration",
+			timer: SessionTimer{StartedAt: start, EndsAt: start.Add(2 * time.Hour)},
+			now:   start.Add(12*time.Minute + 30*time.Second),
+			want:  "upterm: 12m elapsed, 1h47m left",
+		},
+		{
+			name:  "ending",
+			timer: SessionTimer{StartedAt: start, EndsAt: start.Add(time.Hour)},
+			now:   start.Add(time.Hour - time.Second),
+			want:  "upterm: 59m elapsed, <1m left",
+		},
+		{
+			name:  "not started",
+			timer: SessionTimer{StartedAt: start, EndsAt: start.Add(time.Hour)},
+			now:   start.Add(-5 * time.Minute),
+			want:  "upterm: starts in 5m",
+		},
+		{
+			name:  "idle timeout",
+			timer: SessionTimer{StartedAt: start},
+			now:   start.Add(20 * time.Minute),
+			idle:  newIdleTimer(15*time.Minute, start.Add(10*time.Minute)),
+			want:  "upterm: 20m elapsed, idle timeout in 5m",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.timer.title(c.now, c.idle); c.want != got {
+				t.Fatalf("want=%q got=%q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_timerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	title := "upterm: 1m elapsed, 59m left"
+	w := &timerWriter{
+		w:     &buf,
+		title: func(now time.Time) string { return title },
+	}
+
+	write := func(s string) {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the title isn't written in the middle of an escape sequence or a character of the output
+	for _, partial := range []string{"\x1b[3", "\x1b]0;vim", "\x1b]0;vim\x1b", "\xe2\x94"} {
+		buf.Reset()
+		w.output = outputState{}
+		write(partial)
+		w.tick(time.Now())
+		if want, got := partial, buf.String(); want != got {
+			t.Fatalf("want=%q got=%q", want, got)
+		}
+	}
+
+	buf.Reset()
+	write("1m\x1b]0;vim\a\x1b\\\xe2\x94\x80")
+	w.tick(time.Now())
+	if want, got := "1m\x1b]0;vim\a\x1b\\\xe2\x94\x80"+titlePush+"\x1b]2;"+title+"\a", buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the title is written once until it changes
+	buf.Reset()
+	w.tick(time.Now())
+	if buf.Len() != 0 {
+		t.Fatalf("want no update, got %q", buf.String())
+	}
+	title = "upterm: 2m elapsed, 58m left"
+	w.tick(time.Now())
+	if want, got := "\x1b]2;"+title+"\a", buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the previous title is restored
+	buf.Reset()
+	w.restore()
+	if want, got := titlePop, buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	// the previous title is restored while there is nothing to show
+	w.tick(time.Now())
+	buf.Reset()
+	title = ""
+	w.tick(time.Now())
+	if want, got := titlePop, buf.String(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+}
+
+func Test_formatTimerDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		-time.Minute:                  "<1m",
+		59 * time.Second:              "<1m",
+		42*time.Minute + time.Second:  "42m",
+		time.Hour + 5*time.Minute:     "1h05m",
+		26*time.Hour + 59*time.Second: "26h00m",
+	} {
+		if got := formatTimerDuration(d); want != got {
+			t.Fatalf("%s: want=%s got=%s", d, want, got)
+		}
+	}
+}
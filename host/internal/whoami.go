@@ -0,0 +1,43 @@
+package internal
+
+import (
+	gssh "github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+// whoAmIHandler tells clients how their connections are seen by the host, to debug e.g. which of their keys
+// authenticated or which node they are routed through.
+type whoAmIHandler struct {
+	readonly *ReadOnly
+	logger   log.FieldLogger
+}
+
+func (h whoAmIHandler) HandleWhoAmIRequest(ctx gssh.Context, srv *gssh.Server, req *ssh.Request) (bool, []byte) {
+	c, ok := ctx.Value(contextKeyClient).(*api.Client)
+	if !ok {
+		return false, nil
+	}
+
+	w := &api.WhoAmI{
+		Client:   c,
+		ReadOnly: h.readonly.Get(),
+	}
+	// direct clients may connect with any username
+	user, _ := routing.SplitToken(ctx.User())
+	if id, err := api.DecodeIdentifier(user, ctx.ClientVersion()); err == nil && id.Type == api.Identifier_CLIENT {
+		w.SessionId = id.Id
+		w.SessionNodeAddr = id.NodeAddr
+	}
+
+	b, err := proto.Marshal(w)
+	if err != nil {
+		h.logger.WithError(err).Error("error encoding whoami")
+		return false, nil
+	}
+
+	return true, b
+}
@@ -0,0 +1,11 @@
+package host
+
+import "github.com/owenthereal/upterm/host/internal"
+
+// JoinTokens are one-time tokens to join a session, appended to usernames with routing.WithToken.
+type JoinTokens = internal.JoinTokens
+
+// NewJoinTokens returns an empty store of join tokens.
+func NewJoinTokens() *JoinTokens {
+	return internal.NewJoinTokens()
+}
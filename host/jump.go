@@ -0,0 +1,47 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultJumpPort is the port of jump hosts without one.
+const defaultJumpPort = "22"
+
+// ParseJumpHosts parses the jump hosts of the reverse tunnel, like ProxyJump of OpenSSH.
+// Hosts are separated by commas in the order they are hopped through, each in the form of
+// [user@]host[:port] or ssh://[user@]host[:port], e.g. alice@bastion.example.com,10.0.0.5:2222.
+// Ports default to 22.
+func ParseJumpHosts(s string) ([]*url.URL, error) {
+	var jumps []*url.URL
+	for _, h := range strings.Split(s, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			return nil, fmt.Errorf("empty jump host in %q", s)
+		}
+		if !strings.Contains(h, "://") {
+			h = "ssh://" + h
+		}
+
+		u, err := url.Parse(h)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing jump host: %w", err)
+		}
+		if u.Scheme != "ssh" {
+			return nil, fmt.Errorf("unsupported jump host protocol %s", u.Scheme)
+		}
+		if u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid jump host %q", h)
+		}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), defaultJumpPort)
+		}
+		u.Path = ""
+
+		jumps = append(jumps, u)
+	}
+
+	return jumps, nil
+}
@@ -0,0 +1,39 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseJumpHosts(t *testing.T) {
+	jumps, err := ParseJumpHosts("alice@bastion.example.com, ssh://10.0.0.5:2222,[::1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, j := range jumps {
+		got = append(got, j.String())
+	}
+	want := []string{
+		"ssh://alice@bastion.example.com:22",
+		"ssh://10.0.0.5:2222",
+		"ssh://[::1]:22",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"bastion,",
+		"wss://bastion",
+		"bastion/path",
+		"bastion:port",
+	} {
+		if _, err := ParseJumpHosts(invalid); err == nil {
+			t.Fatalf("want error for %q", invalid)
+		}
+	}
+}
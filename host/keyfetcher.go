@@ -0,0 +1,188 @@
+package host
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+	"github.com/sirupsen/logrus"
+)
+
+// maxKeysBytes bounds the keys fetched for a user.
+const maxKeysBytes = 1 << 20
+
+// KeyFetcher fetches the public keys users publish on a code host.
+type KeyFetcher interface {
+	// Name names the code host, e.g. GitHub.
+	Name() string
+	// Fetch returns the keys of the user in the authorized_keys format.
+	Fetch(ctx context.Context, username string) ([]byte, error)
+}
+
+// KeyFetchers are the fetchers of the supported code hosts by the names of their flags, e.g. github for --github-user.
+var KeyFetchers = map[string]KeyFetcher{
+	"codeberg": &urlKeyFetcher{name: "Codeberg", urlFmt: codebergKeysUrlFmt},
+	"github":   &gitHubKeyFetcher{},
+	"gitlab":   &urlKeyFetcher{name: "GitLab", urlFmt: gitLabKeysUrlFmt},
+	"srht":     &urlKeyFetcher{name: "SourceHut", urlFmt: sourceHutKeysUrlFmt},
+}
+
+// KeyFetcherNames returns the names of KeyFetchers in order.
+func KeyFetcherNames() []string {
+	var names []string
+	for name := range KeyFetchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// UserKeys authorizes the keys users publish on a code host.
+type UserKeys struct {
+	Fetcher   KeyFetcher
+	Usernames []string
+	// CacheDir caches the fetched keys if it's set. The cached keys of a user are used when the code host
+	// is unreachable or fails, so that a session can be hosted or refreshed during an outage.
+	CacheDir string
+	Logger   logrus.FieldLogger
+}
+
+// Fetch fetches the keys of each user, named after the user.
+func (u *UserKeys) Fetch(ctx context.Context) ([]*AuthorizedKey, error) {
+	var (
+		authorizedKeys []*AuthorizedKey
+		seen           = make(map[string]bool)
+	)
+	for _, username := range u.Usernames {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+
+		keysBytes, err := u.fetch(ctx, username)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", username, err)
+		}
+
+		aks, err := parseAuthorizedKeys(keysBytes, username)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", username, err)
+		}
+		aks.nameKeys(username)
+
+		authorizedKeys = append(authorizedKeys, aks)
+	}
+
+	return authorizedKeys, nil
+}
+
+func (u *UserKeys) fetch(ctx context.Context, username string) ([]byte, error) {
+	if u.CacheDir == "" {
+		return u.Fetcher.Fetch(ctx, username)
+	}
+
+	sum := sha256.Sum256([]byte(u.Fetcher.Name() + "\x00" + username))
+	file := filepath.Join(u.CacheDir, hex.EncodeToString(sum[:])+".keys")
+
+	keysBytes, err := u.Fetcher.Fetch(ctx, username)
+	if err != nil {
+		cached, cacheErr := os.ReadFile(file)
+		if cacheErr != nil {
+			return nil, err
+		}
+
+		u.Logger.WithError(err).WithFields(logrus.Fields{"code-host": u.Fetcher.Name(), "user": username}).Warn("error fetching user keys, using cached keys")
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(u.CacheDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, keysBytes, 0600); err != nil {
+		return nil, err
+	}
+
+	return keysBytes, nil
+}
+
+// urlKeyFetcher fetches the keys of users at URLs in the format of urlFmt, e.g. https://gitlab.com/USER.keys.
+type urlKeyFetcher struct {
+	name   string
+	urlFmt string
+	// client defaults to an HTTP client timing out after keyFetchTimeout.
+	client *http.Client
+}
+
+func (f *urlKeyFetcher) Name() string {
+	return f.name
+}
+
+func (f *urlKeyFetcher) Fetch(ctx context.Context, username string) ([]byte, error) {
+	path := url.PathEscape(fmt.Sprintf("%s.keys", username))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(f.urlFmt, path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := f.client
+	if client == nil {
+		client = &http.Client{Timeout: keyFetchTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxKeysBytes))
+}
+
+// gitHubKeyFetcher fetches the keys of users with the GitHub API, authenticated like the GitHub CLI.
+// It falls back to the public keys URL without a token.
+type gitHubKeyFetcher struct{}
+
+func (f *gitHubKeyFetcher) Name() string {
+	return "GitHub"
+}
+
+func (f *gitHubKeyFetcher) Fetch(ctx context.Context, username string) ([]byte, error) {
+	client, err := api.DefaultRESTClient()
+	if err != nil {
+		if strings.Contains(err.Error(), "authentication token not found for host") {
+			// fallback to use the public GH API
+			fallback := urlKeyFetcher{name: f.Name(), urlFmt: gitHubKeysUrlFmt}
+			return fallback.Fetch(ctx, username)
+		}
+
+		return nil, err
+	}
+
+	keys := []struct {
+		Key string `json:"key"`
+	}{}
+	if err := client.DoWithContext(ctx, http.MethodGet, fmt.Sprintf("users/%s/keys", url.PathEscape(username)), nil, &keys); err != nil {
+		return nil, err
+	}
+
+	var authorizedKeys []string
+	for _, key := range keys {
+		authorizedKeys = append(authorizedKeys, key.Key)
+	}
+
+	return []byte(strings.Join(authorizedKeys, "\n")), nil
+}
@@ -0,0 +1,94 @@
+package host
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/owenthereal/upterm/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_UserKeys(t *testing.T) {
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/alice.keys":
+			_, _ = w.Write([]byte(testPublicKey + " alice@laptop\n"))
+		case "/bob.keys":
+			_, _ = w.Write([]byte(testPublicKey2 + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	uk := UserKeys{
+		Fetcher:   &urlKeyFetcher{name: "Test", urlFmt: srv.URL + "/%s"},
+		Usernames: []string{"alice", "bob", "alice"},
+		CacheDir:  t.TempDir(),
+		Logger:    logrus.New(),
+	}
+
+	for _, state := range []string{"up", "down"} {
+		down.Store(state == "down")
+
+		aks, err := uk.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", state, err)
+		}
+		if want, got := 2, len(aks); want != got {
+			t.Fatalf("%s: want=%d got=%d", state, want, got)
+		}
+
+		names := AuthorizedKeyNames(aks)
+		if want, got := "alice", names[utils.FingerprintSHA256(aks[0].PublicKeys[0])]; want != got {
+			t.Fatalf("%s: want=%s got=%s", state, want, got)
+		}
+		if want, got := "bob", names[utils.FingerprintSHA256(aks[1].PublicKeys[0])]; want != got {
+			t.Fatalf("%s: want=%s got=%s", state, want, got)
+		}
+	}
+
+	// users without cached keys fail when the code host is down
+	uk.Usernames = []string{"carol"}
+	if _, err := uk.Fetch(context.Background()); err == nil {
+		t.Fatal("want error fetching keys of uncached user")
+	}
+}
+
+func Test_diffKeys(t *testing.T) {
+	pk1, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk2, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		old, new       []ssh.PublicKey
+		added, removed int
+	}{
+		{old: []ssh.PublicKey{pk1}, new: []ssh.PublicKey{pk1}},
+		{old: []ssh.PublicKey{pk1}, new: []ssh.PublicKey{pk1, pk2}, added: 1},
+		{old: []ssh.PublicKey{pk1, pk2}, new: []ssh.PublicKey{pk2}, removed: 1},
+		{old: []ssh.PublicKey{pk1}, new: []ssh.PublicKey{pk2}, added: 1, removed: 1},
+	}
+
+	for i, c := range cases {
+		added, removed := diffKeys(c.old, c.new)
+		if c.added != added || c.removed != removed {
+			t.Fatalf("%d: want=%d,%d got=%d,%d", i, c.added, c.removed, added, removed)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSessionPoolClosed is returned when a session is spawned after all sessions of a listen-only host have ended.
+var ErrSessionPoolClosed = errors.New("all sessions of the host have ended")
+
+// sessionPool serves the sessions of a listen-only host. Each session is hosted like a single session with its own
+// reverse tunnel, PTY, session ID, and admin socket, copying the options of the template host. Sessions are
+// headless, since the terminal of the host is shared by all of them, and clients resize their PTYs.
+type sessionPool struct {
+	template Host
+	logger   log.FieldLogger
+
+	ctx     context.Context
+	mu      sync.Mutex
+	spawned int
+	pending int
+	// sessions are the established sessions by their IDs.
+	sessions map[string]*api.GetSessionResponse
+	closed   bool
+	done     chan struct{}
+}
+
+func newSessionPool(c *Host) *sessionPool {
+	p := &sessionPool{
+		template: *c,
+		logger:   c.Logger.WithField("com", "session-pool"),
+		sessions: make(map[string]*api.GetSessionResponse),
+		done:     make(chan struct{}),
+	}
+	p.template.ListenOnly = false
+	p.template.pool = p
+
+	// the host agrees to the policy of the server once rather than for each session
+	if agree := c.AgreePolicyCallback; agree != nil {
+		var (
+			mu     sync.Mutex
+			agreed string
+		)
+		p.template.AgreePolicyCallback = func(policy, hash string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if agreed == hash {
+				return nil
+			}
+			if err := agree(policy, hash); err != nil {
+				return err
+			}
+			agreed = hash
+
+			return nil
+		}
+	}
+
+	return p
+}
+
+// Run spawns a session with the command of the host and serves the sessions spawned later until all of them end,
+// or until they are ended by ctx.
+func (p *sessionPool) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.ctx = ctx
+
+	if _, err := p.spawn(nil); err != nil {
+		return err
+	}
+
+	// sessions end with ctx, cleaning up their admin sockets and states
+	<-p.done
+
+	return nil
+}
+
+// spawn hosts another session running command, or the command of the host if it's empty. It returns the session
+// once it's established, or the error establishing it.
+func (p *sessionPool) spawn(command []string) (*api.GetSessionResponse, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrSessionPoolClosed
+	}
+	first := p.spawned == 0
+	p.spawned++
+	p.pending++
+	p.mu.Unlock()
+
+	h := p.template
+	if len(command) > 0 {
+		h.Command = command
+	}
+	// the admin socket of the host is served by its first session, and the others get their own
+	if !first {
+		h.AdminSocketFile = ""
+	}
+
+	stdin, stdinw, err := os.Pipe()
+	if err != nil {
+		p.ended("", err)
+		return nil, err
+	}
+	stdout, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		stdin.Close()
+		stdinw.Close()
+		p.ended("", err)
+		return nil, err
+	}
+	h.Stdin, h.Stdout = stdin, stdout
+
+	var (
+		sessionID string
+		created   = make(chan *api.GetSessionResponse, 1)
+		errCh     = make(chan error, 1)
+	)
+	sessionCreated := h.SessionCreatedCallback
+	h.SessionCreatedCallback = func(s *api.GetSessionResponse) error {
+		if sessionCreated != nil {
+			if err := sessionCreated(s); err != nil {
+				return err
+			}
+		}
+
+		sessionID = s.SessionId
+		p.established(s)
+		created <- s
+
+		return nil
+	}
+
+	go func() {
+		// stdinw is held open so that the session doesn't read EOF from its stdin until it ends
+		defer stdinw.Close()
+		defer stdin.Close()
+		defer stdout.Close()
+
+		err := h.run(p.ctx)
+		p.ended(sessionID, err)
+		errCh <- err
+	}()
+
+	select {
+	case s := <-created:
+		return s, nil
+	case err := <-errCh:
+		if err == nil {
+			err = ErrSessionPoolClosed
+		}
+		return nil, err
+	}
+}
+
+func (p *sessionPool) established(s *api.GetSessionResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending--
+	p.sessions[s.SessionId] = s
+	p.logger.WithField("session", s.SessionId).WithField("cmd", s.Command).Info("Session spawned")
+}
+
+// ended removes the session, closing the pool if it's the last one. sessionID is empty if the session failed to be
+// established.
+func (p *sessionPool) ended(sessionID string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	logger := p.logger
+	if sessionID == "" {
+		p.pending--
+	} else {
+		delete(p.sessions, sessionID)
+		logger = logger.WithField("session", sessionID)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logger = logger.WithError(err)
+	}
+	logger.Info("Session ended")
+
+	if p.pending == 0 && len(p.sessions) == 0 && !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+}
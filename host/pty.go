@@ -0,0 +1,13 @@
+package host
+
+import "github.com/owenthereal/upterm/host/internal"
+
+// PtyBackends are the supported backends attaching commands to terminals.
+var PtyBackends = internal.PtyBackends
+
+// ValidatePtyBackend returns an error if the pty backend with the name isn't supported on this platform,
+// e.g. tmux isn't installed.
+func ValidatePtyBackend(name string) error {
+	_, err := internal.NewPtyBackend(name)
+	return err
+}
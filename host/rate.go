@@ -0,0 +1,49 @@
+package host
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rateUnits are the units of rates in bytes per second. Bit units are decimal like tc,
+// e.g. 1mbit. Byte units are binary like curl --limit-rate, e.g. 100k.
+var rateUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"gbit", 1e9 / 8},
+	{"mbit", 1e6 / 8},
+	{"kbit", 1e3 / 8},
+	{"bit", 1.0 / 8},
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+	{"b", 1},
+	{"", 1},
+}
+
+// ParseRate parses a rate, e.g. 1mbit or 100k, into bytes per second.
+func ParseRate(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	for _, u := range rateUnits {
+		num, ok := strings.CutSuffix(v, u.suffix)
+		if !ok {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil || f <= 0 {
+			break
+		}
+
+		rate := int64(f * u.bytes)
+		if rate < 1 {
+			break
+		}
+
+		return rate, nil
+	}
+
+	return 0, fmt.Errorf("invalid rate %q, e.g. 1mbit, 512kbit, or 100k bytes", s)
+}
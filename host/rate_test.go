@@ -0,0 +1,41 @@
+package host
+
+import "testing"
+
+func Test_ParseRate(t *testing.T) {
+	cases := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{rate: "1mbit", want: 125000},
+		{rate: "512kbit", want: 64000},
+		{rate: "1.5Mbit", want: 187500},
+		{rate: "100k", want: 102400},
+		{rate: "2m", want: 2 << 20},
+		{rate: "4096", want: 4096},
+		{rate: "4096b", want: 4096},
+		{rate: "", wantErr: true},
+		{rate: "mbit", wantErr: true},
+		{rate: "-1mbit", wantErr: true},
+		{rate: "1bit", wantErr: true},
+		{rate: "1tbit", wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseRate(c.rate)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%q: want error, got %d", c.rate, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.rate, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: want %d, got %d", c.rate, c.want, got)
+		}
+	}
+}
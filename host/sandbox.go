@@ -0,0 +1,172 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SandboxProfile restricts what a sandboxed command can access.
+type SandboxProfile string
+
+const (
+	// SandboxStrict denies network access and mounts the home directory read-only.
+	SandboxStrict SandboxProfile = "strict"
+	// SandboxNoNetwork denies network access.
+	SandboxNoNetwork SandboxProfile = "no-network"
+	// SandboxReadOnlyHome mounts the home directory read-only.
+	SandboxReadOnlyHome SandboxProfile = "read-only-home"
+)
+
+// SandboxProfiles are the supported sandbox profiles.
+var SandboxProfiles = []SandboxProfile{SandboxStrict, SandboxNoNetwork, SandboxReadOnlyHome}
+
+// SandboxTools are the supported sandbox tools, in the order they are detected.
+var SandboxTools = []string{"bwrap", "firejail", "nsjail"}
+
+// ErrSandboxToolNotFound is returned when the sandbox tool isn't installed.
+var ErrSandboxToolNotFound = errors.New("sandbox tool not found")
+
+// Sandbox wraps the commands of a session in a sandbox tool, so that clients can be handed
+// a shell with restricted access to the host.
+type Sandbox struct {
+	Profile SandboxProfile
+	// Tool is one of SandboxTools. The first installed one is used if it's empty.
+	Tool string
+	// HomeDir is the home directory protected by the profile.
+	HomeDir string
+
+	lookPath func(string) (string, error)
+}
+
+func (s Sandbox) noNetwork() bool {
+	return s.Profile == SandboxStrict || s.Profile == SandboxNoNetwork
+}
+
+func (s Sandbox) readOnlyHome() bool {
+	return s.Profile == SandboxStrict || s.Profile == SandboxReadOnlyHome
+}
+
+// Validate checks the profile and the tool are supported and the tool is installed.
+// It returns the path of the tool.
+func (s Sandbox) Validate() (string, error) {
+	switch s.Profile {
+	case SandboxStrict, SandboxNoNetwork, SandboxReadOnlyHome:
+	default:
+		return "", fmt.Errorf("unsupported sandbox profile %q, supported profiles: %s", s.Profile, joinSandboxProfiles())
+	}
+
+	if s.readOnlyHome() && s.HomeDir == "" {
+		return "", fmt.Errorf("sandbox profile %q requires a home directory", s.Profile)
+	}
+
+	lookPath := s.lookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+
+	if s.Tool == "" {
+		for _, tool := range SandboxTools {
+			if path, err := lookPath(tool); err == nil {
+				return path, nil
+			}
+		}
+
+		return "", fmt.Errorf("%w: install one of %s, or run without --sandbox", ErrSandboxToolNotFound, strings.Join(SandboxTools, ", "))
+	}
+
+	if !isSandboxTool(s.Tool) {
+		return "", fmt.Errorf("unsupported sandbox tool %q, supported tools: %s", s.Tool, strings.Join(SandboxTools, ", "))
+	}
+
+	path, err := lookPath(s.Tool)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not in PATH, install it or pick another tool of %s", ErrSandboxToolNotFound, s.Tool, strings.Join(SandboxTools, ", "))
+	}
+
+	return path, nil
+}
+
+// Wrap returns the command running cmd in the sandbox.
+func (s Sandbox) Wrap(cmd []string) ([]string, error) {
+	if len(cmd) == 0 {
+		return nil, nil
+	}
+
+	path, err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	switch tool := filepath.Base(path); tool {
+	case "bwrap":
+		args = []string{path, "--dev-bind", "/", "/", "--die-with-parent"}
+		if s.readOnlyHome() {
+			args = append(args, "--ro-bind", s.HomeDir, s.HomeDir)
+		}
+		if s.noNetwork() {
+			args = append(args, "--unshare-net")
+		}
+	case "firejail":
+		args = []string{path, "--quiet", "--noprofile"}
+		if s.readOnlyHome() {
+			args = append(args, "--read-only="+s.HomeDir)
+		}
+		if s.noNetwork() {
+			args = append(args, "--net=none")
+		}
+	case "nsjail":
+		// nsjail isolates the network unless it's disabled. Lift its default time and resource limits
+		// which don't suit an interactive session.
+		args = []string{path, "--mode", "o", "--quiet", "--keep_env", "--chroot", "/", "--rw",
+			"--time_limit", "0", "--rlimit_as", "max", "--rlimit_fsize", "max", "--rlimit_nofile", "max"}
+		if s.readOnlyHome() {
+			args = append(args, "--bindmount_ro", s.HomeDir)
+		}
+		if !s.noNetwork() {
+			args = append(args, "--disable_clone_newnet")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sandbox tool %q", tool)
+	}
+
+	return append(append(args, "--"), cmd...), nil
+}
+
+// String describes the sandbox, e.g. strict (bwrap).
+func (s Sandbox) String() string {
+	tool := s.Tool
+	if tool == "" {
+		if path, err := s.Validate(); err == nil {
+			tool = filepath.Base(path)
+		}
+	}
+
+	if tool == "" {
+		return string(s.Profile)
+	}
+
+	return fmt.Sprintf("%s (%s)", s.Profile, tool)
+}
+
+func isSandboxTool(tool string) bool {
+	for _, t := range SandboxTools {
+		if t == tool {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinSandboxProfiles() string {
+	var profiles []string
+	for _, p := range SandboxProfiles {
+		profiles = append(profiles, string(p))
+	}
+
+	return strings.Join(profiles, ", ")
+}
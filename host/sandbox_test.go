@@ -0,0 +1,87 @@
+package host
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func fakeLookPath(installed ...string) func(string) (string, error) {
+	return func(tool string) (string, error) {
+		for _, t := range installed {
+			if t == tool {
+				return "/usr/bin/" + tool, nil
+			}
+		}
+
+		return "", exec.ErrNotFound
+	}
+}
+
+func Test_Sandbox_Wrap(t *testing.T) {
+	cases := []struct {
+		name    string
+		sandbox Sandbox
+		want    []string
+	}{
+		{
+			name:    "bwrap strict",
+			sandbox: Sandbox{Profile: SandboxStrict, HomeDir: "/home/u", lookPath: fakeLookPath("bwrap", "firejail")},
+			want:    []string{"/usr/bin/bwrap", "--dev-bind", "/", "/", "--die-with-parent", "--ro-bind", "/home/u", "/home/u", "--unshare-net", "--", "bash", "-l"},
+		},
+		{
+			name:    "firejail no network",
+			sandbox: Sandbox{Profile: SandboxNoNetwork, Tool: "firejail", lookPath: fakeLookPath("bwrap", "firejail")},
+			want:    []string{"/usr/bin/firejail", "--quiet", "--noprofile", "--net=none", "--", "bash", "-l"},
+		},
+		{
+			name:    "nsjail read-only home",
+			sandbox: Sandbox{Profile: SandboxReadOnlyHome, HomeDir: "/home/u", lookPath: fakeLookPath("nsjail")},
+			want: []string{"/usr/bin/nsjail", "--mode", "o", "--quiet", "--keep_env", "--chroot", "/", "--rw",
+				"--time_limit", "0", "--rlimit_as", "max", "--rlimit_fsize", "max", "--rlimit_nofile", "max",
+				"--bindmount_ro", "/home/u", "--disable_clone_newnet", "--", "bash", "-l"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.sandbox.Wrap([]string{"bash", "-l"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func Test_Sandbox_Validate(t *testing.T) {
+	s := Sandbox{Profile: SandboxNoNetwork, lookPath: fakeLookPath()}
+	if _, err := s.Validate(); !errors.Is(err, ErrSandboxToolNotFound) {
+		t.Fatalf("want ErrSandboxToolNotFound, got %v", err)
+	}
+
+	s = Sandbox{Profile: SandboxNoNetwork, Tool: "nsjail", lookPath: fakeLookPath("bwrap")}
+	if _, err := s.Validate(); !errors.Is(err, ErrSandboxToolNotFound) {
+		t.Fatalf("want ErrSandboxToolNotFound, got %v", err)
+	}
+
+	s = Sandbox{Profile: SandboxNoNetwork, Tool: "docker", lookPath: fakeLookPath("docker")}
+	if _, err := s.Validate(); err == nil {
+		t.Fatal("want error for unsupported tool")
+	}
+
+	s = Sandbox{Profile: "none", lookPath: fakeLookPath("bwrap")}
+	if _, err := s.Validate(); err == nil {
+		t.Fatal("want error for unsupported profile")
+	}
+
+	s = Sandbox{Profile: SandboxStrict, lookPath: fakeLookPath("bwrap")}
+	if _, err := s.Validate(); err == nil {
+		t.Fatal("want error for missing home directory")
+	}
+}
@@ -0,0 +1,195 @@
+package host
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owenthereal/upterm/upterm"
+)
+
+// PublicServers are the curated public upterm servers.
+var PublicServers = []string{
+	"ssh://uptermd.upterm.dev:22",
+	"wss://uptermd.upterm.dev",
+}
+
+// webSocketFallbackTimeout caps probing the WebSocket endpoint of servers unreachable over SSH.
+const webSocketFallbackTimeout = 10 * time.Second
+
+// ErrNoServerReachable is returned by FastestServer if no server responds.
+var ErrNoServerReachable = errors.New("no server is reachable")
+
+// ServerProbe is the outcome of probing an upterm server.
+type ServerProbe struct {
+	Server string
+	// Version is the identification of the server, e.g. SSH-2.0-uptermd.
+	Version string
+	// Latency is the time to connect to the server and read its identification.
+	Latency time.Duration
+	Err     error
+}
+
+// ReadServerList reads a list of servers, one per line. Blank lines and lines starting with # are ignored.
+func ReadServerList(r io.Reader) ([]string, error) {
+	var servers []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		servers = append(servers, line)
+	}
+
+	return servers, s.Err()
+}
+
+// ProbeServer verifies the server is an upterm server and measures its latency.
+func ProbeServer(ctx context.Context, server string) ServerProbe {
+	p := ServerProbe{Server: server}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+
+	start := time.Now()
+	switch u.Scheme {
+	case "ssh":
+		p.Version, p.Err = probeSSH(ctx, u.Host)
+	case "ws", "wss":
+		p.Version, p.Err = probeWS(ctx, u)
+	default:
+		p.Err = fmt.Errorf("unsupported server protocol %q", u.Scheme)
+	}
+	p.Latency = time.Since(start)
+
+	return p
+}
+
+func probeSSH(ctx context.Context, addr string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	version, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	version = strings.TrimSpace(version)
+	if !strings.HasPrefix(version, upterm.ServerSSHServerVersion) {
+		return "", fmt.Errorf("not an upterm server: %s", version)
+	}
+
+	return version, nil
+}
+
+func probeWS(ctx context.Context, u *url.URL) (string, error) {
+	scheme := "http"
+	if u.Scheme == "wss" {
+		scheme = "https"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/getting-started", scheme, u.Host), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.Proto, nil
+}
+
+// WebSocketFallback returns the WebSocket endpoint of the ssh server u to fall back to when it's unreachable over
+// SSH, e.g. from corporate networks blocking ssh ports. Servers advertise it by serving their getting-started guide
+// over https on the same hostname. It returns nil if the server doesn't.
+func WebSocketFallback(ctx context.Context, u *url.URL) *url.URL {
+	if u.Scheme != "ssh" {
+		return nil
+	}
+
+	ws := &url.URL{
+		Scheme: "wss",
+		User:   u.User,
+		Host:   net.JoinHostPort(u.Hostname(), "443"),
+	}
+	ctx, cancel := context.WithTimeout(ctx, webSocketFallbackTimeout)
+	defer cancel()
+	if _, err := probeWS(ctx, ws); err != nil {
+		return nil
+	}
+
+	return ws
+}
+
+// ProbeServers probes the servers concurrently, each within timeout. Reachable servers are sorted
+// by latency, followed by unreachable ones in the original order.
+func ProbeServers(ctx context.Context, servers []string, timeout time.Duration) []ServerProbe {
+	probes := make([]ServerProbe, len(servers))
+
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			probes[i] = ProbeServer(ctx, s)
+		}(i, s)
+	}
+	wg.Wait()
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		pi, pj := probes[i], probes[j]
+		if (pi.Err == nil) != (pj.Err == nil) {
+			return pi.Err == nil
+		}
+		if pi.Err != nil {
+			return false
+		}
+
+		return pi.Latency < pj.Latency
+	})
+
+	return probes
+}
+
+// FastestServer returns the reachable server with the lowest latency.
+func FastestServer(ctx context.Context, servers []string, timeout time.Duration) (string, error) {
+	probes := ProbeServers(ctx, servers, timeout)
+	if len(probes) == 0 || probes[0].Err != nil {
+		return "", ErrNoServerReachable
+	}
+
+	return probes[0].Server, nil
+}
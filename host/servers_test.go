@@ -0,0 +1,99 @@
+package host
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/upterm"
+)
+
+func Test_ReadServerList(t *testing.T) {
+	servers, err := ReadServerList(strings.NewReader(`
+# public
+ssh://uptermd.upterm.dev:22
+
+  wss://uptermd.upterm.dev  
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"ssh://uptermd.upterm.dev:22", "wss://uptermd.upterm.dev"}
+	if strings.Join(want, ",") != strings.Join(servers, ",") {
+		t.Fatalf("want servers %v, got %v", want, servers)
+	}
+}
+
+func serveVersion(t *testing.T, version string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(version + "\r\n"))
+			conn.Close()
+		}
+	}()
+
+	return "ssh://" + ln.Addr().String()
+}
+
+func Test_ProbeServers(t *testing.T) {
+	uptermd := serveVersion(t, upterm.ServerSSHServerVersion)
+	openssh := serveVersion(t, "SSH-2.0-OpenSSH_9.6")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "ssh://" + ln.Addr().String()
+	ln.Close()
+
+	probes := ProbeServers(context.Background(), []string{closed, openssh, uptermd}, time.Second)
+	if want, got := 3, len(probes); want != got {
+		t.Fatalf("want %d probes, got %d", want, got)
+	}
+
+	if want, got := uptermd, probes[0].Server; want != got {
+		t.Fatalf("want reachable server first %s, got %s", want, got)
+	}
+	if probes[0].Err != nil {
+		t.Fatal(probes[0].Err)
+	}
+	if !strings.HasPrefix(probes[0].Version, "SSH-2.0-uptermd") {
+		t.Fatalf("want upterm version, got %q", probes[0].Version)
+	}
+
+	// unreachable servers keep their order
+	for i, want := range []string{closed, openssh} {
+		p := probes[i+1]
+		if p.Server != want {
+			t.Fatalf("want unreachable server %s, got %s", want, p.Server)
+		}
+		if p.Err == nil {
+			t.Fatalf("want error probing %s", p.Server)
+		}
+	}
+
+	fastest, err := FastestServer(context.Background(), []string{closed, uptermd}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fastest != uptermd {
+		t.Fatalf("want fastest server %s, got %s", uptermd, fastest)
+	}
+
+	if _, err := FastestServer(context.Background(), []string{closed}, time.Second); err != ErrNoServerReachable {
+		t.Fatalf("want %s, got %v", ErrNoServerReachable, err)
+	}
+}
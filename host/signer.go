@@ -3,8 +3,10 @@ package host
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
@@ -18,6 +20,8 @@ import (
 
 const (
 	errCannotDecodeEncryptedPrivateKeys = "cannot decode encrypted private keys"
+	// openSSHKeyMagic starts the OpenSSH private key format, see PROTOCOL.key.
+	openSSHKeyMagic = "openssh-key-v1\x00"
 )
 
 type errDescryptingPrivateKey struct {
@@ -31,6 +35,8 @@ func (e *errDescryptingPrivateKey) Error() string {
 // Signers return signers based on the following conditions:
 // If SSH agent is running and has keys, it returns signers from SSH agent, otherwise return signers from private keys;
 // If neither works, it generates a signer on the fly.
+// Keys on security keys, e.g. sk-ssh-ed25519 keys on a YubiKey, sign through the SSH agent, which talks to the
+// security key. Users are asked to confirm their presence whenever they sign.
 func Signers(privateKeys []string) ([]ssh.Signer, func(), error) {
 	var (
 		signers []ssh.Signer
@@ -47,12 +53,19 @@ func Signers(privateKeys []string) ([]ssh.Signer, func(), error) {
 		signers, err = utils.CreateSigners(nil)
 	}
 
-	return signers, cleanup, err
+	return presenceSigners(signers, os.Stderr), cleanup, err
 }
 
+// SignersFromFiles returns signers from the private key files that can be read. The files of keys on security keys
+// only hold the handles of the keys, which can't sign without the SSH agent, so they are skipped with a hint.
 func SignersFromFiles(privateKeys []string) ([]ssh.Signer, error) {
 	var signers []ssh.Signer
 	for _, file := range privateKeys {
+		if isSecurityKeyFile(file) {
+			fmt.Fprintf(os.Stderr, "Skipping %s on a security key: add it to the SSH agent with \"ssh-add %s\" to authenticate with it.\n", file, file)
+			continue
+		}
+
 		s, err := signerFromFile(file, promptForPassphrase)
 		if err == nil {
 			signers = append(signers, s)
@@ -80,6 +93,108 @@ func signersFromSSHAgent(socket string) ([]ssh.Signer, func(), error) {
 	return signers, cleanup, err
 }
 
+// isSecurityKey reports whether key, or the key of a cert, is on a security key.
+func isSecurityKey(key ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	switch key.Type() {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return true
+	}
+
+	return false
+}
+
+// isSecurityKeyFile reports whether file is an OpenSSH private key of a key on a security key. The public key is
+// read from the unencrypted header of the file, so that users aren't prompted for the passphrase of a key that
+// can't be used anyway.
+func isSecurityKeyFile(file string) bool {
+	pb, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+
+	block, _ := pem.Decode(pb)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return false
+	}
+	rest, ok := bytes.CutPrefix(block.Bytes, []byte(openSSHKeyMagic))
+	if !ok {
+		return false
+	}
+
+	var header struct {
+		CipherName string
+		KdfName    string
+		KdfOpts    string
+		NumKeys    uint32
+		PubKey     []byte
+		Rest       []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(rest, &header); err != nil {
+		return false
+	}
+	pub, err := ssh.ParsePublicKey(header.PubKey)
+	if err != nil {
+		return false
+	}
+
+	return isSecurityKey(pub)
+}
+
+// hostKeySigners returns the signers the sshd of the host signs key exchanges with. Keys on security keys are
+// skipped, since they can't be host keys and would wait for a touch whenever a client joins. A key is generated on
+// the fly if none is left.
+func hostKeySigners(signers []ssh.Signer) ([]ssh.Signer, error) {
+	var hostSigners []ssh.Signer
+	for _, s := range signers {
+		if !isSecurityKey(s.PublicKey()) {
+			hostSigners = append(hostSigners, s)
+		}
+	}
+	if len(hostSigners) > 0 {
+		return hostSigners, nil
+	}
+
+	return utils.CreateSigners(nil)
+}
+
+// presenceSigners wraps the signers of keys on security keys to ask users on out to confirm their presence when
+// they sign, since security keys wait for a touch silently.
+func presenceSigners(signers []ssh.Signer, out io.Writer) []ssh.Signer {
+	var wrapped []ssh.Signer
+	for _, s := range signers {
+		as, ok := s.(ssh.AlgorithmSigner)
+		if ok && isSecurityKey(s.PublicKey()) {
+			s = presenceSigner{AlgorithmSigner: as, out: out}
+		}
+		wrapped = append(wrapped, s)
+	}
+
+	return wrapped
+}
+
+type presenceSigner struct {
+	ssh.AlgorithmSigner
+	out io.Writer
+}
+
+func (s presenceSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.prompt()
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s presenceSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.prompt()
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func (s presenceSigner) prompt() {
+	fmt.Fprintf(s.out, "Confirm user presence for key %s %s\n", s.PublicKey().Type(), ssh.FingerprintSHA256(s.PublicKey()))
+}
+
 func signerFromFile(file string, promptForPassphrase func(file string) ([]byte, error)) (ssh.Signer, error) {
 	key, err := readPrivateKeyFromFile(file, promptForPassphrase)
 	if err != nil {
@@ -1,12 +1,19 @@
 package host
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -143,3 +150,141 @@ func Test_signerFromFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_isSecurityKeyFile(t *testing.T) {
+	sk := testSecurityKey(t)
+	header := ssh.Marshal(struct {
+		CipherName string
+		KdfName    string
+		KdfOpts    string
+		NumKeys    uint32
+		PubKey     []byte
+		PrivKey    []byte
+	}{"none", "none", "", 1, sk.Marshal(), []byte("key handle")})
+	skPrivateKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte(openSSHKeyMagic), header...),
+	})
+
+	cases := []struct {
+		name       string
+		privateKey string
+		want       bool
+	}{
+		{name: "security key", privateKey: string(skPrivateKey), want: true},
+		{name: "encrypted ed25519 key", privateKey: ed25519PriavteKey},
+		{name: "not a key", privateKey: "not a key"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "private_key")
+			if err := os.WriteFile(file, []byte(c.privateKey), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := isSecurityKeyFile(file); c.want != got {
+				t.Fatalf("want=%t got=%t", c.want, got)
+			}
+		})
+	}
+
+	if isSecurityKeyFile(filepath.Join(t.TempDir(), "missing")) {
+		t.Fatal("missing file isn't a security key")
+	}
+}
+
+func Test_hostKeySigners(t *testing.T) {
+	signers, err := utils.CreateSigners(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := testSecurityKeySigner{pub: testSecurityKey(t)}
+
+	got, err := hostKeySigners([]ssh.Signer{sk, signers[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !utils.KeysEqual(got[0].PublicKey(), signers[0].PublicKey()) {
+		t.Fatalf("security keys shouldn't be host keys: %v", got)
+	}
+
+	// a host key is generated if all keys are on security keys
+	got, err = hostKeySigners([]ssh.Signer{sk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || isSecurityKey(got[0].PublicKey()) {
+		t.Fatalf("a host key should be generated: %v", got)
+	}
+}
+
+func Test_presenceSigners(t *testing.T) {
+	signers, err := utils.CreateSigners(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := testSecurityKeySigner{pub: testSecurityKey(t)}
+
+	var out bytes.Buffer
+	wrapped := presenceSigners([]ssh.Signer{signers[0], sk}, &out)
+
+	if _, err := wrapped[0].Sign(rand.Reader, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("keys not on security keys shouldn't prompt: %q", out.String())
+	}
+
+	if _, err := wrapped[1].(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, []byte("data"), ssh.KeyAlgoSKED25519); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Confirm user presence for key " + ssh.KeyAlgoSKED25519; !strings.HasPrefix(out.String(), want) {
+		t.Fatalf("want prefix %q got %q", want, out.String())
+	}
+
+	// the signer of an announcement doesn't wait for a touch
+	as, err := AnnouncementSigner([]ssh.Signer{sk, signers[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.KeysEqual(as.PublicKey(), signers[0].PublicKey()) {
+		t.Fatal("announcements should be signed with keys not on security keys")
+	}
+}
+
+// testSecurityKey returns a sk-ssh-ed25519 public key, whose private key is on a security key.
+func testSecurityKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+type testSecurityKeySigner struct {
+	pub ssh.PublicKey
+}
+
+func (s testSecurityKeySigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s testSecurityKeySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return &ssh.Signature{Format: s.pub.Type()}, nil
+}
+
+func (s testSecurityKeySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	return s.Sign(rand, data)
+}
@@ -0,0 +1,13 @@
+package host
+
+import "github.com/owenthereal/upterm/host/internal"
+
+// SizePolicies are the supported policies resolving the window of the terminal shared by the host and clients.
+var SizePolicies = internal.SizePolicies
+
+// ValidateSizePolicy returns an error if the size policy with the name isn't supported, or if width and height
+// aren't set for the manual policy only.
+func ValidateSizePolicy(name string, width, height int) error {
+	_, err := internal.NewSizePolicy(name, width, height)
+	return err
+}
@@ -0,0 +1,133 @@
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	StateFileExt = ".json"
+)
+
+// SessionState is the minimal state of a hosted session persisted in the upterm dir.
+// It allows reporting and cleaning up sessions left behind by a crashed host process,
+// and re-establishing them with the same parameters.
+type SessionState struct {
+	SessionID       string    `json:"session_id"`
+	Name            string    `json:"name,omitempty"`
+	Host            string    `json:"host"`
+	Command         []string  `json:"command"`
+	ForceCommand    []string  `json:"force_command,omitempty"`
+	ReadOnly        bool      `json:"read_only,omitempty"`
+	AdminSocketFile string    `json:"admin_socket_file"`
+	PID             int       `json:"pid"`
+	StartedAt       time.Time `json:"started_at"`
+	// Access is restored when the session is re-established, so that it isn't opened to any key.
+	Access SessionAccess `json:"access"`
+}
+
+// SessionAccess is how the host of a session authenticates to the server and whose keys it authorizes, as
+// specified by the flags of upterm host. Files are absolute paths.
+type SessionAccess struct {
+	PrivateKeys        []string `json:"private_keys,omitempty"`
+	KnownHostsFile     string   `json:"known_hosts_file,omitempty"`
+	AuthorizedKeysFile string   `json:"authorized_keys_file,omitempty"`
+	AuthorizedKeysURLs []string `json:"authorized_keys_urls,omitempty"`
+	CertAuthorities    []string `json:"cert_authorities,omitempty"`
+	CodebergUsers      []string `json:"codeberg_users,omitempty"`
+	GitHubUsers        []string `json:"github_users,omitempty"`
+	GitLabUsers        []string `json:"gitlab_users,omitempty"`
+	SourceHutUsers     []string `json:"srht_users,omitempty"`
+	Profile            string   `json:"profile,omitempty"`
+}
+
+// IsProcessAlive reports whether the host process owning the session is still running.
+func (s *SessionState) IsProcessAlive() bool {
+	if s.PID <= 0 {
+		return false
+	}
+
+	return processAlive(s.PID)
+}
+
+func StateFile(sessionID string) string {
+	return fmt.Sprintf("%s%s", sessionID, StateFileExt)
+}
+
+func WriteSessionState(dir string, s *SessionState) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename to avoid leaving a partial state behind
+	file := filepath.Join(dir, StateFile(s.SessionID))
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+func ReadSessionState(file string) (*SessionState, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var s SessionState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("error parsing session state %s: %w", file, err)
+	}
+
+	return &s, nil
+}
+
+// ReadSessionStates returns all persisted session states in dir, oldest first.
+// Malformed state files are skipped.
+func ReadSessionStates(dir string) ([]*SessionState, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*SessionState
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != StateFileExt {
+			continue
+		}
+
+		s, err := ReadSessionState(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+
+		states = append(states, s)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].StartedAt.Before(states[j].StartedAt)
+	})
+
+	return states, nil
+}
+
+// RemoveSessionState removes the state file of a session along with its admin socket.
+func RemoveSessionState(dir string, s *SessionState) error {
+	if s.AdminSocketFile != "" {
+		if err := os.Remove(s.AdminSocketFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, StateFile(s.SessionID))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
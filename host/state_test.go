@@ -0,0 +1,100 @@
+package host
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_SessionState(t *testing.T) {
+	dir := t.TempDir()
+
+	adminSocketFile := filepath.Join(dir, AdminSocketFile("1"))
+	if err := os.WriteFile(adminSocketFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	want := []*SessionState{
+		{
+			SessionID:       "1",
+			Host:            "ssh://uptermd.upterm.dev:22",
+			Command:         []string{"bash"},
+			AdminSocketFile: adminSocketFile,
+			PID:             os.Getpid(),
+			StartedAt:       now,
+		},
+		{
+			SessionID:    "2",
+			Name:         "pairing on the release",
+			Host:         "wss://uptermd.upterm.dev:443",
+			Command:      []string{"tmux", "new", "-t", "pair"},
+			ForceCommand: []string{"tmux", "attach", "-t", "pair"},
+			ReadOnly:     true,
+			StartedAt:    now.Add(time.Minute),
+			Access: SessionAccess{
+				PrivateKeys:        []string{"/home/owen/.ssh/id_ed25519"},
+				AuthorizedKeysFile: "/home/owen/.ssh/authorized_keys",
+				GitHubUsers:        []string{"owenthereal"},
+				Profile:            "hardened",
+			},
+		},
+	}
+
+	// write in reverse order to verify sorting
+	for i := len(want) - 1; i >= 0; i-- {
+		if err := WriteSessionState(dir, want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// malformed state is skipped
+	if err := os.WriteFile(filepath.Join(dir, StateFile("3")), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadSessionStates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if !got[0].IsProcessAlive() {
+		t.Fatal("current process should be alive")
+	}
+	if got[1].IsProcessAlive() {
+		t.Fatal("session without pid should not be alive")
+	}
+
+	// the test binary exits without running tests
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if (&SessionState{PID: exited.Process.Pid}).IsProcessAlive() {
+		t.Fatal("exited process should not be alive")
+	}
+
+	if err := RemoveSessionState(dir, got[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(adminSocketFile); !os.IsNotExist(err) {
+		t.Fatalf("admin socket should be removed: %v", err)
+	}
+
+	got, err = ReadSessionStates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want[1:], got); diff != "" {
+		t.Fatal(diff)
+	}
+}
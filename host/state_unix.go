@@ -0,0 +1,21 @@
+//go:build !windows
+
+package host
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processAlive reports whether the process of pid is running by signaling it with 0, which checks the process
+// exists without signaling it. Processes of other users can't be signaled but are running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,119 @@
+package host
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultVSCodePort is the localhost port code-server listens on by default.
+const DefaultVSCodePort = 8080
+
+// vscodePasswordBytes is the entropy of a generated VS Code password.
+const vscodePasswordBytes = 15
+
+// ErrVSCodeNotFound is returned when code-server isn't installed.
+var ErrVSCodeNotFound = errors.New("code-server not found")
+
+// VSCode serves a folder of the host in VS Code for the web with code-server on a localhost port, which is
+// published to clients like ForwardedPorts, so that invitees can attach an editor besides the terminal.
+// The editor is protected by a password, since other users of the host can reach localhost ports too.
+type VSCode struct {
+	// Port is the localhost port code-server listens on.
+	Port uint32
+	// Dir is the folder opened in the editor. It defaults to the working directory.
+	Dir string
+	// Bin is the code-server binary. It's looked up in PATH if it's empty.
+	Bin string
+	// Password is the password of the editor. A random one is generated if it's empty.
+	Password string
+
+	lookPath func(string) (string, error)
+}
+
+// Validate checks the port is set and code-server is installed. It returns the path of code-server.
+func (v VSCode) Validate() (string, error) {
+	if v.Port == 0 || v.Port > 65535 {
+		return "", fmt.Errorf("VS Code port %d must be between 1 and 65535", v.Port)
+	}
+
+	lookPath := v.lookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+
+	bin := v.Bin
+	if bin == "" {
+		bin = "code-server"
+	}
+	path, err := lookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not in PATH, install it from https://github.com/coder/code-server", ErrVSCodeNotFound, bin)
+	}
+
+	return path, nil
+}
+
+// URL returns the URL clients open after forwarding Port to the same local port.
+func (v VSCode) URL() string {
+	return fmt.Sprintf("http://localhost:%d", v.Port)
+}
+
+// command returns the command serving the editor. The password is passed in the environment,
+// so that it isn't listed with the arguments of the process.
+func (v VSCode) command(ctx context.Context) (*exec.Cmd, error) {
+	path, err := v.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--bind-addr", fmt.Sprintf("127.0.0.1:%d", v.Port),
+		"--auth", "password",
+		"--disable-telemetry",
+		"--disable-update-check",
+	}
+	// code-server reopens the last folder without one
+	dir := v.Dir
+	if dir == "" {
+		dir = "."
+	}
+	args = append(args, dir)
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), "PASSWORD="+v.Password)
+
+	return cmd, nil
+}
+
+// run serves the editor until ctx is done. The editor exiting early is logged without ending the session.
+func (v VSCode) run(ctx context.Context, logger log.FieldLogger) error {
+	cmd, err := v.command(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		logger.WithError(err).Warn("VS Code exited, clients can only attach to the terminal")
+	}
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// newVSCodePassword returns a random password of an editor.
+func newVSCodePassword() (string, error) {
+	b := make([]byte, vscodePasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
@@ -0,0 +1,67 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_VSCode_command(t *testing.T) {
+	v := VSCode{Port: 8080, Password: "secret", lookPath: fakeLookPath("code-server")}
+	cmd, err := v.command(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/usr/bin/code-server", "--bind-addr", "127.0.0.1:8080", "--auth", "password", "--disable-telemetry", "--disable-update-check", "."}
+	if diff := cmp.Diff(want, cmd.Args); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := cmd.Env[len(cmd.Env)-1]; got != "PASSWORD=secret" {
+		t.Fatalf("want the password in the environment, got %q", got)
+	}
+	if want, got := "http://localhost:8080", v.URL(); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
+
+func Test_VSCode_Validate(t *testing.T) {
+	if _, err := (VSCode{Port: 8080, lookPath: fakeLookPath()}).Validate(); !errors.Is(err, ErrVSCodeNotFound) {
+		t.Fatalf("want code-server not found, got %v", err)
+	}
+	if _, err := (VSCode{Port: 8080, Bin: "my-code-server", lookPath: fakeLookPath("code-server")}).Validate(); !errors.Is(err, ErrVSCodeNotFound) {
+		t.Fatalf("want a custom binary looked up, got %v", err)
+	}
+	if _, err := (VSCode{lookPath: fakeLookPath("code-server")}).Validate(); err == nil {
+		t.Fatal("want an error without a port")
+	}
+}
+
+func Test_Host_VSCodeWithRestrictedCommand(t *testing.T) {
+	vscode := &VSCode{Port: 8080, lookPath: fakeLookPath("code-server")}
+	for _, h := range []*Host{
+		{Host: "ssh://127.0.0.1:22", VSCode: vscode, ReadOnly: true},
+		{Host: "ssh://127.0.0.1:22", VSCode: vscode, ForceCommand: []string{"vim"}},
+		{Host: "ssh://127.0.0.1:22", VSCode: vscode, Sandbox: &Sandbox{Profile: SandboxStrict}},
+	} {
+		if err := h.Run(context.Background()); !errors.Is(err, ErrVSCodeWithRestrictedCommand) {
+			t.Fatalf("want vs code refused with a restriction, got %v", err)
+		}
+	}
+}
+
+func Test_newVSCodePassword(t *testing.T) {
+	a, err := newVSCodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newVSCodePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b || len(a) != 24 {
+		t.Fatalf("want distinct passwords of 24 characters, got %q and %q", a, b)
+	}
+}
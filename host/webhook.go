@@ -0,0 +1,190 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/owenthereal/upterm/events"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader carries the HMAC-SHA256 of the body keyed with the webhook secret, hex-encoded and
+	// prefixed with "sha256=", like the webhooks of GitHub. It's omitted without a secret.
+	WebhookSignatureHeader = "X-Upterm-Signature-256"
+	// WebhookEventHeader carries the kind of the event posted.
+	WebhookEventHeader = "X-Upterm-Event"
+
+	// webhookTimeout bounds posting an event.
+	webhookTimeout = 10 * time.Second
+	// webhookAttempts is how many times an event is posted before it's dropped.
+	webhookAttempts = 3
+	// webhookQueueSize bounds the events waiting to be posted, so that a slow webhook doesn't hold up the session.
+	webhookQueueSize = 64
+)
+
+// WebhookEvent is the JSON body posted to webhooks: the envelope of an event of a session.
+type WebhookEvent struct {
+	SessionID string `json:"session_id"`
+	events.Envelope
+}
+
+// NewWebhook returns a webhook posting the events of sessions to u, signing them with secret if it's non-empty.
+// Events are posted in order in the background until the webhook is closed.
+func NewWebhook(u, secret string, logger log.FieldLogger) (*Webhook, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webhook url: %w", err)
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported webhook url %q, must be http or https", u)
+	}
+
+	w := &Webhook{
+		URL:        pu,
+		Secret:     []byte(secret),
+		Client:     &http.Client{Timeout: webhookTimeout},
+		RetryDelay: time.Second,
+		Logger:     logger,
+		queue:      make(chan WebhookEvent, webhookQueueSize),
+		done:       make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Webhook posts the events of sessions as JSON, e.g. to pipe clients joining into a chat or an audit system.
+type Webhook struct {
+	URL    *url.URL
+	Secret []byte
+	Client *http.Client
+	// RetryDelay is the delay before retrying a failed post, doubling with each attempt.
+	RetryDelay time.Duration
+	Logger     log.FieldLogger
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan WebhookEvent
+	done   chan struct{}
+}
+
+// Post queues e of the session to be posted. Events are dropped if the queue is full or the webhook is closed.
+// It's a no-op if w is nil.
+func (w *Webhook) Post(sessionID string, e events.Event) {
+	if w == nil {
+		return
+	}
+
+	env, err := events.NewEnvelope(e, time.Now())
+	if err != nil {
+		w.Logger.WithError(err).Error("error encoding webhook event")
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- WebhookEvent{SessionID: sessionID, Envelope: *env}:
+	default:
+		w.Logger.WithField("kind", e.Kind()).Warn("webhook queue is full, dropping event")
+	}
+}
+
+// Close posts the queued events until ctx is done, and stops the webhook. It's a no-op if w is nil.
+func (w *Webhook) Close(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+
+	for ev := range w.queue {
+		if err := w.post(ev); err != nil {
+			w.Logger.WithError(err).WithFields(log.Fields{"kind": ev.Kind, "session": ev.SessionID}).Error("error posting webhook event")
+		}
+	}
+}
+
+func (w *Webhook) post(ev WebhookEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	delay := w.RetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := w.send(ev.Kind, b)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send posts body once. It reports whether a failure is worth retrying, e.g. a network error or a 5xx response.
+func (w *Webhook) send(kind events.Kind, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(kind))
+	if len(w.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return false, nil
+}
+
+// SignWebhook returns the value of WebhookSignatureHeader of body. Receivers verify it by computing it over the
+// raw body with the shared secret and comparing them in constant time, e.g. with hmac.Equal.
+func SignWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,109 @@
+package host
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/events"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func Test_Webhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []events.Event
+		failed   bool
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if want, got := SignWebhook([]byte("secret"), body), r.Header.Get(WebhookSignatureHeader); !hmac.Equal([]byte(want), []byte(got)) {
+			t.Errorf("signature mismatched, want=%s got=%s", want, got)
+		}
+
+		var ev WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Error(err)
+			return
+		}
+		if ev.SessionID != "session" {
+			t.Errorf("unexpected session id %q", ev.SessionID)
+		}
+		if want, got := string(ev.Kind), r.Header.Get(WebhookEventHeader); want != got {
+			t.Errorf("event header mismatched, want=%s got=%s", want, got)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the first post of a joined client fails and is retried
+		if ev.Kind == events.KindClientJoined && !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		e, err := ev.Event()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		received = append(received, e)
+	}))
+	defer ts.Close()
+
+	w, err := NewWebhook(ts.URL, "secret", log.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RetryDelay = time.Millisecond
+
+	want := []events.Event{
+		events.SessionCreated{SessionID: "session", Host: "ssh://127.0.0.1:22"},
+		events.ClientJoined{Client: &api.Client{Id: "1", PublicKeyFingerprint: "SHA256:foo"}},
+		events.ClientLeft{Client: &api.Client{Id: "1", PublicKeyFingerprint: "SHA256:foo"}},
+		events.SessionEnded{SessionID: "session", Stats: &api.SessionStats{PeakClients: 1}},
+	}
+	for _, e := range want {
+		w.Post("session", e)
+	}
+
+	// events queued are posted before it's closed
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, received, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_Webhook_Invalid(t *testing.T) {
+	for _, u := range []string{"ftp://example.com", "://"} {
+		if _, err := NewWebhook(u, "", log.New()); err == nil {
+			t.Fatalf("%s: want error", u)
+		}
+	}
+
+	// a nil webhook is a no-op
+	var w *Webhook
+	w.Post("session", events.ClientLeft{})
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// commandTimeout is how long a lookup command may run.
+const commandTimeout = 5 * time.Second
+
+// NewCommand returns a resolver running a lookup command for each key, like AuthorizedKeysCommand of OpenSSH,
+// e.g. to look up names in a directory like LDAP. The first line the command prints is the name of the key.
+// The tokens %f, %k, and %t in the command are expanded to the fingerprint, the base64-encoded key,
+// and the key type. Names are cached, so that the command runs once per key.
+func NewCommand(command string) (*Command, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing lookup command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty lookup command")
+	}
+
+	return &Command{
+		args:  args,
+		names: make(map[string]string),
+	}, nil
+}
+
+// Command resolves display names with a lookup command.
+type Command struct {
+	args []string
+
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func (c *Command) Resolve(ctx context.Context, key ssh.PublicKey) (string, error) {
+	fp := utils.FingerprintSHA256(key)
+
+	c.mu.Lock()
+	name, ok := c.names[fp]
+	c.mu.Unlock()
+	if ok {
+		return name, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	tokens := strings.NewReplacer(
+		"%%", "%",
+		"%f", fp,
+		"%k", base64.StdEncoding.EncodeToString(key.Marshal()),
+		"%t", key.Type(),
+	)
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = tokens.Replace(arg)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running lookup command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	if s.Scan() {
+		name = strings.TrimSpace(s.Text())
+	}
+
+	c.mu.Lock()
+	c.names[fp] = name
+	c.mu.Unlock()
+
+	return name, nil
+}
@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// NamesFromFile reads a lookup file of display names. Each line maps either a fingerprint or a public key
+// in the authorized_keys format to a name, e.g.:
+//
+//	SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8 Alice Smith
+//	ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGxm... Bob Jones
+//
+// Blank lines and lines starting with # are ignored.
+func NamesFromFile(file string) (Names, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseNames(b)
+}
+
+// ParseNames parses a lookup file of display names. See NamesFromFile for the format.
+func ParseNames(b []byte) (Names, error) {
+	names := make(Names)
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "SHA256:") {
+			fp, name, _ := strings.Cut(line, " ")
+			if name = strings.TrimSpace(name); name == "" {
+				return nil, fmt.Errorf("line %d: missing name of %s", n, fp)
+			}
+			names[fp] = name
+			continue
+		}
+
+		pk, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if comment == "" {
+			return nil, fmt.Errorf("line %d: missing name of %s", n, utils.FingerprintSHA256(pk))
+		}
+		names[utils.FingerprintSHA256(pk)] = comment
+	}
+
+	return names, s.Err()
+}
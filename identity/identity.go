@@ -0,0 +1,75 @@
+// Package identity maps the public keys of clients to human-readable display names, so that hosts and
+// servers can show who joined a session instead of bare SHA256 fingerprints.
+package identity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// Resolver resolves the display name of a public key.
+type Resolver interface {
+	// Resolve returns the display name of key, or an empty name if the key is unknown.
+	Resolve(ctx context.Context, key ssh.PublicKey) (string, error)
+}
+
+// Chain resolves names with each resolver in order until one knows the key.
+type Chain []Resolver
+
+func (c Chain) Resolve(ctx context.Context, key ssh.PublicKey) (string, error) {
+	for _, r := range c {
+		name, err := r.Resolve(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Names resolves display names from a map of key fingerprints to names, e.g. the comments of authorized keys.
+type Names map[string]string
+
+func (n Names) Resolve(ctx context.Context, key ssh.PublicKey) (string, error) {
+	return n[utils.FingerprintSHA256(key)], nil
+}
+
+// Describe describes a key by its display name and fingerprint, or its fingerprint only if it has no name.
+func Describe(name, fingerprint string) string {
+	if name == "" {
+		return fingerprint
+	}
+
+	return fmt.Sprintf("%s (%s)", name, fingerprint)
+}
+
+// Load returns a chain resolving names from a lookup file and then with a lookup command, skipping the empty ones.
+// It returns nil if both are empty.
+func Load(file, command string) (Resolver, error) {
+	var chain Chain
+	if file != "" {
+		names, err := NamesFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading identity file: %w", err)
+		}
+		chain = append(chain, names)
+	}
+	if command != "" {
+		cmd, err := NewCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cmd)
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,164 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testPublicKey  = `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA8rHJlvwtpjJASeWmCIU8CBvrmKRzUEtVz3g+x6EmgE`
+	testPublicKey2 = `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINwWa43kU++lbWKaZOpPotpH6uf5jYKIuHBZUT/PEddZ`
+)
+
+func parseKey(t *testing.T, s string) ssh.PublicKey {
+	t.Helper()
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pk
+}
+
+type resolverFunc func(ctx context.Context, key ssh.PublicKey) (string, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, key ssh.PublicKey) (string, error) {
+	return f(ctx, key)
+}
+
+func Test_Chain(t *testing.T) {
+	pk1, pk2 := parseKey(t, testPublicKey), parseKey(t, testPublicKey2)
+
+	chain := Chain{
+		Names{utils.FingerprintSHA256(pk1): "alice"},
+		resolverFunc(func(ctx context.Context, key ssh.PublicKey) (string, error) {
+			return "bob", nil
+		}),
+	}
+	for _, c := range []struct {
+		key  ssh.PublicKey
+		want string
+	}{
+		{pk1, "alice"},
+		{pk2, "bob"},
+	} {
+		got, err := chain.Resolve(context.Background(), c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("want=%s got=%s", c.want, got)
+		}
+	}
+
+	wantErr := errors.New("directory unavailable")
+	chain = Chain{
+		Names{},
+		resolverFunc(func(ctx context.Context, key ssh.PublicKey) (string, error) {
+			return "", wantErr
+		}),
+	}
+	if _, err := chain.Resolve(context.Background(), pk1); !errors.Is(err, wantErr) {
+		t.Fatalf("want=%v got=%v", wantErr, err)
+	}
+}
+
+func Test_Describe(t *testing.T) {
+	if want, got := "alice (SHA256:abc)", Describe("alice", "SHA256:abc"); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := "SHA256:abc", Describe("", "SHA256:abc"); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
+
+func Test_ParseNames(t *testing.T) {
+	pk1, pk2 := parseKey(t, testPublicKey), parseKey(t, testPublicKey2)
+
+	names, err := ParseNames([]byte(`
+# team
+` + utils.FingerprintSHA256(pk1) + `   Alice Smith
+` + testPublicKey2 + ` Bob Jones
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Alice Smith", names[utils.FingerprintSHA256(pk1)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := "Bob Jones", names[utils.FingerprintSHA256(pk2)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	for _, invalid := range []string{
+		utils.FingerprintSHA256(pk1),
+		testPublicKey,
+		"not a key",
+	} {
+		if _, err := ParseNames([]byte(invalid)); err == nil {
+			t.Fatalf("want error for %q", invalid)
+		}
+	}
+}
+
+func Test_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("lookup command is a shell script")
+	}
+
+	pk := parseKey(t, testPublicKey)
+
+	calls := filepath.Join(t.TempDir(), "calls")
+	cmd, err := NewCommand(`sh -c 'echo x >> "$0"; echo "user-$1"; echo ignored' ` + calls + ` %t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		name, err := cmd.Resolve(context.Background(), pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "user-ssh-ed25519", name; want != got {
+			t.Fatalf("want=%s got=%s", want, got)
+		}
+	}
+
+	b, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "x\n", string(b); want != got {
+		t.Fatalf("names should be cached: want=%q got=%q", want, got)
+	}
+
+	cmd, err = NewCommand("false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.Resolve(context.Background(), pk); err == nil {
+		t.Fatal("want error of failed command")
+	}
+}
+
+func Test_Load(t *testing.T) {
+	r, err := Load("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatalf("want nil resolver got=%v", r)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Fatal("want error of missing file")
+	}
+}
@@ -0,0 +1,112 @@
+package io
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// CoalescingWriter coalesces small writes into larger ones like Nagle's algorithm, reducing the overhead of
+// packets and WebSocket frames of chatty output, e.g. of TUIs redrawing the screen in many tiny writes.
+//
+// To keep interactive output like echoed keystrokes snappy, a write after the writer has been idle for the delay
+// is written through immediately. Writes following it are buffered and written at most the delay after the first
+// of them, or as soon as they fill the buffer.
+type CoalescingWriter struct {
+	w     io.Writer
+	delay time.Duration
+	size  int
+
+	mu        sync.Mutex
+	buf       []byte
+	timer     *time.Timer
+	lastWrite time.Time
+	closed    bool
+	err       error
+}
+
+// NewCoalescingWriter returns a writer coalescing the writes to w for the delay, up to size bytes.
+func NewCoalescingWriter(w io.Writer, delay time.Duration, size int) *CoalescingWriter {
+	return &CoalescingWriter{
+		w:     w,
+		delay: delay,
+		size:  size,
+	}
+}
+
+func (c *CoalescingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// errors of flushes by the timer are returned by the next write
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	now := time.Now()
+	if c.closed || (len(c.buf) == 0 && now.Sub(c.lastWrite) >= c.delay) {
+		c.lastWrite = now
+		n, err := c.w.Write(p)
+		if err != nil {
+			c.err = err
+		}
+		return n, err
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.size {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.delay, c.flushTimer)
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the buffered writes.
+func (c *CoalescingWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flush()
+}
+
+// Close flushes the buffered writes, and writes the following ones through. It doesn't close the underlying writer.
+func (c *CoalescingWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return c.flush()
+}
+
+func (c *CoalescingWriter) flushTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.flush()
+}
+
+func (c *CoalescingWriter) flush() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 || c.err != nil {
+		return c.err
+	}
+
+	_, err := c.w.Write(c.buf)
+	c.buf = c.buf[:0]
+	c.lastWrite = time.Now()
+	if err != nil {
+		c.err = err
+	}
+
+	return err
+}
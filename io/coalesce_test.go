@@ -0,0 +1,102 @@
+package io
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writesRecorder records each write to tell them apart.
+type writesRecorder struct {
+	mu     sync.Mutex
+	writes []string
+	err    error
+}
+
+func (r *writesRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func (r *writesRecorder) Writes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.writes...)
+}
+
+func Test_CoalescingWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &writesRecorder{}
+	w := NewCoalescingWriter(r, 50*time.Millisecond, 1024)
+
+	// the first write of an idle writer isn't delayed
+	_, _ = w.Write([]byte("a"))
+	assert.Equal([]string{"a"}, r.Writes())
+
+	// writes following it are coalesced until the delay passes
+	for _, s := range []string{"b", "c", "d"} {
+		_, _ = w.Write([]byte(s))
+	}
+	assert.Equal([]string{"a"}, r.Writes())
+	assert.Eventually(func() bool {
+		return len(r.Writes()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal([]string{"a", "bcd"}, r.Writes())
+
+	// after being idle, writes go through immediately again
+	time.Sleep(60 * time.Millisecond)
+	_, _ = w.Write([]byte("e"))
+	assert.Equal([]string{"a", "bcd", "e"}, r.Writes())
+
+	// close flushes the buffered writes and writes the following ones through
+	_, _ = w.Write([]byte("f"))
+	assert.NoError(w.Close())
+	_, _ = w.Write([]byte("g"))
+	assert.Equal([]string{"a", "bcd", "e", "f", "g"}, r.Writes())
+}
+
+func Test_CoalescingWriter_Size(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &writesRecorder{}
+	w := NewCoalescingWriter(r, time.Hour, 4)
+
+	_, _ = w.Write([]byte("a"))
+	_, _ = w.Write([]byte("bc"))
+	assert.Equal([]string{"a"}, r.Writes())
+
+	// a full buffer is written without waiting for the delay
+	_, _ = w.Write([]byte("de"))
+	assert.Equal([]string{"a", "bcde"}, r.Writes())
+}
+
+func Test_CoalescingWriter_Error(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &writesRecorder{}
+	w := NewCoalescingWriter(r, 10*time.Millisecond, 1024)
+
+	_, _ = w.Write([]byte("a"))
+	r.mu.Lock()
+	r.err = errors.New("closed")
+	r.mu.Unlock()
+
+	// the error of flushing with the timer is returned by the next write
+	_, err := w.Write([]byte("b"))
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		_, err := w.Write([]byte("c"))
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,23 @@
+package io
+
+import (
+	"io"
+	"sync"
+)
+
+const copyBufferSize = 32 * 1024
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// Copy is io.Copy with buffers pooled across calls.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	bp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bp)
+
+	return io.CopyBuffer(dst, src, *bp)
+}
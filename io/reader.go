@@ -3,10 +3,13 @@ package io
 import (
 	"context"
 	"io"
+	"sync"
 )
 
+// NewContextReader returns a reader that returns ctx.Err() once ctx is done, even if a read on r blocks.
+// Reads on r happen in a single goroutine that is reused across reads to avoid allocating per read.
 func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
-	return contextReader{
+	return &contextReader{
 		Reader: r,
 		ctx:    ctx,
 	}
@@ -15,6 +18,10 @@ func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
 type contextReader struct {
 	io.Reader
 	ctx context.Context
+
+	once  sync.Once
+	reqCh chan []byte
+	resCh chan readResult
 }
 
 type readResult struct {
@@ -22,28 +29,41 @@ type readResult struct {
 	err error
 }
 
-func (r contextReader) Read(p []byte) (n int, err error) {
-	c := make(chan readResult, 1)
+func (r *contextReader) Read(p []byte) (n int, err error) {
+	// return early if context is done
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
 
-	go func(ctx context.Context, reader io.Reader) {
-		// close by the sender
-		defer close(c)
-
-		// return early if context is done
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
+	r.once.Do(r.start)
 
-		n, err := reader.Read(p)
-		c <- readResult{n, err}
-	}(r.ctx, r.Reader)
+	select {
+	case r.reqCh <- p:
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
 
 	select {
-	case rr := <-c:
+	case rr := <-r.resCh:
 		return rr.n, rr.err
 	case <-r.ctx.Done():
 		return 0, r.ctx.Err()
 	}
 }
+
+func (r *contextReader) start() {
+	r.reqCh = make(chan []byte)
+	r.resCh = make(chan readResult, 1)
+
+	go func(ctx context.Context, reader io.Reader) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p := <-r.reqCh:
+				n, err := reader.Read(p)
+				r.resCh <- readResult{n, err}
+			}
+		}
+	}(r.ctx, r.Reader)
+}
@@ -95,3 +95,17 @@ func Test_ContextReader(t *testing.T) {
 type readFunc func(p []byte) (n int, err error)
 
 func (rf readFunc) Read(p []byte) (n int, err error) { return rf(p) }
+
+func Benchmark_ContextReader_Read(b *testing.B) {
+	r := NewContextReader(context.Background(), readFunc(func(p []byte) (int, error) {
+		return len(p), nil
+	}))
+	p := make([]byte, 32*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.Read(p)
+	}
+}
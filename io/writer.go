@@ -5,10 +5,13 @@ import (
 	"sync"
 )
 
+// buffer keeps the last writes in a ring. Slots are reused to avoid allocating per write.
 type buffer struct {
 	mu sync.Mutex
 
-	queue [][]byte
+	slots [][]byte
+	start int
+	len   int
 	size  int
 }
 
@@ -16,30 +19,45 @@ func (c *buffer) Append(p []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// remove first element if queue is full
-	if len(c.queue) >= c.size {
-		c.queue = c.queue[1:]
+	if c.size <= 0 {
+		return
+	}
+	if c.slots == nil {
+		c.slots = make([][]byte, c.size)
 	}
 
-	pp := make([]byte, len(p))
-	copy(pp, p)
+	// overwrite the oldest slot if the ring is full
+	var i int
+	if c.len < c.size {
+		i = (c.start + c.len) % c.size
+		c.len++
+	} else {
+		i = c.start
+		c.start = (c.start + 1) % c.size
+	}
 
-	c.queue = append(c.queue, pp)
+	c.slots[i] = append(c.slots[i][:0], p...)
 }
 
 func (c *buffer) Size() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return len(c.queue)
+	return c.len
 }
 
+// Data returns a copy of the buffered writes from the oldest to the newest.
 func (c *buffer) Data() [][]byte {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	result := make([][]byte, len(c.queue))
-	return append(result, c.queue...)
+	result := make([][]byte, 0, c.len)
+	for j := 0; j < c.len; j++ {
+		slot := c.slots[(c.start+j)%c.size]
+		result = append(result, append([]byte(nil), slot...))
+	}
+
+	return result
 }
 
 func NewMultiWriter(bufferSize int, writers ...io.Writer) *MultiWriter {
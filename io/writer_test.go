@@ -47,3 +47,15 @@ func Test_MultiWriter(t *testing.T) {
 	assert.Equal("hello1hello2hello3", w2.String())
 	assert.Equal("hello2hello3hello4", w3.String())
 }
+
+func Benchmark_MultiWriter_Write(b *testing.B) {
+	w := NewMultiWriter(5, io.Discard, io.Discard, io.Discard)
+	p := bytes.Repeat([]byte("a"), 32*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = w.Write(p)
+	}
+}
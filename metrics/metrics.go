@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"io"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
@@ -19,3 +20,19 @@ func measureSince(h metrics.Histogram, t0, t1 time.Time, unit float64) {
 	}
 	h.Observe(float64(d) / unit)
 }
+
+// Writer returns a writer adding the bytes written to w to c.
+func Writer(w io.Writer, c metrics.Counter) io.Writer {
+	return &countingWriter{w: w, c: c}
+}
+
+type countingWriter struct {
+	w io.Writer
+	c metrics.Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.c.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,263 @@
+// Package routing encodes and decodes the SSH usernames clients use to join upterm sessions.
+//
+// The username identifies the session and the uptermd node hosting it, so that any node of a cluster
+// can route a client to the session. Tools outside upterm, e.g. chat bots building join commands,
+// can rely on the formats of this package: an encoded username of a Version is decodable by every later
+// release of upterm. A new format is added as a new Version instead of changing an existing one.
+package routing
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Version is the version of a username format.
+type Version int
+
+const (
+	// V1 embeds the node address in the username: SESSION_ID:BASE64URL(NODE_ADDR).
+	V1 Version = 1
+	// V2 is a compact form of V1 usable in ssh:// URIs and QR codes: SESSION_ID.RAWBASE64URL(NODE_ADDR).
+	// It has no colon, which URIs reserve for passwords, and no padding.
+	V2 Version = 2
+
+	// Latest is the version used by upterm to encode usernames.
+	Latest = V1
+)
+
+var (
+	// ErrInvalidIdentifier is returned when a username or a session ID can't be encoded or decoded.
+	ErrInvalidIdentifier = errors.New("invalid client session id")
+	// ErrUnsupportedVersion is returned for an unknown Version.
+	ErrUnsupportedVersion = errors.New("unsupported routing version")
+)
+
+// EncodeDecoder encodes a session ID and a node address into a username, and decodes it back.
+type EncodeDecoder interface {
+	Encode(sessionID, nodeAddr string) (string, error)
+	Decode(user string) (sessionID, nodeAddr string, err error)
+	Version() Version
+}
+
+// NewEncodeDecoder returns the EncodeDecoder of the version.
+func NewEncodeDecoder(v Version) (EncodeDecoder, error) {
+	switch v {
+	case V1:
+		return v1{}, nil
+	case V2:
+		return v2{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+}
+
+// Encode encodes the session ID and the node address into a username with the Latest version.
+func Encode(sessionID, nodeAddr string) (string, error) {
+	return v1{}.Encode(sessionID, nodeAddr)
+}
+
+// Decode decodes a username encoded with any supported version.
+// A join token appended with WithToken is ignored.
+func Decode(user string) (sessionID, nodeAddr string, err error) {
+	user, _ = SplitToken(user)
+	if strings.Contains(user, ":") {
+		return v1{}.Decode(user)
+	}
+
+	return v2{}.Decode(user)
+}
+
+// tokenSeparator separates a username from a join token. It's in neither alphabet of session IDs or base64url.
+const tokenSeparator = "+"
+
+// WithToken appends a one-time join token to a username of any version.
+// Nodes route the username as if it had no token, and the host of the session redeems the token.
+func WithToken(user, token string) string {
+	return user + tokenSeparator + token
+}
+
+// SplitToken splits a username into the username without a join token, and the token.
+// The token is empty if the username doesn't have one.
+func SplitToken(user string) (string, string) {
+	i := strings.LastIndex(user, tokenSeparator)
+	if i < 0 || !IsToken(user[i+1:]) {
+		return user, ""
+	}
+
+	return user[:i], user[i+1:]
+}
+
+// IsToken reports whether s can be a join token appended with WithToken.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+type v1 struct{}
+
+func (v1) Version() Version {
+	return V1
+}
+
+func (v1) Encode(sessionID, nodeAddr string) (string, error) {
+	if sessionID == "" || strings.Contains(sessionID, ":") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, sessionID)
+	}
+
+	return sessionID + ":" + base64.URLEncoding.EncodeToString([]byte(nodeAddr)), nil
+}
+
+func (v1) Decode(user string) (string, string, error) {
+	split := strings.SplitN(user, ":", 2)
+	if len(split) != 2 || split[0] == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentifier, user)
+	}
+
+	nodeAddr, err := base64.URLEncoding.DecodeString(split[1])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentifier, err)
+	}
+
+	return split[0], string(nodeAddr), nil
+}
+
+type v2 struct{}
+
+func (v2) Version() Version {
+	return V2
+}
+
+func (v2) Encode(sessionID, nodeAddr string) (string, error) {
+	if sessionID == "" || strings.ContainsAny(sessionID, ":."+tokenSeparator) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, sessionID)
+	}
+
+	return sessionID + "." + base64.RawURLEncoding.EncodeToString([]byte(nodeAddr)), nil
+}
+
+func (v2) Decode(user string) (string, string, error) {
+	split := strings.SplitN(user, ".", 2)
+	if len(split) != 2 || split[0] == "" || strings.Contains(user, ":") {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentifier, user)
+	}
+
+	nodeAddr, err := base64.RawURLEncoding.DecodeString(split[1])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentifier, err)
+	}
+
+	return split[0], string(nodeAddr), nil
+}
+
+// JoinURI returns an ssh:// URI to join a session as user on an ssh server, e.g. for mobile SSH clients
+// that open URIs. The user should be encoded with V2, since URIs can't have colons in usernames.
+func JoinURI(server, user string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "ssh" {
+		return "", fmt.Errorf("unsupported server protocol %q, join URIs require an ssh server", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host in server %q", server)
+	}
+
+	host := u.Host
+	if u.Port() == "22" {
+		host = u.Hostname()
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+
+	return fmt.Sprintf("ssh://%s@%s", user, host), nil
+}
+
+// ShareLink returns a link to join a session as user on the server. It's the JoinURI of ssh servers, and the
+// ws:// or wss:// URL of the endpoint of WebSocket servers, which 'upterm proxy' connects to.
+func ShareLink(server, user string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		return JoinURI(server, user)
+	case "ws", "wss":
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("missing host in server %q", server)
+		}
+
+		host := u.Host
+		if (u.Scheme == "ws" && u.Port() == "80") || (u.Scheme == "wss" && u.Port() == "443") {
+			host = u.Hostname()
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+
+		return fmt.Sprintf("%s://%s@%s", u.Scheme, user, host), nil
+	default:
+		return "", fmt.Errorf("unsupported server protocol %q", u.Scheme)
+	}
+}
+
+// JoinCommand returns the ssh command to join a session as user on the server,
+// e.g. ssh://uptermd.upterm.dev:22 or wss://uptermd.upterm.dev.
+// For ws and wss servers, the command proxies the connection with 'upterm proxy'.
+func JoinCommand(server, user string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+		switch u.Scheme {
+		case "ssh":
+			port = "22"
+		case "ws":
+			port = "80"
+		case "wss":
+			port = "443"
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("missing host in server %q", server)
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		cmd := fmt.Sprintf("ssh %s@%s", user, host)
+		if port != "22" {
+			cmd = fmt.Sprintf("%s -p %s", cmd, port)
+		}
+
+		return cmd, nil
+	case "ws", "wss":
+		hostPort := host
+		if port != "80" && port != "443" {
+			hostPort = host + ":" + port
+		}
+
+		return fmt.Sprintf("ssh -o ProxyCommand='upterm proxy %s://%s@%s' %s@%s", u.Scheme, user, hostPort, user, host+":"+port), nil
+	default:
+		return "", fmt.Errorf("unsupported server protocol %q", u.Scheme)
+	}
+}
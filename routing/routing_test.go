@@ -0,0 +1,228 @@
+package routing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test_Compatibility pins the encoded usernames of every version.
+// A failure means usernames shared by released versions of upterm no longer decode.
+func Test_Compatibility(t *testing.T) {
+	cases := []struct {
+		version   Version
+		user      string
+		sessionID string
+		nodeAddr  string
+	}{
+		{V1, "10OLFAKZu4cxx2roOboaY:MTI3LjAuMC4xOjIyMjI=", "10OLFAKZu4cxx2roOboaY", "127.0.0.1:2222"},
+		{V1, "cq1b2m7e0q5s3f3k5ko0:dXB0ZXJtZC0wLnVwdGVybWQuaW50ZXJuYWw6MjI=", "cq1b2m7e0q5s3f3k5ko0", "uptermd-0.uptermd.internal:22"},
+		{V1, "session:", "session", ""},
+		{V2, "10OLFAKZu4cxx2roOboaY.MTI3LjAuMC4xOjIyMjI", "10OLFAKZu4cxx2roOboaY", "127.0.0.1:2222"},
+		{V2, "cq1b2m7e0q5s3f3k5ko0.dXB0ZXJtZC0wLnVwdGVybWQuaW50ZXJuYWw6MjI", "cq1b2m7e0q5s3f3k5ko0", "uptermd-0.uptermd.internal:22"},
+	}
+
+	for _, c := range cases {
+		ed, err := NewEncodeDecoder(c.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := ed.Encode(c.sessionID, c.nodeAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != c.user {
+			t.Errorf("v%d encode: want=%s got=%s", c.version, c.user, user)
+		}
+
+		sessionID, nodeAddr, err := Decode(c.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sessionID != c.sessionID || nodeAddr != c.nodeAddr {
+			t.Errorf("v%d decode %s: want=%s,%s got=%s,%s", c.version, c.user, c.sessionID, c.nodeAddr, sessionID, nodeAddr)
+		}
+	}
+}
+
+func Test_Invalid(t *testing.T) {
+	if _, err := NewEncodeDecoder(Version(0)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("want ErrUnsupportedVersion, got %v", err)
+	}
+
+	for _, id := range []string{"", "a:b"} {
+		if _, err := Encode(id, "127.0.0.1:22"); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("encode %q: want ErrInvalidIdentifier, got %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"a.b", "a+b"} {
+		if _, err := (v2{}).Encode(id, "127.0.0.1:22"); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("v2 encode %q: want ErrInvalidIdentifier, got %v", id, err)
+		}
+	}
+
+	for _, user := range []string{"", "session", ":MTI3LjAuMC4xOjIyMjI=", "session:MTI3LjAuMC4xOjIyMjIIII=", ".MTI3LjAuMC4xOjIyMjI", "session.MTI3LjAuMC4xOjIyMjI="} {
+		if _, _, err := Decode(user); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("decode %q: want ErrInvalidIdentifier, got %v", user, err)
+		}
+	}
+}
+
+func Test_Token(t *testing.T) {
+	cases := []struct {
+		user      string
+		wantUser  string
+		wantToken string
+	}{
+		{"session.MTI3LjAuMC4xOjIyMjI+k3x9a", "session.MTI3LjAuMC4xOjIyMjI", "k3x9a"},
+		{"session:MTI3LjAuMC4xOjIyMjI=+k3x9a", "session:MTI3LjAuMC4xOjIyMjI=", "k3x9a"},
+		{"session:MTI3LjAuMC4xOjIyMjI=", "session:MTI3LjAuMC4xOjIyMjI=", ""},
+		{"session.MTI3LjAuMC4xOjIyMjI+", "session.MTI3LjAuMC4xOjIyMjI+", ""},
+		{"a+b:MTI3LjAuMC4xOjIyMjI=", "a+b:MTI3LjAuMC4xOjIyMjI=", ""},
+	}
+
+	for _, c := range cases {
+		user, token := SplitToken(c.user)
+		if user != c.wantUser || token != c.wantToken {
+			t.Errorf("split %s: want=%s,%s got=%s,%s", c.user, c.wantUser, c.wantToken, user, token)
+		}
+	}
+
+	user := WithToken("session.MTI3LjAuMC4xOjIyMjI", "k3x9a")
+	sessionID, nodeAddr, err := Decode(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID != "session" || nodeAddr != "127.0.0.1:2222" {
+		t.Errorf("decode %s: got=%s,%s", user, sessionID, nodeAddr)
+	}
+}
+
+func Test_JoinURI(t *testing.T) {
+	cases := []struct {
+		server string
+		want   string
+	}{
+		{"ssh://uptermd.upterm.dev:22", "ssh://user@uptermd.upterm.dev"},
+		{"ssh://127.0.0.1:2222", "ssh://user@127.0.0.1:2222"},
+		{"ssh://[::1]:22", "ssh://user@[::1]"},
+	}
+
+	for _, c := range cases {
+		got, err := JoinURI(c.server, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: want=%s got=%s", c.server, c.want, got)
+		}
+	}
+
+	if _, err := JoinURI("wss://uptermd.upterm.dev", "user"); err == nil {
+		t.Error("want error for unsupported protocol")
+	}
+}
+
+func Test_ShareLink(t *testing.T) {
+	cases := []struct {
+		server string
+		want   string
+	}{
+		{"ssh://uptermd.upterm.dev:22", "ssh://user@uptermd.upterm.dev"},
+		{"wss://uptermd.upterm.dev", "wss://user@uptermd.upterm.dev"},
+		{"wss://uptermd.upterm.dev:443", "wss://user@uptermd.upterm.dev"},
+		{"ws://127.0.0.1:8080", "ws://user@127.0.0.1:8080"},
+	}
+
+	for _, c := range cases {
+		got, err := ShareLink(c.server, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: want=%s got=%s", c.server, c.want, got)
+		}
+	}
+
+	if _, err := ShareLink("http://uptermd.upterm.dev", "user"); err == nil {
+		t.Error("want error for unsupported protocol")
+	}
+}
+
+func Test_JoinCommand(t *testing.T) {
+	cases := []struct {
+		server string
+		want   string
+	}{
+		{"ssh://uptermd.upterm.dev:22", "ssh user@uptermd.upterm.dev"},
+		{"ssh://uptermd.upterm.dev", "ssh user@uptermd.upterm.dev"},
+		{"ssh://127.0.0.1:2222", "ssh user@127.0.0.1 -p 2222"},
+		{"wss://uptermd.upterm.dev", "ssh -o ProxyCommand='upterm proxy wss://user@uptermd.upterm.dev' user@uptermd.upterm.dev:443"},
+		{"ws://127.0.0.1:8080", "ssh -o ProxyCommand='upterm proxy ws://user@127.0.0.1:8080' user@127.0.0.1:8080"},
+	}
+
+	for _, c := range cases {
+		got, err := JoinCommand(c.server, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: want=%s got=%s", c.server, c.want, got)
+		}
+	}
+
+	if _, err := JoinCommand("http://uptermd.upterm.dev", "user"); err == nil {
+		t.Error("want error for unsupported protocol")
+	}
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	f.Add("10OLFAKZu4cxx2roOboaY", "127.0.0.1:2222")
+	f.Add("session", "")
+	f.Add("a:b", "[::1]:22")
+
+	f.Fuzz(func(t *testing.T, sessionID, nodeAddr string) {
+		user, err := Encode(sessionID, nodeAddr)
+		if err != nil {
+			if sessionID != "" && !strings.Contains(sessionID, ":") {
+				t.Fatalf("encode %q: %s", sessionID, err)
+			}
+			return
+		}
+
+		gotID, gotAddr, err := Decode(user)
+		if err != nil {
+			t.Fatalf("decode %q: %s", user, err)
+		}
+		if gotID != sessionID || gotAddr != nodeAddr {
+			t.Fatalf("round trip: want=%q,%q got=%q,%q", sessionID, nodeAddr, gotID, gotAddr)
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add("10OLFAKZu4cxx2roOboaY:MTI3LjAuMC4xOjIyMjI=")
+	f.Add("session")
+	f.Add(":")
+	f.Add("a:b:c")
+	f.Add("10OLFAKZu4cxx2roOboaY.MTI3LjAuMC4xOjIyMjI+k3x9a")
+
+	f.Fuzz(func(t *testing.T, user string) {
+		sessionID, nodeAddr, err := Decode(user)
+		if err != nil {
+			return
+		}
+
+		// a decoded username re-encodes to a username that decodes to the same values
+		reencoded, err := Encode(sessionID, nodeAddr)
+		if err != nil {
+			t.Fatalf("re-encode %q: %s", user, err)
+		}
+		gotID, gotAddr, err := Decode(reencoded)
+		if err != nil || gotID != sessionID || gotAddr != nodeAddr {
+			t.Fatalf("re-decode %q: want=%q,%q got=%q,%q err=%v", reencoded, sessionID, nodeAddr, gotID, gotAddr, err)
+		}
+	})
+}
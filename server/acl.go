@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/owenthereal/upterm/approval"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrHostNotAllowed is returned when a host matching no entry of the host ACL creates a session.
+var ErrHostNotAllowed = errors.New("the server doesn't allow your key to create sessions")
+
+const (
+	aclMarkerDefault      = "@default"
+	aclOptCertAuthority   = "cert-authority"
+	aclOptMaxClients      = "max-clients"
+	aclOptSFTP            = "sftp"
+	aclOptPortForwarding  = "port-forwarding"
+	aclOptRecordingOptOut = "recording-opt-out"
+	aclOptLabels          = "labels"
+)
+
+// HostACL grants the sessions of hosts features by the keys hosts authenticate with, or by the CAs signing
+// their certs.
+type HostACL struct {
+	entries []hostACLEntry
+	// defaults are granted to hosts matching no entry. They can't create sessions if it's nil.
+	defaults *HostFeatures
+}
+
+type hostACLEntry struct {
+	key ssh.PublicKey
+	// certAuthority entries match user certs signed by key instead of key itself.
+	certAuthority bool
+	features      *HostFeatures
+}
+
+// ReadHostACL reads a host ACL file in the authorized_keys format, whose options are the features granted
+// to hosts authenticating with the key, e.g.:
+//
+//	@default max-clients=2
+//	max-clients=10,sftp,port-forwarding ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGxm... alice
+//	cert-authority,sftp,labels="env=prod,team=infra" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHb2... corp-ca
+//
+// The options are max-clients=N, sftp, port-forwarding, recording-opt-out, and labels="KEY=VALUE,...".
+// Features missing from an entry aren't granted. The first matching entry applies. Hosts matching no entry
+// are granted the options of the @default line, or can't create sessions if there is none.
+// Blank lines and lines starting with # are ignored.
+func ReadHostACL(file string) (*HostACL, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseHostACL(b)
+}
+
+// ParseHostACL parses a host ACL. See ReadHostACL for the format.
+func ParseHostACL(b []byte) (*HostACL, error) {
+	var acl HostACL
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(line, aclMarkerDefault); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			if acl.defaults != nil {
+				return nil, fmt.Errorf("line %d: duplicate %s", n, aclMarkerDefault)
+			}
+			features, certAuthority, err := parseHostFeatures(splitACLOptions(strings.TrimSpace(rest)))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if certAuthority {
+				return nil, fmt.Errorf("line %d: %s can't be a %s", n, aclMarkerDefault, aclOptCertAuthority)
+			}
+			acl.defaults = features
+			continue
+		}
+
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		features, certAuthority, err := parseHostFeatures(options)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		acl.entries = append(acl.entries, hostACLEntry{
+			key:           key,
+			certAuthority: certAuthority,
+			features:      features,
+		})
+	}
+
+	return &acl, s.Err()
+}
+
+// Features returns the features granted to a host authenticating with key. It reports false if the host
+// matches no entry and the ACL has no defaults.
+func (a *HostACL) Features(key ssh.PublicKey) (*HostFeatures, bool) {
+	cert, _ := key.(*ssh.Certificate)
+	for _, e := range a.entries {
+		if e.certAuthority {
+			if cert != nil && cert.CertType == ssh.UserCert && utils.KeysEqual(cert.SignatureKey, e.key) && validCert(cert) {
+				return proto.Clone(e.features).(*HostFeatures), true
+			}
+			continue
+		}
+
+		if utils.KeysEqual(key, e.key) {
+			return proto.Clone(e.features).(*HostFeatures), true
+		}
+	}
+
+	if a.defaults == nil {
+		return nil, false
+	}
+
+	return proto.Clone(a.defaults).(*HostFeatures), true
+}
+
+// validCert reports whether the cert is signed by its signature key and is valid now.
+// Principals aren't checked, since the ACL grants features to hosts regardless of their users.
+func validCert(cert *ssh.Certificate) bool {
+	var principal string
+	if len(cert.ValidPrincipals) > 0 {
+		principal = cert.ValidPrincipals[0]
+	}
+
+	return (&ssh.CertChecker{}).CheckCert(principal, cert) == nil
+}
+
+func parseHostFeatures(options []string) (*HostFeatures, bool, error) {
+	var (
+		f             HostFeatures
+		certAuthority bool
+	)
+	for _, opt := range options {
+		name, value, hasValue := strings.Cut(opt, "=")
+		if hasValue != (name == aclOptMaxClients || name == aclOptLabels) {
+			return nil, false, fmt.Errorf("invalid option %q", opt)
+		}
+
+		switch name {
+		case aclOptCertAuthority:
+			certAuthority = true
+		case aclOptMaxClients:
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n < 0 {
+				return nil, false, fmt.Errorf("invalid %s %q, must be a non-negative integer", aclOptMaxClients, value)
+			}
+			f.MaxClients = int32(n)
+		case aclOptSFTP:
+			f.Sftp = true
+		case aclOptPortForwarding:
+			f.PortForwarding = true
+		case aclOptRecordingOptOut:
+			f.RecordingOptOut = true
+		case aclOptLabels:
+			if v, err := strconv.Unquote(value); err == nil {
+				value = v
+			}
+			labels, err := approval.ParseLabels(strings.Split(value, ","))
+			if err != nil {
+				return nil, false, err
+			}
+			f.Labels = labels
+		default:
+			return nil, false, fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	return &f, certAuthority, nil
+}
+
+// splitACLOptions splits comma-separated options, keeping commas within double quotes.
+func splitACLOptions(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var (
+		options []string
+		quoted  bool
+		start   int
+	)
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			options = append(options, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(options, s[start:])
+}
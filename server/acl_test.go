@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_HostACL(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+	authorizedKey := func(s ssh.Signer) string {
+		return string(ssh.MarshalAuthorizedKey(s.PublicKey()))
+	}
+
+	alice, ca, bob := newSigner(), newSigner(), newSigner()
+
+	acl, err := ParseHostACL([]byte(`
+# hosts
+@default max-clients=1
+max-clients=10,sftp,port-forwarding ` + authorizedKey(alice) + `
+cert-authority,recording-opt-out,labels="env=prod,team=infra" ` + authorizedKey(ca)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedByCA := func(s ssh.Signer, certType uint32) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:         s.PublicKey(),
+			CertType:    certType,
+			ValidBefore: ssh.CertTimeInfinity,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	cases := []struct {
+		name string
+		key  ssh.PublicKey
+		want *HostFeatures
+	}{
+		{
+			name: "key",
+			key:  alice.PublicKey(),
+			want: &HostFeatures{MaxClients: 10, Sftp: true, PortForwarding: true},
+		},
+		{
+			name: "cert signed by ca",
+			key:  signedByCA(bob, ssh.UserCert),
+			want: &HostFeatures{RecordingOptOut: true, Labels: map[string]string{"env": "prod", "team": "infra"}},
+		},
+		{
+			name: "host cert signed by ca",
+			key:  signedByCA(bob, ssh.HostCert),
+			want: &HostFeatures{MaxClients: 1},
+		},
+		{
+			name: "default",
+			key:  bob.PublicKey(),
+			want: &HostFeatures{MaxClients: 1},
+		},
+	}
+	for _, c := range cases {
+		got, ok := acl.Features(c.key)
+		if !ok {
+			t.Fatalf("%s: want features granted", c.name)
+		}
+		if !proto.Equal(got, c.want) {
+			t.Fatalf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	// features are copied, so that sessions can't change the acl
+	f, _ := acl.Features(alice.PublicKey())
+	f.MaxClients = 0
+	if f, _ := acl.Features(alice.PublicKey()); f.MaxClients != 10 {
+		t.Fatalf("want the features of the acl unchanged, got %v", f)
+	}
+
+	strict, err := ParseHostACL([]byte(authorizedKey(alice)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := strict.Features(alice.PublicKey()); !ok || !proto.Equal(f, &HostFeatures{}) {
+		t.Fatalf("want no features granted to a key without options, got %v", f)
+	}
+	if _, ok := strict.Features(bob.PublicKey()); ok {
+		t.Fatal("want hosts matching no entry refused without defaults")
+	}
+}
+
+func Test_ParseHostACL_Invalid(t *testing.T) {
+	cases := []string{
+		"sftp ssh-ed25519",
+		"unknown " + TestPublicKeyContent,
+		"max-clients=-1 " + TestPublicKeyContent,
+		"max-clients " + TestPublicKeyContent,
+		"sftp=yes " + TestPublicKeyContent,
+		`labels="env" ` + TestPublicKeyContent,
+		"@default sftp\n@default port-forwarding",
+		"@default cert-authority",
+	}
+
+	for _, c := range cases {
+		if _, err := ParseHostACL([]byte(c)); err == nil {
+			t.Errorf("want error parsing %q", c)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// AdminSessionsPath is the path of the sessions of a node on the JSON admin API.
+	AdminSessionsPath = "/v1/sessions"
+	// AdminLogCapturesPath is the path of the log captures of a node on the JSON admin API.
+	AdminLogCapturesPath = "/v1/log-captures"
+)
+
+// sessionKilledReason is sent to the hosts of sessions killed with the admin API.
+const sessionKilledReason = "the server operator ended the session"
+
+// errAdminTokenRequired is returned when the admin API is served without a token.
+var errAdminTokenRequired = errors.New("admin API requires a token")
+
+// adminServer serves the AdminService of a node over gRPC and JSON over HTTP on a single address,
+// telling them apart by the content type of requests. Both require the bearer token Token:
+// gRPC clients send it in the authorization metadata and HTTP clients in the Authorization header.
+//
+// The JSON API mirrors the gRPC methods:
+//
+//	GET    /v1/sessions       ListSessions
+//	GET    /v1/sessions/{id}  GetSession
+//	DELETE /v1/sessions/{id}  KillSession
+//	POST   /v1/log-captures   CaptureLogs
+type adminServer struct {
+	NodeAddr string
+	// Sessions returns the sessions of the node. It returns nil before the node serves.
+	Sessions func() *sessionRepo
+	// Logs captures the logs of sessions and components for CaptureLogs.
+	Logs   *logCapture
+	Token  string
+	Logger log.FieldLogger
+
+	server *http.Server
+	mux    sync.Mutex
+}
+
+func (a *adminServer) Serve(ln net.Listener) error {
+	if a.Token == "" {
+		return errAdminTokenRequired
+	}
+
+	svc := &adminServiceServer{
+		NodeAddr: a.NodeAddr,
+		Sessions: a.Sessions,
+		Logs:     a.Logs,
+		Logger:   a.Logger,
+	}
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(a.authenticateGRPC))
+	RegisterAdminServiceServer(grpcServer, svc)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+AdminSessionsPath, func(w http.ResponseWriter, r *http.Request) {
+		resp, err := svc.ListSessions(r.Context(), &ListSessionsRequest{})
+		writeAdminResponse(w, resp, err)
+	})
+	mux.HandleFunc("GET "+AdminSessionsPath+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := svc.GetSession(r.Context(), &GetSessionRequest{Id: r.PathValue("id")})
+		writeAdminResponse(w, resp, err)
+	})
+	mux.HandleFunc("DELETE "+AdminSessionsPath+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := svc.KillSession(r.Context(), &KillSessionRequest{Id: r.PathValue("id")})
+		writeAdminResponse(w, resp, err)
+	})
+	mux.HandleFunc("POST "+AdminLogCapturesPath, func(w http.ResponseWriter, r *http.Request) {
+		var req CaptureLogsRequest
+		b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err == nil {
+			err = protojson.Unmarshal(b, &req)
+		}
+		if err != nil {
+			writeAdminResponse(w, nil, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		resp, err := svc.CaptureLogs(r.Context(), &req)
+		writeAdminResponse(w, resp, err)
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+
+		if !a.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminResponse(w, nil, status.Error(codes.Unauthenticated, "invalid admin token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	a.mux.Lock()
+	a.server = &http.Server{
+		// gRPC requires HTTP/2, which is served without TLS like the other endpoints of the node
+		Handler: h2c.NewHandler(handler, &http2.Server{}),
+	}
+	a.mux.Unlock()
+
+	return a.server.Serve(ln)
+}
+
+func (a *adminServer) Shutdown(ctx context.Context) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.server == nil {
+		return nil
+	}
+
+	return a.server.Shutdown(ctx)
+}
+
+func (a *adminServer) authenticateGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) == 0 || !a.authorized(auth[0]) {
+		return nil, status.Error(codes.Unauthenticated, "invalid admin token")
+	}
+
+	return handler(ctx, req)
+}
+
+// authorized reports whether the value of an authorization header carries the admin token.
+func (a *adminServer) authorized(header string) bool {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+var adminJSON = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// writeAdminResponse writes a response of the JSON admin API, or the error with the HTTP status of its gRPC code.
+func writeAdminResponse(w http.ResponseWriter, resp proto.Message, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch status.Code(err) {
+		case codes.NotFound:
+			code = http.StatusNotFound
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.Unauthenticated:
+			code = http.StatusUnauthorized
+		case codes.FailedPrecondition:
+			code = http.StatusConflict
+		}
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
+		return
+	}
+
+	b, err := adminJSON.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+type adminServiceServer struct {
+	UnimplementedAdminServiceServer
+
+	NodeAddr string
+	Sessions func() *sessionRepo
+	Logs     *logCapture
+	Logger   log.FieldLogger
+}
+
+func (s *adminServiceServer) ListSessions(ctx context.Context, in *ListSessionsRequest) (*ListSessionsResponse, error) {
+	resp := &ListSessionsResponse{}
+	if repo := s.Sessions(); repo != nil {
+		for _, sess := range repo.List() {
+			resp.Sessions = append(resp.Sessions, s.sessionInfo(sess))
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *adminServiceServer) GetSession(ctx context.Context, in *GetSessionRequest) (*SessionInfo, error) {
+	sess, err := s.session(in.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sessionInfo(*sess), nil
+}
+
+func (s *adminServiceServer) KillSession(ctx context.Context, in *KillSessionRequest) (*KillSessionResponse, error) {
+	sess, err := s.session(in.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sess.End(sessionKilledReason); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.Logger.WithFields(log.Fields{"session": sess.ID, "host-user": sess.HostUser}).Warn("session killed by admin")
+
+	return &KillSessionResponse{}, nil
+}
+
+func (s *adminServiceServer) CaptureLogs(ctx context.Context, in *CaptureLogsRequest) (*CaptureLogsResponse, error) {
+	if s.Logs == nil {
+		return nil, status.Error(codes.Unimplemented, "log captures are disabled")
+	}
+	if in.SessionId != "" {
+		if _, err := s.session(in.SessionId); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := s.Logs.Start(in.SessionId, in.Component, time.Duration(in.DurationSeconds)*time.Second)
+	if errors.Is(err, errLogCaptureTarget) || errors.Is(err, errLogCaptureDuration) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Logger.WithFields(log.Fields{"session": in.SessionId, "component": in.Component, "file": c.File, "expires-at": c.ExpiresAt}).Warn("capturing logs")
+
+	return &CaptureLogsResponse{File: c.File, ExpiresAt: c.ExpiresAt.Unix()}, nil
+}
+
+func (s *adminServiceServer) session(id string) (*session, error) {
+	repo := s.Sessions()
+	if repo == nil {
+		return nil, status.Errorf(codes.NotFound, "session %s not found", id)
+	}
+
+	sess, err := repo.Get(id)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "session %s not found", id)
+	}
+
+	return sess, nil
+}
+
+func (s *adminServiceServer) sessionInfo(sess session) *SessionInfo {
+	info := &SessionInfo{
+		Id:        sess.ID,
+		NodeAddr:  s.NodeAddr,
+		HostUser:  sess.HostUser,
+		CreatedAt: sess.CreatedAt.Unix(),
+		Features:  sess.Features,
+	}
+	if !sess.ExpiresAt.IsZero() {
+		info.ExpiresAt = sess.ExpiresAt.Unix()
+	}
+	for _, pk := range sess.HostPublicKeys {
+		info.HostPublicKeyFingerprints = append(info.HostPublicKeyFingerprints, utils.FingerprintSHA256(pk))
+	}
+	for _, pk := range sess.ClientAuthorizedKeys {
+		info.ClientAuthorizedKeyFingerprints = append(info.ClientAuthorizedKeyFingerprints, utils.FingerprintSHA256(pk))
+	}
+
+	return info
+}
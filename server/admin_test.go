@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_adminServer(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ended := make(chan string, 1)
+
+	repo := newSessionRepo()
+	for i, id := range []string{"second", "first"} {
+		id := id
+		if err := repo.Add(session{
+			ID:        id,
+			HostUser:  "owen",
+			CreatedAt: createdAt.Add(-time.Duration(i) * time.Minute),
+			end: func(reason string) error {
+				ended <- id
+				return nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &adminServer{
+		NodeAddr: "10.0.0.1:22",
+		Sessions: func() *sessionRepo { return repo },
+		Logs:     newLogCapture(log.New(), t.TempDir()),
+		Token:    "secret",
+		Logger:   log.New(),
+	}
+	go func() {
+		_ = a.Serve(ln)
+	}()
+	defer a.Shutdown(context.Background())
+
+	baseURL := "http://" + ln.Addr().String() + AdminSessionsPath
+	do := func(method, url, token string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, body
+	}
+
+	// JSON API
+	if code, _ := do(http.MethodGet, baseURL, "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("want=%d got=%d", http.StatusUnauthorized, code)
+	}
+
+	code, body := do(http.MethodGet, baseURL, "secret")
+	if code != http.StatusOK {
+		t.Fatalf("want=%d got=%d: %v", http.StatusOK, code, body)
+	}
+	var ids []string
+	for _, s := range body["sessions"].([]interface{}) {
+		ids = append(ids, s.(map[string]interface{})["id"].(string))
+	}
+	if diff := cmp.Diff([]string{"first", "second"}, ids); diff != "" {
+		t.Fatal(diff)
+	}
+
+	code, body = do(http.MethodGet, baseURL+"/first", "secret")
+	if code != http.StatusOK {
+		t.Fatalf("want=%d got=%d: %v", http.StatusOK, code, body)
+	}
+	if want, got := "10.0.0.1:22", body["node_addr"]; want != got {
+		t.Fatalf("want=%s got=%v", want, got)
+	}
+
+	if code, _ := do(http.MethodGet, baseURL+"/missing", "secret"); code != http.StatusNotFound {
+		t.Fatalf("want=%d got=%d", http.StatusNotFound, code)
+	}
+
+	if code, body := do(http.MethodDelete, baseURL+"/first", "secret"); code != http.StatusOK {
+		t.Fatalf("want=%d got=%d: %v", http.StatusOK, code, body)
+	}
+	if want, got := "first", <-ended; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String()+AdminLogCapturesPath, strings.NewReader(`{"session_id": "second", "duration_seconds": 60}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want=%d got=%d", http.StatusOK, resp.StatusCode)
+	}
+
+	// gRPC API
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewAdminServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.ListSessions(ctx, &ListSessionsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("want unauthenticated, got %v", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	list, err := client.ListSessions(ctx, &ListSessionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(list.Sessions); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	sess, err := client.GetSession(ctx, &GetSessionRequest{Id: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := createdAt.Unix(), sess.CreatedAt; want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	if _, err := client.CaptureLogs(ctx, &CaptureLogsRequest{SessionId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("want not found, got %v", err)
+	}
+	if _, err := client.CaptureLogs(ctx, &CaptureLogsRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("want invalid argument, got %v", err)
+	}
+	capture, err := client.CaptureLogs(ctx, &CaptureLogsRequest{Component: "sshd", DurationSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(capture.File); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.KillSession(ctx, &KillSessionRequest{Id: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("want not found, got %v", err)
+	}
+	if _, err := client.KillSession(ctx, &KillSessionRequest{Id: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "second", <-ended; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/owenthereal/upterm/host/api"
+)
+
+// SessionAliases maps vanity hostnames, e.g. debug-1234.upterm.example.com, to sessions.
+// A request to the ws proxy whose Host header matches an alias is routed to the aliased session
+// without the session ID in the basic auth.
+type SessionAliases map[string]*api.Identifier
+
+// ParseSessionAliases parses aliases in the form of HOSTNAME=SESSION_ID[@NODE_ADDR].
+// The node address defaults to defaultNodeAddr.
+func ParseSessionAliases(aliases []string, defaultNodeAddr string) (SessionAliases, error) {
+	result := make(SessionAliases)
+	for _, alias := range aliases {
+		hostname, target, ok := strings.Cut(alias, "=")
+		if !ok || hostname == "" || target == "" {
+			return nil, fmt.Errorf("invalid session alias %q: must be HOSTNAME=SESSION_ID[@NODE_ADDR]", alias)
+		}
+
+		sessionID, nodeAddr, ok := strings.Cut(target, "@")
+		if !ok {
+			nodeAddr = defaultNodeAddr
+		}
+		if sessionID == "" || nodeAddr == "" {
+			return nil, fmt.Errorf("invalid session alias %q: must be HOSTNAME=SESSION_ID[@NODE_ADDR]", alias)
+		}
+
+		hostname = strings.ToLower(hostname)
+		if _, found := result[hostname]; found {
+			return nil, fmt.Errorf("duplicated session alias %q", hostname)
+		}
+
+		result[hostname] = &api.Identifier{
+			Id:       sessionID,
+			Type:     api.Identifier_CLIENT,
+			NodeAddr: nodeAddr,
+		}
+	}
+
+	return result, nil
+}
+
+// Lookup returns the session identifier aliased by host. The port of host is ignored.
+func (a SessionAliases) Lookup(host string) (*api.Identifier, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	id, ok := a[strings.ToLower(host)]
+	return id, ok
+}
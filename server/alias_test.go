@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/host/api"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func Test_ParseSessionAliases(t *testing.T) {
+	aliases, err := ParseSessionAliases([]string{
+		"Debug-1234.upterm.example.com=session1",
+		"pair.upterm.example.com=session2@10.0.0.2:2222",
+	}, "10.0.0.1:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host string
+		want *api.Identifier
+	}{
+		{
+			host: "debug-1234.upterm.example.com:443",
+			want: &api.Identifier{Id: "session1", Type: api.Identifier_CLIENT, NodeAddr: "10.0.0.1:2222"},
+		},
+		{
+			host: "pair.upterm.example.com",
+			want: &api.Identifier{Id: "session2", Type: api.Identifier_CLIENT, NodeAddr: "10.0.0.2:2222"},
+		},
+		{
+			host: "upterm.example.com",
+		},
+	}
+
+	for _, c := range cases {
+		got, ok := aliases.Lookup(c.host)
+		if want := c.want != nil; want != ok {
+			t.Fatalf("%s: want=%t got=%t", c.host, want, ok)
+		}
+		if diff := cmp.Diff(c.want, got, protocmp.Transform()); diff != "" {
+			t.Fatalf("%s: %s", c.host, diff)
+		}
+	}
+
+	for _, invalid := range [][]string{
+		{"upterm.example.com"},
+		{"=session"},
+		{"upterm.example.com=@10.0.0.1:2222"},
+		{"a.example.com=s1", "A.example.com=s2"},
+	} {
+		if _, err := ParseSessionAliases(invalid, "10.0.0.1:2222"); err == nil {
+			t.Fatalf("%v: expected error", invalid)
+		}
+	}
+}
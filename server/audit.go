@@ -0,0 +1,449 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// auditQueueSize is how many audit events are queued for a slow sink before they are dropped.
+	auditQueueSize = 1024
+	// auditWebhookTimeout bounds posting an audit event to a webhook.
+	auditWebhookTimeout = 10 * time.Second
+)
+
+type AuditEventType string
+
+const (
+	AuditSessionCreated      AuditEventType = "session-created"
+	AuditSessionClosed       AuditEventType = "session-closed"
+	AuditClientAuthenticated AuditEventType = "client-authenticated"
+	AuditAuthRejected        AuditEventType = "auth-rejected"
+)
+
+// AuditEvent records who accessed which session. It's written as a line of JSON.
+type AuditEvent struct {
+	Time time.Time      `json:"time"`
+	Type AuditEventType `json:"type"`
+	// NodeAddr is the node recording the event.
+	NodeAddr  string `json:"node_addr"`
+	SessionID string `json:"session_id,omitempty"`
+	HostUser  string `json:"host_user,omitempty"`
+	// RemoteAddr, Fingerprint, ClientVersion, and ClientName are of the host creating the session
+	// or of the client authenticating.
+	RemoteAddr    string `json:"remote_addr,omitempty"`
+	Fingerprint   string `json:"fingerprint,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+	ClientName    string `json:"client_name,omitempty"`
+	// EntryNodeAddr is the node a client routed to the session connected to.
+	EntryNodeAddr string `json:"entry_node_addr,omitempty"`
+	// Reason is why a client is rejected.
+	Reason string `json:"reason,omitempty"`
+	// Labels describe the session, e.g. env=prod.
+	Labels map[string]string `json:"labels,omitempty"`
+	// DurationSeconds is how long a closed session lasted.
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// AuditSink stores audit events, e.g. in a file or with a webhook of a SIEM.
+type AuditSink interface {
+	Write(ctx context.Context, ev AuditEvent) error
+	Close() error
+}
+
+// AuditRetention bounds the audit log files kept on disk. An audit log is rotated once it exceeds MaxSize or its first
+// event is older than MaxAge, and rotated logs are removed once their last events are older than MaxAge, or the oldest
+// first for them to total at most MaxSize.
+type AuditRetention struct {
+	// MaxSize is in bytes. It's unlimited if 0.
+	MaxSize int64
+	// MaxAge is unlimited if 0.
+	MaxAge time.Duration
+}
+
+func (r AuditRetention) enabled() bool {
+	return r.MaxSize > 0 || r.MaxAge > 0
+}
+
+// NewAuditSink returns the sink of the spec: stdout or - for the standard output, an http or https URL of a webhook
+// events are POSTed to as JSON, or otherwise a file events are appended to. Only files are retained per retention.
+func NewAuditSink(spec string, retention AuditRetention) (AuditSink, error) {
+	switch {
+	case spec == "stdout" || spec == "-":
+		if retention.enabled() {
+			return nil, fmt.Errorf("audit log retention requires an audit log file")
+		}
+		return &writerAuditSink{w: os.Stdout}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		if retention.enabled() {
+			return nil, fmt.Errorf("audit log retention requires an audit log file")
+		}
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid audit webhook url %q", spec)
+		}
+		return &webhookAuditSink{
+			url:    u.String(),
+			client: &http.Client{Timeout: auditWebhookTimeout},
+		}, nil
+	default:
+		s := &fileAuditSink{path: spec, retention: retention}
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+		if err := s.prune(time.Now()); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// writerAuditSink writes events as JSON lines.
+type writerAuditSink struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (s *writerAuditSink) Write(ctx context.Context, ev AuditEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *writerAuditSink) Close() error {
+	return nil
+}
+
+// auditRotatedLayout suffixes the paths of rotated audit logs with the times they're rotated at.
+const auditRotatedLayout = "20060102T150405.000000000Z"
+
+// fileAuditSink appends events to a file as JSON lines, rotating and pruning it per retention.
+// Rotating and pruning are driven by the times of the events written.
+type fileAuditSink struct {
+	path      string
+	retention AuditRetention
+
+	mu     sync.Mutex
+	f      *os.File
+	closed bool
+	size   int64
+	// started is the time of the first event of the file, which is zero if it's empty.
+	started time.Time
+}
+
+func (s *fileAuditSink) Write(ctx context.Context, ev AuditEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	now := ev.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	// events are still written to the log if it fails to be rotated
+	var rerr error
+	if s.f != nil && s.size > 0 && s.due(now, len(b)) {
+		rerr = s.rotate(now)
+	}
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return errors.Join(rerr, err)
+		}
+	}
+
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	if s.started.IsZero() {
+		s.started = now
+	}
+
+	return errors.Join(rerr, err)
+}
+
+// due reports whether the log is to be rotated before writing n bytes at now.
+func (s *fileAuditSink) due(now time.Time, n int) bool {
+	if s.retention.MaxSize > 0 && s.size+int64(n) > s.retention.MaxSize {
+		return true
+	}
+
+	return s.retention.MaxAge > 0 && !s.started.IsZero() && now.Sub(s.started) >= s.retention.MaxAge
+}
+
+// open opens the log to append to, which is created if it doesn't exist.
+func (s *fileAuditSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+
+	s.f = f
+	s.size = fi.Size()
+	s.started = time.Time{}
+	if s.size > 0 {
+		s.started = firstAuditEventTime(s.path)
+	}
+
+	return nil
+}
+
+// rotate renames the log with the time it's rotated at, and prunes the rotated logs.
+func (s *fileAuditSink) rotate(now time.Time) error {
+	err := s.f.Close()
+	s.f = nil
+	if err != nil {
+		return fmt.Errorf("error closing audit log: %w", err)
+	}
+
+	if err := os.Rename(s.path, s.path+"."+now.UTC().Format(auditRotatedLayout)); err != nil {
+		return fmt.Errorf("error rotating audit log: %w", err)
+	}
+
+	return s.prune(now)
+}
+
+// prune removes the rotated logs exceeding the retention at now.
+func (s *fileAuditSink) prune(now time.Time) error {
+	if !s.retention.enabled() {
+		return nil
+	}
+
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error pruning audit logs: %w", err)
+	}
+
+	type rotatedLog struct {
+		path string
+		at   time.Time
+		size int64
+	}
+	var logs []rotatedLog
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		at, err := time.Parse(auditRotatedLayout, suffix)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, rotatedLog{path: filepath.Join(dir, e.Name()), at: at, size: fi.Size()})
+	}
+	// newest first
+	sort.Slice(logs, func(i, j int) bool { return logs[i].at.After(logs[j].at) })
+
+	var (
+		total int64
+		errs  []error
+	)
+	for _, l := range logs {
+		total += l.size
+		expired := s.retention.MaxAge > 0 && now.Sub(l.at) >= s.retention.MaxAge
+		oversized := s.retention.MaxSize > 0 && total > s.retention.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("error pruning audit log: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *fileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.f == nil {
+		return nil
+	}
+
+	return s.f.Close()
+}
+
+// firstAuditEventTime returns the time of the first event of the audit log, or the zero time if it can't be read.
+func firstAuditEventTime(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}
+	}
+	var ev AuditEvent
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return time.Time{}
+	}
+
+	return ev.Time
+}
+
+// webhookAuditSink posts events as JSON.
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookAuditSink) Write(ctx context.Context, ev AuditEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook responded %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *webhookAuditSink) Close() error {
+	return nil
+}
+
+// auditor records audit events of a node to a sink in the background, so that slow sinks don't hold up
+// hosts and clients connecting. Events are dropped if the sink falls too far behind.
+// A nil auditor records nothing.
+type auditor struct {
+	nodeAddr string
+	sink     AuditSink
+	events   chan AuditEvent
+	dropped  metrics.Counter
+	logger   log.FieldLogger
+}
+
+func newAuditor(sink AuditSink, nodeAddr string, mp provider.Provider, logger log.FieldLogger) *auditor {
+	return &auditor{
+		nodeAddr: nodeAddr,
+		sink:     sink,
+		events:   make(chan AuditEvent, auditQueueSize),
+		dropped:  mp.NewCounter("audit_dropped_count"),
+		logger:   logger,
+	}
+}
+
+// Record queues the event, stamping it with the time and the node.
+func (a *auditor) Record(ev AuditEvent) {
+	if a == nil {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	ev.NodeAddr = a.nodeAddr
+
+	select {
+	case a.events <- ev:
+	default:
+		a.dropped.Add(1)
+		a.logger.WithFields(log.Fields{"type": ev.Type, "session": ev.SessionID}).Error("audit log is behind, dropped event")
+	}
+}
+
+// Run writes the queued events to the sink until ctx is done. Events queued by then are written
+// within serverShutDownDeadline before it returns.
+func (a *auditor) Run(ctx context.Context) error {
+	for {
+		select {
+		case ev := <-a.events:
+			a.write(ctx, ev)
+		case <-ctx.Done():
+			dctx, cancel := context.WithTimeout(context.Background(), serverShutDownDeadline)
+			defer cancel()
+
+			for {
+				select {
+				case ev := <-a.events:
+					a.write(dctx, ev)
+				default:
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+func (a *auditor) write(ctx context.Context, ev AuditEvent) {
+	if err := a.sink.Write(ctx, ev); err != nil {
+		a.logger.WithError(err).WithFields(log.Fields{"type": ev.Type, "session": ev.SessionID}).Error("error writing audit event")
+	}
+}
+
+// authFingerprint returns the fingerprint of the key of the auth request, or an empty string if it can't be parsed.
+func authFingerprint(auth *AuthRequest) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(auth.AuthorizedKey)
+	if err != nil {
+		return ""
+	}
+
+	return utils.FingerprintSHA256(key)
+}
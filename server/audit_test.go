@@ -0,0 +1,450 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/upterm"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
+)
+
+type chanAuditSink chan AuditEvent
+
+func (s chanAuditSink) Write(ctx context.Context, ev AuditEvent) error {
+	s <- ev
+	return nil
+}
+
+func (s chanAuditSink) Close() error {
+	return nil
+}
+
+func waitAuditEvent(t *testing.T, events chanAuditSink) AuditEvent {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect an audit event")
+	}
+
+	return AuditEvent{}
+}
+
+func Test_NewAuditSink_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+
+	for _, typ := range []AuditEventType{AuditSessionCreated, AuditSessionClosed} {
+		// events are appended across restarts
+		sink, err := NewAuditSink(file, AuditRetention{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(context.Background(), AuditEvent{Type: typ, SessionID: "session"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []AuditEventType
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev.Type)
+	}
+
+	if want := []AuditEventType{AuditSessionCreated, AuditSessionClosed}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("want=%v got=%v", want, got)
+	}
+}
+
+func Test_NewAuditSink_FileRetention(t *testing.T) {
+	start := time.Date(2024, 5, 21, 12, 30, 0, 0, time.UTC)
+	line, err := json.Marshal(AuditEvent{Time: start, Type: AuditSessionCreated, SessionID: "session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(line) + 1)
+
+	rotated := func(t *testing.T, file string) []string {
+		t.Helper()
+
+		matches, err := filepath.Glob(file + ".*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range matches {
+			matches[i] = filepath.Base(m)
+		}
+
+		return matches
+	}
+
+	t.Run("max size", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "audit.log")
+		sink, err := NewAuditSink(file, AuditRetention{MaxSize: 2 * size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+
+		// two events fit in each log
+		for i := 0; i < 7; i++ {
+			ev := AuditEvent{Time: start.Add(time.Duration(i) * time.Minute), Type: AuditSessionCreated, SessionID: "session"}
+			if err := sink.Write(context.Background(), ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// logs are rotated at the 3rd, the 5th, and the 7th events, and only the last rotated fits in max size
+		want := []string{"audit.log.20240521T123600.000000000Z"}
+		if diff := cmp.Diff(want, rotated(t, file)); diff != "" {
+			t.Fatal(diff)
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != size {
+			t.Fatalf("want the log with the last event of size %d, got %d", size, fi.Size())
+		}
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "audit.log")
+		// a log rotated before the retention was configured
+		if err := os.WriteFile(file+".20240101T000000.000000000Z", line, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// a file of another name is left alone
+		if err := os.WriteFile(file+".bak", line, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		sink, err := NewAuditSink(file, AuditRetention{MaxAge: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		if diff := cmp.Diff([]string{"audit.log.bak"}, rotated(t, file)); diff != "" {
+			t.Fatalf("expect the expired log removed once the sink is created: %s", diff)
+		}
+
+		for _, d := range []time.Duration{0, 30 * time.Minute, 61 * time.Minute} {
+			if err := sink.Write(context.Background(), AuditEvent{Time: start.Add(d), Type: AuditSessionCreated}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := []string{"audit.log.20240521T133100.000000000Z", "audit.log.bak"}
+		if diff := cmp.Diff(want, rotated(t, file)); diff != "" {
+			t.Fatalf("expect the log rotated once its first event is older than max age: %s", diff)
+		}
+
+		if err := sink.Write(context.Background(), AuditEvent{Time: start.Add(3 * time.Hour), Type: AuditSessionCreated}); err != nil {
+			t.Fatal(err)
+		}
+		want = []string{"audit.log.20240521T153000.000000000Z", "audit.log.bak"}
+		if diff := cmp.Diff(want, rotated(t, file)); diff != "" {
+			t.Fatalf("expect the log with the events older than max age removed: %s", diff)
+		}
+	})
+
+	t.Run("not a file", func(t *testing.T) {
+		if _, err := NewAuditSink("stdout", AuditRetention{MaxAge: time.Hour}); err == nil {
+			t.Fatal("expect error retaining stdout")
+		}
+	})
+}
+
+func Test_NewAuditSink_Webhook(t *testing.T) {
+	posted := make(chan AuditEvent, 1)
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev AuditEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		posted <- ev
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := NewAuditSink(srv.URL, AuditRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(context.Background(), AuditEvent{Type: AuditAuthRejected, Fingerprint: "SHA256:abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-posted; ev.Type != AuditAuthRejected || ev.Fingerprint != "SHA256:abc" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Write(context.Background(), AuditEvent{Type: AuditAuthRejected}); err == nil {
+		t.Fatal("expect error when the webhook fails")
+	}
+	<-posted
+
+	if _, err := NewAuditSink("https://", AuditRetention{}); err == nil {
+		t.Fatal("expect error for a webhook url without host")
+	}
+}
+
+func Test_auditor_Run(t *testing.T) {
+	events := make(chanAuditSink, 2)
+	a := newAuditor(events, "10.0.0.1:22", provider.NewDiscardProvider(), log.New())
+
+	// events queued before shutting down are written
+	a.Record(AuditEvent{Type: AuditSessionCreated})
+	a.Record(AuditEvent{Type: AuditSessionClosed})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = a.Run(ctx)
+
+	for _, want := range []AuditEventType{AuditSessionCreated, AuditSessionClosed} {
+		ev := waitAuditEvent(t, events)
+		if ev.Type != want {
+			t.Fatalf("want=%s got=%s", want, ev.Type)
+		}
+		if ev.NodeAddr != "10.0.0.1:22" || ev.Time.IsZero() {
+			t.Fatalf("expect event stamped with node and time: %+v", ev)
+		}
+	}
+
+	// nil auditors record nothing
+	var nilAuditor *auditor
+	nilAuditor.Record(AuditEvent{Type: AuditSessionCreated})
+}
+
+func Test_sshd_Audit(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    "192.0.2.1:1234",
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chanAuditSink, 2)
+	a := newAuditor(events, addr, provider.NewDiscardProvider(), logger)
+	notified := make(chanAuditSink, 2)
+	n := newNotifier(notified, addr, provider.NewDiscardProvider(), logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = a.Run(ctx)
+	}()
+	go func() {
+		_ = n.Run(ctx)
+	}()
+
+	sshd := &sshd{
+		SessionRepo: newSessionRepo(),
+		HostSigners: []ssh.Signer{signer},
+		NodeAddr:    addr,
+		Auditor:     a,
+		Notifier:    n,
+		Logger:      logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	if err != nil || !ok {
+		t.Fatalf("error creating session: %v %s", err, body)
+	}
+
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(TestPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := waitAuditEvent(t, events)
+	want := AuditEvent{
+		Time:          ev.Time,
+		Type:          AuditSessionCreated,
+		NodeAddr:      addr,
+		SessionID:     resp.SessionID,
+		HostUser:      "owen",
+		RemoteAddr:    "192.0.2.1:1234",
+		Fingerprint:   utils.FingerprintSHA256(pk),
+		ClientVersion: upterm.HostSSHClientVersion,
+		Labels:        map[string]string{"env": "prod"},
+	}
+	if diff := cmp.Diff(want, ev); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(want, waitAuditEvent(t, notified), cmpopts.IgnoreFields(AuditEvent{}, "Time")); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the session is closed with the connection of the host
+	client.Close()
+
+	ev = waitAuditEvent(t, events)
+	if ev.Type != AuditSessionClosed || ev.SessionID != resp.SessionID || ev.Fingerprint != want.Fingerprint {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if ev := waitAuditEvent(t, notified); ev.Type != AuditSessionClosed || ev.SessionID != resp.SessionID {
+		t.Fatalf("unexpected notified event %+v", ev)
+	}
+}
+
+type testConnMetadata struct {
+	user          string
+	clientVersion string
+	remoteAddr    net.Addr
+}
+
+func (c testConnMetadata) User() string          { return c.user }
+func (c testConnMetadata) SessionID() []byte     { return nil }
+func (c testConnMetadata) ClientVersion() []byte { return []byte(c.clientVersion) }
+func (c testConnMetadata) ServerVersion() []byte { return nil }
+func (c testConnMetadata) RemoteAddr() net.Addr  { return c.remoteAddr }
+func (c testConnMetadata) LocalAddr() net.Addr   { return c.remoteAddr }
+
+func Test_authPiper_AuditRejected(t *testing.T) {
+	const nodeAddr = "10.0.0.1:22"
+
+	authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(TestPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessRepo := newSessionRepo()
+	if err := sessRepo.Add(session{ID: "session", ClientAuthorizedKeys: []ssh.PublicKey{authorizedKey}}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chanAuditSink, 1)
+	a := newAuditor(events, nodeAddr, provider.NewDiscardProvider(), log.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = a.Run(ctx)
+	}()
+
+	ap := authPiper{
+		NodeAddr:    nodeAddr,
+		SessionRepo: sessRepo,
+		Auditor:     a,
+		Logger:      log.New(),
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: nodeAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := testConnMetadata{
+		user:          user,
+		clientVersion: "SSH-2.0-OpenSSH_9.6",
+		remoteAddr:    &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+	}
+
+	// a key not authorized for the session
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ap.PublicKeyCallback(conn, key, nil); err == nil {
+		t.Fatal("expect the unauthorized key rejected")
+	}
+
+	ev := waitAuditEvent(t, events)
+	want := AuditEvent{
+		Time:          ev.Time,
+		Type:          AuditAuthRejected,
+		NodeAddr:      nodeAddr,
+		SessionID:     "session",
+		RemoteAddr:    "192.0.2.1:1234",
+		Fingerprint:   utils.FingerprintSHA256(key),
+		ClientVersion: "SSH-2.0-OpenSSH_9.6",
+		Reason:        string(RejectionKeyNotAuthorized),
+	}
+	if diff := cmp.Diff(want, ev); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// authzTimeout bounds asking the authorization hook about a client. Clients are denied if the hook doesn't answer
+// in time. It's half of pipeEstablishingTimeout, which the hook runs within, leaving the rest to the handshake and
+// to routing clients from other nodes, so that clients are told they're denied rather than disconnected.
+var authzTimeout = pipeEstablishingTimeout / 2
+
+// AuthzRequest asks the authorization hook whether a client may join a session hosted on the node.
+type AuthzRequest struct {
+	SessionID string `json:"session_id"`
+	// HostUser and Labels describe the session.
+	HostUser string            `json:"host_user,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// Fingerprint is the SHA256 fingerprint of the key of the client, and RemoteIP is the IP it connects from, to
+	// the node it entered the cluster through.
+	Fingerprint string `json:"fingerprint"`
+	RemoteIP    string `json:"remote_ip"`
+	ClientName  string `json:"client_name,omitempty"`
+}
+
+// AuthzResponse is the decision of the authorization hook. Reason is shown to denied clients.
+type AuthzResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Authorizer decides whether clients may join sessions, e.g. by org-specific rules like time windows or on-call
+// checks, after the keys of the clients are authorized by the hosts.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AuthzRequest) (AuthzResponse, error)
+}
+
+// NewAuthorizer returns the authorizer of the hook posting requests to webhook, or running command for each
+// request. It returns nil if both are empty.
+func NewAuthorizer(webhook, command string) (Authorizer, error) {
+	switch {
+	case webhook != "" && command != "":
+		return nil, errors.New("--authz-webhook can't be used with --authz-command")
+	case webhook != "":
+		return NewAuthzWebhook(webhook)
+	case command != "":
+		return NewAuthzCommand(command)
+	default:
+		return nil, nil
+	}
+}
+
+// NewAuthzWebhook returns an authorizer posting requests as JSON to u, which responds with an AuthzResponse.
+func NewAuthzWebhook(u string) (*AuthzWebhook, error) {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, fmt.Errorf("invalid authz webhook url %q", u)
+	}
+
+	return &AuthzWebhook{
+		URL:    pu.String(),
+		Client: &http.Client{Timeout: authzTimeout},
+	}, nil
+}
+
+// AuthzWebhook authorizes clients with a webhook.
+type AuthzWebhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *AuthzWebhook) Authorize(ctx context.Context, req AuthzRequest) (AuthzResponse, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return AuthzResponse{}, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return AuthzResponse{}, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(hreq)
+	if err != nil {
+		return AuthzResponse{}, fmt.Errorf("error requesting authz webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return AuthzResponse{}, fmt.Errorf("error reading authz response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return AuthzResponse{}, fmt.Errorf("authz webhook responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var r AuthzResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return AuthzResponse{}, fmt.Errorf("error parsing authz response: %w", err)
+	}
+
+	return r, nil
+}
+
+// NewAuthzCommand returns an authorizer running a command for each request, like AuthorizedKeysCommand of OpenSSH.
+// The command allows the client by exiting with zero and denies it otherwise, printing the reason on the first
+// line. The tokens %s, %f, %a, and %u in the command are expanded to the session ID, the fingerprint and the IP of
+// the client, and the host user. They're also set in the environment as UPTERM_SESSION_ID,
+// UPTERM_CLIENT_FINGERPRINT, UPTERM_CLIENT_IP, and UPTERM_HOST_USER, with UPTERM_CLIENT_NAME and
+// UPTERM_SESSION_LABELS.
+func NewAuthzCommand(command string) (*AuthzCommand, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing authz command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty authz command")
+	}
+
+	return &AuthzCommand{args: args}, nil
+}
+
+// AuthzCommand authorizes clients with a command.
+type AuthzCommand struct {
+	args []string
+}
+
+func (c *AuthzCommand) Authorize(ctx context.Context, req AuthzRequest) (AuthzResponse, error) {
+	tokens := strings.NewReplacer(
+		"%%", "%",
+		"%s", req.SessionID,
+		"%f", req.Fingerprint,
+		"%a", req.RemoteIP,
+		"%u", req.HostUser,
+	)
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = tokens.Replace(arg)
+	}
+
+	var labels []string
+	for k, v := range req.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"UPTERM_SESSION_ID="+req.SessionID,
+		"UPTERM_SESSION_LABELS="+strings.Join(labels, ","),
+		"UPTERM_HOST_USER="+req.HostUser,
+		"UPTERM_CLIENT_FINGERPRINT="+req.Fingerprint,
+		"UPTERM_CLIENT_IP="+req.RemoteIP,
+		"UPTERM_CLIENT_NAME="+req.ClientName,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return AuthzResponse{}, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return AuthzResponse{}, fmt.Errorf("error running authz command: %w", err)
+	}
+	if err == nil {
+		return AuthzResponse{Allow: true}, nil
+	}
+
+	s := bufio.NewScanner(&stdout)
+	if s.Scan() {
+		return AuthzResponse{Reason: strings.TrimSpace(s.Text())}, nil
+	}
+
+	return AuthzResponse{}, nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_AuthzWebhook(t *testing.T) {
+	requests := make(chan AuthzRequest, 1)
+	resp := AuthzResponse{Reason: "outside of the maintenance window"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AuthzRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests <- req
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	w, err := NewAuthzWebhook(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := AuthzRequest{SessionID: "session", Fingerprint: "SHA256:abc", RemoteIP: "192.0.2.1", Labels: map[string]string{"env": "prod"}}
+	got, err := w.Authorize(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != resp {
+		t.Fatalf("want %+v, got %+v", resp, got)
+	}
+	if posted := <-requests; posted.SessionID != req.SessionID || posted.Fingerprint != req.Fingerprint || posted.RemoteIP != req.RemoteIP || posted.Labels["env"] != "prod" {
+		t.Fatalf("unexpected request %+v", posted)
+	}
+
+	resp = AuthzResponse{Allow: true}
+	if got, err := w.Authorize(context.Background(), req); err != nil || !got.Allow {
+		t.Fatalf("expect the client allowed, got %+v: %v", got, err)
+	}
+	<-requests
+
+	if _, err := NewAuthzWebhook("ftp://example.com"); err == nil {
+		t.Fatal("expect error for a webhook url that isn't http")
+	}
+}
+
+func Test_AuthzCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	c, err := NewAuthzCommand(`sh -c 'if [ "$1" = 192.0.2.1 ] && [ "$UPTERM_CLIENT_FINGERPRINT" = SHA256:abc ]; then exit 0; fi; echo "not on call"; exit 1' sh %a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Authorize(context.Background(), AuthzRequest{Fingerprint: "SHA256:abc", RemoteIP: "192.0.2.1"})
+	if err != nil || !got.Allow {
+		t.Fatalf("expect the client allowed, got %+v: %v", got, err)
+	}
+
+	got, err = c.Authorize(context.Background(), AuthzRequest{Fingerprint: "SHA256:abc", RemoteIP: "192.0.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (AuthzResponse{Reason: "not on call"}); got != want {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+
+	if _, err := NewAuthorizer("https://example.com", "true"); err == nil {
+		t.Fatal("expect error for both a webhook and a command")
+	}
+	if a, err := NewAuthorizer("", ""); a != nil || err != nil {
+		t.Fatalf("expect no authorizer, got %v: %v", a, err)
+	}
+}
+
+type authorizerFunc func(ctx context.Context, req AuthzRequest) (AuthzResponse, error)
+
+func (f authorizerFunc) Authorize(ctx context.Context, req AuthzRequest) (AuthzResponse, error) {
+	return f(ctx, req)
+}
+
+type errConnDialer struct{}
+
+func (errConnDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	return nil, errors.New("dial refused")
+}
+
+func Test_authPiper_Authorize(t *testing.T) {
+	const nodeAddr = "10.0.0.1:22"
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(TestPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessRepo := newSessionRepo()
+	if err := sessRepo.Add(session{ID: "session", HostUser: "owen", Labels: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: nodeAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := testConnMetadata{
+		user:          user,
+		clientVersion: "SSH-2.0-OpenSSH_9.6",
+		remoteAddr:    &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+	}
+
+	cases := []struct {
+		name   string
+		resp   AuthzResponse
+		err    error
+		reason string
+	}{
+		{
+			name: "allowed",
+			resp: AuthzResponse{Allow: true},
+		},
+		{
+			name:   "denied",
+			resp:   AuthzResponse{Reason: "not on call\nignored"},
+			reason: "not on call",
+		},
+		{
+			name:   "failed",
+			err:    errors.New("hook is down"),
+			reason: rejectionMessages[RejectionAccessDenied],
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got AuthzRequest
+			ap := authPiper{
+				NodeAddr:    nodeAddr,
+				SessionRepo: sessRepo,
+				ConnDialer:  errConnDialer{},
+				Authorizer: authorizerFunc(func(ctx context.Context, req AuthzRequest) (AuthzResponse, error) {
+					got = req
+					return c.resp, c.err
+				}),
+				Logger: log.New(),
+			}
+
+			_, err := ap.PublicKeyCallback(conn, key, nil)
+			want := AuthzRequest{
+				SessionID:   "session",
+				HostUser:    "owen",
+				Labels:      map[string]string{"env": "prod"},
+				Fingerprint: utils.FingerprintSHA256(key),
+				RemoteIP:    "192.0.2.1",
+			}
+			if got.SessionID != want.SessionID || got.HostUser != want.HostUser || got.Labels["env"] != "prod" || got.Fingerprint != want.Fingerprint || got.RemoteIP != want.RemoteIP {
+				t.Fatalf("want request %+v, got %+v", want, got)
+			}
+
+			var r *Rejection
+			if c.reason == "" {
+				// allowed clients are dialed upstream
+				if errors.As(err, &r) {
+					t.Fatalf("expect the client allowed, got %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &r) || r.Code != RejectionAccessDenied || r.Message != c.reason {
+				t.Fatalf("want rejection %q, got %v", c.reason, err)
+			}
+		})
+	}
+}
@@ -3,19 +3,26 @@ package server
 import (
 	"crypto/rand"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/owenthereal/upterm/upterm"
+	"github.com/owenthereal/upterm/utils"
 	"golang.org/x/crypto/ssh"
 	"google.golang.org/protobuf/proto"
 )
 
 var (
 	errCertNotSignedByHost = fmt.Errorf("ssh cert not signed by host")
+	// errCertUnknownAuthority is returned when a cert carrying an auth request isn't signed by the user CA.
+	errCertUnknownAuthority = fmt.Errorf("ssh cert carrying auth request not signed by a user CA")
 )
 
 type UserCertChecker struct {
 	UserKeyFallback func(user string, key ssh.PublicKey) (ssh.PublicKey, error)
+	// Authorities are the keys of the user CAs certs carrying auth requests must be signed by.
+	// Certs signed by any key are accepted if it's empty.
+	Authorities []ssh.PublicKey
 }
 
 // Authenticate tries to pass auth request and public key from a cert.
@@ -31,12 +38,13 @@ func (c *UserCertChecker) Authenticate(user string, key ssh.PublicKey) (*AuthReq
 		return nil, nil, fmt.Errorf("public key not a cert")
 	}
 
-	return parseAuthRequestFromCert(user, cert)
+	return parseAuthRequestFromCert(user, cert, c.Authorities)
 }
 
 // parseAuthRequestFromCert parses auth request and public key from a cert.
 // The public key is always the signature key of the cert.
-func parseAuthRequestFromCert(principal string, cert *ssh.Certificate) (*AuthRequest, ssh.PublicKey, error) {
+// Certs carrying auth requests must be signed by one of authorities if it's non-empty.
+func parseAuthRequestFromCert(principal string, cert *ssh.Certificate, authorities []ssh.PublicKey) (*AuthRequest, ssh.PublicKey, error) {
 	key := cert.SignatureKey
 
 	if cert.CertType != ssh.UserCert {
@@ -44,17 +52,28 @@ func parseAuthRequestFromCert(principal string, cert *ssh.Certificate) (*AuthReq
 	}
 
 	checker := &ssh.CertChecker{}
-	if err := checker.CheckCert(principal, cert); err != nil {
-		return nil, key, err
-	}
+	ext, ok := cert.Permissions.Extensions[upterm.SSHCertExtension]
+	if !ok {
+		// The cert is signed by another CA, e.g. one authorized by the host.
+		// Its principals don't name upterm sessions, so only its signature and validity are checked.
+		if len(cert.ValidPrincipals) > 0 {
+			principal = cert.ValidPrincipals[0]
+		}
+		if err := checker.CheckCert(principal, cert); err != nil {
+			return nil, key, err
+		}
 
-	if cert.Permissions.Extensions == nil {
 		return nil, key, errCertNotSignedByHost
 	}
 
-	ext, ok := cert.Permissions.Extensions[upterm.SSHCertExtension]
-	if !ok {
-		return nil, key, errCertNotSignedByHost
+	if len(authorities) > 0 && !slices.ContainsFunc(authorities, func(ca ssh.PublicKey) bool {
+		return utils.KeysEqual(ca, cert.SignatureKey)
+	}) {
+		return nil, key, errCertUnknownAuthority
+	}
+
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return nil, key, err
 	}
 
 	var auth AuthRequest
@@ -74,6 +93,8 @@ type UserCertSigner struct {
 	SessionID   string
 	User        string
 	AuthRequest *AuthRequest
+	// CA signs the cert. The cert is signed by the key it certifies if it's nil.
+	CA ssh.Signer
 }
 
 func (g *UserCertSigner) SignCert(signer ssh.Signer) (ssh.Signer, error) {
@@ -96,8 +117,11 @@ func (g *UserCertSigner) SignCert(signer ssh.Signer) (ssh.Signer, error) {
 		},
 	}
 
-	// TODO: use different key to sign
-	if err := cert.SignCert(rand.Reader, signer); err != nil {
+	ca := g.CA
+	if ca == nil {
+		ca = signer
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
 		return nil, fmt.Errorf("error signing host cert: %w", err)
 	}
 
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_UserCertChecker_certSignedByOtherCA(t *testing.T) {
+	caSigner, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             clientSigner.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "alice",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := UserCertChecker{}
+	// the principal is the session user, not a principal of the cert
+	auth, key, err := checker.Authenticate("session-id", cert)
+	if err != errCertNotSignedByHost {
+		t.Fatalf("want=%s got=%s", errCertNotSignedByHost, err)
+	}
+	if auth != nil {
+		t.Fatalf("auth request should be empty: %v", auth)
+	}
+	if !utils.KeysEqual(caSigner.PublicKey(), key) {
+		t.Fatalf("key should be the CA key")
+	}
+
+	// tampered certs are rejected
+	cert.ValidPrincipals = []string{"bob"}
+	if _, _, err := checker.Authenticate("session-id", cert); err == nil || err == errCertNotSignedByHost {
+		t.Fatalf("tampered cert should fail: %v", err)
+	}
+}
+
+func Test_UserCertChecker_authorities(t *testing.T) {
+	proxySigner, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signer
+	}
+	ca, oldCA := newSigner(), newSigner()
+
+	signCert := func(ca ssh.Signer) ssh.PublicKey {
+		ucs := UserCertSigner{
+			SessionID: "1234",
+			User:      "owen",
+			AuthRequest: &AuthRequest{
+				ClientVersion: "SSH-2.0-Go",
+				AuthorizedKey: []byte(TestPublicKeyContent),
+			},
+			CA: ca,
+		}
+		cs, err := ucs.SignCert(proxySigner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cs.PublicKey()
+	}
+
+	checker := UserCertChecker{Authorities: []ssh.PublicKey{ca.PublicKey(), oldCA.PublicKey()}}
+
+	// certs signed by any of the CAs are accepted, e.g. while the CA is rotated
+	for _, signer := range []ssh.Signer{ca, oldCA} {
+		auth, _, err := checker.Authenticate("owen", signCert(signer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := TestPublicKeyContent, string(auth.AuthorizedKey); want != got {
+			t.Fatalf("want=%s got=%s", want, got)
+		}
+	}
+
+	// self-signed certs and certs of other CAs are rejected
+	for _, signer := range []ssh.Signer{nil, newSigner()} {
+		if _, _, err := checker.Authenticate("owen", signCert(signer)); err != errCertUnknownAuthority {
+			t.Fatalf("want=%s got=%v", errCertUnknownAuthority, err)
+		}
+	}
+
+	// any signer is accepted without authorities
+	if _, _, err := (&UserCertChecker{}).Authenticate("owen", signCert(nil)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	// HealthzPath is the path of the liveness of a node, served on the metric server and the health server.
+	HealthzPath = "/healthz"
+	// ReadyzPath is the path of the readiness of a node, served on the metric server and the health server.
+	// Nodes that aren't ready respond with 503, so that load balancers stop routing to them.
+	ReadyzPath = "/readyz"
+
+	// RoutingModeSSH routes clients to sessions on neighbour nodes over SSH.
+	RoutingModeSSH = "ssh"
+	// RoutingModeWebSocket routes clients to sessions on neighbour nodes over WebSocket, when the node doesn't serve SSH.
+	RoutingModeWebSocket = "websocket"
+)
+
+// NodeHealth is the health of a node.
+type NodeHealth struct {
+	NodeAddr string `json:"node_addr"`
+	Version  string `json:"version"`
+	// Routing is how the node routes clients to sessions on neighbour nodes, RoutingModeSSH or RoutingModeWebSocket.
+	// It's empty before the node serves.
+	Routing string `json:"routing,omitempty"`
+	// Ready reports whether the node serves hosts and clients. Reason is why it isn't.
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+	// Sessions and Clients are the numbers of sessions hosted on the node and the clients connected to them.
+	Sessions int `json:"sessions"`
+	Clients  int `json:"clients"`
+	// MaxSessions caps the sessions of the node if it's positive. QueuedHosts are the hosts waiting for capacity.
+	MaxSessions int `json:"max_sessions,omitempty"`
+	QueuedHosts int `json:"queued_hosts"`
+	// MemoryPressure reports whether the memory usage of the node exceeds its budget.
+	MemoryPressure bool `json:"memory_pressure"`
+	// EvictedNodes are the neighbour nodes failing health checks, whose clients are rejected.
+	EvictedNodes []string `json:"evicted_nodes,omitempty"`
+}
+
+// handleHealth serves the health of the node on HealthzPath, and on ReadyzPath with 503 if the node isn't ready.
+func handleHealth(mux *http.ServeMux, health func() NodeHealth) {
+	mux.Handle(HealthzPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, health(), http.StatusOK)
+	}))
+	mux.Handle(ReadyzPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := health()
+		code := http.StatusOK
+		if !h.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, h, code)
+	}))
+}
+
+func writeHealth(w http.ResponseWriter, h NodeHealth, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+// healthServer serves the health of the node on a dedicated address, apart from the metric server.
+type healthServer struct {
+	Health func() NodeHealth
+
+	server *http.Server
+	mux    sync.Mutex
+}
+
+func (h *healthServer) Shutdown(ctx context.Context) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.server == nil {
+		return nil
+	}
+
+	return h.server.Shutdown(ctx)
+}
+
+func (h *healthServer) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	handleHealth(mux, h.Health)
+
+	h.mux.Lock()
+	h.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	h.mux.Unlock()
+
+	return h.server.ListenAndServe()
+}
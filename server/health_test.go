@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_handleHealth(t *testing.T) {
+	health := NodeHealth{NodeAddr: "127.0.0.1:2222", Ready: true, Sessions: 2, Clients: 3}
+	mux := http.NewServeMux()
+	handleHealth(mux, func() NodeHealth { return health })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, NodeHealth) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var h NodeHealth
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, h
+	}
+
+	for _, path := range []string{HealthzPath, ReadyzPath} {
+		code, h := get(path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: want status %d, got %d", path, http.StatusOK, code)
+		}
+		if h.NodeAddr != health.NodeAddr || h.Sessions != 2 || h.Clients != 3 {
+			t.Fatalf("%s: want %+v, got %+v", path, health, h)
+		}
+	}
+
+	// nodes that aren't ready are alive
+	health.Ready, health.Reason = false, "the node is shutting down"
+	if code, _ := get(HealthzPath); code != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, code)
+	}
+	if code, h := get(ReadyzPath); code != http.StatusServiceUnavailable || h.Reason != health.Reason {
+		t.Fatalf("want status %d with reason %q, got %d with %q", http.StatusServiceUnavailable, health.Reason, code, h.Reason)
+	}
+}
+
+func Test_Server_Health(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := &MemoryProvider{}
+	_ = network.SetOpts(nil)
+
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	s := &Server{
+		NodeAddr:        "127.0.0.1:2222",
+		HostSigners:     []ssh.Signer{signer},
+		Signers:         []ssh.Signer{signer},
+		NetworkProvider: network,
+		MetricsProvider: provider.NewDiscardProvider(),
+		MaxSessions:     10,
+		Logger:          logger,
+	}
+
+	if h := s.Health(); h.Ready || h.Reason == "" {
+		t.Fatalf("want the node not ready before it serves, got %+v", h)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.ServeWithContext(context.Background(), ln, nil)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	h := s.Health()
+	for !h.Ready {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the node ready once it serves, got %+v", h)
+		}
+		time.Sleep(10 * time.Millisecond)
+		h = s.Health()
+	}
+	if h.Routing != RoutingModeSSH || h.MaxSessions != 10 || h.Sessions != 0 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	s.Shutdown()
+	if h := s.Health(); h.Ready || h.Reason == "" {
+		t.Fatalf("want the node not ready once it shuts down, got %+v", h)
+	}
+	select {
+	case <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the node shut down")
+	}
+}
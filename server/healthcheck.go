@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/owenthereal/upterm/upterm"
+)
+
+// CheckHealth verifies an uptermd serves SSH at addr by reading its identification, like a client
+// starting a handshake. Unspecified hosts, e.g. 0.0.0.0:2222 or :2222, are checked on the loopback address,
+// so that the check can use the listen address of the server.
+func CheckHealth(ctx context.Context, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	version, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading server identification: %w", err)
+	}
+
+	if version = strings.TrimSpace(version); !strings.HasPrefix(version, upterm.ServerSSHServerVersion) {
+		return fmt.Errorf("not an upterm server: %s", version)
+	}
+
+	return nil
+}
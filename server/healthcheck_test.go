@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_CheckHealth(t *testing.T) {
+	serve := func(banner string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, banner)
+				_ = conn.Close()
+			}
+		}()
+
+		return ln.Addr().String()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	healthy := serve("SSH-2.0-uptermd\r\n")
+	if err := CheckHealth(ctx, healthy); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listen address of the server is checked on the loopback address
+	_, port, _ := net.SplitHostPort(healthy)
+	for _, addr := range []string{"0.0.0.0:" + port, ":" + port} {
+		if err := CheckHealth(ctx, addr); err != nil {
+			t.Fatalf("%s: %s", addr, err)
+		}
+	}
+
+	if err := CheckHealth(ctx, serve("SSH-2.0-OpenSSH_9.6\r\n")); err == nil {
+		t.Fatal("want error for non-upterm server")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	_ = ln.Close()
+	if err := CheckHealth(ctx, closed); err == nil {
+		t.Fatal("want error for closed port")
+	}
+}
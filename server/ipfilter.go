@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+)
+
+// errIPDenied is returned for connections of an IP denied by the IPFilter.
+var errIPDenied = errors.New("the ip isn't allowed to connect")
+
+// IPFilter allows or denies connections to the SSH and the WebSocket proxies of a node by the IPs of clients. Deny
+// takes precedence over Allow, and IPs matching no prefix of Allow are denied unless Allow is empty. Connections from
+// the node itself, e.g. relayed by its WebSocket proxy, or over unix sockets aren't filtered. Connections of denied
+// IPs to the SSH proxy may only authenticate as neighbour nodes routing clients, with certs signed by the nodes.
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseIPFilter parses the CIDRs, e.g. 10.8.0.0/16, or the IPs of the allow and the deny lists of an IPFilter.
+func ParseIPFilter(allow, deny []string) (IPFilter, error) {
+	var (
+		f   IPFilter
+		err error
+	)
+	if f.Allow, err = parsePrefixes(allow); err != nil {
+		return IPFilter{}, err
+	}
+	if f.Deny, err = parsePrefixes(deny); err != nil {
+		return IPFilter{}, err
+	}
+
+	return f, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			addr, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing cidr %q: %w", c, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cidr %q: %w", c, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func (f IPFilter) enabled() bool {
+	return len(f.Allow) > 0 || len(f.Deny) > 0
+}
+
+// Allowed reports whether connections from ip are allowed.
+func (f IPFilter) Allowed(ip string) bool {
+	if ip == "" {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	if containsAddr(f.Deny, addr) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || containsAddr(f.Allow, addr)
+}
+
+func (f IPFilter) String() string {
+	return fmt.Sprintf("allow=%v,deny=%v", f.Allow, f.Deny)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newIPGuard returns an ipGuard enforcing filter, or nil if filter is empty.
+func newIPGuard(filter IPFilter, p provider.Provider) *ipGuard {
+	if !filter.enabled() {
+		return nil
+	}
+
+	return &ipGuard{
+		filter: filter,
+		denied: p.NewCounter("ip_denied_conn_count"),
+	}
+}
+
+// ipGuard enforces an IPFilter, counting the connections it denies.
+type ipGuard struct {
+	filter IPFilter
+	denied metrics.Counter
+}
+
+// Check returns errIPDenied if connections from ip are denied. It's safe to call on a nil guard.
+func (g *ipGuard) Check(ip string) error {
+	if g.Allowed(ip) {
+		return nil
+	}
+
+	g.Deny()
+	return errIPDenied
+}
+
+// Allowed reports whether connections from ip are allowed without counting them. It's safe to call on a nil guard.
+func (g *ipGuard) Allowed(ip string) bool {
+	return g == nil || g.filter.Allowed(ip)
+}
+
+// Deny counts a connection denied. It's safe to call on a nil guard.
+func (g *ipGuard) Deny() {
+	if g == nil {
+		return
+	}
+
+	g.denied.Add(1)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+)
+
+func Test_IPFilter_Allowed(t *testing.T) {
+	f, err := ParseIPFilter([]string{"10.8.0.0/16", "192.168.1.10", "fd00::/8"}, []string{"10.8.1.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.8.0.1", want: true},
+		{ip: "::ffff:10.8.0.1", want: true},
+		{ip: "192.168.1.10", want: true},
+		{ip: "fd00::1", want: true},
+		// deny takes precedence
+		{ip: "10.8.1.1", want: false},
+		{ip: "192.168.1.11", want: false},
+		{ip: "127.0.0.1", want: false},
+		{ip: "malformed", want: false},
+		// the node itself and unix sockets
+		{ip: "", want: true},
+	}
+	for _, c := range cases {
+		if got := f.Allowed(c.ip); got != c.want {
+			t.Fatalf("ip %q: want=%t got=%t", c.ip, c.want, got)
+		}
+	}
+
+	deny, err := ParseIPFilter(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deny.Allowed("198.51.100.1") || deny.Allowed("203.0.113.7") {
+		t.Fatal("expect IPs allowed unless denied without an allow list")
+	}
+
+	if _, err := ParseIPFilter([]string{"10.8.0.0/33"}, nil); err == nil {
+		t.Fatal("expect error for a malformed cidr")
+	}
+	if _, err := ParseIPFilter(nil, []string{"example.com"}); err == nil {
+		t.Fatal("expect error for a malformed ip")
+	}
+}
+
+func Test_ipGuard(t *testing.T) {
+	if g := newIPGuard(IPFilter{}, provider.NewDiscardProvider()); g != nil {
+		t.Fatal("expect no guard without a filter")
+	}
+	var g *ipGuard
+	if err := g.Check("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ParseIPFilter([]string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = newIPGuard(f, provider.NewDiscardProvider())
+	denied := generic.NewCounter("denied")
+	g.denied = denied
+
+	if err := g.Check("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check("172.16.0.1"); !errors.Is(err, errIPDenied) {
+		t.Fatalf("want=%v got=%v", errIPDenied, err)
+	}
+	if want, got := float64(1), denied.Value(); want != got {
+		t.Fatalf("want %v denied connections, got %v", want, got)
+	}
+}
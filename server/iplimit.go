@@ -0,0 +1,212 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// connRateWindow is the window of MaxConnsPerIP.
+	connRateWindow = time.Minute
+	// neighbourTrustTTL is how long the IP of a neighbour node is exempt from ConnLimits after a connection it routed.
+	neighbourTrustTTL = routeTTL
+)
+
+var (
+	// errIPRateLimited is returned for connections of an IP exceeding MaxConnsPerIP.
+	errIPRateLimited = errors.New("too many connections from the ip")
+	// errIPBanned is returned for connections of an IP banned for failing to authenticate.
+	errIPBanned = errors.New("the ip is banned for failing to authenticate")
+)
+
+// ConnLimits protect the proxies of a node from clients hogging connections or scanning keys. A zero value disables
+// the corresponding limit. Connections from the node itself, e.g. relayed by its WebSocket proxy, and from neighbour
+// nodes aren't limited.
+type ConnLimits struct {
+	// MaxConnsPerIP is the max connections per minute from an IP to the SSH and the WebSocket proxies.
+	MaxConnsPerIP int
+	// AuthFailureBan bans an IP whose connections fail to authenticate MaxAuthFailuresPerIP times within the
+	// duration for the duration.
+	AuthFailureBan       time.Duration
+	MaxAuthFailuresPerIP int
+}
+
+func (l ConnLimits) enabled() bool {
+	return l.MaxConnsPerIP > 0 || (l.AuthFailureBan > 0 && l.MaxAuthFailuresPerIP > 0)
+}
+
+// newIPLimiter returns an ipLimiter enforcing limits, or nil if limits are disabled.
+func newIPLimiter(limits ConnLimits, p provider.Provider, logger log.FieldLogger) *ipLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+
+	return &ipLimiter{
+		limits:    limits,
+		limited:   p.NewCounter("ip_conn_limited_count"),
+		refused:   p.NewCounter("ip_banned_conn_count"),
+		bans:      p.NewCounter("ip_ban_count"),
+		bannedIPs: p.NewGauge("ip_banned_count"),
+		logger:    logger,
+		conns:     make(map[string][]time.Time),
+		failures:  make(map[string][]time.Time),
+		banned:    make(map[string]time.Time),
+		trusted:   make(map[string]time.Time),
+	}
+}
+
+// ipLimiter enforces ConnLimits by the IPs of connections.
+type ipLimiter struct {
+	limits ConnLimits
+	// limited and refused count connections refused over MaxConnsPerIP and from banned IPs.
+	limited metrics.Counter
+	refused metrics.Counter
+	// bans counts the IPs banned, and bannedIPs are the IPs banned currently.
+	bans      metrics.Counter
+	bannedIPs metrics.Gauge
+	logger    log.FieldLogger
+
+	mu        sync.Mutex
+	conns     map[string][]time.Time
+	failures  map[string][]time.Time
+	banned    map[string]time.Time
+	trusted   map[string]time.Time
+	lastSweep time.Time
+}
+
+// Allow records a connection from ip. It returns errIPBanned if ip is banned, or errIPRateLimited if ip exceeds
+// MaxConnsPerIP. Refused connections are counted too, so that a client retrying in a tight loop stays limited.
+// It's safe to call on a nil limiter.
+func (l *ipLimiter) Allow(ip string, now time.Time) error {
+	if l == nil || exemptIP(ip) {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweepLocked(now)
+	if l.trustedLocked(ip, now) {
+		return nil
+	}
+	if until, ok := l.banned[ip]; ok && now.Before(until) {
+		l.refused.Add(1)
+		return errIPBanned
+	}
+	if !record(l.conns, ip, now, connRateWindow, l.limits.MaxConnsPerIP) {
+		l.limited.Add(1)
+		return errIPRateLimited
+	}
+
+	return nil
+}
+
+// Fail records a connection from ip failing to authenticate, banning ip for AuthFailureBan once it fails
+// MaxAuthFailuresPerIP times within AuthFailureBan. It's safe to call on a nil limiter.
+func (l *ipLimiter) Fail(ip string, now time.Time) {
+	if l == nil || l.limits.AuthFailureBan <= 0 || exemptIP(ip) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.trustedLocked(ip, now) {
+		return
+	}
+	if until, ok := l.banned[ip]; ok && now.Before(until) {
+		return
+	}
+	record(l.failures, ip, now, l.limits.AuthFailureBan, l.limits.MaxAuthFailuresPerIP)
+	if len(l.failures[ip]) < l.limits.MaxAuthFailuresPerIP {
+		return
+	}
+
+	delete(l.failures, ip)
+	l.banned[ip] = now.Add(l.limits.AuthFailureBan)
+	l.bans.Add(1)
+	l.bannedIPs.Set(float64(len(l.banned)))
+	l.logger.WithFields(log.Fields{
+		"ip":       ip,
+		"failures": l.limits.MaxAuthFailuresPerIP,
+		"until":    now.Add(l.limits.AuthFailureBan),
+		"event":    "ip-banned",
+	}).Warn("banned ip failing to authenticate")
+}
+
+// Trust exempts ip of a neighbour node routing connections to this node from the limits for neighbourTrustTTL.
+// It's safe to call on a nil limiter.
+func (l *ipLimiter) Trust(ip string, now time.Time) {
+	if l == nil || exemptIP(ip) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.trusted[ip] = now.Add(neighbourTrustTTL)
+}
+
+func (l *ipLimiter) trustedLocked(ip string, now time.Time) bool {
+	until, ok := l.trusted[ip]
+	return ok && now.Before(until)
+}
+
+func (l *ipLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) <= connRateWindow {
+		return
+	}
+	l.lastSweep = now
+
+	sweep(l.conns, now, connRateWindow)
+	if l.limits.AuthFailureBan > 0 {
+		sweep(l.failures, now, l.limits.AuthFailureBan)
+	}
+	for ip, until := range l.banned {
+		if !now.Before(until) {
+			delete(l.banned, ip)
+		}
+	}
+	for ip, until := range l.trusted {
+		if !now.Before(until) {
+			delete(l.trusted, ip)
+		}
+	}
+	l.bannedIPs.Set(float64(len(l.banned)))
+}
+
+// exemptIP reports whether connections from ip are exempt from the limits, e.g. relayed by the node itself.
+func exemptIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed == nil || parsed.IsLoopback()
+}
+
+// connIP returns the IP of the peer of conn, or an empty IP if the peer is the node itself, e.g. the WebSocket proxy
+// relaying a connection to the SSH proxy.
+func connIP(conn net.Conn) string {
+	ip := addrIP(conn.RemoteAddr().String())
+	if ip != "" && ip == addrIP(conn.LocalAddr().String()) {
+		return ""
+	}
+
+	return ip
+}
+
+// addrIP returns the IP of a host:port address, or an empty IP if it has none.
+func addrIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_ipLimiter_Allow(t *testing.T) {
+	l := newIPLimiter(ConnLimits{MaxConnsPerIP: 2}, provider.NewDiscardProvider(), log.New())
+	now := time.Now()
+
+	cases := []struct {
+		ip   string
+		at   time.Time
+		want error
+	}{
+		{ip: "10.0.0.1", at: now},
+		{ip: "10.0.0.1", at: now.Add(time.Second)},
+		{ip: "10.0.0.1", at: now.Add(2 * time.Second), want: errIPRateLimited},
+		{ip: "10.0.0.2", at: now.Add(2 * time.Second)},
+		// the node itself isn't limited
+		{ip: "127.0.0.1", at: now.Add(3 * time.Second)},
+		{ip: "127.0.0.1", at: now.Add(3 * time.Second)},
+		{ip: "127.0.0.1", at: now.Add(3 * time.Second)},
+		{ip: "", at: now.Add(3 * time.Second)},
+		// the window slides
+		{ip: "10.0.0.1", at: now.Add(time.Minute + 3*time.Second)},
+	}
+
+	for i, c := range cases {
+		if got := l.Allow(c.ip, c.at); !errors.Is(got, c.want) {
+			t.Fatalf("case %d: want=%v got=%v", i, c.want, got)
+		}
+	}
+}
+
+func Test_ipLimiter_Fail(t *testing.T) {
+	l := newIPLimiter(ConnLimits{AuthFailureBan: time.Minute, MaxAuthFailuresPerIP: 3}, provider.NewDiscardProvider(), log.New())
+	now := time.Now()
+
+	l.Fail("10.0.0.1", now)
+	l.Fail("10.0.0.1", now.Add(time.Second))
+	if err := l.Allow("10.0.0.1", now.Add(2*time.Second)); err != nil {
+		t.Fatalf("ip should be allowed before the ban: %s", err)
+	}
+
+	// failures slide out of the window
+	l.Fail("10.0.0.1", now.Add(time.Minute+time.Second))
+	if err := l.Allow("10.0.0.1", now.Add(time.Minute+2*time.Second)); err != nil {
+		t.Fatalf("ip should be allowed with failures out of the window: %s", err)
+	}
+
+	l.Fail("10.0.0.1", now.Add(time.Minute+3*time.Second))
+	l.Fail("10.0.0.1", now.Add(time.Minute+4*time.Second))
+	if err := l.Allow("10.0.0.1", now.Add(time.Minute+5*time.Second)); !errors.Is(err, errIPBanned) {
+		t.Fatalf("want=%v got=%v", errIPBanned, err)
+	}
+	if err := l.Allow("10.0.0.2", now.Add(time.Minute+5*time.Second)); err != nil {
+		t.Fatalf("other ips should be allowed: %s", err)
+	}
+
+	// the ban expires
+	if err := l.Allow("10.0.0.1", now.Add(2*time.Minute+5*time.Second)); err != nil {
+		t.Fatalf("ip should be allowed after the ban: %s", err)
+	}
+}
+
+func Test_ipLimiter_Trust(t *testing.T) {
+	l := newIPLimiter(ConnLimits{MaxConnsPerIP: 1, AuthFailureBan: time.Minute, MaxAuthFailuresPerIP: 1}, provider.NewDiscardProvider(), log.New())
+	now := time.Now()
+
+	l.Trust("10.0.0.1", now)
+	l.Fail("10.0.0.1", now)
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("10.0.0.1", now.Add(time.Second)); err != nil {
+			t.Fatalf("trusted ip should be allowed: %s", err)
+		}
+	}
+
+	// the trust expires
+	at := now.Add(neighbourTrustTTL + time.Second)
+	if err := l.Allow("10.0.0.1", at); err != nil {
+		t.Fatalf("first connection should be allowed: %s", err)
+	}
+	if err := l.Allow("10.0.0.1", at); !errors.Is(err, errIPRateLimited) {
+		t.Fatalf("want=%v got=%v", errIPRateLimited, err)
+	}
+}
+
+func Test_ipLimiter_Disabled(t *testing.T) {
+	l := newIPLimiter(ConnLimits{AuthFailureBan: time.Minute}, provider.NewDiscardProvider(), log.New())
+	if l != nil {
+		t.Fatal("limiter should be disabled without limits")
+	}
+
+	// a nil limiter allows everything
+	l.Fail("10.0.0.1", time.Now())
+	l.Trust("10.0.0.1", time.Now())
+	if err := l.Allow("10.0.0.1", time.Now()); err != nil {
+		t.Fatalf("disabled limiter should allow: %s", err)
+	}
+}
+
+func Test_connIP(t *testing.T) {
+	cases := []struct {
+		local  string
+		remote string
+		want   string
+	}{
+		{local: "10.0.0.1:22", remote: "10.0.0.2:1234", want: "10.0.0.2"},
+		{local: "[::1]:22", remote: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		// relayed by the node itself
+		{local: "10.0.0.1:22", remote: "10.0.0.1:1234", want: ""},
+	}
+
+	for i, c := range cases {
+		conn := addrConn{local: mustTCPAddr(t, c.local), remote: mustTCPAddr(t, c.remote)}
+		if got := connIP(conn); c.want != got {
+			t.Fatalf("case %d: want=%q got=%q", i, c.want, got)
+		}
+	}
+
+	if got := addrIP("not an addr"); got != "" {
+		t.Fatalf("want empty ip got=%q", got)
+	}
+}
+
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func mustTCPAddr(t *testing.T, addr string) net.Addr {
+	t.Helper()
+
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/upterm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nodeCheckInterval = 10 * time.Second
+	nodeCheckTimeout  = 3 * time.Second
+)
+
+// nodeJanitor health checks the neighbour nodes this node routed clients to recently, as recorded by Routes.
+// A node failing checks for longer than Grace is evicted: its routes are dropped from the topology and
+// clients of its sessions are rejected without dialing it, until it passes a check again.
+// Evicted nodes that don't recover within routeTTL are deregistered.
+type nodeJanitor struct {
+	Grace  time.Duration
+	Routes *routeRecorder
+	Logger log.FieldLogger
+
+	// check health checks a node. It defaults to checkNode.
+	check func(ctx context.Context, addr string) error
+
+	evictions    metrics.Counter
+	recoveries   metrics.Counter
+	evictedNodes metrics.Gauge
+
+	mu    sync.Mutex
+	nodes map[string]*nodeHealth
+}
+
+type nodeHealth struct {
+	failingSince time.Time
+	evictedAt    time.Time
+}
+
+func (h *nodeHealth) evicted() bool {
+	return !h.evictedAt.IsZero()
+}
+
+func newNodeJanitor(grace time.Duration, routes *routeRecorder, p provider.Provider, logger log.FieldLogger) *nodeJanitor {
+	return &nodeJanitor{
+		Grace:        grace,
+		Routes:       routes,
+		Logger:       logger,
+		check:        checkNode,
+		evictions:    p.NewCounter("node_eviction_count"),
+		recoveries:   p.NewCounter("node_recovery_count"),
+		evictedNodes: p.NewGauge("node_evicted_count"),
+		nodes:        make(map[string]*nodeHealth),
+	}
+}
+
+// Evicted reports whether the node with addr is evicted. It's safe to call on a nil janitor.
+func (j *nodeJanitor) Evicted(addr string) bool {
+	if j == nil {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	h, ok := j.nodes[addr]
+	return ok && h.evicted()
+}
+
+// EvictedNodes returns the addresses of the evicted nodes in order. It's safe to call on a nil janitor.
+func (j *nodeJanitor) EvictedNodes() []string {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var addrs []string
+	for addr, h := range j.nodes {
+		if h.evicted() {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+func (j *nodeJanitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(nodeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.Check(ctx, time.Now())
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Check health checks the nodes concurrently and evicts or restores them.
+func (j *nodeJanitor) Check(ctx context.Context, now time.Time) {
+	targets := make(map[string]bool)
+	for _, r := range j.Routes.Routes(now) {
+		targets[r.To] = true
+	}
+
+	j.mu.Lock()
+	for addr, h := range j.nodes {
+		switch {
+		case h.evicted() && now.Sub(h.evictedAt) > routeTTL:
+			j.Logger.WithFields(log.Fields{"node": addr, "event": "node-deregistered"}).Warn("deregistered evicted node")
+			j.evictedNodes.Add(-1)
+			delete(j.nodes, addr)
+		case h.evicted():
+			targets[addr] = true
+		case !targets[addr]:
+			// not routed to anymore
+			delete(j.nodes, addr)
+		}
+	}
+	j.mu.Unlock()
+
+	results := make(map[string]error)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for addr := range targets {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, nodeCheckTimeout)
+			defer cancel()
+
+			err := j.check(ctx, addr)
+
+			mu.Lock()
+			results[addr] = err
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for addr, err := range results {
+		j.update(addr, err, now)
+	}
+}
+
+func (j *nodeJanitor) update(addr string, err error, now time.Time) {
+	logger := j.Logger.WithField("node", addr)
+
+	h, ok := j.nodes[addr]
+	if err == nil {
+		if ok && h.evicted() {
+			logger.WithField("event", "node-recovered").Warn("restored recovered node")
+			j.recoveries.Add(1)
+			j.evictedNodes.Add(-1)
+		}
+		delete(j.nodes, addr)
+		return
+	}
+
+	if !ok {
+		h = &nodeHealth{failingSince: now}
+		j.nodes[addr] = h
+		logger.WithError(err).Info("node failed health check")
+	}
+	if h.evicted() || now.Sub(h.failingSince) < j.Grace {
+		return
+	}
+
+	h.evictedAt = now
+	j.Routes.Forget(addr)
+	j.evictions.Add(1)
+	j.evictedNodes.Add(1)
+	logger.WithError(err).WithFields(log.Fields{
+		"event":         "node-evicted",
+		"failing-since": h.failingSince,
+	}).Warn("evicted node failing health checks")
+}
+
+// checkNode verifies the node at addr accepts connections as an upterm server.
+func checkNode(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	version, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(version, upterm.ServerSSHServerVersion) {
+		return fmt.Errorf("not an upterm server: %s", strings.TrimSpace(version))
+	}
+
+	return nil
+}
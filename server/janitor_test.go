@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/upterm"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_nodeJanitor(t *testing.T) {
+	const (
+		node  = "10.0.0.2:22"
+		grace = time.Minute
+	)
+
+	var (
+		mu      sync.Mutex
+		healthy bool
+	)
+	setHealthy := func(h bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		healthy = h
+	}
+
+	now := time.Now()
+	routes := newRouteRecorder("10.0.0.1:22")
+	routes.Record(node, now)
+
+	logger := log.New()
+	logger.SetLevel(log.PanicLevel)
+	j := newNodeJanitor(grace, routes, provider.NewDiscardProvider(), logger)
+	evictions, recoveries := generic.NewCounter("evictions"), generic.NewCounter("recoveries")
+	j.evictions, j.recoveries = evictions, recoveries
+	j.check = func(ctx context.Context, addr string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if addr != node {
+			t.Errorf("unexpected check of %s", addr)
+		}
+		if !healthy {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	j.Check(context.Background(), now)
+	if j.Evicted(node) {
+		t.Fatal("want node not evicted within the grace period")
+	}
+
+	j.Check(context.Background(), now.Add(grace))
+	if !j.Evicted(node) {
+		t.Fatal("want node evicted after the grace period")
+	}
+	if want, got := []string{node}, j.EvictedNodes(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("want evicted nodes %v, got %v", want, got)
+	}
+	if want, got := 1.0, evictions.Value(); want != got {
+		t.Fatalf("want %v evictions, got %v", want, got)
+	}
+	if routes := routes.Routes(now.Add(grace)); len(routes) != 0 {
+		t.Fatalf("want routes to the evicted node dropped, got %v", routes)
+	}
+
+	cd := sidewayConnDialer{NodeAddr: "10.0.0.1:22", Janitor: j, Logger: logger}
+	_, err := cd.Dial(context.Background(), &api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: node})
+	var r *Rejection
+	if !errors.As(err, &r) || r.Code != RejectionNodeUnavailable {
+		t.Fatalf("want rejection %s dialing an evicted node, got %v", RejectionNodeUnavailable, err)
+	}
+
+	// evicted nodes are still checked and restored when they recover
+	setHealthy(true)
+	j.Check(context.Background(), now.Add(2*grace))
+	if j.Evicted(node) {
+		t.Fatal("want recovered node restored")
+	}
+	if got := j.EvictedNodes(); len(got) != 0 {
+		t.Fatalf("want no evicted nodes, got %v", got)
+	}
+	if want, got := 1.0, recoveries.Value(); want != got {
+		t.Fatalf("want %v recoveries, got %v", want, got)
+	}
+
+	// evicted nodes that don't recover are deregistered
+	setHealthy(false)
+	routes.Record(node, now.Add(3*grace))
+	j.Check(context.Background(), now.Add(3*grace))
+	j.Check(context.Background(), now.Add(4*grace))
+	if !j.Evicted(node) {
+		t.Fatal("want node evicted again")
+	}
+	j.Check(context.Background(), now.Add(4*grace+routeTTL+time.Second))
+	if j.Evicted(node) {
+		t.Fatal("want evicted node deregistered after the route TTL")
+	}
+}
+
+func Test_checkNode(t *testing.T) {
+	serve := func(version string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(version + "\r\n"))
+				conn.Close()
+			}
+		}()
+
+		return ln.Addr().String()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := checkNode(ctx, serve(upterm.ServerSSHServerVersion)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNode(ctx, serve("SSH-2.0-OpenSSH_9.6")); err == nil {
+		t.Fatal("want error checking a server that isn't upterm")
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	unixAddrPrefix    = "unix://"
+	systemdAddrPrefix = "systemd:"
+
+	// listenFDsStart is the first file descriptor passed by systemd socket activation.
+	listenFDsStart = 3
+)
+
+// activated are the sockets passed by systemd socket activation, which are read from the environment once.
+var activated = sync.OnceValue(func() *systemdSockets {
+	fds, err := parseListenFDs(os.Getenv, os.Getpid())
+	// the sockets aren't passed on to child processes
+	for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(k)
+	}
+
+	return &systemdSockets{fds: fds, err: err}
+})
+
+// listen listens on addr, which is either a TCP address, e.g. :2222, a unix domain socket, e.g.
+// unix:///run/uptermd.sock, or a socket passed by systemd socket activation, e.g. systemd:uptermd-ssh.socket.
+// Sockets passed by systemd are selected by their FileDescriptorName=, which defaults to the name of the socket unit.
+// systemd: without a name selects the only socket passed.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return listenUnix(path)
+	}
+	if name, ok := strings.CutPrefix(addr, systemdAddrPrefix); ok {
+		return activated().Listener(name)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on the unix domain socket at path. A socket left behind by a process that exited is removed,
+// whereas a socket that is still accepted on fails listening. The socket is removed once the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket address %q has no path", unixAddrPrefix+path)
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, tcpDialTimeout)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing stale unix socket: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// listenFD is a socket passed by systemd socket activation.
+type listenFD struct {
+	fd   int
+	name string
+}
+
+// parseListenFDs parses the sockets passed by systemd socket activation to the process with pid from LISTEN_PID,
+// LISTEN_FDS, and LISTEN_FDNAMES. It returns none if the sockets aren't passed to the process.
+func parseListenFDs(getenv func(string) string, pid int) ([]listenFD, error) {
+	if getenv("LISTEN_PID") == "" && getenv("LISTEN_FDS") == "" {
+		return nil, nil
+	}
+
+	lpid, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing LISTEN_PID: %w", err)
+	}
+	if lpid != pid {
+		// the sockets are passed to another process, e.g. the parent
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("error parsing LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+
+	var names []string
+	if s := getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+
+	fds := make([]listenFD, n)
+	for i := range fds {
+		fds[i].fd = listenFDsStart + i
+		if i < len(names) {
+			fds[i].name = names[i]
+		}
+	}
+
+	return fds, nil
+}
+
+// systemdSockets are the sockets passed by systemd socket activation. Each of them is listened on once.
+type systemdSockets struct {
+	fds []listenFD
+	err error
+
+	mu      sync.Mutex
+	claimed map[int]bool
+}
+
+// Listener returns a listener of the socket named name, or of the only socket passed if name is empty.
+func (s *systemdSockets) Listener(name string) (net.Listener, error) {
+	if s.err != nil {
+		return nil, fmt.Errorf("error reading sockets passed by systemd: %w", s.err)
+	}
+	if len(s.fds) == 0 {
+		return nil, errors.New("no sockets are passed by systemd socket activation, check LISTEN_FDS")
+	}
+
+	var lfd *listenFD
+	if name == "" {
+		if len(s.fds) > 1 {
+			return nil, fmt.Errorf("%d sockets are passed by systemd, select one with %sNAME", len(s.fds), systemdAddrPrefix)
+		}
+		lfd = &s.fds[0]
+	} else {
+		for i := range s.fds {
+			if s.fds[i].name == name {
+				lfd = &s.fds[i]
+				break
+			}
+		}
+		if lfd == nil {
+			return nil, fmt.Errorf("no socket named %s is passed by systemd", name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claimed[lfd.fd] {
+		return nil, fmt.Errorf("socket %d passed by systemd is listened on more than once", lfd.fd)
+	}
+
+	f := os.NewFile(uintptr(lfd.fd), lfd.name)
+	if f == nil {
+		return nil, fmt.Errorf("socket %d passed by systemd is invalid", lfd.fd)
+	}
+	// the listener dups the file descriptor
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket %d passed by systemd: %w", lfd.fd, err)
+	}
+
+	if s.claimed == nil {
+		s.claimed = make(map[int]bool)
+	}
+	s.claimed[lfd.fd] = true
+
+	return ln, nil
+}
+
+// nodeAddrOf returns the address of ln other nodes dial, which is only known for TCP listeners.
+func nodeAddrOf(ln net.Listener) string {
+	if ln == nil {
+		return ""
+	}
+	if _, ok := ln.Addr().(*net.TCPAddr); !ok {
+		return ""
+	}
+
+	return ln.Addr().String()
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_listenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are tested on unix")
+	}
+
+	// unix socket paths are limited to ~100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "uptermd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "uptermd.sock")
+
+	ln, err := listen(unixAddrPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeAddrOf(ln); got != "" {
+		t.Fatalf("want no node addr of a unix socket, got %s", got)
+	}
+
+	go func(ln net.Listener) {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}(ln)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := listen(unixAddrPrefix + path); err == nil {
+		t.Fatal("expect error listening on a socket in use")
+	}
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a socket left behind by a process that exited is removed
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err = listen(unixAddrPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	if _, err := listen(unixAddrPrefix); err == nil {
+		t.Fatal("expect error for a unix socket without a path")
+	}
+}
+
+func Test_parseListenFDs(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		want    []listenFD
+		wantErr bool
+	}{
+		{
+			name: "not activated",
+		},
+		{
+			name: "another process",
+			env:  map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"},
+		},
+		{
+			name: "named",
+			env:  map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "ssh:ws"},
+			want: []listenFD{{fd: 3, name: "ssh"}, {fd: 4, name: "ws"}},
+		},
+		{
+			name: "unnamed",
+			env:  map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1"},
+			want: []listenFD{{fd: 3}},
+		},
+		{
+			name:    "malformed",
+			env:     map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "x"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseListenFDs(func(k string) string { return c.env[k] }, 42)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("want error %t, got %v", c.wantErr, err)
+			}
+			if diff := cmp.Diff(c.want, got, cmp.AllowUnexported(listenFD{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func Test_systemdSockets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// systemd hands over a descriptor no *os.File owns: the listener closes it once
+	// it's listened on, and f would otherwise close it again when it's finalized,
+	// by then possibly reused by another file
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &systemdSockets{fds: []listenFD{{fd: fd, name: "uptermd-ssh.socket"}}}
+	if _, err := s.Listener("uptermd-ws.socket"); err == nil {
+		t.Fatal("expect error for a socket that isn't passed")
+	}
+
+	activated, err := s.Listener("uptermd-ssh.socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer activated.Close()
+	if want, got := ln.Addr().String(), nodeAddrOf(activated); want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	go func() {
+		conn, err := activated.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := s.Listener(""); err == nil {
+		t.Fatal("expect error listening on a socket more than once")
+	}
+	if _, err := (&systemdSockets{}).Listener(""); err == nil {
+		t.Fatal("expect error without sockets passed")
+	}
+}
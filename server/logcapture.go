@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultLogCaptureDuration is how long log captures last if the operator doesn't say.
+	defaultLogCaptureDuration = 10 * time.Minute
+	// maxLogCaptureDuration caps log captures, so that a forgotten capture doesn't keep the node logging at debug.
+	maxLogCaptureDuration = time.Hour
+	// logCaptureMaxBytes caps the size of each capture file.
+	logCaptureMaxBytes = 64 << 20
+)
+
+var (
+	// errLogCaptureTarget is returned when a log capture names neither a session nor a component.
+	errLogCaptureTarget = errors.New("log capture requires a session or a component")
+	// errLogCaptureDuration is returned when the duration of a log capture is negative or above maxLogCaptureDuration.
+	errLogCaptureDuration = fmt.Errorf("log capture duration must be positive and at most %s", maxLogCaptureDuration)
+)
+
+// logCapture captures the debug logs of a session or a component of the node, e.g. sshd, to capped files for a
+// while, so that operators debug a problematic session in production without enabling debug logging globally.
+// Logger logs at debug while captures run, but entries more verbose than its level are only written to the captures.
+type logCapture struct {
+	Logger *log.Logger
+	Dir    string
+	// MaxBytes caps the size of each capture file.
+	MaxBytes int64
+
+	mu        sync.Mutex
+	captures  map[*capture]struct{}
+	level     log.Level
+	formatter log.Formatter
+}
+
+// capture is a running log capture.
+type capture struct {
+	Session   string
+	Component string
+	File      string
+	ExpiresAt time.Time
+
+	f       *os.File
+	written int64
+}
+
+func newLogCapture(logger *log.Logger, dir string) *logCapture {
+	l := &logCapture{
+		Logger:   logger,
+		Dir:      dir,
+		MaxBytes: logCaptureMaxBytes,
+		captures: make(map[*capture]struct{}),
+	}
+	logger.AddHook(l)
+
+	return l
+}
+
+// Start captures the logs of the session or the component for d, or defaultLogCaptureDuration if it's zero, to a
+// new file in Dir. The capture ends on its own.
+func (l *logCapture) Start(session, component string, d time.Duration) (*capture, error) {
+	if session == "" && component == "" {
+		return nil, errLogCaptureTarget
+	}
+	if d == 0 {
+		d = defaultLogCaptureDuration
+	}
+	if d < 0 || d > maxLogCaptureDuration {
+		return nil, errLogCaptureDuration
+	}
+
+	if err := os.MkdirAll(l.Dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(l.Dir, "capture-*.log")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &capture{
+		Session:   session,
+		Component: component,
+		File:      filepath.Clean(f.Name()),
+		ExpiresAt: time.Now().Add(d),
+		f:         f,
+	}
+
+	l.mu.Lock()
+	if len(l.captures) == 0 {
+		l.level, l.formatter = l.Logger.GetLevel(), l.Logger.Formatter
+		if l.level < log.DebugLevel {
+			l.Logger.SetFormatter(levelFormatter{Formatter: l.formatter, level: l.level})
+			l.Logger.SetLevel(log.DebugLevel)
+		}
+	}
+	l.captures[c] = struct{}{}
+	l.mu.Unlock()
+
+	time.AfterFunc(d, func() { l.stop(c) })
+
+	return c, nil
+}
+
+func (l *logCapture) stop(c *capture) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.captures, c)
+	_ = c.f.Close()
+
+	if len(l.captures) == 0 && l.level < log.DebugLevel {
+		l.Logger.SetLevel(l.level)
+		l.Logger.SetFormatter(l.formatter)
+	}
+}
+
+// Len returns the number of running captures.
+func (l *logCapture) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.captures)
+}
+
+func (l *logCapture) Levels() []log.Level {
+	return log.AllLevels
+}
+
+var logCaptureFormatter = &log.JSONFormatter{}
+
+func (l *logCapture) Fire(entry *log.Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for c := range l.captures {
+		if !c.matches(entry) {
+			continue
+		}
+
+		b, err := logCaptureFormatter.Format(entry)
+		if err != nil {
+			return err
+		}
+		// entries past the cap are dropped rather than cut off
+		if c.written+int64(len(b)) > l.MaxBytes {
+			continue
+		}
+		n, err := c.f.Write(b)
+		c.written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *capture) matches(entry *log.Entry) bool {
+	if c.Session != "" && fmt.Sprint(entry.Data["session"]) != c.Session {
+		return false
+	}
+	if c.Component != "" && fmt.Sprint(entry.Data["com"]) != c.Component {
+		return false
+	}
+
+	return true
+}
+
+// levelFormatter formats entries up to level, dropping entries more verbose than it.
+type levelFormatter struct {
+	log.Formatter
+	level log.Level
+}
+
+func (f levelFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if entry.Level > f.level {
+		return nil, nil
+	}
+
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_logCapture(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+
+	l := newLogCapture(logger, t.TempDir())
+	c, err := l.Start("abc", "", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := log.DebugLevel, logger.GetLevel(); want != got {
+		t.Fatalf("want level %s while capturing, got %s", want, got)
+	}
+
+	logger.WithFields(log.Fields{"com": "sshd", "session": "abc"}).Debug("captured debug")
+	logger.WithFields(log.Fields{"com": "sshd", "session": "abc"}).Info("captured info")
+	logger.WithFields(log.Fields{"com": "sshd", "session": "xyz"}).Debug("other session")
+
+	// the output keeps the level of the logger
+	if strings.Contains(out.String(), "debug") || !strings.Contains(out.String(), "captured info") {
+		t.Fatalf("want only info logged, got %q", out.String())
+	}
+
+	for l.Len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want, got := log.InfoLevel, logger.GetLevel(); want != got {
+		t.Fatalf("want level %s restored, got %s", want, got)
+	}
+	logger.WithFields(log.Fields{"com": "sshd", "session": "abc"}).Info("after the capture")
+
+	b, err := os.ReadFile(c.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured := string(b)
+	if !strings.Contains(captured, "captured debug") || !strings.Contains(captured, "captured info") {
+		t.Fatalf("want the session captured, got %q", captured)
+	}
+	if strings.Contains(captured, "other session") || strings.Contains(captured, "after the capture") {
+		t.Fatalf("want only the session captured while capturing, got %q", captured)
+	}
+}
+
+func Test_logCapture_invalid(t *testing.T) {
+	l := newLogCapture(log.New(), t.TempDir())
+
+	if _, err := l.Start("", "", time.Minute); err != errLogCaptureTarget {
+		t.Fatalf("want %v, got %v", errLogCaptureTarget, err)
+	}
+	if _, err := l.Start("abc", "", 2*maxLogCaptureDuration); err != errLogCaptureDuration {
+		t.Fatalf("want %v, got %v", errLogCaptureDuration, err)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrMemoryPressure is returned when a host creates a session on a node under memory pressure.
+var ErrMemoryPressure = errors.New("the server is low on memory, try again later")
+
+const (
+	memoryCheckInterval = 5 * time.Second
+	// memoryRecoveryRatio is the fraction of the budget memory usage falls below for the pressure to be relieved,
+	// so that the node doesn't flap between accepting and refusing sessions around the budget.
+	memoryRecoveryRatio = 0.9
+	// sessionEvictedReason is sent to the hosts of sessions evicted under memory pressure.
+	sessionEvictedReason = "the server is low on memory and ended the idle session"
+)
+
+// memoryWatchdog monitors the memory usage of the node against Budget. Under pressure, the node refuses
+// to create sessions, and if EvictIdle is set, the oldest session without connected clients is evicted on
+// each check, notifying its host, until the usage falls below memoryRecoveryRatio of the budget.
+// It prevents the node from being OOM killed, which would end all of its sessions at once.
+type memoryWatchdog struct {
+	Budget    uint64
+	EvictIdle bool
+	Sessions  *sessionRepo
+	Logger    log.FieldLogger
+
+	// usage returns the memory usage of the node. It defaults to processMemory.
+	usage func() (uint64, error)
+
+	evictions metrics.Counter
+	pressured metrics.Gauge
+
+	mu       sync.Mutex
+	pressure bool
+}
+
+func newMemoryWatchdog(budget uint64, evictIdle bool, sessions *sessionRepo, p provider.Provider, logger log.FieldLogger) *memoryWatchdog {
+	return &memoryWatchdog{
+		Budget:    budget,
+		EvictIdle: evictIdle,
+		Sessions:  sessions,
+		Logger:    logger,
+		usage:     processMemory,
+		evictions: p.NewCounter("memory_session_eviction_count"),
+		pressured: p.NewGauge("memory_pressure"),
+	}
+}
+
+// UnderPressure reports whether the memory usage of the node exceeds the budget. It's safe to call on a nil watchdog.
+func (w *memoryWatchdog) UnderPressure() bool {
+	if w == nil {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.pressure
+}
+
+func (w *memoryWatchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Check()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Check measures the memory usage and enters or leaves pressure, evicting a session under pressure if EvictIdle is set.
+func (w *memoryWatchdog) Check() {
+	usage, err := w.usage()
+	if err != nil {
+		w.Logger.WithError(err).Error("error measuring memory usage")
+		return
+	}
+
+	logger := w.Logger.WithFields(log.Fields{"memory-usage": usage, "memory-budget": w.Budget})
+
+	w.mu.Lock()
+	was := w.pressure
+	switch {
+	case usage >= w.Budget:
+		w.pressure = true
+	case float64(usage) < float64(w.Budget)*memoryRecoveryRatio:
+		w.pressure = false
+	}
+	pressure := w.pressure
+	w.mu.Unlock()
+
+	switch {
+	case pressure && !was:
+		logger.WithField("event", "memory-pressure").Warn("refusing new sessions under memory pressure")
+		w.pressured.Set(1)
+	case !pressure && was:
+		logger.WithField("event", "memory-recovered").Info("accepting new sessions after memory pressure")
+		w.pressured.Set(0)
+	}
+
+	if pressure && w.EvictIdle {
+		w.evictIdleSession(logger)
+	}
+}
+
+// evictIdleSession ends the oldest session without connected clients.
+func (w *memoryWatchdog) evictIdleSession(logger log.FieldLogger) {
+	for _, sess := range w.Sessions.List() {
+		if w.Sessions.Clients(sess.ID) > 0 {
+			continue
+		}
+
+		sessLogger := logger.WithFields(log.Fields{"session": sess.ID, "host-user": sess.HostUser, "event": "session-evicted"})
+		if err := sess.End(sessionEvictedReason); err != nil {
+			sessLogger.WithError(err).Error("error evicting idle session")
+			continue
+		}
+		sessLogger.Warn("evicted idle session under memory pressure")
+		w.evictions.Add(1)
+
+		return
+	}
+}
+
+// processMemory returns the resident set size of the process on Linux,
+// or the memory the Go runtime obtained from the OS and hasn't released elsewhere.
+func processMemory() (uint64, error) {
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) > 1 {
+			pages, err := strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				return pages * uint64(os.Getpagesize()), nil
+			}
+		}
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return m.Sys - m.HeapReleased, nil
+}
+
+// ParseByteSize parses a size in bytes with an optional decimal (KB, MB, GB) or binary (KiB, MiB, GiB) unit,
+// e.g. 512MiB or 2GB. Single letter units, e.g. 2G, are binary.
+func ParseByteSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	}
+
+	num, mult := strings.TrimSpace(s), uint64(1)
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, e.g. 512MiB or 2GB", s)
+	}
+
+	return uint64(n * float64(mult)), nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_ParseByteSize(t *testing.T) {
+	for s, want := range map[string]uint64{
+		"1024":    1024,
+		"100B":    100,
+		"512MiB":  512 << 20,
+		"2GB":     2 * 1000 * 1000 * 1000,
+		"1.5G":    3 << 29,
+		" 64 KB ": 64 * 1000,
+	} {
+		got, err := ParseByteSize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != got {
+			t.Fatalf("%s: want=%d got=%d", s, want, got)
+		}
+	}
+
+	for _, invalid := range []string{"", "GB", "-1MB", "0", "2TB", "lots"} {
+		if _, err := ParseByteSize(invalid); err == nil {
+			t.Fatalf("want error for %q", invalid)
+		}
+	}
+}
+
+func Test_memoryWatchdog(t *testing.T) {
+	now := time.Now()
+	repo := newSessionRepo()
+
+	var ended []string
+	for i, id := range []string{"oldest", "busy", "newest"} {
+		id := id
+		if err := repo.Add(session{
+			ID:        id,
+			CreatedAt: now.Add(time.Duration(i) * time.Minute),
+			end: func(reason string) error {
+				if reason != sessionEvictedReason {
+					t.Errorf("want=%s got=%s", sessionEvictedReason, reason)
+				}
+				ended = append(ended, id)
+				repo.Delete(id)
+				return nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disconnected := repo.ClientConnected("busy")
+
+	var usage uint64
+	w := newMemoryWatchdog(1000, true, repo, provider.NewDiscardProvider(), log.New())
+	w.usage = func() (uint64, error) { return usage, nil }
+
+	usage = 500
+	w.Check()
+	if w.UnderPressure() || len(ended) != 0 {
+		t.Fatalf("want no pressure below the budget, evicted %v", ended)
+	}
+
+	// the oldest idle session is evicted on each check under pressure
+	usage = 1000
+	w.Check()
+	if !w.UnderPressure() {
+		t.Fatal("want pressure at the budget")
+	}
+	w.Check()
+	if want, got := []string{"oldest", "newest"}, ended; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("want=%v got=%v", want, got)
+	}
+
+	// sessions with clients are kept
+	w.Check()
+	if want, got := 2, len(ended); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+
+	// pressure is relieved below the recovery ratio of the budget
+	usage = 950
+	w.Check()
+	if !w.UnderPressure() {
+		t.Fatal("want pressure above the recovery ratio")
+	}
+	usage = 899
+	w.Check()
+	if w.UnderPressure() {
+		t.Fatal("want pressure relieved below the recovery ratio")
+	}
+
+	disconnected()
+	disconnected()
+	if want, got := 0, repo.Clients("busy"); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+}
@@ -9,6 +9,11 @@ import (
 )
 
 type metricServer struct {
+	// Topology serves the topology of the node if it's set.
+	Topology func() NodeTopology
+	// Health serves the health of the node if it's set.
+	Health func() NodeHealth
+
 	server *http.Server
 	mux    sync.Mutex
 }
@@ -27,6 +32,12 @@ func (m *metricServer) Shutdown(ctx context.Context) error {
 func (m *metricServer) ListenAndServe(addr string) error {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	if m.Topology != nil {
+		mux.Handle(TopologyPath, topologyHandler(m.Topology))
+	}
+	if m.Health != nil {
+		handleHealth(mux, m.Health)
+	}
 
 	m.mux.Lock()
 	m.server = &http.Server{
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// muxSniffTimeout is how long a connection of the single-port listener waits for the first bytes of the client.
+// SSH clients that wait for the server to speak first are routed to the SSH proxy when it expires.
+const muxSniffTimeout = 2 * time.Second
+
+// muxListener serves SSH and WebSocket on a single port, e.g. :443 for networks that only allow HTTPS.
+// It sniffs the first bytes of accepted connections and hands SSH connections to SSH() and
+// everything else, i.e. HTTP requests to upgrade to WebSocket, to WS().
+type muxListener struct {
+	ln           net.Listener
+	sniffTimeout time.Duration
+	logger       log.FieldLogger
+
+	ssh *muxChildListener
+	ws  *muxChildListener
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newMuxListener(ln net.Listener, logger log.FieldLogger) *muxListener {
+	m := &muxListener{
+		ln:           ln,
+		sniffTimeout: muxSniffTimeout,
+		logger:       logger,
+	}
+	m.ssh = newMuxChildListener(m)
+	m.ws = newMuxChildListener(m)
+
+	return m
+}
+
+// SSH returns the listener of SSH connections.
+func (m *muxListener) SSH() net.Listener {
+	return m.ssh
+}
+
+// WS returns the listener of HTTP and WebSocket connections.
+func (m *muxListener) WS() net.Listener {
+	return m.ws
+}
+
+func (m *muxListener) Addr() net.Addr {
+	return m.ln.Addr()
+}
+
+// Serve accepts connections and dispatches them until the listener is closed.
+func (m *muxListener) Serve() error {
+	defer m.ssh.shutdown()
+	defer m.ws.shutdown()
+
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go m.dispatch(conn)
+	}
+}
+
+// Close closes the underlying listener, which closes SSH() and WS().
+func (m *muxListener) Close() error {
+	m.closeOnce.Do(func() {
+		m.closeErr = m.ln.Close()
+	})
+
+	return m.closeErr
+}
+
+func (m *muxListener) dispatch(conn net.Conn) {
+	prefix, isSSH, err := sniffSSH(conn, m.sniffTimeout)
+	if err != nil {
+		m.logger.WithError(err).WithField("addr", conn.RemoteAddr()).Debug("error sniffing connection")
+		conn.Close()
+		return
+	}
+
+	child := m.ws
+	if isSSH {
+		child = m.ssh
+	}
+
+	child.deliver(&prefixConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(prefix), conn),
+	})
+}
+
+// sniffSSH reads the first bytes of conn and reports whether they start an SSH connection.
+// A connection that sends nothing before timeout is considered SSH, since SSH servers may speak first.
+// The bytes read are returned so that they can be replayed.
+func sniffSSH(conn net.Conn, timeout time.Duration) ([]byte, bool, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, false, err
+	}
+
+	prefix := make([]byte, 0, len(sshIdentPrefix))
+	buf := make([]byte, len(sshIdentPrefix))
+	for len(prefix) < len(sshIdentPrefix) {
+		n, err := conn.Read(buf[:len(sshIdentPrefix)-len(prefix)])
+		prefix = append(prefix, buf[:n]...)
+		if !bytes.HasPrefix(sshIdentPrefix, prefix) {
+			break
+		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, false, err
+	}
+
+	return prefix, bytes.HasPrefix(sshIdentPrefix, prefix), nil
+}
+
+// prefixConn replays the bytes read while sniffing before the rest of the connection.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// NetConn returns the sniffed connection, e.g. to tune its socket options.
+func (c *prefixConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// muxChildListener is a listener of connections dispatched by a muxListener.
+type muxChildListener struct {
+	parent *muxListener
+	conns  chan net.Conn
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newMuxChildListener(parent *muxListener) *muxChildListener {
+	return &muxChildListener{
+		parent: parent,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+}
+
+func (l *muxChildListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *muxChildListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the muxListener, since the listeners share the port.
+func (l *muxChildListener) Close() error {
+	l.shutdown()
+	return l.parent.Close()
+}
+
+func (l *muxChildListener) shutdown() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+func (l *muxChildListener) Addr() net.Addr {
+	return l.parent.Addr()
+}
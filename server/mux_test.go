@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_muxListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMuxListener(ln, log.New())
+	m.sniffTimeout = 100 * time.Millisecond
+
+	served := make(chan error, 1)
+	go func() {
+		served <- m.Serve()
+	}()
+
+	cases := []struct {
+		name    string
+		data    string
+		wantSSH bool
+	}{
+		{
+			name:    "ssh",
+			data:    "SSH-2.0-Go\r\n",
+			wantSSH: true,
+		},
+		{
+			name:    "ssh split ident",
+			data:    "SS",
+			wantSSH: true,
+		},
+		{
+			name:    "silent client",
+			data:    "",
+			wantSSH: true,
+		},
+		{
+			name: "http",
+			data: "GET / HTTP/1.1\r\n",
+		},
+		{
+			name: "tls",
+			data: "\x16\x03\x01",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := io.WriteString(conn, c.data); err != nil {
+				t.Fatal(err)
+			}
+
+			child := m.WS()
+			if c.wantSSH {
+				child = m.SSH()
+			}
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := child.Accept()
+				if err == nil {
+					accepted <- conn
+				}
+			}()
+
+			var got net.Conn
+			select {
+			case got = <-accepted:
+			case <-time.After(3 * time.Second):
+				t.Fatal("connection not dispatched")
+			}
+			defer got.Close()
+
+			if c.data == "" {
+				return
+			}
+
+			// sniffed bytes are replayed
+			b := make([]byte, len(c.data))
+			if _, err := io.ReadFull(got, b); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != c.data {
+				t.Fatalf("want %q, got %q", c.data, b)
+			}
+		})
+	}
+
+	if err := m.SSH().Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err == nil {
+		t.Fatal("want serve to fail after close")
+	}
+	if _, err := m.WS().Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("want closed error, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyTimeout bounds posting a notification to a chat.
+const notifyTimeout = 10 * time.Second
+
+// Chats sessions are notified in.
+const (
+	NotifySlack   = "slack"
+	NotifyDiscord = "discord"
+)
+
+// NewNotifySink returns the sink posting the sessions created and closed on the node to the incoming webhook u of
+// chat, NotifySlack or NotifyDiscord, for the visibility of operators. Other audit events are ignored.
+func NewNotifySink(chat, u string) (AuditSink, error) {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, fmt.Errorf("invalid %s webhook url %q", chat, u)
+	}
+
+	s := &notifySink{
+		url:    pu.String(),
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+	switch chat {
+	case NotifySlack:
+		s.body = func(text string) any { return map[string]string{"text": text} }
+	case NotifyDiscord:
+		s.body = func(text string) any { return map[string]string{"content": text} }
+	default:
+		return nil, fmt.Errorf("unknown chat %q", chat)
+	}
+
+	return s, nil
+}
+
+// newNotifier returns an auditor posting events to the chats of sink in the background.
+func newNotifier(sink AuditSink, nodeAddr string, mp provider.Provider, logger log.FieldLogger) *auditor {
+	a := newAuditor(sink, nodeAddr, provider.NewDiscardProvider(), logger)
+	a.dropped = mp.NewCounter("notify_dropped_count")
+
+	return a
+}
+
+// notifySink posts sessions created and closed as messages to the incoming webhook of a chat.
+type notifySink struct {
+	url    string
+	client *http.Client
+	// body returns the JSON body of a message of text.
+	body func(text string) any
+}
+
+func (s *notifySink) Write(ctx context.Context, ev AuditEvent) error {
+	text := notifyText(ev)
+	if text == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.body(text))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notify webhook responded %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *notifySink) Close() error {
+	return nil
+}
+
+// notifyText returns the message of a session created or closed, or an empty message for other events.
+func notifyText(ev AuditEvent) string {
+	var b strings.Builder
+	switch ev.Type {
+	case AuditSessionCreated:
+		fmt.Fprintf(&b, "Session `%s` created on %s", ev.SessionID, ev.NodeAddr)
+		if ev.HostUser != "" {
+			fmt.Fprintf(&b, " by %s", ev.HostUser)
+		}
+		if ev.Fingerprint != "" {
+			fmt.Fprintf(&b, " with key %s", ev.Fingerprint)
+		}
+	case AuditSessionClosed:
+		fmt.Fprintf(&b, "Session `%s` closed on %s after %s", ev.SessionID, ev.NodeAddr, time.Duration(ev.DurationSeconds*float64(time.Second)).Round(time.Second))
+	default:
+		return ""
+	}
+
+	if len(ev.Labels) > 0 {
+		var labels []string
+		for k, v := range ev.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(&b, " (%s)", strings.Join(labels, ", "))
+	}
+
+	return b.String()
+}
+
+// multiAuditSink writes events to all of its sinks.
+type multiAuditSink []AuditSink
+
+func (m multiAuditSink) Write(ctx context.Context, ev AuditEvent) error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Write(ctx, ev))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m multiAuditSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_NewNotifySink(t *testing.T) {
+	cases := []struct {
+		chat string
+		key  string
+	}{
+		{chat: NotifySlack, key: "text"},
+		{chat: NotifyDiscord, key: "content"},
+	}
+	for _, c := range cases {
+		t.Run(c.chat, func(t *testing.T) {
+			posted := make(chan map[string]string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Error(err)
+				}
+				posted <- body
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			sink, err := NewNotifySink(c.chat, srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// other events aren't posted
+			if err := sink.Write(context.Background(), AuditEvent{Type: AuditAuthRejected}); err != nil {
+				t.Fatal(err)
+			}
+
+			ev := AuditEvent{Type: AuditSessionCreated, SessionID: "session", NodeAddr: "127.0.0.1:22"}
+			if err := sink.Write(context.Background(), ev); err != nil {
+				t.Fatal(err)
+			}
+			body := <-posted
+			if want := notifyText(ev); body[c.key] != want || len(body) != 1 {
+				t.Fatalf("want %s=%q, got %v", c.key, want, body)
+			}
+		})
+	}
+
+	if _, err := NewNotifySink("teams", "https://example.com/hook"); err == nil {
+		t.Fatal("expect error for an unknown chat")
+	}
+	if _, err := NewNotifySink(NotifySlack, "ftp://example.com/hook"); err == nil {
+		t.Fatal("expect error for a webhook url that isn't http")
+	}
+}
+
+func Test_notifyText(t *testing.T) {
+	cases := []struct {
+		name string
+		ev   AuditEvent
+		want string
+	}{
+		{
+			name: "created",
+			ev: AuditEvent{
+				Type:        AuditSessionCreated,
+				SessionID:   "session",
+				NodeAddr:    "127.0.0.1:22",
+				HostUser:    "owen",
+				Fingerprint: "SHA256:abc",
+				Labels:      map[string]string{"team": "infra", "env": "prod"},
+			},
+			want: "Session `session` created on 127.0.0.1:22 by owen with key SHA256:abc (env=prod, team=infra)",
+		},
+		{
+			name: "closed",
+			ev: AuditEvent{
+				Type:            AuditSessionClosed,
+				SessionID:       "session",
+				NodeAddr:        "127.0.0.1:22",
+				DurationSeconds: 90.4,
+			},
+			want: "Session `session` closed on 127.0.0.1:22 after 1m30s",
+		},
+		{
+			name: "other",
+			ev:   AuditEvent{Type: AuditClientAuthenticated, SessionID: "session"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := notifyText(c.ev); got != c.want {
+				t.Fatalf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_sessionLabels(t *testing.T) {
+	if got := sessionLabels(nil, nil); got != nil {
+		t.Fatalf("want nil labels, got %v", got)
+	}
+
+	got := sessionLabels(map[string]string{"env": "dev", "app": "api"}, map[string]string{"env": "prod"})
+	if len(got) != 2 || got["env"] != "prod" || got["app"] != "api" {
+		t.Fatalf("expect labels granted to override the labels of the host, got %v", got)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrPolicyNotAgreed is returned when a host creates a session without agreeing to the server policy.
+var ErrPolicyNotAgreed = errors.New("the server policy is not agreed")
+
+// ErrAuthorizedKeysRequired is returned when a host creates a session without authorized keys
+// on a server requiring them.
+var ErrAuthorizedKeysRequired = errors.New("the server requires sessions to authorize clients with authorized keys")
+
+// PolicyHash returns the hash hosts send to agree to the policy.
+func PolicyHash(policy []byte) string {
+	sum := sha256.Sum256(policy)
+	return hex.EncodeToString(sum[:])
+}
+
+func newGetPolicyResponse(policy []byte) *GetPolicyResponse {
+	if len(policy) == 0 {
+		return &GetPolicyResponse{}
+	}
+
+	return &GetPolicyResponse{
+		Text: string(policy),
+		Hash: PolicyHash(policy),
+	}
+}
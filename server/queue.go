@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+)
+
+var (
+	// ErrServerAtCapacity is returned when a host creates a session on a node hosting its max sessions.
+	ErrServerAtCapacity = errors.New("the server is at capacity, try again later")
+	// ErrSessionQueueFull is returned when a host waits for capacity in a session queue that is full.
+	ErrSessionQueueFull = errors.New("the server is at capacity and its queue is full, try again later")
+)
+
+// sessionQueueCheckInterval is how often the head of session queues checks whether the node has capacity.
+const sessionQueueCheckInterval = time.Second
+
+// sessionQueue lines up hosts waiting for the node to have capacity to create their sessions, e.g. on a shared team
+// server during a busy incident, rather than failing them. Hosts are let in first come, first served, and hosts not
+// waiting in line are refused while others are.
+type sessionQueue struct {
+	// Size caps the hosts waiting at once.
+	Size int
+
+	length metrics.Gauge
+	served metrics.Counter
+
+	mu sync.Mutex
+	// waiters are the tickets of the hosts waiting in line.
+	waiters []uint64
+	next    uint64
+	// changed is closed and replaced when the line moves.
+	changed chan struct{}
+}
+
+func newSessionQueue(size int, p provider.Provider) *sessionQueue {
+	return &sessionQueue{
+		Size:    size,
+		length:  p.NewGauge("session_queue_length"),
+		served:  p.NewCounter("session_queue_served_count"),
+		changed: make(chan struct{}),
+	}
+}
+
+// Len returns the number of hosts waiting. It's safe to call on a nil queue.
+func (q *sessionQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.waiters)
+}
+
+// Wait waits in line until the host is first and full returns nil, or until ctx is done, e.g. when the host hangs
+// up. position is called with the 1-based position of the host and the length of the line when it joins and
+// whenever they change. The host leaves the line by calling the returned func once its session is created, so that
+// the next host doesn't take the same capacity.
+func (q *sessionQueue) Wait(ctx context.Context, full func() error, position func(pos, length int)) (func(), error) {
+	q.mu.Lock()
+	if len(q.waiters) >= q.Size {
+		q.mu.Unlock()
+		return nil, ErrSessionQueueFull
+	}
+	q.next++
+	w := q.next
+	q.waiters = append(q.waiters, w)
+	q.moved()
+	q.mu.Unlock()
+
+	ticker := time.NewTicker(sessionQueueCheckInterval)
+	defer ticker.Stop()
+
+	var lastPos, lastLen int
+	for {
+		q.mu.Lock()
+		pos, length, changed := slices.Index(q.waiters, w)+1, len(q.waiters), q.changed
+		q.mu.Unlock()
+
+		if pos == 1 && full() == nil {
+			q.served.Add(1)
+			return func() { q.leave(w) }, nil
+		}
+		if pos != lastPos || length != lastLen {
+			position(pos, length)
+			lastPos, lastLen = pos, length
+		}
+
+		select {
+		case <-ticker.C:
+		case <-changed:
+		case <-ctx.Done():
+			q.leave(w)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *sessionQueue) leave(w uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := slices.Index(q.waiters, w); i >= 0 {
+		q.waiters = slices.Delete(q.waiters, i, i+1)
+		q.moved()
+	}
+}
+
+// moved tells the waiters that the line moved. It must be called with mu held.
+func (q *sessionQueue) moved() {
+	q.length.Set(float64(len(q.waiters)))
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
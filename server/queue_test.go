@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/provider"
+)
+
+func Test_sessionQueue(t *testing.T) {
+	q := newSessionQueue(2, provider.NewDiscardProvider())
+
+	var capacity atomic.Bool
+	full := func() error {
+		if capacity.Load() {
+			return nil
+		}
+		return ErrServerAtCapacity
+	}
+
+	type result struct {
+		name  string
+		leave func()
+	}
+	let := make(chan result, 2)
+	wait := func(name string, joined chan<- struct{}) {
+		leave, err := q.Wait(context.Background(), full, func(pos, length int) {
+			if joined != nil {
+				close(joined)
+				joined = nil
+			}
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		let <- result{name, leave}
+	}
+
+	first, second := make(chan struct{}), make(chan struct{})
+	go wait("first", first)
+	<-first
+	go wait("second", second)
+	<-second
+
+	if _, err := q.Wait(context.Background(), full, func(pos, length int) {}); !errors.Is(err, ErrSessionQueueFull) {
+		t.Fatalf("want %v, got %v", ErrSessionQueueFull, err)
+	}
+
+	// hosts are let in first come, first served, one at a time until they leave
+	capacity.Store(true)
+	r := <-let
+	if r.name != "first" {
+		t.Fatalf("want the first host let in, got the %s", r.name)
+	}
+	select {
+	case r := <-let:
+		t.Fatalf("want the %s host to wait until the first leaves", r.name)
+	case <-time.After(2 * sessionQueueCheckInterval):
+	}
+	r.leave()
+	if r := <-let; r.name != "second" {
+		t.Fatalf("want the second host let in, got the %s", r.name)
+	}
+}
+
+func Test_sessionQueue_canceled(t *testing.T) {
+	q := newSessionQueue(1, provider.NewDiscardProvider())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.Wait(ctx, func() error { return ErrServerAtCapacity }, func(pos, length int) {}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+	if want, got := 0, q.Len(); want != got {
+		t.Fatalf("want %d hosts in the queue, got %d", want, got)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrHostQuotaExceeded is returned when a host creates more sessions than its quota allows.
+var ErrHostQuotaExceeded = errors.New("the server limits the sessions of your key, end one of them first")
+
+// HostQuota limits the sessions of a host. A zero value disables the corresponding limit.
+type HostQuota struct {
+	// MaxSessions is the max concurrent sessions of the host on the node.
+	MaxSessions int `yaml:"max-sessions"`
+	// MaxClients is the max clients connected to a session of the host at once.
+	MaxClients int `yaml:"max-clients"`
+	// MaxSessionAge ends the sessions of the host after the duration since they're created.
+	MaxSessionAge time.Duration `yaml:"max-session-age"`
+}
+
+func (q HostQuota) validate() error {
+	if q.MaxSessions < 0 || q.MaxClients < 0 || q.MaxSessionAge < 0 {
+		return fmt.Errorf("max-sessions, max-clients, and max-session-age must not be negative")
+	}
+
+	return nil
+}
+
+func (q HostQuota) String() string {
+	return fmt.Sprintf("max-sessions=%d,max-clients=%d,max-session-age=%s", q.MaxSessions, q.MaxClients, q.MaxSessionAge)
+}
+
+// HostQuotas are the quotas of hosts by the SHA256 fingerprints of their keys, which are the keys of the user certs
+// hosts authenticate with. Hosts without a quota of their own are limited by Default.
+type HostQuotas struct {
+	Default HostQuota `yaml:"default"`
+	// Hosts replace Default for the hosts of the fingerprints, e.g. SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8.
+	Hosts map[string]HostQuota `yaml:"hosts"`
+}
+
+// ReadHostQuotas reads the quotas of hosts from a YAML file, e.g.:
+//
+//	default:
+//	  max-sessions: 2
+//	  max-clients: 5
+//	  max-session-age: 8h
+//	hosts:
+//	  SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8:
+//	    max-sessions: 10
+//
+// The quota of a host replaces the default quota, so limits missing from it are unlimited. base is the default
+// quota if the file has none, e.g. from flags.
+func ReadHostQuotas(file string, base HostQuota) (*HostQuotas, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseHostQuotas(b, base)
+}
+
+// ParseHostQuotas parses the quotas of hosts. See ReadHostQuotas for the format.
+func ParseHostQuotas(b []byte, base HostQuota) (*HostQuotas, error) {
+	var doc struct {
+		Default *HostQuota           `yaml:"default"`
+		Hosts   map[string]HostQuota `yaml:"hosts"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	q := &HostQuotas{Default: base, Hosts: doc.Hosts}
+	if doc.Default != nil {
+		q.Default = *doc.Default
+	}
+	if err := q.Default.validate(); err != nil {
+		return nil, fmt.Errorf("invalid default quota: %w", err)
+	}
+	for fp, hq := range q.Hosts {
+		if !strings.HasPrefix(fp, "SHA256:") {
+			return nil, fmt.Errorf("invalid quota of %s: must be keyed on a SHA256 fingerprint", fp)
+		}
+		if err := hq.validate(); err != nil {
+			return nil, fmt.Errorf("invalid quota of %s: %w", fp, err)
+		}
+	}
+
+	return q, nil
+}
+
+// Quota returns the quota of the host with the key fingerprint fp. It's safe to call on nil quotas, which are
+// unlimited.
+func (q *HostQuotas) Quota(fp string) HostQuota {
+	if q == nil {
+		return HostQuota{}
+	}
+	if hq, ok := q.Hosts[fp]; ok {
+		return hq
+	}
+
+	return q.Default
+}
+
+func (q *HostQuotas) String() string {
+	return fmt.Sprintf("default=(%s),hosts=%d", q.Default, len(q.Hosts))
+}
+
+// hostFingerprint returns the SHA256 fingerprint quotas of the host authenticating with key are keyed on, which is
+// of the key of the cert if key is a cert. It's empty if key is nil.
+func hostFingerprint(key ssh.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	return utils.FingerprintSHA256(key)
+}
+
+// minLimit returns the lower of the limits a and b, where zero is unlimited.
+func minLimit[T int | int32 | time.Duration](a, b T) T {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+
+	return a
+}
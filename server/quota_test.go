@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/owenthereal/upterm/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_ParseHostQuotas(t *testing.T) {
+	base := HostQuota{MaxSessions: 3}
+
+	cases := []struct {
+		name    string
+		yaml    string
+		want    *HostQuotas
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			want: &HostQuotas{Default: base},
+		},
+		{
+			name: "default and hosts",
+			yaml: `
+default:
+  max-sessions: 2
+  max-clients: 5
+  max-session-age: 8h
+hosts:
+  SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8:
+    max-sessions: 10
+`,
+			want: &HostQuotas{
+				Default: HostQuota{MaxSessions: 2, MaxClients: 5, MaxSessionAge: 8 * time.Hour},
+				Hosts: map[string]HostQuota{
+					"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8": {MaxSessions: 10},
+				},
+			},
+		},
+		{
+			name: "hosts only",
+			yaml: `
+hosts:
+  SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8:
+    max-clients: 1
+`,
+			want: &HostQuotas{
+				Default: base,
+				Hosts: map[string]HostQuota{
+					"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8": {MaxClients: 1},
+				},
+			},
+		},
+		{
+			name:    "unknown limit",
+			yaml:    "default:\n  max-hosts: 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			yaml:    "default:\n  max-sessions: -1\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a fingerprint",
+			yaml:    "hosts:\n  alice:\n    max-sessions: 1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseHostQuotas([]byte(c.yaml), base)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("want error %t, got %v", c.wantErr, err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func Test_HostQuotas_Quota(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{Key: key, CertType: ssh.UserCert}
+
+	fp := hostFingerprint(cert)
+	if want := utils.FingerprintSHA256(key); fp != want {
+		t.Fatalf("want the fingerprint of the key of the cert %s, got %s", want, fp)
+	}
+
+	q := &HostQuotas{
+		Default: HostQuota{MaxSessions: 1},
+		Hosts:   map[string]HostQuota{fp: {MaxClients: 2}},
+	}
+	if want, got := (HostQuota{MaxClients: 2}), q.Quota(fp); want != got {
+		t.Fatalf("want=%v got=%v", want, got)
+	}
+	if want, got := (HostQuota{MaxSessions: 1}), q.Quota("SHA256:other"); want != got {
+		t.Fatalf("want=%v got=%v", want, got)
+	}
+
+	var unlimited *HostQuotas
+	if got := unlimited.Quota(fp); got != (HostQuota{}) {
+		t.Fatalf("want no quota, got %v", got)
+	}
+}
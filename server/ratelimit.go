@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	joinRateWindow = time.Minute
+)
+
+// JoinLimits limits clients joining sessions. A zero value disables the corresponding limit.
+type JoinLimits struct {
+	// AttemptsPerKey is the max join attempts per minute by a client public key.
+	AttemptsPerKey int
+	// AttemptsPerSession is the max join attempts per minute to a session.
+	AttemptsPerSession int
+	// ConcurrentPerKey is the max concurrent joins by a client public key.
+	ConcurrentPerKey int
+}
+
+func (l JoinLimits) enabled() bool {
+	return l.AttemptsPerKey > 0 || l.AttemptsPerSession > 0 || l.ConcurrentPerKey > 0
+}
+
+func newJoinLimiter(limits JoinLimits) *joinLimiter {
+	return &joinLimiter{
+		limits:          limits,
+		keyAttempts:     make(map[string][]time.Time),
+		sessionAttempts: make(map[string][]time.Time),
+		active:          make(map[string]int),
+	}
+}
+
+// joinLimiter enforces JoinLimits with sliding windows of attempts.
+type joinLimiter struct {
+	limits JoinLimits
+
+	mu              sync.Mutex
+	keyAttempts     map[string][]time.Time
+	sessionAttempts map[string][]time.Time
+	active          map[string]int
+	lastSweep       time.Time
+}
+
+// Attempt records a join attempt by the key fingerprint to the session.
+// It returns false if the attempt exceeds the per-key or the per-session rate.
+// Rejected attempts are counted too, so that a client retrying in a tight loop stays limited.
+func (l *joinLimiter) Attempt(fingerprint, sessionID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > joinRateWindow {
+		sweep(l.keyAttempts, now, joinRateWindow)
+		sweep(l.sessionAttempts, now, joinRateWindow)
+		l.lastSweep = now
+	}
+
+	keyOK := record(l.keyAttempts, fingerprint, now, joinRateWindow, l.limits.AttemptsPerKey)
+	sessionOK := record(l.sessionAttempts, sessionID, now, joinRateWindow, l.limits.AttemptsPerSession)
+
+	return keyOK && sessionOK
+}
+
+// Acquire reserves a concurrent join by the key fingerprint.
+// It returns a func to release the join, or false if the key has reached its concurrent joins.
+func (l *joinLimiter) Acquire(fingerprint string) (func(), bool) {
+	if l.limits.ConcurrentPerKey <= 0 {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[fingerprint] >= l.limits.ConcurrentPerKey {
+		return nil, false
+	}
+	l.active[fingerprint]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.active[fingerprint]--
+			if l.active[fingerprint] <= 0 {
+				delete(l.active, fingerprint)
+			}
+		})
+	}, true
+}
+
+// record appends an attempt to the window of key, dropping attempts older than window.
+// It reports whether the attempts in the window are within max.
+func record(windows map[string][]time.Time, key string, now time.Time, window time.Duration, max int) bool {
+	if max <= 0 {
+		return true
+	}
+
+	cutoff := now.Add(-window)
+	attempts := windows[key]
+	i := 0
+	for i < len(attempts) && !attempts[i].After(cutoff) {
+		i++
+	}
+	attempts = append(attempts[i:], now)
+	windows[key] = attempts
+
+	return len(attempts) <= max
+}
+
+// sweep deletes windows without attempts since window ago.
+func sweep(windows map[string][]time.Time, now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	for key, attempts := range windows {
+		if len(attempts) == 0 || !attempts[len(attempts)-1].After(cutoff) {
+			delete(windows, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_joinLimiter_Attempt(t *testing.T) {
+	l := newJoinLimiter(JoinLimits{AttemptsPerKey: 2, AttemptsPerSession: 3})
+	now := time.Now()
+
+	cases := []struct {
+		fingerprint string
+		session     string
+		at          time.Time
+		want        bool
+	}{
+		{fingerprint: "key1", session: "s1", at: now, want: true},
+		{fingerprint: "key1", session: "s1", at: now.Add(time.Second), want: true},
+		// per key limit
+		{fingerprint: "key1", session: "s1", at: now.Add(2 * time.Second), want: false},
+		// per session limit, counting rejected attempts
+		{fingerprint: "key2", session: "s1", at: now.Add(3 * time.Second), want: false},
+		{fingerprint: "key2", session: "s2", at: now.Add(4 * time.Second), want: true},
+		// the window slides
+		{fingerprint: "key1", session: "s3", at: now.Add(time.Minute + 3*time.Second), want: true},
+	}
+
+	for i, c := range cases {
+		if got := l.Attempt(c.fingerprint, c.session, c.at); c.want != got {
+			t.Fatalf("case %d: want=%t got=%t", i, c.want, got)
+		}
+	}
+}
+
+func Test_joinLimiter_Acquire(t *testing.T) {
+	l := newJoinLimiter(JoinLimits{ConcurrentPerKey: 1})
+
+	release, ok := l.Acquire("key1")
+	if !ok {
+		t.Fatal("first join should be allowed")
+	}
+	if _, ok := l.Acquire("key1"); ok {
+		t.Fatal("second concurrent join should be rejected")
+	}
+	if _, ok := l.Acquire("key2"); !ok {
+		t.Fatal("join by another key should be allowed")
+	}
+
+	// released by the auth context when the connection is closed
+	actx := &authChallengeContext{}
+	actx.Hold(release)
+	actx.Release()
+	actx.Release()
+
+	release, ok = l.Acquire("key1")
+	if !ok {
+		t.Fatal("join should be allowed after release")
+	}
+
+	// holding after the connection is closed releases right away
+	actx.Hold(release)
+	if _, ok := l.Acquire("key1"); !ok {
+		t.Fatal("join should be allowed after the connection is closed")
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	rejectionPrefix = "upterm-rejection: "
+)
+
+type RejectionCode string
+
+const (
+	RejectionInvalidSession   RejectionCode = "invalid-session"
+	RejectionSessionNotFound  RejectionCode = "session-not-found"
+	RejectionKeyNotAuthorized RejectionCode = "key-not-authorized"
+	RejectionQuotaExceeded    RejectionCode = "quota-exceeded"
+	RejectionServerDraining   RejectionCode = "server-draining"
+	RejectionRateLimited      RejectionCode = "rate-limited"
+	RejectionNodeUnavailable  RejectionCode = "node-unavailable"
+	RejectionAccessDenied     RejectionCode = "access-denied"
+)
+
+var rejectionMessages = map[RejectionCode]string{
+	RejectionInvalidSession:   "the session token is malformed",
+	RejectionSessionNotFound:  "the session has expired or does not exist",
+	RejectionKeyNotAuthorized: "your key is not authorized to join this session",
+	RejectionQuotaExceeded:    "the session has reached its limits, try again later",
+	RejectionServerDraining:   "the server is shutting down, try again shortly",
+	RejectionRateLimited:      "too many join attempts, try again later",
+	RejectionNodeUnavailable:  "the server hosting the session is down, ask the host to share the session again",
+	RejectionAccessDenied:     "the server policy denies you joining this session",
+}
+
+// Rejection is a machine-readable reason for rejecting a connection.
+// It is sent to the client via SSH banner or keyboard-interactive instruction,
+// so that upterm-aware clients can render it helpfully.
+type Rejection struct {
+	Code    RejectionCode
+	Message string
+}
+
+func NewRejection(code RejectionCode) *Rejection {
+	return &Rejection{
+		Code:    code,
+		Message: rejectionMessages[code],
+	}
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%s (%s)", r.Message, r.Code)
+}
+
+// String returns the wire format of the rejection, a human-readable line
+// followed by a machine-readable line.
+func (r *Rejection) String() string {
+	return fmt.Sprintf("upterm: %s\r\n%s%s\r\n", r.Message, rejectionPrefix, r.Code)
+}
+
+// ParseRejection parses a rejection from a banner or keyboard-interactive instruction.
+func ParseRejection(msg string) (*Rejection, bool) {
+	var (
+		r       Rejection
+		scanner = bufio.NewScanner(strings.NewReader(msg))
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if code, ok := strings.CutPrefix(line, rejectionPrefix); ok {
+			r.Code = RejectionCode(code)
+		} else if message, ok := strings.CutPrefix(line, "upterm: "); ok {
+			r.Message = message
+		}
+	}
+
+	if r.Code == "" {
+		return nil, false
+	}
+
+	if r.Message == "" {
+		r.Message = rejectionMessages[r.Code]
+	}
+
+	return &r, true
+}
+
+// authChallengeContext tracks the rejection of a connection across auth attempts.
+// It also holds resources reserved for the connection until it is closed.
+type authChallengeContext struct {
+	user string
+
+	mu        sync.Mutex
+	rejection *Rejection
+	delivered bool
+	release   func()
+	closed    bool
+	attempted bool
+	// offeredNode and offeredOther record the connection offering certs signed by nodes and other keys.
+	offeredNode  bool
+	offeredOther bool
+	// filtered records the IP of the connection being denied by the IPFilter.
+	filtered bool
+}
+
+func newAuthChallengeContext(conn ssh.ConnMetadata) (ssh.ChallengeContext, error) {
+	return &authChallengeContext{user: conn.User()}, nil
+}
+
+func (c *authChallengeContext) Meta() interface{} {
+	return nil
+}
+
+func (c *authChallengeContext) ChallengedUsername() string {
+	return c.user
+}
+
+func (c *authChallengeContext) Reject(r *Rejection) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rejection = r
+}
+
+// Attempt records the connection attempting to authenticate.
+func (c *authChallengeContext) Attempt() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempted = true
+}
+
+// Offer records the connection offering a key to authenticate with, which is a cert signed by a node if byNode.
+// Keys are offered before their signatures are verified, e.g. in queries whether they're accepted.
+func (c *authChallengeContext) Offer(byNode bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if byNode {
+		c.offeredNode = true
+	} else {
+		c.offeredOther = true
+	}
+}
+
+// Neighbour reports whether the connection only offered certs signed by nodes. Once the connection is
+// established, it's of a neighbour node, since authenticating verified that it holds the key of the cert.
+func (c *authChallengeContext) Neighbour() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offeredNode && !c.offeredOther
+}
+
+// Filter records the IP of the connection being denied by the IPFilter, which only lets neighbours authenticate.
+func (c *authChallengeContext) Filter() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filtered = true
+}
+
+// Filtered reports whether the IP of the connection is denied by the IPFilter.
+func (c *authChallengeContext) Filtered() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.filtered
+}
+
+// AuthFailed reports whether the connection, which failed to establish, attempted to authenticate and was refused for its credentials or the session it named, rather than for limits or unavailable servers.
+func (c *authChallengeContext) AuthFailed() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.attempted {
+		return false
+	}
+	if c.rejection == nil {
+		return true
+	}
+	switch c.rejection.Code {
+	case RejectionInvalidSession, RejectionSessionNotFound, RejectionKeyNotAuthorized:
+		return true
+	default:
+		return false
+	}
+}
+
+// Pending returns true if there is a rejection that has not been delivered to the client.
+func (c *authChallengeContext) Pending() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rejection != nil && !c.delivered
+}
+
+// Deliver returns the rejection and marks it as delivered.
+func (c *authChallengeContext) Deliver() *Rejection {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rejection == nil || c.delivered {
+		return nil
+	}
+
+	c.delivered = true
+	return c.rejection
+}
+
+// Hold keeps release to be called when the connection is closed.
+// A previously held release is called right away, so is release if the connection is already closed.
+func (c *authChallengeContext) Hold(release func()) {
+	if c == nil {
+		release()
+		return
+	}
+
+	c.mu.Lock()
+	prev := c.release
+	if c.closed {
+		prev, c.release = release, nil
+	} else {
+		c.release = release
+	}
+	c.mu.Unlock()
+
+	if prev != nil {
+		prev()
+	}
+}
+
+// Release calls the held release, if any, when the connection is closed.
+func (c *authChallengeContext) Release() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	release := c.release
+	c.release = nil
+	c.closed = true
+	c.mu.Unlock()
+
+	if release != nil {
+		release()
+	}
+}
+
+// authContext returns the auth challenge context of a connection.
+// It returns nil if ctx is not an auth challenge context. All methods are nil-safe.
+func authContext(ctx ssh.ChallengeContext) *authChallengeContext {
+	c, _ := ctx.(*authChallengeContext)
+	return c
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	uio "github.com/owenthereal/upterm/io"
+)
+
+// Cores relaying the data of connections piped by the proxies.
+const (
+	// RelayCoreGoroutine copies each direction of a relayed connection in a goroutine blocking on reads.
+	RelayCoreGoroutine = "goroutine"
+	// RelayCoreNetpoll waits for socket-backed connections to be readable in a single event loop, so that idle
+	// directions hold neither a goroutine nor a buffer. Other connections, e.g. WebSockets and SSH channels,
+	// are copied in goroutines. It's only supported on Linux.
+	RelayCoreNetpoll = "netpoll"
+)
+
+var RelayCores = []string{RelayCoreGoroutine, RelayCoreNetpoll}
+
+// pipe is a direction of a relayed connection.
+type pipe struct {
+	dst io.Writer
+	src io.Reader
+}
+
+// relayer copies the data of relayed connections.
+type relayer interface {
+	// Relay copies the pipes concurrently until any of them ends, then calls closeAll, which must end the others,
+	// and waits for them. It returns the error of the pipe ending first, which is nil if its source reached io.EOF.
+	Relay(closeAll func(), pipes ...pipe) error
+	Close() error
+}
+
+func newRelayer(core string) (relayer, error) {
+	switch core {
+	case "", RelayCoreGoroutine:
+		return goroutineRelayer{}, nil
+	case RelayCoreNetpoll:
+		return newNetpollRelayer()
+	default:
+		return nil, fmt.Errorf("unsupported relay core %q, must be one of %s", core, strings.Join(RelayCores, ", "))
+	}
+}
+
+// relayState collects the ends of the pipes of a relay.
+type relayState struct {
+	closeOnce sync.Once
+	closeAll  func()
+	// err is the error of the pipe ending first.
+	err  error
+	errc chan error
+}
+
+func newRelayState(closeAll func(), n int) *relayState {
+	return &relayState{
+		closeAll: closeAll,
+		errc:     make(chan error, n),
+	}
+}
+
+// done records the end of a pipe. The first one ends the others.
+func (s *relayState) done(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		s.closeAll()
+	})
+	s.finish()
+}
+
+// finish records the end of a pipe ended by the others.
+func (s *relayState) finish() {
+	s.errc <- nil
+}
+
+func (s *relayState) wait(n int) error {
+	for i := 0; i < n; i++ {
+		<-s.errc
+	}
+
+	return s.err
+}
+
+// goroutineRelayer copies all but the first pipe in new goroutines, and the first in the goroutine calling Relay.
+type goroutineRelayer struct{}
+
+func (goroutineRelayer) Relay(closeAll func(), pipes ...pipe) error {
+	s := newRelayState(closeAll, len(pipes))
+	for _, p := range pipes[1:] {
+		go func(p pipe) {
+			_, err := uio.Copy(p.dst, p.src)
+			s.done(err)
+		}(p)
+	}
+	_, err := uio.Copy(pipes[0].dst, pipes[0].src)
+	s.done(err)
+
+	return s.wait(len(pipes))
+}
+
+func (goroutineRelayer) Close() error {
+	return nil
+}
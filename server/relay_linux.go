@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	uio "github.com/owenthereal/upterm/io"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// netpollWakeID is the id of the eventfd waking the event loop up to exit.
+	netpollWakeID = 0
+	// netpollEvents are the events sources of pipes are polled for. Pipes are re-armed after they are drained.
+	netpollEvents = unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT
+	// netpollBufferSize is the size of the buffers pipes are drained with.
+	netpollBufferSize = 32 * 1024
+)
+
+var netpollBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, netpollBufferSize)
+		return &b
+	},
+}
+
+type polledPipeState int
+
+const (
+	// pipeIdle pipes aren't registered yet.
+	pipeIdle polledPipeState = iota
+	// pipeArmed pipes wait for their source to be readable.
+	pipeArmed
+	// pipeCopying pipes are drained by a goroutine.
+	pipeCopying
+	// pipeStopping pipes are drained by a goroutine while their relay ends.
+	pipeStopping
+	pipeDone
+)
+
+// polledPipe is a pipe whose source is a socket polled by the event loop.
+type polledPipe struct {
+	id    int32
+	rc    syscall.RawConn
+	dst   io.Writer
+	relay *relayState
+
+	mu    sync.Mutex
+	state polledPipeState
+}
+
+// drain copies the source to the destination until the socket has no more data to read. It returns io.EOF
+// once the source ends.
+func (pp *polledPipe) drain() error {
+	bp := netpollBufferPool.Get().(*[]byte)
+	defer netpollBufferPool.Put(bp)
+	buf := *bp
+
+	for {
+		var (
+			n    int
+			rerr error
+		)
+		// the socket is non-blocking, so the read never parks the goroutine
+		if err := pp.rc.Read(func(fd uintptr) bool {
+			n, rerr = unix.Read(int(fd), buf)
+			return true
+		}); err != nil {
+			return err
+		}
+
+		switch {
+		case errors.Is(rerr, unix.EAGAIN):
+			return nil
+		case errors.Is(rerr, unix.EINTR):
+			continue
+		case rerr != nil:
+			return os.NewSyscallError("read", rerr)
+		case n == 0:
+			return io.EOF
+		}
+
+		if _, err := pp.dst.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// netpollRelayer waits for the sockets of pipes to be readable with epoll, and drains a readable socket in a
+// goroutine exiting once there's nothing left to read. Idle pipes thus hold neither a goroutine nor a buffer,
+// unlike goroutineRelayer, whose reads are parked in the poller of the runtime with their stacks and buffers.
+type netpollRelayer struct {
+	epfd     int
+	wakefd   int
+	loopDone chan struct{}
+
+	mu     sync.Mutex
+	seq    int32
+	pipes  map[int32]*polledPipe
+	closed bool
+}
+
+func newNetpollRelayer() (relayer, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	wakefd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: netpollWakeID}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakefd, &ev); err != nil {
+		_ = unix.Close(wakefd)
+		_ = unix.Close(epfd)
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+
+	r := &netpollRelayer{
+		epfd:     epfd,
+		wakefd:   wakefd,
+		loopDone: make(chan struct{}),
+		pipes:    make(map[int32]*polledPipe),
+	}
+	go r.loop()
+
+	return r, nil
+}
+
+func (r *netpollRelayer) Relay(closeAll func(), pipes ...pipe) error {
+	var polled []*polledPipe
+	s := newRelayState(func() {
+		// polled pipes are stopped before the sockets are closed, since epoll silently drops closed sockets
+		for _, pp := range polled {
+			r.stop(pp)
+		}
+		closeAll()
+	}, len(pipes))
+
+	var copied []pipe
+	for _, p := range pipes {
+		if rc, ok := socketRawConn(p.src); ok {
+			polled = append(polled, &polledPipe{rc: rc, dst: p.dst, relay: s})
+		} else {
+			copied = append(copied, p)
+		}
+	}
+
+	for _, pp := range polled {
+		if err := r.register(pp); err != nil {
+			s.done(err)
+		}
+	}
+	for _, p := range copied {
+		go func(p pipe) {
+			_, err := uio.Copy(p.dst, p.src)
+			s.done(err)
+		}(p)
+	}
+
+	return s.wait(len(pipes))
+}
+
+// Close ends the relays of polled pipes.
+func (r *netpollRelayer) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	pipes := make([]*polledPipe, 0, len(r.pipes))
+	for _, pp := range r.pipes {
+		pipes = append(pipes, pp)
+	}
+	r.pipes = make(map[int32]*polledPipe)
+	r.mu.Unlock()
+
+	// pipes being drained fail to be re-armed
+	for _, pp := range pipes {
+		pp.mu.Lock()
+		armed := pp.state == pipeArmed
+		if armed {
+			pp.state = pipeDone
+		}
+		pp.mu.Unlock()
+
+		if armed {
+			pp.relay.done(net.ErrClosed)
+		}
+	}
+
+	var b [8]byte
+	binary.NativeEndian.PutUint64(b[:], 1)
+	_, _ = unix.Write(r.wakefd, b[:])
+	<-r.loopDone
+
+	_ = unix.Close(r.wakefd)
+	return unix.Close(r.epfd)
+}
+
+func (r *netpollRelayer) loop() {
+	defer close(r.loopDone)
+
+	events := make([]unix.EpollEvent, 256)
+	for {
+		n, err := unix.EpollWait(r.epfd, events, -1)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for _, ev := range events[:n] {
+			if ev.Fd == netpollWakeID {
+				return
+			}
+
+			r.mu.Lock()
+			pp := r.pipes[ev.Fd]
+			r.mu.Unlock()
+			if pp != nil {
+				go r.copy(pp)
+			}
+		}
+	}
+}
+
+// copy drains the readable source of pp, and re-arms it unless the pipe ends.
+func (r *netpollRelayer) copy(pp *polledPipe) {
+	pp.mu.Lock()
+	if pp.state != pipeArmed {
+		pp.mu.Unlock()
+		return
+	}
+	pp.state = pipeCopying
+	pp.mu.Unlock()
+
+	err := pp.drain()
+
+	pp.mu.Lock()
+	if err == nil && pp.state == pipeCopying {
+		if err = r.ctl(pp, unix.EPOLL_CTL_MOD); err == nil {
+			pp.state = pipeArmed
+			pp.mu.Unlock()
+			return
+		}
+	}
+	stopped := pp.state == pipeStopping
+	pp.state = pipeDone
+	pp.mu.Unlock()
+
+	r.unregister(pp)
+	if stopped {
+		pp.relay.finish()
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	pp.relay.done(err)
+}
+
+func (r *netpollRelayer) register(pp *polledPipe) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return net.ErrClosed
+	}
+	for {
+		r.seq++
+		if r.seq <= netpollWakeID {
+			r.seq = netpollWakeID + 1
+		}
+		if _, ok := r.pipes[r.seq]; !ok {
+			break
+		}
+	}
+	pp.id = r.seq
+	r.pipes[pp.id] = pp
+	r.mu.Unlock()
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if err := r.ctl(pp, unix.EPOLL_CTL_ADD); err != nil {
+		r.mu.Lock()
+		delete(r.pipes, pp.id)
+		r.mu.Unlock()
+		return err
+	}
+	pp.state = pipeArmed
+
+	return nil
+}
+
+// stop ends pp without ending its relay, which is ending.
+func (r *netpollRelayer) stop(pp *polledPipe) {
+	pp.mu.Lock()
+	switch pp.state {
+	case pipeArmed:
+		pp.state = pipeDone
+		pp.mu.Unlock()
+
+		r.unregister(pp)
+		pp.relay.finish()
+	case pipeCopying:
+		// the goroutine draining it finishes it
+		pp.state = pipeStopping
+		pp.mu.Unlock()
+	default:
+		pp.mu.Unlock()
+	}
+}
+
+func (r *netpollRelayer) unregister(pp *polledPipe) {
+	_ = r.ctl(pp, unix.EPOLL_CTL_DEL)
+
+	r.mu.Lock()
+	delete(r.pipes, pp.id)
+	r.mu.Unlock()
+}
+
+// ctl changes the registration of the socket of pp. The socket is controlled through its raw conn,
+// so that a closed socket whose descriptor is reused isn't changed.
+func (r *netpollRelayer) ctl(pp *polledPipe, op int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return net.ErrClosed
+	}
+
+	var cerr error
+	if err := pp.rc.Control(func(fd uintptr) {
+		ev := unix.EpollEvent{Events: netpollEvents, Fd: pp.id}
+		cerr = unix.EpollCtl(r.epfd, op, int(fd), &ev)
+	}); err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("epoll_ctl", cerr)
+}
+
+// socketRawConn returns the raw conn of src if it's a socket read directly. Conns wrapping sockets may buffer
+// data, so they aren't polled.
+func socketRawConn(src io.Reader) (syscall.RawConn, bool) {
+	var sc syscall.Conn
+	switch c := src.(type) {
+	case *net.TCPConn:
+		sc = c
+	case *net.UnixConn:
+		sc = c
+	default:
+		return nil, false
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	return rc, true
+}
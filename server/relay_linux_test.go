@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_netpollRelayer_Close(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	r, err := newNetpollRelayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, upstream, done := relaySession(t, ln, r)
+	defer client.Close()
+	defer upstream.Close()
+
+	// idle relays are ended when the relayer is closed, e.g. when the node shuts down
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != net.ErrClosed {
+			t.Fatalf("want %s, got %v", net.ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay didn't end")
+	}
+
+	if err := r.Relay(func() {}, pipe{dst: client, src: upstream}); err != net.ErrClosed {
+		t.Fatalf("want %s relaying with a closed relayer, got %v", net.ErrClosed, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "fmt"
+
+// newNetpollRelayer is only supported on Linux, whose epoll polls sockets outside of the runtime.
+func newNetpollRelayer() (relayer, error) {
+	return nil, fmt.Errorf("the %s relay core is only supported on linux", RelayCoreNetpoll)
+}
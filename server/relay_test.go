@@ -0,0 +1,225 @@
+package server
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func testRelayers(t testing.TB) map[string]relayer {
+	relayers := make(map[string]relayer)
+	for _, core := range RelayCores {
+		r, err := newRelayer(core)
+		if err != nil {
+			// netpoll is only supported on linux
+			continue
+		}
+		t.Cleanup(func() {
+			_ = r.Close()
+		})
+		relayers[core] = r
+	}
+
+	return relayers
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t testing.TB, ln net.Listener) (net.Conn, net.Conn) {
+	t.Helper()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, ok := <-accepted
+	if !ok {
+		t.Fatal("error accepting connection")
+	}
+
+	return c1, c2
+}
+
+// relaySession relays the client and the upstream end of two TCP connections, like the proxies relay
+// the connection of a client to the one dialed to the session. It returns the peers of the relayed ends.
+func relaySession(t testing.TB, ln net.Listener, r relayer) (client net.Conn, upstream net.Conn, done chan error) {
+	t.Helper()
+
+	client, a := tcpPair(t, ln)
+	b, upstream := tcpPair(t, ln)
+
+	done = make(chan error, 1)
+	go func() {
+		done <- r.Relay(func() {
+			a.Close()
+			b.Close()
+		}, pipe{dst: b, src: a}, pipe{dst: a, src: b})
+	}()
+
+	return client, upstream, done
+}
+
+func Test_relayer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for core, r := range testRelayers(t) {
+		t.Run(core, func(t *testing.T) {
+			client, upstream, done := relaySession(t, ln, r)
+			defer client.Close()
+			defer upstream.Close()
+
+			for _, c := range []struct {
+				name     string
+				src, dst net.Conn
+			}{
+				{name: "to upstream", src: client, dst: upstream},
+				{name: "to client", src: upstream, dst: client},
+			} {
+				// more than a buffer, after the pipes are idle
+				want := make([]byte, 100*1024)
+				for i := range want {
+					want[i] = byte(i)
+				}
+				go func() {
+					_, _ = c.src.Write(want)
+				}()
+
+				got := make([]byte, len(want))
+				if _, err := io.ReadFull(c.dst, got); err != nil {
+					t.Fatalf("%s: %s", c.name, err)
+				}
+				if string(got) != string(want) {
+					t.Fatalf("%s: relayed data mismatched", c.name)
+				}
+			}
+
+			// the relay ends with either side
+			client.Close()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("want relay ended without error, got %s", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("relay didn't end")
+			}
+			if _, err := upstream.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("want upstream closed, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_newRelayer(t *testing.T) {
+	if _, err := newRelayer("fibers"); err == nil {
+		t.Fatal("expect error for unsupported relay core")
+	}
+
+	r, err := newRelayer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.(goroutineRelayer); !ok {
+		t.Fatalf("want goroutine relay core by default, got %T", r)
+	}
+}
+
+// BenchmarkRelayIdleMemory reports the memory of idle relayed sessions, e.g. of hosts without clients typing.
+func BenchmarkRelayIdleMemory(b *testing.B) {
+	const sessions = 500
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	for core, r := range testRelayers(b) {
+		b.Run(core, func(b *testing.B) {
+			var perSession float64
+			for i := 0; i < b.N; i++ {
+				before := inUseMemory()
+
+				var conns []net.Conn
+				var dones []chan error
+				for j := 0; j < sessions; j++ {
+					client, upstream, done := relaySession(b, ln, r)
+					conns = append(conns, client, upstream)
+					dones = append(dones, done)
+				}
+				// let the relays park
+				time.Sleep(100 * time.Millisecond)
+
+				perSession += float64(inUseMemory()-before) / sessions
+
+				for _, c := range conns {
+					c.Close()
+				}
+				for _, done := range dones {
+					<-done
+				}
+			}
+			b.ReportMetric(perSession/float64(b.N), "bytes/session")
+		})
+	}
+}
+
+// BenchmarkRelayWakeup reports the latency of a keystroke relayed to an idle session and echoed back.
+func BenchmarkRelayWakeup(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	for core, r := range testRelayers(b) {
+		b.Run(core, func(b *testing.B) {
+			client, upstream, done := relaySession(b, ln, r)
+			defer func() {
+				client.Close()
+				upstream.Close()
+				<-done
+			}()
+
+			buf := make([]byte, 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(upstream, buf); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := upstream.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(client, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// inUseMemory is the heap and stack memory in use after a collection.
+func inUseMemory() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse + m.StackInuse
+}
@@ -2,44 +2,168 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/metrics/provider"
+	"github.com/gorilla/websocket"
 	"github.com/oklog/run"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 	"github.com/owenthereal/upterm/ws"
 	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slices"
 )
 
 const (
 	tcpDialTimeout = 1 * time.Second
+	// peerVersionCheckTimeout is how long the versions of peers are queried at startup.
+	peerVersionCheckTimeout = 10 * time.Second
 )
 
 type Opt struct {
-	SSHAddr     string   `mapstructure:"ssh-addr"`
-	WSAddr      string   `mapstructure:"ws-addr"`
+	SSHAddr string `mapstructure:"ssh-addr"`
+	WSAddr  string `mapstructure:"ws-addr"`
+	// MuxAddr serves SSH and WebSocket on a single port, sniffing the protocol of connections.
+	// SSHAddr and WSAddr aren't listened on if it's set.
+	MuxAddr     string   `mapstructure:"mux-addr"`
 	NodeAddr    string   `mapstructure:"node-addr"`
 	PrivateKeys []string `mapstructure:"private-key"`
 	Hostnames   []string `mapstructure:"hostname"`
 	Network     string   `mapstructure:"network"`
 	NetworkOpts []string `mapstructure:"network-opt"`
 	MetricAddr  string   `mapstructure:"metric-addr"`
-	Debug       bool     `mapstructure:"debug"`
+	// HealthAddr serves the health of the node on HealthzPath and ReadyzPath, which the metric server also serves.
+	HealthAddr string `mapstructure:"health-addr"`
+	Debug      bool   `mapstructure:"debug"`
+	// SessionAliases are vanity hostnames of sessions in the form of HOSTNAME=SESSION_ID[@NODE_ADDR].
+	SessionAliases []string `mapstructure:"session-alias"`
+	// JoinAttemptsPerKey, JoinAttemptsPerSession, and JoinConcurrencyPerKey limit clients joining sessions.
+	// Zero disables a limit.
+	JoinAttemptsPerKey     int `mapstructure:"join-attempts-per-key"`
+	JoinAttemptsPerSession int `mapstructure:"join-attempts-per-session"`
+	JoinConcurrencyPerKey  int `mapstructure:"join-concurrency-per-key"`
+	// MaxConnsPerIP limits the connections per minute of an IP to the SSH and the WebSocket proxies. AuthFailureBan
+	// bans IPs failing to authenticate MaxAuthFailuresPerIP times within the duration for the duration.
+	// Zero disables a limit.
+	MaxConnsPerIP        int           `mapstructure:"max-conns-per-ip"`
+	AuthFailureBan       time.Duration `mapstructure:"auth-failure-ban"`
+	MaxAuthFailuresPerIP int           `mapstructure:"max-auth-failures-per-ip"`
+	// AllowCIDRs and DenyCIDRs filter the connections to the SSH and the WebSocket proxies by the IPs of clients.
+	AllowCIDRs []string `mapstructure:"allow-cidr"`
+	DenyCIDRs  []string `mapstructure:"deny-cidr"`
+	// PolicyFile is a document hosts must agree to before creating sessions.
+	PolicyFile string `mapstructure:"policy-file"`
+	// ShadowAddr is the SSH address of a canary proxy that connection handshakes are mirrored to.
+	// ShadowRate is the fraction of connections to mirror.
+	ShadowAddr string  `mapstructure:"shadow-addr"`
+	ShadowRate float64 `mapstructure:"shadow-rate"`
+	// NodeEvictionGrace is how long a neighbour node fails health checks before it's evicted.
+	// Zero disables evicting nodes.
+	NodeEvictionGrace time.Duration `mapstructure:"node-eviction-grace"`
+	// MaxSessionAge ends sessions after the duration since they're created, regardless of the duration
+	// configured by hosts. Zero means unlimited.
+	MaxSessionAge time.Duration `mapstructure:"max-session-age"`
+	// SessionIdleTimeout ends sessions without connected clients for the duration. Zero means unlimited.
+	SessionIdleTimeout time.Duration `mapstructure:"session-idle-timeout"`
+	// SessionResumeGrace keeps the sessions of hosts whose connections drop for the duration, for them to resume.
+	SessionResumeGrace time.Duration `mapstructure:"session-resume-grace"`
+	// WSCoalesceDelay coalesces small writes to WebSocket connections for up to the duration. Zero disables it.
+	WSCoalesceDelay time.Duration `mapstructure:"ws-coalesce-delay"`
+	// WSTLSCert and WSTLSKey, or WSTLSDomains to obtain certificates for with ACME, terminate TLS on the WebSocket
+	// proxy. WSTLSCacheDir caches the certificates obtained. See WSTLS.
+	WSTLSCert     string   `mapstructure:"ws-tls-cert"`
+	WSTLSKey      string   `mapstructure:"ws-tls-key"`
+	WSTLSDomains  []string `mapstructure:"ws-tls-domain"`
+	WSTLSCacheDir string   `mapstructure:"ws-tls-cache-dir"`
+	// RelayCore is the core relaying the data of connections, one of RelayCores. It defaults to RelayCoreGoroutine.
+	RelayCore string `mapstructure:"relay-core"`
+	// TCPOpts tune the TCP connections of hosts and clients, and to neighbour nodes, in the form of KEY=VALUE.
+	// See utils.ParseTCPTuning.
+	TCPOpts []string `mapstructure:"tcp-opt"`
+	// Profile is the name of the curated defaults the options are applied on top of, e.g. hardened.
+	Profile string `mapstructure:"profile"`
+	// StrictCrypto restricts SSH connections to modern key exchanges, AEAD ciphers, and non-SHA-1 signatures.
+	StrictCrypto bool `mapstructure:"strict-crypto"`
+	// RequireAuthorizedKeys refuses to create sessions for hosts that let any client join.
+	RequireAuthorizedKeys bool `mapstructure:"require-authorized-keys"`
+	// HostACLFile grants the sessions of hosts features by their keys. See ReadHostACL for the format.
+	HostACLFile string `mapstructure:"host-acl-file"`
+	// HostMaxSessions, HostMaxClients, and HostMaxSessionAge are the default quota of hosts by their keys, which
+	// HostQuotaFile overrides. See ReadHostQuotas for the format. Zero disables a limit.
+	HostMaxSessions   int           `mapstructure:"host-max-sessions"`
+	HostMaxClients    int           `mapstructure:"host-max-clients"`
+	HostMaxSessionAge time.Duration `mapstructure:"host-max-session-age"`
+	HostQuotaFile     string        `mapstructure:"host-quota-file"`
+	// Peers are the metric server URLs of the other nodes in the cluster.
+	Peers []string `mapstructure:"peer"`
+	// RequireMinVersionPeers refuses to start the node if Peers run older uptermd versions.
+	RequireMinVersionPeers string `mapstructure:"require-min-version-peers"`
+	// IdentityFile and IdentityCommand resolve the display names of clients, which are logged and passed on to hosts.
+	IdentityFile    string `mapstructure:"identity-file"`
+	IdentityCommand string `mapstructure:"identity-command"`
+	// AuthzWebhook and AuthzCommand are the hook deciding whether clients may join sessions, after their keys are
+	// authorized by the hosts. See NewAuthorizer. Clients are only authorized by the hosts if they're empty.
+	AuthzWebhook string `mapstructure:"authz-webhook"`
+	AuthzCommand string `mapstructure:"authz-command"`
+	// AdminAddr serves the admin API listing and ending the sessions of the node over gRPC and JSON.
+	// AdminToken is the bearer token clients of the admin API authenticate with.
+	// LogCaptureDir is where the admin API captures the debug logs of sessions and components to.
+	AdminAddr     string `mapstructure:"admin-addr"`
+	AdminToken    string `mapstructure:"admin-token"`
+	LogCaptureDir string `mapstructure:"log-capture-dir"`
+	// MemoryBudget is the memory usage of the node, e.g. 2GiB, above which it refuses to create sessions.
+	// MemoryEvictIdle also evicts the oldest sessions without clients above the budget. It's unlimited if empty.
+	MemoryBudget    string `mapstructure:"memory-budget"`
+	MemoryEvictIdle bool   `mapstructure:"memory-evict-idle"`
+	// MaxSessions caps the sessions of the node. Hosts beyond it are refused, or wait in a queue of SessionQueueSize
+	// hosts if they ask to. It's unlimited if zero.
+	MaxSessions      int `mapstructure:"max-sessions"`
+	SessionQueueSize int `mapstructure:"session-queue-size"`
+	// SSHDPrivateKeys are the host keys of the internal sshd hosts create sessions on, separate from PrivateKeys
+	// of the ssh proxy facing hosts and clients. They default to PrivateKeys.
+	SSHDPrivateKeys []string `mapstructure:"sshd-private-key"`
+	// UserCAKeys sign the user certs the ssh proxy authenticates with to the sshd, neighbour nodes, and hosts.
+	// The first key signs and all are trusted, so that the CA can be rotated. Certs are signed by PrivateKeys if it's empty.
+	UserCAKeys []string `mapstructure:"user-ca-key"`
+	// OTelEndpoint is the OTLP/HTTP endpoint spans of joins and sessions are exported to, e.g. http://localhost:4318.
+	// Tracing is disabled if it's empty.
+	OTelEndpoint string `mapstructure:"otel-endpoint"`
+	// AuditLog is where audit events of sessions and joins are written as JSON: stdout, a file, or an http(s) webhook.
+	// Auditing is disabled if it's empty.
+	AuditLog string `mapstructure:"audit-log"`
+	// AuditLogMaxSize, e.g. 100MiB, and AuditLogMaxAge bound an audit log file and its rotated logs. They're unlimited
+	// if empty and 0.
+	AuditLogMaxSize string        `mapstructure:"audit-log-max-size"`
+	AuditLogMaxAge  time.Duration `mapstructure:"audit-log-max-age"`
+	// NotifySlackWebhook and NotifyDiscordWebhook are the incoming webhooks of chats sessions created and closed on
+	// the node are posted to, e.g. with UPTERMD_NOTIFY_SLACK_WEBHOOK. Notifying is disabled if they're empty.
+	NotifySlackWebhook   string `mapstructure:"notify-slack-webhook"`
+	NotifyDiscordWebhook string `mapstructure:"notify-discord-webhook"`
+	// TelnetAddr serves the telnet-over-TLS bridge letting viewers watch the sessions of hosts that allow it, with the
+	// certificate and the key of TelnetTLSCert and TelnetTLSKey. The bridge is disabled if it's empty.
+	TelnetAddr    string `mapstructure:"telnet-addr"`
+	TelnetTLSCert string `mapstructure:"telnet-tls-cert"`
+	TelnetTLSKey  string `mapstructure:"telnet-tls-key"`
 }
 
 func Start(opt Opt) error {
 	// must always have a ssh addr
-	if opt.SSHAddr == "" {
+	if opt.SSHAddr == "" && opt.MuxAddr == "" {
 		return fmt.Errorf("must specify a ssh address")
 	}
 
@@ -67,18 +191,27 @@ func Start(opt Opt) error {
 		return err
 	}
 
-	// key signers + corresponding cert signers
-	hostSigners := slices.Clone(signers)
-	for _, s := range signers {
-		hs := HostCertSigner{
-			Hostnames: opt.Hostnames,
-		}
-		ss, err := hs.SignCert(s)
+	hostSigners, err := withHostCerts(signers, opt.Hostnames)
+	if err != nil {
+		return err
+	}
+
+	var sshdHostSigners []ssh.Signer
+	if len(opt.SSHDPrivateKeys) > 0 {
+		sshdSigners, err := readSigners(opt.SSHDPrivateKeys)
 		if err != nil {
+			return fmt.Errorf("error reading sshd private keys: %w", err)
+		}
+		if sshdHostSigners, err = withHostCerts(sshdSigners, opt.Hostnames); err != nil {
 			return err
 		}
+	}
 
-		hostSigners = append(hostSigners, ss)
+	var userCASigners []ssh.Signer
+	if len(opt.UserCAKeys) > 0 {
+		if userCASigners, err = readSigners(opt.UserCAKeys); err != nil {
+			return fmt.Errorf("error reading user CA keys: %w", err)
+		}
 	}
 
 	l := log.New()
@@ -88,42 +221,324 @@ func Start(opt Opt) error {
 
 	logger := l.WithFields(log.Fields{"app": "uptermd", "network": opt.Network, "network-opt": opt.NetworkOpts})
 
+	// refuse to join a cluster of incompatible nodes before serving any connection
+	if opt.RequireMinVersionPeers != "" {
+		if len(opt.Peers) == 0 {
+			return fmt.Errorf("--require-min-version-peers requires --peer")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), peerVersionCheckTimeout)
+		defer cancel()
+		if err := CheckPeerVersions(ctx, http.DefaultClient, opt.Peers, opt.RequireMinVersionPeers, logger.WithField("com", "version-check")); err != nil {
+			return fmt.Errorf("refusing to join the cluster: %w", err)
+		}
+		logger.WithField("min-version", opt.RequireMinVersionPeers).Info("peers passed version check")
+	}
+
 	var (
 		sshln net.Listener
 		wsln  net.Listener
+		muxln *muxListener
 	)
 
-	if opt.SSHAddr != "" {
-		sshln, err = net.Listen("tcp", opt.SSHAddr)
+	if opt.MuxAddr != "" {
+		ln, err := listen(opt.MuxAddr)
+		if err != nil {
+			return err
+		}
+		logger = logger.WithField("mux-addr", ln.Addr())
+
+		muxln = newMuxListener(ln, logger.WithField("com", "mux"))
+		sshln, wsln = muxln.SSH(), muxln.WS()
+	}
+
+	if opt.SSHAddr != "" && muxln == nil {
+		sshln, err = listen(opt.SSHAddr)
 		if err != nil {
 			return err
 		}
 		logger = logger.WithField("ssh-addr", sshln.Addr())
 	}
 
-	if opt.WSAddr != "" {
-		wsln, err = net.Listen("tcp", opt.WSAddr)
+	if opt.WSAddr != "" && muxln == nil {
+		wsln, err = listen(opt.WSAddr)
 		if err != nil {
 			return err
 		}
 		logger = logger.WithField("ws-addr", wsln.Addr())
 	}
 
+	var wsTLSConfig *tls.Config
+	wsTLS := WSTLS{
+		CertFile: opt.WSTLSCert,
+		KeyFile:  opt.WSTLSKey,
+		Domains:  opt.WSTLSDomains,
+		CacheDir: opt.WSTLSCacheDir,
+	}
+	if wsTLS.enabled() {
+		if wsln == nil {
+			return fmt.Errorf("--ws-tls-cert, --ws-tls-key, and --ws-tls-domain require --ws-addr or --mux-addr")
+		}
+
+		wsTLSConfig, err = wsTLS.Config()
+		if err != nil {
+			return err
+		}
+		if len(wsTLS.Domains) > 0 {
+			logger = logger.WithField("ws-tls-domain", wsTLS.Domains)
+		} else {
+			logger = logger.WithField("ws-tls-cert", wsTLS.CertFile)
+		}
+	}
+
 	// fallback node addr to ssh addr or ws addr if empty
 	nodeAddr := opt.NodeAddr
-	if nodeAddr == "" && sshln != nil {
-		nodeAddr = sshln.Addr().String()
+	if nodeAddr == "" {
+		nodeAddr = nodeAddrOf(sshln)
 	}
-	if nodeAddr == "" && wsln != nil {
-		nodeAddr = wsln.Addr().String()
+	if nodeAddr == "" {
+		nodeAddr = nodeAddrOf(wsln)
 	}
 	if nodeAddr == "" {
-		return fmt.Errorf("node address can't by empty")
+		return fmt.Errorf("node address can't by empty, set --node-addr when listening on unix sockets")
 	}
 
 	logger = logger.WithField("node-addr", nodeAddr)
 
-	var g run.Group
+	aliases, err := ParseSessionAliases(opt.SessionAliases, nodeAddr)
+	if err != nil {
+		return err
+	}
+
+	var policy []byte
+	if opt.PolicyFile != "" {
+		policy, err = os.ReadFile(opt.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("error reading policy file: %w", err)
+		}
+		logger = logger.WithField("policy-hash", PolicyHash(policy))
+	}
+
+	if opt.MaxSessionAge < 0 {
+		return fmt.Errorf("max session age must be positive, got %s", opt.MaxSessionAge)
+	}
+	if opt.MaxSessionAge > 0 {
+		logger = logger.WithField("max-session-age", opt.MaxSessionAge)
+	}
+	if opt.SessionIdleTimeout < 0 {
+		return fmt.Errorf("session idle timeout must be positive, got %s", opt.SessionIdleTimeout)
+	}
+	if opt.SessionIdleTimeout > 0 {
+		logger = logger.WithField("session-idle-timeout", opt.SessionIdleTimeout)
+	}
+	if opt.SessionResumeGrace < 0 {
+		return fmt.Errorf("session resume grace must not be negative, got %s", opt.SessionResumeGrace)
+	}
+	logger = logger.WithField("session-resume-grace", opt.SessionResumeGrace)
+	if opt.WSCoalesceDelay < 0 {
+		return fmt.Errorf("ws coalesce delay must not be negative, got %s", opt.WSCoalesceDelay)
+	}
+	if opt.RelayCore != "" && !slices.Contains(RelayCores, opt.RelayCore) {
+		return fmt.Errorf("unsupported relay core %q, must be one of %s", opt.RelayCore, strings.Join(RelayCores, ", "))
+	}
+	tcpTuning, err := utils.ParseTCPTuning(opt.TCPOpts)
+	if err != nil {
+		return err
+	}
+	logger = logger.WithField("tcp-tuning", tcpTuning)
+
+	if opt.MaxConnsPerIP < 0 || opt.AuthFailureBan < 0 || opt.MaxAuthFailuresPerIP < 0 {
+		return fmt.Errorf("--max-conns-per-ip, --auth-failure-ban, and --max-auth-failures-per-ip must not be negative")
+	}
+	ipFilter, err := ParseIPFilter(opt.AllowCIDRs, opt.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+
+	hostQuota := HostQuota{
+		MaxSessions:   opt.HostMaxSessions,
+		MaxClients:    opt.HostMaxClients,
+		MaxSessionAge: opt.HostMaxSessionAge,
+	}
+	var hostQuotas *HostQuotas
+	if opt.HostQuotaFile != "" {
+		if hostQuotas, err = ReadHostQuotas(opt.HostQuotaFile, hostQuota); err != nil {
+			return fmt.Errorf("error reading host quota file: %w", err)
+		}
+	} else if hostQuota != (HostQuota{}) {
+		if err := hostQuota.validate(); err != nil {
+			return fmt.Errorf("--host-max-sessions, --host-max-clients, and --host-max-session-age must not be negative")
+		}
+		hostQuotas = &HostQuotas{Default: hostQuota}
+	}
+
+	policyLogger := logger.WithFields(log.Fields{
+		"strict-crypto":            opt.StrictCrypto,
+		"require-authorized-keys":  opt.RequireAuthorizedKeys,
+		"max-session-age":          opt.MaxSessionAge,
+		"session-idle-timeout":     opt.SessionIdleTimeout,
+		"max-conns-per-ip":         opt.MaxConnsPerIP,
+		"auth-failure-ban":         opt.AuthFailureBan,
+		"max-auth-failures-per-ip": opt.MaxAuthFailuresPerIP,
+	})
+	if ipFilter.enabled() {
+		policyLogger = policyLogger.WithField("ip-filter", ipFilter)
+	}
+	if hostQuotas != nil {
+		policyLogger = policyLogger.WithField("host-quotas", hostQuotas)
+	}
+	if opt.Profile != "" {
+		policyLogger = policyLogger.WithField("profile", opt.Profile)
+	}
+	policyLogger.Info("effective security policy")
+
+	identities, err := identity.Load(opt.IdentityFile, opt.IdentityCommand)
+	if err != nil {
+		return err
+	}
+
+	authorizer, err := NewAuthorizer(opt.AuthzWebhook, opt.AuthzCommand)
+	if err != nil {
+		return err
+	}
+	if authorizer != nil {
+		logger = logger.WithField("authz-hook", true)
+	}
+
+	var hostACL *HostACL
+	if opt.HostACLFile != "" {
+		if hostACL, err = ReadHostACL(opt.HostACLFile); err != nil {
+			return fmt.Errorf("error reading host acl file: %w", err)
+		}
+	}
+
+	var memoryBudget uint64
+	if opt.MemoryBudget != "" {
+		if memoryBudget, err = ParseByteSize(opt.MemoryBudget); err != nil {
+			return fmt.Errorf("error parsing memory budget: %w", err)
+		}
+		logger = logger.WithFields(log.Fields{"memory-budget": memoryBudget, "memory-evict-idle": opt.MemoryEvictIdle})
+	} else if opt.MemoryEvictIdle {
+		return fmt.Errorf("--memory-evict-idle requires --memory-budget")
+	}
+
+	if opt.MaxSessions < 0 || opt.SessionQueueSize < 0 {
+		return fmt.Errorf("--max-sessions and --session-queue-size must not be negative")
+	}
+	if opt.MaxSessions > 0 || opt.SessionQueueSize > 0 {
+		logger = logger.WithFields(log.Fields{"max-sessions": opt.MaxSessions, "session-queue-size": opt.SessionQueueSize})
+	}
+
+	var adminln net.Listener
+	if opt.AdminAddr != "" {
+		if opt.AdminToken == "" {
+			return fmt.Errorf("--admin-addr requires --admin-token")
+		}
+
+		adminln, err = listen(opt.AdminAddr)
+		if err != nil {
+			return err
+		}
+		if opt.LogCaptureDir == "" {
+			opt.LogCaptureDir = filepath.Join(os.TempDir(), "uptermd-log-captures")
+		}
+		logger = logger.WithFields(log.Fields{"admin-addr": adminln.Addr(), "log-capture-dir": opt.LogCaptureDir})
+	}
+
+	var telnetln net.Listener
+	if opt.TelnetAddr != "" {
+		if sshln == nil {
+			return fmt.Errorf("--telnet-addr requires a ssh address to join sessions through")
+		}
+		if opt.TelnetTLSCert == "" || opt.TelnetTLSKey == "" {
+			return fmt.Errorf("--telnet-addr requires --telnet-tls-cert and --telnet-tls-key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opt.TelnetTLSCert, opt.TelnetTLSKey)
+		if err != nil {
+			return fmt.Errorf("error reading telnet tls certificate: %w", err)
+		}
+		telnetln, err = tls.Listen("tcp", opt.TelnetAddr, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			return err
+		}
+		logger = logger.WithField("telnet-addr", telnetln.Addr())
+		logger.Warn("the telnet bridge decrypts the sessions it relays on this node, viewers aren't end-to-end encrypted with hosts")
+	}
+
+	if opt.ShadowAddr != "" {
+		if opt.ShadowRate < 0 || opt.ShadowRate > 1 {
+			return fmt.Errorf("shadow rate must be between 0 and 1, got %v", opt.ShadowRate)
+		}
+		logger = logger.WithField("shadow-addr", opt.ShadowAddr)
+	}
+
+	var tp *sdktrace.TracerProvider
+	if opt.OTelEndpoint != "" {
+		if tp, err = newTracerProvider(context.Background(), opt.OTelEndpoint, nodeAddr); err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), serverShutDownDeadline)
+			defer cancel()
+
+			if err := tp.Shutdown(ctx); err != nil {
+				logger.WithError(err).Error("error flushing spans")
+			}
+		}()
+		logger = logger.WithField("otel-endpoint", opt.OTelEndpoint)
+	}
+
+	auditRetention := AuditRetention{MaxAge: opt.AuditLogMaxAge}
+	if opt.AuditLogMaxSize != "" {
+		size, err := ParseByteSize(opt.AuditLogMaxSize)
+		if err != nil {
+			return fmt.Errorf("error parsing audit log max size: %w", err)
+		}
+		auditRetention.MaxSize = int64(size)
+	}
+	if auditRetention.MaxAge < 0 {
+		return fmt.Errorf("--audit-log-max-age must not be negative")
+	}
+
+	var auditSink AuditSink
+	if opt.AuditLog != "" {
+		if auditSink, err = NewAuditSink(opt.AuditLog, auditRetention); err != nil {
+			return err
+		}
+		defer auditSink.Close()
+		logger = logger.WithField("audit-log", opt.AuditLog)
+		if auditRetention.enabled() {
+			logger = logger.WithFields(log.Fields{"audit-log-max-size": auditRetention.MaxSize, "audit-log-max-age": auditRetention.MaxAge})
+		}
+	} else if auditRetention.enabled() {
+		return fmt.Errorf("--audit-log-max-size and --audit-log-max-age require --audit-log")
+	}
+
+	var notifySinks multiAuditSink
+	for _, n := range []struct{ chat, url string }{
+		{NotifySlack, opt.NotifySlackWebhook},
+		{NotifyDiscord, opt.NotifyDiscordWebhook},
+	} {
+		if n.url == "" {
+			continue
+		}
+
+		sink, err := NewNotifySink(n.chat, n.url)
+		if err != nil {
+			return err
+		}
+		notifySinks = append(notifySinks, sink)
+		logger = logger.WithField("notify-"+n.chat, true)
+	}
+
+	var (
+		g run.Group
+		s *Server
+	)
 	{
 		var mp provider.Provider
 		if opt.MetricAddr == "" {
@@ -132,13 +547,57 @@ func Start(opt Opt) error {
 			mp = provider.NewPrometheusProvider("upterm", "uptermd")
 		}
 
-		s := &Server{
-			NodeAddr:        nodeAddr,
-			HostSigners:     hostSigners,
-			Signers:         signers,
-			NetworkProvider: network,
-			Logger:          logger.WithField("com", "server"),
-			MetricsProvider: mp,
+		s = &Server{
+			NodeAddr:              nodeAddr,
+			HostSigners:           hostSigners,
+			Signers:               signers,
+			SSHDHostSigners:       sshdHostSigners,
+			UserCASigners:         userCASigners,
+			NetworkProvider:       network,
+			Logger:                logger.WithField("com", "server"),
+			MetricsProvider:       mp,
+			SessionAliases:        aliases,
+			Policy:                policy,
+			ShadowAddr:            opt.ShadowAddr,
+			ShadowRate:            opt.ShadowRate,
+			NodeEvictionGrace:     opt.NodeEvictionGrace,
+			MaxSessionAge:         opt.MaxSessionAge,
+			SessionIdleTimeout:    opt.SessionIdleTimeout,
+			SessionResumeGrace:    opt.SessionResumeGrace,
+			WSCoalesceDelay:       opt.WSCoalesceDelay,
+			WSTLSConfig:           wsTLSConfig,
+			RelayCore:             opt.RelayCore,
+			TCPTuning:             &tcpTuning,
+			StrictCrypto:          opt.StrictCrypto,
+			RequireAuthorizedKeys: opt.RequireAuthorizedKeys,
+			HostACL:               hostACL,
+			HostQuotas:            hostQuotas,
+			Identities:            identities,
+			Authorizer:            authorizer,
+			MemoryBudget:          memoryBudget,
+			MemoryEvictIdle:       opt.MemoryEvictIdle,
+			MaxSessions:           opt.MaxSessions,
+			SessionQueueSize:      opt.SessionQueueSize,
+			JoinLimits: JoinLimits{
+				AttemptsPerKey:     opt.JoinAttemptsPerKey,
+				AttemptsPerSession: opt.JoinAttemptsPerSession,
+				ConcurrentPerKey:   opt.JoinConcurrencyPerKey,
+			},
+			ConnLimits: ConnLimits{
+				MaxConnsPerIP:        opt.MaxConnsPerIP,
+				AuthFailureBan:       opt.AuthFailureBan,
+				MaxAuthFailuresPerIP: opt.MaxAuthFailuresPerIP,
+			},
+			IPFilter: ipFilter,
+		}
+		if tp != nil {
+			s.TracerProvider = tp
+		}
+		if auditSink != nil {
+			s.AuditSink = auditSink
+		}
+		if len(notifySinks) > 0 {
+			s.NotifySink = notifySinks
 		}
 		g.Add(func() error {
 			return s.ServeWithContext(context.Background(), sshln, wsln)
@@ -146,11 +605,25 @@ func Start(opt Opt) error {
 			s.Shutdown()
 		})
 	}
+	{
+		if muxln != nil {
+			g.Add(func() error {
+				return muxln.Serve()
+			}, func(err error) {
+				_ = muxln.Close()
+			})
+		}
+	}
 	{
 		if opt.MetricAddr != "" {
 			logger = logger.WithField("metric-addr", opt.MetricAddr)
 
-			m := &metricServer{}
+			m := &metricServer{
+				Topology: func() NodeTopology {
+					return s.Topology(time.Now())
+				},
+				Health: s.Health,
+			}
 			g.Add(func() error {
 				return m.ListenAndServe(opt.MetricAddr)
 			}, func(err error) {
@@ -159,6 +632,52 @@ func Start(opt Opt) error {
 		}
 	}
 
+	{
+		if opt.HealthAddr != "" {
+			logger = logger.WithField("health-addr", opt.HealthAddr)
+
+			h := &healthServer{Health: s.Health}
+			g.Add(func() error {
+				return h.ListenAndServe(opt.HealthAddr)
+			}, func(err error) {
+				_ = h.Shutdown(context.Background())
+			})
+		}
+	}
+
+	{
+		if adminln != nil {
+			a := &adminServer{
+				NodeAddr: nodeAddr,
+				Sessions: s.sessions,
+				Logs:     newLogCapture(l, opt.LogCaptureDir),
+				Token:    opt.AdminToken,
+				Logger:   logger.WithField("com", "admin"),
+			}
+			g.Add(func() error {
+				return a.Serve(adminln)
+			}, func(err error) {
+				_ = a.Shutdown(context.Background())
+			})
+		}
+	}
+
+	{
+		if telnetln != nil {
+			var hostKeys []ssh.PublicKey
+			for _, s := range hostSigners {
+				hostKeys = append(hostKeys, s.PublicKey())
+			}
+
+			b := newTelnetBridge(sshln.Addr(), hostKeys, s.MetricsProvider, logger.WithField("com", "telnet"))
+			g.Add(func() error {
+				return b.Serve(telnetln)
+			}, func(err error) {
+				_ = b.Shutdown()
+			})
+		}
+	}
+
 	logger.Info("starting server")
 	defer logger.Info("shutting down server")
 
@@ -176,15 +695,84 @@ func parseNetworkOpt(opts []string) NetworkOptions {
 }
 
 type Server struct {
-	NodeAddr        string
-	HostSigners     []ssh.Signer
-	Signers         []ssh.Signer
+	NodeAddr    string
+	HostSigners []ssh.Signer
+	Signers     []ssh.Signer
+	// SSHDHostSigners are the host keys of the internal sshd. They default to HostSigners.
+	SSHDHostSigners []ssh.Signer
+	// UserCASigners sign the user certs the ssh proxy authenticates upstream with. The first one signs and
+	// all are trusted. Certs are signed by Signers, and certs signed by any key are trusted by the proxy, if it's empty.
+	UserCASigners   []ssh.Signer
 	NetworkProvider NetworkProvider
 	MetricsProvider provider.Provider
 	Logger          log.FieldLogger
+	SessionAliases  SessionAliases
+	JoinLimits      JoinLimits
+	// ConnLimits limit the connections of IPs to the SSH and the WebSocket proxies.
+	ConnLimits ConnLimits
+	// IPFilter allows or denies the connections of IPs to the SSH and the WebSocket proxies.
+	IPFilter IPFilter
+	// Policy is a document hosts must agree to before creating sessions.
+	Policy []byte
+	// ShadowAddr and ShadowRate configure mirroring connection handshakes to a canary proxy.
+	ShadowAddr string
+	ShadowRate float64
+	// NodeEvictionGrace is how long a neighbour node fails health checks before it's evicted.
+	// Zero disables evicting nodes.
+	NodeEvictionGrace time.Duration
+	// MaxSessionAge ends sessions after the duration since they're created. Zero means unlimited.
+	MaxSessionAge time.Duration
+	// SessionIdleTimeout ends sessions without connected clients for the duration. Zero means unlimited.
+	SessionIdleTimeout time.Duration
+	// SessionResumeGrace keeps the sessions of hosts whose connections drop for the duration, for them to resume.
+	SessionResumeGrace time.Duration
+	// WSCoalesceDelay coalesces small writes to WebSocket connections for up to the duration, sending fewer frames.
+	// Zero disables it.
+	WSCoalesceDelay time.Duration
+	// WSTLSConfig terminates TLS on the WebSocket proxy if it's non-nil. Neighbour nodes are dialed over TLS too.
+	WSTLSConfig *tls.Config
+	// RelayCore is the core relaying the data of connections piped by the WebSocket proxy and the sshd,
+	// one of RelayCores. It defaults to RelayCoreGoroutine.
+	RelayCore string
+	// TCPTuning sets the socket options of the TCP connections accepted by the node and dialed to neighbour nodes
+	// if it's non-nil.
+	TCPTuning *utils.TCPTuning
+	// StrictCrypto restricts SSH connections to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// RequireAuthorizedKeys refuses to create sessions without client authorized keys.
+	RequireAuthorizedKeys bool
+	// HostACL grants the sessions of hosts features, and refuses hosts it doesn't match, if it's non-nil.
+	HostACL *HostACL
+	// HostQuotas limit the sessions of hosts by their keys if it's non-nil.
+	HostQuotas *HostQuotas
+	// Identities resolves the display names of clients connecting to the node if it's non-nil.
+	Identities identity.Resolver
+	// Authorizer decides whether clients may join the sessions of the node if it's non-nil.
+	Authorizer Authorizer
+	// MemoryBudget is the memory usage in bytes above which the node refuses to create sessions,
+	// and evicts idle sessions if MemoryEvictIdle is set. Zero means unlimited.
+	MemoryBudget    uint64
+	MemoryEvictIdle bool
+	// MaxSessions caps the sessions of the node if it's positive.
+	MaxSessions int
+	// SessionQueueSize lets up to the hosts asking for it wait for capacity instead of being refused when the node
+	// is at capacity, e.g. at MaxSessions or under memory pressure, if it's positive.
+	SessionQueueSize int
+	// TracerProvider records spans of joins and sessions if it's non-nil.
+	TracerProvider trace.TracerProvider
+	// AuditSink records sessions created and closed, and clients authenticated and rejected, if it's non-nil.
+	AuditSink AuditSink
+	// NotifySink posts sessions created and closed to chats, e.g. Slack, if it's non-nil.
+	NotifySink AuditSink
 
-	sshln net.Listener
-	wsln  net.Listener
+	sshln    net.Listener
+	wsln     net.Listener
+	sessRepo *sessionRepo
+	routes   *routeRecorder
+	memory   *memoryWatchdog
+	queue    *sessionQueue
+	janitor  *nodeJanitor
+	routing  string
 
 	mux    sync.Mutex
 	ctx    context.Context
@@ -208,7 +796,68 @@ func (s *Server) Shutdown() {
 	}
 }
 
+// sessions returns the sessions of the node, or nil before it serves.
+func (s *Server) sessions() *sessionRepo {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.sessRepo
+}
+
+// Topology returns the node's view of the cluster: its sessions and the routes to neighbour nodes.
+func (s *Server) Topology(now time.Time) NodeTopology {
+	s.mux.Lock()
+	sessRepo, routes := s.sessRepo, s.routes
+	s.mux.Unlock()
+
+	t := NodeTopology{NodeAddr: s.NodeAddr, Version: upterm.Version}
+	if sessRepo != nil {
+		t.Sessions = sessRepo.Count()
+	}
+	if routes != nil {
+		t.Routes = routes.Routes(now)
+	}
+
+	return t
+}
+
+// Health returns the health of the node. The node is ready once it serves, until it shuts down.
+func (s *Server) Health() NodeHealth {
+	s.mux.Lock()
+	ctx, sessRepo, memory, queue, janitor, routing := s.ctx, s.sessRepo, s.memory, s.queue, s.janitor, s.routing
+	s.mux.Unlock()
+
+	h := NodeHealth{
+		NodeAddr:       s.NodeAddr,
+		Version:        upterm.Version,
+		Routing:        routing,
+		MaxSessions:    s.MaxSessions,
+		QueuedHosts:    queue.Len(),
+		MemoryPressure: memory.UnderPressure(),
+		EvictedNodes:   janitor.EvictedNodes(),
+	}
+	switch {
+	case sessRepo == nil:
+		h.Reason = "the node isn't serving yet"
+		return h
+	case ctx.Err() != nil:
+		h.Reason = "the node is shutting down"
+	default:
+		h.Ready = true
+	}
+	h.Sessions = sessRepo.Count()
+	h.Clients = sessRepo.CountClients()
+
+	return h
+}
+
 func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln net.Listener) error {
+	var tcp *tcpTuner
+	if s.TCPTuning != nil {
+		tcp = newTCPTuner(*s.TCPTuning, s.MetricsProvider, s.Logger.WithField("com", "tcp-tuner"))
+		sshln, wsln = tcp.Listener(sshln), tcp.Listener(wsln)
+	}
+
 	s.mux.Lock()
 	s.sshln, s.wsln = sshln, wsln
 	s.ctx, s.cancel = context.WithCancel(ctx)
@@ -216,7 +865,25 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 
 	sshdDialListener := s.NetworkProvider.SSHD()
 	sessionDialListener := s.NetworkProvider.Session()
-	sessRepo := newSessionRepo()
+	sessRepo := newInstrumentedSessionRepo(s.MetricsProvider)
+	routes := newRouteRecorder(s.NodeAddr)
+	ingress := newIngress(s.MetricsProvider)
+	connLimiter := newIPLimiter(s.ConnLimits, s.MetricsProvider, s.Logger.WithField("com", "conn-limiter"))
+	ipGuard := newIPGuard(s.IPFilter, s.MetricsProvider)
+	var tracer trace.Tracer
+	if s.TracerProvider != nil {
+		tracer = s.TracerProvider.Tracer(tracerName)
+	}
+
+	relay, err := newRelayer(s.RelayCore)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	s.mux.Lock()
+	s.sessRepo, s.routes = sessRepo, routes
+	s.mux.Unlock()
 
 	var g run.Group
 	{
@@ -227,23 +894,126 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 			s.cancel()
 		})
 	}
+	sshdHostSigners := s.SSHDHostSigners
+	if len(sshdHostSigners) == 0 {
+		sshdHostSigners = s.HostSigners
+	}
+	var sshdHostKeys []ssh.PublicKey
+	for _, signer := range sshdHostSigners {
+		sshdHostKeys = append(sshdHostKeys, signer.PublicKey())
+	}
+
+	// the sshd only trusts user certs signed by the proxy of the node, which are signed by Signers without a user CA
+	var (
+		userCA         ssh.Signer
+		userCAKeys     []ssh.PublicKey
+		sshdUserCAKeys []ssh.PublicKey
+	)
+	if len(s.UserCASigners) > 0 {
+		userCA = s.UserCASigners[0]
+		for _, signer := range s.UserCASigners {
+			userCAKeys = append(userCAKeys, signer.PublicKey())
+		}
+		sshdUserCAKeys = userCAKeys
+	} else {
+		for _, signer := range s.Signers {
+			sshdUserCAKeys = append(sshdUserCAKeys, signer.PublicKey())
+		}
+	}
+
+	var audit *auditor
+	if s.AuditSink != nil {
+		audit = newAuditor(s.AuditSink, s.NodeAddr, s.MetricsProvider, s.Logger.WithField("com", "audit"))
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		g.Add(func() error {
+			return audit.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	var notifier *auditor
+	if s.NotifySink != nil {
+		notifier = newNotifier(s.NotifySink, s.NodeAddr, s.MetricsProvider, s.Logger.WithField("com", "notifier"))
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		g.Add(func() error {
+			return notifier.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	var memory *memoryWatchdog
+	if s.MemoryBudget > 0 {
+		memory = newMemoryWatchdog(s.MemoryBudget, s.MemoryEvictIdle, sessRepo, s.MetricsProvider, s.Logger.WithField("com", "memory-watchdog"))
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		g.Add(func() error {
+			return memory.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	var queue *sessionQueue
+	if s.SessionQueueSize > 0 {
+		queue = newSessionQueue(s.SessionQueueSize, s.MetricsProvider)
+	}
+	var janitor *nodeJanitor
+	if sshln != nil && s.NodeEvictionGrace > 0 {
+		// neighbours are only health checked over SSH
+		janitor = newNodeJanitor(s.NodeEvictionGrace, routes, s.MetricsProvider, s.Logger.WithField("com", "node-janitor"))
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		g.Add(func() error {
+			return janitor.Run(ctx)
+		}, func(err error) {
+			cancel()
+		})
+	}
+	routing := RoutingModeSSH
+	if sshln == nil {
+		routing = RoutingModeWebSocket
+	}
+
+	s.mux.Lock()
+	s.memory, s.queue, s.janitor, s.routing = memory, queue, janitor, routing
+	s.mux.Unlock()
 	{
 		if sshln != nil {
 			cd := sidewayConnDialer{
 				NodeAddr:            s.NodeAddr,
 				SSHDDialListener:    sshdDialListener,
 				SessionDialListener: sessionDialListener,
-				NeighbourDialer:     tcpConnDialer{},
+				NeighbourDialer:     tcpConnDialer{Tuner: tcp},
+				Routes:              routes,
+				Janitor:             janitor,
+				Tracer:              tracer,
 				Logger:              s.Logger.WithField("com", "ssh-conn-dialer"),
 			}
 			sp := &sshProxy{
 				HostSigners:     s.HostSigners,
 				Signers:         s.Signers,
+				SSHDHostKeys:    sshdHostKeys,
+				UserCA:          userCA,
+				UserCAKeys:      userCAKeys,
 				NodeAddr:        s.NodeAddr,
 				ConnDialer:      cd,
 				SessionRepo:     sessRepo,
 				Logger:          s.Logger.WithField("com", "ssh-proxy"),
 				MetricsProvider: s.MetricsProvider,
+				JoinLimits:      s.JoinLimits,
+				ShadowAddr:      s.ShadowAddr,
+				ShadowRate:      s.ShadowRate,
+				Janitor:         janitor,
+				Ingress:         ingress,
+				StrictCrypto:    s.StrictCrypto,
+				Identities:      s.Identities,
+				Tracer:          tracer,
+				Auditor:         audit,
+				ConnLimiter:     connLimiter,
+				IPGuard:         ipGuard,
+				Authorizer:      s.Authorizer,
+				Done:            s.ctx.Done(),
 			}
 			g.Add(func() error {
 				return sp.Serve(sshln)
@@ -260,7 +1030,9 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 					NodeAddr:            s.NodeAddr,
 					SSHDDialListener:    sshdDialListener,
 					SessionDialListener: sessionDialListener,
-					NeighbourDialer:     wsConnDialer{},
+					NeighbourDialer:     wsConnDialer{TLS: s.WSTLSConfig != nil},
+					Routes:              routes,
+					Tracer:              tracer,
 					Logger:              s.Logger.WithField("com", "ws-conn-dialer"),
 				}
 			} else {
@@ -269,13 +1041,21 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 				// This makes sure that SSHProxy terminates all SSH requests
 				// which provides a consistent authentication mechanism.
 				cd = sshProxyDialer{
-					sshProxyAddr: sshln.Addr().String(),
+					sshProxyAddr: sshln.Addr(),
 					Logger:       s.Logger.WithField("com", "ws-sshproxy-dialer"),
 				}
 			}
 			ws := &webSocketProxy{
-				ConnDialer: cd,
-				Logger:     s.Logger.WithField("com", "ws-proxy"),
+				ConnDialer:     cd,
+				SessionAliases: s.SessionAliases,
+				Ingress:        ingress,
+				CoalesceDelay:  s.WSCoalesceDelay,
+				TLSConfig:      s.WSTLSConfig,
+				Relayer:        relay,
+				Tracer:         tracer,
+				ConnLimiter:    connLimiter,
+				IPGuard:        ipGuard,
+				Logger:         s.Logger.WithField("com", "ws-proxy"),
 			}
 			g.Add(func() error {
 				return ws.Serve(wsln)
@@ -291,11 +1071,27 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 		}
 
 		sshd := sshd{
-			SessionRepo:         sessRepo,
-			HostSigners:         s.HostSigners, // TODO: use different host keys
-			NodeAddr:            s.NodeAddr,
-			SessionDialListener: sessionDialListener,
-			Logger:              s.Logger.WithField("com", "sshd"),
+			SessionRepo:           sessRepo,
+			HostSigners:           sshdHostSigners,
+			UserCAKeys:            sshdUserCAKeys,
+			NodeAddr:              s.NodeAddr,
+			SessionDialListener:   sessionDialListener,
+			Policy:                s.Policy,
+			MaxSessionAge:         s.MaxSessionAge,
+			SessionIdleTimeout:    s.SessionIdleTimeout,
+			SessionResumeGrace:    s.SessionResumeGrace,
+			StrictCrypto:          s.StrictCrypto,
+			RequireAuthorizedKeys: s.RequireAuthorizedKeys,
+			HostACL:               s.HostACL,
+			HostQuotas:            s.HostQuotas,
+			Memory:                memory,
+			MaxSessions:           s.MaxSessions,
+			Queue:                 queue,
+			Tracer:                tracer,
+			Auditor:               audit,
+			Notifier:              notifier,
+			Relayer:               relay,
+			Logger:                s.Logger.WithField("com", "sshd"),
 		}
 		g.Add(func() error {
 			return sshd.Serve(ln)
@@ -308,45 +1104,71 @@ func (s *Server) ServeWithContext(ctx context.Context, sshln net.Listener, wsln
 }
 
 type connDialer interface {
-	Dial(id *api.Identifier) (net.Conn, error)
+	Dial(ctx context.Context, id *api.Identifier) (net.Conn, error)
 }
 
 type sshProxyDialer struct {
-	sshProxyAddr string
+	sshProxyAddr net.Addr
 	Logger       log.FieldLogger
 }
 
-func (d sshProxyDialer) Dial(id *api.Identifier) (net.Conn, error) {
+func (d sshProxyDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: tcpDialTimeout}
+
 	// If it's a host request, dial to SSHProxy in the same node.
 	// Otherwise, dial to the specified SSHProxy.
 	if id.Type == api.Identifier_HOST {
 		d.Logger.WithFields(log.Fields{"host": id.Id, "sshproxy-addr": d.sshProxyAddr}).Info("dialing sshproxy sshd")
-		return net.DialTimeout("tcp", d.sshProxyAddr, tcpDialTimeout)
+		return dialer.DialContext(ctx, d.sshProxyAddr.Network(), d.sshProxyAddr.String())
 	}
 
 	d.Logger.WithFields(log.Fields{"session": id.Id, "sshproxy-addr": d.sshProxyAddr, "addr": id.NodeAddr}).Info("dialing sshproxy session")
-	return net.DialTimeout("tcp", id.NodeAddr, tcpDialTimeout)
+	return dialer.DialContext(ctx, "tcp", id.NodeAddr)
 }
 
 type tcpConnDialer struct {
+	// Tuner tunes the connections to neighbour nodes if it's non-nil.
+	Tuner *tcpTuner
 }
 
-func (d tcpConnDialer) Dial(id *api.Identifier) (net.Conn, error) {
-	return net.DialTimeout("tcp", id.NodeAddr, tcpDialTimeout)
+func (d tcpConnDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: tcpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", id.NodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	d.Tuner.Apply(conn)
+
+	return conn, nil
 }
 
 type wsConnDialer struct {
+	// TLS dials neighbour nodes over wss://.
+	TLS bool
 }
 
-func (d wsConnDialer) Dial(id *api.Identifier) (net.Conn, error) {
-	u, err := url.Parse("ws://" + id.NodeAddr)
+func (d wsConnDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	scheme, dialer := "ws", websocket.DefaultDialer
+	if d.TLS {
+		// Neighbour nodes are dialed by their node addrs, which their certificates are rarely issued for.
+		// Their certificates aren't verified, since the SSH connections of clients relayed to them are
+		// authenticated end to end with the host keys of the sshd.
+		scheme, dialer = "wss", &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+			TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	u, err := url.Parse(scheme + "://" + id.NodeAddr)
 	if err != nil {
 		return nil, err
 	}
 	encodedNodeAddr := base64.StdEncoding.EncodeToString([]byte(id.NodeAddr))
 	u.User = url.UserPassword(id.Id, encodedNodeAddr)
 
-	return ws.NewWSConn(u, true)
+	conn, _, err := ws.DialWSConn(ctx, dialer, u, true)
+	return conn, err
 }
 
 type sidewayConnDialer struct {
@@ -354,11 +1176,19 @@ type sidewayConnDialer struct {
 	SSHDDialListener    SSHDDialListener
 	SessionDialListener SessionDialListener
 	NeighbourDialer     connDialer
-	Logger              log.FieldLogger
+	Routes              *routeRecorder
+	// Janitor fails dialing evicted neighbours if it's non-nil.
+	Janitor *nodeJanitor
+	// Tracer records a span per dial if it's non-nil.
+	Tracer trace.Tracer
+	Logger log.FieldLogger
 }
 
-func (cd sidewayConnDialer) Dial(id *api.Identifier) (net.Conn, error) {
+func (cd sidewayConnDialer) Dial(ctx context.Context, id *api.Identifier) (conn net.Conn, err error) {
 	if id.Type == api.Identifier_HOST {
+		_, span := startSpan(ctx, cd.Tracer, "dial", attrDialTarget.String("sshd"))
+		defer func() { endSpan(span, err) }()
+
 		cd.Logger.WithFields(log.Fields{"host": id.Id, "node": cd.NodeAddr}).Info("dialing sshd")
 		return cd.SSHDDialListener.Dial()
 	} else {
@@ -371,11 +1201,110 @@ func (cd sidewayConnDialer) Dial(id *api.Identifier) (net.Conn, error) {
 		// if current node is matching, dial to session.
 		// Otherwise, dial to neighbour node
 		if cd.NodeAddr == addr {
+			_, span := startSpan(ctx, cd.Tracer, "dial", attrDialTarget.String("session"), attrSessionID.String(id.Id))
+			defer func() { endSpan(span, err) }()
+
 			cd.Logger.WithFields(log.Fields{"session": id.Id, "node": cd.NodeAddr, "addr": addr}).Info("dialing session")
 			return cd.SessionDialListener.Dial(id.Id)
 		}
 
+		ctx, span := startSpan(ctx, cd.Tracer, "dial", attrDialTarget.String("neighbour"), attrSessionID.String(id.Id), attrSessionNodeAddr.String(addr))
+		defer func() { endSpan(span, err) }()
+
+		if cd.Janitor.Evicted(addr) {
+			return nil, NewRejection(RejectionNodeUnavailable)
+		}
+
 		cd.Logger.WithFields(log.Fields{"session": id.Id, "node": cd.NodeAddr, "addr": addr}).Info("dialing neighbour")
-		return cd.NeighbourDialer.Dial(id)
+		if cd.Routes != nil {
+			cd.Routes.Record(addr, time.Now())
+		}
+		return cd.NeighbourDialer.Dial(ctx, id)
+	}
+}
+
+// readSigners reads private keys from files.
+func readSigners(paths []string) ([]ssh.Signer, error) {
+	privateKeys, err := utils.ReadFiles(paths)
+	if err != nil {
+		return nil, err
 	}
+
+	var signers []ssh.Signer
+	for _, pk := range privateKeys {
+		signer, err := ssh.ParsePrivateKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// HostKeyFingerprints returns the fingerprints of the host keys of the server for each of opt.Hostnames,
+// or without a host if there are none.
+func HostKeyFingerprints(opt Opt) ([]utils.HostKeyFingerprint, error) {
+	privateKeys, err := utils.ReadFiles(opt.PrivateKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if pp := os.Getenv("PRIVATE_KEY"); pp != "" {
+		privateKeys = append(privateKeys, []byte(pp))
+	}
+
+	// the server generates an ephemeral key without private keys, whose fingerprint can't be known in advance
+	if len(privateKeys) == 0 {
+		return nil, fmt.Errorf("must specify private keys")
+	}
+
+	signers, err := utils.CreateSigners(privateKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := opt.SSHAddr
+	if opt.MuxAddr != "" {
+		addr = opt.MuxAddr
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh address %q: %w", addr, err)
+	}
+
+	hosts := []string{""}
+	if len(opt.Hostnames) > 0 {
+		hosts = nil
+		for _, h := range opt.Hostnames {
+			hosts = append(hosts, net.JoinHostPort(h, port))
+		}
+	}
+
+	var fps []utils.HostKeyFingerprint
+	for _, h := range hosts {
+		for _, s := range signers {
+			fps = append(fps, utils.NewHostKeyFingerprint(h, s.PublicKey()))
+		}
+	}
+
+	return fps, nil
+}
+
+// withHostCerts returns the key signers followed by the corresponding host cert signers for hostnames.
+func withHostCerts(signers []ssh.Signer, hostnames []string) ([]ssh.Signer, error) {
+	hostSigners := slices.Clone(signers)
+	for _, s := range signers {
+		hs := HostCertSigner{
+			Hostnames: hostnames,
+		}
+		ss, err := hs.SignCert(s)
+		if err != nil {
+			return nil, err
+		}
+
+		hostSigners = append(hostSigners, ss)
+	}
+
+	return hostSigners, nil
 }
@@ -1,18 +1,79 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/dchest/uniuri"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	libmetrics "github.com/owenthereal/upterm/metrics"
 	"github.com/owenthereal/upterm/utils"
 	"golang.org/x/crypto/ssh"
 )
 
+// MaxJoinTokensPerSession is how many unexpired one-time join tokens a session has at most.
+const MaxJoinTokensPerSession = 32
+
+// resumeTokenLen is the length of the tokens hosts resume their sessions with.
+const resumeTokenLen = 32
+
+// ErrSessionNotResumable is returned when a host resumes a session that has ended or whose resume token doesn't
+// match.
+var ErrSessionNotResumable = errors.New("the session can't be resumed")
+
 type session struct {
 	ID                   string
 	HostUser             string
 	HostPublicKeys       []ssh.PublicKey
 	ClientAuthorizedKeys []ssh.PublicKey
+	// ExpiresAt is when the server ends the session. It's zero if the session has no max age.
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// Features are the features the host is granted by the host ACL. They're nil if the server has no host ACL.
+	Features *HostFeatures
+	// Labels describe the session, e.g. env=prod: the labels of the host, overridden by the labels of Features.
+	Labels map[string]string
+	// HostFingerprint is the fingerprint of the key of the host, which its quota is keyed on.
+	HostFingerprint string
+	// MaxClients caps the clients connected to the session at once by the quota of the host. Zero is unlimited.
+	MaxClients int
+	// ResumeToken reclaims the session after the connection of the host drops. It's empty if the session can't be
+	// resumed.
+	ResumeToken string
+
+	// end notifies the host of the reason and closes its connection if it's set, which tears down the session.
+	end func(reason string) error
+	// disconnect closes the connection of the host if it's set, keeping the session for the host to resume it.
+	disconnect func() error
+	// hostConn identifies the connection of the host among the connections the session was hosted on.
+	hostConn uint64
+	// deleted is closed once the session is deleted if it's set.
+	deleted chan struct{}
+}
+
+// End ends the session by closing the connection of the host, which is told the reason.
+func (s session) End(reason string) error {
+	if s.end == nil {
+		return fmt.Errorf("session can't be ended")
+	}
+
+	return s.end(reason)
+}
+
+// ClientLimit returns the max clients connected to the session at once, the lower of the host ACL and the quota of
+// the host. Zero is unlimited.
+func (s session) ClientLimit() int {
+	return minLimit(int(s.Features.GetMaxClients()), s.MaxClients)
+}
+
+// Expiring reports whether the session ends within SessionExpiryWarning.
+func (s session) Expiring(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && s.ExpiresAt.Sub(now) <= SessionExpiryWarning
 }
 
 func (s session) IsClientKeyAllowed(key ssh.PublicKey) bool {
@@ -56,21 +117,78 @@ func newSession(id, hostUser string, hostPublicKeys, clientAuthorizedKeys [][]by
 		HostUser:             hostUser,
 		HostPublicKeys:       hpk,
 		ClientAuthorizedKeys: cak,
+		deleted:              make(chan struct{}),
 	}, nil
 }
 
+// sessionInstruments measure the lifecycle of the sessions of a node and of their clients.
+type sessionInstruments struct {
+	activeSessions  metrics.Gauge
+	sessionsCreated metrics.Counter
+	sessionsDeleted metrics.Counter
+	sessionDuration metrics.Histogram
+	activeClients   metrics.Gauge
+	clientJoins     metrics.Counter
+	clientLeaves    metrics.Counter
+	// bytesToHost and bytesToClient are the bytes proxied between clients and hosts in each direction.
+	bytesToHost   metrics.Counter
+	bytesToClient metrics.Counter
+}
+
+func newSessionInstruments(p provider.Provider) *sessionInstruments {
+	return &sessionInstruments{
+		activeSessions:  p.NewGauge("session_active_count"),
+		sessionsCreated: p.NewCounter("session_created_count"),
+		sessionsDeleted: p.NewCounter("session_deleted_count"),
+		sessionDuration: p.NewHistogram("session_duration_ms", 50),
+		activeClients:   p.NewGauge("client_active_count"),
+		clientJoins:     p.NewCounter("client_join_count"),
+		clientLeaves:    p.NewCounter("client_leave_count"),
+		bytesToHost:     p.NewCounter("proxied_bytes_to_host_count"),
+		bytesToClient:   p.NewCounter("proxied_bytes_to_client_count"),
+	}
+}
+
+// newSessionRepo returns a repo of sessions whose lifecycle isn't measured.
 func newSessionRepo() *sessionRepo {
+	return newInstrumentedSessionRepo(provider.NewDiscardProvider())
+}
+
+// newInstrumentedSessionRepo returns a repo of sessions whose lifecycle is measured with p.
+func newInstrumentedSessionRepo(p provider.Provider) *sessionRepo {
 	return &sessionRepo{
-		sessions: make(map[string]session),
+		sessions:    make(map[string]session),
+		clients:     make(map[string]int),
+		joinTokens:  make(map[string]map[string]time.Time),
+		dropped:     make(map[string]*time.Timer),
+		instruments: newSessionInstruments(p),
 	}
 }
 
 type sessionRepo struct {
 	sessions map[string]session
-	mutex    sync.Mutex
+	// clients are the numbers of client connections of the sessions
+	clients map[string]int
+	// joinTokens are the expiry times of the one-time join tokens of the sessions
+	joinTokens map[string]map[string]time.Time
+	// dropped are the timers deleting the sessions of hosts whose connections dropped unless they resume them
+	dropped     map[string]*time.Timer
+	instruments *sessionInstruments
+	mutex       sync.Mutex
+}
+
+// newResumeToken returns a random token hosts resume their sessions with.
+func newResumeToken() string {
+	return uniuri.NewLen(resumeTokenLen)
 }
 
 func (s *sessionRepo) Add(sess session) error {
+	return s.AddLimited(sess, 0)
+}
+
+// AddLimited adds sess unless its host already hosts maxPerHost sessions, counting the sessions the host may
+// resume, if maxPerHost is positive. Sessions of hosts with unknown keys aren't limited.
+func (s *sessionRepo) AddLimited(sess session, maxPerHost int) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -78,8 +196,22 @@ func (s *sessionRepo) Add(sess session) error {
 	if ok {
 		return fmt.Errorf("session already exists")
 	}
+	if maxPerHost > 0 && sess.HostFingerprint != "" {
+		var n int
+		for _, other := range s.sessions {
+			if other.HostFingerprint == sess.HostFingerprint {
+				n++
+			}
+		}
+		if n >= maxPerHost {
+			return ErrHostQuotaExceeded
+		}
+	}
 
 	s.sessions[sess.ID] = sess
+	s.instruments.sessionsCreated.Add(1)
+	s.instruments.activeSessions.Set(float64(len(s.sessions)))
+
 	return nil
 }
 
@@ -95,9 +227,223 @@ func (s *sessionRepo) Get(id string) (*session, error) {
 	return &sess, nil
 }
 
+// SetClientAuthorizedKeys replaces the client authorized keys of a session.
+// Clients already connected aren't affected.
+func (s *sessionRepo) SetClientAuthorizedKeys(id string, keys []ssh.PublicKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("no session is found")
+	}
+
+	sess.ClientAuthorizedKeys = keys
+	s.sessions[id] = sess
+
+	return nil
+}
+
 func (s *sessionRepo) Delete(id string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	s.deleteLocked(id)
+}
+
+func (s *sessionRepo) deleteLocked(id string) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+
+	if sess.deleted != nil {
+		close(sess.deleted)
+	}
 	delete(s.sessions, id)
+	delete(s.clients, id)
+	delete(s.joinTokens, id)
+	if t, ok := s.dropped[id]; ok {
+		t.Stop()
+		delete(s.dropped, id)
+	}
+	s.instruments.sessionsDeleted.Add(1)
+	s.instruments.activeSessions.Set(float64(len(s.sessions)))
+	if !sess.CreatedAt.IsZero() {
+		libmetrics.MeasureSince(s.instruments.sessionDuration, sess.CreatedAt)
+	}
+}
+
+// Drop deletes the session of a host whose connection hostConn dropped once grace passes, unless the host resumes
+// it meanwhile. Sessions without resume tokens are deleted at once, as are all sessions if grace isn't positive.
+// Connections the session was resumed from are ignored.
+func (s *sessionRepo) Drop(id string, hostConn uint64, grace time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if ok && sess.hostConn != hostConn {
+		return
+	}
+	if !ok || sess.ResumeToken == "" || grace <= 0 {
+		s.deleteLocked(id)
+		return
+	}
+	if _, ok := s.dropped[id]; ok {
+		return
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(grace, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		// the host may have resumed the session meanwhile, and dropped it again
+		if s.dropped[id] == t {
+			s.deleteLocked(id)
+		}
+	})
+	s.dropped[id] = t
+}
+
+// Resume reclaims the session for a new connection of its host if token is its resume token, replacing how the
+// session is ended and disconnected with end and disconnect of the new connection. The old connection of the host
+// is disconnected if the server hasn't noticed it dropped yet.
+func (s *sessionRepo) Resume(id, token string, end func(reason string) error, disconnect func() error) (*session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || sess.ResumeToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sess.ResumeToken)) != 1 {
+		return nil, ErrSessionNotResumable
+	}
+
+	if t, ok := s.dropped[id]; ok {
+		t.Stop()
+		delete(s.dropped, id)
+	} else if sess.disconnect != nil {
+		go func(disconnect func() error) { _ = disconnect() }(sess.disconnect)
+	}
+	sess.hostConn++
+	sess.end, sess.disconnect = end, disconnect
+	s.sessions[id] = sess
+
+	return &sess, nil
+}
+
+// AddJoinToken lets a client with any key join a session once with the token until it expires.
+// Sessions have up to MaxJoinTokensPerSession unexpired tokens.
+func (s *sessionRepo) AddJoinToken(id, token string, expiresAt, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return fmt.Errorf("no session is found")
+	}
+
+	tokens := s.joinTokens[id]
+	if tokens == nil {
+		tokens = make(map[string]time.Time)
+		s.joinTokens[id] = tokens
+	}
+	for t, exp := range tokens {
+		if !now.Before(exp) {
+			delete(tokens, t)
+		}
+	}
+	if len(tokens) >= MaxJoinTokensPerSession {
+		return fmt.Errorf("session has too many join tokens, the limit is %d", MaxJoinTokensPerSession)
+	}
+	tokens[token] = expiresAt
+
+	return nil
+}
+
+// RedeemJoinToken consumes a join token of a session. It reports whether the token was added,
+// and hasn't expired or been redeemed.
+func (s *sessionRepo) RedeemJoinToken(id, token string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expiresAt, ok := s.joinTokens[id][token]
+	if !ok {
+		return false
+	}
+	delete(s.joinTokens[id], token)
+
+	return now.Before(expiresAt)
+}
+
+// ClientConnected records a client connecting to a session. It returns a func recording the client disconnecting.
+func (s *sessionRepo) ClientConnected(id string) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clients[id]++
+	s.instruments.clientJoins.Add(1)
+	s.instruments.activeClients.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			s.instruments.clientLeaves.Add(1)
+			s.instruments.activeClients.Add(-1)
+
+			if s.clients[id] <= 1 {
+				delete(s.clients, id)
+				return
+			}
+			s.clients[id]--
+		})
+	}
+}
+
+// Clients returns the number of clients connected to a session.
+func (s *sessionRepo) Clients(id string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.clients[id]
+}
+
+// CountClients returns the number of clients connected to the sessions.
+func (s *sessionRepo) CountClients() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var n int
+	for _, c := range s.clients {
+		n += c
+	}
+
+	return n
+}
+
+// List returns the sessions in the order they're created.
+func (s *sessionRepo) List() []session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions
+}
+
+func (s *sessionRepo) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.sessions)
 }
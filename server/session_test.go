@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+func Test_sessionRepo_Instruments(t *testing.T) {
+	inst := &sessionInstruments{
+		activeSessions:  generic.NewGauge("session_active_count"),
+		sessionsCreated: generic.NewCounter("session_created_count"),
+		sessionsDeleted: generic.NewCounter("session_deleted_count"),
+		sessionDuration: generic.NewHistogram("session_duration_ms", 50),
+		activeClients:   generic.NewGauge("client_active_count"),
+		clientJoins:     generic.NewCounter("client_join_count"),
+		clientLeaves:    generic.NewCounter("client_leave_count"),
+		bytesToHost:     generic.NewCounter("proxied_bytes_to_host_count"),
+		bytesToClient:   generic.NewCounter("proxied_bytes_to_client_count"),
+	}
+	repo := newSessionRepo()
+	repo.instruments = inst
+
+	for _, id := range []string{"1", "2"} {
+		if err := repo.Add(session{ID: id, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Add(session{ID: "1"}); err == nil {
+		t.Fatal("want error adding an existing session")
+	}
+
+	disconnected := repo.ClientConnected("1")
+	repo.ClientConnected("2")
+	disconnected()
+	disconnected()
+
+	repo.Delete("1")
+	repo.Delete("missing")
+
+	cases := []struct {
+		name      string
+		want, got float64
+	}{
+		{name: "active sessions", want: 1, got: inst.activeSessions.(*generic.Gauge).Value()},
+		{name: "sessions created", want: 2, got: inst.sessionsCreated.(*generic.Counter).Value()},
+		{name: "sessions deleted", want: 1, got: inst.sessionsDeleted.(*generic.Counter).Value()},
+		{name: "active clients", want: 1, got: inst.activeClients.(*generic.Gauge).Value()},
+		{name: "client joins", want: 2, got: inst.clientJoins.(*generic.Counter).Value()},
+		{name: "client leaves", want: 1, got: inst.clientLeaves.(*generic.Counter).Value()},
+	}
+	for _, c := range cases {
+		if c.want != c.got {
+			t.Fatalf("%s: want=%v got=%v", c.name, c.want, c.got)
+		}
+	}
+}
+
+func Test_sessionRepo_JoinTokens(t *testing.T) {
+	now := time.Now()
+	repo := newSessionRepo()
+	if err := repo.AddJoinToken("1", "token", now.Add(time.Minute), now); err == nil {
+		t.Fatal("want error adding a join token of a missing session")
+	}
+	if err := repo.Add(session{ID: "1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.AddJoinToken("1", "token", now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if repo.RedeemJoinToken("2", "token", now) {
+		t.Fatal("token must not be redeemed for another session")
+	}
+	if !repo.RedeemJoinToken("1", "token", now) {
+		t.Fatal("token must be redeemed")
+	}
+	if repo.RedeemJoinToken("1", "token", now) {
+		t.Fatal("token must not be redeemed twice")
+	}
+
+	if err := repo.AddJoinToken("1", "expired", now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if repo.RedeemJoinToken("1", "expired", now.Add(time.Minute)) {
+		t.Fatal("expired token must not be redeemed")
+	}
+
+	for i := 0; i < MaxJoinTokensPerSession; i++ {
+		if err := repo.AddJoinToken("1", fmt.Sprintf("token%d", i), now.Add(time.Minute), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.AddJoinToken("1", "over", now.Add(time.Minute), now); err == nil {
+		t.Fatal("want error adding more join tokens than the limit")
+	}
+	// expired tokens don't count towards the limit
+	if err := repo.AddJoinToken("1", "over", now.Add(2*time.Minute), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Delete("1")
+	if err := repo.Add(session{ID: "1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if repo.RedeemJoinToken("1", "over", now) {
+		t.Fatal("tokens must be deleted with their session")
+	}
+}
+
+func Test_sessionRepo_Resume(t *testing.T) {
+	repo := newSessionRepo()
+	if err := repo.Add(session{ID: "1", ResumeToken: "token", deleted: make(chan struct{})}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Add(session{ID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions without resume tokens are deleted once their hosts drop
+	repo.Drop("2", 0, time.Minute)
+	if _, err := repo.Get("2"); err == nil {
+		t.Fatal("want the session without a resume token deleted")
+	}
+
+	repo.Drop("1", 0, time.Minute)
+	if _, err := repo.Resume("1", "wrong", nil, nil); !errors.Is(err, ErrSessionNotResumable) {
+		t.Fatalf("want the session not resumable with a wrong token, got %v", err)
+	}
+	sess, err := repo.Resume("1", "token", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.hostConn != 1 {
+		t.Fatalf("want the session resumed on connection 1, got %d", sess.hostConn)
+	}
+
+	// drops of connections the session was resumed from are ignored
+	repo.Drop("1", 0, 0)
+	if _, err := repo.Get("1"); err != nil {
+		t.Fatal("want the session kept dropping an old connection")
+	}
+
+	// sessions still hosted are taken over, disconnecting the old connection
+	disconnected := make(chan struct{})
+	if _, err := repo.Resume("1", "token", nil, func() error { close(disconnected); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Resume("1", "token", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the old connection disconnected")
+	}
+
+	// sessions are deleted after the grace
+	sess, err = repo.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Drop("1", sess.hostConn, 10*time.Millisecond)
+	select {
+	case <-sess.deleted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the session deleted after the grace")
+	}
+	if _, err := repo.Resume("1", "token", nil, nil); !errors.Is(err, ErrSessionNotResumable) {
+		t.Fatalf("want the deleted session not resumable, got %v", err)
+	}
+}
+
+func Test_sessionRepo_AddLimited(t *testing.T) {
+	repo := newSessionRepo()
+
+	for _, id := range []string{"1", "2"} {
+		if err := repo.AddLimited(session{ID: id, HostFingerprint: "SHA256:a"}, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.AddLimited(session{ID: "3", HostFingerprint: "SHA256:a"}, 2); !errors.Is(err, ErrHostQuotaExceeded) {
+		t.Fatalf("want=%v got=%v", ErrHostQuotaExceeded, err)
+	}
+	if err := repo.AddLimited(session{ID: "4", HostFingerprint: "SHA256:b"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Delete("1")
+	if err := repo.AddLimited(session{ID: "5", HostFingerprint: "SHA256:a"}, 2); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	shadowTimeout     = 5 * time.Second
+	shadowConcurrency = 16
+)
+
+// shadowOutcome is how a proxy handles the pre-auth phase of a connection.
+type shadowOutcome struct {
+	// Result is accepted, auth-required, rejected:CODE, auth-failed, handshake-error, or dial-error.
+	Result  string
+	Latency time.Duration
+}
+
+// shadower mirrors the handshakes of incoming connections to a canary proxy and compares
+// the outcome and latency with the primary proxy, e.g. to de-risk upgrades of the proxy stack.
+// A connection can't be replayed since SSH keys are exchanged per connection. Instead,
+// the primary and the canary are both probed with the user and the client version of the connection,
+// without keys, so that the probes reach the same point of the auth flow as unauthorized clients.
+// The primary connection is never affected: probes run in the background, are sampled, and are bounded.
+type shadower struct {
+	PrimaryAddr string
+	CanaryAddr  string
+	// Rate is the fraction of connections to shadow, between 0 and 1.
+	Rate   float64
+	Logger log.FieldLogger
+
+	matches         metrics.Counter
+	mismatches      metrics.Counter
+	skipped         metrics.Counter
+	primaryDuration metrics.Histogram
+	canaryDuration  metrics.Histogram
+
+	sem chan struct{}
+	// probes are the local addresses of probes to the primary, which must not be shadowed again.
+	probes sync.Map
+}
+
+func newShadower(primaryAddr, canaryAddr string, rate float64, p provider.Provider, logger log.FieldLogger) *shadower {
+	return &shadower{
+		PrimaryAddr:     primaryAddr,
+		CanaryAddr:      canaryAddr,
+		Rate:            rate,
+		Logger:          logger,
+		matches:         p.NewCounter("shadow_match_count"),
+		mismatches:      p.NewCounter("shadow_mismatch_count"),
+		skipped:         p.NewCounter("shadow_skipped_count"),
+		primaryDuration: p.NewHistogram("shadow_primary_duration_ms", 50),
+		canaryDuration:  p.NewHistogram("shadow_canary_duration_ms", 50),
+		sem:             make(chan struct{}, shadowConcurrency),
+	}
+}
+
+// Shadow probes the primary and the canary in the background for a sample of connections.
+func (s *shadower) Shadow(conn ssh.ConnMetadata) {
+	if _, ok := s.probes.Load(conn.RemoteAddr().String()); ok {
+		return
+	}
+	if rand.Float64() >= s.Rate {
+		return
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+	default:
+		// too many probes in flight
+		s.skipped.Add(1)
+		return
+	}
+
+	user, clientVersion := conn.User(), string(conn.ClientVersion())
+	go func() {
+		defer func() { <-s.sem }()
+		s.compare(user, clientVersion)
+	}()
+}
+
+// compare probes the primary and the canary and records whether the outcomes match.
+func (s *shadower) compare(user, clientVersion string) (primary, canary shadowOutcome) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		primary = probeHandshake(s.PrimaryAddr, user, clientVersion, func(local net.Addr, done bool) {
+			if done {
+				s.probes.Delete(local.String())
+			} else {
+				s.probes.Store(local.String(), struct{}{})
+			}
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		canary = probeHandshake(s.CanaryAddr, user, clientVersion, nil)
+	}()
+	wg.Wait()
+
+	s.primaryDuration.Observe(float64(primary.Latency) / float64(time.Millisecond))
+	s.canaryDuration.Observe(float64(canary.Latency) / float64(time.Millisecond))
+
+	logger := s.Logger.WithFields(log.Fields{
+		"user":            user,
+		"client-version":  clientVersion,
+		"primary":         primary.Result,
+		"canary":          canary.Result,
+		"primary-latency": primary.Latency,
+		"canary-latency":  canary.Latency,
+	})
+	if primary.Result == canary.Result {
+		s.matches.Add(1)
+		logger.Debug("shadow outcomes match")
+	} else {
+		s.mismatches.Add(1)
+		logger.Warn("shadow outcomes mismatch")
+	}
+
+	return primary, canary
+}
+
+// probeHandshake connects to a proxy as user without keys and reports how far the connection gets.
+// trackConn is called with the local address of the connection when it's dialed and when it's done.
+func probeHandshake(addr, user, clientVersion string, trackConn func(local net.Addr, done bool)) shadowOutcome {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, shadowTimeout)
+	if err != nil {
+		return shadowOutcome{Result: "dial-error", Latency: time.Since(start)}
+	}
+	defer conn.Close()
+
+	if trackConn != nil {
+		trackConn(conn.LocalAddr(), false)
+		defer trackConn(conn.LocalAddr(), true)
+	}
+	_ = conn.SetDeadline(time.Now().Add(shadowTimeout))
+
+	var (
+		banner  strings.Builder
+		offered bool
+	)
+	config := &ssh.ClientConfig{
+		User:            user,
+		ClientVersion:   clientVersion,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(msg string) error {
+			banner.WriteString(msg)
+			return nil
+		},
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				offered = true
+				return nil, nil
+			}),
+		},
+	}
+
+	c, _, _, err := ssh.NewClientConn(conn, addr, config)
+	outcome := shadowOutcome{Latency: time.Since(start)}
+	switch {
+	case err == nil:
+		c.Close()
+		outcome.Result = "accepted"
+	case banner.Len() > 0:
+		if r, ok := ParseRejection(banner.String()); ok {
+			outcome.Result = "rejected:" + string(r.Code)
+			break
+		}
+		fallthrough
+	case offered:
+		outcome.Result = "auth-required"
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		outcome.Result = "auth-failed"
+	default:
+		outcome.Result = "handshake-error"
+	}
+
+	return outcome
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func serveShadowTarget(t *testing.T, config *ssh.ServerConfig) string {
+	t.Helper()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if sc, _, _, err := ssh.NewServerConn(c, config); err == nil {
+					sc.Close()
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func Test_probeHandshake(t *testing.T) {
+	rejectPublicKey := func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return nil, NewRejection(RejectionKeyNotAuthorized)
+	}
+
+	closedLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closedLn.Addr().String()
+	_ = closedLn.Close()
+
+	cases := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{
+			name: "accepted",
+			addr: serveShadowTarget(t, &ssh.ServerConfig{NoClientAuth: true}),
+			want: "accepted",
+		},
+		{
+			name: "auth required",
+			addr: serveShadowTarget(t, &ssh.ServerConfig{PublicKeyCallback: rejectPublicKey}),
+			want: "auth-required",
+		},
+		{
+			name: "rejected",
+			addr: serveShadowTarget(t, &ssh.ServerConfig{
+				PublicKeyCallback: rejectPublicKey,
+				BannerCallback: func(ssh.ConnMetadata) string {
+					return NewRejection(RejectionSessionNotFound).String()
+				},
+			}),
+			want: "rejected:" + string(RejectionSessionNotFound),
+		},
+		{
+			name: "dial error",
+			addr: closedAddr,
+			want: "dial-error",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			got := probeHandshake(c.addr, "user", "SSH-2.0-upterm-test", nil)
+			if got.Result != c.want {
+				t.Fatalf("want %s, got %s", c.want, got.Result)
+			}
+		})
+	}
+}
+
+func Test_shadower_compare(t *testing.T) {
+	auth := serveShadowTarget(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, NewRejection(RejectionKeyNotAuthorized)
+		},
+	})
+	noAuth := serveShadowTarget(t, &ssh.ServerConfig{NoClientAuth: true})
+
+	cases := []struct {
+		name         string
+		primary      string
+		canary       string
+		wantMatch    float64
+		wantMismatch float64
+	}{
+		{
+			name:      "match",
+			primary:   auth,
+			canary:    auth,
+			wantMatch: 1,
+		},
+		{
+			name:         "mismatch",
+			primary:      auth,
+			canary:       noAuth,
+			wantMismatch: 1,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s := newShadower(c.primary, c.canary, 1, provider.NewDiscardProvider(), log.New())
+			matches, mismatches := generic.NewCounter("matches"), generic.NewCounter("mismatches")
+			s.matches, s.mismatches = matches, mismatches
+
+			s.compare("user", "SSH-2.0-upterm-test")
+
+			if got := matches.Value(); got != c.wantMatch {
+				t.Fatalf("want %v matches, got %v", c.wantMatch, got)
+			}
+			if got := mismatches.Value(); got != c.wantMismatch {
+				t.Fatalf("want %v mismatches, got %v", c.wantMismatch, got)
+			}
+		})
+	}
+}
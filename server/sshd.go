@@ -2,14 +2,19 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/charmbracelet/ssh"
+	"github.com/owenthereal/upterm/routing"
 	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	gossh "golang.org/x/crypto/ssh"
 	"google.golang.org/protobuf/proto"
 )
@@ -18,16 +23,70 @@ var (
 	serverShutDownDeadline = 1 * time.Second
 )
 
+// SessionExpiryWarning is how long before sessions reach the max session age of the server
+// that hosts and clients are warned.
+const SessionExpiryWarning = 10 * time.Minute
+
+// sessionIdleCheckInterval is how often the clients of sessions are checked at most for SessionIdleTimeout.
+const sessionIdleCheckInterval = 10 * time.Second
+
+// sessionEndedNotifyTimeout is how long the server waits for hosts to acknowledge why their sessions are ended.
+const sessionEndedNotifyTimeout = 3 * time.Second
+
+type contextKey struct {
+	name string
+}
+
+// contextKeySessionID is the ID of the session created on a host connection.
+var contextKeySessionID = &contextKey{"session-id"}
+
+// contextKeyAuthRequest is the auth request of the host passed on by the ssh proxy.
+var contextKeyAuthRequest = &contextKey{"auth-request"}
+
 type ServerInfo struct {
 	NodeAddr string
 }
 
 type sshd struct {
-	SessionRepo         *sessionRepo
-	HostSigners         []gossh.Signer
+	SessionRepo *sessionRepo
+	// HostSigners are the host keys of the sshd, which the ssh proxy checks. They may differ from the keys of the proxy.
+	HostSigners []gossh.Signer
+	// UserCAKeys are the keys the user certs the ssh proxy authenticates with must be signed by.
+	// Certs signed by any key are accepted if it's empty.
+	UserCAKeys          []gossh.PublicKey
 	NodeAddr            string
 	SessionDialListener SessionDialListener
-	Logger              log.FieldLogger
+	Policy              []byte
+	// MaxSessionAge ends sessions after the duration since they're created. Zero means unlimited.
+	MaxSessionAge time.Duration
+	// SessionIdleTimeout ends sessions without connected clients for the duration. Zero means unlimited.
+	SessionIdleTimeout time.Duration
+	// SessionResumeGrace keeps the sessions of hosts whose connections drop for the duration, for the hosts to
+	// resume them. Sessions end with the connections of their hosts if it's zero.
+	SessionResumeGrace time.Duration
+	// StrictCrypto restricts SSH connections to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// RequireAuthorizedKeys refuses to create sessions without client authorized keys.
+	RequireAuthorizedKeys bool
+	// HostACL grants the sessions of hosts features, and refuses hosts it doesn't match, if it's non-nil.
+	HostACL *HostACL
+	// HostQuotas limit the sessions of hosts by their keys if it's non-nil.
+	HostQuotas *HostQuotas
+	// Memory refuses to create sessions under memory pressure if it's non-nil.
+	Memory *memoryWatchdog
+	// MaxSessions refuses to create sessions once the node hosts the sessions if it's positive.
+	MaxSessions int
+	// Queue lets hosts asking for it wait for capacity instead of being refused if it's non-nil.
+	Queue *sessionQueue
+	// Tracer records a span per session created if it's non-nil.
+	Tracer trace.Tracer
+	// Auditor records sessions created and closed if it's non-nil.
+	Auditor *auditor
+	// Notifier posts sessions created and closed to chats, e.g. Slack, if it's non-nil.
+	Notifier *auditor
+	// Relayer copies the data of clients to and from hosts. Each direction is copied in a goroutine if it's nil.
+	Relayer relayer
+	Logger  log.FieldLogger
 
 	server *ssh.Server
 	mux    sync.Mutex
@@ -53,9 +112,14 @@ func (s *sshd) Serve(ln net.Listener) error {
 		signers = append(signers, signer)
 	}
 
+	relay := s.Relayer
+	if relay == nil {
+		relay = goroutineRelayer{}
+	}
 	sh := newStreamlocalForwardHandler(
 		s.SessionRepo,
 		s.SessionDialListener,
+		relay,
 		s.Logger.WithField("com", "stream-local-handler"),
 	)
 	s.mux.Lock()
@@ -71,6 +135,10 @@ func (s *sshd) Serve(ln net.Listener) error {
 			config := &gossh.ServerConfig{
 				ServerVersion: upterm.ServerSSHServerVersion,
 			}
+			if s.StrictCrypto {
+				config.Config = utils.StrictCryptoConfig()
+				config.PublicKeyAuthAlgorithms = utils.StrictPublicKeyAuthAlgorithms
+			}
 			return config
 		},
 		ReversePortForwardingCallback: ssh.ReversePortForwardingCallback(func(ctx ssh.Context, host string, port uint32) (granted bool) {
@@ -78,22 +146,24 @@ func (s *sshd) Serve(ln net.Listener) error {
 			return true
 		}),
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
-			checker := UserCertChecker{}
-			_, _, err := checker.Authenticate(ctx.User(), key)
+			checker := UserCertChecker{Authorities: s.UserCAKeys}
+			auth, _, err := checker.Authenticate(ctx.User(), key)
 			if err != nil {
 				s.Logger.WithError(err).Error("error parsing auth request from cert")
 				return false
 			}
-
-			// TOOD: validate pk
+			ctx.SetValue(contextKeyAuthRequest, auth)
 
 			return true
 		},
 		ChannelHandlers: make(map[string]ssh.ChannelHandler), // disallow channel requests, e.g. shell
 		RequestHandlers: map[string]ssh.RequestHandler{
-			streamlocalForwardChannelType:         sh.Handler,
-			cancelStreamlocalForwardChannelType:   sh.Handler,
-			upterm.ServerCreateSessionRequestType: s.createSessionHandler,
+			streamlocalForwardChannelType:                sh.Handler,
+			cancelStreamlocalForwardChannelType:          sh.Handler,
+			upterm.ServerCreateSessionRequestType:        s.createSessionHandler,
+			upterm.ServerPolicyRequestType:               s.policyHandler,
+			upterm.ServerUpdateAuthorizedKeysRequestType: s.updateAuthorizedKeysHandler,
+			upterm.ServerIssueJoinTokenRequestType:       s.issueJoinTokenHandler,
 		},
 	}
 	s.mux.Unlock()
@@ -102,10 +172,55 @@ func (s *sshd) Serve(ln net.Listener) error {
 }
 
 func (s *sshd) createSessionHandler(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	_, span := startSpan(ctx, s.Tracer, "sshd.CreateSession", attrNodeAddr.String(s.NodeAddr))
+
+	ok, reply := s.createSession(ctx, req)
+	if !ok {
+		endSpan(span, errors.New(string(reply)))
+		return ok, reply
+	}
+	if id, ok := ctx.Value(contextKeySessionID).(string); ok {
+		span.SetAttributes(attrSessionID.String(id))
+	}
+	span.End()
+
+	return ok, reply
+}
+
+func (s *sshd) createSession(ctx ssh.Context, req *gossh.Request) (bool, []byte) {
 	var sessReq CreateSessionRequest
 	if err := proto.Unmarshal(req.Payload, &sessReq); err != nil {
 		return false, []byte(err.Error())
 	}
+	if sessReq.ResumeSessionId != "" {
+		return s.resumeSession(ctx, &sessReq)
+	}
+
+	if len(s.Policy) > 0 && sessReq.PolicyHash != PolicyHash(s.Policy) {
+		return false, []byte(ErrPolicyNotAgreed.Error())
+	}
+
+	if s.RequireAuthorizedKeys && len(sessReq.ClientAuthorizedKeys) == 0 {
+		return false, []byte(ErrAuthorizedKeysRequired.Error())
+	}
+
+	var features *HostFeatures
+	if s.HostACL != nil {
+		var ok bool
+		if features, ok = s.HostACL.Features(hostAuthKey(ctx)); !ok {
+			return false, []byte(ErrHostNotAllowed.Error())
+		}
+	}
+
+	leave, err := s.waitForCapacity(ctx, &sessReq)
+	if err != nil {
+		return false, []byte(err.Error())
+	}
+	defer leave()
+	// hosts may only opt out of the audit log and notifications if they're granted to
+	optedOut := sessReq.RecordingOptOut && features.GetRecordingOptOut()
+	recorded := s.Auditor != nil && !optedOut
+	notified := s.Notifier != nil && !optedOut
 
 	sess, err := newSession(
 		utils.GenerateSessionID(),
@@ -116,17 +231,412 @@ func (s *sshd) createSessionHandler(ctx ssh.Context, srv *ssh.Server, req *gossh
 	if err != nil {
 		return false, []byte(err.Error())
 	}
+	sess.CreatedAt = time.Now()
+	sess.Features = features
+	sess.Labels = sessionLabels(sessReq.Labels, features.GetLabels())
+	sess.HostFingerprint = hostFingerprint(hostAuthKey(ctx))
+	quota := s.HostQuotas.Quota(sess.HostFingerprint)
+	sess.MaxClients = quota.MaxClients
+	if maxAge := minLimit(s.MaxSessionAge, quota.MaxSessionAge); maxAge > 0 {
+		sess.ExpiresAt = sess.CreatedAt.Add(maxAge)
+	}
+	if s.SessionResumeGrace > 0 {
+		sess.ResumeToken = newResumeToken()
+	}
+	sess.end, sess.disconnect = s.sessionEnder(ctx, sess.ID)
 
-	if err := s.SessionRepo.Add(*sess); err != nil {
+	if err := s.SessionRepo.AddLimited(*sess, quota.MaxSessions); err != nil {
+		if errors.Is(err, ErrHostQuotaExceeded) {
+			s.Logger.WithFields(log.Fields{
+				"host-user":    sessReq.HostUser,
+				"fingerprint":  sess.HostFingerprint,
+				"max-sessions": quota.MaxSessions,
+				"event":        "host-quota-exceeded",
+			}).Warn("refused host exceeding its quota")
+		}
 		return false, []byte(err.Error())
 	}
+	s.hostSession(ctx, sess)
+	if recorded || notified {
+		go s.auditSession(ctx, sess, recorded, notified)
+	}
+
+	if len(s.Policy) > 0 {
+		s.Logger.WithFields(log.Fields{
+			"session":     sess.ID,
+			"host-user":   sessReq.HostUser,
+			"remote-addr": ctx.RemoteAddr(),
+			"policy-hash": sessReq.PolicyHash,
+		}).Info("host agreed to the policy")
+	}
 
 	sessResp := &CreateSessionResponse{
+		SessionID:  sess.ID,
+		NodeAddr:   s.NodeAddr,
+		Features:   features,
+		Recorded:   recorded,
+		MaxClients: int32(sess.ClientLimit()),
+	}
+
+	return s.sessionResponse(sess, sessResp)
+}
+
+// resumeSession reclaims the session of a host whose connection dropped within SessionResumeGrace on the new
+// connection of the host, disconnecting the old connection if the server hasn't noticed it dropped yet. Clients
+// connected to the session are lost with the old connection, but can join again.
+func (s *sshd) resumeSession(ctx ssh.Context, req *CreateSessionRequest) (bool, []byte) {
+	logger := s.Logger.WithFields(log.Fields{
+		"session":     req.ResumeSessionId,
+		"host-user":   req.HostUser,
+		"remote-addr": ctx.RemoteAddr(),
+	})
+
+	end, disconnect := s.sessionEnder(ctx, req.ResumeSessionId)
+	sess, err := s.SessionRepo.Resume(req.ResumeSessionId, req.ResumeToken, end, disconnect)
+	if err != nil {
+		logger.WithError(err).Warn("error resuming session")
+		return false, []byte(err.Error())
+	}
+	s.hostSession(ctx, sess)
+	logger.WithField("event", "session-resumed").Info("host resumed session")
+
+	return s.sessionResponse(sess, &CreateSessionResponse{
+		SessionID:  sess.ID,
+		NodeAddr:   s.NodeAddr,
+		Features:   sess.Features,
+		Recorded:   s.Auditor != nil && !(req.RecordingOptOut && sess.Features.GetRecordingOptOut()),
+		MaxClients: int32(sess.ClientLimit()),
+	})
+}
+
+// hostSession hosts the session on the connection of the host until the connection is closed, when the session is
+// dropped for the host to resume it within SessionResumeGrace.
+func (s *sshd) hostSession(ctx ssh.Context, sess *session) {
+	ctx.SetValue(contextKeySessionID, sess.ID)
+
+	if !sess.ExpiresAt.IsZero() {
+		go s.expireSession(ctx, sess)
+	}
+	if s.SessionIdleTimeout > 0 {
+		go s.endIdleSession(ctx, sess)
+	}
+	go func() {
+		<-ctx.Done()
+		s.SessionRepo.Drop(sess.ID, sess.hostConn, s.SessionResumeGrace)
+	}()
+}
+
+func (s *sshd) sessionResponse(sess *session, resp *CreateSessionResponse) (bool, []byte) {
+	resp.ResumeToken = sess.ResumeToken
+	if !sess.ExpiresAt.IsZero() {
+		resp.ExpiresAt = sess.ExpiresAt.Unix()
+	}
+
+	b, err := proto.Marshal(resp)
+	if err != nil {
+		return false, []byte(err.Error())
+	}
+
+	return true, b
+}
+
+// sessionEnder returns how the session of id hosted on the connection of the host is ended and disconnected, or
+// nils if the connection is unknown. Ended sessions are deleted, so that the host can't resume them.
+func (s *sshd) sessionEnder(ctx ssh.Context, id string) (end func(reason string) error, disconnect func() error) {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return nil, nil
+	}
+
+	end = func(reason string) error {
+		notifySessionEnded(conn, reason)
+		s.SessionRepo.Delete(id)
+		return conn.Close()
+	}
+
+	return end, conn.Close
+}
+
+// capacity returns an error if the node can't host another session, e.g. under memory pressure.
+func (s *sshd) capacity() error {
+	if s.Memory.UnderPressure() {
+		return ErrMemoryPressure
+	}
+	if s.MaxSessions > 0 && s.SessionRepo.Count() >= s.MaxSessions {
+		return ErrServerAtCapacity
+	}
+
+	return nil
+}
+
+// waitForCapacity returns once the node has capacity for the session of req. Hosts asking to queue wait in line
+// while the node is at capacity or others are waiting, and are told their positions. Others are refused meanwhile.
+// The returned func is called once the session is created or fails to be.
+func (s *sshd) waitForCapacity(ctx ssh.Context, req *CreateSessionRequest) (func(), error) {
+	err := s.capacity()
+	if err == nil && s.Queue.Len() == 0 {
+		return func() {}, nil
+	}
+	if !req.Queue || s.Queue == nil {
+		if err == nil {
+			// hosts waiting in line go first
+			err = ErrServerAtCapacity
+		}
+		return nil, err
+	}
+
+	logger := s.Logger.WithFields(log.Fields{
+		"host-user":   req.HostUser,
+		"remote-addr": ctx.RemoteAddr(),
+	})
+	conn, _ := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	leave, err := s.Queue.Wait(ctx, s.capacity, func(pos, length int) {
+		logger.WithFields(log.Fields{"position": pos, "length": length}).Info("host waiting for capacity")
+		if conn != nil {
+			notifyQueuePosition(conn, pos, length)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("host let in from the queue")
+
+	return leave, nil
+}
+
+// notifyQueuePosition tells the host its position in the session queue without waiting for a reply, since the
+// host is waiting for its session to be created.
+func notifyQueuePosition(conn gossh.Conn, pos, length int) {
+	b, err := proto.Marshal(&QueuePosition{Position: int32(pos), Length: int32(length)})
+	if err != nil {
+		return
+	}
+
+	_, _, _ = conn.SendRequest(upterm.ServerQueuePositionRequestType, false, b)
+}
+
+// expireSession ends the session when it reaches the max session age by closing the connection of the host,
+// which tears down the reverse tunnel and the connections of clients. The host learns the expiry when the
+// session is created and warns clients, but the server enforces it regardless.
+func (s *sshd) expireSession(ctx ssh.Context, sess *session) {
+	logger := s.Logger.WithFields(log.Fields{
+		"session":         sess.ID,
+		"host-user":       sess.HostUser,
+		"remote-addr":     ctx.RemoteAddr(),
+		"max-session-age": s.MaxSessionAge,
+		"expires-at":      sess.ExpiresAt,
+	})
+
+	warning := time.NewTimer(time.Until(sess.ExpiresAt.Add(-SessionExpiryWarning)))
+	defer warning.Stop()
+	expiry := time.NewTimer(time.Until(sess.ExpiresAt))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-warning.C:
+			logger.WithField("event", "session-expiring").Info("session is reaching max session age")
+		case <-expiry.C:
+			logger.WithField("event", "session-expired").Warn("ended session reaching max session age")
+			s.SessionRepo.Delete(sess.ID)
+			if conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn); ok {
+				_ = conn.Close()
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// endIdleSession ends the session once it has no connected clients for SessionIdleTimeout, telling the host why.
+// Clients are checked periodically, so a client connecting only between two checks isn't noticed.
+func (s *sshd) endIdleSession(ctx ssh.Context, sess *session) {
+	logger := s.Logger.WithFields(log.Fields{
+		"session":              sess.ID,
+		"host-user":            sess.HostUser,
+		"remote-addr":          ctx.RemoteAddr(),
+		"session-idle-timeout": s.SessionIdleTimeout,
+	})
+
+	ticker := time.NewTicker(min(s.SessionIdleTimeout/4, sessionIdleCheckInterval))
+	defer ticker.Stop()
+
+	idleSince := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if s.SessionRepo.Clients(sess.ID) > 0 {
+				idleSince = now
+				continue
+			}
+			if now.Sub(idleSince) < s.SessionIdleTimeout {
+				continue
+			}
+
+			logger.WithField("event", "session-idle").Warn("ended session without clients")
+			if err := sess.End(fmt.Sprintf("the session had no clients for %s", s.SessionIdleTimeout)); err != nil {
+				logger.WithError(err).Error("error ending idle session")
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// hostAuthKey returns the key the host authenticated to the ssh proxy with, which is passed on in its auth request.
+// It returns nil if the key is unknown.
+func hostAuthKey(ctx ssh.Context) gossh.PublicKey {
+	auth, ok := ctx.Value(contextKeyAuthRequest).(*AuthRequest)
+	if !ok || auth == nil {
+		return nil
+	}
+
+	key, _, _, _, err := gossh.ParseAuthorizedKey(auth.AuthorizedKey)
+	if err != nil {
+		return nil
+	}
+
+	return key
+}
+
+// auditSession records the session created and closed once the connection of the host is closed, in the audit log
+// if it's recorded and in notifications if it's notified.
+func (s *sshd) auditSession(ctx ssh.Context, sess *session, recorded, notified bool) {
+	ev := AuditEvent{
 		SessionID: sess.ID,
-		NodeAddr:  s.NodeAddr,
+		HostUser:  sess.HostUser,
+		Labels:    sess.Labels,
+	}
+	record := func(ev AuditEvent) {
+		if recorded {
+			s.Auditor.Record(ev)
+		}
+		if notified {
+			s.Notifier.Record(ev)
+		}
+	}
+	if auth, ok := ctx.Value(contextKeyAuthRequest).(*AuthRequest); ok && auth != nil {
+		ev.RemoteAddr = auth.RemoteAddr
+		ev.ClientVersion = auth.ClientVersion
+		ev.Fingerprint = authFingerprint(auth)
+	}
+
+	created := ev
+	created.Type = AuditSessionCreated
+	record(created)
+
+	// sessions outlive the connections of their hosts within SessionResumeGrace
+	<-sess.deleted
+
+	closed := ev
+	closed.Type = AuditSessionClosed
+	closed.DurationSeconds = time.Since(sess.CreatedAt).Seconds()
+	record(closed)
+}
+
+// sessionLabels returns the labels of the host overridden by the labels granted by the host ACL.
+func sessionLabels(host, granted map[string]string) map[string]string {
+	if len(host) == 0 && len(granted) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(host)+len(granted))
+	maps.Copy(labels, host)
+	maps.Copy(labels, granted)
+
+	return labels
+}
+
+// notifySessionEnded tells the host why the server ends its session before the connection is closed.
+// It waits for the host to acknowledge the reason, so that the reason arrives before the connection is closed.
+// Hosts predating the notification reject it.
+func notifySessionEnded(conn gossh.Conn, reason string) {
+	if reason == "" {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = conn.SendRequest(upterm.ServerSessionEndedRequestType, true, []byte(reason))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(sessionEndedNotifyTimeout):
+	}
+}
+
+// updateAuthorizedKeysHandler replaces the client authorized keys of the session created on the connection.
+// Sessions can't be opened to any client by removing all the keys.
+func (s *sshd) updateAuthorizedKeysHandler(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	id, ok := ctx.Value(contextKeySessionID).(string)
+	if !ok {
+		return false, []byte("no session is created")
+	}
+
+	var (
+		keys []gossh.PublicKey
+		rest = req.Payload
+	)
+	for len(rest) > 0 {
+		pk, _, _, r, err := gossh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return false, []byte(err.Error())
+		}
+		keys = append(keys, pk)
+		rest = r
+	}
+	if len(keys) == 0 {
+		return false, []byte(ErrAuthorizedKeysRequired.Error())
 	}
 
-	b, err := proto.Marshal(sessResp)
+	if err := s.SessionRepo.SetClientAuthorizedKeys(id, keys); err != nil {
+		return false, []byte(err.Error())
+	}
+
+	s.Logger.WithFields(log.Fields{
+		"session":         id,
+		"remote-addr":     ctx.RemoteAddr(),
+		"authorized-keys": len(keys),
+	}).Info("host updated client authorized keys")
+
+	return true, nil
+}
+
+// issueJoinTokenHandler registers a one-time join token of the session created on the connection.
+func (s *sshd) issueJoinTokenHandler(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	id, ok := ctx.Value(contextKeySessionID).(string)
+	if !ok {
+		return false, []byte("no session is created")
+	}
+
+	var tokenReq IssueJoinTokenRequest
+	if err := proto.Unmarshal(req.Payload, &tokenReq); err != nil {
+		return false, []byte(err.Error())
+	}
+	if !routing.IsToken(tokenReq.Token) {
+		return false, []byte("invalid join token")
+	}
+
+	expiresAt := time.Unix(tokenReq.ExpiresAt, 0)
+	if err := s.SessionRepo.AddJoinToken(id, tokenReq.Token, expiresAt, time.Now()); err != nil {
+		return false, []byte(err.Error())
+	}
+
+	s.Logger.WithFields(log.Fields{
+		"session":     id,
+		"remote-addr": ctx.RemoteAddr(),
+		"expires-at":  expiresAt,
+	}).Info("host issued join token")
+
+	return true, nil
+}
+
+func (s *sshd) policyHandler(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	b, err := proto.Marshal(newGetPolicyResponse(s.Policy))
 	if err != nil {
 		return false, []byte(err.Error())
 	}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/metrics/provider"
 	"github.com/owenthereal/upterm/upterm"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -83,3 +88,1154 @@ func Test_sshd_DisallowSession(t *testing.T) {
 		t.Fatalf("expect unsupported channel type error but got %v", err)
 	}
 }
+
+func Test_sshd_Policy(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := []byte("No customer data on shared sessions.")
+	sshd := &sshd{
+		SessionRepo: newSessionRepo(),
+		HostSigners: []ssh.Signer{signer},
+		NodeAddr:    addr,
+		Policy:      policy,
+		Logger:      logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ok, body, err := client.SendRequest(upterm.ServerPolicyRequestType, true, nil)
+	if err != nil || !ok {
+		t.Fatalf("error getting policy: %v", err)
+	}
+	var resp GetPolicyResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != string(policy) || resp.Hash != PolicyHash(policy) {
+		t.Fatalf("unexpected policy: %v", &resp)
+	}
+
+	createSession := func(hash string) (bool, []byte) {
+		b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", PolicyHash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ok, body
+	}
+
+	if ok, body := createSession("outdated"); ok || string(body) != ErrPolicyNotAgreed.Error() {
+		t.Fatalf("expect policy not agreed but got %t: %s", ok, body)
+	}
+	if ok, body := createSession(resp.Hash); !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+}
+
+func Test_sshd_MaxSessionAge(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessRepo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo:   sessRepo,
+		HostSigners:   []ssh.Signer{signer},
+		NodeAddr:      addr,
+		MaxSessionAge: 2 * time.Second,
+		Logger:        logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	if err != nil || !ok {
+		t.Fatalf("error creating session: %v %s", err, body)
+	}
+
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ExpiresAt == 0 {
+		t.Fatal("expect session to expire")
+	}
+
+	sess, err := sessRepo.Get(resp.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the session ends within the warning period
+	if !sess.Expiring(time.Now()) {
+		t.Fatalf("expect session expiring at %s", sess.ExpiresAt)
+	}
+
+	// the server closes the connection of the host when the session expires
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host connection closed after the session expires")
+	}
+}
+
+func Test_sshd_Hardened(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshd := &sshd{
+		SessionRepo:           newSessionRepo(),
+		HostSigners:           []ssh.Signer{signer},
+		NodeAddr:              addr,
+		StrictCrypto:          true,
+		RequireAuthorizedKeys: true,
+		Logger:                logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	weak := &ssh.ClientConfig{
+		Config:          ssh.Config{Ciphers: []string{"aes128-ctr"}},
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	if _, err := ssh.Dial("tcp", addr, weak); err == nil {
+		t.Fatal("expect clients with weak ciphers to be refused")
+	}
+
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	createSession := func(authorizedKeys [][]byte) (bool, []byte) {
+		b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", ClientAuthorizedKeys: authorizedKeys})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ok, body
+	}
+
+	if ok, body := createSession(nil); ok || string(body) != ErrAuthorizedKeysRequired.Error() {
+		t.Fatalf("expect authorized keys required but got %t: %s", ok, body)
+	}
+	if ok, body := createSession([][]byte{[]byte(TestPublicKeyContent)}); !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+}
+
+func Test_sshd_MemoryPressure(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var usage uint64 = 2000
+	sessRepo := newSessionRepo()
+	memory := newMemoryWatchdog(1000, true, sessRepo, provider.NewDiscardProvider(), logger)
+	memory.usage = func() (uint64, error) { return usage, nil }
+	memory.Check()
+
+	sshd := &sshd{
+		SessionRepo: sessRepo,
+		HostSigners: []ssh.Signer{signer},
+		NodeAddr:    addr,
+		Memory:      memory,
+		Logger:      logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ended := make(chan string, 1)
+	go func() {
+		for req := range reqs {
+			if req.Type == upterm.ServerSessionEndedRequestType {
+				ended <- string(req.Payload)
+			}
+			_ = req.Reply(true, nil)
+		}
+	}()
+	client := ssh.NewClient(cc, chans, nil)
+	defer client.Close()
+
+	createSession := func() (bool, []byte) {
+		b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ok, body
+	}
+
+	// sessions are refused under pressure
+	if ok, body := createSession(); ok || string(body) != ErrMemoryPressure.Error() {
+		t.Fatalf("expect memory pressure but got %t: %s", ok, body)
+	}
+
+	usage = 500
+	memory.Check()
+	if ok, body := createSession(); !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+
+	// idle sessions are evicted under pressure, notifying the host
+	usage = 2000
+	memory.Check()
+	select {
+	case reason := <-ended:
+		if reason != sessionEvictedReason {
+			t.Fatalf("want=%s got=%s", sessionEvictedReason, reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host notified of the eviction")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host connection closed after the eviction")
+	}
+}
+
+func Test_sshd_SessionIdleTimeout(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idleTimeout := 400 * time.Millisecond
+	sessRepo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo:        sessRepo,
+		HostSigners:        []ssh.Signer{signer},
+		NodeAddr:           addr,
+		SessionIdleTimeout: idleTimeout,
+		Logger:             logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ended := make(chan string, 1)
+	go func() {
+		for req := range reqs {
+			if req.Type == upterm.ServerSessionEndedRequestType {
+				ended <- string(req.Payload)
+			}
+			_ = req.Reply(true, nil)
+		}
+	}()
+	client := ssh.NewClient(cc, chans, nil)
+	defer client.Close()
+
+	b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	if err != nil || !ok {
+		t.Fatalf("error creating session: %v %s", err, body)
+	}
+
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions with connected clients are kept
+	disconnected := sessRepo.ClientConnected(resp.SessionID)
+	select {
+	case reason := <-ended:
+		t.Fatalf("expect session with clients kept but it ended: %s", reason)
+	case <-time.After(3 * idleTimeout):
+	}
+	disconnected()
+
+	select {
+	case reason := <-ended:
+		if want := "the session had no clients for 400ms"; reason != want {
+			t.Fatalf("want=%s got=%s", want, reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host notified of the idle session ending")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host connection closed after the idle session ends")
+	}
+}
+
+func Test_sshd_UpdateAuthorizedKeys(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo: repo,
+		HostSigners: []ssh.Signer{signer},
+		NodeAddr:    addr,
+		Logger:      logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		User:            "owen",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	updateKeys := func(keys string) (bool, []byte) {
+		ok, body, err := client.SendRequest(upterm.ServerUpdateAuthorizedKeysRequestType, true, []byte(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ok, body
+	}
+
+	if ok, _ := updateKeys(TestPublicKeyContent); ok {
+		t.Fatal("expect keys of connections without sessions to be refused")
+	}
+
+	b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", ClientAuthorizedKeys: [][]byte{[]byte(TestPublicKeyContent)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+	if err != nil || !ok {
+		t.Fatalf("error creating session: %v %s", err, body)
+	}
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, body := updateKeys(TestPublicKeyContent + "\n" + string(ssh.MarshalAuthorizedKey(otherKey))); !ok {
+		t.Fatalf("expect keys updated but got %s", body)
+	}
+
+	sess, err := repo.Get(resp.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sess.IsClientKeyAllowed(otherKey) || len(sess.ClientAuthorizedKeys) != 2 {
+		t.Fatalf("expect updated keys, got %d keys", len(sess.ClientAuthorizedKeys))
+	}
+
+	if ok, body := updateKeys(""); ok || string(body) != ErrAuthorizedKeysRequired.Error() {
+		t.Fatalf("expect removing all keys to be refused but got %t: %s", ok, body)
+	}
+	if sess, _ := repo.Get(resp.SessionID); len(sess.ClientAuthorizedKeys) != 2 {
+		t.Fatalf("expect keys kept, got %d keys", len(sess.ClientAuthorizedKeys))
+	}
+}
+
+func Test_sshd_HostACL(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := string(ssh.MarshalAuthorizedKey(otherSigner.PublicKey()))
+
+	cases := []struct {
+		name      string
+		acl       string
+		optOut    bool
+		wantErr   error
+		want      *HostFeatures
+		wantAudit bool
+	}{
+		{
+			name:      "granted",
+			acl:       `max-clients=3,sftp,recording-opt-out,labels="env=prod" ` + TestPublicKeyContent,
+			optOut:    true,
+			want:      &HostFeatures{MaxClients: 3, Sftp: true, RecordingOptOut: true, Labels: map[string]string{"env": "prod"}},
+			wantAudit: false,
+		},
+		{
+			name:      "opt out not granted",
+			acl:       "@default port-forwarding\n" + otherKey,
+			optOut:    true,
+			want:      &HostFeatures{PortForwarding: true},
+			wantAudit: true,
+		},
+		{
+			name:    "not allowed",
+			acl:     otherKey,
+			wantErr: ErrHostNotAllowed,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			addr := ln.Addr().String()
+
+			cs := UserCertSigner{
+				SessionID: "1234",
+				User:      "owen",
+				AuthRequest: &AuthRequest{
+					ClientVersion: upterm.HostSSHClientVersion,
+					RemoteAddr:    addr,
+					AuthorizedKey: []byte(TestPublicKeyContent),
+				},
+			}
+			certSigner, err := cs.SignCert(signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			acl, err := ParseHostACL([]byte(c.acl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo := newSessionRepo()
+			sshd := &sshd{
+				SessionRepo: repo,
+				HostSigners: []ssh.Signer{signer},
+				NodeAddr:    addr,
+				HostACL:     acl,
+				Auditor:     newAuditor(nil, addr, provider.NewDiscardProvider(), logger),
+				Logger:      logger,
+			}
+
+			go func() {
+				_ = sshd.Serve(ln)
+			}()
+
+			if err := utils.WaitForServer(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+				User:            "owen",
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", RecordingOptOut: c.optOut})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.wantErr != nil {
+				if ok || string(body) != c.wantErr.Error() {
+					t.Fatalf("expect %q but got %t: %s", c.wantErr, ok, body)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expect session created but got %s", body)
+			}
+
+			var resp CreateSessionResponse
+			if err := proto.Unmarshal(body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(resp.Features, c.want) {
+				t.Fatalf("want features %v, got %v", c.want, resp.Features)
+			}
+			if resp.Recorded != c.wantAudit {
+				t.Fatalf("want recorded %t, got %t", c.wantAudit, resp.Recorded)
+			}
+
+			sess, err := repo.Get(resp.SessionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(sess.Features, c.want) {
+				t.Fatalf("want session features %v, got %v", c.want, sess.Features)
+			}
+		})
+	}
+}
+
+func Test_sshd_HostQuotas(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl, err := ParseHostACL([]byte("max-clients=3 " + TestPublicKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas := &HostQuotas{
+		Default: HostQuota{MaxSessions: 5},
+		Hosts: map[string]HostQuota{
+			hostFingerprint(signer.PublicKey()): {MaxSessions: 1, MaxClients: 2, MaxSessionAge: time.Hour},
+		},
+	}
+	repo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo:   repo,
+		HostSigners:   []ssh.Signer{signer},
+		NodeAddr:      addr,
+		HostACL:       acl,
+		HostQuotas:    quotas,
+		MaxSessionAge: 2 * time.Hour,
+		Logger:        logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	createSession := func() (bool, []byte) {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+			User:            "owen",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ok, body
+	}
+
+	ok, body := createSession()
+	if !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	// the lower of the host ACL and the quota
+	if resp.MaxClients != 2 {
+		t.Fatalf("want max clients 2, got %d", resp.MaxClients)
+	}
+	// the lower of the max session age and the quota
+	if ttl := time.Until(time.Unix(resp.ExpiresAt, 0)); ttl > time.Hour || ttl < 59*time.Minute {
+		t.Fatalf("want the session expiring in an hour, got %s", ttl)
+	}
+	sess, err := repo.Get(resp.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.ClientLimit() != 2 || sess.HostFingerprint != utils.FingerprintSHA256(signer.PublicKey()) {
+		t.Fatalf("want the quota of the host, got max clients %d of %s", sess.ClientLimit(), sess.HostFingerprint)
+	}
+
+	ok, body = createSession()
+	if ok || string(body) != ErrHostQuotaExceeded.Error() {
+		t.Fatalf("expect %q but got %t: %s", ErrHostQuotaExceeded, ok, body)
+	}
+	if n := repo.Count(); n != 1 {
+		t.Fatalf("want 1 session, got %d", n)
+	}
+}
+
+func Test_sshd_SessionQueue(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessRepo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo: sessRepo,
+		HostSigners: []ssh.Signer{signer},
+		NodeAddr:    addr,
+		MaxSessions: 1,
+		Queue:       newSessionQueue(1, provider.NewDiscardProvider()),
+		Logger:      logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// createSession creates a session on a new host connection, sending positions in the queue to positions
+	createSession := func(queue bool, positions chan<- *QueuePosition) (bool, []byte) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return false, nil
+		}
+		config := &ssh.ClientConfig{
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+			User:            "owen",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+		cc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+		if err != nil {
+			t.Error(err)
+			return false, nil
+		}
+		go func() {
+			for req := range reqs {
+				var pos QueuePosition
+				if req.Type == upterm.ServerQueuePositionRequestType && proto.Unmarshal(req.Payload, &pos) == nil && positions != nil {
+					positions <- &pos
+				}
+			}
+		}()
+		client := ssh.NewClient(cc, chans, nil)
+		t.Cleanup(func() { client.Close() })
+
+		b, err := proto.Marshal(&CreateSessionRequest{HostUser: "owen", Queue: queue})
+		if err != nil {
+			t.Error(err)
+			return false, nil
+		}
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Error(err)
+			return false, nil
+		}
+
+		return ok, body
+	}
+
+	if ok, body := createSession(false, nil); !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+	sessions := sessRepo.List()
+
+	// hosts not asking to queue are refused at capacity
+	if ok, body := createSession(false, nil); ok || string(body) != ErrServerAtCapacity.Error() {
+		t.Fatalf("expect the server at capacity but got %t: %s", ok, body)
+	}
+
+	positions := make(chan *QueuePosition, 1)
+	created := make(chan bool, 1)
+	go func() {
+		ok, _ := createSession(true, positions)
+		created <- ok
+	}()
+	select {
+	case pos := <-positions:
+		if pos.Position != 1 || pos.Length != 1 {
+			t.Fatalf("want position 1 of 1, got %d of %d", pos.Position, pos.Length)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the host told its position in the queue")
+	}
+
+	// the queue is full
+	if ok, body := createSession(true, nil); ok || string(body) != ErrSessionQueueFull.Error() {
+		t.Fatalf("expect the queue full but got %t: %s", ok, body)
+	}
+
+	// the queued host is let in once the session ends
+	sessRepo.Delete(sessions[0].ID)
+	select {
+	case ok := <-created:
+		if !ok {
+			t.Fatal("expect the queued session created")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the queued host let in")
+	}
+	if want, got := 0, sshd.Queue.Len(); want != got {
+		t.Fatalf("want %d hosts in the queue, got %d", want, got)
+	}
+}
+
+func Test_sshd_SessionResume(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+
+	signer, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := UserCertSigner{
+		SessionID: "1234",
+		User:      "owen",
+		AuthRequest: &AuthRequest{
+			ClientVersion: upterm.HostSSHClientVersion,
+			RemoteAddr:    addr,
+			AuthorizedKey: []byte(TestPublicKeyContent),
+		},
+	}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessRepo := newSessionRepo()
+	sshd := &sshd{
+		SessionRepo:        sessRepo,
+		HostSigners:        []ssh.Signer{signer},
+		NodeAddr:           addr,
+		SessionResumeGrace: time.Second,
+		Logger:             logger,
+	}
+
+	go func() {
+		_ = sshd.Serve(ln)
+	}()
+
+	if err := utils.WaitForServer(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// createSession sends the request on a new host connection
+	createSession := func(req *CreateSessionRequest) (*ssh.Client, bool, []byte) {
+		config := &ssh.ClientConfig{
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+			User:            "owen",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+		client, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		b, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, body, err := client.SendRequest(upterm.ServerCreateSessionRequestType, true, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return client, ok, body
+	}
+
+	client, ok, body := createSession(&CreateSessionRequest{HostUser: "owen"})
+	if !ok {
+		t.Fatalf("expect session created but got %s", body)
+	}
+	var resp CreateSessionResponse
+	if err := proto.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ResumeToken == "" {
+		t.Fatal("want a resume token")
+	}
+
+	if _, ok, body := createSession(&CreateSessionRequest{HostUser: "owen", ResumeSessionId: resp.SessionID, ResumeToken: "wrong"}); ok || string(body) != ErrSessionNotResumable.Error() {
+		t.Fatalf("expect the session not resumable with a wrong token but got %t: %s", ok, body)
+	}
+
+	// the session outlives the connection of its host
+	client.Close()
+	client, ok, body = createSession(&CreateSessionRequest{HostUser: "owen", ResumeSessionId: resp.SessionID, ResumeToken: resp.ResumeToken})
+	if !ok {
+		t.Fatalf("expect session resumed but got %s", body)
+	}
+	var resumed CreateSessionResponse
+	if err := proto.Unmarshal(body, &resumed); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := resp.SessionID, resumed.SessionID; want != got {
+		t.Fatalf("want session %s resumed, got %s", want, got)
+	}
+
+	// the session is deleted once the grace passes
+	client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sessRepo.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the session deleted after the grace")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, ok, body := createSession(&CreateSessionRequest{HostUser: "owen", ResumeSessionId: resp.SessionID, ResumeToken: resp.ResumeToken}); ok || string(body) != ErrSessionNotResumable.Error() {
+		t.Fatalf("expect the deleted session not resumable but got %t: %s", ok, body)
+	}
+}
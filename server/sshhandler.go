@@ -1,12 +1,12 @@
 package server
 
 import (
-	"io"
 	"net"
 	"sync"
 
 	"github.com/charmbracelet/ssh"
 	"github.com/oklog/run"
+	libmetrics "github.com/owenthereal/upterm/metrics"
 	log "github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -29,11 +29,13 @@ type forwardedStreamlocalPayload struct {
 func newStreamlocalForwardHandler(
 	sessionRepo *sessionRepo,
 	sessionDialListener SessionDialListener,
+	relayer relayer,
 	logger log.FieldLogger,
 ) *streamlocalForwardHandler {
 	return &streamlocalForwardHandler{
 		sessionRepo:         sessionRepo,
 		sessionDialListener: sessionDialListener,
+		relayer:             relayer,
 		forwards:            make(map[string]net.Listener),
 		logger:              logger,
 	}
@@ -42,8 +44,10 @@ func newStreamlocalForwardHandler(
 type streamlocalForwardHandler struct {
 	sessionRepo         *sessionRepo
 	sessionDialListener SessionDialListener
-	forwards            map[string]net.Listener
-	logger              log.FieldLogger
+	// relayer copies the data of clients to and from the channels forwarded to hosts.
+	relayer  relayer
+	forwards map[string]net.Listener
+	logger   log.FieldLogger
 	sync.Mutex
 }
 
@@ -57,6 +61,9 @@ func (h *streamlocalForwardHandler) listen(ctx ssh.Context, ln net.Listener, ses
 		}
 
 		go func(sessionID string, logger log.FieldLogger) {
+			disconnected := h.sessionRepo.ClientConnected(sessionID)
+			defer disconnected()
+
 			payload := gossh.Marshal(&forwardedStreamlocalPayload{
 				SocketPath: sessionID,
 			})
@@ -67,38 +74,16 @@ func (h *streamlocalForwardHandler) listen(ctx ssh.Context, ln net.Listener, ses
 				return
 			}
 
-			closeAll := func() {
+			go gossh.DiscardRequests(reqs)
+
+			err = h.relayer.Relay(func() {
 				ch.Close()
 				c.Close()
-			}
-
-			var g run.Group
-			{
-				g.Add(func() error {
-					gossh.DiscardRequests(reqs)
-					return nil
-				}, func(err error) {
-					closeAll()
-				})
-			}
-			{
-				g.Add(func() error {
-					_, err := io.Copy(ch, c)
-					return err
-				}, func(err error) {
-					closeAll()
-				})
-			}
-			{
-				g.Add(func() error {
-					_, err := io.Copy(c, ch)
-					return err
-				}, func(err error) {
-					closeAll()
-				})
-			}
-
-			if err := g.Run(); err != nil {
+			},
+				pipe{dst: libmetrics.Writer(ch, h.sessionRepo.instruments.bytesToHost), src: c},
+				pipe{dst: libmetrics.Writer(c, h.sessionRepo.instruments.bytesToClient), src: ch},
+			)
+			if err != nil {
 				logger.WithError(err).Error("error listening connection")
 			}
 		}(sessionID, logger)
@@ -126,6 +111,11 @@ func (h *streamlocalForwardHandler) Handler(ctx ssh.Context, srv *ssh.Server, re
 			return false, []byte(err.Error())
 		}
 
+		// the dropped connection of a host resuming its session may still forward the session
+		if id, ok := ctx.Value(contextKeySessionID).(string); ok && id == sessionID {
+			h.closeListener(sessionID)
+		}
+
 		ln, err := h.sessionDialListener.Listen(sessionID)
 		if err != nil {
 			logger.WithError(err).Error("error listening socketing")
@@ -140,14 +130,14 @@ func (h *streamlocalForwardHandler) Handler(ctx ssh.Context, srv *ssh.Server, re
 				<-ctx.Done()
 				return ctx.Err()
 			}, func(err error) {
-				h.closeListener(sessionID)
+				h.untrackListener(sessionID, ln)
 			})
 		}
 		{
 			g.Add(func() error {
 				return h.listen(ctx, ln, sessionID, logger)
 			}, func(err error) {
-				h.closeListener(sessionID)
+				h.untrackListener(sessionID, ln)
 			})
 		}
 
@@ -167,6 +157,7 @@ func (h *streamlocalForwardHandler) Handler(ctx ssh.Context, srv *ssh.Server, re
 
 		sessionID := reqPayload.SocketPath
 		h.closeListener(sessionID)
+		h.sessionRepo.Delete(sessionID)
 
 		return true, nil
 
@@ -191,6 +182,16 @@ func (h *streamlocalForwardHandler) closeListener(sessionID string) {
 	}
 
 	delete(h.forwards, sessionID)
+}
 
-	h.sessionRepo.Delete(sessionID)
+// untrackListener closes ln of the session, untracking it unless the session is forwarded by another listener
+// meanwhile, e.g. once its host resumed it.
+func (h *streamlocalForwardHandler) untrackListener(sessionID string, ln net.Listener) {
+	h.Lock()
+	defer h.Unlock()
+
+	ln.Close()
+	if h.forwards[sessionID] == ln {
+		delete(h.forwards, sessionID)
+	}
 }
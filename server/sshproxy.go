@@ -1,25 +1,66 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/identity"
+	"github.com/owenthereal/upterm/routing"
 	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
 )
 
 type sshProxy struct {
-	HostSigners     []ssh.Signer
-	Signers         []ssh.Signer
+	HostSigners []ssh.Signer
+	Signers     []ssh.Signer
+	// SSHDHostKeys are the host keys of the sshd of the node, which hosts are piped to.
+	// The host keys of the proxy are accepted too, e.g. of neighbour nodes.
+	SSHDHostKeys []ssh.PublicKey
+	// UserCA signs the user certs the proxy authenticates upstream with if it's non-nil.
+	// Otherwise, the certs are signed by Signers.
+	UserCA ssh.Signer
+	// UserCAKeys are the keys user certs carrying auth requests of neighbour nodes must be signed by.
+	// Certs signed by any key are accepted if it's empty.
+	UserCAKeys      []ssh.PublicKey
 	NodeAddr        string
 	ConnDialer      connDialer
 	SessionRepo     *sessionRepo
 	Logger          log.FieldLogger
 	MetricsProvider provider.Provider
+	JoinLimits      JoinLimits
+	// ShadowAddr and ShadowRate configure shadowing connections to a canary proxy.
+	ShadowAddr string
+	ShadowRate float64
+	Janitor    *nodeJanitor
+	// Ingress tags connections with their transport.
+	Ingress *ingress
+	// StrictCrypto restricts SSH connections to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// Identities resolves the display names of clients if it's non-nil.
+	Identities identity.Resolver
+	// Tracer records a span per authentication of clients if it's non-nil.
+	Tracer trace.Tracer
+	// Auditor records clients authenticating and being rejected if it's non-nil.
+	Auditor *auditor
+	// ConnLimiter limits the connections of IPs if it's non-nil.
+	ConnLimiter *ipLimiter
+	// IPGuard denies the connections of IPs filtered out if it's non-nil.
+	IPGuard *ipGuard
+	// Authorizer decides whether clients may join sessions on this node if it's non-nil.
+	Authorizer Authorizer
+	// Done is closed once the node shuts down, after which connections still authenticating are rejected.
+	Done <-chan struct{}
 
 	routing *SSHRouting
 	mux     sync.Mutex
@@ -37,17 +78,40 @@ func (r *sshProxy) Shutdown() error {
 }
 
 func (r *sshProxy) Serve(ln net.Listener) error {
+	var shadower *shadower
+	if r.ShadowAddr != "" {
+		shadower = newShadower(ln.Addr().String(), r.ShadowAddr, r.ShadowRate, r.MetricsProvider, r.Logger.WithField("com", "shadow"))
+	}
+
 	r.mux.Lock()
 	r.routing = &SSHRouting{
 		HostSigners: r.HostSigners,
 		AuthPiper: &authPiper{
-			HostSigners: r.HostSigners,
-			Signers:     r.Signers,
-			SessionRepo: r.SessionRepo,
-			ConnDialer:  r.ConnDialer,
-			NodeAddr:    r.NodeAddr,
+			HostSigners:  r.HostSigners,
+			Signers:      r.Signers,
+			SSHDHostKeys: r.SSHDHostKeys,
+			UserCA:       r.UserCA,
+			UserCAKeys:   r.UserCAKeys,
+			SessionRepo:  r.SessionRepo,
+			ConnDialer:   r.ConnDialer,
+			NodeAddr:     r.NodeAddr,
+			JoinLimiter:  newJoinLimiter(r.JoinLimits),
+			JoinLimited:  r.MetricsProvider.NewCounter("routing_join_limited_count"),
+			Janitor:      r.Janitor,
+			Identities:   r.Identities,
+			Tracer:       r.Tracer,
+			Auditor:      r.Auditor,
+			Authorizer:   r.Authorizer,
+			Ingress:      r.Ingress,
+			Done:         r.Done,
+			Logger:       r.Logger.WithField("com", "auth"),
 		},
+		StrictCrypto:    r.StrictCrypto,
 		MetricsProvider: r.MetricsProvider,
+		Shadower:        shadower,
+		Ingress:         r.Ingress,
+		ConnLimiter:     r.ConnLimiter,
+		IPGuard:         r.IPGuard,
 		Logger:          r.Logger,
 	}
 	r.mux.Unlock()
@@ -61,20 +125,52 @@ type authPiper struct {
 	ConnDialer  connDialer
 	Signers     []ssh.Signer
 	HostSigners []ssh.Signer
+	// SSHDHostKeys are the host keys of the sshd of the node, which are accepted besides HostSigners
+	// for upstreams other than sessions.
+	SSHDHostKeys []ssh.PublicKey
+	// UserCA signs the user certs for upstreams if it's non-nil, and UserCAKeys check the certs of neighbours.
+	UserCA      ssh.Signer
+	UserCAKeys  []ssh.PublicKey
+	JoinLimiter *joinLimiter
+	JoinLimited metrics.Counter
+	// Janitor rejects clients of sessions on evicted nodes if it's non-nil.
+	Janitor *nodeJanitor
+	// Identities resolves the display names of clients, which are passed on to hosts, if it's non-nil.
+	Identities identity.Resolver
+	// Tracer records a span per authentication if it's non-nil. Spans of clients routed from other nodes
+	// continue the traces of the nodes.
+	Tracer trace.Tracer
+	// Auditor records clients authenticating to sessions on this node, and clients rejected by this node,
+	// if it's non-nil.
+	Auditor *auditor
+	// Authorizer decides whether clients may join sessions on this node if it's non-nil. Clients of sessions on
+	// other nodes are authorized by the nodes.
+	Authorizer Authorizer
+	// Ingress resolves the addresses of clients of connections relayed by the WebSocket proxy.
+	Ingress *ingress
+	// Done is closed once the node shuts down. Connections still authenticating are told the node is draining, for
+	// them to retry rather than to be disconnected without a reason.
+	Done   <-chan struct{}
+	Logger log.FieldLogger
 }
 
-func (a authPiper) PublicKeyCallback(conn ssh.ConnMetadata, pk ssh.PublicKey, challengeCtx ssh.ChallengeContext) (*ssh.Upstream, error) {
+func (a authPiper) PublicKeyCallback(conn ssh.ConnMetadata, pk ssh.PublicKey, challengeCtx ssh.ChallengeContext) (_ *ssh.Upstream, err error) {
+	actx := authContext(challengeCtx)
+	actx.Attempt()
+
 	checker := UserCertChecker{
 		UserKeyFallback: func(user string, key ssh.PublicKey) (ssh.PublicKey, error) {
 			return key, nil
 		},
+		Authorities: a.UserCAKeys,
 	}
 	auth, key, err := checker.Authenticate(conn.User(), pk)
 	if err == errCertNotSignedByHost {
 		err = nil
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error checking user cert: %w", err)
+	// neighbours route clients of any IP to this node, so they're exempt from the IPFilter
+	if actx.Filtered() && (auth == nil || !a.signedByNode(pk)) {
+		return nil, errIPDenied
 	}
 
 	// Use the public-key if a key can't be parsed from cert
@@ -82,41 +178,126 @@ func (a authPiper) PublicKeyCallback(conn ssh.ConnMetadata, pk ssh.PublicKey, ch
 		key = pk
 	}
 
+	ctx, span := startSpan(extractTraceContext(context.Background(), auth), a.Tracer, "sshproxy.Authenticate",
+		attrNodeAddr.String(a.NodeAddr),
+		attrRouted.Bool(auth != nil),
+		attrClientKey.String(utils.FingerprintSHA256(key)),
+	)
+	defer func() {
+		endSpan(span, err)
+		if err != nil {
+			a.audit(conn, key, auth, err)
+		}
+	}()
+
+	if err != nil {
+		return nil, fmt.Errorf("error checking user cert: %w", err)
+	}
+	if a.draining() {
+		r := NewRejection(RejectionServerDraining)
+		actx.Reject(r)
+		return nil, r
+	}
+	// neighbours are trusted by SSHRouting once they authenticate, which verifies the signatures of their certs
+	actx.Offer(auth != nil && a.signedByNode(pk))
+
+	// Limit joins of clients connecting to this node directly.
+	// Clients routed from other nodes have been limited by the nodes they connect to.
+	var release func()
 	if auth == nil {
+		var r *Rejection
+		release, r = a.limitJoin(conn, key)
+		if r != nil {
+			actx.Reject(r)
+			return nil, r
+		}
+	}
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
+	direct := auth == nil
+	if direct {
 		auth = &AuthRequest{
 			ClientVersion: string(conn.ClientVersion()),
-			RemoteAddr:    conn.RemoteAddr().String(),
+			RemoteAddr:    a.clientAddr(conn),
 			AuthorizedKey: ssh.MarshalAuthorizedKey(key),
+			NodeAddr:      a.NodeAddr,
 		}
 	}
 
 	hostSess, err := a.hostSession(conn)
 	if err != nil {
+		if r, ok := err.(*Rejection); ok {
+			actx.Reject(r)
+		}
 		return nil, err
 	}
 	// TODO: simplify auth key validation by moving it to host validation only
 	if hostSess != nil && !hostSess.IsClientKeyAllowed(key) {
-		return nil, fmt.Errorf("public key not allowed")
+		// an invite lets a client with any key in once. Results of auth are cached per key of a connection,
+		// so the token is only redeemed by the first attempt of the key.
+		_, token := routing.SplitToken(conn.User())
+		if token == "" || !a.SessionRepo.RedeemJoinToken(hostSess.ID, token, time.Now()) {
+			r := NewRejection(RejectionKeyNotAuthorized)
+			actx.Reject(r)
+			return nil, fmt.Errorf("public key not allowed: %w", r)
+		}
+		a.Logger.WithFields(log.Fields{
+			"session":     hostSess.ID,
+			"fingerprint": utils.FingerprintSHA256(key),
+		}).Info("client redeemed join token")
+	}
+	// the host ACL and the quota of the host cap the clients of the session
+	if hostSess != nil && hostSess.ClientLimit() > 0 && a.SessionRepo.Clients(hostSess.ID) >= hostSess.ClientLimit() {
+		r := NewRejection(RejectionQuotaExceeded)
+		actx.Reject(r)
+		return nil, fmt.Errorf("session is full: %w", r)
+	}
+	// clients routed from other nodes have been resolved by the nodes they connect to
+	if direct {
+		auth.DisplayName = a.resolveClient(conn, key)
+	}
+	if hostSess != nil {
+		if r := a.authorize(ctx, hostSess, key, auth); r != nil {
+			actx.Reject(r)
+			return nil, fmt.Errorf("client not authorized: %w", r)
+		}
 	}
 
+	// the next node continues the trace
+	injectTraceContext(ctx, auth)
 	signers, err := a.newUserCertSigners(conn, auth)
 	if err != nil {
 		return nil, fmt.Errorf("error creating cert signers: %w", err)
 	}
 
-	c, err := a.dialUpstream(conn)
+	c, err := a.dialUpstream(ctx, conn)
 	if err != nil {
 		return nil, fmt.Errorf("error dialing upstream: %w", err)
 	}
 
+	// keep the join reserved until the connection is closed
+	if release != nil {
+		actx.Hold(release)
+		release = nil
+	}
+
 	hostKeyCb := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 		if hostSess == nil {
-			// check host keys for sideway connections
+			// check host keys of the sshd and of neighbour nodes for sideway connections
 			for _, s := range a.HostSigners {
 				if utils.KeysEqual(key, s.PublicKey()) {
 					return nil
 				}
 			}
+			for _, pk := range a.SSHDHostKeys {
+				if utils.KeysEqual(key, pk) {
+					return nil
+				}
+			}
 		} else {
 			for _, pk := range hostSess.HostPublicKeys {
 				if utils.KeysEqual(key, pk) {
@@ -128,17 +309,280 @@ func (a authPiper) PublicKeyCallback(conn ssh.ConnMetadata, pk ssh.PublicKey, ch
 		return fmt.Errorf("ssh: host key mismatch")
 	}
 
+	// Capture rejections of the upstream node for sideway connections,
+	// so that they can be relayed to the client.
+	captureRejection := func(msg string) {
+		if r, ok := ParseRejection(msg); ok {
+			actx.Reject(r)
+		}
+	}
+
+	// clients of sessions on other nodes are audited by the nodes
+	if hostSess != nil {
+		a.audit(conn, key, auth, nil)
+	}
+
 	return &ssh.Upstream{
 		Conn:    c,
 		Address: conn.RemoteAddr().String(),
 		ClientConfig: ssh.ClientConfig{
 			HostKeyCallback: hostKeyCb,
-			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+			Auth: []ssh.AuthMethod{
+				ssh.PublicKeys(signers...),
+				ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+					captureRejection(instruction)
+					return nil, nil
+				}),
+			},
+			BannerCallback: func(msg string) error {
+				captureRejection(msg)
+				return nil
+			},
 		},
 	}, nil
 }
 
-func (a *authPiper) dialUpstream(conn ssh.ConnMetadata) (net.Conn, error) {
+// signedByNode reports whether pk is a user cert signed by a node of the cluster: by the user CA if the node has
+// one, or else by one of Signers, which nodes usually share. Auth callbacks run before the signature of the client
+// is verified, so the client isn't known to hold the key of the cert until it authenticates.
+func (a authPiper) signedByNode(pk ssh.PublicKey) bool {
+	cert, ok := pk.(*ssh.Certificate)
+	if !ok {
+		return false
+	}
+
+	key := cert.SignatureKey
+	if len(a.UserCAKeys) > 0 {
+		return slices.ContainsFunc(a.UserCAKeys, func(ca ssh.PublicKey) bool { return utils.KeysEqual(ca, key) })
+	}
+
+	return slices.ContainsFunc(a.Signers, func(s ssh.Signer) bool { return utils.KeysEqual(s.PublicKey(), key) })
+}
+
+// draining reports whether the node is shutting down.
+func (a authPiper) draining() bool {
+	select {
+	case <-a.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// clientAddr returns the address of the client of conn, which is the address of the client of the relay if conn is
+// relayed by the WebSocket proxy.
+func (a authPiper) clientAddr(conn ssh.ConnMetadata) string {
+	if a.Ingress == nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return a.Ingress.ClientAddr(conn.RemoteAddr())
+}
+
+// audit records a client authenticating with key, or being rejected if err is non-nil. Hosts aren't audited.
+// Clients routed from other nodes are recorded with the auth requests of the nodes.
+func (a authPiper) audit(conn ssh.ConnMetadata, key ssh.PublicKey, auth *AuthRequest, err error) {
+	if a.Auditor == nil {
+		return
+	}
+
+	ev := AuditEvent{
+		Type:          AuditClientAuthenticated,
+		RemoteAddr:    a.clientAddr(conn),
+		ClientVersion: string(conn.ClientVersion()),
+		Fingerprint:   utils.FingerprintSHA256(key),
+	}
+	// clients with malformed tokens are rejected without sessions
+	if id, derr := api.DecodeIdentifier(conn.User(), string(conn.ClientVersion())); derr == nil {
+		if id.Type != api.Identifier_CLIENT {
+			return
+		}
+		ev.SessionID = id.Id
+	}
+	if auth != nil {
+		ev.RemoteAddr = auth.RemoteAddr
+		ev.ClientVersion = auth.ClientVersion
+		ev.ClientName = auth.DisplayName
+		if auth.NodeAddr != a.NodeAddr {
+			ev.EntryNodeAddr = auth.NodeAddr
+		}
+	}
+	if err != nil {
+		ev.Type = AuditAuthRejected
+		ev.Reason = err.Error()
+		var r *Rejection
+		if errors.As(err, &r) {
+			ev.Reason = string(r.Code)
+		}
+	}
+
+	a.Auditor.Record(ev)
+}
+
+// BannerCallback rejects connections early if the rejection can be determined
+// before authentication, e.g. the session does not exist on this node.
+// Clients joining a session on this node that ends soon are warned.
+func (a authPiper) BannerCallback(conn ssh.ConnMetadata, challengeCtx ssh.ChallengeContext) string {
+	// connections of IPs denied by the IPFilter aren't told about sessions
+	if conn.User() == "" || authContext(challengeCtx).Filtered() {
+		return ""
+	}
+	if a.draining() {
+		actx := authContext(challengeCtx)
+		actx.Reject(NewRejection(RejectionServerDraining))
+		return actx.Deliver().String()
+	}
+
+	sess, err := a.hostSession(conn)
+	if err != nil {
+		if r, ok := err.(*Rejection); ok {
+			actx := authContext(challengeCtx)
+			actx.Reject(r)
+			return actx.Deliver().String()
+		}
+	}
+
+	// warn clients joining a session that is about to reach the max session age
+	if sess != nil && sess.Expiring(time.Now()) {
+		return expiryBanner(sess.ExpiresAt)
+	}
+
+	return ""
+}
+
+func expiryBanner(expiresAt time.Time) string {
+	return fmt.Sprintf("This session ends at %s by server policy.\n", expiresAt.UTC().Format(time.RFC1123))
+}
+
+// KeyboardInteractiveCallback is only offered to clients when a rejection is pending.
+// It delivers the rejection as the instruction of a challenge without questions.
+func (a authPiper) KeyboardInteractiveCallback(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge, challengeCtx ssh.ChallengeContext) (*ssh.Upstream, error) {
+	r := authContext(challengeCtx).Deliver()
+	if r == nil {
+		return nil, fmt.Errorf("keyboard-interactive auth not supported")
+	}
+
+	if _, err := client("", r.String(), nil, nil); err != nil {
+		return nil, err
+	}
+
+	return nil, r
+}
+
+// limitJoin enforces the join limits for a client joining with key.
+// It returns a func to release the join, or a rejection if a limit is exceeded.
+func (a authPiper) limitJoin(conn ssh.ConnMetadata, key ssh.PublicKey) (func(), *Rejection) {
+	if a.JoinLimiter == nil || !a.JoinLimiter.limits.enabled() {
+		return nil, nil
+	}
+
+	id, err := api.DecodeIdentifier(conn.User(), string(conn.ClientVersion()))
+	if err != nil || id.Type != api.Identifier_CLIENT {
+		return nil, nil
+	}
+
+	fp := utils.FingerprintSHA256(key)
+	if !a.JoinLimiter.Attempt(fp, id.Id, time.Now()) {
+		a.JoinLimited.Add(1)
+		return nil, NewRejection(RejectionRateLimited)
+	}
+
+	release, ok := a.JoinLimiter.Acquire(fp)
+	if !ok {
+		a.JoinLimited.Add(1)
+		return nil, NewRejection(RejectionRateLimited)
+	}
+
+	return release, nil
+}
+
+// authorize asks the Authorizer whether the client with key and auth may join sess. It returns a rejection if the
+// client is denied, or if the Authorizer fails, so that clients aren't let in when the policy can't be checked.
+func (a authPiper) authorize(ctx context.Context, sess *session, key ssh.PublicKey, auth *AuthRequest) *Rejection {
+	if a.Authorizer == nil {
+		return nil
+	}
+
+	req := AuthzRequest{
+		SessionID:   sess.ID,
+		HostUser:    sess.HostUser,
+		Labels:      sess.Labels,
+		Fingerprint: utils.FingerprintSHA256(key),
+		RemoteIP:    addrIP(auth.RemoteAddr),
+		ClientName:  auth.DisplayName,
+	}
+	logger := a.Logger.WithFields(log.Fields{"session": req.SessionID, "fingerprint": req.Fingerprint, "ip": req.RemoteIP})
+
+	ctx, cancel := context.WithTimeout(ctx, authzTimeout)
+	defer cancel()
+
+	resp, err := a.Authorizer.Authorize(ctx, req)
+	if err != nil {
+		logger.WithError(err).Error("error authorizing client")
+		return NewRejection(RejectionAccessDenied)
+	}
+	if resp.Allow {
+		return nil
+	}
+
+	logger.WithField("reason", resp.Reason).Info("client denied by authz hook")
+	r := NewRejection(RejectionAccessDenied)
+	// the reason is delivered on a line of its own
+	if reason, _, _ := strings.Cut(resp.Reason, "\n"); strings.TrimSpace(reason) != "" {
+		r.Message = strings.TrimSpace(reason)
+	}
+
+	return r
+}
+
+// resolveClient resolves the display name of a client connecting to this node directly.
+// Hosts are not resolved.
+func (a authPiper) resolveClient(conn ssh.ConnMetadata, key ssh.PublicKey) string {
+	if a.Identities == nil {
+		return ""
+	}
+
+	id, err := api.DecodeIdentifier(conn.User(), string(conn.ClientVersion()))
+	if err != nil || id.Type != api.Identifier_CLIENT {
+		return ""
+	}
+
+	fp := utils.FingerprintSHA256(key)
+	logger := a.Logger.WithFields(log.Fields{"session": id.Id, "addr": conn.RemoteAddr(), "fingerprint": fp})
+
+	name, err := a.Identities.Resolve(context.Background(), key)
+	if err != nil {
+		logger.WithError(err).Warn("error resolving client identity")
+		return ""
+	}
+
+	logger.WithField("client", identity.Describe(name, fp)).Info("client authenticated")
+	return name
+}
+
+// NextAuthMethods offers keyboard-interactive auth on top of public-key auth when
+// a rejection is pending, so that the rejection reason can be delivered to the client.
+func (a authPiper) NextAuthMethods(conn ssh.ConnMetadata, challengeCtx ssh.ChallengeContext) ([]string, error) {
+	authContext(challengeCtx).Attempt()
+
+	// Fail early if the user is not a valid identifier.
+	user := conn.User()
+	if user != "" {
+		_, err := api.DecodeIdentifier(user, string(conn.ClientVersion()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	methods := []string{"publickey"}
+	if authContext(challengeCtx).Pending() {
+		methods = append(methods, "keyboard-interactive")
+	}
+
+	return methods, nil
+}
+
+func (a *authPiper) dialUpstream(ctx context.Context, conn ssh.ConnMetadata) (net.Conn, error) {
 	var (
 		user = conn.User()
 	)
@@ -148,7 +592,11 @@ func (a *authPiper) dialUpstream(conn ssh.ConnMetadata) (net.Conn, error) {
 		return nil, fmt.Errorf("error decoding identifier from user %s: %w", user, err)
 	}
 
-	c, err := a.ConnDialer.Dial(id)
+	if id.Type == api.Identifier_CLIENT {
+		trace.SpanFromContext(ctx).SetAttributes(attrSessionID.String(id.Id), attrSessionNodeAddr.String(id.NodeAddr))
+	}
+
+	c, err := a.ConnDialer.Dial(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +611,7 @@ func (a authPiper) newUserCertSigners(conn ssh.ConnMetadata, auth *AuthRequest)
 			SessionID:   string(conn.SessionID()),
 			User:        conn.User(),
 			AuthRequest: auth,
+			CA:          a.UserCA,
 		}
 
 		cs, err := ucs.SignCert(s)
@@ -182,15 +631,23 @@ func (a *authPiper) hostSession(conn ssh.ConnMetadata) (*session, error) {
 	user := conn.User()
 	id, err := api.DecodeIdentifier(user, string(conn.ClientVersion()))
 	if err != nil {
-		return nil, fmt.Errorf("error decoding identifier from user %s: %w", user, err)
+		return nil, NewRejection(RejectionInvalidSession)
 	}
 
 	// Don't validate authorized key if:
 	// 1. This is not a client request
 	// 2. The node does not match the request that routing is needed
+	if id.Type == api.Identifier_CLIENT && a.Janitor.Evicted(id.NodeAddr) {
+		return nil, NewRejection(RejectionNodeUnavailable)
+	}
 	if id.Type != api.Identifier_CLIENT || a.NodeAddr != id.NodeAddr {
 		return nil, nil
 	}
 
-	return a.SessionRepo.Get(id.Id)
+	sess, err := a.SessionRepo.Get(id.Id)
+	if err != nil {
+		return nil, NewRejection(RejectionSessionNotFound)
+	}
+
+	return sess, nil
 }
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
+	"errors"
+	"io"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/owenthereal/upterm/host/api"
@@ -131,6 +135,136 @@ func Test_sshProxy_dialUpstream(t *testing.T) {
 	}
 }
 
+func Test_sshProxy_separateSSHDKeys(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signer
+	}
+	proxyKey, sshdKey, userCA := newSigner(), newSigner(), newSigner()
+
+	cases := []struct {
+		Name         string
+		SSHDHostKeys []ssh.PublicKey
+		SSHDUserCA   ssh.PublicKey
+		WantErr      bool
+	}{
+		{
+			Name:         "sshd host key and user CA trusted",
+			SSHDHostKeys: []ssh.PublicKey{sshdKey.PublicKey()},
+			SSHDUserCA:   userCA.PublicKey(),
+		},
+		{
+			Name:       "sshd host key unknown to proxy",
+			SSHDUserCA: userCA.PublicKey(),
+			WantErr:    true,
+		},
+		{
+			Name:         "user CA untrusted by sshd",
+			SSHDHostKeys: []ssh.PublicKey{sshdKey.PublicKey()},
+			SSHDUserCA:   proxyKey.PublicKey(),
+			WantErr:      true,
+		},
+	}
+
+	for _, c := range cases {
+		cc := c
+
+		t.Run(c.Name, func(t *testing.T) {
+			sshLn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sshLn.Close()
+
+			sshdAddr := sshLn.Addr().String()
+			sshd := &sshd{
+				HostSigners: []ssh.Signer{sshdKey},
+				UserCAKeys:  []ssh.PublicKey{cc.SSHDUserCA},
+				NodeAddr:    sshdAddr,
+				Logger:      logger,
+			}
+			go func() {
+				_ = sshd.Serve(sshLn)
+			}()
+
+			proxyLn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer proxyLn.Close()
+
+			proxyAddr := proxyLn.Addr().String()
+			proxy := &sshProxy{
+				HostSigners:  []ssh.Signer{proxyKey},
+				Signers:      []ssh.Signer{proxyKey},
+				SSHDHostKeys: cc.SSHDHostKeys,
+				UserCA:       userCA,
+				NodeAddr:     proxyAddr,
+				ConnDialer: sidewayConnDialer{
+					NodeAddr:        proxyAddr,
+					NeighbourDialer: tcpConnDialer{},
+					Logger:          logger,
+				},
+				Logger:          logger,
+				MetricsProvider: provider.NewDiscardProvider(),
+			}
+			go func() {
+				_ = proxy.Serve(proxyLn)
+			}()
+
+			for _, addr := range []string{sshdAddr, proxyAddr} {
+				if err := utils.WaitForServer(addr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user, err := api.EncodeIdentifier(&api.Identifier{
+				Id:       xid.New().String(),
+				Type:     api.Identifier_CLIENT,
+				NodeAddr: sshdAddr,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := &ssh.ClientConfig{
+				User:            user,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(newSigner())},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			}
+			client, err := ssh.Dial("tcp", proxyAddr, config) // proxy to sshd
+			if cc.WantErr {
+				if err == nil {
+					client.Close()
+					t.Fatal("expect the proxy to fail to reach the sshd")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// the client reaches the sshd, which refuses sessions
+			_, err = client.NewSession()
+			if err == nil || !strings.Contains(err.Error(), "unsupported channel type") {
+				t.Fatalf("expect unsupported channel type error but got %v", err)
+			}
+		})
+	}
+}
+
 func testCertSigner(user string, signer ssh.Signer) (ssh.Signer, error) {
 	cert := &ssh.Certificate{
 		Key:             signer.PublicKey(),
@@ -146,3 +280,430 @@ func testCertSigner(user string, signer ssh.Signer) (ssh.Signer, error) {
 
 	return ssh.NewCertSigner(cert, signer)
 }
+
+func Test_authPiper_MaxClients(t *testing.T) {
+	nodeAddr := "127.0.0.1:2222"
+	sessRepo := newSessionRepo()
+	if err := sessRepo.Add(session{ID: "session", Features: &HostFeatures{MaxClients: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := sessRepo.ClientConnected("session")
+	defer disconnected()
+
+	ap := authPiper{
+		NodeAddr:    nodeAddr,
+		SessionRepo: sessRepo,
+		Logger:      log.New(),
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: nodeAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := testConnMetadata{
+		user:          user,
+		clientVersion: "SSH-2.0-OpenSSH_9.6",
+		remoteAddr:    &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actx, err := newAuthChallengeContext(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ap.PublicKeyCallback(conn, key, actx); err == nil {
+		t.Fatal("expect the client of the full session rejected")
+	}
+	if r := authContext(actx).Deliver(); r == nil || r.Code != RejectionQuotaExceeded {
+		t.Fatalf("want rejection %s, got %v", RejectionQuotaExceeded, r)
+	}
+}
+
+func Test_authPiper_TrustNeighbours(t *testing.T) {
+	nodeSigner, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: "127.0.0.1:2222"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &AuthRequest{
+		ClientVersion: "SSH-2.0-OpenSSH_9.6",
+		RemoteAddr:    "192.0.2.1:1234",
+		AuthorizedKey: ssh.MarshalAuthorizedKey(nodeSigner.PublicKey()),
+	}
+
+	cases := []struct {
+		name string
+		// ca signs the cert, which is self-signed if it's nil
+		ca ssh.Signer
+		// other offers another key too
+		other         bool
+		wantNeighbour bool
+	}{
+		{
+			name:          "neighbour",
+			ca:            nodeSigner,
+			wantNeighbour: true,
+		},
+		{
+			// the auth request names the key of the node, but the cert isn't signed by it
+			name: "forged",
+		},
+		{
+			name: "other ca",
+			ca:   newSigner(),
+		},
+		{
+			// the cert may be queried without its key before authenticating with another key
+			name:  "other key",
+			ca:    nodeSigner,
+			other: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cs := UserCertSigner{SessionID: "session", User: user, AuthRequest: auth, CA: c.ca}
+			certSigner, err := cs.SignCert(newSigner())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ap := authPiper{
+				NodeAddr:    "127.0.0.1:2222",
+				Signers:     []ssh.Signer{nodeSigner},
+				SessionRepo: newSessionRepo(),
+				Logger:      log.New(),
+			}
+			conn := testConnMetadata{
+				user:          user,
+				clientVersion: "SSH-2.0-OpenSSH_9.6",
+				remoteAddr:    &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234},
+			}
+			actx, err := newAuthChallengeContext(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the session doesn't exist, only recognizing the node matters
+			_, _ = ap.PublicKeyCallback(conn, certSigner.PublicKey(), actx)
+			if c.other {
+				_, _ = ap.PublicKeyCallback(conn, newSigner().PublicKey(), actx)
+			}
+
+			if got := authContext(actx).Neighbour(); got != c.wantNeighbour {
+				t.Fatalf("want neighbour %t, got %t", c.wantNeighbour, got)
+			}
+		})
+	}
+}
+
+// remoteAddrListener accepts connections appearing to be from addr.
+type remoteAddrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l remoteAddrListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteAddrConn{Conn: conn, addr: l.addr}, nil
+}
+
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr { return c.addr }
+
+// querySigner offers the key of a cert without holding it, so that clients only query whether it's accepted.
+type querySigner struct {
+	pub ssh.PublicKey
+}
+
+func (s querySigner) PublicKey() ssh.PublicKey { return s.pub }
+func (s querySigner) Sign(io.Reader, []byte) (*ssh.Signature, error) {
+	return nil, errors.New("no private key")
+}
+
+func Test_SSHRouting_TrustNeighbours(t *testing.T) {
+	nodeSigner, err := ssh.ParsePrivateKey([]byte(TestPrivateKeyContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: "127.0.0.1:2222"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &AuthRequest{
+		ClientVersion: "SSH-2.0-OpenSSH_9.6",
+		RemoteAddr:    "192.0.2.1:1234",
+		AuthorizedKey: ssh.MarshalAuthorizedKey(nodeSigner.PublicKey()),
+	}
+	// a copy of a cert of a neighbour, e.g. captured from a debug log
+	cs := UserCertSigner{SessionID: "session", User: user, AuthRequest: auth, CA: nodeSigner}
+	certSigner, err := cs.SignCert(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := newIPLimiter(ConnLimits{MaxConnsPerIP: 1}, provider.NewDiscardProvider(), log.New())
+	routing := &SSHRouting{
+		HostSigners: []ssh.Signer{nodeSigner},
+		AuthPiper: &authPiper{
+			NodeAddr:    "127.0.0.1:2222",
+			Signers:     []ssh.Signer{nodeSigner},
+			SessionRepo: newSessionRepo(),
+			Logger:      log.New(),
+		},
+		MetricsProvider: provider.NewDiscardProvider(),
+		ConnLimiter:     limiter,
+		Logger:          log.New(),
+	}
+	go func() {
+		_ = routing.Serve(remoteAddrListener{Listener: ln, addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234}})
+	}()
+	defer routing.Shutdown()
+
+	_, err = ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(querySigner{pub: certSigner.PublicKey()})},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		t.Fatal("expect authenticating without the key of the cert to fail")
+	}
+
+	limiter.mu.Lock()
+	trusted := limiter.trustedLocked("192.0.2.2", time.Now())
+	limiter.mu.Unlock()
+	if trusted {
+		t.Fatal("expect the ip querying the cert of a neighbour not trusted")
+	}
+}
+
+func Test_sshProxy_IPFilterNeighbours(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.DebugLevel
+
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signer
+	}
+	proxyKey, userCA := newSigner(), newSigner()
+
+	sshLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshLn.Close()
+
+	sshdAddr := sshLn.Addr().String()
+	sshd := &sshd{
+		HostSigners: []ssh.Signer{proxyKey},
+		UserCAKeys:  []ssh.PublicKey{userCA.PublicKey()},
+		NodeAddr:    sshdAddr,
+		Logger:      logger,
+	}
+	go func() {
+		_ = sshd.Serve(sshLn)
+	}()
+
+	filter, err := ParseIPFilter(nil, []string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyLn.Close()
+
+	proxyAddr := proxyLn.Addr().String()
+	proxy := &sshProxy{
+		HostSigners: []ssh.Signer{proxyKey},
+		Signers:     []ssh.Signer{proxyKey},
+		UserCA:      userCA,
+		NodeAddr:    proxyAddr,
+		ConnDialer: sidewayConnDialer{
+			NodeAddr:        proxyAddr,
+			NeighbourDialer: tcpConnDialer{},
+			Logger:          logger,
+		},
+		IPGuard:         newIPGuard(filter, provider.NewDiscardProvider()),
+		Logger:          logger,
+		MetricsProvider: provider.NewDiscardProvider(),
+	}
+	// connections are from a denied ip
+	go func() {
+		_ = proxy.Serve(remoteAddrListener{Listener: proxyLn, addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234}})
+	}()
+
+	for _, addr := range []string{sshdAddr, proxyAddr} {
+		if err := utils.WaitForServer(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{
+		Id:       xid.New().String(),
+		Type:     api.Identifier_CLIENT,
+		NodeAddr: sshdAddr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := ssh.Dial("tcp", proxyAddr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newSigner())},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		client.Close()
+		t.Fatal("expect clients of a denied ip to be refused")
+	}
+
+	// a neighbour node routing a client of another ip
+	clientKey := newSigner()
+	cs := UserCertSigner{
+		SessionID: "session",
+		User:      user,
+		AuthRequest: &AuthRequest{
+			ClientVersion: "SSH-2.0-OpenSSH_9.6",
+			RemoteAddr:    "198.51.100.1:1234",
+			AuthorizedKey: ssh.MarshalAuthorizedKey(clientKey.PublicKey()),
+			NodeAddr:      "192.0.2.2:2222",
+		},
+		CA: proxyKey,
+	}
+	certSigner, err := cs.SignCert(newSigner())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = ssh.Dial("tcp", proxyAddr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("expect neighbours of a denied ip to route clients: %v", err)
+	}
+	defer client.Close()
+
+	// the neighbour reaches the sshd, which refuses sessions
+	_, err = client.NewSession()
+	if err == nil || !strings.Contains(err.Error(), "unsupported channel type") {
+		t.Fatalf("expect unsupported channel type error but got %v", err)
+	}
+}
+
+func Test_authPiper_Draining(t *testing.T) {
+	done := make(chan struct{})
+	ap := authPiper{
+		NodeAddr:    "127.0.0.1:2222",
+		SessionRepo: newSessionRepo(),
+		Done:        done,
+		Logger:      log.New(),
+	}
+
+	user, err := api.EncodeIdentifier(&api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: ap.NodeAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := testConnMetadata{
+		user:          user,
+		clientVersion: "SSH-2.0-OpenSSH_9.6",
+		remoteAddr:    &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actx, err := newAuthChallengeContext(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ap.PublicKeyCallback(conn, key, actx)
+	var r *Rejection
+	if !errors.As(err, &r) || r.Code != RejectionSessionNotFound {
+		t.Fatalf("want rejection %s before the node shuts down, got %v", RejectionSessionNotFound, err)
+	}
+
+	close(done)
+
+	actx, err = newAuthChallengeContext(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	banner := ap.BannerCallback(conn, actx)
+	if r, ok := ParseRejection(banner); !ok || r.Code != RejectionServerDraining {
+		t.Fatalf("want banner of rejection %s, got %q", RejectionServerDraining, banner)
+	}
+
+	actx, err = newAuthChallengeContext(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ap.PublicKeyCallback(conn, key, actx)
+	if !errors.As(err, &r) || r.Code != RejectionServerDraining {
+		t.Fatalf("want rejection %s, got %v", RejectionServerDraining, err)
+	}
+	if r := authContext(actx).Deliver(); r == nil || r.Code != RejectionServerDraining {
+		t.Fatalf("want rejection %s delivered, got %v", RejectionServerDraining, r)
+	}
+}
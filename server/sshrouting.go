@@ -4,13 +4,14 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/provider"
-	"github.com/owenthereal/upterm/host/api"
 	libmetrics "github.com/owenthereal/upterm/metrics"
 	"github.com/owenthereal/upterm/upterm"
+	"github.com/owenthereal/upterm/utils"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
@@ -20,11 +21,26 @@ var (
 	pipeEstablishingTimeout = 3 * time.Second
 )
 
+// SSHRouting pipes SSH connections to the node hosting the session after authenticating them.
+// Connections are relayed packet by packet, so channels of any type, e.g. terminals, exec, subsystems
+// like sftp, and port forwards, reach the host unchanged whichever node clients connect to.
 type SSHRouting struct {
 	HostSigners     []ssh.Signer
 	AuthPiper       *authPiper
 	Logger          log.FieldLogger
 	MetricsProvider provider.Provider
+	// Shadower, if non-nil, mirrors the handshakes of connections to a canary proxy.
+	Shadower *shadower
+	// Ingress tags connections with their transport. It's created from MetricsProvider if it's nil.
+	Ingress *ingress
+	// StrictCrypto restricts SSH connections to the algorithms of utils.StrictCryptoConfig.
+	StrictCrypto bool
+	// ConnLimiter refuses connections of IPs exceeding ConnLimits, and bans IPs failing to authenticate, if it's
+	// non-nil. Failures of connections relayed by the WebSocket proxy count towards the IPs of their clients.
+	ConnLimiter *ipLimiter
+	// IPGuard denies the connections of IPs filtered out if it's non-nil. They're let through the SSH handshake for
+	// neighbour nodes routing clients to authenticate, but can't authenticate otherwise.
+	IPGuard *ipGuard
 
 	listener net.Listener
 	mux      sync.Mutex
@@ -37,6 +53,8 @@ type routingInstruments struct {
 	connectionDuration metrics.Histogram
 	errors             metrics.Counter
 	connectionTimeouts metrics.Counter
+	// handshakeFailures are connections failing the SSH handshake with the proxy or the upstream.
+	handshakeFailures metrics.Counter
 }
 
 func newSSHRoutingInstruments(p provider.Provider) *routingInstruments {
@@ -46,6 +64,7 @@ func newSSHRoutingInstruments(p provider.Provider) *routingInstruments {
 		activeConnections:  p.NewGauge("routing_active_connections_count"),
 		connectionDuration: p.NewHistogram("routing_connection_duration_ms", 50),
 		connectionTimeouts: p.NewCounter("routing_connection_timeout_count"),
+		handshakeFailures:  p.NewCounter("routing_handshake_failure_count"),
 	}
 }
 
@@ -55,26 +74,37 @@ func (p *SSHRouting) Serve(ln net.Listener) error {
 	p.mux.Unlock()
 
 	piperCfg := &ssh.PiperConfig{
-		PublicKeyCallback: p.AuthPiper.PublicKeyCallback,
-		ServerVersion:     upterm.ServerSSHServerVersion,
-		NextAuthMethods: func(conn ssh.ConnMetadata, challengeCtx ssh.ChallengeContext) ([]string, error) {
-			// Fail early if the user is not a valid identifier.
-			user := conn.User()
-			if user != "" {
-				_, err := api.DecodeIdentifier(user, string(conn.ClientVersion()))
-				if err != nil {
-					return nil, err
-				}
+		PublicKeyCallback:           p.AuthPiper.PublicKeyCallback,
+		KeyboardInteractiveCallback: p.AuthPiper.KeyboardInteractiveCallback,
+		BannerCallback:              p.AuthPiper.BannerCallback,
+		NextAuthMethods:             p.AuthPiper.NextAuthMethods,
+		CreateChallengeContext:      newAuthChallengeContext,
+		ServerVersion:               upterm.ServerSSHServerVersion,
+	}
+	if p.StrictCrypto {
+		piperCfg.Config = utils.StrictCryptoConfig()
+		piperCfg.PublicKeyAuthAlgorithms = utils.StrictPublicKeyAuthAlgorithms
+	}
+	if p.Shadower != nil {
+		// The banner callback is the first callback that knows the user of the connection.
+		piperCfg.BannerCallback = func(conn ssh.ConnMetadata, challengeCtx ssh.ChallengeContext) string {
+			if !authContext(challengeCtx).Filtered() {
+				p.Shadower.Shadow(conn)
 			}
-
-			return []string{"publickey"}, nil
-		},
+			return p.AuthPiper.BannerCallback(conn, challengeCtx)
+		}
 	}
 	for _, s := range p.HostSigners {
 		piperCfg.AddHostKey(s)
 	}
 
 	inst := newSSHRoutingInstruments(p.MetricsProvider)
+	if p.Ingress == nil {
+		p.Ingress = newIngress(p.MetricsProvider)
+	}
+	if p.AuthPiper.Ingress == nil {
+		p.AuthPiper.Ingress = p.Ingress
+	}
 
 	var tempDelay time.Duration // how long to sleep on accept failure
 	for {
@@ -108,10 +138,16 @@ func (p *SSHRouting) Serve(ln net.Listener) error {
 		tempDelay = 0
 
 		logger := p.Logger.WithField("addr", dconn.RemoteAddr())
+		if err := p.ConnLimiter.Allow(connIP(dconn), time.Now()); err != nil {
+			logger.WithError(err).Debug("refused connection")
+			_ = dconn.Close()
+			continue
+		}
 		go func(dconn net.Conn, inst *routingInstruments, logger log.FieldLogger) {
 			defer dconn.Close()
 
-			defer libmetrics.MeasureSince(inst.connectionDuration, time.Now())
+			start := time.Now()
+			defer libmetrics.MeasureSince(inst.connectionDuration, start)
 			defer inst.activeConnections.Add(-1)
 			inst.connections.Add(1)
 			inst.activeConnections.Add(1)
@@ -119,13 +155,30 @@ func (p *SSHRouting) Serve(ln net.Listener) error {
 			pipec := make(chan *ssh.PiperConn)
 			errorc := make(chan error)
 
+			// neighbour nodes route clients of any IP, so they may authenticate from IPs denied by the IPFilter
+			filtered := !p.IPGuard.Allowed(connIP(dconn))
+
+			// release resources held by the connection, e.g. concurrent joins, when it is closed
+			var actx atomic.Pointer[authChallengeContext]
+			cfg := *piperCfg
+			cfg.CreateChallengeContext = func(conn ssh.ConnMetadata) (ssh.ChallengeContext, error) {
+				ctx, err := newAuthChallengeContext(conn)
+				if filtered {
+					authContext(ctx).Filter()
+				}
+				actx.Store(authContext(ctx))
+				return ctx, err
+			}
+			defer func() { actx.Load().Release() }()
+
+			sconn := &sniffConn{Conn: dconn}
 			go func() {
 				defer func() {
 					close(pipec)
 					close(errorc)
 				}()
 
-				pconn, err := ssh.NewSSHPiperConn(dconn, piperCfg)
+				pconn, err := ssh.NewSSHPiperConn(sconn, &cfg)
 				if err != nil {
 					errorc <- err
 					return
@@ -134,20 +187,76 @@ func (p *SSHRouting) Serve(ln net.Listener) error {
 				pipec <- pconn
 			}()
 
+			// Connections relayed by the WebSocket proxy are counted by the proxy.
+			// Their handshake failures are accounted for here under the original transport.
+			var (
+				t       transport
+				relayed bool
+				tinst   *transportInstruments
+			)
+			classify := func() {
+				t, relayed = p.Ingress.Transport(dconn)
+				tinst = p.Ingress.Instruments(t)
+				logger = logger.WithFields(t.logFields())
+				if !relayed {
+					tinst.connections.Add(1)
+				}
+			}
+
 			select {
 			case pconn := <-pipec:
 				defer pconn.Close()
 
+				// neighbour nodes routing clients to this node are exempt from ConnLimits
+				if actx.Load().Neighbour() {
+					p.ConnLimiter.Trust(connIP(dconn), time.Now())
+				}
+
+				classify()
+				if !relayed {
+					tinst.activeConnections.Add(1)
+					defer tinst.activeConnections.Add(-1)
+					defer libmetrics.MeasureSince(tinst.connectionDuration, start)
+				}
+
 				if err := pconn.Wait(); err != nil {
 					logger.WithError(err).Debug("error waiting for pipe")
 					inst.errors.Add(1)
+					tinst.errors.Add(1)
 				}
 			case err := <-errorc:
+				classify()
 				logger.WithError(err).Debug("connection establishing failed")
 				inst.errors.Add(1)
+				tinst.errors.Add(1)
+				if filtered {
+					logger.WithError(errIPDenied).Debug("denied connection")
+					p.IPGuard.Deny()
+				} else if actx.Load().AuthFailed() {
+					p.ConnLimiter.Fail(p.Ingress.ClientIP(dconn), time.Now())
+				}
+
+				switch {
+				case sconn.Mismatched():
+					logger.Info("connection of another protocol than ssh")
+					tinst.mismatches.Add(1)
+				case isEarlyEOF(err):
+					tinst.earlyEOFs.Add(1)
+					inst.handshakeFailures.Add(1)
+				default:
+					inst.handshakeFailures.Add(1)
+				}
 			case <-time.After(pipeEstablishingTimeout):
+				classify()
 				logger.Debug("pipe establishing timeout")
 				inst.connectionTimeouts.Add(1)
+				if filtered {
+					p.IPGuard.Deny()
+				}
+				if sconn.Mismatched() {
+					logger.Info("connection of another protocol than ssh")
+					tinst.mismatches.Add(1)
+				}
 			}
 		}(dconn, inst, logger)
 	}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// tcpTuner sets the socket options of the TCP connections of hosts and clients arriving at the node, and of
+// the connections to neighbour nodes, counting the connections tuned and the ones failing to be tuned.
+type tcpTuner struct {
+	tuning utils.TCPTuning
+	tuned  metrics.Counter
+	errors metrics.Counter
+	logger log.FieldLogger
+}
+
+func newTCPTuner(t utils.TCPTuning, p provider.Provider, logger log.FieldLogger) *tcpTuner {
+	return &tcpTuner{
+		tuning: t,
+		tuned:  p.NewCounter("tcp_tuned_connection_count"),
+		errors: p.NewCounter("tcp_tuning_error_count"),
+		logger: logger,
+	}
+}
+
+// Listener returns a listener tuning the connections accepted by ln. It returns ln if t is nil.
+func (t *tcpTuner) Listener(ln net.Listener) net.Listener {
+	if t == nil || ln == nil {
+		return ln
+	}
+
+	return t.tuning.TunedListener(ln, func(net.Conn) {
+		t.tuned.Add(1)
+	}, t.failed)
+}
+
+// Apply tunes conn. Errors are logged and counted without failing the connection, since the options are
+// optimizations, e.g. a congestion control algorithm may not be permitted on the node. It's a no-op if t is nil.
+func (t *tcpTuner) Apply(conn net.Conn) {
+	if t == nil {
+		return
+	}
+
+	if err := t.tuning.Apply(conn); err != nil {
+		t.failed(conn, err)
+		return
+	}
+	t.tuned.Add(1)
+}
+
+func (t *tcpTuner) failed(conn net.Conn, err error) {
+	t.errors.Add(1)
+	t.logger.WithError(err).WithField("addr", conn.RemoteAddr()).Warn("error tuning tcp connection")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_tcpTuner(t *testing.T) {
+	logger := log.New()
+	logger.SetLevel(log.PanicLevel)
+
+	cases := []struct {
+		name       string
+		tuning     utils.TCPTuning
+		wantTuned  float64
+		wantErrors float64
+	}{
+		{
+			name:      "tuned",
+			tuning:    utils.TCPTuning{NoDelay: true, SendBuffer: 1 << 20, RecvBuffer: 1 << 20},
+			wantTuned: 1,
+		},
+		{
+			name:       "unknown congestion control",
+			tuning:     utils.TCPTuning{NoDelay: true, CongestionControl: "no-such-algorithm"},
+			wantErrors: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tuner := newTCPTuner(c.tuning, provider.NewDiscardProvider(), logger)
+			tuned, errors := generic.NewCounter("tuned"), generic.NewCounter("errors")
+			tuner.tuned, tuner.errors = tuned, errors
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln = tuner.Listener(ln)
+			defer ln.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatalf("want connections failing to be tuned accepted, got %s", err)
+			}
+			defer conn.Close()
+
+			if want, got := c.wantTuned, tuned.Value(); want != got {
+				t.Fatalf("want %v tuned connections, got %v", want, got)
+			}
+			if want, got := c.wantErrors, errors.Value(); want != got {
+				t.Fatalf("want %v tuning errors, got %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_tcpTuner_nil(t *testing.T) {
+	var tuner *tcpTuner
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if got := tuner.Listener(ln); got != ln {
+		t.Fatal("want a nil tuner to return the listener")
+	}
+	tuner.Apply(nil)
+}
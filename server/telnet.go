@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/owenthereal/upterm/host/api"
+	"github.com/owenthereal/upterm/routing"
+	"github.com/owenthereal/upterm/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+
+	// telnetLoginTimeout is how long viewers have to log in and the session has to be joined.
+	telnetLoginTimeout = 30 * time.Second
+	// telnetMaxLogin is the longest login, which is the username of a session with a join token.
+	telnetMaxLogin   = 512
+	telnetTermWidth  = 80
+	telnetTermHeight = 24
+)
+
+var errTelnetLoginTooLong = errors.New("login is too long")
+
+// telnetBridge lets viewers watch sessions over telnet-over-TLS, e.g. from classroom tools that only speak telnet.
+// It's another front-end of the ssh proxy: viewers log in with the username clients join a session with, and the
+// bridge joins the session through the ssh proxy of the node with a throwaway key, so that sessions are routed,
+// limited, and audited like for any client. Unauthorized keys are only let in with an invite carried by the login,
+// e.g. of a share link. The bridge only relays sessions whose hosts let viewers watch, and hosts discard the input
+// of the bridge.
+//
+// It's insecure compared to joining over SSH: the node decrypts the output of the sessions it relays, and viewers
+// verify the TLS certificate of the node rather than the host keys of sessions.
+type telnetBridge struct {
+	// SSHAddr is the address of the ssh proxy of the node sessions are joined through.
+	SSHAddr net.Addr
+	// HostKeys are the host keys of the ssh proxy.
+	HostKeys []ssh.PublicKey
+	Logger   log.FieldLogger
+
+	viewers  metrics.Counter
+	rejected metrics.Counter
+
+	ln  net.Listener
+	mux sync.Mutex
+}
+
+func newTelnetBridge(sshAddr net.Addr, hostKeys []ssh.PublicKey, p provider.Provider, logger log.FieldLogger) *telnetBridge {
+	return &telnetBridge{
+		SSHAddr:  sshAddr,
+		HostKeys: hostKeys,
+		Logger:   logger,
+		viewers:  p.NewCounter("telnet_viewer_count"),
+		rejected: p.NewCounter("telnet_rejected_count"),
+	}
+}
+
+// Serve serves viewers connecting to ln, which is expected to terminate TLS, until it's closed.
+func (b *telnetBridge) Serve(ln net.Listener) error {
+	b.mux.Lock()
+	b.ln = ln
+	b.mux.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go b.handle(conn)
+	}
+}
+
+func (b *telnetBridge) Shutdown() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.ln == nil {
+		return nil
+	}
+
+	return b.ln.Close()
+}
+
+func (b *telnetBridge) handle(conn net.Conn) {
+	defer conn.Close()
+
+	logger := b.Logger.WithField("addr", conn.RemoteAddr())
+	_ = conn.SetDeadline(time.Now().Add(telnetLoginTimeout))
+
+	if _, err := io.WriteString(conn, "upterm telnet bridge, sessions are watched read-only\r\nlogin: "); err != nil {
+		return
+	}
+	login, err := newTelnetReader(conn).ReadLine(telnetMaxLogin)
+	if err != nil {
+		logger.WithError(err).Debug("error reading telnet login")
+		return
+	}
+
+	client, sess, stdout, err := b.join(login)
+	if err != nil {
+		b.rejected.Add(1)
+		logger.WithError(err).Info("rejected telnet viewer")
+		msg := "unable to join the session"
+		var r *Rejection
+		if errors.As(err, &r) {
+			msg = r.Message
+		}
+		_, _ = fmt.Fprintf(conn, "\r\nupterm: %s\r\n", msg)
+		return
+	}
+	defer client.Close()
+
+	_ = conn.SetDeadline(time.Time{})
+	b.viewers.Add(1)
+	// the login may carry an invite, which isn't logged
+	user, _ := routing.SplitToken(login)
+	logger = logger.WithField("user", user)
+	logger.Info("telnet viewer joined")
+	defer logger.Info("telnet viewer left")
+
+	// the bridge echoes nothing, so that keystrokes of viewers don't garble the terminal
+	if _, err := conn.Write([]byte{telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetWILL, telnetOptSGA}); err != nil {
+		return
+	}
+
+	go func() {
+		// input of viewers is discarded until they disconnect
+		_, _ = io.Copy(io.Discard, conn)
+		_ = sess.Close()
+		_ = client.Close()
+	}()
+
+	if _, err := io.Copy(telnetWriter{conn}, stdout); err != nil {
+		logger.WithError(err).Debug("error relaying session to telnet viewer")
+	}
+}
+
+// join joins the session of login as a view-only client. It returns a *Rejection if the ssh proxy rejects it.
+func (b *telnetBridge) join(login string) (*ssh.Client, *ssh.Session, io.Reader, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	conn, err := net.DialTimeout(b.SSHAddr.Network(), b.SSHAddr.String(), telnetLoginTimeout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(telnetLoginTimeout))
+
+	var rejection *Rejection
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, b.SSHAddr.String(), &ssh.ClientConfig{
+		User: login,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				if r, ok := ParseRejection(instruction); ok {
+					rejection = r
+				}
+				return nil, nil
+			}),
+		},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, k := range b.HostKeys {
+				if utils.KeysEqual(key, k) {
+					return nil
+				}
+			}
+			return fmt.Errorf("ssh: host key mismatch")
+		},
+		BannerCallback: func(msg string) error {
+			if r, ok := ParseRejection(msg); ok {
+				rejection = r
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		conn.Close()
+		if rejection != nil {
+			return nil, nil, nil, rejection
+		}
+		return nil, nil, nil, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	sess, stdout, err := b.view(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return client, sess, stdout, nil
+}
+
+func (b *telnetBridge) view(client *ssh.Client) (*ssh.Session, io.Reader, error) {
+	agreed, err := api.NegotiateFeatures(client, map[string]string{api.FeatureViewOnly: "true"})
+	if err != nil {
+		return nil, nil, err
+	}
+	if agreed[api.FeatureViewOnly] != "true" {
+		return nil, nil, &Rejection{Code: RejectionKeyNotAuthorized, Message: "the host doesn't let viewers watch this session over telnet"}
+	}
+
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	// stdin is left open until the session is closed, since hosts end the sessions of clients sending EOF
+	if _, err := sess.StdinPipe(); err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	if err := sess.RequestPty("xterm", telnetTermHeight, telnetTermWidth, ssh.TerminalModes{}); err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	if err := sess.Shell(); err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+
+	return sess, stdout, nil
+}
+
+// telnetReader reads the data of a telnet connection, skipping commands and option negotiations.
+type telnetReader struct {
+	r *bufio.Reader
+}
+
+func newTelnetReader(r io.Reader) *telnetReader {
+	return &telnetReader{r: bufio.NewReader(r)}
+}
+
+// ReadByte returns the next byte of data, skipping commands.
+func (t *telnetReader) ReadByte() (byte, error) {
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil || c != telnetIAC {
+			return c, err
+		}
+
+		cmd, err := t.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch cmd {
+		case telnetIAC:
+			return telnetIAC, nil
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			if _, err := t.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		case telnetSB:
+			if err := t.skipSubnegotiation(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+func (t *telnetReader) skipSubnegotiation() error {
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != telnetIAC {
+			continue
+		}
+
+		if c, err = t.r.ReadByte(); err != nil {
+			return err
+		}
+		if c == telnetSE {
+			return nil
+		}
+	}
+}
+
+// ReadLine reads a line of at most max bytes, ended by CR or LF, applying backspaces.
+func (t *telnetReader) ReadLine(max int) (string, error) {
+	var line []byte
+	for {
+		c, err := t.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch c {
+		case '\r', '\n':
+			return strings.TrimSpace(string(line)), nil
+		case '\b', 0x7f:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case 0:
+		default:
+			if len(line) >= max {
+				return "", errTelnetLoginTooLong
+			}
+			line = append(line, c)
+		}
+	}
+}
+
+// telnetWriter writes data to a telnet connection, escaping bytes that would be read as commands.
+type telnetWriter struct {
+	w io.Writer
+}
+
+func (t telnetWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, telnetIAC) < 0 {
+		return t.w.Write(p)
+	}
+
+	escaped := bytes.ReplaceAll(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})
+	if _, err := t.w.Write(escaped); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
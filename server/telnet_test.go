@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_telnetReader_ReadLine(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "line",
+			input: []byte("user\r\n"),
+			want:  "user",
+		},
+		{
+			name:  "negotiations",
+			input: append([]byte{telnetIAC, telnetDO, telnetOptEcho, 'u', telnetIAC, telnetWILL, telnetOptSGA, 's'}, []byte("er\r\x00")...),
+			want:  "user",
+		},
+		{
+			name:  "subnegotiation",
+			input: append([]byte{telnetIAC, telnetSB, 31, 0, 80, 0, 24, telnetIAC, telnetSE}, []byte("user\n")...),
+			want:  "user",
+		},
+		{
+			name:  "escaped IAC and backspaces",
+			input: []byte{'u', 'x', 0x7f, 's', telnetIAC, telnetIAC, '\b', 'e', 'r', '\r'},
+			want:  "user",
+		},
+		{
+			name:    "too long",
+			input:   []byte(strings.Repeat("u", telnetMaxLogin+1) + "\r\n"),
+			wantErr: errTelnetLoginTooLong,
+		},
+		{
+			name:    "eof",
+			input:   []byte("user"),
+			wantErr: io.EOF,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := newTelnetReader(bytes.NewReader(c.input)).ReadLine(telnetMaxLogin)
+			if err != c.wantErr {
+				t.Fatalf("want error %v, got %v", c.wantErr, err)
+			}
+			if got != c.want {
+				t.Fatalf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_telnetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := telnetWriter{&buf}
+
+	p := []byte{'a', telnetIAC, 'b'}
+	n, err := w.Write(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(p) {
+		t.Fatalf("want %d bytes written, got %d", len(p), n)
+	}
+	if want, got := []byte{'a', telnetIAC, telnetIAC, 'b'}, buf.Bytes(); !bytes.Equal(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func Test_telnetBridge_unableToJoin(t *testing.T) {
+	logger := log.New()
+	logger.SetLevel(log.PanicLevel)
+
+	// nothing listens on the ssh address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshAddr := ln.Addr()
+	ln.Close()
+
+	b := newTelnetBridge(sshAddr, nil, provider.NewDiscardProvider(), logger)
+	rejected := generic.NewCounter("rejected")
+	b.rejected = rejected
+
+	client, server := net.Pipe()
+	go b.handle(server)
+
+	go func() {
+		_, _ = io.WriteString(client, "user\r\n")
+	}()
+	out, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), "login: ") || !strings.Contains(string(out), "upterm: unable to join the session") {
+		t.Fatalf("want a login prompt and an error, got %q", out)
+	}
+	if want, got := 1.0, rejected.Value(); want != got {
+		t.Fatalf("want %v rejected viewers, got %v", want, got)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// routeTTL is how long a route between nodes is reported after it's last seen.
+	routeTTL = 15 * time.Minute
+	// TopologyPath is the path of the topology of a node on the metric server.
+	TopologyPath = "/topology"
+)
+
+// NodeTopology is a node's view of the cluster.
+type NodeTopology struct {
+	NodeAddr string `json:"node_addr"`
+	// Version is the uptermd version of the node. It's empty for nodes predating it.
+	Version string `json:"version,omitempty"`
+	// Sessions is the number of sessions hosted on the node.
+	Sessions int `json:"sessions"`
+	// Routes are the connections the node routed to neighbour nodes recently.
+	Routes []Route `json:"routes"`
+	// Error is set if the node can't be queried.
+	Error string `json:"error,omitempty"`
+}
+
+// Route is connections routed from a node to the node hosting the session.
+type Route struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func newRouteRecorder(nodeAddr string) *routeRecorder {
+	return &routeRecorder{
+		nodeAddr: nodeAddr,
+		routes:   make(map[string]*Route),
+	}
+}
+
+// routeRecorder records the connections a node routes to neighbour nodes.
+type routeRecorder struct {
+	nodeAddr string
+
+	mu     sync.Mutex
+	routes map[string]*Route
+}
+
+func (r *routeRecorder) Record(to string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	route, ok := r.routes[to]
+	if !ok {
+		route = &Route{From: r.nodeAddr, To: to}
+		r.routes[to] = route
+	}
+	route.Count++
+	route.LastSeen = now
+}
+
+// Forget drops the route to a node, e.g. when the node is evicted.
+func (r *routeRecorder) Forget(to string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.routes, to)
+}
+
+// Routes returns the routes seen within routeTTL, dropping older ones.
+func (r *routeRecorder) Routes(now time.Time) []Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var routes []Route
+	for to, route := range r.routes {
+		if now.Sub(route.LastSeen) > routeTTL {
+			delete(r.routes, to)
+			continue
+		}
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].To < routes[j].To
+	})
+
+	return routes
+}
+
+func topologyHandler(topology func() NodeTopology) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(topology())
+	})
+}
+
+// Topology is the cluster topology aggregated from the nodes.
+type Topology struct {
+	Nodes []NodeTopology
+}
+
+// FetchTopology queries the topology of each node from its metric server URL, e.g. http://10.0.0.1:9090.
+// Nodes that fail to respond are included with Error set.
+func FetchTopology(ctx context.Context, client *http.Client, urls []string) *Topology {
+	nodes := make([]NodeTopology, len(urls))
+
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+
+			node, err := fetchNodeTopology(ctx, client, u)
+			if err != nil {
+				node = &NodeTopology{NodeAddr: u, Error: err.Error()}
+			}
+			nodes[i] = *node
+		}(i, u)
+	}
+	wg.Wait()
+
+	return &Topology{Nodes: nodes}
+}
+
+func fetchNodeTopology(ctx context.Context, client *http.Client, u string) (*NodeTopology, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(u, "/")+TopologyPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var node NodeTopology
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// WriteDOT writes the topology as a Graphviz DOT graph. Nodes only known as route destinations
+// are drawn dashed, which often points to a node missing from the queried ones.
+func (t *Topology) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph uptermd {\n")
+	b.WriteString("  node [shape=box];\n")
+
+	known := make(map[string]bool)
+	for _, n := range t.Nodes {
+		known[n.NodeAddr] = true
+
+		label := dotLabel(n.NodeAddr, fmt.Sprintf("%d session(s)", n.Sessions))
+		attrs := ""
+		if n.Error != "" {
+			label = dotLabel(n.NodeAddr, "unreachable")
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(&b, "  %q [label=%s%s];\n", n.NodeAddr, label, attrs)
+	}
+
+	var unknown []string
+	for _, n := range t.Nodes {
+		for _, r := range n.Routes {
+			if !known[r.To] {
+				known[r.To] = true
+				unknown = append(unknown, r.To)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	for _, addr := range unknown {
+		fmt.Fprintf(&b, "  %q [style=dashed];\n", addr)
+	}
+
+	for _, n := range t.Nodes {
+		for _, r := range n.Routes {
+			fmt.Fprintf(&b, "  %q -> %q [label=\"%d\"];\n", r.From, r.To, r.Count)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotLabel returns the quoted DOT label of lines, which are escaped like the IDs of nodes and separated by line
+// breaks of DOT.
+func dotLabel(lines ...string) string {
+	quoted := make([]string, len(lines))
+	for i, l := range lines {
+		q := strconv.Quote(l)
+		quoted[i] = q[1 : len(q)-1]
+	}
+
+	return `"` + strings.Join(quoted, `\n`) + `"`
+}
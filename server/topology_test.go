@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_routeRecorder(t *testing.T) {
+	r := newRouteRecorder("10.0.0.1:22")
+	now := time.Now()
+
+	r.Record("10.0.0.2:22", now.Add(-routeTTL-time.Second))
+	r.Record("10.0.0.3:22", now.Add(-time.Minute))
+	r.Record("10.0.0.3:22", now)
+
+	want := []Route{{From: "10.0.0.1:22", To: "10.0.0.3:22", Count: 2, LastSeen: now}}
+	if diff := cmp.Diff(want, r.Routes(now)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_FetchTopology(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	node := NodeTopology{
+		NodeAddr: "10.0.0.1:22",
+		Sessions: 2,
+		Routes:   []Route{{From: "10.0.0.1:22", To: "10.0.0.2:22", Count: 3, LastSeen: now}},
+	}
+	srv := httptest.NewServer(topologyHandler(func() NodeTopology { return node }))
+	defer srv.Close()
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	defer unreachable.Close()
+
+	topo := FetchTopology(context.Background(), srv.Client(), []string{srv.URL, unreachable.URL})
+	if len(topo.Nodes) != 2 {
+		t.Fatalf("want 2 nodes, got %d", len(topo.Nodes))
+	}
+	if diff := cmp.Diff(node, topo.Nodes[0]); diff != "" {
+		t.Fatal(diff)
+	}
+	if topo.Nodes[1].Error == "" {
+		t.Fatal("want error for unreachable node")
+	}
+
+	var b bytes.Buffer
+	if err := (&Topology{Nodes: []NodeTopology{node}}).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	wantDOT := `digraph uptermd {
+  node [shape=box];
+  "10.0.0.1:22" [label="10.0.0.1:22\n2 session(s)"];
+  "10.0.0.2:22" [style=dashed];
+  "10.0.0.1:22" -> "10.0.0.2:22" [label="3"];
+}
+`
+	if diff := cmp.Diff(wantDOT, b.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// labels are escaped like the IDs of nodes
+	b.Reset()
+	if err := (&Topology{Nodes: []NodeTopology{{NodeAddr: `evil"];\n`, Error: "unreachable"}}}).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	wantDOT = `digraph uptermd {
+  node [shape=box];
+  "evil\"];\\n" [label="evil\"];\\n\nunreachable", color=red];
+}
+`
+	if diff := cmp.Diff(wantDOT, b.String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
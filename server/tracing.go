@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/owenthereal/upterm/upterm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/owenthereal/upterm/server"
+
+// Span attributes of joins, so that traces can be searched by session or node.
+const (
+	attrSessionID       = attribute.Key("upterm.session.id")
+	attrSessionNodeAddr = attribute.Key("upterm.session.node_addr")
+	attrNodeAddr        = attribute.Key("upterm.node.addr")
+	attrClientKey       = attribute.Key("upterm.client.fingerprint")
+	attrRouted          = attribute.Key("upterm.routed")
+	attrDialTarget      = attribute.Key("upterm.dial.target")
+)
+
+// tracePropagator carries trace contexts between nodes in auth requests, and from clients in HTTP headers.
+var tracePropagator = propagation.TraceContext{}
+
+// newTracerProvider returns a provider exporting the spans of the node to the OTLP/HTTP endpoint,
+// e.g. http://otel-collector:4318.
+func newTracerProvider(ctx context.Context, endpoint, nodeAddr string) (*sdktrace.TracerProvider, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OpenTelemetry endpoint %q, e.g. http://localhost:4318", endpoint)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OpenTelemetry exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(
+		semconv.ServiceName("uptermd"),
+		semconv.ServiceVersion(upterm.Version),
+		attrNodeAddr.String(nodeAddr),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// startSpan starts a span with the tracer, or a span that isn't recorded if the tracer is nil, e.g. in tests.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it failed if err is non-nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext records the trace context of ctx in the auth request passed on to the next node.
+func injectTraceContext(ctx context.Context, auth *AuthRequest) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	auth.TraceContext = carrier
+}
+
+// extractTraceContext continues the trace of the node that routed the auth request, if any.
+func extractTraceContext(ctx context.Context, auth *AuthRequest) context.Context {
+	if auth == nil || len(auth.TraceContext) == 0 {
+		return ctx
+	}
+
+	return tracePropagator.Extract(ctx, propagation.MapCarrier(auth.TraceContext))
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_traceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	// the node the client connects to passes on its trace in the auth request
+	ctx, span := startSpan(context.Background(), tracer, "sshproxy.Authenticate")
+	auth := &AuthRequest{ClientVersion: "SSH-2.0-test"}
+	injectTraceContext(ctx, auth)
+	span.End()
+
+	b, err := proto.Marshal(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routed AuthRequest
+	if err := proto.Unmarshal(b, &routed); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node of the session continues it
+	_, span = startSpan(extractTraceContext(context.Background(), &routed), tracer, "sshproxy.Authenticate")
+	span.End()
+
+	spans := recorder.Ended()
+	if want, got := 2, len(spans); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+	if want, got := spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID(); want != got {
+		t.Fatalf("want trace=%s got=%s", want, got)
+	}
+	if want, got := spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID(); want != got {
+		t.Fatalf("want parent=%s got=%s", want, got)
+	}
+
+	// auth requests of old nodes have no trace context
+	if got := extractTraceContext(context.Background(), &AuthRequest{}); got != context.Background() {
+		t.Fatal("want context unchanged without trace context")
+	}
+}
+
+type failingConnDialer struct {
+	err error
+}
+
+func (d failingConnDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	return nil, d.err
+}
+
+func Test_sidewayConnDialer_Trace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	cd := sidewayConnDialer{
+		NodeAddr:        "10.0.0.1:22",
+		NeighbourDialer: failingConnDialer{err: errors.New("connection refused")},
+		Tracer:          tracer,
+		Logger:          log.New(),
+	}
+	if _, err := cd.Dial(context.Background(), &api.Identifier{Id: "session", Type: api.Identifier_CLIENT, NodeAddr: "10.0.0.2:22"}); err == nil {
+		t.Fatal("want error dialing neighbour")
+	}
+
+	spans := recorder.Ended()
+	if want, got := 1, len(spans); want != got {
+		t.Fatalf("want=%d got=%d", want, got)
+	}
+	s := spans[0]
+	if want, got := codes.Error, s.Status().Code; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if want, got := "neighbour", attrs[string(attrDialTarget)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+	if want, got := "10.0.0.2:22", attrs[string(attrSessionNodeAddr)]; want != got {
+		t.Fatalf("want=%s got=%s", want, got)
+	}
+}
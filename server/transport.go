@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// transport is how a connection arrives at a node.
+type transport string
+
+const (
+	transportSSH transport = "ssh"
+	transportWS  transport = "ws"
+	transportWSS transport = "wss"
+)
+
+var transports = []transport{transportSSH, transportWS, transportWSS}
+
+// sshIdentPrefix is how an SSH client starts a connection.
+var sshIdentPrefix = []byte("SSH-")
+
+// TLS reports whether the connection is encrypted with TLS, terminated by uptermd or an edge proxy.
+// SSH connections are encrypted by SSH itself.
+func (t transport) TLS() bool {
+	return t == transportWSS
+}
+
+// logFields are the fields tagging logs of connections arriving with t.
+func (t transport) logFields() log.Fields {
+	return log.Fields{"transport": t, "tls": t.TLS()}
+}
+
+// requestTransport returns the transport of a WebSocket request. TLS is usually terminated by an edge proxy,
+// which reports the original protocol with X-Forwarded-Proto.
+func requestTransport(r *http.Request) transport {
+	if r.TLS != nil {
+		return transportWSS
+	}
+
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
+		return transportWSS
+	}
+
+	return transportWS
+}
+
+type transportInstruments struct {
+	connections        metrics.Counter
+	activeConnections  metrics.Gauge
+	connectionDuration metrics.Histogram
+	errors             metrics.Counter
+	// earlyEOFs are connections closed before the SSH handshake completes.
+	earlyEOFs metrics.Counter
+	// mismatches are connections speaking another protocol than the endpoint, e.g. HTTP on the SSH port.
+	mismatches metrics.Counter
+	// downgrades are WebSocket requests that arrive without the upgrade, e.g. stripped by a proxy.
+	downgrades metrics.Counter
+}
+
+// ingress tags connections with the transport they arrive with. Connections of the WebSocket proxy
+// relayed to the SSH proxy on the same node are tracked by their local addresses, so that the SSH proxy
+// accounts for their handshakes under the original transport.
+type ingress struct {
+	instruments map[transport]*transportInstruments
+	relayed     sync.Map
+}
+
+func newIngress(p provider.Provider) *ingress {
+	i := &ingress{instruments: make(map[transport]*transportInstruments)}
+	for _, t := range transports {
+		i.instruments[t] = &transportInstruments{
+			connections:        p.NewCounter(fmt.Sprintf("%s_connections_count", t)),
+			activeConnections:  p.NewGauge(fmt.Sprintf("%s_active_connections_count", t)),
+			connectionDuration: p.NewHistogram(fmt.Sprintf("%s_connection_duration_ms", t), 50),
+			errors:             p.NewCounter(fmt.Sprintf("%s_errors_count", t)),
+			earlyEOFs:          p.NewCounter(fmt.Sprintf("%s_early_eof_count", t)),
+			mismatches:         p.NewCounter(fmt.Sprintf("%s_mismatch_count", t)),
+			downgrades:         p.NewCounter(fmt.Sprintf("%s_downgrade_count", t)),
+		}
+	}
+
+	return i
+}
+
+func (i *ingress) Instruments(t transport) *transportInstruments {
+	return i.instruments[t]
+}
+
+// Relay records that conn relays a connection of the client at clientAddr that arrived with t until the returned
+// func is called. Only TCP connections are tracked since other addresses aren't unique.
+func (i *ingress) Relay(conn net.Conn, t transport, clientAddr string) func() {
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return func() {}
+	}
+
+	key := addr.String()
+	i.relayed.Store(key, relayedConn{transport: t, clientAddr: clientAddr})
+
+	return func() {
+		i.relayed.Delete(key)
+	}
+}
+
+// relayedConn is a connection relayed to the SSH proxy.
+type relayedConn struct {
+	transport  transport
+	clientAddr string
+}
+
+// Transport returns the transport of a connection accepted by the SSH proxy.
+// It's only reliable once data has been read from conn, which the relay sends after it's recorded.
+func (i *ingress) Transport(conn net.Conn) (transport, bool) {
+	if r, ok := i.relayed.Load(conn.RemoteAddr().String()); ok {
+		return r.(relayedConn).transport, true
+	}
+
+	return transportSSH, false
+}
+
+// ClientIP returns the IP of the client of a connection accepted by the SSH proxy, which is the IP of the client
+// of the relay for relayed connections. It's empty if the client is the node itself.
+// Like Transport, it's only reliable once data has been read from conn.
+func (i *ingress) ClientIP(conn net.Conn) string {
+	if r, ok := i.relayed.Load(conn.RemoteAddr().String()); ok {
+		return addrIP(r.(relayedConn).clientAddr)
+	}
+
+	return connIP(conn)
+}
+
+// ClientAddr returns the address of the client of a connection accepted by the SSH proxy from addr, the remote
+// address of the connection, which is the address of the client of the relay for relayed connections.
+// Like Transport, it's only reliable once data has been read from the connection.
+func (i *ingress) ClientAddr(addr net.Addr) string {
+	if r, ok := i.relayed.Load(addr.String()); ok {
+		return r.(relayedConn).clientAddr
+	}
+
+	return addr.String()
+}
+
+// isEarlyEOF reports whether err is a connection closed by the peer.
+func isEarlyEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sniffConn records the first bytes read from a connection to detect clients speaking another protocol.
+type sniffConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	prefix []byte
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.mu.Lock()
+	if missing := len(sshIdentPrefix) - len(c.prefix); missing > 0 && n > 0 {
+		c.prefix = append(c.prefix, p[:min(n, missing)]...)
+	}
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Mismatched reports whether the peer has sent data that isn't the start of an SSH connection.
+func (c *sniffConn) Mismatched() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.prefix) > 0 && !bytes.HasPrefix(sshIdentPrefix, c.prefix)
+}
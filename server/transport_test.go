@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/metrics/provider"
+	"github.com/gorilla/websocket"
+	"github.com/owenthereal/upterm/host/api"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_requestTransport(t *testing.T) {
+	cases := []struct {
+		name   string
+		tls    bool
+		header string
+		want   transport
+	}{
+		{name: "plain", want: transportWS},
+		{name: "tls", tls: true, want: transportWSS},
+		{name: "edge tls", header: "https", want: transportWSS},
+		{name: "edge tls chain", header: "HTTPS, http", want: transportWSS},
+		{name: "edge plain", header: "http", want: transportWS},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if c.header != "" {
+				r.Header.Set("X-Forwarded-Proto", c.header)
+			}
+
+			if got := requestTransport(r); got != c.want {
+				t.Fatalf("want transport %s, got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_sniffConn(t *testing.T) {
+	cases := []struct {
+		data string
+		want bool
+	}{
+		{data: "SSH-2.0-OpenSSH_9.6\r\n", want: false},
+		{data: "SS", want: false},
+		{data: "GET / HTTP/1.1\r\n", want: true},
+		{data: "\x16\x03\x01", want: true},
+	}
+
+	for _, c := range cases {
+		client, server := net.Pipe()
+		sc := &sniffConn{Conn: server}
+
+		go func() {
+			_, _ = client.Write([]byte(c.data))
+			client.Close()
+		}()
+
+		buf := make([]byte, 1)
+		for {
+			if _, err := sc.Read(buf); err != nil {
+				break
+			}
+		}
+
+		if got := sc.Mismatched(); got != c.want {
+			t.Fatalf("data %q: want mismatched %t, got %t", c.data, c.want, got)
+		}
+	}
+}
+
+func Test_ingress_Relay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	relay, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	i := newIngress(provider.NewDiscardProvider())
+	if tr, relayed := i.Transport(accepted); tr != transportSSH || relayed {
+		t.Fatalf("want direct ssh, got %s relayed=%t", tr, relayed)
+	}
+	// connections from the node itself have no client IP
+	if ip := i.ClientIP(accepted); ip != "" {
+		t.Fatalf("want no client ip, got %s", ip)
+	}
+
+	release := i.Relay(relay, transportWSS, "203.0.113.1:1234")
+	if tr, relayed := i.Transport(accepted); tr != transportWSS || !relayed {
+		t.Fatalf("want relayed wss, got %s relayed=%t", tr, relayed)
+	}
+	if want, got := "203.0.113.1", i.ClientIP(accepted); want != got {
+		t.Fatalf("want client ip %s of the relay, got %s", want, got)
+	}
+	if want, got := "203.0.113.1:1234", i.ClientAddr(accepted.RemoteAddr()); want != got {
+		t.Fatalf("want client addr %s of the relay, got %s", want, got)
+	}
+
+	release()
+	if tr, relayed := i.Transport(accepted); tr != transportSSH || relayed {
+		t.Fatalf("want direct ssh after release, got %s relayed=%t", tr, relayed)
+	}
+	if want, got := accepted.RemoteAddr().String(), i.ClientAddr(accepted.RemoteAddr()); want != got {
+		t.Fatalf("want client addr %s after release, got %s", want, got)
+	}
+}
+
+func Test_wsHandler_Failures(t *testing.T) {
+	i := newIngress(provider.NewDiscardProvider())
+	inst := i.Instruments(transportWS)
+	downgrades, mismatches := generic.NewCounter("downgrades"), generic.NewCounter("mismatches")
+	inst.downgrades, inst.mismatches = downgrades, mismatches
+
+	logger := log.New()
+	logger.SetLevel(log.PanicLevel)
+	ts := httptest.NewServer(&wsHandler{
+		ConnDialer: testRecordingConnDialer{ids: make(chan *api.Identifier, 1)},
+		Ingress:    i,
+		Logger:     logger,
+	})
+	defer ts.Close()
+
+	// a proxy stripping the upgrade
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want, got := http.StatusBadRequest, resp.StatusCode; want != got {
+		t.Fatalf("want status %d, got %d", want, got)
+	}
+	if want, got := 1.0, downgrades.Value(); want != got {
+		t.Fatalf("want %v downgrades, got %v", want, got)
+	}
+
+	// a WebSocket client that isn't upterm
+	if _, _, err := websocket.DefaultDialer.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"), nil); err == nil {
+		t.Fatal("want error dialing without upterm headers")
+	}
+	if want, got := 1.0, mismatches.Value(); want != got {
+		t.Fatalf("want %v mismatches, got %v", want, got)
+	}
+}
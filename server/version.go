@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// parseVersion parses a version in the form of MAJOR.MINOR.PATCH with an optional v prefix.
+// Pre-release and build suffixes are ignored.
+func parseVersion(v string) ([3]int, error) {
+	var parsed [3]int
+
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("invalid version %q", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid version %q", v)
+		}
+		parsed[i] = n
+	}
+
+	return parsed, nil
+}
+
+// versionAtLeast reports whether version v is at least min.
+func versionAtLeast(v, min [3]int) bool {
+	for i := range v {
+		if v[i] != min[i] {
+			return v[i] > min[i]
+		}
+	}
+
+	return true
+}
+
+// CheckPeerVersions verifies the nodes of the cluster run at least version min before a node joins it.
+// Peers are the metric server URLs of the nodes, e.g. http://10.0.0.2:9090. Unreachable peers are
+// skipped, e.g. when the cluster is first started, but peers too old to report their version fail the check.
+func CheckPeerVersions(ctx context.Context, client *http.Client, peers []string, min string, logger log.FieldLogger) error {
+	minVersion, err := parseVersion(min)
+	if err != nil {
+		return err
+	}
+
+	var incompatible []string
+	for _, node := range FetchTopology(ctx, client, peers).Nodes {
+		if node.Error != "" {
+			logger.WithField("peer", node.NodeAddr).WithField("error", node.Error).Warn("skipped version check of unreachable peer")
+			continue
+		}
+
+		if node.Version == "" {
+			incompatible = append(incompatible, fmt.Sprintf("%s (unknown)", node.NodeAddr))
+			continue
+		}
+		v, err := parseVersion(node.Version)
+		if err != nil || !versionAtLeast(v, minVersion) {
+			incompatible = append(incompatible, fmt.Sprintf("%s (%s)", node.NodeAddr, node.Version))
+		}
+	}
+
+	if len(incompatible) > 0 {
+		return fmt.Errorf("peers run versions older than %s: %s", min, strings.Join(incompatible, ", "))
+	}
+
+	return nil
+}
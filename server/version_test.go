@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_parseVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		want    [3]int
+		err     bool
+	}{
+		{version: "0.14.3", want: [3]int{0, 14, 3}},
+		{version: "v1.2.3", want: [3]int{1, 2, 3}},
+		{version: "0.15.0-rc.1", want: [3]int{0, 15, 0}},
+		{version: "1.2", err: true},
+		{version: "1.x.3", err: true},
+		{version: "", err: true},
+	}
+
+	for _, c := range cases {
+		got, err := parseVersion(c.version)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s: want error", c.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", c.version, err)
+		}
+		if got != c.want {
+			t.Fatalf("%s: want=%v got=%v", c.version, c.want, got)
+		}
+	}
+
+	if !versionAtLeast([3]int{0, 14, 3}, [3]int{0, 14, 3}) || !versionAtLeast([3]int{1, 0, 0}, [3]int{0, 15, 9}) {
+		t.Fatal("want version at least min")
+	}
+	if versionAtLeast([3]int{0, 14, 3}, [3]int{0, 15, 0}) {
+		t.Fatal("want version older than min")
+	}
+}
+
+func Test_CheckPeerVersions(t *testing.T) {
+	peer := func(version string) string {
+		srv := httptest.NewServer(topologyHandler(func() NodeTopology {
+			return NodeTopology{NodeAddr: "node-" + version, Version: version}
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	defer unreachable.Close()
+
+	current, old, legacy := peer("0.15.0"), peer("0.14.3"), peer("")
+	logger := log.New()
+
+	if err := CheckPeerVersions(context.Background(), http.DefaultClient, []string{current, unreachable.URL}, "0.15.0", logger); err != nil {
+		t.Fatalf("want unreachable peers skipped, got %s", err)
+	}
+
+	err := CheckPeerVersions(context.Background(), http.DefaultClient, []string{current, old, legacy}, "0.15.0", logger)
+	if err == nil {
+		t.Fatal("want error for old peers")
+	}
+	if want, got := "peers run versions older than 0.15.0: node-0.14.3 (0.14.3), node- (unknown)", err.Error(); want != got {
+		t.Fatalf("want=%q got=%q", want, got)
+	}
+
+	if err := CheckPeerVersions(context.Background(), http.DefaultClient, []string{current}, "latest", logger); err == nil {
+		t.Fatal("want error for invalid min version")
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -10,22 +11,42 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-kit/kit/metrics/provider"
 	"github.com/gorilla/websocket"
-	"github.com/oklog/run"
 	"github.com/owenthereal/upterm/host/api"
+	uio "github.com/owenthereal/upterm/io"
+	libmetrics "github.com/owenthereal/upterm/metrics"
 	"github.com/owenthereal/upterm/ws"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type webSocketProxy struct {
-	ConnDialer connDialer
-	Logger     log.FieldLogger
+	ConnDialer     connDialer
+	SessionAliases SessionAliases
+	// Ingress tags connections with their transport. It's created from a discard provider if it's nil.
+	Ingress *ingress
+	// CoalesceDelay coalesces small writes to WebSocket connections for up to the duration if it's positive,
+	// sending fewer frames for chatty output.
+	CoalesceDelay time.Duration
+	// TLSConfig terminates TLS on the connections accepted if it's non-nil.
+	TLSConfig *tls.Config
+	// Relayer copies the data of connections. Each direction is copied in a goroutine if it's nil.
+	Relayer relayer
+	// Tracer records a span per connection until the session is dialed if it's non-nil.
+	Tracer trace.Tracer
+	// ConnLimiter refuses connections of IPs exceeding ConnLimits or banned by the SSH proxy if it's non-nil.
+	ConnLimiter *ipLimiter
+	// IPGuard denies the connections of IPs filtered out if it's non-nil.
+	IPGuard *ipGuard
+	Logger  log.FieldLogger
 
 	srv *http.Server
 	mux sync.Mutex
 }
 
-func webHandler(h http.Handler) http.Handler {
+func webHandler(h http.Handler, aliases SessionAliases) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.HasPrefix(r.URL.Path, "/getting-started") {
 			h.ServeHTTP(w, r)
@@ -33,6 +54,12 @@ func webHandler(h http.Handler) http.Handler {
 		}
 
 		w.Header().Add("Content-Type", "text/plain")
+		if id, ok := aliases.Lookup(r.Host); ok {
+			user, _ := api.EncodeIdentifier(id)
+			fmt.Fprintf(w, "Join the session with \"ssh -o ProxyCommand='upterm proxy wss://%s' %s@%s:443\".\n", r.Host, user, r.Host)
+			return
+		}
+
 		// TODO: better getting-started guide
 		data := `1. Install the upterm CLI by following https://github.com/owenthereal/upterm#installation.
 2. On your machine, host a session with "upterm host --server wss://%s -- YOUR_COMMAND". More details in https://github.com/owenthereal/upterm#quick-start.
@@ -43,15 +70,30 @@ func webHandler(h http.Handler) http.Handler {
 }
 
 func (s *webSocketProxy) Serve(ln net.Listener) error {
+	if s.Ingress == nil {
+		s.Ingress = newIngress(provider.NewDiscardProvider())
+	}
+
 	s.mux.Lock()
 	s.srv = &http.Server{
 		Handler: webHandler(&wsHandler{
-			ConnDialer: s.ConnDialer,
-			Logger:     s.Logger,
-		}),
+			ConnDialer:     s.ConnDialer,
+			SessionAliases: s.SessionAliases,
+			Ingress:        s.Ingress,
+			CoalesceDelay:  s.CoalesceDelay,
+			Relayer:        s.Relayer,
+			Tracer:         s.Tracer,
+			ConnLimiter:    s.ConnLimiter,
+			IPGuard:        s.IPGuard,
+			Logger:         s.Logger,
+		}, s.SessionAliases),
 	}
 	s.mux.Unlock()
 
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+
 	return s.srv.Serve(ln)
 }
 
@@ -69,6 +111,9 @@ func (s *webSocketProxy) Shutdown() error {
 	return nil
 }
 
+// wsCoalesceSize is the most data coalesced into a WebSocket frame.
+const wsCoalesceSize = 32 * 1024
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -76,82 +121,142 @@ var upgrader = websocket.Upgrader{
 }
 
 type wsHandler struct {
-	ConnDialer connDialer
-	Logger     log.FieldLogger
+	ConnDialer     connDialer
+	SessionAliases SessionAliases
+	Ingress        *ingress
+	CoalesceDelay  time.Duration
+	Relayer        relayer
+	Tracer         trace.Tracer
+	ConnLimiter    *ipLimiter
+	IPGuard        *ipGuard
+	Logger         log.FieldLogger
 }
 
 // ServeHTTP checks the following header:
 // * Authorization
 // * Upterm-Client-Version
+// Neither is required if the Host header is a session alias.
 func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := requestTransport(r)
+	inst := h.Ingress.Instruments(t)
+	logger := h.Logger.WithFields(t.logFields())
+
+	// clients may pass trace contexts in traceparent headers
+	ctx, span := startSpan(tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header)), h.Tracer, "wsproxy.Connect")
+
+	if err := h.IPGuard.Check(addrIP(r.RemoteAddr)); err != nil {
+		logger.WithError(err).WithField("addr", r.RemoteAddr).Debug("denied connection")
+		endSpan(span, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := h.ConnLimiter.Allow(addrIP(r.RemoteAddr), time.Now()); err != nil {
+		logger.WithError(err).WithField("addr", r.RemoteAddr).Debug("refused connection")
+		endSpan(span, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		inst.downgrades.Add(1)
+		h.httpError(logger, span, w, fmt.Errorf("ws upgrade required"))
+		return
+	}
+
+	id, aliased := h.SessionAliases.Lookup(r.Host)
+
 	clientVersion := r.Header.Get("Upterm-Client-Version")
-	if clientVersion == "" {
-		h.httpError(w, fmt.Errorf("missing upterm client version"))
+	if clientVersion == "" && !aliased {
+		inst.mismatches.Add(1)
+		h.httpError(logger, span, w, fmt.Errorf("missing upterm client version"))
 		return
 	}
 
 	user, pass, ok := r.BasicAuth()
-	if !ok {
-		h.httpError(w, fmt.Errorf("basic auth failed"))
+	if !ok && !aliased {
+		inst.mismatches.Add(1)
+		h.httpError(logger, span, w, fmt.Errorf("basic auth failed"))
 		return
 	}
 
 	wsc, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		h.httpError(w, fmt.Errorf("ws upgrade failed"))
+		inst.errors.Add(1)
+		h.httpError(logger, span, w, fmt.Errorf("ws upgrade failed"))
 		return
 	}
 	wsconn := ws.WrapWSConn(wsc)
 	defer wsconn.Close()
 
-	id, err := api.DecodeIdentifier(user+":"+pass, string(clientVersion))
-	if err != nil {
-		h.wsError(wsc, err, "error decoding id")
-		return
+	defer libmetrics.MeasureSince(inst.connectionDuration, time.Now())
+	defer inst.activeConnections.Add(-1)
+	inst.connections.Add(1)
+	inst.activeConnections.Add(1)
+
+	if !aliased {
+		id, err = api.DecodeIdentifier(user+":"+pass, string(clientVersion))
+		if err != nil {
+			inst.errors.Add(1)
+			h.wsError(logger, span, wsc, err, "error decoding id")
+			return
+		}
 	}
 
-	conn, err := h.ConnDialer.Dial(id)
+	span.SetAttributes(attrSessionID.String(id.Id), attrSessionNodeAddr.String(id.NodeAddr))
+	conn, err := h.ConnDialer.Dial(ctx, id)
 	if err != nil {
-		h.wsError(wsc, err, "error dialing")
+		inst.errors.Add(1)
+		h.wsError(logger, span, wsc, err, "error dialing")
 		return
 	}
+	span.End()
+	defer h.Ingress.Relay(conn, t, r.RemoteAddr)()
 
-	var o sync.Once
-	cl := func() {
-		wsconn.Close()
-		conn.Close()
+	relay := h.Relayer
+	if relay == nil {
+		relay = goroutineRelayer{}
 	}
 
-	var g run.Group
-	{
-		g.Add(func() error {
-			_, err := io.Copy(wsconn, conn)
-			return err
-		}, func(err error) {
-			o.Do(cl)
-		})
-	}
-	{
-		g.Add(func() error {
-			_, err := io.Copy(conn, wsconn)
-			return err
-		}, func(err error) {
-			o.Do(cl)
-		})
+	// each write to the WebSocket is a frame
+	var toWS io.Writer = wsconn
+	var cw *uio.CoalescingWriter
+	if h.CoalesceDelay > 0 {
+		cw = uio.NewCoalescingWriter(wsconn, h.CoalesceDelay, wsCoalesceSize)
+		toWS = cw
 	}
 
-	if err := g.Run(); err != nil {
-		h.wsError(wsc, err, "error piping")
+	var flushErr error
+	err = relay.Relay(func() {
+		// coalesced writes are flushed before the WebSocket is closed
+		if cw != nil {
+			flushErr = cw.Close()
+		}
+		wsconn.Close()
+		conn.Close()
+	}, pipe{dst: toWS, src: conn}, pipe{dst: conn, src: wsconn})
+	if err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		h.wsError(logger, nil, wsc, err, "error piping")
 	}
 }
 
-func (h *wsHandler) httpError(w http.ResponseWriter, err error) {
-	h.Logger.WithError(err).Error("http error")
+// httpError replies the error, which fails the span if it's non-nil.
+func (h *wsHandler) httpError(logger log.FieldLogger, span trace.Span, w http.ResponseWriter, err error) {
+	logger.WithError(err).Error("http error")
+	if span != nil {
+		endSpan(span, err)
+	}
 	w.WriteHeader(400)
 	_, _ = w.Write([]byte(err.Error()))
 }
 
-func (h *wsHandler) wsError(ws *websocket.Conn, err error, msg string) {
-	h.Logger.WithError(err).Error(msg)
+// wsError closes the connection with the error, which fails the span if it's non-nil.
+func (h *wsHandler) wsError(logger log.FieldLogger, span trace.Span, ws *websocket.Conn, err error, msg string) {
+	logger.WithError(err).Error(msg)
+	if span != nil {
+		endSpan(span, err)
+	}
 	_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
 }
@@ -2,16 +2,22 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"net"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/go-kit/kit/metrics/provider"
 	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+	"github.com/owenthereal/upterm/host/api"
 	"github.com/owenthereal/upterm/ws"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 type testSshdDialListener struct {
@@ -46,6 +52,7 @@ func Test_WebSocketProxy_Host(t *testing.T) {
 	}
 	wsh := &wsHandler{
 		ConnDialer: cd,
+		Ingress:    newIngress(provider.NewDiscardProvider()),
 		Logger:     log.New(),
 	}
 	ts := httptest.NewServer(wsh)
@@ -99,6 +106,97 @@ func Test_WebSocketProxy_Host(t *testing.T) {
 	}
 }
 
+type testRecordingConnDialer struct {
+	ids chan *api.Identifier
+}
+
+func (d testRecordingConnDialer) Dial(ctx context.Context, id *api.Identifier) (net.Conn, error) {
+	d.ids <- id
+	c1, c2 := net.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, c2)
+	}()
+
+	return c1, nil
+}
+
+func Test_WebSocketProxy_SessionAlias(t *testing.T) {
+	aliases, err := ParseSessionAliases([]string{"debug-1234.upterm.example.com=session-id"}, "127.0.0.1:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd := testRecordingConnDialer{ids: make(chan *api.Identifier, 1)}
+	wsh := &wsHandler{
+		ConnDialer:     cd,
+		SessionAliases: aliases,
+		Ingress:        newIngress(provider.NewDiscardProvider()),
+		Logger:         log.New(),
+	}
+	ts := httptest.NewServer(wsh)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Scheme = "ws"
+
+	// no basic auth or client version
+	header := http.Header{"Host": []string{"debug-1234.upterm.example.com:443"}}
+	wsc, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsc.Close()
+
+	want := &api.Identifier{Id: "session-id", Type: api.Identifier_CLIENT, NodeAddr: "127.0.0.1:2222"}
+	if diff := cmp.Diff(want, <-cd.ids, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func Test_WebSocketProxy_TLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	cfg, err := WSTLS{CertFile: certFile, KeyFile: keyFile}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd := testRecordingConnDialer{ids: make(chan *api.Identifier, 1)}
+	wsp := &webSocketProxy{
+		ConnDialer: cd,
+		TLSConfig:  cfg,
+		Logger:     log.New(),
+	}
+	go func() {
+		_ = wsp.Serve(ln)
+	}()
+	defer wsp.Shutdown()
+
+	// neighbour nodes dial over wss://
+	conn, err := wsConnDialer{TLS: true}.Dial(context.Background(), &api.Identifier{Id: "session-id", NodeAddr: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	want := &api.Identifier{Id: "session-id", Type: api.Identifier_CLIENT, NodeAddr: ln.Addr().String()}
+	if diff := cmp.Diff(want, <-cd.ids, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// plain ws:// is refused
+	if _, err := (wsConnDialer{}).Dial(context.Background(), &api.Identifier{Id: "session-id", NodeAddr: ln.Addr().String()}); err == nil {
+		t.Fatal("ws:// should be refused by a tls proxy")
+	}
+}
+
 func scan(s *bufio.Scanner) string {
 	for s.Scan() {
 		return s.Text()
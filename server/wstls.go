@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// WSTLS terminates TLS on the WebSocket proxy, so that wss:// is served without a reverse proxy in front of the node.
+// Either CertFile and KeyFile, or Domains to obtain certificates for from Let's Encrypt, are set.
+type WSTLS struct {
+	CertFile string
+	KeyFile  string
+	// Domains are the domains certificates are obtained for with the ACME TLS-ALPN challenge, which requires the
+	// WebSocket proxy to be reachable on port 443 of the domains. Certificates are cached in CacheDir.
+	Domains  []string
+	CacheDir string
+}
+
+func (t WSTLS) enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.Domains) > 0
+}
+
+// Config returns the TLS config of the WebSocket proxy. It serves HTTP/1.1 only, since WebSocket connections are
+// upgraded from HTTP/1.1 requests.
+func (t WSTLS) Config() (*tls.Config, error) {
+	if len(t.Domains) > 0 {
+		if t.CertFile != "" || t.KeyFile != "" {
+			return nil, errors.New("--ws-tls-domain can't be used with --ws-tls-cert and --ws-tls-key")
+		}
+
+		cacheDir := t.CacheDir
+		if cacheDir == "" {
+			dir, err := os.UserCacheDir()
+			if err != nil {
+				return nil, fmt.Errorf("error finding the cache dir of ws tls certificates, set --ws-tls-cache-dir: %w", err)
+			}
+			cacheDir = filepath.Join(dir, "uptermd", "autocert")
+		}
+
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.Domains...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+		cfg := m.TLSConfig()
+		cfg.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		cfg.MinVersion = tls.VersionTLS12
+
+		return cfg, nil
+	}
+
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("--ws-tls-cert and --ws-tls-key must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ws tls certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_WSTLS_Config(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	cfg, err := WSTLS{CertFile: certFile, KeyFile: keyFile}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("want 1 certificate got=%d", len(cfg.Certificates))
+	}
+	if slices.Contains(cfg.NextProtos, "h2") {
+		t.Fatalf("websocket can't be upgraded over h2: %v", cfg.NextProtos)
+	}
+
+	cfg, err = WSTLS{Domains: []string{"upterm.example.com"}, CacheDir: t.TempDir()}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetCertificate == nil {
+		t.Fatal("certificates should be obtained with acme")
+	}
+	if slices.Contains(cfg.NextProtos, "h2") {
+		t.Fatalf("websocket can't be upgraded over h2: %v", cfg.NextProtos)
+	}
+
+	invalid := []WSTLS{
+		{CertFile: certFile},
+		{KeyFile: keyFile},
+		{CertFile: certFile, KeyFile: keyFile, Domains: []string{"upterm.example.com"}},
+		{CertFile: keyFile, KeyFile: certFile},
+	}
+	for i, c := range invalid {
+		if _, err := c.Config(); err == nil {
+			t.Fatalf("case %d: want error", i)
+		}
+	}
+}
+
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
@@ -2,9 +2,14 @@ package upterm
 
 const (
 	// host
-	HostSSHClientVersion  = "SSH-2.0-upterm-host-client"
-	HostSSHServerVersion  = "SSH-2.0-upterm-host-server"
-	HostAdminSocketEnvVar = "UPTERM_ADMIN_SOCKET"
+	HostSSHClientVersion    = "SSH-2.0-upterm-host-client"
+	HostSSHServerVersion    = "SSH-2.0-upterm-host-server"
+	HostAdminSocketEnvVar   = "UPTERM_ADMIN_SOCKET"
+	HostFeaturesRequestType = "upterm-features@upterm.dev"
+	HostPingRequestType     = "upterm-ping@upterm.dev"
+	// HostWhoAmIRequestType is sent by clients to learn how the host sees their connections, e.g. the key they
+	// authenticated with. It's replied to with api.WhoAmI.
+	HostWhoAmIRequestType = "upterm-whoami@upterm.dev"
 
 	// client
 	ClientSSHClientVersion = "SSH-2.0-upterm-client-client"
@@ -13,14 +18,21 @@ const (
 	ServerSSHServerVersion         = "SSH-2.0-uptermd"
 	ServerServerInfoRequestType    = "upterm-server-info@upterm.dev"
 	ServerCreateSessionRequestType = "upterm-create-session@upterm.dev"
+	ServerPolicyRequestType        = "upterm-policy@upterm.dev"
+	// ServerSessionEndedRequestType is sent to hosts with the reason the server ends their sessions, e.g. evictions.
+	ServerSessionEndedRequestType = "upterm-session-ended@upterm.dev"
+	// ServerQueuePositionRequestType is sent to hosts waiting for capacity in the session queue of the server whenever
+	// their positions change. The payload is server.QueuePosition.
+	ServerQueuePositionRequestType = "upterm-queue-position@upterm.dev"
+	// ServerUpdateAuthorizedKeysRequestType is sent by hosts to replace the client authorized keys of their sessions,
+	// e.g. when the keys of users are re-fetched. The payload is the keys in the authorized_keys format.
+	ServerUpdateAuthorizedKeysRequestType = "upterm-update-authorized-keys@upterm.dev"
+	// ServerIssueJoinTokenRequestType is sent by hosts to register a one-time join token of their sessions, which lets
+	// a client with a key that isn't authorized join once, e.g. with a share link. The payload is server.IssueJoinTokenRequest.
+	ServerIssueJoinTokenRequestType = "upterm-issue-join-token@upterm.dev"
 
 	// misc
 	OpenSSHKeepAliveRequestType = "keepalive@openssh.com"
 
 	SSHCertExtension = "upterm-auth-request"
-
-	EventClientJoined          = "client-joined"
-	EventClientLeft            = "client-left"
-	EventTerminalWindowChanged = "terminal-window-changed"
-	EventTerminalDetached      = "terminal-detached"
 )
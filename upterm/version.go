@@ -0,0 +1,4 @@
+package upterm
+
+// Version is the version of upterm and uptermd.
+const Version = "0.14.3"
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// StrictKeyExchanges, StrictCiphers, and StrictMACs are the only SSH algorithms negotiated with strict crypto.
+	// Ciphers are all AEAD, so MACs are only listed for completeness. SHA-1, CBC, and NIST curves are excluded.
+	StrictKeyExchanges = []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "diffie-hellman-group16-sha512"}
+	StrictCiphers      = []string{"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com"}
+	StrictMACs         = []string{"hmac-sha2-512-etm@openssh.com", "hmac-sha2-256-etm@openssh.com"}
+	// StrictPublicKeyAuthAlgorithms are the public key algorithms clients may authenticate with under strict crypto.
+	// RSA signatures with SHA-1 and DSA keys are refused.
+	StrictPublicKeyAuthAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoSKED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoSKECDSA256,
+		ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSASHA256,
+	}
+)
+
+// StrictCryptoConfig returns the SSH config negotiating only StrictKeyExchanges, StrictCiphers, and StrictMACs.
+func StrictCryptoConfig() ssh.Config {
+	return ssh.Config{
+		KeyExchanges: StrictKeyExchanges,
+		Ciphers:      StrictCiphers,
+		MACs:         StrictMACs,
+	}
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Formats of host key fingerprints.
+const (
+	// FingerprintFormatOpenSSH is the format of ssh-keygen -l, e.g. 256 SHA256:... host (ED25519).
+	FingerprintFormatOpenSSH = "openssh"
+	// FingerprintFormatKnownHosts is the format of known_hosts files.
+	FingerprintFormatKnownHosts = "known-hosts"
+	// FingerprintFormatSSHFP is the format of SSHFP resource records of RFC 4255 to publish in DNS.
+	FingerprintFormatSSHFP = "sshfp"
+	// FingerprintFormatJSON is a JSON array of HostKeyFingerprint.
+	FingerprintFormatJSON = "json"
+)
+
+var FingerprintFormats = []string{
+	FingerprintFormatOpenSSH,
+	FingerprintFormatKnownHosts,
+	FingerprintFormatSSHFP,
+	FingerprintFormatJSON,
+}
+
+// sshfpAlgorithms are the SSHFP algorithm numbers of the key types of RFC 4255, RFC 6594, and RFC 7479.
+var sshfpAlgorithms = map[string]int{
+	ssh.KeyAlgoRSA:      1,
+	ssh.KeyAlgoDSA:      2,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  4,
+}
+
+// HostKeyFingerprint describes a host key, so that it can be published, e.g. in DNS, and verified.
+type HostKeyFingerprint struct {
+	// Host is the address the key is served on, e.g. uptermd.upterm.dev or [uptermd.upterm.dev]:2222.
+	// It's empty if the key isn't served on a known address.
+	Host      string   `json:"host,omitempty"`
+	Type      string   `json:"type"`
+	Bits      int      `json:"bits"`
+	SHA256    string   `json:"sha256"`
+	PublicKey string   `json:"public_key"`
+	SSHFP     []string `json:"sshfp,omitempty"`
+
+	key ssh.PublicKey
+}
+
+// NewHostKeyFingerprint returns the fingerprint of the key served on addr, e.g. uptermd.upterm.dev:22.
+// Certificates are described by the keys they certify.
+func NewHostKeyFingerprint(addr string, key ssh.PublicKey) HostKeyFingerprint {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	fp := HostKeyFingerprint{
+		Type:      key.Type(),
+		Bits:      keyBits(key),
+		SHA256:    FingerprintSHA256(key),
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		key:       key,
+	}
+	if addr != "" {
+		fp.Host = knownhosts.Normalize(addr)
+		fp.SSHFP = sshfpRecords(addr, key)
+	}
+
+	return fp
+}
+
+// DescribeKey describes key by its type and fingerprint, e.g. ssh-ed25519 SHA256:..., for the host to tell its keys
+// apart. Certificates are described by the keys they certify, their key IDs, their CAs, and their expiry at now.
+func DescribeKey(key ssh.PublicKey, now time.Time) string {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return fmt.Sprintf("%s %s", key.Type(), FingerprintSHA256(key))
+	}
+
+	var expiry string
+	switch {
+	case cert.ValidBefore == ssh.CertTimeInfinity:
+		expiry = "valid forever"
+	case now.Unix() >= int64(cert.ValidBefore):
+		expiry = "expired at " + time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339)
+	default:
+		expiry = "valid until " + time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%s cert %s (key ID %q, signed by %s, %s)", cert.Key.Type(), FingerprintSHA256(cert.Key), cert.KeyId, FingerprintSHA256(cert.SignatureKey), expiry)
+}
+
+// WriteHostKeyFingerprints writes the fingerprints in one of FingerprintFormats.
+func WriteHostKeyFingerprints(w io.Writer, format string, fps []HostKeyFingerprint) error {
+	switch format {
+	case FingerprintFormatOpenSSH:
+		for _, fp := range fps {
+			host := fp.Host
+			if host == "" {
+				host = "no comment"
+			}
+			if _, err := fmt.Fprintf(w, "%d %s %s (%s)\n", fp.Bits, fp.SHA256, host, keyTypeName(fp.Type)); err != nil {
+				return err
+			}
+		}
+	case FingerprintFormatKnownHosts:
+		for _, fp := range fps {
+			if fp.Host == "" {
+				continue
+			}
+			if _, err := fmt.Fprintln(w, knownhosts.Line([]string{fp.Host}, fp.key)); err != nil {
+				return err
+			}
+		}
+	case FingerprintFormatSSHFP:
+		for _, fp := range fps {
+			for _, rr := range fp.SSHFP {
+				if _, err := fmt.Fprintln(w, rr); err != nil {
+					return err
+				}
+			}
+		}
+	case FingerprintFormatJSON:
+		if fps == nil {
+			fps = []HostKeyFingerprint{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(fps)
+	default:
+		return fmt.Errorf("unsupported format %q, must be one of %s", format, strings.Join(FingerprintFormats, ", "))
+	}
+
+	return nil
+}
+
+// sshfpRecords returns the SSHFP resource records of the key with SHA-1 and SHA-256 fingerprints,
+// or nil if the key type has no SSHFP algorithm number.
+func sshfpRecords(addr string, key ssh.PublicKey) []string {
+	alg, ok := sshfpAlgorithms[key.Type()]
+	if !ok {
+		return nil
+	}
+
+	// SSHFP records are looked up by hostname regardless of the port
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+
+	sha1Sum := sha1.Sum(key.Marshal())
+	sha256Sum := sha256.Sum256(key.Marshal())
+
+	return []string{
+		fmt.Sprintf("%s IN SSHFP %d 1 %s", host, alg, hex.EncodeToString(sha1Sum[:])),
+		fmt.Sprintf("%s IN SSHFP %d 2 %s", host, alg, hex.EncodeToString(sha256Sum[:])),
+	}
+}
+
+// keyBits returns the size of the key like ssh-keygen -l.
+func keyBits(key ssh.PublicKey) int {
+	ck, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+
+	switch k := ck.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		return k.P.BitLen()
+	default:
+		// ed25519 keys
+		return 256
+	}
+}
+
+// keyTypeName returns the name of the key type like ssh-keygen -l, e.g. ED25519.
+func keyTypeName(t string) string {
+	switch {
+	case t == ssh.KeyAlgoRSA:
+		return "RSA"
+	case t == ssh.KeyAlgoDSA:
+		return "DSA"
+	case strings.HasPrefix(t, "ecdsa-"):
+		return "ECDSA"
+	case t == ssh.KeyAlgoED25519:
+		return "ED25519"
+	default:
+		return strings.ToUpper(t)
+	}
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys of the TCP options parsed by ParseTCPTuning.
+const (
+	TCPOptNoDelay    = "nodelay"
+	TCPOptKeepAlive  = "keepalive"
+	TCPOptSendBuffer = "sndbuf"
+	TCPOptRecvBuffer = "rcvbuf"
+	TCPOptCongestion = "congestion"
+)
+
+var TCPOpts = []string{TCPOptNoDelay, TCPOptKeepAlive, TCPOptSendBuffer, TCPOptRecvBuffer, TCPOptCongestion}
+
+// defaultTCPKeepAlive is the keepalive period Go enables on TCP connections by default.
+const defaultTCPKeepAlive = 15 * time.Second
+
+// TCPTuning are the socket options of relayed TCP connections. The zero value of each option but NoDelay
+// keeps the default of the OS. See DefaultTCPTuning.
+type TCPTuning struct {
+	// NoDelay disables Nagle's algorithm, sending keystrokes without waiting for the acks of earlier segments.
+	NoDelay bool
+	// KeepAlive is the idle time before and the interval between TCP keepalive probes. Negative disables them.
+	KeepAlive time.Duration
+	// SendBuffer and RecvBuffer are the sizes of the socket buffers in bytes. Larger buffers keep long fat
+	// networks busy, at the cost of memory per connection.
+	SendBuffer int
+	RecvBuffer int
+	// CongestionControl is the congestion control algorithm, e.g. bbr or cubic. It's only supported on Linux,
+	// and the algorithm must be available to the process, e.g. listed in net.ipv4.tcp_allowed_congestion_control.
+	CongestionControl string
+}
+
+// DefaultTCPTuning returns the options Go sets on TCP connections by default.
+func DefaultTCPTuning() TCPTuning {
+	return TCPTuning{
+		NoDelay:   true,
+		KeepAlive: defaultTCPKeepAlive,
+	}
+}
+
+// ParseTCPTuning parses options in the form of KEY=VALUE on top of DefaultTCPTuning, e.g. nodelay=false,
+// keepalive=30s, sndbuf=4194304, rcvbuf=4194304, or congestion=bbr.
+func ParseTCPTuning(opts []string) (TCPTuning, error) {
+	t := DefaultTCPTuning()
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return t, fmt.Errorf("invalid tcp option %q, must be in the form of KEY=VALUE", opt)
+		}
+
+		var err error
+		switch key {
+		case TCPOptNoDelay:
+			t.NoDelay, err = strconv.ParseBool(value)
+		case TCPOptKeepAlive:
+			t.KeepAlive, err = time.ParseDuration(value)
+		case TCPOptSendBuffer:
+			t.SendBuffer, err = parseBufferSize(value)
+		case TCPOptRecvBuffer:
+			t.RecvBuffer, err = parseBufferSize(value)
+		case TCPOptCongestion:
+			t.CongestionControl = value
+			err = validateCongestionControl(value)
+		default:
+			return t, fmt.Errorf("unsupported tcp option %q, must be one of %s", key, strings.Join(TCPOpts, ", "))
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid tcp option %s: %w", key, err)
+		}
+	}
+
+	return t, nil
+}
+
+func parseBufferSize(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("buffer size must not be negative, got %d", n)
+	}
+
+	return n, nil
+}
+
+// String describes the options, e.g. for logging the effective tuning.
+func (t TCPTuning) String() string {
+	opts := map[string]string{
+		TCPOptNoDelay:   strconv.FormatBool(t.NoDelay),
+		TCPOptKeepAlive: t.KeepAlive.String(),
+	}
+	if t.SendBuffer > 0 {
+		opts[TCPOptSendBuffer] = strconv.Itoa(t.SendBuffer)
+	}
+	if t.RecvBuffer > 0 {
+		opts[TCPOptRecvBuffer] = strconv.Itoa(t.RecvBuffer)
+	}
+	if t.CongestionControl != "" {
+		opts[TCPOptCongestion] = t.CongestionControl
+	}
+
+	var s []string
+	for k, v := range opts {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+
+	return strings.Join(s, ",")
+}
+
+// Apply sets the options on conn if it's a TCP connection, or wraps one with a NetConn method like tls.Conn.
+// Other connections, e.g. of unix sockets, are left as they are. It returns the errors of all options failing
+// to be set, e.g. a congestion control algorithm not permitted, after setting the others.
+func (t TCPTuning) Apply(conn net.Conn) error {
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	var errs []string
+	check := func(opt string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", opt, err))
+		}
+	}
+
+	check(TCPOptNoDelay, tc.SetNoDelay(t.NoDelay))
+	switch {
+	case t.KeepAlive < 0:
+		check(TCPOptKeepAlive, tc.SetKeepAlive(false))
+	case t.KeepAlive > 0:
+		check(TCPOptKeepAlive, tc.SetKeepAlive(true))
+		check(TCPOptKeepAlive, tc.SetKeepAlivePeriod(t.KeepAlive))
+	}
+	if t.SendBuffer > 0 {
+		check(TCPOptSendBuffer, tc.SetWriteBuffer(t.SendBuffer))
+	}
+	if t.RecvBuffer > 0 {
+		check(TCPOptRecvBuffer, tc.SetReadBuffer(t.RecvBuffer))
+	}
+	if t.CongestionControl != "" {
+		check(TCPOptCongestion, setCongestionControl(tc, t.CongestionControl))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error tuning tcp connection: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// TunedListener returns a listener setting the options on the TCP connections it accepts. Errors setting them
+// are passed to onError if it's non-nil, and don't fail the connections. onTuned is called with each tuned
+// connection if it's non-nil.
+func (t TCPTuning) TunedListener(ln net.Listener, onTuned func(net.Conn), onError func(net.Conn, error)) net.Listener {
+	return tunedListener{Listener: ln, tuning: t, onTuned: onTuned, onError: onError}
+}
+
+type tunedListener struct {
+	net.Listener
+	tuning  TCPTuning
+	onTuned func(net.Conn)
+	onError func(net.Conn, error)
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.tuning.Apply(conn); err != nil {
+		if l.onError != nil {
+			l.onError(conn, err)
+		}
+	} else if l.onTuned != nil {
+		l.onTuned(conn)
+	}
+
+	return conn, nil
+}
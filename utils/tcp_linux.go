@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func validateCongestionControl(name string) error {
+	return nil
+}
+
+// setCongestionControl sets the congestion control algorithm of conn with TCP_CONGESTION.
+// Unprivileged processes can only pick the algorithms in net.ipv4.tcp_allowed_congestion_control.
+func setCongestionControl(conn *net.TCPConn, name string) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, name)
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
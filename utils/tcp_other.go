@@ -0,0 +1,17 @@
+//go:build !linux
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func validateCongestionControl(name string) error {
+	return fmt.Errorf("congestion control is only supported on linux, not %s", runtime.GOOS)
+}
+
+func setCongestionControl(conn *net.TCPConn, name string) error {
+	return validateCongestionControl(name)
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseTCPTuning(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    []string
+		want    TCPTuning
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: DefaultTCPTuning(),
+		},
+		{
+			name: "tuned",
+			opts: []string{"nodelay=false", "keepalive=30s", "sndbuf=4194304", "rcvbuf=2097152"},
+			want: TCPTuning{KeepAlive: 30 * time.Second, SendBuffer: 4194304, RecvBuffer: 2097152},
+		},
+		{
+			name: "keepalive disabled",
+			opts: []string{"keepalive=-1s"},
+			want: TCPTuning{NoDelay: true, KeepAlive: -time.Second},
+		},
+		{
+			name:    "missing value",
+			opts:    []string{"nodelay"},
+			wantErr: "must be in the form of KEY=VALUE",
+		},
+		{
+			name:    "unsupported option",
+			opts:    []string{"quickack=true"},
+			wantErr: "unsupported tcp option",
+		},
+		{
+			name:    "negative buffer",
+			opts:    []string{"sndbuf=-1"},
+			wantErr: "buffer size must not be negative",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseTCPTuning(c.opts)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("want error %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	_, err := ParseTCPTuning([]string{"congestion=bbr"})
+	if runtime.GOOS == "linux" && err != nil {
+		t.Fatalf("want congestion control supported on linux, got %s", err)
+	}
+	if runtime.GOOS != "linux" && err == nil {
+		t.Fatalf("want congestion control unsupported on %s", runtime.GOOS)
+	}
+}
+
+func Test_TCPTuning_String(t *testing.T) {
+	tuning := TCPTuning{NoDelay: true, KeepAlive: 15 * time.Second, SendBuffer: 1024, CongestionControl: "bbr"}
+	if want, got := "congestion=bbr,keepalive=15s,nodelay=true,sndbuf=1024", tuning.String(); want != got {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func Test_TCPTuning_Apply(t *testing.T) {
+	// connections other than tcp are left as they are
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err := DefaultTCPTuning().Apply(a); err != nil {
+		t.Fatal(err)
+	}
+}
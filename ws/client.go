@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"context"
 	"encoding/base64"
 	"net"
 	"net/http"
@@ -32,18 +33,25 @@ func NewSSHClient(u *url.URL, config *ssh.ClientConfig, isUptermClient bool) (*s
 // The url must include username as session id and password as encoded node address.
 // isUptermClient indicates whether the client is host client or client client.
 func NewWSConn(u *url.URL, isUptermClient bool) (net.Conn, error) {
+	conn, _, err := DialWSConn(context.Background(), websocket.DefaultDialer, u, isUptermClient)
+	return conn, err
+}
+
+// DialWSConn creates a ws net.Conn with the dialer, like NewWSConn.
+// The response of the handshake is returned if the server replied, e.g. with an error status.
+func DialWSConn(ctx context.Context, d *websocket.Dialer, u *url.URL, isUptermClient bool) (net.Conn, *http.Response, error) {
 	u, _ = url.Parse(u.String()) // clone
 	user := u.User
 	u.User = nil // ws spec doesn't support basic auth
 
 	encodedNodeAddr, _ := user.Password()
 	header := webSocketDialHeader(user.Username(), encodedNodeAddr, isUptermClient)
-	wsc, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+	wsc, resp, err := d.DialContext(ctx, u.String(), header)
 	if err != nil {
-		return nil, err
+		return nil, resp, err
 	}
 
-	return WrapWSConn(wsc), nil
+	return WrapWSConn(wsc), resp, nil
 }
 
 func WrapWSConn(ws *websocket.Conn) net.Conn {